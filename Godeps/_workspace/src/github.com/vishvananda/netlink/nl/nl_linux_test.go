@@ -7,6 +7,7 @@ import (
 	"reflect"
 	"syscall"
 	"testing"
+	"time"
 )
 
 type testSerializer interface {
@@ -31,7 +32,7 @@ func testDeserializeSerialize(t *testing.T, orig []byte, safemsg testSerializer,
 func (msg *IfInfomsg) write(b []byte) {
 	native := NativeEndian()
 	b[0] = msg.Family
-	b[1] = msg.X__ifi_pad
+	// pad byte is skipped because it is not exported on linux/s390x
 	native.PutUint16(b[2:4], msg.Type)
 	native.PutUint32(b[4:8], uint32(msg.Index))
 	native.PutUint32(b[8:12], msg.Flags)
@@ -54,7 +55,45 @@ func deserializeIfInfomsgSafe(b []byte) *IfInfomsg {
 func TestIfInfomsgDeserializeSerialize(t *testing.T) {
 	var orig = make([]byte, syscall.SizeofIfInfomsg)
 	rand.Read(orig)
+	// zero out the pad byte
+	orig[1] = 0
 	safemsg := deserializeIfInfomsgSafe(orig)
 	msg := DeserializeIfInfomsg(orig)
 	testDeserializeSerialize(t, orig, safemsg, msg)
 }
+
+func TestIfSocketCloses(t *testing.T) {
+	nlSock, err := Subscribe(syscall.NETLINK_ROUTE, syscall.RTNLGRP_NEIGH)
+	if err != nil {
+		t.Fatalf("Error on creating the socket: %v", err)
+	}
+	nlSock.SetReceiveTimeout(&syscall.Timeval{Sec: 2, Usec: 0})
+	endCh := make(chan error)
+	go func(sk *NetlinkSocket, endCh chan error) {
+		endCh <- nil
+		for {
+			_, err := sk.Receive()
+			// Receive returned because of a timeout and the FD == -1 means that the socket got closed
+			if err == syscall.EAGAIN && nlSock.GetFd() == -1 {
+				endCh <- err
+				return
+			}
+		}
+	}(nlSock, endCh)
+
+	// first receive nil
+	if msg := <-endCh; msg != nil {
+		t.Fatalf("Expected nil instead got: %v", msg)
+	}
+	// this to guarantee that the receive is invoked before the close
+	time.Sleep(4 * time.Second)
+
+	// Close the socket
+	nlSock.Close()
+
+	// Expect to have an error
+	msg := <-endCh
+	if msg == nil {
+		t.Fatalf("Expected error instead received nil")
+	}
+}
@@ -0,0 +1,438 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//+build linux
+
+// Package api implements the read-only API service of rkt, which lets
+// external tools, e.g. monitoring agents or orchestrators, list and inspect
+// the pods and images of a host, and follow the lifecycle of the pods,
+// without running rkt and parsing its output. It reads the same pod
+// directories and store as the rkt commands do, taking the same locks.
+//
+// The service is a JSON-RPC 1.0 service (net/rpc/jsonrpc), named "API",
+// served on a unix socket, so that it can be used from any language:
+//
+//	{"method": "API.ListPods", "params": [{}], "id": 1}
+//
+// The events of the journal of the data directory (see pkg/events) are
+// streamed by long polling: API.WatchEvents returns the events after a
+// sequence number, waiting for some if there are none yet.
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/appc/spec/schema/types"
+	"github.com/coreos/rocket/cas"
+	"github.com/coreos/rocket/pkg/events"
+	"github.com/coreos/rocket/pkg/pod"
+)
+
+// SocketPath is where the API service listens by default
+const SocketPath = "/run/rkt/api-service.sock"
+
+const (
+	// maxEvents is how many events are kept for clients to catch up with
+	maxEvents = 1024
+	// maxWait bounds how long WatchEvents waits for events
+	maxWait = 5 * time.Minute
+)
+
+// Pod describes a pod.
+type Pod struct {
+	ID string
+	// State is the state of the pod in the data directory: prepared,
+	// running, exited or garbage
+	State string
+	// Phase is the phase stage1 reports the pod in, if it does
+	Phase string `json:",omitempty"`
+	// PID is the PID of the pod, once it ran
+	PID         int               `json:",omitempty"`
+	Apps        []App             `json:",omitempty"`
+	Networks    []Network         `json:",omitempty"`
+	Annotations map[string]string `json:",omitempty"`
+}
+
+// App describes an app of a pod.
+type App struct {
+	Name    string
+	ImageID string
+	// PID is the PID of the main process of the app in the PID namespace
+	// of the pod, once it is ready
+	PID int `json:",omitempty"`
+	// ExitCode is set once the app exited
+	ExitCode *int `json:",omitempty"`
+}
+
+// Network describes a network a pod is attached to.
+type Network struct {
+	Name string
+	// IP, and IP6 on dual-stack networks, are the addresses of the pod on
+	// the network, in CIDR notation
+	IP  string
+	IP6 string `json:",omitempty"`
+}
+
+// Image describes an image of the store.
+type Image struct {
+	ID          string
+	Name        string
+	Labels      map[string]string `json:",omitempty"`
+	Annotations map[string]string `json:",omitempty"`
+	// Manifest is the image manifest, only returned by InspectImage
+	Manifest json.RawMessage `json:",omitempty"`
+}
+
+// Event is an event of the journal of the data directory.
+type Event struct {
+	// Seq numbers the events in the order they were read, from 1
+	Seq  uint64
+	Time time.Time
+	// Type is one of the event types of pkg/events
+	Type string
+	Pod  string
+	App  string `json:",omitempty"`
+	// ExitCode is the exit status of the app of an app-exit event
+	ExitCode *int `json:",omitempty"`
+}
+
+// ListPodsRequest are the arguments of API.ListPods.
+type ListPodsRequest struct {
+	// States, if not empty, restricts the pods listed to those in one of
+	// them
+	States []string
+}
+
+// ListPodsResponse is the reply of API.ListPods.
+type ListPodsResponse struct {
+	Pods []Pod
+}
+
+// InspectPodRequest are the arguments of API.InspectPod.
+type InspectPodRequest struct {
+	ID string
+}
+
+// InspectPodResponse is the reply of API.InspectPod.
+type InspectPodResponse struct {
+	Pod Pod
+}
+
+// ListImagesRequest are the arguments of API.ListImages.
+type ListImagesRequest struct {
+	// Name, if set, restricts the images listed to those with the name
+	Name string
+}
+
+// ListImagesResponse is the reply of API.ListImages.
+type ListImagesResponse struct {
+	Images []Image
+}
+
+// InspectImageRequest are the arguments of API.InspectImage.
+type InspectImageRequest struct {
+	// ID is the image ID, which may be abbreviated
+	ID string
+}
+
+// InspectImageResponse is the reply of API.InspectImage.
+type InspectImageResponse struct {
+	Image Image
+}
+
+// WatchEventsRequest are the arguments of API.WatchEvents.
+type WatchEventsRequest struct {
+	// After is the sequence number of the last event seen, 0 for none
+	After uint64
+	// Wait is how long to wait for events if there are none after After,
+	// not waiting if 0
+	Wait time.Duration
+}
+
+// WatchEventsResponse is the reply of API.WatchEvents.
+type WatchEventsResponse struct {
+	Events []Event
+	// Lost tells events were missed, as they occurred too long before
+	Lost bool
+}
+
+// API is the API service, for the rkt data directory dataDir and the
+// store in it.
+type API struct {
+	dataDir string
+	store   *cas.Store
+
+	mu     sync.Mutex
+	cond   *sync.Cond
+	events []Event
+	seq    uint64
+}
+
+// New returns the API service of the rkt data directory dataDir, whose
+// store is ds.
+func New(dataDir string, ds *cas.Store) *API {
+	a := &API{
+		dataDir: dataDir,
+		store:   ds,
+	}
+	a.cond = sync.NewCond(&a.mu)
+	return a
+}
+
+// ListPods lists the pods.
+func (a *API) ListPods(req *ListPodsRequest, resp *ListPodsResponse) error {
+	uuids, err := pod.List(a.dataDir)
+	if err != nil {
+		return err
+	}
+	resp.Pods = []Pod{}
+	for _, u := range uuids {
+		p, err := inspectPod(a.dataDir, u)
+		if err != nil {
+			// e.g. removed meanwhile
+			continue
+		}
+		if len(req.States) > 0 && !contains(req.States, p.State) {
+			continue
+		}
+		resp.Pods = append(resp.Pods, *p)
+	}
+	return nil
+}
+
+func contains(l []string, s string) bool {
+	for _, e := range l {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
+
+// InspectPod describes the pod req.ID.
+func (a *API) InspectPod(req *InspectPodRequest, resp *InspectPodResponse) error {
+	u, err := types.NewUUID(req.ID)
+	if err != nil {
+		return fmt.Errorf("invalid pod ID: %v", err)
+	}
+	p, err := inspectPod(a.dataDir, *u)
+	if err != nil {
+		return err
+	}
+	resp.Pod = *p
+	return nil
+}
+
+// inspectPod describes the pod with the given UUID in dataDir
+func inspectPod(dataDir string, u types.UUID) (*Pod, error) {
+	p, err := pod.Open(dataDir, u)
+	if err != nil {
+		return nil, err
+	}
+	defer p.Close()
+
+	ap := &Pod{
+		ID:    u.String(),
+		State: string(p.State),
+	}
+	cm, err := p.Manifest()
+	if err != nil {
+		return nil, err
+	}
+	if len(cm.Annotations) > 0 {
+		ap.Annotations = make(map[string]string)
+		for _, an := range cm.Annotations {
+			ap.Annotations[an.Name.String()] = an.Value
+		}
+	}
+	s, err := p.Status()
+	if err != nil {
+		return nil, err
+	}
+	ap.Phase = string(s.Phase)
+	for _, as := range s.Apps {
+		app := App{
+			Name:    as.Name.String(),
+			ImageID: as.ImageID.String(),
+			PID:     as.PID,
+		}
+		if as.Exited {
+			code := as.ExitCode
+			app.ExitCode = &code
+		}
+		ap.Apps = append(ap.Apps, app)
+	}
+	if p.State == pod.Prepared {
+		return ap, nil
+	}
+	// the pid is recorded once the pod is started
+	if pid, err := p.PID(); err == nil {
+		ap.PID = pid
+	}
+	ni, err := p.NetInfo()
+	if err != nil {
+		return nil, err
+	}
+	for _, n := range ni.Nets {
+		ap.Networks = append(ap.Networks, Network{Name: n.NetName, IP: n.IP, IP6: n.IP6})
+	}
+	return ap, nil
+}
+
+// ListImages lists the images of the store.
+func (a *API) ListImages(req *ListImagesRequest, resp *ListImagesResponse) error {
+	keys := a.store.ImageKeys()
+	sort.Strings(keys)
+	resp.Images = []Image{}
+	for _, key := range keys {
+		img, err := a.image(key, false)
+		if err != nil {
+			// not every blob is a readable image
+			continue
+		}
+		if req.Name != "" && img.Name != req.Name {
+			continue
+		}
+		resp.Images = append(resp.Images, *img)
+	}
+	return nil
+}
+
+// InspectImage describes the image req.ID, with its manifest.
+func (a *API) InspectImage(req *InspectImageRequest, resp *InspectImageResponse) error {
+	key, err := a.store.ResolveKey(req.ID)
+	if err != nil {
+		return err
+	}
+	img, err := a.image(key, true)
+	if err != nil {
+		return err
+	}
+	resp.Image = *img
+	return nil
+}
+
+// image describes the image stored under key, with its manifest if
+// withManifest is set
+func (a *API) image(key string, withManifest bool) (*Image, error) {
+	im, err := a.store.GetImageManifest(key)
+	if err != nil {
+		return nil, err
+	}
+	img := &Image{
+		ID:   key,
+		Name: im.Name.String(),
+	}
+	if len(im.Labels) > 0 {
+		img.Labels = make(map[string]string)
+		for _, l := range im.Labels {
+			img.Labels[l.Name.String()] = l.Value
+		}
+	}
+	if len(im.Annotations) > 0 {
+		img.Annotations = make(map[string]string)
+		for _, an := range im.Annotations {
+			img.Annotations[an.Name.String()] = an.Value
+		}
+	}
+	if withManifest {
+		if img.Manifest, err = json.Marshal(im); err != nil {
+			return nil, fmt.Errorf("error marshalling image manifest: %v", err)
+		}
+	}
+	return img, nil
+}
+
+// WatchEvents returns the events after req.After, waiting up to req.Wait
+// for some if there are none yet.
+func (a *API) WatchEvents(req *WatchEventsRequest, resp *WatchEventsResponse) error {
+	wait := req.Wait
+	if wait > maxWait {
+		wait = maxWait
+	}
+	timeout := time.AfterFunc(wait, func() {
+		a.mu.Lock()
+		a.cond.Broadcast()
+		a.mu.Unlock()
+	})
+	defer timeout.Stop()
+	deadline := time.Now().Add(wait)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for a.seq <= req.After && time.Now().Before(deadline) {
+		a.cond.Wait()
+	}
+	resp.Events = []Event{}
+	for _, e := range a.events {
+		if e.Seq > req.After {
+			resp.Events = append(resp.Events, e)
+		}
+	}
+	resp.Lost = len(a.events) > 0 && a.events[0].Seq > req.After+1
+	return nil
+}
+
+// add appends the journal event e to the events clients are served
+func (a *API) add(e events.Event) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.seq++
+	a.events = append(a.events, Event{
+		Seq:      a.seq,
+		Time:     e.Time,
+		Type:     string(e.Type),
+		Pod:      e.Pod,
+		App:      e.App,
+		ExitCode: e.ExitCode,
+	})
+	if len(a.events) > maxEvents {
+		a.events = a.events[len(a.events)-maxEvents:]
+	}
+	a.cond.Broadcast()
+	return nil
+}
+
+// Serve serves a on l until an error occurs.
+func (a *API) Serve(l net.Listener) error {
+	srv := rpc.NewServer()
+	if err := srv.RegisterName("API", a); err != nil {
+		return err
+	}
+	go events.Follow(context.Background(), a.dataDir, time.Time{}, a.add)
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			return fmt.Errorf("error accepting connection: %v", err)
+		}
+		go srv.ServeCodec(jsonrpc.NewServerCodec(c))
+	}
+}
+
+// Dial connects to the API service on the unix socket at path.
+func Dial(path string) (*rpc.Client, error) {
+	c, err := jsonrpc.Dial("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to the API service, is it running? %v", err)
+	}
+	return c, nil
+}
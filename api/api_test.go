@@ -0,0 +1,155 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//+build linux
+
+package api
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/coreos/rocket/cas"
+	"github.com/coreos/rocket/pkg/events"
+	"github.com/coreos/rocket/pkg/util"
+)
+
+const (
+	testUUID    = "6733c3b1-8b9e-4f5c-9c2a-1d2e3f4a5b6c"
+	testImageID = "sha512-0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+)
+
+func writeFile(t *testing.T, path, data string) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatalf("error creating dir: %v", err)
+	}
+	if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatalf("error writing file: %v", err)
+	}
+}
+
+func TestAPI(t *testing.T) {
+	dir, err := ioutil.TempDir("", "api")
+	if err != nil {
+		t.Fatalf("error creating tempdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	cdir := filepath.Join(dir, "containers", testUUID)
+	writeFile(t, filepath.Join(cdir, "container"), `{"acKind":"ContainerRuntimeManifest","acVersion":"0.1.1","annotations":[{"name":"example.com/owner","value":"ops"}],"apps":[{"name":"example.com/app","imageID":"`+testImageID+`"}]}`)
+	writeFile(t, filepath.Join(cdir, "pid"), "4242\n")
+	writeFile(t, filepath.Join(cdir, "net-info.json"), `{"nets":[{"netName":"default","ifName":"eth0","ip":"172.16.28.2"}]}`)
+
+	ds := cas.NewStore(dir)
+	aci, err := util.NewBasicACI(dir, "example.com/app")
+	if err != nil {
+		t.Fatalf("error creating ACI: %v", err)
+	}
+	defer aci.Close()
+	if _, err := aci.Seek(0, 0); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	key, err := ds.WriteACI(context.Background(), aci)
+	if err != nil {
+		t.Fatalf("error importing ACI: %v", err)
+	}
+
+	a := New(dir, ds)
+	sock := filepath.Join(dir, "api.sock")
+	l, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer l.Close()
+	go a.Serve(l)
+	c, err := Dial(sock)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer c.Close()
+
+	var pods ListPodsResponse
+	if err := c.Call("API.ListPods", &ListPodsRequest{}, &pods); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(pods.Pods) != 1 {
+		t.Fatalf("got %d pods, want 1", len(pods.Pods))
+	}
+	p := pods.Pods[0]
+	if p.ID != testUUID || p.State != "exited" || p.PID != 4242 {
+		t.Errorf("got pod %+v", p)
+	}
+	if p.Annotations["example.com/owner"] != "ops" {
+		t.Errorf("got annotations %v", p.Annotations)
+	}
+	if len(p.Apps) != 1 || p.Apps[0].Name != "example.com/app" || p.Apps[0].ImageID != testImageID {
+		t.Errorf("got apps %+v", p.Apps)
+	}
+	if len(p.Networks) != 1 || p.Networks[0].Name != "default" || p.Networks[0].IP != "172.16.28.2" {
+		t.Errorf("got networks %+v", p.Networks)
+	}
+	if err := c.Call("API.ListPods", &ListPodsRequest{States: []string{"running"}}, &pods); err != nil || len(pods.Pods) != 0 {
+		t.Errorf("got %+v, %v, want no running pods", pods.Pods, err)
+	}
+
+	var pod InspectPodResponse
+	if err := c.Call("API.InspectPod", &InspectPodRequest{ID: testUUID}, &pod); err != nil || pod.Pod.ID != testUUID {
+		t.Errorf("got %+v, %v", pod.Pod, err)
+	}
+	if err := c.Call("API.InspectPod", &InspectPodRequest{ID: "6733c3b1-0000-0000-0000-000000000000"}, &pod); err == nil {
+		t.Errorf("expected an error inspecting an unknown pod")
+	}
+
+	var imgs ListImagesResponse
+	if err := c.Call("API.ListImages", &ListImagesRequest{}, &imgs); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(imgs.Images) != 1 || imgs.Images[0].ID != key || imgs.Images[0].Name != "example.com/app" || imgs.Images[0].Manifest != nil {
+		t.Errorf("got images %+v", imgs.Images)
+	}
+	if err := c.Call("API.ListImages", &ListImagesRequest{Name: "example.com/other"}, &imgs); err != nil || len(imgs.Images) != 0 {
+		t.Errorf("got %+v, %v, want no images", imgs.Images, err)
+	}
+	var img InspectImageResponse
+	if err := c.Call("API.InspectImage", &InspectImageRequest{ID: key[:20]}, &img); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if img.Image.ID != key || len(img.Image.Manifest) == 0 {
+		t.Errorf("got image %+v", img.Image)
+	}
+
+	// no events until one is recorded
+	var evs WatchEventsResponse
+	if err := c.Call("API.WatchEvents", &WatchEventsRequest{}, &evs); err != nil || len(evs.Events) != 0 {
+		t.Errorf("got %+v, %v, want no events", evs.Events, err)
+	}
+	code := 1
+	if err := events.Record(dir, events.Event{Time: time.Now(), Type: events.AppExit, Pod: testUUID, App: "example.com/app", ExitCode: &code}); err != nil {
+		t.Fatalf("error recording event: %v", err)
+	}
+	if err := c.Call("API.WatchEvents", &WatchEventsRequest{Wait: 10 * time.Second}, &evs); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(evs.Events) != 1 || evs.Events[0].Seq != 1 || evs.Events[0].Pod != testUUID || evs.Events[0].Type != string(events.AppExit) || evs.Events[0].App != "example.com/app" || evs.Events[0].ExitCode == nil || *evs.Events[0].ExitCode != 1 || evs.Lost {
+		t.Errorf("got events %+v, lost %v", evs.Events, evs.Lost)
+	}
+	if err := c.Call("API.WatchEvents", &WatchEventsRequest{After: 1}, &evs); err != nil || len(evs.Events) != 0 {
+		t.Errorf("got %+v, %v, want no events after the last one", evs.Events, err)
+	}
+}
@@ -0,0 +1,125 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cas
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// maxSendfile bounds the bytes copied by a single sendfile(2)
+const maxSendfile = 1 << 30
+
+// Blob is an image of the store opened for reading with OpenBlob.
+// Unencrypted images are memory-mapped rather than read through buffers:
+// reading a Blob copies the image straight from the page cache, Bytes
+// returns it without copying it at all, and WriteTo lets the kernel copy it
+// to files and pipes with sendfile(2). Encrypted images are streamed as
+// they are decrypted, as with ReadStream.
+type Blob struct {
+	io.Reader
+	// data maps f, it is nil if the image is streamed
+	data []byte
+	r    *bytes.Reader
+	f    *os.File
+	c    io.Closer
+}
+
+func (ds Store) blobPath(key string) string {
+	p := append([]string{ds.base, "cas", otmap[blobType]}, blockTransform(key)...)
+	return filepath.Join(append(p, key)...)
+}
+
+// OpenBlob opens the image stored under key for reading, mapping it if it
+// isn't encrypted.
+func (ds Store) OpenBlob(key string) (*Blob, error) {
+	f, err := os.Open(ds.blobPath(key))
+	if err != nil {
+		return nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	data := []byte{}
+	if fi.Size() > 0 {
+		data, err = syscall.Mmap(int(f.Fd()), 0, int(fi.Size()), syscall.PROT_READ, syscall.MAP_SHARED)
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("error mapping image %s: %v", key, err)
+		}
+		if bytes.HasPrefix(data, []byte(encMagic)) {
+			syscall.Munmap(data)
+			f.Close()
+			rc, err := ds.ReadStream(key)
+			if err != nil {
+				return nil, err
+			}
+			return &Blob{Reader: rc, c: rc}, nil
+		}
+		// images are read front to back, the kernel can read ahead
+		syscall.Madvise(data, syscall.MADV_SEQUENTIAL)
+	}
+	r := bytes.NewReader(data)
+	return &Blob{Reader: r, data: data, r: r, f: f, c: f}, nil
+}
+
+// Bytes returns the mapped image, or nil if it is streamed. It must not be
+// used after the Blob is closed.
+func (b *Blob) Bytes() []byte {
+	return b.data
+}
+
+// WriteTo copies the rest of the image to w, with sendfile(2) if the image
+// is mapped and w is a file or a pipe.
+func (b *Blob) WriteTo(w io.Writer) (int64, error) {
+	wf, ok := w.(*os.File)
+	if b.data == nil || !ok {
+		return io.Copy(w, b.Reader)
+	}
+	start := b.r.Size() - int64(b.r.Len())
+	off := start
+	for off < b.r.Size() {
+		n := b.r.Size() - off
+		if n > maxSendfile {
+			n = maxSendfile
+		}
+		// sendfile advances off, not the offset of the image file
+		m, err := syscall.Sendfile(int(wf.Fd()), int(b.f.Fd()), &off, int(n))
+		if err != nil || m == 0 {
+			// e.g. w is opened in append mode; copy what's left
+			break
+		}
+	}
+	if _, err := b.r.Seek(off, 0); err != nil {
+		return off - start, err
+	}
+	n, err := b.r.WriteTo(w)
+	return off - start + n, err
+}
+
+// Close unmaps or stops streaming the image.
+func (b *Blob) Close() error {
+	if len(b.data) > 0 {
+		syscall.Munmap(b.data)
+	}
+	b.data = nil
+	return b.c.Close()
+}
@@ -0,0 +1,143 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cas
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/appc/spec/schema/types"
+)
+
+func TestOpenBlob(t *testing.T) {
+	dir, err := ioutil.TempDir("", tstprefix)
+	if err != nil {
+		t.Fatalf("error creating tempdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	ds := NewStore(dir)
+
+	data := bytes.Repeat([]byte("0123456789abcdef"), 4096)
+	key := types.NewHashSHA512(data).String()
+	if err := ds.WriteStream(key, bytes.NewReader(data)); err != nil {
+		t.Fatalf("error writing blob: %v", err)
+	}
+
+	b, err := ds.OpenBlob(key)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !bytes.Equal(b.Bytes(), data) {
+		t.Errorf("mapped blob doesn't match the stored one")
+	}
+	head := make([]byte, 10)
+	if _, err := io.ReadFull(b, head); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// the rest is sent to files by the kernel, copied to other writers
+	f, err := ioutil.TempFile(dir, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer f.Close()
+	if n, err := b.WriteTo(f); err != nil || n != int64(len(data)-10) {
+		t.Fatalf("got %d, %v, want %d bytes written", n, err, len(data)-10)
+	}
+	if err := b.Close(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	written, err := ioutil.ReadFile(f.Name())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !bytes.Equal(head, data[:10]) || !bytes.Equal(written, data[10:]) {
+		t.Errorf("blob read and written doesn't match the stored one")
+	}
+
+	// encrypted blobs are streamed
+	if err := ds.SetEncryptionKey(testKey); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	secret := []byte("top secret contents")
+	skey := types.NewHashSHA512(secret).String()
+	if err := ds.WriteStream(skey, bytes.NewReader(secret)); err != nil {
+		t.Fatalf("error writing blob: %v", err)
+	}
+	b, err = ds.OpenBlob(skey)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer b.Close()
+	if b.Bytes() != nil {
+		t.Errorf("encrypted blob is mapped")
+	}
+	var buf bytes.Buffer
+	if _, err := b.WriteTo(&buf); err != nil || !bytes.Equal(buf.Bytes(), secret) {
+		t.Errorf("got %q, %v, want %q", buf.Bytes(), err, secret)
+	}
+}
+
+// BenchmarkReadImage compares copying an image to a file read through
+// ReadStream and mapped by OpenBlob, as export does.
+func BenchmarkReadImage(b *testing.B) {
+	dir, err := ioutil.TempDir("", tstprefix)
+	if err != nil {
+		b.Fatalf("error creating tempdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	ds := NewStore(dir)
+	data := bytes.Repeat([]byte("0123456789abcdef"), 4<<20)
+	key := types.NewHashSHA512(data).String()
+	if err := ds.WriteStream(key, bytes.NewReader(data)); err != nil {
+		b.Fatalf("error writing blob: %v", err)
+	}
+	out, err := ioutil.TempFile(dir, "")
+	if err != nil {
+		b.Fatalf("unexpected error: %v", err)
+	}
+	defer out.Close()
+
+	b.Run("stream", func(b *testing.B) {
+		b.SetBytes(int64(len(data)))
+		for i := 0; i < b.N; i++ {
+			rs, err := ds.ReadStream(key)
+			if err != nil {
+				b.Fatalf("unexpected error: %v", err)
+			}
+			out.Seek(0, 0)
+			if _, err := io.Copy(out, rs); err != nil {
+				b.Fatalf("unexpected error: %v", err)
+			}
+			rs.Close()
+		}
+	})
+	b.Run("mapped", func(b *testing.B) {
+		b.SetBytes(int64(len(data)))
+		for i := 0; i < b.N; i++ {
+			blob, err := ds.OpenBlob(key)
+			if err != nil {
+				b.Fatalf("unexpected error: %v", err)
+			}
+			out.Seek(0, 0)
+			if _, err := blob.WriteTo(out); err != nil {
+				b.Fatalf("unexpected error: %v", err)
+			}
+			blob.Close()
+		}
+	})
+}
@@ -15,18 +15,26 @@
 package cas
 
 import (
+	"archive/tar"
 	"bufio"
 	"bytes"
+	"context"
+	"crypto/cipher"
 	"crypto/sha512"
+	"encoding/json"
 	"fmt"
 	"hash"
 	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
 
-	"github.com/appc/spec/aci"
+	pkgio "github.com/coreos/rocket/pkg/io"
+	ptar "github.com/coreos/rocket/pkg/tar"
 
+	"github.com/appc/spec/aci"
+	"github.com/appc/spec/schema"
 	"github.com/coreos/rocket/Godeps/_workspace/src/github.com/peterbourgon/diskv"
 )
 
@@ -35,6 +43,7 @@ import (
 const (
 	blobType int64 = iota
 	remoteType
+	conversionType
 
 	defaultPathPerm os.FileMode = 0777
 
@@ -49,12 +58,15 @@ const (
 var otmap = [...]string{
 	"blob",
 	"remote", // remote is a temporary secondary index
+	"conversion",
 }
 
 // Store encapsulates a content-addressable-storage for storing ACIs on disk.
 type Store struct {
 	base   string
 	stores []*diskv.Diskv
+	// aead encrypts the images written to the store, if set
+	aead cipher.AEAD
 }
 
 func NewStore(base string) *Store {
@@ -73,6 +85,23 @@ func NewStore(base string) *Store {
 	return ds
 }
 
+// SetEncryptionKey makes the store encrypt the images it writes from now on
+// with the given AES-256 key, and decrypt those it reads. Images already
+// stored unencrypted remain readable.
+func (ds *Store) SetEncryptionKey(key []byte) error {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return err
+	}
+	ds.aead = aead
+	return nil
+}
+
+// Encrypted reports whether the store encrypts the images it writes.
+func (ds Store) Encrypted() bool {
+	return ds.aead != nil
+}
+
 func (ds Store) tmpFile() (*os.File, error) {
 	dir := filepath.Join(ds.base, "tmp")
 	if err := os.MkdirAll(dir, defaultPathPerm); err != nil {
@@ -81,6 +110,17 @@ func (ds Store) tmpFile() (*os.File, error) {
 	return ioutil.TempFile(dir, "")
 }
 
+// TmpDir creates and returns a new temporary directory inside the store,
+// suitable for staging data (e.g. image conversions) that will later be
+// imported. The caller is responsible for removing it.
+func (ds Store) TmpDir() (string, error) {
+	dir := filepath.Join(ds.base, "tmp")
+	if err := os.MkdirAll(dir, defaultPathPerm); err != nil {
+		return "", err
+	}
+	return ioutil.TempDir(dir, "")
+}
+
 // ResolveKey resolves a partial key (of format `sha512-0c45e8c0ab2`) to a full
 // key by considering the key a prefix and using the store for resolution.
 // If the key is longer than the full key length, it is first truncated.
@@ -108,20 +148,55 @@ func (ds Store) ResolveKey(key string) (string, error) {
 	return k, nil
 }
 
+// ReadStream returns the image stored under key, decrypting it if needed.
 func (ds Store) ReadStream(key string) (io.ReadCloser, error) {
-	return ds.stores[blobType].ReadStream(key, false)
+	rc, err := ds.stores[blobType].ReadStream(key, false)
+	if err != nil {
+		return nil, err
+	}
+	r, err := decrypting(rc, ds.aead)
+	if err != nil {
+		rc.Close()
+		return nil, fmt.Errorf("error reading image %s: %v", key, err)
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{r, rc}, nil
 }
 
 func (ds Store) WriteStream(key string, r io.Reader) error {
-	return ds.stores[blobType].WriteStream(key, r, true)
+	if ds.aead == nil {
+		return ds.stores[blobType].WriteStream(key, r, true)
+	}
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(ds.encrypt(pw, r))
+	}()
+	err := ds.stores[blobType].WriteStream(key, pr, true)
+	pr.CloseWithError(err)
+	return err
+}
+
+// encrypt copies r to w, encrypting it
+func (ds Store) encrypt(w io.Writer, r io.Reader) error {
+	ew, err := newEncryptWriter(w, ds.aead)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(ew, r); err != nil {
+		return err
+	}
+	return ew.Close()
 }
 
 // WriteACI takes an ACI encapsulated in an io.Reader, decompresses it if
 // necessary, and then stores it in the store under a key based on the image ID
-// (i.e. the hash of the uncompressed ACI)
-func (ds Store) WriteACI(r io.Reader) (string, error) {
+// (i.e. the hash of the uncompressed ACI). Once ctx is done the image is
+// no longer read and nothing is stored.
+func (ds Store) WriteACI(ctx context.Context, r io.Reader) (string, error) {
 	// Peek at the first 512 bytes of the reader to detect filetype
-	br := bufio.NewReaderSize(r, 512)
+	br := bufio.NewReaderSize(&pkgio.ContextReader{Ctx: ctx, R: r}, 512)
 	hd, err := br.Peek(512)
 	switch err {
 	case nil:
@@ -139,14 +214,22 @@ func (ds Store) WriteACI(r io.Reader) (string, error) {
 	}
 
 	// Write the decompressed image (tar) to a temporary file on disk, and
-	// tee so we can generate the hash
+	// tee so we can generate the hash. The hash is always computed over
+	// the plain image, as it is what the image ID refers to.
 	h := sha512.New()
 	tr := io.TeeReader(dr, h)
 	fh, err := ds.tmpFile()
 	if err != nil {
 		return "", fmt.Errorf("error creating image: %v", err)
 	}
-	if _, err := io.Copy(fh, tr); err != nil {
+	if ds.aead != nil {
+		err = ds.encrypt(fh, tr)
+	} else {
+		_, err = io.Copy(fh, tr)
+	}
+	if err != nil {
+		fh.Close()
+		os.Remove(fh.Name())
 		return "", fmt.Errorf("error copying image: %v", err)
 	}
 	if err := fh.Close(); err != nil {
@@ -162,6 +245,44 @@ func (ds Store) WriteACI(r io.Reader) (string, error) {
 	return key, nil
 }
 
+// GetImageManifest returns the manifest of the image stored under key
+func (ds Store) GetImageManifest(key string) (*schema.ImageManifest, error) {
+	rs, err := ds.ReadStream(key)
+	if err != nil {
+		return nil, err
+	}
+	defer rs.Close()
+	b, err := ptar.ExtractFileFromTar(tar.NewReader(rs), aci.ManifestFile)
+	if err != nil {
+		return nil, fmt.Errorf("error reading image manifest: %v", err)
+	}
+	var im schema.ImageManifest
+	if err := json.Unmarshal(b, &im); err != nil {
+		return nil, fmt.Errorf("error unmarshaling image manifest: %v", err)
+	}
+	return &im, nil
+}
+
+// ImageKeysByName returns the sorted keys of the images in the store whose
+// manifest has the given name. Every image manifest in the store is read,
+// so it is not meant to be used in hot paths.
+func (ds Store) ImageKeysByName(name string) []string {
+	var keys []string
+	for key := range ds.stores[blobType].Keys(nil) {
+		im, err := ds.GetImageManifest(key)
+		if err != nil {
+			// not every blob is a readable image, e.g. if it was
+			// encrypted with another key
+			continue
+		}
+		if im.Name.String() == name {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
 type Index interface {
 	Hash() string
 	Marshal() []byte
@@ -17,6 +17,7 @@ package cas
 import (
 	"archive/tar"
 	"bytes"
+	"context"
 	"encoding/hex"
 	"io/ioutil"
 	"net/http"
@@ -94,8 +95,8 @@ func TestDownloading(t *testing.T) {
 		hit  bool
 	}{
 		// The Blob entry isn't used
-		{Remote{ts.URL, "", "12", ""}, body, false},
-		{Remote{ts.URL, "", "12", ""}, body, true},
+		{Remote{ACIURL: ts.URL, ETag: "12"}, body, false},
+		{Remote{ACIURL: ts.URL, ETag: "12"}, body, true},
 	}
 
 	ds := NewStore(dir)
@@ -109,13 +110,16 @@ func TestDownloading(t *testing.T) {
 			panic("expected a hit got a miss")
 		}
 		ds.stores[remoteType].Write(tt.r.Hash(), tt.r.Marshal())
-		_, aciFile, err := tt.r.Download(*ds, nil)
+		_, aciFile, _, err := tt.r.Download(context.Background(), *ds, nil, RetryPolicy{}, nil, nil)
 		if err != nil {
 			t.Fatalf("error downloading aci: %v", err)
 		}
-		defer os.Remove(aciFile.Name())
 
-		_, err = tt.r.Store(*ds, aciFile)
+		_, err = tt.r.Store(context.Background(), *ds, aciFile)
+		// done with before the next download of the same URL, which
+		// would wait for it otherwise
+		os.Remove(aciFile.Name())
+		aciFile.Close()
 		if err != nil {
 			panic(err)
 		}
@@ -0,0 +1,71 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cas
+
+import (
+	"encoding/json"
+
+	"github.com/appc/spec/schema/types"
+)
+
+// Conversion records the ACI an image of a foreign format, e.g. a Docker
+// image, was converted to. It is indexed by the digest identifying the
+// image upstream, so that an image is converted again only once it
+// changed.
+type Conversion struct {
+	// Digest identifies the image converted along with its format and
+	// what the ACI is named after, e.g.
+	// "docker:quay.io/coreos/etcd:latest@sha256:..."
+	Digest string
+	// BlobKey is the key in the blob store of the ACI it was converted to
+	BlobKey string
+}
+
+func NewConversion(digest string) *Conversion {
+	return &Conversion{Digest: digest}
+}
+
+func (c Conversion) Marshal() []byte {
+	m, _ := json.Marshal(c)
+	return m
+}
+
+func (c *Conversion) Unmarshal(data []byte) {
+	if err := json.Unmarshal(data, c); err != nil {
+		panic(err)
+	}
+}
+
+func (c Conversion) Hash() string {
+	return types.NewHashSHA512([]byte(c.Digest)).String()
+}
+
+func (c Conversion) Type() int64 {
+	return conversionType
+}
+
+// ConvertedImage returns the key of the ACI the image with the given
+// digest was converted to, false if it wasn't or the ACI has since been
+// removed from the store.
+func (ds Store) ConvertedImage(digest string) (string, bool) {
+	c := NewConversion(digest)
+	if err := ds.ReadIndex(c); err != nil || c.BlobKey == "" {
+		return "", false
+	}
+	if !ds.stores[blobType].Has(c.BlobKey) {
+		return "", false
+	}
+	return c.BlobKey, true
+}
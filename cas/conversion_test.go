@@ -0,0 +1,65 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cas
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/coreos/rocket/pkg/util"
+)
+
+func TestConvertedImage(t *testing.T) {
+	dir, err := ioutil.TempDir("", tstprefix)
+	if err != nil {
+		t.Fatalf("error creating tempdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	ds := NewStore(dir)
+
+	const digest = "docker:sha256:0123"
+	if _, ok := ds.ConvertedImage(digest); ok {
+		t.Fatalf("unexpected conversion in an empty store")
+	}
+
+	aci, err := util.NewBasicACI(dir, "example.com/app")
+	if err != nil {
+		t.Fatalf("error creating ACI: %v", err)
+	}
+	defer aci.Close()
+	if _, err := aci.Seek(0, 0); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	key, err := ds.WriteACI(context.Background(), aci)
+	if err != nil {
+		t.Fatalf("error importing ACI: %v", err)
+	}
+
+	ds.WriteIndex(&Conversion{Digest: digest, BlobKey: key})
+	if k, ok := ds.ConvertedImage(digest); !ok || k != key {
+		t.Errorf("got %q, %v, want %q", k, ok, key)
+	}
+	if _, ok := ds.ConvertedImage("docker:sha256:4567"); ok {
+		t.Errorf("unexpected conversion of another digest")
+	}
+
+	// the ACI was removed since
+	ds.WriteIndex(&Conversion{Digest: digest, BlobKey: "sha512-gone"})
+	if _, ok := ds.ConvertedImage(digest); ok {
+		t.Errorf("unexpected conversion to a missing ACI")
+	}
+}
@@ -0,0 +1,221 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cas
+
+// Images can be stored encrypted at rest. An encrypted blob starts with
+// encMagic and a random nonce prefix, followed by the image split in chunks
+// of encChunkSize bytes, each sealed with AES-256-GCM. The nonce of a chunk
+// is the prefix, the chunk's counter and a flag marking the last chunk, so
+// chunks can't be reordered, dropped or appended without being detected.
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	encMagic           = "rktenc1\n"
+	encChunkSize       = 64 * 1024
+	encNoncePrefixSize = 7
+	// EncryptionKeySize is the size of the keys used to encrypt images
+	EncryptionKeySize = 32
+)
+
+var errEncTruncated = errors.New("encrypted image is truncated")
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != EncryptionKeySize {
+		return nil, fmt.Errorf("encryption key must be %d bytes, got %d", EncryptionKeySize, len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func encNonce(prefix []byte, counter uint32, last bool) []byte {
+	nonce := make([]byte, encNoncePrefixSize+5)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[encNoncePrefixSize:], counter)
+	if last {
+		nonce[len(nonce)-1] = 1
+	}
+	return nonce
+}
+
+// encryptWriter encrypts everything written to it into w. Close must be
+// called to write the last chunk.
+type encryptWriter struct {
+	w       io.Writer
+	aead    cipher.AEAD
+	prefix  []byte
+	counter uint32
+	buf     []byte
+}
+
+func newEncryptWriter(w io.Writer, aead cipher.AEAD) (*encryptWriter, error) {
+	prefix := make([]byte, encNoncePrefixSize)
+	if _, err := io.ReadFull(rand.Reader, prefix); err != nil {
+		return nil, fmt.Errorf("error generating nonce: %v", err)
+	}
+	if _, err := io.WriteString(w, encMagic); err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(prefix); err != nil {
+		return nil, err
+	}
+	return &encryptWriter{
+		w:      w,
+		aead:   aead,
+		prefix: prefix,
+		buf:    make([]byte, 0, encChunkSize),
+	}, nil
+}
+
+func (ew *encryptWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		// a full buffer is only sealed once more data arrives, as the
+		// last chunk has to be flagged as such
+		if len(ew.buf) == encChunkSize {
+			if err := ew.seal(false); err != nil {
+				return 0, err
+			}
+		}
+		c := copy(ew.buf[len(ew.buf):encChunkSize], p)
+		ew.buf = ew.buf[:len(ew.buf)+c]
+		p = p[c:]
+	}
+	return n, nil
+}
+
+func (ew *encryptWriter) seal(last bool) error {
+	if ew.counter == ^uint32(0) {
+		return errors.New("image too large to encrypt")
+	}
+	ct := ew.aead.Seal(nil, encNonce(ew.prefix, ew.counter, last), ew.buf, nil)
+	ew.counter++
+	ew.buf = ew.buf[:0]
+	_, err := ew.w.Write(ct)
+	return err
+}
+
+// Close writes the last chunk, it does not close the underlying writer.
+func (ew *encryptWriter) Close() error {
+	return ew.seal(true)
+}
+
+// decryptReader decrypts a stream written by an encryptWriter, minus the
+// magic which has already been consumed.
+type decryptReader struct {
+	r       *bufio.Reader
+	aead    cipher.AEAD
+	prefix  []byte
+	counter uint32
+	chunk   []byte
+	buf     []byte
+	done    bool
+}
+
+func newDecryptReader(r *bufio.Reader, aead cipher.AEAD) (*decryptReader, error) {
+	prefix := make([]byte, encNoncePrefixSize)
+	if _, err := io.ReadFull(r, prefix); err != nil {
+		return nil, errEncTruncated
+	}
+	return &decryptReader{
+		r:      r,
+		aead:   aead,
+		prefix: prefix,
+		chunk:  make([]byte, encChunkSize+aead.Overhead()),
+	}, nil
+}
+
+func (dr *decryptReader) Read(p []byte) (int, error) {
+	for len(dr.buf) == 0 {
+		if dr.done {
+			return 0, io.EOF
+		}
+		if err := dr.open(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, dr.buf)
+	dr.buf = dr.buf[n:]
+	return n, nil
+}
+
+func (dr *decryptReader) open() error {
+	n, err := io.ReadFull(dr.r, dr.chunk)
+	switch err {
+	case nil:
+		// a full chunk is the last one if nothing follows it
+		if _, err := dr.r.Peek(1); err == io.EOF {
+			dr.done = true
+		} else if err != nil {
+			return err
+		}
+	case io.ErrUnexpectedEOF:
+		dr.done = true
+	case io.EOF:
+		return errEncTruncated
+	default:
+		return err
+	}
+
+	pt, err := dr.aead.Open(dr.chunk[:0], encNonce(dr.prefix, dr.counter, dr.done), dr.chunk[:n], nil)
+	if err != nil {
+		if dr.done {
+			// either tampered with or cut off at a chunk boundary
+			return fmt.Errorf("error decrypting image: %v (image tampered with or truncated)", err)
+		}
+		return fmt.Errorf("error decrypting image: %v", err)
+	}
+	dr.counter++
+	dr.buf = pt
+	return nil
+}
+
+// decrypting returns a reader decrypting r if it holds an encrypted blob,
+// blobs stored unencrypted are passed through.
+func decrypting(r io.Reader, aead cipher.AEAD) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	hd, err := br.Peek(len(encMagic))
+	switch err {
+	case nil:
+	case io.EOF:
+		// too short to be encrypted, and r must not be read past EOF
+		return bytes.NewReader(append([]byte(nil), hd...)), nil
+	default:
+		return nil, err
+	}
+	if !bytes.Equal(hd, []byte(encMagic)) {
+		return br, nil
+	}
+	if aead == nil {
+		return nil, errors.New("image is encrypted but no encryption key is configured")
+	}
+	if _, err := br.Discard(len(encMagic)); err != nil {
+		return nil, err
+	}
+	return newDecryptReader(br, aead)
+}
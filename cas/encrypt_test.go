@@ -0,0 +1,185 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cas
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/coreos/rocket/pkg/util"
+)
+
+var testKey = bytes.Repeat([]byte{0x42}, EncryptionKeySize)
+
+func encryptBytes(t *testing.T, pt []byte) []byte {
+	aead, err := newAEAD(testKey)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var buf bytes.Buffer
+	ew, err := newEncryptWriter(&buf, aead)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := ew.Write(pt); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := ew.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func decryptBytes(ct []byte) ([]byte, error) {
+	aead, err := newAEAD(testKey)
+	if err != nil {
+		return nil, err
+	}
+	r, err := decrypting(bytes.NewReader(ct), aead)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(r)
+}
+
+func TestEncryptRoundTrip(t *testing.T) {
+	for _, size := range []int{0, 1, encChunkSize - 1, encChunkSize, encChunkSize + 1, 3 * encChunkSize} {
+		pt := make([]byte, size)
+		for i := range pt {
+			pt[i] = byte(i)
+		}
+		ct := encryptBytes(t, pt)
+		got, err := decryptBytes(ct)
+		if err != nil {
+			t.Errorf("size %d: unexpected error: %v", size, err)
+			continue
+		}
+		if !bytes.Equal(got, pt) {
+			t.Errorf("size %d: decrypted data differs", size)
+		}
+	}
+}
+
+func TestDecryptCorrupted(t *testing.T) {
+	pt := make([]byte, 2*encChunkSize+100)
+	ct := encryptBytes(t, pt)
+	hdr := len(encMagic) + encNoncePrefixSize
+	full := encChunkSize + 16
+
+	tampered := append([]byte(nil), ct...)
+	tampered[hdr+10] ^= 1
+
+	tests := map[string][]byte{
+		"tampered":             tampered,
+		"last chunk dropped":   ct[:hdr+2*full],
+		"cut inside a chunk":   ct[:hdr+full+10],
+		"header only":          ct[:hdr],
+		"truncated header":     ct[:hdr-1],
+		"chunks reordered":     append(append(append([]byte(nil), ct[:hdr]...), ct[hdr+full:hdr+2*full]...), ct[hdr:hdr+full]...),
+		"extra chunk appended": append(append([]byte(nil), ct...), ct[hdr:hdr+full]...),
+	}
+	for name, ct := range tests {
+		if _, err := decryptBytes(ct); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestStoreEncryption(t *testing.T) {
+	dir, err := ioutil.TempDir("", tstprefix)
+	if err != nil {
+		t.Fatalf("error creating tempdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	imj := `{
+			"acKind": "ImageManifest",
+			"acVersion": "0.1.1",
+			"name": "example.com/secret"
+		}`
+	secret := "top secret contents"
+	entries := []*util.ACIEntry{
+		{
+			Contents: secret,
+			Header: &tar.Header{
+				Name: "rootfs/secret.txt",
+				Size: int64(len(secret)),
+			},
+		},
+	}
+	aci, err := util.NewACI(dir, imj, entries)
+	if err != nil {
+		t.Fatalf("error creating test tar: %v", err)
+	}
+	if _, err := aci.Seek(0, 0); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	ds := NewStore(dir)
+	if err := ds.SetEncryptionKey([]byte("short")); err == nil {
+		t.Fatalf("expected an error setting a short key")
+	}
+	if err := ds.SetEncryptionKey(testKey); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	key, err := ds.WriteACI(context.Background(), aci)
+	if err != nil {
+		t.Fatalf("error writing image: %v", err)
+	}
+
+	raw, err := ds.stores[blobType].Read(key)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if bytes.Contains(raw, []byte(secret)) {
+		t.Errorf("image stored in the clear")
+	}
+
+	im, err := ds.GetImageManifest(key)
+	if err != nil {
+		t.Fatalf("error reading image manifest: %v", err)
+	}
+	if im.Name.String() != "example.com/secret" {
+		t.Errorf("got image name %q, want %q", im.Name, "example.com/secret")
+	}
+
+	// images stored in the clear remain readable
+	if err := ds.WriteStream("sha512-plain", bytes.NewBufferString("plain")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ds.stores[blobType].Write("sha512-clear", []byte("clear"))
+	for k, want := range map[string]string{"sha512-plain": "plain", "sha512-clear": "clear"} {
+		rs, err := ds.ReadStream(k)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		b, err := ioutil.ReadAll(rs)
+		rs.Close()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if string(b) != want {
+			t.Errorf("got %q, want %q", b, want)
+		}
+	}
+
+	if _, err := NewStore(dir).ReadStream(key); err == nil {
+		t.Errorf("expected an error reading an encrypted image without a key")
+	}
+}
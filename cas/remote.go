@@ -15,15 +15,21 @@
 package cas
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/coreos/rocket/pkg/keystore"
+	"github.com/coreos/rocket/pkg/trace"
 
 	"github.com/appc/spec/aci"
 	"github.com/appc/spec/schema/types"
@@ -42,11 +48,45 @@ func NewRemote(aciurl, sigurl string) *Remote {
 type Remote struct {
 	ACIURL string
 	SigURL string
-	ETag   string
+	// Cache validators and freshness information of the last response
+	ETag         string
+	LastModified string
+	CacheMaxAge  int
+	DownloadTime time.Time
 	// The key in the blob store under which the ACI has been saved.
 	BlobKey string
 }
 
+// CacheData holds the caching related information of a download.
+type CacheData struct {
+	ETag         string
+	LastModified string
+	MaxAge       int
+	// UseCached is true if the server reported the ACI as not modified,
+	// in which case nothing was downloaded.
+	UseCached bool
+}
+
+// Fresh reports whether the ACI stored for r may be used without asking the
+// remote server if it has changed, based on the Cache-Control max-age it was
+// served with.
+func (r Remote) Fresh(now time.Time) bool {
+	if r.BlobKey == "" || r.CacheMaxAge <= 0 {
+		return false
+	}
+	return now.Before(r.DownloadTime.Add(time.Duration(r.CacheMaxAge) * time.Second))
+}
+
+// UpdateCache records the cache information of a download made at time t.
+func (r *Remote) UpdateCache(cd *CacheData, t time.Time) {
+	if !cd.UseCached {
+		r.ETag = cd.ETag
+		r.LastModified = cd.LastModified
+	}
+	r.CacheMaxAge = cd.MaxAge
+	r.DownloadTime = t
+}
+
 func (r Remote) Marshal() []byte {
 	m, _ := json.Marshal(r)
 	return m
@@ -67,52 +107,64 @@ func (r Remote) Type() int64 {
 	return remoteType
 }
 
-// Download downloads and verifies the remote ACI.
+// Download downloads and verifies the remote ACI, retrying failed or
+// interrupted transfers according to rp, until ctx is done. If auth is not nil it is used to add
+// credentials to the ACI and signature requests. A progress bar is drawn on
+// progress, if not nil.
+// If r carries cache validators from a previous download, a conditional
+// request is made and nothing is transferred if the ACI did not change.
 // If Keystore is nil signature verification will be skipped.
-// Download returns the signer, an *os.File representing the ACI, and an error if any.
+// Download returns the signer, an *os.File representing the ACI, the cache
+// information of the response and an error if any. The file is nil if
+// CacheData.UseCached is set.
 // err will be nil if the ACI downloads successfully and the ACI is verified.
-func (r Remote) Download(ds Store, ks *keystore.Keystore) (*openpgp.Entity, *os.File, error) {
+func (r Remote) Download(ctx context.Context, ds Store, ks *keystore.Keystore, rp RetryPolicy, auth Authenticator, progress io.Writer) (*openpgp.Entity, *os.File, *CacheData, error) {
 	var entity *openpgp.Entity
 	var err error
-	acif, err := downloadACI(ds, r.ACIURL)
+	acif, cd, err := downloadACI(ctx, ds, r.ACIURL, r.ETag, r.LastModified, rp, auth, progress)
 	if err != nil {
-		return nil, acif, fmt.Errorf("error downloading the aci image: %v", err)
+		return nil, acif, nil, fmt.Errorf("error downloading the aci image: %v", err)
+	}
+	if cd.UseCached {
+		return nil, nil, cd, nil
 	}
 
 	if ks != nil {
-		sigTempFile, err := downloadSignatureFile(r.SigURL)
+		_, span := trace.Start(ctx, "verify")
+		defer span.Finish()
+		sigTempFile, err := downloadSignatureFile(ctx, r.SigURL, auth)
 		if err != nil {
-			return nil, acif, fmt.Errorf("error downloading the signature file: %v", err)
+			return nil, acif, nil, fmt.Errorf("error downloading the signature file: %v", err)
 		}
 		defer sigTempFile.Close()
 		defer os.Remove(sigTempFile.Name())
 
 		manifest, err := aci.ManifestFromImage(acif)
 		if err != nil {
-			return nil, acif, err
+			return nil, acif, nil, err
 		}
 
 		if _, err := acif.Seek(0, 0); err != nil {
-			return nil, acif, err
+			return nil, acif, nil, err
 		}
 		if _, err := sigTempFile.Seek(0, 0); err != nil {
-			return nil, acif, err
+			return nil, acif, nil, err
 		}
 		if entity, err = ks.CheckSignature(manifest.Name.String(), acif, sigTempFile); err != nil {
-			return nil, acif, err
+			return nil, acif, nil, err
 		}
 	}
 
 	if _, err := acif.Seek(0, 0); err != nil {
-		return nil, acif, err
+		return nil, acif, nil, err
 	}
-	return entity, acif, nil
+	return entity, acif, cd, nil
 }
 
 // TODO: add locking
 // Store stores the ACI represented by r in the target data store.
-func (r Remote) Store(ds Store, aci io.Reader) (*Remote, error) {
-	key, err := ds.WriteACI(aci)
+func (r Remote) Store(ctx context.Context, ds Store, aci io.Reader) (*Remote, error) {
+	key, err := ds.WriteACI(ctx, aci)
 	if err != nil {
 		return nil, err
 	}
@@ -121,62 +173,349 @@ func (r Remote) Store(ds Store, aci io.Reader) (*Remote, error) {
 	return &r, nil
 }
 
-func downloadACI(ds Store, aciurl string) (*os.File, error) {
-	res, err := http.Get(aciurl)
+// Authenticator adds credentials to the requests made for remote ACIs and
+// their signatures.
+type Authenticator interface {
+	Authenticate(req *http.Request)
+}
+
+// RetryPolicy controls how failed downloads are retried. The zero value
+// means a download is attempted only once.
+type RetryPolicy struct {
+	Retries int           // number of times a failed download is retried
+	Backoff time.Duration // delay before the first retry, doubled after each one
+}
+
+// statusError is returned for unexpected HTTP status codes
+type statusError struct {
+	code int
+}
+
+func (e statusError) Error() string {
+	return fmt.Sprintf("bad HTTP status code: %d", e.code)
+}
+
+// temporary reports whether err is worth retrying. Client errors (4xx) will
+// not go away by asking again.
+func temporary(err error) bool {
+	if se, ok := err.(statusError); ok {
+		return se.code >= 500
+	}
+	return true
+}
+
+// partialPath returns the path under which the possibly incomplete download
+// of url is kept, so that an interrupted download can be resumed later, even
+// by another rkt invocation.
+func (ds Store) partialPath(url string) (string, error) {
+	dir := filepath.Join(ds.base, "tmp", "partial")
+	if err := os.MkdirAll(dir, defaultPathPerm); err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, types.NewHashSHA512([]byte(url)).String()), nil
+}
+
+// downloadACI downloads aciurl into a partial file in the store's tmp dir,
+// resuming any previously interrupted download of the same URL and retrying
+// according to rp. The partial file is kept on failure so a later attempt can
+// pick up where this one left off, which includes downloads aborted because
+// ctx is done.
+// etag and lastModified, if set, make the request conditional; when the
+// server reports the ACI as not modified no file is returned and the
+// returned CacheData has UseCached set.
+func downloadACI(ctx context.Context, ds Store, aciurl, etag, lastModified string, rp RetryPolicy, auth Authenticator, progress io.Writer) (*os.File, *CacheData, error) {
+	pp, err := ds.partialPath(aciurl)
 	if err != nil {
-		return nil, err
+		return nil, nil, fmt.Errorf("error downloading aci: %v", err)
+	}
+	aciTempFile, err := openPartial(ctx, pp)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error downloading aci: %v", err)
 	}
-	defer res.Body.Close()
 
-	prefix := "Downloading aci"
-	fmtBytesSize := 18
-	barSize := int64(80 - len(prefix) - fmtBytesSize)
-	bar := ioprogress.DrawTextFormatBar(barSize)
-	fmtfunc := func(progress, total int64) string {
-		return fmt.Sprintf(
-			"%s: %s %s",
-			prefix,
-			bar(progress, total),
-			ioprogress.DrawTextFormatBytes(progress, total),
-		)
+	var cd *CacheData
+	delay := rp.Backoff
+	for attempt := 0; ; attempt++ {
+		cd, err = resumeDownload(ctx, aciTempFile, aciurl, etag, lastModified, auth, progress)
+		if err == nil {
+			break
+		}
+		if ctx.Err() != nil {
+			aciTempFile.Close()
+			return nil, nil, ctx.Err()
+		}
+		if !temporary(err) {
+			removePartial(pp)
+			aciTempFile.Close()
+			return nil, nil, err
+		}
+		if attempt >= rp.Retries {
+			aciTempFile.Close()
+			return nil, nil, err
+		}
+		fmt.Fprintf(os.Stderr, "Download of %s failed (%v), retrying in %v\n", aciurl, err, delay)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			aciTempFile.Close()
+			return nil, nil, ctx.Err()
+		}
+		delay *= 2
 	}
 
-	reader := &ioprogress.Reader{
-		Reader:       res.Body,
-		Size:         res.ContentLength,
-		DrawFunc:     ioprogress.DrawTerminalf(os.Stdout, fmtfunc),
-		DrawInterval: time.Second,
+	if cd.UseCached {
+		removePartial(pp)
+		aciTempFile.Close()
+		return nil, cd, nil
+	}
+	if err := aciTempFile.Sync(); err != nil {
+		removePartial(pp)
+		aciTempFile.Close()
+		return nil, nil, fmt.Errorf("error writing temp aci: %v", err)
+	}
+	if _, err := aciTempFile.Seek(0, 0); err != nil {
+		removePartial(pp)
+		aciTempFile.Close()
+		return nil, nil, fmt.Errorf("error seeking temp aci: %v", err)
 	}
+	// the download is complete, nothing left to resume
+	os.Remove(validatorPath(pp))
+	return aciTempFile, cd, nil
+}
 
-	// TODO(jonboulle): handle http more robustly (redirects?)
-	if res.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("bad HTTP status code: %d", res.StatusCode)
+// openPartial opens the partial file at pp and takes an exclusive lock on
+// it, held until the file is closed, so that concurrent downloads of the
+// same URL don't append to the same file: the later ones wait, until ctx is
+// done, and then resume from what the first one left behind. A partial file
+// is removed before it is closed once done with, and a new one is created
+// by those which waited for it.
+func openPartial(ctx context.Context, pp string) (*os.File, error) {
+	for {
+		f, err := os.OpenFile(pp, os.O_RDWR|os.O_CREATE, 0644)
+		if err != nil {
+			return nil, err
+		}
+		if err := lockPartial(ctx, f); err != nil {
+			f.Close()
+			return nil, err
+		}
+		fi, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		if pfi, err := os.Stat(pp); err == nil && os.SameFile(fi, pfi) {
+			return f, nil
+		}
+		f.Close()
 	}
+}
 
-	aciTempFile, err := ds.tmpFile()
+// lockPartial takes an exclusive lock on f, waiting for it until ctx is
+// done.
+func lockPartial(ctx context.Context, f *os.File) error {
+	waiting := false
+	for {
+		err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if err != syscall.EWOULDBLOCK {
+			return err
+		}
+		if !waiting {
+			fmt.Fprintf(os.Stderr, "Waiting for another download of the same aci to finish\n")
+			waiting = true
+		}
+		select {
+		case <-time.After(100 * time.Millisecond):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// validatorPath is the file recording the ETag or Last-Modified value of the
+// response a partial download was started from.
+func validatorPath(partial string) string {
+	return partial + ".validator"
+}
+
+func removePartial(partial string) {
+	os.Remove(partial)
+	os.Remove(validatorPath(partial))
+}
+
+// resumeDownload appends the remainder of aciurl to f. If f already holds
+// some data a Range request is made; the server's answer decides whether we
+// continue where we left off or start over. Otherwise the request is made
+// conditional on etag and lastModified, if given. Progress is drawn on
+// progress, if not nil.
+func resumeDownload(ctx context.Context, f *os.File, aciurl, etag, lastModified string, auth Authenticator, progress io.Writer) (*CacheData, error) {
+	offset, err := f.Seek(0, os.SEEK_END)
 	if err != nil {
-		return nil, fmt.Errorf("error downloading aci: %v", err)
+		return nil, err
 	}
 
-	if _, err := io.Copy(aciTempFile, reader); err != nil {
-		aciTempFile.Close()
-		os.Remove(aciTempFile.Name())
+	req, err := http.NewRequest("GET", aciurl, nil)
+	if err != nil {
+		return nil, err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		// only accept a partial response if the remote file did not change
+		if v, err := ioutil.ReadFile(validatorPath(f.Name())); err == nil && len(v) > 0 {
+			req.Header.Set("If-Range", string(v))
+		}
+	} else {
+		if etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		if lastModified != "" {
+			req.Header.Set("If-Modified-Since", lastModified)
+		}
+	}
+
+	if auth != nil {
+		auth.Authenticate(req)
+	}
+
+	res, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	cd := &CacheData{
+		ETag:         res.Header.Get("ETag"),
+		LastModified: res.Header.Get("Last-Modified"),
+		MaxAge:       MaxAge(res.Header.Get("Cache-Control")),
+	}
+
+	switch res.StatusCode {
+	case http.StatusNotModified:
+		cd.UseCached = true
+		return cd, nil
+	case http.StatusPartialContent:
+		start, err := contentRangeStart(res.Header.Get("Content-Range"))
+		if err != nil || start != offset {
+			if offset == 0 {
+				return nil, fmt.Errorf("unexpected partial response for %s: %q", aciurl, res.Header.Get("Content-Range"))
+			}
+			// not the remainder of what we have, start over
+			if err := f.Truncate(0); err != nil {
+				return nil, err
+			}
+			res.Body.Close()
+			return resumeDownload(ctx, f, aciurl, etag, lastModified, auth, progress)
+		}
+	case http.StatusOK:
+		// either a fresh download or the server ignored our range
+		if err := f.Truncate(0); err != nil {
+			return nil, err
+		}
+		if _, err := f.Seek(0, 0); err != nil {
+			return nil, err
+		}
+		offset = 0
+		v := cd.ETag
+		if v == "" {
+			v = cd.LastModified
+		}
+		if err := ioutil.WriteFile(validatorPath(f.Name()), []byte(v), 0644); err != nil {
+			return nil, err
+		}
+	case http.StatusRequestedRangeNotSatisfiable:
+		// what we have doesn't match the remote file, start over
+		if err := f.Truncate(0); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("partial download of %s is stale", aciurl)
+	default:
+		return nil, statusError{res.StatusCode}
+	}
+
+	var reader io.Reader = res.Body
+	if progress != nil {
+		prefix := "Downloading aci"
+		fmtBytesSize := 18
+		barSize := int64(80 - len(prefix) - fmtBytesSize)
+		bar := ioprogress.DrawTextFormatBar(barSize)
+		fmtfunc := func(done, total int64) string {
+			return fmt.Sprintf(
+				"%s: %s %s",
+				prefix,
+				bar(offset+done, total),
+				ioprogress.DrawTextFormatBytes(offset+done, total),
+			)
+		}
+
+		size := res.ContentLength
+		if size >= 0 {
+			size += offset
+		}
+		reader = &ioprogress.Reader{
+			Reader:       res.Body,
+			Size:         size,
+			DrawFunc:     ioprogress.DrawTerminalf(progress, fmtfunc),
+			DrawInterval: time.Second,
+		}
+	}
+
+	if _, err := io.Copy(f, reader); err != nil {
 		return nil, fmt.Errorf("error copying temp aci: %v", err)
 	}
-	if err := aciTempFile.Sync(); err != nil {
-		aciTempFile.Close()
-		os.Remove(aciTempFile.Name())
-		return nil, fmt.Errorf("error writing temp aci: %v", err)
+	return cd, nil
+}
+
+// contentRangeStart returns the offset of the first byte of a partial
+// response from its Content-Range header, e.g. "bytes 5000-9999/10000".
+func contentRangeStart(cr string) (int64, error) {
+	var start, end int64
+	var total string
+	if _, err := fmt.Sscanf(cr, "bytes %d-%d/%s", &start, &end, &total); err != nil {
+		return 0, fmt.Errorf("invalid Content-Range %q", cr)
 	}
-	return aciTempFile, nil
+	return start, nil
 }
 
-func downloadSignatureFile(sigurl string) (*os.File, error) {
-	sig, err := ioutil.TempFile("", "")
+// MaxAge returns the number of seconds a response with the given
+// Cache-Control header may be considered fresh, 0 meaning it must be
+// revalidated before being used again.
+func MaxAge(cacheControl string) int {
+	age := 0
+	for _, d := range strings.Split(cacheControl, ",") {
+		d = strings.ToLower(strings.TrimSpace(d))
+		switch {
+		case d == "no-cache" || d == "no-store":
+			return 0
+		case strings.HasPrefix(d, "max-age="):
+			v, err := strconv.Atoi(strings.TrimPrefix(d, "max-age="))
+			if err == nil && v > 0 {
+				age = v
+			}
+		}
+	}
+	return age
+}
+
+func downloadSignatureFile(ctx context.Context, sigurl string, auth Authenticator) (sig *os.File, err error) {
+	sig, err = ioutil.TempFile("", "")
 	if err != nil {
 		return nil, fmt.Errorf("error downloading signature: %v", err)
 	}
-	res, err := http.Get(sigurl)
+	defer func() {
+		if err != nil {
+			sig.Close()
+			os.Remove(sig.Name())
+		}
+	}()
+
+	req, err := http.NewRequest("GET", sigurl, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error downloading signature: %v", err)
+	}
+	if auth != nil {
+		auth.Authenticate(req)
+	}
+	res, err := http.DefaultClient.Do(req.WithContext(ctx))
 	if err != nil {
 		return nil, fmt.Errorf("error downloading signature: %v", err)
 	}
@@ -188,13 +527,9 @@ func downloadSignatureFile(sigurl string) (*os.File, error) {
 	}
 
 	if _, err := io.Copy(sig, res.Body); err != nil {
-		sig.Close()
-		os.Remove(sig.Name())
 		return nil, fmt.Errorf("error copying signature: %v", err)
 	}
 	if err := sig.Sync(); err != nil {
-		sig.Close()
-		os.Remove(sig.Name())
 		return nil, fmt.Errorf("error writing signature: %v", err)
 	}
 	return sig, nil
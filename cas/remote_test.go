@@ -1,9 +1,15 @@
 package cas
 
 import (
+	"bytes"
+	"context"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestNewRemote(t *testing.T) {
@@ -46,3 +52,232 @@ func TestNewRemote(t *testing.T) {
 		t.Errorf("unexpected blob: got %v", nc.BlobKey)
 	}
 }
+
+func TestDownloadResume(t *testing.T) {
+	dir, err := ioutil.TempDir("", tstprefix)
+	if err != nil {
+		t.Fatalf("error creating tempdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	ds := NewStore(dir)
+
+	body := []byte(strings.Repeat("0123456789", 1000))
+	var ranges []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ranges = append(ranges, r.Header.Get("Range"))
+		w.Header().Set("ETag", `"v1"`)
+		if len(ranges) == 1 {
+			// send only half of the body, then drop the connection
+			w.Header().Set("Content-Length", "10000")
+			w.WriteHeader(http.StatusOK)
+			w.Write(body[:5000])
+			panic(http.ErrAbortHandler)
+		}
+		http.ServeContent(w, r, "app.aci", time.Time{}, bytes.NewReader(body))
+	}))
+	defer ts.Close()
+
+	f, _, err := downloadACI(context.Background(), *ds, ts.URL+"/app.aci", "", "", RetryPolicy{Retries: 1}, nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected error downloading: %v", err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	got, err := ioutil.ReadAll(f)
+	if err != nil {
+		t.Fatalf("unexpected error reading download: %v", err)
+	}
+	if !bytes.Equal(got, body) {
+		t.Errorf("downloaded %d bytes, want %d matching bytes", len(got), len(body))
+	}
+	if len(ranges) != 2 || ranges[0] != "" || ranges[1] != "bytes=5000-" {
+		t.Errorf("unexpected Range headers: %q", ranges)
+	}
+	if _, err := os.Stat(validatorPath(f.Name())); !os.IsNotExist(err) {
+		t.Errorf("validator file left behind after complete download")
+	}
+}
+
+func TestDownloadNotModified(t *testing.T) {
+	dir, err := ioutil.TempDir("", tstprefix)
+	if err != nil {
+		t.Fatalf("error creating tempdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	ds := NewStore(dir)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Cache-Control", "public, max-age=3600")
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Write([]byte("aci"))
+	}))
+	defer ts.Close()
+
+	f, cd, err := downloadACI(context.Background(), *ds, ts.URL, "", "", RetryPolicy{}, nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected error downloading: %v", err)
+	}
+	f.Close()
+	os.Remove(f.Name())
+	if cd.UseCached || cd.ETag != `"v1"` || cd.MaxAge != 3600 {
+		t.Errorf("unexpected cache data for first download: %+v", cd)
+	}
+
+	f, cd, err = downloadACI(context.Background(), *ds, ts.URL, cd.ETag, "", RetryPolicy{}, nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected error downloading: %v", err)
+	}
+	if f != nil || !cd.UseCached {
+		t.Errorf("expected the cached ACI to be used, got file %v and %+v", f, cd)
+	}
+}
+
+func TestRemoteFresh(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		r     Remote
+		fresh bool
+	}{
+		{Remote{BlobKey: "sha512-aaa", CacheMaxAge: 60, DownloadTime: now.Add(-time.Second)}, true},
+		{Remote{BlobKey: "sha512-aaa", CacheMaxAge: 60, DownloadTime: now.Add(-time.Hour)}, false},
+		{Remote{BlobKey: "sha512-aaa", DownloadTime: now}, false},
+		{Remote{CacheMaxAge: 60, DownloadTime: now}, false},
+	}
+	for i, tt := range tests {
+		if g := tt.r.Fresh(now); g != tt.fresh {
+			t.Errorf("#%d: got %v, want %v", i, g, tt.fresh)
+		}
+	}
+}
+
+func TestMaxAge(t *testing.T) {
+	tests := []struct {
+		in  string
+		out int
+	}{
+		{"", 0},
+		{"max-age=60", 60},
+		{"public, Max-Age=120", 120},
+		{"max-age=60, no-cache", 0},
+		{"no-store", 0},
+		{"max-age=bogus", 0},
+	}
+	for i, tt := range tests {
+		if g := MaxAge(tt.in); g != tt.out {
+			t.Errorf("#%d: got %v, want %v", i, g, tt.out)
+		}
+	}
+}
+
+func TestDownloadResumeRangeMismatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", tstprefix)
+	if err != nil {
+		t.Fatalf("error creating tempdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	ds := NewStore(dir)
+
+	body := []byte(strings.Repeat("0123456789", 1000))
+	var ranges []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ranges = append(ranges, r.Header.Get("Range"))
+		if r.Header.Get("Range") != "" {
+			// a range other than the one asked for
+			w.Header().Set("Content-Range", "bytes 4000-9999/10000")
+			w.WriteHeader(http.StatusPartialContent)
+			w.Write(body[4000:])
+			return
+		}
+		w.Write(body)
+	}))
+	defer ts.Close()
+
+	pp, err := ds.partialPath(ts.URL)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := ioutil.WriteFile(pp, body[:5000], 0644); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	f, _, err := downloadACI(context.Background(), *ds, ts.URL, "", "", RetryPolicy{}, nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected error downloading: %v", err)
+	}
+	defer f.Close()
+	defer os.Remove(f.Name())
+
+	got, err := ioutil.ReadAll(f)
+	if err != nil {
+		t.Fatalf("unexpected error reading download: %v", err)
+	}
+	if !bytes.Equal(got, body) {
+		t.Errorf("downloaded %d bytes, want %d matching bytes", len(got), len(body))
+	}
+	if len(ranges) != 2 || ranges[0] != "bytes=5000-" || ranges[1] != "" {
+		t.Errorf("unexpected Range headers: %q", ranges)
+	}
+}
+
+func TestDownloadConcurrent(t *testing.T) {
+	dir, err := ioutil.TempDir("", tstprefix)
+	if err != nil {
+		t.Fatalf("error creating tempdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	ds := NewStore(dir)
+
+	body := []byte(strings.Repeat("0123456789", 1000))
+	started := make(chan struct{}, 2)
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		w.Write(body[:5000])
+		w.(http.Flusher).Flush()
+		<-release
+		w.Write(body[5000:])
+	}))
+	defer ts.Close()
+
+	type result struct {
+		f   *os.File
+		err error
+	}
+	download := func(c chan<- result) {
+		f, _, err := downloadACI(context.Background(), *ds, ts.URL, "", "", RetryPolicy{}, nil, nil)
+		c <- result{f, err}
+	}
+	first, second := make(chan result, 1), make(chan result, 1)
+	go download(first)
+	<-started
+	go download(second)
+	// the second download waits for the first rather than appending to
+	// its file
+	select {
+	case <-started:
+		t.Fatalf("second download requested while the first was in progress")
+	case <-time.After(300 * time.Millisecond):
+	}
+	close(release)
+
+	for _, c := range []chan result{first, second} {
+		r := <-c
+		if r.err != nil {
+			t.Fatalf("unexpected error downloading: %v", r.err)
+		}
+		got, err := ioutil.ReadAll(r.f)
+		if err != nil {
+			t.Fatalf("unexpected error reading download: %v", err)
+		}
+		if !bytes.Equal(got, body) {
+			t.Errorf("downloaded %d bytes, want %d matching bytes", len(got), len(body))
+		}
+		os.Remove(r.f.Name())
+		r.f.Close()
+	}
+}
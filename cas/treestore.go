@@ -0,0 +1,203 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cas
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/coreos/rocket/pkg/lock"
+	"github.com/coreos/rocket/pkg/zfs"
+)
+
+// The tree store keeps images rendered (i.e. extracted on top of their
+// dependencies) as plain directory trees, so that containers can reuse
+// them instead of extracting the images again. Trees are identified by
+// their caller, are immutable once rendered and shared by all their users.
+// When the store is on ZFS, trees may also be rendered into datasets of
+// their own, which containers get writable clones of.
+
+// zfsSnapshotName names the snapshot of a rendered ZFS tree
+const zfsSnapshotName = "rendered"
+
+func (ds Store) treeDir() string {
+	return filepath.Join(ds.base, "cas", "tree")
+}
+
+// TreePath returns the directory holding the tree identified by id.
+func (ds Store) TreePath(id string) string {
+	return filepath.Join(ds.treeDir(), id)
+}
+
+// RenderTree returns the directory holding the tree identified by id,
+// calling render to populate a directory with it first if it isn't in the
+// store yet. Trees hold decrypted images, so they are not available when
+// the store is encrypted.
+func (ds Store) RenderTree(id string, render func(dir string) error) (string, error) {
+	if ds.aead != nil {
+		return "", errors.New("the tree store is not available when images are encrypted at rest")
+	}
+	tp := ds.TreePath(id)
+	if _, err := os.Stat(tp); err == nil {
+		return tp, nil
+	} else if !os.IsNotExist(err) {
+		return "", err
+	}
+
+	if err := os.MkdirAll(ds.treeDir(), defaultPathPerm); err != nil {
+		return "", fmt.Errorf("error creating tree store: %v", err)
+	}
+	// render next to the final location so it can be renamed in place
+	// atomically, a tree is never seen half-rendered
+	tmp, err := ioutil.TempDir(ds.treeDir(), "."+id+"-")
+	if err != nil {
+		return "", fmt.Errorf("error creating tree: %v", err)
+	}
+	if err := render(tmp); err != nil {
+		os.RemoveAll(tmp)
+		return "", err
+	}
+	// recorded so that the tree can be verified later
+	digest, err := digestTree(context.Background(), tmp, 0)
+	if err == nil {
+		err = ds.writeTreeDigest(id, digest)
+	}
+	if err != nil {
+		os.RemoveAll(tmp)
+		return "", fmt.Errorf("error recording digest of tree: %v", err)
+	}
+	if err := os.Rename(tmp, tp); err != nil {
+		os.RemoveAll(tmp)
+		// the tree may have been rendered concurrently
+		if _, serr := os.Stat(tp); serr == nil {
+			return tp, nil
+		}
+		return "", fmt.Errorf("error storing tree: %v", err)
+	}
+	return tp, nil
+}
+
+// RemoveTree removes the tree identified by id from the store. Hard-link
+// copies of the tree are not affected, but it must not be removed while a
+// container uses it as the lower layer of an overlay. Its ZFS rendering, if
+// any, is destroyed too, which fails while containers hold clones of it.
+func (ds Store) RemoveTree(id string) error {
+	if _, err := os.Stat(ds.zfsTreePath(id)); err == nil {
+		parent, err := zfs.DatasetOf(ds.zfsTreeDir())
+		if err != nil {
+			return err
+		}
+		if err := zfs.Destroy(zfsTreeDataset(parent, id)); err != nil {
+			return err
+		}
+		if err := os.Remove(ds.zfsTreePath(id)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if err := os.Remove(ds.treeDigestPath(id)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return os.RemoveAll(ds.TreePath(id))
+}
+
+// LockTrees takes a lock on the tree store, shared unless exclusive is
+// set. The containers hold it shared while they record the trees they use
+// and set them up, waiting for rkt gc to be done removing the trees no
+// container uses, which holds it exclusively. Exclusive locks aren't waited
+// for, lock.ErrLocked is returned if it is held.
+func (ds Store) LockTrees(exclusive bool) (*lock.DirLock, error) {
+	if err := os.MkdirAll(ds.treeDir(), defaultPathPerm); err != nil {
+		return nil, fmt.Errorf("error creating tree store: %v", err)
+	}
+	if exclusive {
+		return lock.TryExclusiveLock(ds.treeDir())
+	}
+	return lock.SharedLock(ds.treeDir())
+}
+
+// ZFSTreeIDs returns the identifiers of the trees rendered in ZFS datasets
+// in the store.
+func (ds Store) ZFSTreeIDs() ([]string, error) {
+	fis, err := ioutil.ReadDir(ds.zfsTreeDir())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for _, fi := range fis {
+		ids = append(ids, fi.Name())
+	}
+	return ids, nil
+}
+
+func (ds Store) zfsTreeDir() string {
+	return filepath.Join(ds.base, "cas", "zfstree")
+}
+
+func (ds Store) zfsTreePath(id string) string {
+	return filepath.Join(ds.zfsTreeDir(), id)
+}
+
+func zfsTreeDataset(parent, id string) string {
+	return parent + "/rkt-tree-" + id
+}
+
+// RenderTreeZFS returns the ZFS snapshot holding the tree identified by id,
+// calling render to populate the mountpoint of a new dataset with it first
+// if it isn't in the store yet. The store must be on a ZFS dataset, which
+// the tree datasets are created beneath; the snapshot is taken once the
+// tree is fully rendered so it is never seen half-rendered.
+func (ds Store) RenderTreeZFS(id string, render func(dir string) error) (string, error) {
+	if ds.aead != nil {
+		return "", errors.New("the tree store is not available when images are encrypted at rest")
+	}
+	if err := os.MkdirAll(ds.zfsTreeDir(), defaultPathPerm); err != nil {
+		return "", fmt.Errorf("error creating tree store: %v", err)
+	}
+	parent, err := zfs.DatasetOf(ds.zfsTreeDir())
+	if err != nil {
+		return "", err
+	}
+	name := zfsTreeDataset(parent, id)
+	snap := name + "@" + zfsSnapshotName
+	if zfs.Exists(snap) {
+		return snap, nil
+	}
+	// left behind by an interrupted rendering
+	if zfs.Exists(name) {
+		if err := zfs.Destroy(name); err != nil {
+			return "", err
+		}
+	}
+
+	if err := zfs.Create(name, ds.zfsTreePath(id)); err != nil {
+		return "", err
+	}
+	if err := render(ds.zfsTreePath(id)); err != nil {
+		zfs.Destroy(name)
+		return "", err
+	}
+	if err := zfs.Snapshot(snap); err != nil {
+		zfs.Destroy(name)
+		return "", err
+	}
+	return snap, nil
+}
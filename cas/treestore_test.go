@@ -0,0 +1,101 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cas
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/coreos/rocket/pkg/lock"
+)
+
+func TestRenderTree(t *testing.T) {
+	dir, err := ioutil.TempDir("", tstprefix)
+	if err != nil {
+		t.Fatalf("error creating tempdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	ds := NewStore(dir)
+
+	renders := 0
+	render := func(d string) error {
+		renders++
+		return ioutil.WriteFile(filepath.Join(d, "file"), []byte("rendered"), 0644)
+	}
+	for i := 0; i < 2; i++ {
+		tp, err := ds.RenderTree("sha512-tree", render)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if tp != ds.TreePath("sha512-tree") {
+			t.Errorf("got tree path %q, want %q", tp, ds.TreePath("sha512-tree"))
+		}
+		if b, err := ioutil.ReadFile(filepath.Join(tp, "file")); err != nil || string(b) != "rendered" {
+			t.Errorf("got %q, %v, want the rendered file", b, err)
+		}
+	}
+	if renders != 1 {
+		t.Errorf("tree rendered %d times, want 1", renders)
+	}
+
+	// failed renderings leave nothing behind
+	if _, err := ds.RenderTree("sha512-fail", func(string) error { return errors.New("failed") }); err == nil {
+		t.Errorf("expected an error")
+	}
+	if fis, err := ioutil.ReadDir(ds.treeDir()); err != nil || len(fis) != 1 {
+		t.Errorf("got %d trees (%v), want 1", len(fis), err)
+	}
+
+	if err := ds.RemoveTree("sha512-tree"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := os.Stat(ds.TreePath("sha512-tree")); !os.IsNotExist(err) {
+		t.Errorf("tree not removed")
+	}
+
+	if err := ds.SetEncryptionKey(testKey); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := ds.RenderTree("sha512-tree", render); err == nil {
+		t.Errorf("expected an error using the tree store with encryption")
+	}
+}
+
+func TestLockTrees(t *testing.T) {
+	dir, err := ioutil.TempDir("", tstprefix)
+	if err != nil {
+		t.Fatalf("error creating tempdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	ds := NewStore(dir)
+
+	l, err := ds.LockTrees(false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// trees are being set up, they can't be removed
+	if _, err := ds.LockTrees(true); err != lock.ErrLocked {
+		t.Errorf("got %v, want %v", err, lock.ErrLocked)
+	}
+	l.Close()
+	l, err = ds.LockTrees(true)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	l.Close()
+}
@@ -0,0 +1,308 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cas
+
+import (
+	"context"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	pkgio "github.com/coreos/rocket/pkg/io"
+)
+
+// The store can be verified in the background: images are hashed again and
+// compared to their keys, rendered trees to the digest recorded when they
+// were rendered. Reads are throttled to the given rate, in bytes per
+// second, 0 meaning unthrottled, and when each image or tree was last
+// verified is kept so that a sweep can be spread over several runs.
+//
+// The fingerprint of the key the signature of an image was verified with
+// as it was fetched is recorded too, so that whether the key is still
+// trusted can be checked without the image or its signature, and the
+// results of such checks are kept for as long as the keystore doesn't
+// change.
+
+// ErrCorrupt is returned when the content of an image or tree doesn't match
+// its digest.
+var ErrCorrupt = errors.New("content doesn't match its digest")
+
+func (ds Store) treeDigestPath(id string) string {
+	return filepath.Join(ds.base, "cas", "treedigest", id)
+}
+
+func (ds Store) verifiedPath() string {
+	return filepath.Join(ds.base, "cas", "verified")
+}
+
+func (ds Store) signerPath(key string) string {
+	return filepath.Join(ds.base, "cas", "signer", key)
+}
+
+func (ds Store) signatureChecksPath() string {
+	return filepath.Join(ds.base, "cas", "sigchecked")
+}
+
+// ImageKeys returns the keys of all the images in the store.
+func (ds Store) ImageKeys() []string {
+	var keys []string
+	for key := range ds.stores[blobType].Keys(nil) {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// VerifyImage hashes the image stored under key again, returning
+// ErrCorrupt if it doesn't match the key. Encrypted images are
+// authenticated as they are decrypted.
+func (ds Store) VerifyImage(ctx context.Context, key string, rate int64) error {
+	rs, err := ds.ReadStream(key)
+	if err != nil {
+		return err
+	}
+	defer rs.Close()
+	h := sha512.New()
+	r := &pkgio.ThrottledReader{R: &pkgio.ContextReader{Ctx: ctx, R: rs}, Rate: rate}
+	if _, err := io.Copy(h, r); err != nil {
+		if ctx.Err() != nil {
+			return err
+		}
+		return fmt.Errorf("%v: %v", ErrCorrupt, err)
+	}
+	if HashToKey(h) != key {
+		return ErrCorrupt
+	}
+	return nil
+}
+
+// TreeIDs returns the identifiers of the trees rendered in the store.
+func (ds Store) TreeIDs() ([]string, error) {
+	fis, err := ioutil.ReadDir(ds.treeDir())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for _, fi := range fis {
+		// skip trees being rendered and set aside
+		if fi.IsDir() && !strings.HasPrefix(fi.Name(), ".") {
+			ids = append(ids, fi.Name())
+		}
+	}
+	return ids, nil
+}
+
+// VerifyTree compares the tree identified by id to the digest recorded
+// when it was rendered, returning ErrCorrupt if it doesn't match. The
+// digest of a tree rendered before digests were recorded is recorded now.
+func (ds Store) VerifyTree(ctx context.Context, id string, rate int64) error {
+	digest, err := digestTree(ctx, ds.TreePath(id), rate)
+	if err != nil {
+		return err
+	}
+	want, err := ioutil.ReadFile(ds.treeDigestPath(id))
+	if os.IsNotExist(err) {
+		return ds.writeTreeDigest(id, digest)
+	}
+	if err != nil {
+		return err
+	}
+	if string(want) != digest {
+		return ErrCorrupt
+	}
+	return nil
+}
+
+// SetTreeAside moves the tree identified by id out of the way, so that it
+// is rendered again when next used, and returns where it is now. Unlike
+// removing it, this is safe while containers use it as the lower layer of
+// their overlay.
+func (ds Store) SetTreeAside(id string) (string, error) {
+	aside, err := ioutil.TempDir(ds.treeDir(), ".corrupt-"+id+"-")
+	if err != nil {
+		return "", err
+	}
+	dst := filepath.Join(aside, id)
+	if err := os.Rename(ds.TreePath(id), dst); err != nil {
+		os.Remove(aside)
+		return "", err
+	}
+	if err := os.Remove(ds.treeDigestPath(id)); err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+	return dst, nil
+}
+
+func (ds Store) writeTreeDigest(id, digest string) error {
+	if err := os.MkdirAll(filepath.Dir(ds.treeDigestPath(id)), defaultPathPerm); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(ds.treeDigestPath(id), []byte(digest), 0644)
+}
+
+// digestTree returns a digest of the files in dir, their metadata and
+// contents
+func digestTree(ctx context.Context, dir string, rate int64) (string, error) {
+	h := sha512.New()
+	err := filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		st := fi.Sys().(*syscall.Stat_t)
+		fmt.Fprintf(h, "%q %v %d %d %d\n", rel, fi.Mode(), st.Uid, st.Gid, st.Rdev)
+		switch {
+		case fi.Mode()&os.ModeSymlink != 0:
+			target, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(h, "%q\n", target)
+		case fi.Mode().IsRegular():
+			f, err := os.Open(path)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			fmt.Fprintf(h, "%d\n", fi.Size())
+			if _, err := io.Copy(h, &pkgio.ThrottledReader{R: f, Rate: rate}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// LastVerified returns when the images and trees of the store were last
+// verified, as recorded by SetLastVerified.
+func (ds Store) LastVerified() (map[string]time.Time, error) {
+	verified := make(map[string]time.Time)
+	if err := ds.readJSON(ds.verifiedPath(), &verified); err != nil {
+		return nil, err
+	}
+	return verified, nil
+}
+
+// SetLastVerified records when the images and trees of the store were
+// last verified, by names of the caller's choosing.
+func (ds Store) SetLastVerified(verified map[string]time.Time) error {
+	return ds.writeJSON(ds.verifiedPath(), verified)
+}
+
+// SetSigner records the fingerprint, in hex, of the key the signature of
+// the image stored under key was verified with as it was fetched.
+func (ds Store) SetSigner(key, fingerprint string) error {
+	if err := os.MkdirAll(filepath.Dir(ds.signerPath(key)), defaultPathPerm); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(ds.signerPath(key), []byte(fingerprint), 0644)
+}
+
+// Signer returns the fingerprint recorded by SetSigner for the image stored
+// under key, empty if its signature was never verified.
+func (ds Store) Signer(key string) (string, error) {
+	b, err := ioutil.ReadFile(ds.signerPath(key))
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	return string(b), err
+}
+
+// SignatureCheck is the result of checking whether the key an image was
+// signed with is trusted for its name.
+type SignatureCheck struct {
+	Trusted bool `json:"trusted"`
+	// Keystore is the digest of the keystore the key was checked
+	// against, the result being stale once it changed
+	Keystore string `json:"keystore"`
+}
+
+// SignatureChecks returns the results of the signature checks recorded by
+// SetSignatureChecks.
+func (ds Store) SignatureChecks() (map[string]SignatureCheck, error) {
+	checks := make(map[string]SignatureCheck)
+	if err := ds.readJSON(ds.signatureChecksPath(), &checks); err != nil {
+		return nil, err
+	}
+	return checks, nil
+}
+
+// SetSignatureChecks records the results of signature checks, by names of
+// the caller's choosing, e.g. the keys of the images and the fingerprints
+// of the keys they were signed with.
+func (ds Store) SetSignatureChecks(checks map[string]SignatureCheck) error {
+	return ds.writeJSON(ds.signatureChecksPath(), checks)
+}
+
+// readJSON unmarshals the file at p into v, leaving v as is if there is
+// no such file
+func (ds Store) readJSON(p string, v interface{}) error {
+	b, err := ioutil.ReadFile(p)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return fmt.Errorf("error parsing %s: %v", p, err)
+	}
+	return nil
+}
+
+// writeJSON replaces the file at p with v marshalled, atomically
+func (ds Store) writeJSON(p string, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), defaultPathPerm); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(p), "."+filepath.Base(p)+"-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(b)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), p)
+}
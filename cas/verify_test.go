@@ -0,0 +1,150 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cas
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha512"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestVerifyImage(t *testing.T) {
+	dir, err := ioutil.TempDir("", tstprefix)
+	if err != nil {
+		t.Fatalf("error creating tempdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	ds := NewStore(dir)
+
+	h := sha512.New()
+	h.Write([]byte("image"))
+	good := HashToKey(h)
+	h.Write([]byte("other"))
+	bad := HashToKey(h)
+	for _, key := range []string{good, bad} {
+		if err := ds.WriteStream(key, bytes.NewBufferString("image")); err != nil {
+			t.Fatalf("error writing to store: %v", err)
+		}
+	}
+
+	if err := ds.VerifyImage(context.Background(), good, 0); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := ds.VerifyImage(context.Background(), bad, 0); err != ErrCorrupt {
+		t.Errorf("got %v, want %v", err, ErrCorrupt)
+	}
+	if keys := ds.ImageKeys(); len(keys) != 2 {
+		t.Errorf("got keys %v, want 2", keys)
+	}
+}
+
+func TestVerifyTree(t *testing.T) {
+	dir, err := ioutil.TempDir("", tstprefix)
+	if err != nil {
+		t.Fatalf("error creating tempdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	ds := NewStore(dir)
+
+	tp, err := ds.RenderTree("sha512-tree", func(d string) error {
+		return ioutil.WriteFile(filepath.Join(d, "file"), []byte("rendered"), 0644)
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ids, err := ds.TreeIDs(); err != nil || len(ids) != 1 || ids[0] != "sha512-tree" {
+		t.Errorf("got trees %v, %v, want sha512-tree", ids, err)
+	}
+	if err := ds.VerifyTree(context.Background(), "sha512-tree", 0); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(tp, "file"), []byte("modified"), 0644); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := ds.VerifyTree(context.Background(), "sha512-tree", 0); err != ErrCorrupt {
+		t.Errorf("got %v, want %v", err, ErrCorrupt)
+	}
+
+	aside, err := ds.SetTreeAside("sha512-tree")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if b, err := ioutil.ReadFile(filepath.Join(aside, "file")); err != nil || string(b) != "modified" {
+		t.Errorf("got %q, %v, want the tree set aside", b, err)
+	}
+	if ids, err := ds.TreeIDs(); err != nil || len(ids) != 0 {
+		t.Errorf("got trees %v, %v, want none", ids, err)
+	}
+}
+
+func TestLastVerified(t *testing.T) {
+	dir, err := ioutil.TempDir("", tstprefix)
+	if err != nil {
+		t.Fatalf("error creating tempdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	ds := NewStore(dir)
+
+	verified, err := ds.LastVerified()
+	if err != nil || len(verified) != 0 {
+		t.Fatalf("got %v, %v, want nothing verified", verified, err)
+	}
+	now := time.Now().UTC().Truncate(time.Second)
+	verified["sha512-image"] = now
+	if err := ds.SetLastVerified(verified); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if verified, err = ds.LastVerified(); err != nil || !verified["sha512-image"].Equal(now) {
+		t.Errorf("got %v, %v, want sha512-image verified at %v", verified, err, now)
+	}
+}
+
+func TestSigners(t *testing.T) {
+	dir, err := ioutil.TempDir("", tstprefix)
+	if err != nil {
+		t.Fatalf("error creating tempdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	ds := NewStore(dir)
+
+	if fp, err := ds.Signer("sha512-image"); err != nil || fp != "" {
+		t.Errorf("got %q, %v, want no signer", fp, err)
+	}
+	if err := ds.SetSigner("sha512-image", "4d5b338b00c2935b90e50c16d71af6b1683451d2"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if fp, err := ds.Signer("sha512-image"); err != nil || fp != "4d5b338b00c2935b90e50c16d71af6b1683451d2" {
+		t.Errorf("got %q, %v, want the signer recorded", fp, err)
+	}
+
+	checks, err := ds.SignatureChecks()
+	if err != nil || len(checks) != 0 {
+		t.Fatalf("got %v, %v, want nothing checked", checks, err)
+	}
+	checks["sha512-image 4d5b338b00c2935b90e50c16d71af6b1683451d2"] = SignatureCheck{Trusted: true, Keystore: "digest"}
+	if err := ds.SetSignatureChecks(checks); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	checks, err = ds.SignatureChecks()
+	if c := checks["sha512-image 4d5b338b00c2935b90e50c16d71af6b1683451d2"]; err != nil || !c.Trusted || c.Keystore != "digest" {
+		t.Errorf("got %v, %v, want the check recorded", checks, err)
+	}
+}
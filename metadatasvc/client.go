@@ -0,0 +1,132 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metadatasvc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/appc/spec/schema"
+	"github.com/appc/spec/schema/types"
+)
+
+// ErrNotRegistered is returned when registering the IP of a container the
+// service doesn't know, e.g. as it was restarted since the container was
+// prepared.
+var ErrNotRegistered = errors.New("container not registered with the metadata service")
+
+// socketPath is where the service is reached, overridden by the tests
+var socketPath = SocketPath
+
+var client = &http.Client{
+	Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", socketPath)
+		},
+	},
+	Timeout: 10 * time.Second,
+}
+
+// Available reports whether the metadata service is running, i.e. its
+// socket exists.
+func Available() bool {
+	_, err := os.Stat(socketPath)
+	return err == nil
+}
+
+func do(method, path string, body []byte) (int, error) {
+	req, err := http.NewRequest(method, "http://metadata-svc"+path, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	msg, _ := ioutil.ReadAll(resp.Body)
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusNotFound:
+		return resp.StatusCode, nil
+	}
+	return resp.StatusCode, fmt.Errorf("%s: %s", resp.Status, msg)
+}
+
+// RegisterContainer registers the container described by cm, whose apps
+// have the image manifests apps by app name, replacing any previous
+// registration of it.
+func RegisterContainer(cm *schema.ContainerRuntimeManifest, apps map[string]*schema.ImageManifest) error {
+	b, err := json.Marshal(cm)
+	if err != nil {
+		return fmt.Errorf("error marshalling container manifest: %v", err)
+	}
+	path := "/containers/" + cm.UUID.String()
+	if _, err := do("PUT", path, b); err != nil {
+		return fmt.Errorf("error registering container: %v", err)
+	}
+	for name, am := range apps {
+		if b, err = json.Marshal(am); err != nil {
+			return fmt.Errorf("error marshalling image manifest: %v", err)
+		}
+		code, err := do("PUT", path+"/apps/"+name, b)
+		if err == nil && code == http.StatusNotFound {
+			err = ErrNotRegistered
+		}
+		if err != nil {
+			return fmt.Errorf("error registering app %q: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// RegisterIP tells the service the apps of the container uuid connect from
+// ip on the network netName. The traffic of the container from its host
+// link on netName, if it has one, is then restricted to ip.
+func RegisterIP(uuid types.UUID, ip net.IP, netName string) error {
+	q := url.Values{
+		"ip":  []string{ip.String()},
+		"net": []string{netName},
+	}
+	code, err := do("PUT", "/containers/"+uuid.String()+"/ip?"+q.Encode(), nil)
+	if err != nil {
+		return fmt.Errorf("error registering IP: %v", err)
+	}
+	if code == http.StatusNotFound {
+		return ErrNotRegistered
+	}
+	return nil
+}
+
+// UnregisterContainer makes the service forget the container uuid. It is
+// not an error if the service isn't running or doesn't know the container.
+func UnregisterContainer(uuid string) error {
+	if !Available() {
+		return nil
+	}
+	if _, err := do("DELETE", "/containers/"+uuid, nil); err != nil {
+		return fmt.Errorf("error unregistering container: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,564 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package metadatasvc implements the App Container metadata service, which
+// lets the apps of a container introspect it, and the registration of the
+// containers with it.
+//
+// Containers are registered over a unix socket only root can reach: their
+// manifests when they are prepared, their IP once their network is set up.
+// The apps then reach the service at a link-local address redirected to it
+// on the host, and are told apart by their source address.
+package metadatasvc
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os/exec"
+	"strconv"
+	"sync"
+
+	"github.com/appc/spec/schema"
+	"github.com/appc/spec/schema/types"
+	"github.com/coreos/rocket/Godeps/_workspace/src/github.com/gorilla/mux"
+	"github.com/coreos/rocket/networking"
+)
+
+const (
+	// SocketPath is the unix socket containers are registered on
+	SocketPath = "/run/rkt/metadata-svc.sock"
+	// IP is the link-local address the apps reach the service at
+	IP = "169.254.169.255"
+	// URL is the URL of the service given to the apps in AC_METADATA_URL
+	URL = "http://" + IP
+	// DefaultPort is the port the service listens on for the apps, the
+	// traffic to IP is redirected to it
+	DefaultPort = 4444
+)
+
+type metadata struct {
+	manifest schema.ContainerRuntimeManifest
+	apps     map[string]*schema.ImageManifest
+	// ip is the address the container is identified by, and link the
+	// host link the container is restricted to it on, if any
+	ip   string
+	link string
+	// hmacKey is the secret of the container its identity is signed with
+	hmacKey [sha256.Size]byte
+}
+
+// Server keeps the metadata of the registered containers.
+type Server struct {
+	mu            sync.Mutex
+	metadataByIP  map[string]*metadata
+	metadataByUID map[types.UUID]*metadata
+	// antiSpoof adds, or removes, the rule dropping the traffic from the
+	// host link of a container not sourced from its IP
+	antiSpoof func(link, ip string, add bool) error
+}
+
+// NewServer returns a Server with no containers registered.
+func NewServer() *Server {
+	return &Server{
+		metadataByIP:  make(map[string]*metadata),
+		metadataByUID: make(map[types.UUID]*metadata),
+		antiSpoof:     antiSpoof,
+	}
+}
+
+// Redirect adds, or removes, the rule redirecting the traffic to IP on
+// port 80 to port on the host.
+func Redirect(port int, add bool) error {
+	op := "-A"
+	if !add {
+		op = "-D"
+	}
+	return exec.Command(
+		"iptables",
+		"-t", "nat",
+		op, "PREROUTING",
+		"-p", "tcp",
+		"-d", IP,
+		"--dport", "80",
+		"-j", "REDIRECT",
+		"--to-port", strconv.Itoa(port),
+	).Run()
+}
+
+func antiSpoof(brPort, ipAddr string, add bool) error {
+	op := "-I"
+	if !add {
+		op = "-D"
+	}
+	return exec.Command(
+		"ebtables",
+		"-t", "filter",
+		op, "INPUT",
+		"-i", brPort,
+		"-p", "IPV4",
+		"!", "--ip-source", ipAddr,
+		"-j", "DROP",
+	).Run()
+}
+
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func (s *Server) handleRegisterContainer(w http.ResponseWriter, r *http.Request) {
+	uid, err := types.NewUUID(mux.Vars(r)["uid"])
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "UUID is missing or mulformed: %v", err)
+		return
+	}
+
+	m := &metadata{
+		apps: make(map[string]*schema.ImageManifest),
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&m.manifest); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "JSON-decoding failed: %v", err)
+		return
+	}
+	m.manifest.UUID = *uid
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// registering again, e.g. when running a prepared container, starts
+	// afresh but for the secret, so that its signatures stay valid
+	if old, ok := s.metadataByUID[*uid]; ok {
+		m.hmacKey = old.hmacKey
+		s.unregister(*uid)
+	} else if n, err := rand.Reader.Read(m.hmacKey[:]); err != nil || n != len(m.hmacKey) {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, "failed to generate HMAC Key")
+		return
+	}
+	s.metadataByUID[*uid] = m
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) handleRegisterApp(w http.ResponseWriter, r *http.Request) {
+	uid, err := types.NewUUID(mux.Vars(r)["uid"])
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, "UUID is missing or mulformed: %v", err)
+		return
+	}
+
+	app := &schema.ImageManifest{}
+	if err := json.NewDecoder(r.Body).Decode(&app); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "JSON-decoding failed: %v", err)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m, ok := s.metadataByUID[*uid]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, "Container with given UUID not found")
+		return
+	}
+
+	m.apps[mux.Vars(r)["app"]] = app
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) handleRegisterIP(w http.ResponseWriter, r *http.Request) {
+	uid, err := types.NewUUID(mux.Vars(r)["uid"])
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, "UUID is missing or mulformed: %v", err)
+		return
+	}
+
+	ip := net.ParseIP(r.FormValue("ip"))
+	if ip == nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "ip missing or malformed")
+		return
+	}
+
+	// the container can only be trusted to be who its address says if it
+	// can't use another one, on networks with a host link
+	var link string
+	if netName := r.FormValue("net"); netName != "" {
+		stats, err := networking.HostLinkStats()
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprintf(w, "failed to find the host link: %v", err)
+			return
+		}
+		for _, ls := range stats[uid.String()] {
+			if ls.NetName == netName {
+				link = ls.Link
+			}
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m, ok := s.metadataByUID[*uid]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, "Container with given UUID not found")
+		return
+	}
+	s.forgetIP(m)
+	// the address may have belonged to a container gone without
+	// unregistering
+	if old, ok := s.metadataByIP[ip.String()]; ok {
+		s.forgetIP(old)
+	}
+	if link != "" {
+		if err := s.antiSpoof(link, ip.String(), true); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprintf(w, "failed to set anti-spoofing: %v", err)
+			return
+		}
+	}
+	m.ip, m.link = ip.String(), link
+	s.metadataByIP[m.ip] = m
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) handleUnregister(w http.ResponseWriter, r *http.Request) {
+	uid, err := types.NewUUID(mux.Vars(r)["uid"])
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, "UUID is missing or mulformed: %v", err)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.unregister(*uid) {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, "Container with given UUID not found")
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// unregister forgets the container uid, returning whether it was
+// registered. s.mu must be held.
+func (s *Server) unregister(uid types.UUID) bool {
+	m, ok := s.metadataByUID[uid]
+	if !ok {
+		return false
+	}
+	s.forgetIP(m)
+	delete(s.metadataByUID, uid)
+	return true
+}
+
+// forgetIP stops identifying m by its IP. s.mu must be held.
+func (s *Server) forgetIP(m *metadata) {
+	if m.ip == "" {
+		return
+	}
+	if m.link != "" {
+		if err := s.antiSpoof(m.link, m.ip, false); err != nil {
+			log.Printf("Failed to remove anti-spoofing of %s: %v", m.link, err)
+		}
+	}
+	if s.metadataByIP[m.ip] == m {
+		delete(s.metadataByIP, m.ip)
+	}
+	m.ip, m.link = "", ""
+}
+
+func (s *Server) containerGet(h func(w http.ResponseWriter, r *http.Request, m *metadata)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ip := remoteIP(r)
+		s.mu.Lock()
+		m, ok := s.metadataByIP[ip]
+		s.mu.Unlock()
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprintf(w, "metadata by remoteIP (%v) not found", ip)
+			return
+		}
+
+		h(w, r, m)
+	}
+}
+
+func (s *Server) appGet(h func(w http.ResponseWriter, r *http.Request, m *metadata, _ *schema.ImageManifest)) http.HandlerFunc {
+	return s.containerGet(func(w http.ResponseWriter, r *http.Request, m *metadata) {
+		appname := mux.Vars(r)["app"]
+
+		s.mu.Lock()
+		im, ok := m.apps[appname]
+		s.mu.Unlock()
+		if ok {
+			h(w, r, m, im)
+		} else {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprintf(w, "App (%v) not found", appname)
+		}
+	})
+}
+
+func handleContainerAnnotations(w http.ResponseWriter, r *http.Request, m *metadata) {
+	w.Header().Add("Content-Type", "text/plain")
+	w.WriteHeader(http.StatusOK)
+
+	for _, annot := range m.manifest.Annotations {
+		fmt.Fprintln(w, annot.Name)
+	}
+}
+
+func handleContainerAnnotation(w http.ResponseWriter, r *http.Request, m *metadata) {
+	k, err := types.NewACName(mux.Vars(r)["name"])
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, "Container annotation is not a valid AC Name")
+		return
+	}
+
+	v, ok := m.manifest.Annotations.Get(k.String())
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, "Container annotation (%v) not found", k)
+		return
+	}
+
+	w.Header().Add("Content-Type", "text/plain")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(v))
+}
+
+func handleContainerManifest(w http.ResponseWriter, r *http.Request, m *metadata) {
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	if err := json.NewEncoder(w).Encode(m.manifest); err != nil {
+		log.Print(err)
+	}
+}
+
+func handleContainerUID(w http.ResponseWriter, r *http.Request, m *metadata) {
+	uid := m.manifest.UUID.String()
+
+	w.Header().Add("Content-Type", "text/plain")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(uid))
+}
+
+func mergeAppAnnotations(im *schema.ImageManifest, cm *schema.ContainerRuntimeManifest) types.Annotations {
+	merged := types.Annotations{}
+
+	for _, annot := range im.Annotations {
+		merged.Set(annot.Name, annot.Value)
+	}
+
+	if app := cm.Apps.Get(im.Name); app != nil {
+		for _, annot := range app.Annotations {
+			merged.Set(annot.Name, annot.Value)
+		}
+	}
+
+	return merged
+}
+
+func handleAppAnnotations(w http.ResponseWriter, r *http.Request, m *metadata, im *schema.ImageManifest) {
+	w.Header().Add("Content-Type", "text/plain")
+	w.WriteHeader(http.StatusOK)
+
+	for _, annot := range mergeAppAnnotations(im, &m.manifest) {
+		fmt.Fprintln(w, string(annot.Name))
+	}
+}
+
+func handleAppAnnotation(w http.ResponseWriter, r *http.Request, m *metadata, im *schema.ImageManifest) {
+	k, err := types.NewACName(mux.Vars(r)["name"])
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, "App annotation is not a valid AC Name")
+		return
+	}
+
+	merged := mergeAppAnnotations(im, &m.manifest)
+
+	v, ok := merged.Get(k.String())
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, "App annotation (%v) not found", k)
+		return
+	}
+
+	w.Header().Add("Content-Type", "text/plain")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(v))
+}
+
+func handleImageManifest(w http.ResponseWriter, r *http.Request, m *metadata, im *schema.ImageManifest) {
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	if err := json.NewEncoder(w).Encode(*im); err != nil {
+		log.Print(err)
+	}
+}
+
+func handleAppID(w http.ResponseWriter, r *http.Request, m *metadata, im *schema.ImageManifest) {
+	a := m.manifest.Apps.Get(im.Name)
+	if a == nil {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, "App (%v) not in the container manifest", im.Name)
+		return
+	}
+	w.Header().Add("Content-Type", "text/plain")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(a.ImageID.String()))
+}
+
+// handlePodSign signs the content form value with the secret of the
+// container, for whoever it is given to to verify it was sent by an app of
+// the container.
+func handlePodSign(w http.ResponseWriter, r *http.Request, m *metadata) {
+	content := r.FormValue("content")
+	if content == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "content field missing")
+		return
+	}
+
+	h := hmac.New(sha256.New, m.hmacKey[:])
+	io.WriteString(h, content)
+
+	w.Header().Add("Content-Type", "text/plain")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(base64.StdEncoding.EncodeToString(h.Sum(nil))))
+}
+
+// handlePodVerify checks the signature form value is the signature of the
+// content form value by the container uuid. Anyone may verify signatures.
+func (s *Server) handlePodVerify(w http.ResponseWriter, r *http.Request) {
+	uid, err := types.NewUUID(r.FormValue("uuid"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "uuid field missing or malformed: %v", err)
+		return
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(r.FormValue("signature"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "signature field missing or corrupt: %v", err)
+		return
+	}
+
+	s.mu.Lock()
+	m, ok := s.metadataByUID[*uid]
+	s.mu.Unlock()
+	// signatures of unknown containers can't be told from forged ones
+	if !ok {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	h := hmac.New(sha256.New, m.hmacKey[:])
+	io.WriteString(h, r.FormValue("content"))
+
+	if hmac.Equal(sig, h.Sum(nil)) {
+		w.WriteHeader(http.StatusOK)
+	} else {
+		w.WriteHeader(http.StatusForbidden)
+	}
+}
+
+type httpResp struct {
+	writer http.ResponseWriter
+	status int
+}
+
+func (r *httpResp) Header() http.Header {
+	return r.writer.Header()
+}
+
+func (r *httpResp) Write(d []byte) (int, error) {
+	return r.writer.Write(d)
+}
+
+func (r *httpResp) WriteHeader(status int) {
+	r.status = status
+	r.writer.WriteHeader(status)
+}
+
+func logReq(h func(w http.ResponseWriter, r *http.Request)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		resp := &httpResp{w, 0}
+		h(resp, r)
+		log.Printf("%v %v - %v", r.Method, r.RequestURI, resp.status)
+	}
+}
+
+// RegistrationHandler returns the handler registering containers, to be
+// served on SocketPath.
+func (s *Server) RegistrationHandler() http.Handler {
+	r := mux.NewRouter()
+	r.HandleFunc("/containers/{uid}", logReq(s.handleRegisterContainer)).Methods("PUT")
+	r.HandleFunc("/containers/{uid}", logReq(s.handleUnregister)).Methods("DELETE")
+	r.HandleFunc("/containers/{uid}/ip", logReq(s.handleRegisterIP)).Methods("PUT")
+	r.HandleFunc("/containers/{uid}/apps/{app:.*}", logReq(s.handleRegisterApp)).Methods("PUT")
+	return r
+}
+
+// Handler returns the handler serving the metadata to the apps of the
+// containers, as /acMetadata/v1.
+func (s *Server) Handler() http.Handler {
+	r := mux.NewRouter()
+
+	acRtr := r.Headers("Metadata-Flavor", "AppContainer").
+		PathPrefix("/acMetadata/v1").Subrouter()
+
+	mr := acRtr.Methods("GET").Subrouter()
+
+	mr.HandleFunc("/container/annotations/", logReq(s.containerGet(handleContainerAnnotations)))
+	mr.HandleFunc("/container/annotations/{name}", logReq(s.containerGet(handleContainerAnnotation)))
+	mr.HandleFunc("/container/manifest", logReq(s.containerGet(handleContainerManifest)))
+	mr.HandleFunc("/container/uid", logReq(s.containerGet(handleContainerUID)))
+
+	mr.HandleFunc("/apps/{app:.*}/annotations/", logReq(s.appGet(handleAppAnnotations)))
+	mr.HandleFunc("/apps/{app:.*}/annotations/{name}", logReq(s.appGet(handleAppAnnotation)))
+	mr.HandleFunc("/apps/{app:.*}/image/manifest", logReq(s.appGet(handleImageManifest)))
+	mr.HandleFunc("/apps/{app:.*}/image/id", logReq(s.appGet(handleAppID)))
+
+	acRtr.HandleFunc("/pod/hmac/sign", logReq(s.containerGet(handlePodSign))).Methods("POST")
+	acRtr.HandleFunc("/pod/hmac/verify", logReq(s.handlePodVerify)).Methods("POST")
+
+	return r
+}
@@ -0,0 +1,185 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metadatasvc
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/appc/spec/schema"
+	"github.com/appc/spec/schema/types"
+)
+
+// get requests path from the metadata service as an app connecting from ip
+func get(h http.Handler, method, ip, path, body string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest(method, URL+"/acMetadata/v1"+path, strings.NewReader(body))
+	req.Header.Set("Metadata-Flavor", "AppContainer")
+	if method == "POST" {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	req.RemoteAddr = ip + ":40000"
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+func TestMetadataService(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metadatasvc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(p string) { socketPath = p }(socketPath)
+	socketPath = filepath.Join(dir, "metadata-svc.sock")
+
+	s := NewServer()
+	s.antiSpoof = func(link, ip string, add bool) error {
+		t.Errorf("unexpected anti-spoofing of %s", link)
+		return nil
+	}
+	l, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go http.Serve(l, s.RegistrationHandler())
+
+	uuid, err := types.NewUUID("6733c35b-3b5f-4b4c-a67b-1f3a5d6e1c2a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ip := net.ParseIP("10.1.2.3")
+	if err := RegisterIP(*uuid, ip, ""); err != ErrNotRegistered {
+		t.Fatalf("registering the IP of an unknown container: got %v, want %v", err, ErrNotRegistered)
+	}
+
+	img := types.NewHashSHA512([]byte("app"))
+	cm := &schema.ContainerRuntimeManifest{
+		ACKind: "ContainerRuntimeManifest",
+		UUID:   *uuid,
+		Apps:   schema.AppList{{Name: "example.com/app", ImageID: *img}},
+	}
+	cm.Annotations.Set("team", "backend")
+	apps := map[string]*schema.ImageManifest{
+		"example.com/app": {ACKind: "ImageManifest", Name: "example.com/app"},
+	}
+	if err := RegisterContainer(cm, apps); err != nil {
+		t.Fatalf("unexpected error registering container: %v", err)
+	}
+
+	h := s.Handler()
+	// not identified until its IP is
+	if w := get(h, "GET", "10.1.2.3", "/container/uid", ""); w.Code != http.StatusNotFound {
+		t.Errorf("container with no IP: got status %d, want %d", w.Code, http.StatusNotFound)
+	}
+	if err := RegisterIP(*uuid, ip, ""); err != nil {
+		t.Fatalf("unexpected error registering IP: %v", err)
+	}
+
+	for _, tt := range []struct {
+		ip   string
+		path string
+		code int
+		body string
+	}{
+		{"10.1.2.3", "/container/uid", http.StatusOK, uuid.String()},
+		{"10.1.2.3", "/container/annotations/team", http.StatusOK, "backend"},
+		{"10.1.2.3", "/container/annotations/", http.StatusOK, "team\n"},
+		{"10.1.2.3", "/container/annotations/owner", http.StatusNotFound, ""},
+		{"10.1.2.3", "/apps/example.com/app/image/manifest", http.StatusOK, `"name":"example.com/app"`},
+		{"10.1.2.3", "/apps/example.com/app/image/id", http.StatusOK, img.String()},
+		{"10.1.2.3", "/apps/example.com/other/image/manifest", http.StatusNotFound, ""},
+		{"10.1.2.4", "/container/uid", http.StatusNotFound, ""},
+	} {
+		w := get(h, "GET", tt.ip, tt.path, "")
+		if w.Code != tt.code {
+			t.Errorf("%s from %s: got status %d, want %d", tt.path, tt.ip, w.Code, tt.code)
+		} else if !strings.Contains(w.Body.String(), tt.body) {
+			t.Errorf("%s from %s: got %q, want it to contain %q", tt.path, tt.ip, w.Body.String(), tt.body)
+		}
+	}
+
+	sign := func(ip, content string) string {
+		form := url.Values{"content": []string{content}}
+		w := get(h, "POST", ip, "/pod/hmac/sign", form.Encode())
+		if w.Code != http.StatusOK {
+			t.Fatalf("signing from %s: got status %d, want %d", ip, w.Code, http.StatusOK)
+		}
+		return w.Body.String()
+	}
+	sig := sign("10.1.2.3", "some message")
+
+	// another container signs with a secret of its own
+	other, err := types.NewUUID("0f4a2c6e-8a1b-4c3d-9e5f-7a6b5c4d3e2f")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ocm := &schema.ContainerRuntimeManifest{ACKind: "ContainerRuntimeManifest", UUID: *other}
+	if err := RegisterContainer(ocm, nil); err != nil {
+		t.Fatalf("unexpected error registering container: %v", err)
+	}
+	if err := RegisterIP(*other, net.ParseIP("10.1.2.4"), ""); err != nil {
+		t.Fatalf("unexpected error registering IP: %v", err)
+	}
+	if osig := sign("10.1.2.4", "some message"); osig == sig {
+		t.Errorf("containers signed with the same secret")
+	}
+
+	// registering again keeps the secret
+	if err := RegisterContainer(cm, apps); err != nil {
+		t.Fatalf("unexpected error registering container again: %v", err)
+	}
+
+	for _, tt := range []struct {
+		uid     string
+		content string
+		sig     string
+		code    int
+	}{
+		{uuid.String(), "some message", sig, http.StatusOK},
+		{uuid.String(), "another message", sig, http.StatusForbidden},
+		{uuid.String(), "some message", "AAAA", http.StatusForbidden},
+		{other.String(), "some message", sig, http.StatusForbidden},
+		{"2b8e5d1c-4f3a-4e6b-8c7d-9a0b1c2d3e4f", "some message", sig, http.StatusForbidden},
+		{uuid.String(), "some message", "not base64", http.StatusBadRequest},
+	} {
+		form := url.Values{
+			"uuid":      []string{tt.uid},
+			"content":   []string{tt.content},
+			"signature": []string{tt.sig},
+		}
+		// verifying doesn't need the caller to be a container
+		if w := get(h, "POST", "192.168.0.1", "/pod/hmac/verify", form.Encode()); w.Code != tt.code {
+			t.Errorf("verifying %q by %s with %q: got status %d, want %d", tt.content, tt.uid, tt.sig, w.Code, tt.code)
+		}
+	}
+
+	if err := UnregisterContainer(uuid.String()); err != nil {
+		t.Fatalf("unexpected error unregistering container: %v", err)
+	}
+	if w := get(h, "GET", "10.1.2.3", "/container/uid", ""); w.Code != http.StatusNotFound {
+		t.Errorf("unregistered container: got status %d, want %d", w.Code, http.StatusNotFound)
+	}
+	if err := UnregisterContainer(uuid.String()); err != nil {
+		t.Errorf("unexpected error unregistering unknown container: %v", err)
+	}
+}
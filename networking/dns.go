@@ -0,0 +1,59 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package networking
+
+import (
+	"github.com/coreos/rocket/networking/util"
+)
+
+// DNS returns the resolver settings the plugins returned for the nets of
+// the container.
+func (n *Networking) DNS() util.DNS {
+	return mergeDNS(n.nets)
+}
+
+// mergeDNS merges the resolver settings of nets. The default net, the last
+// one, comes first: its nameservers are tried first and its domain is
+// kept, if it has one.
+func mergeDNS(nets []activeNet) util.DNS {
+	var d util.DNS
+	for i := len(nets) - 1; i >= 0; i-- {
+		nd := &nets[i].dns
+		d.Nameservers = appendNew(d.Nameservers, nd.Nameservers...)
+		if d.Domain == "" {
+			d.Domain = nd.Domain
+		}
+		d.Search = appendNew(d.Search, nd.Search...)
+		d.Options = appendNew(d.Options, nd.Options...)
+	}
+	return d
+}
+
+// appendNew appends to l the elements of e not in l yet
+func appendNew(l []string, e ...string) []string {
+	for _, s := range e {
+		found := false
+		for _, ls := range l {
+			if ls == s {
+				found = true
+				break
+			}
+		}
+		if !found {
+			l = append(l, s)
+		}
+	}
+	return l
+}
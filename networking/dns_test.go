@@ -0,0 +1,51 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package networking
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/coreos/rocket/networking/util"
+)
+
+func TestMergeDNS(t *testing.T) {
+	nets := []activeNet{
+		{dns: util.DNS{
+			Nameservers: []string{"10.1.0.1", "8.8.8.8"},
+			Domain:      "backend.local",
+			Search:      []string{"backend.local"},
+		}},
+		{},
+		{dns: util.DNS{
+			Nameservers: []string{"8.8.8.8", "8.8.4.4"},
+			Search:      []string{"example.com", "backend.local"},
+			Options:     []string{"ndots:2"},
+		}},
+	}
+	expected := util.DNS{
+		Nameservers: []string{"8.8.8.8", "8.8.4.4", "10.1.0.1"},
+		Domain:      "backend.local",
+		Search:      []string{"example.com", "backend.local"},
+		Options:     []string{"ndots:2"},
+	}
+	if d := mergeDNS(nets); !reflect.DeepEqual(d, expected) {
+		t.Errorf("expected %#v, got %#v", expected, d)
+	}
+
+	if d := mergeDNS([]activeNet{{}}); !d.Empty() {
+		t.Errorf("expected no settings, got %#v", d)
+	}
+}
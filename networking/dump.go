@@ -0,0 +1,248 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package networking
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+
+	"github.com/coreos/rocket/Godeps/_workspace/src/github.com/vishvananda/netlink"
+
+	"github.com/coreos/rocket/networking/util"
+)
+
+// Dump is the network state programmed for a container: the addresses and
+// routes of its interfaces, the host routes leading to it and the firewall
+// rules referring to it.
+type Dump struct {
+	Nets       []NetDump `json:"nets"`
+	HostRoutes []Route   `json:"hostRoutes"`
+	Rules      []Rule    `json:"rules"`
+}
+
+// NetDump is the state of a container's interface on a network.
+type NetDump struct {
+	NetAttachment
+	Addresses []string `json:"addresses"`
+	Routes    []Route  `json:"routes"`
+}
+
+// Route is a route of the main routing table.
+type Route struct {
+	Dst   string `json:"dst"`
+	Gw    string `json:"gw,omitempty"`
+	Dev   string `json:"dev"`
+	Scope string `json:"scope"`
+}
+
+// Rule is a firewall rule as printed by the save command of its tool, e.g.
+// "-A PREROUTING -d 10.1.0.2/32 -j ACCEPT". Feeding Tool -t Table Rule to a
+// shell restores it.
+type Rule struct {
+	Tool  string `json:"tool"`
+	Table string `json:"table"`
+	Rule  string `json:"rule"`
+}
+
+// saveCommands are the commands dumping the firewall rules of each tool
+var saveCommands = []struct {
+	tool string
+	cmd  string
+}{
+	{"iptables", "iptables-save"},
+	{"ip6tables", "ip6tables-save"},
+	{"ebtables", "ebtables-save"},
+}
+
+// DumpNetInfo collects the current state of the networking described by ni.
+// It has to run as root as it enters the container's network namespace.
+func DumpNetInfo(ni *NetInfo) (*Dump, error) {
+	// namespaces are per thread
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	d := &Dump{}
+	err := util.WithNetNSPath(ni.NetNS, func(*os.File) error {
+		for _, na := range ni.Nets {
+			nd, err := dumpNet(na)
+			if err != nil {
+				return err
+			}
+			d.Nets = append(d.Nets, *nd)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var ips []net.IP
+	for _, na := range ni.Nets {
+		addrs := []string{na.IP}
+		if na.IP6 != "" {
+			addrs = append(addrs, na.IP6)
+		}
+		for _, a := range addrs {
+			ip, _, err := net.ParseCIDR(a)
+			if err != nil {
+				return nil, fmt.Errorf("bad IP of net %q: %v", na.NetName, err)
+			}
+			ips = append(ips, ip)
+		}
+	}
+
+	if d.HostRoutes, err = hostRoutes(ips); err != nil {
+		return nil, err
+	}
+
+	for _, sc := range saveCommands {
+		out, err := exec.Command(sc.cmd).Output()
+		if err != nil {
+			if e, ok := err.(*exec.Error); ok && e.Err == exec.ErrNotFound {
+				// the tool isn't installed, so no rules for it
+				continue
+			}
+			return nil, fmt.Errorf("error running %s: %v", sc.cmd, err)
+		}
+		d.Rules = append(d.Rules, filterRules(sc.tool, out, ips)...)
+	}
+	return d, nil
+}
+
+// dumpNet dumps the interface of na, in the container's network namespace
+func dumpNet(na NetAttachment) (*NetDump, error) {
+	nd := &NetDump{
+		NetAttachment: na,
+	}
+	link, err := netlink.LinkByName(na.IfName)
+	if err != nil {
+		return nil, fmt.Errorf("error looking up %q: %v", na.IfName, err)
+	}
+	addrs, err := netlink.AddrList(link, netlink.FAMILY_ALL)
+	if err != nil {
+		return nil, fmt.Errorf("error listing addresses of %q: %v", na.IfName, err)
+	}
+	for _, a := range addrs {
+		nd.Addresses = append(nd.Addresses, a.IPNet.String())
+	}
+	routes, err := netlink.RouteList(link, netlink.FAMILY_ALL)
+	if err != nil {
+		return nil, fmt.Errorf("error listing routes of %q: %v", na.IfName, err)
+	}
+	for _, r := range routes {
+		nd.Routes = append(nd.Routes, toRoute(r, na.IfName))
+	}
+	return nd, nil
+}
+
+// hostRoutes returns the routes of the host leading to any of ips, not
+// counting default routes
+func hostRoutes(ips []net.IP) ([]Route, error) {
+	routes, err := netlink.RouteList(nil, netlink.FAMILY_ALL)
+	if err != nil {
+		return nil, fmt.Errorf("error listing host routes: %v", err)
+	}
+	var hr []Route
+	for _, r := range routes {
+		if r.Dst == nil {
+			continue
+		}
+		for _, ip := range ips {
+			if r.Dst.Contains(ip) {
+				dev := ""
+				if l, err := netlink.LinkByIndex(r.LinkIndex); err == nil {
+					dev = l.Attrs().Name
+				}
+				hr = append(hr, toRoute(r, dev))
+				break
+			}
+		}
+	}
+	return hr, nil
+}
+
+func toRoute(r netlink.Route, dev string) Route {
+	rt := Route{
+		Dst:   "default",
+		Dev:   dev,
+		Scope: scopeName(r.Scope),
+	}
+	if r.Dst != nil {
+		rt.Dst = r.Dst.String()
+	}
+	if r.Gw != nil {
+		rt.Gw = r.Gw.String()
+	}
+	return rt
+}
+
+func scopeName(s netlink.Scope) string {
+	switch s {
+	case netlink.SCOPE_UNIVERSE:
+		return "global"
+	case netlink.SCOPE_SITE:
+		return "site"
+	case netlink.SCOPE_LINK:
+		return "link"
+	case netlink.SCOPE_HOST:
+		return "host"
+	case netlink.SCOPE_NOWHERE:
+		return "nowhere"
+	}
+	return fmt.Sprintf("%d", s)
+}
+
+// filterRules picks the rules referring to any of ips from the output of
+// the save command of tool
+func filterRules(tool string, out []byte, ips []net.IP) []Rule {
+	refs := make(map[string]bool)
+	for _, ip := range ips {
+		refs[ip.String()] = true
+		if ip.To4() != nil {
+			refs[ip.String()+"/32"] = true
+		} else {
+			refs[ip.String()+"/128"] = true
+		}
+	}
+
+	var rules []Rule
+	table := ""
+	s := bufio.NewScanner(bytes.NewReader(out))
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		switch {
+		case strings.HasPrefix(line, "*"):
+			table = line[1:]
+		case strings.HasPrefix(line, "-A "):
+			for _, f := range strings.Fields(line) {
+				if refs[f] {
+					rules = append(rules, Rule{
+						Tool:  tool,
+						Table: table,
+						Rule:  line,
+					})
+					break
+				}
+			}
+		}
+	}
+	return rules
+}
@@ -0,0 +1,67 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package networking
+
+import (
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestFilterRules(t *testing.T) {
+	out := `# Generated by iptables-save v1.4.21
+*nat
+:PREROUTING ACCEPT [0:0]
+:POSTROUTING ACCEPT [0:0]
+-A PREROUTING -d 169.254.169.255/32 -p tcp -m tcp --dport 80 -j REDIRECT --to-ports 4444
+-A POSTROUTING -s 172.16.28.2/32 -j MASQUERADE
+-A POSTROUTING -s 172.16.28.20/32 -j MASQUERADE
+COMMIT
+*filter
+:INPUT ACCEPT [0:0]
+-A INPUT -i veth1a2b ! -s 172.16.28.2 -j DROP
+-A INPUT -s 10.0.0.3/32 -j ACCEPT
+COMMIT
+`
+	ips := []net.IP{net.ParseIP("172.16.28.2"), net.ParseIP("10.0.0.3")}
+	want := []Rule{
+		{"iptables", "nat", "-A POSTROUTING -s 172.16.28.2/32 -j MASQUERADE"},
+		{"iptables", "filter", "-A INPUT -i veth1a2b ! -s 172.16.28.2 -j DROP"},
+		{"iptables", "filter", "-A INPUT -s 10.0.0.3/32 -j ACCEPT"},
+	}
+	if got := filterRules("iptables", []byte(out), ips); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got := filterRules("iptables", []byte(out), nil); got != nil {
+		t.Errorf("got %v, want no rules", got)
+	}
+}
+
+func TestFilterRules6(t *testing.T) {
+	out := `# Generated by ip6tables-save v1.4.21
+*nat
+:POSTROUTING ACCEPT [0:0]
+-A POSTROUTING -s fd00:10:1::2a/128 ! -d fd00:10:1::/64 -j MASQUERADE
+-A POSTROUTING -s fd00:10:1::2b/128 ! -d fd00:10:1::/64 -j MASQUERADE
+COMMIT
+`
+	ips := []net.IP{net.ParseIP("10.1.0.2"), net.ParseIP("fd00:10:1::2a")}
+	want := []Rule{
+		{"ip6tables", "nat", "-A POSTROUTING -s fd00:10:1::2a/128 ! -d fd00:10:1::/64 -j MASQUERADE"},
+	}
+	if got := filterRules("ip6tables", []byte(out), ips); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
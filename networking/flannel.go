@@ -0,0 +1,42 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package networking
+
+import (
+	"fmt"
+
+	"github.com/coreos/rocket/networking/util"
+)
+
+// flannelType is the type of the networks of the flannel plugin
+const flannelType = "flannel"
+
+// applyFlannel fills in the masquerading settings of n, a flannel network,
+// from the subnet flannel leased for the host: the traffic of the
+// containers is masqueraded when it leaves the whole flannel network,
+// spanning all the hosts, unless flannel does it itself.
+func applyFlannel(n *Net) error {
+	fn := util.FlannelNet{}
+	if err := util.ParseNet(n.plugins()[0].conf, &fn); err != nil {
+		return err
+	}
+	env, err := util.LoadFlannelEnv(fn.SubnetFilePath())
+	if err != nil {
+		return fmt.Errorf("error loading flannel subnet: %v", err)
+	}
+	n.IPMasq = fn.Masquerade(env)
+	n.IPAlloc.Subnet = env.Network.String()
+	return nil
+}
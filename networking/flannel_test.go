@@ -0,0 +1,56 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package networking
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestApplyFlannel(t *testing.T) {
+	dir, err := ioutil.TempDir("", "flannel")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	env := filepath.Join(dir, "subnet.env")
+	if err := ioutil.WriteFile(env, []byte("FLANNEL_NETWORK=10.1.0.0/16\nFLANNEL_SUBNET=10.1.17.1/24\nFLANNEL_IPMASQ=false\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	conf := filepath.Join(dir, "flannel.conf")
+	c := fmt.Sprintf(`{"name": "overlay", "type": "flannel", "subnetFile": %q}`, env)
+	if err := ioutil.WriteFile(conf, []byte(c), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := loadNet(conf)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := applyFlannel(&n); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !n.IPMasq {
+		t.Errorf("expected the net to be masqueraded")
+	}
+	// the traffic between hosts of the flannel network isn't masqueraded
+	if n.IPAlloc.Subnet != "10.1.0.0/16" {
+		t.Errorf("expected the subnet of the flannel network, got %q", n.IPAlloc.Subnet)
+	}
+}
@@ -0,0 +1,147 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package networking
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+
+	"github.com/coreos/rocket/Godeps/_workspace/src/github.com/vishvananda/netlink"
+
+	"github.com/coreos/rocket/networking/util"
+)
+
+const (
+	guestTapPattern    = "tap%d"
+	guestBridgePattern = "br%d"
+)
+
+// GuestNet describes a net a container run in a virtual machine is
+// attached to: the VM is connected to it through a tap device of the
+// container's netns, and configures its interface with the addresses
+// and routes the plugin set up.
+type GuestNet struct {
+	NetName string
+	// Tap is the name of the tap device the VM is connected through
+	Tap string
+	// MAC is the hardware address of the interface of the VM
+	MAC     net.HardwareAddr
+	IP, IP6 *net.IPNet
+	Routes  []GuestRoute
+}
+
+// GuestRoute is a route of a GuestNet, through GW unless the destination
+// is on the link.
+type GuestRoute struct {
+	Dst *net.IPNet
+	GW  net.IP
+}
+
+// SetupGuestNets hands the nets of the container over to a virtual machine
+// run in its netns: the addresses and routes of each interface set up by
+// the plugins are moved off it, and the interface is bridged with a new tap
+// device for the VM to configure them on its own interface. It must be
+// called in the container's netns.
+func (n *Networking) SetupGuestNets() ([]GuestNet, error) {
+	var gns []GuestNet
+	for i, an := range n.nets {
+		gn, err := setupGuestNet(&an, fmt.Sprintf(guestTapPattern, i), fmt.Sprintf(guestBridgePattern, i))
+		if err != nil {
+			return nil, fmt.Errorf("error setting up net %q for the VM: %v", an.Name, err)
+		}
+		gns = append(gns, *gn)
+	}
+	return gns, nil
+}
+
+func setupGuestNet(an *activeNet, tapName, brName string) (*GuestNet, error) {
+	link, err := netlink.LinkByName(an.ifName)
+	if err != nil {
+		return nil, err
+	}
+	mac, err := util.RandomMAC()
+	if err != nil {
+		return nil, err
+	}
+	gn := &GuestNet{
+		NetName: an.Name,
+		Tap:     tapName,
+		MAC:     mac,
+		IP:      an.ipn,
+		IP6:     an.ipn6,
+	}
+
+	for _, family := range []int{netlink.FAMILY_V4, netlink.FAMILY_V6} {
+		routes, err := netlink.RouteList(link, family)
+		if err != nil {
+			return nil, fmt.Errorf("error listing routes: %v", err)
+		}
+		for _, r := range routes {
+			if r.Dst != nil && r.Gw == nil && isPrefixRoute(r.Dst, an.addrs()) {
+				continue
+			}
+			dst := r.Dst
+			if dst == nil {
+				dst = &net.IPNet{IP: net.IPv4zero, Mask: net.CIDRMask(0, 32)}
+				if family == netlink.FAMILY_V6 {
+					dst = &net.IPNet{IP: net.IPv6zero, Mask: net.CIDRMask(0, 128)}
+				}
+			}
+			gn.Routes = append(gn.Routes, GuestRoute{Dst: dst, GW: r.Gw})
+		}
+	}
+
+	// the routes go away with the addresses
+	for _, ipn := range an.addrs() {
+		if err := netlink.AddrDel(link, &netlink.Addr{IPNet: ipn}); err != nil && err != syscall.EADDRNOTAVAIL {
+			return nil, fmt.Errorf("error removing address %v: %v", ipn, err)
+		}
+	}
+
+	br := &netlink.Bridge{LinkAttrs: netlink.LinkAttrs{Name: brName}}
+	if err := netlink.LinkAdd(br); err != nil {
+		return nil, fmt.Errorf("error creating bridge %q: %v", brName, err)
+	}
+	tap, err := util.CreateTap(tapName)
+	if err != nil {
+		return nil, err
+	}
+	for _, l := range []netlink.Link{link, tap} {
+		if err := netlink.LinkSetMaster(l, br); err != nil {
+			return nil, fmt.Errorf("error adding %q to bridge %q: %v", l.Attrs().Name, brName, err)
+		}
+	}
+	if err := netlink.LinkSetUp(br); err != nil {
+		return nil, err
+	}
+	return gn, nil
+}
+
+// isPrefixRoute reports whether dst is the subnet of one of addrs, i.e.
+// the route the kernel adds along with the address, or link-local
+func isPrefixRoute(dst *net.IPNet, addrs []*net.IPNet) bool {
+	if dst.IP.IsLinkLocalUnicast() {
+		return true
+	}
+	ones, bits := dst.Mask.Size()
+	for _, a := range addrs {
+		aones, abits := a.Mask.Size()
+		if dst.IP.Equal(a.IP.Mask(a.Mask)) && ones == aones && bits == abits {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,50 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package networking
+
+import (
+	"net"
+	"testing"
+
+	"github.com/coreos/rocket/networking/util"
+)
+
+func TestIsPrefixRoute(t *testing.T) {
+	addrs := []*net.IPNet{
+		{IP: net.ParseIP("10.1.2.3").To4(), Mask: net.CIDRMask(16, 32)},
+		{IP: net.ParseIP("fd00::3"), Mask: net.CIDRMask(64, 128)},
+	}
+	tests := []struct {
+		dst    string
+		prefix bool
+	}{
+		{"10.1.0.0/16", true},
+		{"fd00::/64", true},
+		{"fe80::/64", true},
+		{"10.1.0.1/32", false},
+		{"10.1.0.0/24", false},
+		{"0.0.0.0/0", false},
+	}
+	for _, tt := range tests {
+		dst, err := util.ParseCIDR(tt.dst)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		dst.IP = dst.IP.Mask(dst.Mask)
+		if p := isPrefixRoute(dst, addrs); p != tt.prefix {
+			t.Errorf("%s: got %v, want %v", tt.dst, p, tt.prefix)
+		}
+	}
+}
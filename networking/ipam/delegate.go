@@ -0,0 +1,170 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ipam
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/appc/spec/schema/types"
+
+	"github.com/coreos/rocket/networking/util"
+)
+
+// Allocation is what the IPAM plugin of a network allocated to a container:
+// its IPv4 address and, on dual-stack networks, its IPv6 one, with their
+// gateways, the routes through them, in CIDR notation, and DNS settings.
+type Allocation struct {
+	IPN    *net.IPNet
+	IPN6   *net.IPNet
+	GW     net.IP
+	GW6    net.IP
+	Routes []string
+	DNS    util.DNS
+}
+
+// Delegated reports whether the addresses of containers on n are managed
+// by the IPAM plugin named by the type of its ipam section, rather than
+// allocated by the interface plugin itself from its ipAlloc section.
+func Delegated(n *util.Net) bool {
+	return n.IPAM != nil && n.IPAM.Type != ""
+}
+
+// findPlugin looks the plugin up in the directories of path, as given in
+// CNI_PATH.
+func findPlugin(plugin, path string) (string, error) {
+	if plugin == "" || strings.Contains(plugin, "/") {
+		return "", fmt.Errorf("invalid IPAM plugin name %q", plugin)
+	}
+	for _, d := range filepath.SplitList(path) {
+		p := filepath.Join(d, plugin)
+		if fi, err := os.Stat(p); err == nil && fi.Mode().IsRegular() {
+			return p, nil
+		}
+	}
+	return "", fmt.Errorf("could not find IPAM plugin %q in %q", plugin, path)
+}
+
+// ExecAdd runs the IPAM plugin of n for ADD, with the arguments the
+// interface plugin was run with, and returns what it allocated.
+func ExecAdd(n *util.Net, args *util.CmdArgs) (*Allocation, error) {
+	path, err := findPlugin(n.IPAM.Type, args.Path)
+	if err != nil {
+		return nil, err
+	}
+	out, err := util.ExecPlugin(path, "ADD", args)
+	if err != nil {
+		return nil, err
+	}
+	r := &util.Result{}
+	if err := json.Unmarshal(out, r); err != nil {
+		return nil, fmt.Errorf("error parsing result of IPAM plugin %q: %v", n.IPAM.Type, err)
+	}
+
+	a, err := allocationOf(r)
+	if err != nil {
+		ExecDel(n, args)
+		return nil, fmt.Errorf("IPAM plugin %q: %v", n.IPAM.Type, err)
+	}
+	return a, nil
+}
+
+func allocationOf(r *util.Result) (*Allocation, error) {
+	if r.IP4 == nil {
+		return nil, fmt.Errorf("no IPv4 address returned")
+	}
+	a := &Allocation{
+		IPN: (*net.IPNet)(&r.IP4.IP),
+		GW:  r.IP4.Gateway,
+		DNS: r.DNS,
+	}
+	if r.IP6 != nil {
+		a.IPN6 = (*net.IPNet)(&r.IP6.IP)
+		a.GW6 = r.IP6.Gateway
+		if a.IPN6.IP.To4() != nil {
+			return nil, fmt.Errorf("%v returned as an IPv6 address", a.IPN6)
+		}
+	}
+	for _, ipc := range []*util.IPConfig{r.IP4, r.IP6} {
+		if ipc == nil {
+			continue
+		}
+		for _, rt := range ipc.Routes {
+			// routes are set up through the gateway of their family
+			if rt.GW != nil && !rt.GW.Equal(ipc.Gateway) {
+				return nil, fmt.Errorf("route to %v through %v, not the gateway, is not supported", (*net.IPNet)(&rt.Dst), rt.GW)
+			}
+			a.Routes = append(a.Routes, (*net.IPNet)(&rt.Dst).String())
+		}
+	}
+	return a, nil
+}
+
+// ExecDel runs the IPAM plugin of n for DEL, releasing what it allocated.
+func ExecDel(n *util.Net, args *util.CmdArgs) error {
+	path, err := findPlugin(n.IPAM.Type, args.Path)
+	if err != nil {
+		return err
+	}
+	_, err = util.ExecPlugin(path, "DEL", args)
+	return err
+}
+
+// Release releases the addresses of the container on n, through its IPAM
+// plugin if it has one.
+func Release(n *util.Net, contID types.UUID, args *util.CmdArgs) error {
+	if Delegated(n) {
+		return ExecDel(n, args)
+	}
+	return DeallocIP(contID, args.StdinData, args.IfName, nil)
+}
+
+// PluginAdd allocates the addresses of a container as the IPAM plugins
+// shipped with rkt do, from the ipam section of the network, which is
+// mapped onto ipAlloc when loaded.
+func PluginAdd(args *util.CmdArgs) (*util.Result, error) {
+	cid, err := types.NewUUID(args.ContainerID)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing ContainerID: %v", err)
+	}
+	n := util.Net{}
+	if err := util.ParseNet(args.StdinData, &n); err != nil {
+		return nil, fmt.Errorf("failed to parse network configuration: %v", err)
+	}
+
+	ipn, gw, err := AllocIPOnLink(*cid, args.Netns, args.StdinData, args.IfName, args.Args)
+	if err != nil {
+		return nil, err
+	}
+	ipn6, gw6, err := AllocIP6(*cid, args.StdinData, args.IfName, args.Args)
+	if err != nil {
+		DeallocIP(*cid, args.StdinData, args.IfName, ipn)
+		return nil, err
+	}
+	return util.NewResult(ipn, ipn6, gw, gw6, n.Routes, n.DNS)
+}
+
+// PluginDel releases the addresses PluginAdd allocated.
+func PluginDel(args *util.CmdArgs) error {
+	cid, err := types.NewUUID(args.ContainerID)
+	if err != nil {
+		return fmt.Errorf("error parsing ContainerID: %v", err)
+	}
+	return DeallocIP(*cid, args.StdinData, args.IfName, nil)
+}
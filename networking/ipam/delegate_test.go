@@ -0,0 +1,117 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ipam
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/coreos/rocket/networking/util"
+)
+
+const testIPAMPlugin = `#!/bin/sh
+case "$CNI_COMMAND" in
+ADD) cat > "$0.stdin"; echo '{"ip4": {"ip": "10.1.2.3/24", "gateway": "10.1.2.1", "routes": [{"dst": "0.0.0.0/0"}]}, "dns": {"nameservers": ["10.1.2.53"]}}' ;;
+DEL) touch "$0.released" ;;
+esac
+`
+
+func TestExecIPAM(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ipam")
+	if err != nil {
+		t.Fatalf("error creating tempdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	plugin := filepath.Join(dir, "test-ipam")
+	if err := ioutil.WriteFile(plugin, []byte(testIPAMPlugin), 0755); err != nil {
+		t.Fatalf("error writing plugin: %v", err)
+	}
+
+	conf := []byte(`{"name": "backend", "type": "bridge", "ipam": {"type": "test-ipam"}}`)
+	n := &util.Net{}
+	if err := util.ParseNet(conf, n); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !Delegated(n) {
+		t.Fatalf("net with an ipam section not delegated")
+	}
+	args := &util.CmdArgs{
+		ContainerID: "6733c35b-3b5f-4b4c-a67b-1f3a5d6e1c2a",
+		Netns:       "/proc/self/ns/net",
+		IfName:      "eth0",
+		Path:        "/nonexistent:" + dir,
+		StdinData:   conf,
+	}
+
+	a, err := ExecAdd(n, args)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if a.IPN.String() != "10.1.2.3/24" || a.GW.String() != "10.1.2.1" || a.IPN6 != nil {
+		t.Errorf("got addresses %v via %v, %v", a.IPN, a.GW, a.IPN6)
+	}
+	if !reflect.DeepEqual(a.Routes, []string{"0.0.0.0/0"}) {
+		t.Errorf("got routes %v", a.Routes)
+	}
+	if !reflect.DeepEqual(a.DNS.Nameservers, []string{"10.1.2.53"}) {
+		t.Errorf("got DNS settings %+v", a.DNS)
+	}
+	if b, err := ioutil.ReadFile(plugin + ".stdin"); err != nil || string(b) != string(conf) {
+		t.Errorf("network configuration not passed on stdin: %q, %v", b, err)
+	}
+
+	if err := ExecDel(n, args); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := os.Stat(plugin + ".released"); err != nil {
+		t.Errorf("IPAM plugin not run for DEL: %v", err)
+	}
+
+	n.IPAM.Type = "missing"
+	if _, err := ExecAdd(n, args); err == nil {
+		t.Errorf("expected an error for a missing IPAM plugin")
+	}
+	n.IPAM.Type = "../test-ipam"
+	if _, err := ExecAdd(n, args); err == nil {
+		t.Errorf("expected an error for an IPAM plugin name with a slash")
+	}
+}
+
+func TestAllocationOf(t *testing.T) {
+	ipn, _ := util.ParseCIDR("10.1.2.3/24")
+	ipn6, _ := util.ParseCIDR("fd00::3/64")
+	r, err := util.NewResult(ipn, ipn6, nil, nil, []string{"0.0.0.0/0", "::/0"}, util.DNS{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	a, err := allocationOf(r)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if a.IPN6.String() != "fd00::3/64" || !reflect.DeepEqual(a.Routes, []string{"0.0.0.0/0", "::/0"}) {
+		t.Errorf("got %+v", a)
+	}
+
+	r.IP4.Routes[0].GW = ipn.IP
+	if _, err := allocationOf(r); err == nil {
+		t.Errorf("expected an error for a route through another gateway")
+	}
+	if _, err := allocationOf(&util.Result{}); err == nil {
+		t.Errorf("expected an error for a result without an IPv4 address")
+	}
+}
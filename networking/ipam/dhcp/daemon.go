@@ -0,0 +1,155 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//+build linux
+
+package dhcp
+
+import (
+	"fmt"
+	"net"
+	"net/rpc"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// SocketPath is where rkt-dhcp listens for the requests of the plugins
+const SocketPath = "/run/rkt/dhcp.sock"
+
+// AllocateArgs are the arguments of DHCP.Allocate
+type AllocateArgs struct {
+	ContainerID string
+	Netns       string
+	IfName      string
+}
+
+// AllocateReply is the reply of DHCP.Allocate
+type AllocateReply struct {
+	IP      net.IPNet
+	Gateway net.IP
+}
+
+// ReleaseArgs are the arguments of DHCP.Release
+type ReleaseArgs struct {
+	ContainerID string
+	IfName      string
+}
+
+// DHCP is the RPC service of rkt-dhcp, holding the leases of the container
+// interfaces
+type DHCP struct {
+	mu     sync.Mutex
+	leases map[string]*lease
+}
+
+func leaseKey(contID, ifName string) string {
+	return contID + "/" + ifName
+}
+
+// Allocate acquires an address for an interface, keeping it until Release
+// is called for it.
+func (d *DHCP) Allocate(args *AllocateArgs, reply *AllocateReply) error {
+	l, err := acquireLease(args.ContainerID, args.Netns, args.IfName)
+	if err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	old := d.leases[leaseKey(args.ContainerID, args.IfName)]
+	d.leases[leaseKey(args.ContainerID, args.IfName)] = l
+	d.mu.Unlock()
+	if old != nil {
+		old.release()
+	}
+
+	reply.IP = *l.ip
+	reply.Gateway = l.gateway
+	return nil
+}
+
+// Release gives back the address of an interface. Interfaces without a
+// lease, e.g. because rkt-dhcp was restarted, are ignored.
+func (d *DHCP) Release(args *ReleaseArgs, reply *struct{}) error {
+	d.mu.Lock()
+	l := d.leases[leaseKey(args.ContainerID, args.IfName)]
+	delete(d.leases, leaseKey(args.ContainerID, args.IfName))
+	d.mu.Unlock()
+	if l != nil {
+		l.release()
+	}
+	return nil
+}
+
+// Serve serves the requests of the plugins on the unix socket at path
+// until an error occurs.
+func Serve(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("error creating socket directory: %v", err)
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("error removing stale socket: %v", err)
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return fmt.Errorf("error listening on %q: %v", path, err)
+	}
+	defer l.Close()
+
+	srv := rpc.NewServer()
+	if err := srv.Register(&DHCP{leases: make(map[string]*lease)}); err != nil {
+		return err
+	}
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			return fmt.Errorf("error accepting connection: %v", err)
+		}
+		go srv.ServeConn(c)
+	}
+}
+
+func call(method string, args, reply interface{}) error {
+	c, err := rpc.Dial("unix", SocketPath)
+	if err != nil {
+		return fmt.Errorf("error connecting to rkt-dhcp, is it running? %v", err)
+	}
+	defer c.Close()
+	return c.Call("DHCP."+method, args, reply)
+}
+
+// Allocate asks rkt-dhcp for an address for the interface ifName, which
+// must be up, in the netns at nspath. It returns the address and the
+// router of the network, if the server gave one.
+func Allocate(contID, nspath, ifName string) (*net.IPNet, net.IP, error) {
+	var reply AllocateReply
+	args := &AllocateArgs{
+		ContainerID: contID,
+		Netns:       nspath,
+		IfName:      ifName,
+	}
+	if err := call("Allocate", args, &reply); err != nil {
+		return nil, nil, err
+	}
+	return &reply.IP, reply.Gateway, nil
+}
+
+// Release asks rkt-dhcp to give back the address of the interface ifName.
+func Release(contID, ifName string) error {
+	args := &ReleaseArgs{
+		ContainerID: contID,
+		IfName:      ifName,
+	}
+	return call("Release", args, &struct{}{})
+}
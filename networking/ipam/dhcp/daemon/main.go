@@ -0,0 +1,30 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//+build linux
+
+// rkt-dhcp holds the DHCP leases of the container interfaces on networks
+// with the dhcp IP allocation, renewing them for as long as the containers
+// run. It must be running on the host when such containers are started.
+package main
+
+import (
+	"log"
+
+	"github.com/coreos/rocket/networking/ipam/dhcp"
+)
+
+func main() {
+	log.Fatal(dhcp.Serve(dhcp.SocketPath))
+}
@@ -0,0 +1,299 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//+build linux
+
+package dhcp
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"runtime"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/coreos/rocket/networking/util"
+)
+
+const (
+	clientPort = 68
+	serverPort = 67
+
+	// replyTimeout is how long the client waits for a reply before
+	// sending its request again, up to maxAttempts times
+	replyTimeout = 4 * time.Second
+	maxAttempts  = 4
+
+	// retryInterval is how often a failed renewal is retried, until the
+	// lease expires
+	retryInterval = 30 * time.Second
+)
+
+// lease is an address acquired for an interface of a container, kept
+// renewed until it is released
+type lease struct {
+	clientID []byte
+	ifName   string
+	hwAddr   net.HardwareAddr
+	conn     net.PacketConn
+
+	ip       *net.IPNet
+	gateway  net.IP
+	serverID net.IP
+	t1       time.Time // renewal
+	expiry   time.Time
+
+	stop chan struct{}
+	done sync.WaitGroup
+}
+
+// openConn opens a UDP socket on the DHCP client port, bound to the
+// interface ifName of the calling thread's network namespace
+func openConn(ifName string) (net.PacketConn, error) {
+	fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_DGRAM, syscall.IPPROTO_UDP)
+	if err != nil {
+		return nil, err
+	}
+	f := os.NewFile(uintptr(fd), "dhcp")
+	defer f.Close()
+
+	if err := syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_REUSEADDR, 1); err != nil {
+		return nil, err
+	}
+	if err := syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_BROADCAST, 1); err != nil {
+		return nil, err
+	}
+	if err := syscall.BindToDevice(fd, ifName); err != nil {
+		return nil, err
+	}
+	if err := syscall.Bind(fd, &syscall.SockaddrInet4{Port: clientPort}); err != nil {
+		return nil, err
+	}
+	return net.FilePacketConn(f)
+}
+
+// acquireLease gets an address for the interface ifName in the netns at
+// nspath, which must be up, and keeps it renewed until release is called
+func acquireLease(contID, nspath, ifName string) (*lease, error) {
+	l := &lease{
+		// the container ID identifies the client to the server rather
+		// than the MAC address, which may be shared (ipvlan)
+		clientID: append([]byte{0}, []byte(contID+"/"+ifName)...),
+		ifName:   ifName,
+		stop:     make(chan struct{}),
+	}
+
+	// sockets stay in the netns they were created in, only their creation
+	// has to switch to it
+	runtime.LockOSThread()
+	err := util.WithNetNSPath(nspath, func(hostNS *os.File) error {
+		iface, err := net.InterfaceByName(ifName)
+		if err != nil {
+			return fmt.Errorf("failed to lookup %q: %v", ifName, err)
+		}
+		l.hwAddr = iface.HardwareAddr
+		if l.conn, err = openConn(ifName); err != nil {
+			return fmt.Errorf("failed to open DHCP socket on %q: %v", ifName, err)
+		}
+		return nil
+	})
+	runtime.UnlockOSThread()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := l.discover(); err != nil {
+		l.conn.Close()
+		return nil, err
+	}
+
+	l.done.Add(1)
+	go l.maintain()
+	return l, nil
+}
+
+func newXID() (uint32, error) {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint32(b), nil
+}
+
+// newMessage returns a request of type t from the client
+func (l *lease) newMessage(t byte, xid uint32) *message {
+	return &message{
+		op:     opRequest,
+		xid:    xid,
+		flags:  flagBroadcast,
+		chaddr: l.hwAddr,
+		options: map[byte][]byte{
+			optMessageType:  {t},
+			optClientID:     l.clientID,
+			optParamRequest: {optSubnetMask, optRouter, optLeaseTime, optRenewalTime, optRebindingTime},
+		},
+	}
+}
+
+// exchange broadcasts req and returns the first reply to it of one of the
+// given types, sending req again when none arrives in time
+func (l *lease) exchange(req *message, types ...byte) (*message, error) {
+	dst := &net.UDPAddr{IP: net.IPv4bcast, Port: serverPort}
+	buf := make([]byte, 1500)
+	for i := 0; i < maxAttempts; i++ {
+		if _, err := l.conn.WriteTo(req.marshal(), dst); err != nil {
+			return nil, fmt.Errorf("error sending DHCP message: %v", err)
+		}
+		deadline := time.Now().Add(replyTimeout)
+		if err := l.conn.SetReadDeadline(deadline); err != nil {
+			return nil, err
+		}
+		for {
+			n, _, err := l.conn.ReadFrom(buf)
+			if nerr, ok := err.(net.Error); ok && nerr.Timeout() {
+				break
+			}
+			if err != nil {
+				return nil, fmt.Errorf("error receiving DHCP message: %v", err)
+			}
+			m, err := parseMessage(buf[:n])
+			if err != nil || m.op != opReply || m.xid != req.xid {
+				continue
+			}
+			for _, t := range types {
+				if m.msgType() == t {
+					return m, nil
+				}
+			}
+		}
+	}
+	return nil, fmt.Errorf("no reply from a DHCP server on %q", l.ifName)
+}
+
+// discover acquires a new lease
+func (l *lease) discover() error {
+	xid, err := newXID()
+	if err != nil {
+		return err
+	}
+	offer, err := l.exchange(l.newMessage(msgDiscover, xid), msgOffer)
+	if err != nil {
+		return err
+	}
+
+	req := l.newMessage(msgRequest, xid)
+	req.options[optRequestedIP] = offer.yiaddr.To4()
+	req.options[optServerID] = offer.options[optServerID]
+	ack, err := l.exchange(req, msgAck, msgNak)
+	if err != nil {
+		return err
+	}
+	if ack.msgType() == msgNak {
+		return fmt.Errorf("DHCP server declined the offered address %v", offer.yiaddr)
+	}
+	l.update(ack)
+	return nil
+}
+
+// renew extends the lease. The request is broadcast, as when rebinding, so
+// any server may extend it if the one which granted it doesn't answer.
+func (l *lease) renew() error {
+	xid, err := newXID()
+	if err != nil {
+		return err
+	}
+	req := l.newMessage(msgRequest, xid)
+	req.ciaddr = l.ip.IP
+	ack, err := l.exchange(req, msgAck, msgNak)
+	if err != nil {
+		return err
+	}
+	if ack.msgType() == msgNak {
+		return fmt.Errorf("DHCP server refused to renew the lease of %v", l.ip.IP)
+	}
+	l.update(ack)
+	return nil
+}
+
+// update records the lease granted by ack
+func (l *lease) update(ack *message) {
+	now := time.Now()
+	d := ack.durationOption(optLeaseTime, time.Hour)
+	l.ip = &net.IPNet{IP: ack.yiaddr.To4(), Mask: ack.netmask()}
+	l.gateway = ack.ipOption(optRouter)
+	l.serverID = ack.ipOption(optServerID)
+	l.t1 = now.Add(ack.durationOption(optRenewalTime, d/2))
+	l.expiry = now.Add(d)
+}
+
+// maintain renews the lease at the renewal time, retrying until it expires
+func (l *lease) maintain() {
+	defer l.done.Done()
+	next := l.t1
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-time.After(next.Sub(time.Now())):
+		}
+
+		err := l.renew()
+		switch {
+		case err == nil:
+			next = l.t1
+		case time.Now().After(l.expiry):
+			log.Printf("Lease of %v on %q expired: %v", l.ip.IP, l.ifName, err)
+			return
+		default:
+			log.Printf("Failed to renew lease of %v on %q, retrying: %v", l.ip.IP, l.ifName, err)
+			next = time.Now().Add(retryInterval)
+		}
+	}
+}
+
+// release stops renewing the lease and gives the address back to the server
+func (l *lease) release() {
+	close(l.stop)
+	l.done.Wait()
+	defer l.conn.Close()
+
+	if time.Now().After(l.expiry) {
+		return
+	}
+	xid, err := newXID()
+	if err != nil {
+		return
+	}
+	m := l.newMessage(msgRelease, xid)
+	m.flags = 0
+	m.ciaddr = l.ip.IP
+	delete(m.options, optParamRequest)
+	if l.serverID != nil {
+		m.options[optServerID] = l.serverID.To4()
+	}
+	// the interface may already be gone with its container, releasing is
+	// best effort
+	dst := &net.UDPAddr{IP: l.serverID, Port: serverPort}
+	if l.serverID == nil {
+		dst.IP = net.IPv4bcast
+	}
+	if _, err := l.conn.WriteTo(m.marshal(), dst); err != nil {
+		log.Printf("Failed to release lease of %v on %q: %v", l.ip.IP, l.ifName, err)
+	}
+}
@@ -0,0 +1,207 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package dhcp acquires addresses for container interfaces from the DHCP
+// server of their network. Leases have to be renewed for as long as the
+// container runs, which outlives the network plugin, so they are held by a
+// daemon (rkt-dhcp) the plugins talk to through a unix socket.
+package dhcp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"time"
+)
+
+// BOOTP operations
+const (
+	opRequest = 1
+	opReply   = 2
+)
+
+// DHCP message types (option 53)
+const (
+	msgDiscover = 1
+	msgOffer    = 2
+	msgRequest  = 3
+	msgDecline  = 4
+	msgAck      = 5
+	msgNak      = 6
+	msgRelease  = 7
+)
+
+// DHCP options used by the client
+const (
+	optPad           = 0
+	optSubnetMask    = 1
+	optRouter        = 3
+	optRequestedIP   = 50
+	optLeaseTime     = 51
+	optMessageType   = 53
+	optServerID      = 54
+	optParamRequest  = 55
+	optRenewalTime   = 58
+	optRebindingTime = 59
+	optClientID      = 61
+	optEnd           = 255
+)
+
+const (
+	// flagBroadcast asks the server to broadcast its replies, the
+	// interface has no address to receive them on before the lease is
+	// acquired
+	flagBroadcast = 0x8000
+
+	headerLen = 236
+)
+
+var magicCookie = []byte{99, 130, 83, 99}
+
+// message is a DHCP message, see RFC 2131. Fields the client doesn't need
+// (hops, sname, file) are left zero.
+type message struct {
+	op      byte
+	xid     uint32
+	secs    uint16
+	flags   uint16
+	ciaddr  net.IP
+	yiaddr  net.IP
+	siaddr  net.IP
+	giaddr  net.IP
+	chaddr  net.HardwareAddr
+	options map[byte][]byte
+}
+
+func putIP(b []byte, ip net.IP) {
+	if ip4 := ip.To4(); ip4 != nil {
+		copy(b, ip4)
+	}
+}
+
+// marshal encodes m in the wire format. Options are written in increasing
+// order of their code.
+func (m *message) marshal() []byte {
+	b := make([]byte, headerLen, headerLen+len(magicCookie)+64)
+	b[0] = m.op
+	b[1] = 1 // htype: ethernet
+	b[2] = byte(len(m.chaddr))
+	binary.BigEndian.PutUint32(b[4:8], m.xid)
+	binary.BigEndian.PutUint16(b[8:10], m.secs)
+	binary.BigEndian.PutUint16(b[10:12], m.flags)
+	putIP(b[12:16], m.ciaddr)
+	putIP(b[16:20], m.yiaddr)
+	putIP(b[20:24], m.siaddr)
+	putIP(b[24:28], m.giaddr)
+	copy(b[28:44], m.chaddr)
+
+	b = append(b, magicCookie...)
+	codes := make([]int, 0, len(m.options))
+	for c := range m.options {
+		codes = append(codes, int(c))
+	}
+	sort.Ints(codes)
+	for _, c := range codes {
+		v := m.options[byte(c)]
+		b = append(b, byte(c), byte(len(v)))
+		b = append(b, v...)
+	}
+	return append(b, optEnd)
+}
+
+// parseMessage decodes a DHCP message from the wire format
+func parseMessage(b []byte) (*message, error) {
+	if len(b) < headerLen+len(magicCookie) {
+		return nil, errors.New("message too short")
+	}
+	for i, c := range magicCookie {
+		if b[headerLen+i] != c {
+			return nil, errors.New("invalid magic cookie")
+		}
+	}
+	hlen := int(b[2])
+	if hlen > 16 {
+		return nil, fmt.Errorf("invalid hardware address length %d", hlen)
+	}
+
+	m := &message{
+		op:      b[0],
+		xid:     binary.BigEndian.Uint32(b[4:8]),
+		secs:    binary.BigEndian.Uint16(b[8:10]),
+		flags:   binary.BigEndian.Uint16(b[10:12]),
+		ciaddr:  net.IP(append([]byte(nil), b[12:16]...)),
+		yiaddr:  net.IP(append([]byte(nil), b[16:20]...)),
+		siaddr:  net.IP(append([]byte(nil), b[20:24]...)),
+		giaddr:  net.IP(append([]byte(nil), b[24:28]...)),
+		chaddr:  net.HardwareAddr(append([]byte(nil), b[28:28+hlen]...)),
+		options: make(map[byte][]byte),
+	}
+
+	opts := b[headerLen+len(magicCookie):]
+	for len(opts) > 0 {
+		c := opts[0]
+		if c == optEnd {
+			break
+		}
+		if c == optPad {
+			opts = opts[1:]
+			continue
+		}
+		if len(opts) < 2 || len(opts) < 2+int(opts[1]) {
+			return nil, fmt.Errorf("option %d truncated", c)
+		}
+		n := int(opts[1])
+		// options given more than once are concatenated, see RFC 3396
+		m.options[c] = append(m.options[c], opts[2:2+n]...)
+		opts = opts[2+n:]
+	}
+	return m, nil
+}
+
+// msgType returns the DHCP message type of m, 0 if it has none
+func (m *message) msgType() byte {
+	if v := m.options[optMessageType]; len(v) == 1 {
+		return v[0]
+	}
+	return 0
+}
+
+// ipOption returns the first address held by option c, nil if m doesn't
+// have it
+func (m *message) ipOption(c byte) net.IP {
+	if v := m.options[c]; len(v) >= 4 {
+		return net.IPv4(v[0], v[1], v[2], v[3])
+	}
+	return nil
+}
+
+// durationOption returns the duration, in seconds on the wire, held by
+// option c, or def if m doesn't have it
+func (m *message) durationOption(c byte, def time.Duration) time.Duration {
+	if v := m.options[c]; len(v) == 4 {
+		return time.Duration(binary.BigEndian.Uint32(v)) * time.Second
+	}
+	return def
+}
+
+// netmask returns the subnet mask of the offered address, the default mask
+// of its class if the server didn't send one
+func (m *message) netmask() net.IPMask {
+	if v := m.options[optSubnetMask]; len(v) == 4 {
+		return net.IPMask(append([]byte(nil), v...))
+	}
+	return m.yiaddr.DefaultMask()
+}
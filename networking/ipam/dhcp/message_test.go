@@ -0,0 +1,87 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dhcp
+
+import (
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMessage(t *testing.T) {
+	hw, _ := net.ParseMAC("02:42:ac:11:00:02")
+	m := &message{
+		op:     opReply,
+		xid:    0xdeadbeef,
+		flags:  flagBroadcast,
+		ciaddr: net.IPv4zero.To4(),
+		yiaddr: net.IPv4(10, 1, 2, 3).To4(),
+		siaddr: net.IPv4zero.To4(),
+		giaddr: net.IPv4zero.To4(),
+		chaddr: hw,
+		options: map[byte][]byte{
+			optMessageType: {msgAck},
+			optSubnetMask:  {255, 255, 255, 0},
+			optRouter:      {10, 1, 2, 1, 10, 1, 2, 254},
+			optServerID:    {10, 1, 2, 1},
+			optLeaseTime:   {0, 0, 0x0e, 0x10},
+		},
+	}
+
+	got, err := parseMessage(m.marshal())
+	if err != nil {
+		t.Fatalf("error parsing message: %v", err)
+	}
+	if !reflect.DeepEqual(got, m) {
+		t.Errorf("got %+v, want %+v", got, m)
+	}
+
+	if got.msgType() != msgAck {
+		t.Errorf("got message type %d, want %d", got.msgType(), msgAck)
+	}
+	if gw := got.ipOption(optRouter); !gw.Equal(net.IPv4(10, 1, 2, 1)) {
+		t.Errorf("got router %v, want 10.1.2.1", gw)
+	}
+	if mask := got.netmask(); mask.String() != "ffffff00" {
+		t.Errorf("got netmask %v, want ffffff00", mask)
+	}
+	if d := got.durationOption(optLeaseTime, 0); d != time.Hour {
+		t.Errorf("got lease time %v, want 1h", d)
+	}
+	if d := got.durationOption(optRenewalTime, time.Minute); d != time.Minute {
+		t.Errorf("got renewal time %v, want the default 1m", d)
+	}
+}
+
+func TestParseMessageErrors(t *testing.T) {
+	b := (&message{op: opReply}).marshal()
+
+	if _, err := parseMessage(b[:headerLen]); err == nil {
+		t.Errorf("expected error parsing truncated message")
+	}
+
+	bad := append([]byte(nil), b...)
+	bad[headerLen] = 0
+	if _, err := parseMessage(bad); err == nil {
+		t.Errorf("expected error parsing message without magic cookie")
+	}
+
+	// an option longer than the message
+	trunc := append(append([]byte(nil), b[:len(b)-1]...), optRouter, 8, 10, 1)
+	if _, err := parseMessage(trunc); err == nil {
+		t.Errorf("expected error parsing truncated option")
+	}
+}
@@ -0,0 +1,388 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ipam
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/coreos/rocket/networking/util"
+)
+
+// HostLocalDir holds the addresses allocated by the host-local IP
+// allocation: a directory per network, with a file per allocated address
+// holding the ID of the container it is allocated to.
+var HostLocalDir = "/var/lib/rkt/networks"
+
+// lastReservedFile remembers, in the directory of a network, the last
+// allocated address, so that addresses are handed out in turn rather than
+// reused as soon as they are released
+const lastReservedFile = "last_reserved_ip"
+
+// hostLocal allocates addresses of a network from the range start-end of
+// its subnet
+type hostLocal struct {
+	dir     string
+	subnet  *net.IPNet
+	start   uint32
+	end     uint32
+	gateway net.IP
+	exclude map[uint32]bool
+}
+
+func ipToUint32(ip net.IP) uint32 {
+	return binary.BigEndian.Uint32(ip.To4())
+}
+
+func uint32ToIP(n uint32) net.IP {
+	ip := make(net.IP, 4)
+	binary.BigEndian.PutUint32(ip, n)
+	return ip
+}
+
+func newHostLocal(n *util.Net) (*hostLocal, error) {
+	if n.Name == "" {
+		return nil, fmt.Errorf("host-local IP allocation needs a network name")
+	}
+	_, subnet, err := net.ParseCIDR(n.IPAlloc.Subnet)
+	if err != nil {
+		return nil, fmt.Errorf("ipAlloc.subnet: %v", err)
+	}
+	if subnet.IP.To4() == nil {
+		return nil, fmt.Errorf("ipAlloc.subnet: only IPv4 subnets are supported")
+	}
+
+	// the network and broadcast addresses are not allocated, except in
+	// subnets too small to have them
+	ones, bits := subnet.Mask.Size()
+	first := ipToUint32(subnet.IP)
+	last := first | (1<<uint(bits-ones) - 1)
+	h := &hostLocal{
+		dir:     filepath.Join(HostLocalDir, n.Name),
+		subnet:  subnet,
+		start:   first,
+		end:     last,
+		exclude: make(map[uint32]bool),
+	}
+	if ones < 31 {
+		h.start, h.end = first+1, last-1
+	}
+
+	parse := func(field, s string) (uint32, error) {
+		ip := net.ParseIP(s)
+		if ip == nil || ip.To4() == nil {
+			return 0, fmt.Errorf("ipAlloc.%s: invalid address %q", field, s)
+		}
+		if !subnet.Contains(ip) {
+			return 0, fmt.Errorf("ipAlloc.%s: %v is not in %v", field, ip, subnet)
+		}
+		return ipToUint32(ip), nil
+	}
+	if s := n.IPAlloc.RangeStart; s != "" {
+		if h.start, err = parse("rangeStart", s); err != nil {
+			return nil, err
+		}
+	}
+	if s := n.IPAlloc.RangeEnd; s != "" {
+		if h.end, err = parse("rangeEnd", s); err != nil {
+			return nil, err
+		}
+	}
+	if h.start > h.end {
+		return nil, fmt.Errorf("ipAlloc: rangeStart %v is after rangeEnd %v", uint32ToIP(h.start), uint32ToIP(h.end))
+	}
+
+	// the gateway defaults to the first address of the subnet, as with
+	// the static allocation
+	gw := first + 1
+	if s := n.IPAlloc.Gateway; s != "" {
+		if gw, err = parse("gateway", s); err != nil {
+			return nil, err
+		}
+	}
+	h.gateway = uint32ToIP(gw)
+	h.exclude[gw] = true
+	for _, s := range n.IPAlloc.Exclude {
+		ip, err := parse("exclude", s)
+		if err != nil {
+			return nil, err
+		}
+		h.exclude[ip] = true
+	}
+	return h, nil
+}
+
+// lastReserved returns the last address allocated in the range, or the
+// one before the range if none is known
+func (h *hostLocal) lastReserved() uint32 {
+	b, err := ioutil.ReadFile(filepath.Join(h.dir, lastReservedFile))
+	if err == nil {
+		if ip := net.ParseIP(strings.TrimSpace(string(b))); ip != nil && ip.To4() != nil {
+			if n := ipToUint32(ip); n >= h.start && n <= h.end {
+				return n
+			}
+		}
+	}
+	return h.start - 1
+}
+
+// reserve records the addresses as allocated to contID, all of them or
+// none. It returns false if one of them is already allocated.
+func (h *hostLocal) reserve(ips []uint32, contID string) (bool, error) {
+	nips := make([]net.IP, len(ips))
+	for i, n := range ips {
+		nips[i] = uint32ToIP(n)
+	}
+	return reserveIPs(h.dir, nips, contID)
+}
+
+// reserveIPs records, in the directory dir of a network, the addresses as
+// allocated to contID, all of them or none. It returns false if one of them
+// is already allocated.
+func reserveIPs(dir string, ips []net.IP, contID string) (bool, error) {
+	for i, ip := range ips {
+		p := filepath.Join(dir, ip.String())
+		f, err := os.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err == nil {
+			_, err = f.WriteString(contID)
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+			if err == nil {
+				continue
+			}
+			os.Remove(p)
+		}
+		for _, r := range ips[:i] {
+			os.Remove(filepath.Join(dir, r.String()))
+		}
+		if os.IsExist(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("error reserving %v: %v", ip, err)
+	}
+	return true, nil
+}
+
+// allocatedError tells which of ips, that couldn't be reserved in dir, is
+// already allocated and to which container
+func allocatedError(dir string, ips []net.IP) error {
+	for _, ip := range ips {
+		b, err := ioutil.ReadFile(filepath.Join(dir, ip.String()))
+		if err == nil {
+			return fmt.Errorf("IP %v is already allocated to container %s", ip, strings.TrimSpace(string(b)))
+		}
+	}
+	return fmt.Errorf("IP %v is already allocated", ips[0])
+}
+
+// alloc allocates size consecutive addresses, aligned on size, to contID
+// and returns the first one. Addresses are handed out in turn, starting
+// after the last allocated one.
+func (h *hostLocal) alloc(contID string, size uint32) (net.IP, error) {
+	if err := os.MkdirAll(h.dir, 0755); err != nil {
+		return nil, fmt.Errorf("error creating %s: %v", h.dir, err)
+	}
+
+	count := h.end - h.start + 1
+	last := h.lastReserved()
+	for i := uint32(1); i <= count; i++ {
+		n := h.start + (last-h.start+i)%count
+		if n%size != 0 || n+size-1 > h.end {
+			continue
+		}
+		ips := make([]uint32, 0, size)
+		for j := uint32(0); j < size; j++ {
+			if h.exclude[n+j] {
+				break
+			}
+			ips = append(ips, n+j)
+		}
+		if uint32(len(ips)) != size {
+			continue
+		}
+
+		ok, err := h.reserve(ips, contID)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		ip := uint32ToIP(ips[size-1])
+		if err := ioutil.WriteFile(filepath.Join(h.dir, lastReservedFile), []byte(ip.String()), 0644); err != nil {
+			return nil, fmt.Errorf("error recording last reserved IP: %v", err)
+		}
+		return uint32ToIP(n), nil
+	}
+	return nil, fmt.Errorf("no IP addresses available in range %v-%v", uint32ToIP(h.start), uint32ToIP(h.end))
+}
+
+// allocAt allocates the size consecutive addresses starting at ip, which
+// must be aligned on size, to contID. The addresses may be out of the range
+// handed out by alloc, to keep them for containers asking for them, but
+// must be in the subnet and not excluded. It fails if one of them is
+// already allocated.
+func (h *hostLocal) allocAt(contID string, ip net.IP, size uint32) error {
+	if ip.To4() == nil || !h.subnet.Contains(ip) {
+		return fmt.Errorf("IP %v is not in %v", ip, h.subnet)
+	}
+	n := ipToUint32(ip)
+	if n%size != 0 {
+		return fmt.Errorf("IP %v is not aligned on %d addresses", ip, size)
+	}
+	ones, bits := h.subnet.Mask.Size()
+	first := ipToUint32(h.subnet.IP)
+	last := first | (1<<uint(bits-ones) - 1)
+	ips := make([]net.IP, size)
+	for i := range ips {
+		m := n + uint32(i)
+		ips[i] = uint32ToIP(m)
+		if m > last || (ones < 31 && (m == first || m == last)) {
+			return fmt.Errorf("IP %v is not usable in %v", ips[i], h.subnet)
+		}
+		if h.exclude[m] {
+			return fmt.Errorf("IP %v is excluded from allocation", ips[i])
+		}
+	}
+
+	if err := os.MkdirAll(h.dir, 0755); err != nil {
+		return fmt.Errorf("error creating %s: %v", h.dir, err)
+	}
+	ok, err := reserveIPs(h.dir, ips, contID)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return allocatedError(h.dir, ips)
+	}
+	return nil
+}
+
+// release frees all the addresses allocated to contID.
+func (h *hostLocal) release(contID string) error {
+	files, err := ioutil.ReadDir(h.dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		if net.ParseIP(f.Name()) == nil {
+			continue
+		}
+		p := filepath.Join(h.dir, f.Name())
+		b, err := ioutil.ReadFile(p)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return err
+		}
+		if strings.TrimSpace(string(b)) == contID {
+			if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("error releasing %s: %v", f.Name(), err)
+			}
+		}
+	}
+	return nil
+}
+
+// Lease is an address allocated host-local to a container.
+type Lease struct {
+	Net    string
+	IP     net.IP
+	ContID string
+	// Time is when the address was allocated
+	Time time.Time
+}
+
+// ReleaseOrphans frees the addresses allocated host-local, in all networks,
+// to containers inUse says are gone, e.g. because their directory was
+// removed by hand and they were never torn down, and returns them.
+func ReleaseOrphans(inUse func(l Lease) bool) ([]Lease, error) {
+	nets, err := ioutil.ReadDir(HostLocalDir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error listing networks: %v", err)
+	}
+	var released []Lease
+	for _, n := range nets {
+		if !n.IsDir() {
+			continue
+		}
+		dir := filepath.Join(HostLocalDir, n.Name())
+		files, err := ioutil.ReadDir(dir)
+		if err != nil {
+			return released, fmt.Errorf("error listing addresses of %q: %v", n.Name(), err)
+		}
+		for _, f := range files {
+			ip := net.ParseIP(f.Name())
+			if ip == nil {
+				continue
+			}
+			p := filepath.Join(dir, f.Name())
+			b, err := ioutil.ReadFile(p)
+			if os.IsNotExist(err) {
+				continue
+			}
+			if err != nil {
+				return released, err
+			}
+			l := Lease{
+				Net:    n.Name(),
+				IP:     ip,
+				ContID: strings.TrimSpace(string(b)),
+				Time:   f.ModTime(),
+			}
+			if inUse(l) {
+				continue
+			}
+			if err := releaseLease(p, l.ContID); err != nil {
+				return released, fmt.Errorf("error releasing %v: %v", ip, err)
+			}
+			released = append(released, l)
+		}
+	}
+	return released, nil
+}
+
+// releaseLease removes the allocation file p, unless the address it records
+// was released and allocated to another container than contID meanwhile.
+func releaseLease(p, contID string) error {
+	b, err := ioutil.ReadFile(p)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(string(b)) != contID {
+		return nil
+	}
+	if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
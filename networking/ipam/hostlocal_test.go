@@ -0,0 +1,205 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ipam
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/coreos/rocket/networking/util"
+)
+
+func testNet(name, subnet, start, end string, exclude ...string) *util.Net {
+	n := &util.Net{Name: name}
+	n.IPAlloc.Type = "host-local"
+	n.IPAlloc.Subnet = subnet
+	n.IPAlloc.RangeStart = start
+	n.IPAlloc.RangeEnd = end
+	n.IPAlloc.Exclude = exclude
+	return n
+}
+
+func TestHostLocal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hostlocal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	HostLocalDir = dir
+
+	h, err := newHostLocal(testNet("test", "10.0.0.0/29", "10.0.0.2", "10.0.0.6", "10.0.0.4"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if h.gateway.String() != "10.0.0.1" {
+		t.Errorf("got gateway %v, want 10.0.0.1", h.gateway)
+	}
+
+	alloc := func(id, want string) {
+		ip, err := h.alloc(id, 1)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", id, err)
+		}
+		if ip.String() != want {
+			t.Errorf("%s: got %v, want %s", id, ip, want)
+		}
+	}
+	alloc("c1", "10.0.0.2")
+	alloc("c2", "10.0.0.3")
+	alloc("c3", "10.0.0.5")
+	alloc("c4", "10.0.0.6")
+	if ip, err := h.alloc("c5", 1); err == nil {
+		t.Errorf("expected error allocating in a full range, got %v", ip)
+	}
+
+	if err := h.release("c2"); err != nil {
+		t.Fatalf("unexpected error releasing: %v", err)
+	}
+	alloc("c5", "10.0.0.3")
+	if err := h.release("unknown"); err != nil {
+		t.Errorf("unexpected error releasing unknown container: %v", err)
+	}
+
+	// a /31 pair is aligned, and skips the gateway
+	p, err := newHostLocal(testNet("ptp", "10.0.1.0/28", "", ""))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ip, err := p.alloc("c1", 2)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ip.String() != "10.0.1.2" {
+		t.Errorf("got pair starting at %v, want 10.0.1.2", ip)
+	}
+	if ip, err = p.alloc("c2", 2); err != nil || ip.String() != "10.0.1.4" {
+		t.Errorf("got pair starting at %v (err %v), want 10.0.1.4", ip, err)
+	}
+
+	// specific addresses may be out of the range but not conflict with
+	// allocated or excluded ones
+	if err := h.allocAt("c6", net.ParseIP("10.0.0.7"), 1); err == nil {
+		t.Errorf("expected error allocating the broadcast address")
+	}
+	if err := h.allocAt("c6", net.ParseIP("10.0.0.4"), 1); err == nil {
+		t.Errorf("expected error allocating an excluded address")
+	}
+	if err := h.allocAt("c6", net.ParseIP("10.0.1.2"), 1); err == nil {
+		t.Errorf("expected error allocating out of the subnet")
+	}
+	err = h.allocAt("c6", net.ParseIP("10.0.0.5"), 1)
+	if err == nil || !strings.Contains(err.Error(), "container c3") {
+		t.Errorf("got %v, want conflict with c3", err)
+	}
+	if err := h.release("c3"); err != nil {
+		t.Fatalf("unexpected error releasing: %v", err)
+	}
+	if err := h.allocAt("c6", net.ParseIP("10.0.0.5"), 1); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := p.allocAt("c3", net.ParseIP("10.0.1.9"), 2); err == nil {
+		t.Errorf("expected error allocating an unaligned pair")
+	}
+	if err := p.allocAt("c3", net.ParseIP("10.0.1.4"), 2); err == nil {
+		t.Errorf("expected error allocating an allocated pair")
+	}
+	if err := p.allocAt("c3", net.ParseIP("10.0.1.12"), 2); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestReleaseOrphans(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hostlocal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	HostLocalDir = dir
+
+	if l, err := ReleaseOrphans(func(Lease) bool { return false }); err != nil || len(l) != 0 {
+		t.Errorf("got %v, %v, want nothing released without allocations", l, err)
+	}
+
+	h, err := newHostLocal(testNet("test", "10.0.0.0/29", "", ""))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	p, err := newHostLocal(testNet("ptp", "10.0.1.0/28", "", ""))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, id := range []string{"c1", "c2"} {
+		if _, err := h.alloc(id, 1); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if _, err := p.alloc("c2", 2); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var seen int
+	released, err := ReleaseOrphans(func(l Lease) bool {
+		seen++
+		return l.ContID == "c1"
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if seen != 4 {
+		t.Errorf("got %d leases checked, want 4", seen)
+	}
+	var got []string
+	for _, l := range released {
+		if l.ContID != "c2" {
+			t.Errorf("released %+v, in use", l)
+		}
+		got = append(got, l.Net+"/"+l.IP.String())
+	}
+	want := []string{"ptp/10.0.1.2", "ptp/10.0.1.3", "test/10.0.0.3"}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("got %v released, want %v", got, want)
+	}
+
+	// the last reserved addresses are still remembered, c2's are reused
+	// once the range wraps around
+	if ip, err := h.alloc("c3", 1); err != nil || ip.String() != "10.0.0.4" {
+		t.Errorf("got %v, %v, want 10.0.0.4", ip, err)
+	}
+	if err := h.allocAt("c4", net.ParseIP("10.0.0.3"), 1); err != nil {
+		t.Errorf("unexpected error reallocating a released address: %v", err)
+	}
+	if err := h.allocAt("c4", net.ParseIP("10.0.0.2"), 1); err == nil {
+		t.Errorf("expected error allocating an address in use")
+	}
+}
+
+func TestHostLocalConf(t *testing.T) {
+	bad := []*util.Net{
+		testNet("", "10.0.0.0/24", "", ""),
+		testNet("test", "10.0.0.0", "", ""),
+		testNet("test", "10.0.0.0/24", "10.0.1.2", ""),
+		testNet("test", "10.0.0.0/24", "10.0.0.20", "10.0.0.10"),
+		testNet("test", "10.0.0.0/24", "", "", "bogus"),
+		testNet("test", "fd00::/64", "", ""),
+	}
+	for i, n := range bad {
+		if _, err := newHostLocal(n); err == nil {
+			t.Errorf("#%d: expected error for %+v", i, n.IPAlloc)
+		}
+	}
+}
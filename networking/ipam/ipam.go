@@ -27,12 +27,14 @@ import (
 
 	"github.com/appc/spec/schema/types"
 
+	"github.com/coreos/rocket/networking/ipam/dhcp"
 	"github.com/coreos/rocket/networking/util"
 )
 
 type options struct {
 	ipRange *net.IPNet
 	ip      net.IP
+	ip6     net.IP
 }
 
 func ipAdd(ip net.IP, val uint) net.IP {
@@ -75,15 +77,19 @@ func splitArg(arg string) (k, v string) {
 	return
 }
 
+// parseArgs parses the KEY=VALUE arguments, separated by commas or
+// semicolons, given to the plugin. Keys are case-insensitive.
 func parseArgs(args string) (*options, error) {
-	argv := strings.Split(args, ",")
+	argv := strings.FieldsFunc(args, func(r rune) bool {
+		return r == ',' || r == ';'
+	})
 
 	var err error
 	opts := &options{}
 
 	for _, arg := range argv {
 		k, v := splitArg(arg)
-		switch k {
+		switch strings.ToLower(k) {
 		case "iprange":
 			opts.ipRange, err = util.ParseCIDR(v)
 			if err != nil {
@@ -92,26 +98,38 @@ func parseArgs(args string) (*options, error) {
 
 		case "ip":
 			opts.ip = net.ParseIP(v)
-			if opts.ip == nil {
+			if opts.ip == nil || opts.ip.To4() == nil {
 				return nil, fmt.Errorf("failed to parse ip arg (%q)", v)
 			}
+
+		case "ip6":
+			opts.ip6 = net.ParseIP(v)
+			if opts.ip6 == nil || opts.ip6.To4() != nil {
+				return nil, fmt.Errorf("failed to parse ip6 arg (%q)", v)
+			}
 		}
 	}
 
 	return opts, nil
 }
 
-// AllocIP allocates an IP in a given range.
-func AllocIP(contID types.UUID, netConf, ifName, args string) (*net.IPNet, net.IP, error) {
+// AllocIP allocates an IP in a given range. An "ip" argument requests a
+// specific IP, which host-local allocations check isn't allocated already.
+func AllocIP(contID types.UUID, netConf []byte, ifName, args string) (*net.IPNet, net.IP, error) {
 	opts, err := parseArgs(args)
 	if err != nil {
 		return nil, nil, err
 	}
 
 	if opts.ipRange != nil {
-		ip, err := allocIP(opts.ipRange)
-		if err != nil {
-			return nil, nil, fmt.Errorf("error allocating IP in %v: %v", opts.ipRange, err)
+		ip := opts.ip
+		if ip != nil && !opts.ipRange.Contains(ip) {
+			return nil, nil, fmt.Errorf("requested IP %v is not in %v", ip, opts.ipRange)
+		}
+		if ip == nil {
+			if ip, err = allocIP(opts.ipRange); err != nil {
+				return nil, nil, fmt.Errorf("error allocating IP in %v: %v", opts.ipRange, err)
+			}
 		}
 
 		return &net.IPNet{
@@ -121,7 +139,7 @@ func AllocIP(contID types.UUID, netConf, ifName, args string) (*net.IPNet, net.I
 	}
 
 	n := util.Net{}
-	if err := util.LoadNet(netConf, &n); err != nil {
+	if err := util.ParseNet(netConf, &n); err != nil {
 		return nil, nil, err
 	}
 
@@ -130,40 +148,167 @@ func AllocIP(contID types.UUID, netConf, ifName, args string) (*net.IPNet, net.I
 		_, rng, err := net.ParseCIDR(n.IPAlloc.Subnet)
 		if err != nil {
 			// TODO: cleanup
-			return nil, nil, fmt.Errorf("error parsing %q conf: ipAlloc.Subnet: %v", netConf, err)
+			return nil, nil, fmt.Errorf("error parsing %q conf: ipAlloc.Subnet: %v", n.Name, err)
 		}
 
-		ip, err := allocIP(rng)
-		if err != nil {
-			// TODO: cleanup
-			return nil, nil, fmt.Errorf("error allocating IP in %v: %v", rng, err)
+		// the gateway defaults to the first address of the subnet
+		gw := ipAdd(rng.IP, 1)
+		if n.IPAlloc.Gateway != "" {
+			if gw = net.ParseIP(n.IPAlloc.Gateway); gw == nil {
+				return nil, nil, fmt.Errorf("error parsing %q conf: invalid ipAlloc.Gateway %q", n.Name, n.IPAlloc.Gateway)
+			}
+		}
+
+		if ip := opts.ip; ip != nil {
+			if !rng.Contains(ip) {
+				return nil, nil, fmt.Errorf("requested IP %v is not in %v", ip, rng)
+			}
+			if ip.Equal(gw) {
+				return nil, nil, fmt.Errorf("requested IP %v is the gateway of %v", ip, rng)
+			}
+			return &net.IPNet{
+				IP:   ip,
+				Mask: rng.Mask,
+			}, gw, nil
+		}
+
+		var ip net.IP
+		for ip == nil || ip.Equal(gw) {
+			ip, err = allocIP(rng)
+			if err != nil {
+				// TODO: cleanup
+				return nil, nil, fmt.Errorf("error allocating IP in %v: %v", rng, err)
+			}
 		}
 
 		return &net.IPNet{
 			IP:   ip,
 			Mask: rng.Mask,
-		}, nil, nil
+		}, gw, nil
+
+	case "host-local":
+		h, err := newHostLocal(&n)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error parsing %q conf: %v", n.Name, err)
+		}
+		ip := opts.ip
+		if ip != nil {
+			if err := h.allocAt(contID.String(), ip, 1); err != nil {
+				return nil, nil, fmt.Errorf("error allocating requested IP: %v", err)
+			}
+		} else if ip, err = h.alloc(contID.String(), 1); err != nil {
+			return nil, nil, fmt.Errorf("error allocating IP in %v: %v", h.subnet, err)
+		}
+
+		return &net.IPNet{
+			IP:   ip,
+			Mask: h.subnet.Mask,
+		}, h.gateway, nil
+
+	case "dhcp":
+		return nil, nil, fmt.Errorf("error loading %q conf: the dhcp IP allocation needs the container interface set up first, it is only supported by the macvlan and ipvlan plugins", n.Name)
 
 	default:
 		return nil, nil, fmt.Errorf("unsupported IP allocation type")
 	}
 }
 
-// AllocPtP allocates a /31 for point-to-point links.
-func AllocPtP(contID types.UUID, netConf, ifName, args string) ([2]net.IP, error) {
+// AllocIPOnLink allocates an IP for the interface ifName, already set up
+// and up in the netns at netns. Unlike AllocIP, it supports getting the IP
+// from the network's DHCP server.
+func AllocIPOnLink(contID types.UUID, netns string, netConf []byte, ifName, args string) (*net.IPNet, net.IP, error) {
+	opts, err := parseArgs(args)
+	if err != nil {
+		return nil, nil, err
+	}
+	if opts.ipRange != nil {
+		return AllocIP(contID, netConf, ifName, args)
+	}
+
+	n := util.Net{}
+	if err := util.ParseNet(netConf, &n); err != nil {
+		return nil, nil, err
+	}
+	if n.IPAlloc.Type != "dhcp" {
+		return AllocIP(contID, netConf, ifName, args)
+	}
+	if opts.ip != nil {
+		return nil, nil, fmt.Errorf("error loading %q conf: a specific IP can't be requested from DHCP", n.Name)
+	}
+
+	ipn, gw, err := dhcp.Allocate(contID.String(), netns, ifName)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error getting IP from DHCP: %v", err)
+	}
+	return ipn, gw, nil
+}
+
+// AllocPtP allocates a /31 for point-to-point links. The second address
+// is the container's: an "ip" argument must be the second address of a /31.
+func AllocPtP(contID types.UUID, netConf []byte, ifName, args string) ([2]net.IP, error) {
+	opts, err := parseArgs(args)
+	if err != nil {
+		return [2]net.IP{nil, nil}, err
+	}
+	mask := net.CIDRMask(31, 32)
+	if opts.ip != nil && opts.ip.Equal(opts.ip.Mask(mask)) {
+		return [2]net.IP{nil, nil}, fmt.Errorf("requested IP %v is not the second address of a /31", opts.ip)
+	}
+
+	n := util.Net{}
+	if err := util.ParseNet(netConf, &n); err != nil {
+		return [2]net.IP{nil, nil}, err
+	}
+	if n.IPAlloc.Type == "host-local" {
+		// both addresses of the /31 are reserved
+		h, err := newHostLocal(&n)
+		if err != nil {
+			return [2]net.IP{nil, nil}, fmt.Errorf("error parsing %q conf: %v", n.Name, err)
+		}
+		first := opts.ip.Mask(mask)
+		if first != nil {
+			if err := h.allocAt(contID.String(), first, 2); err != nil {
+				return [2]net.IP{nil, nil}, fmt.Errorf("error allocating requested IP: %v", err)
+			}
+		} else if first, err = h.alloc(contID.String(), 2); err != nil {
+			return [2]net.IP{nil, nil}, fmt.Errorf("error allocating /31 in %v: %v", h.subnet, err)
+		}
+		return [2]net.IP{first, ipAdd(first, 1)}, nil
+	}
+
 	ipn, _, err := AllocIP(contID, netConf, ifName, args)
 	if err != nil {
 		return [2]net.IP{nil, nil}, err
 	}
 
-	mask := net.CIDRMask(31, 32)
 	first := ipn.IP.Mask(mask)
 	second := ipAdd(first, 1)
 
 	return [2]net.IP{first, second}, nil
 }
 
-// DeallocIP is a no-op.
-func DeallocIP(contID types.UUID, netConf, ifName string, ipn *net.IPNet) error {
+// DeallocIP releases the IPs, IPv4 and IPv6, of the interface ifName.
+// Only addresses allocated host-local or from DHCP leases need to be
+// released, it is a no-op for the others.
+func DeallocIP(contID types.UUID, netConf []byte, ifName string, ipn *net.IPNet) error {
+	n := util.Net{}
+	if err := util.ParseNet(netConf, &n); err != nil {
+		return err
+	}
+
+	switch n.IPAlloc.Type {
+	case "host-local":
+		h, err := newHostLocal(&n)
+		if err != nil {
+			return fmt.Errorf("error parsing %q conf: %v", n.Name, err)
+		}
+		if err := h.release(contID.String()); err != nil {
+			return fmt.Errorf("error releasing IP: %v", err)
+		}
+	case "dhcp":
+		if err := dhcp.Release(contID.String(), ifName); err != nil {
+			return fmt.Errorf("error releasing DHCP lease: %v", err)
+		}
+	}
 	return nil
 }
@@ -0,0 +1,254 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ipam
+
+import (
+	"crypto/rand"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+
+	"github.com/appc/spec/schema/types"
+
+	"github.com/coreos/rocket/networking/util"
+)
+
+// maxPrefix6 is the longest prefix of IPv6 subnets: their addresses are
+// picked at random, which needs room to rarely collide
+const maxPrefix6 = 120
+
+// allocTries6 is the number of random IPv6 addresses tried before giving up
+const allocTries6 = 16
+
+// net6 is the IPv6 part of a dual-stack network
+type net6 struct {
+	name    string
+	ipType  string
+	subnet  *net.IPNet
+	gateway net.IP
+}
+
+// loadNet6 returns the IPv6 part of n, nil if n is IPv4 only.
+func loadNet6(n *util.Net) (*net6, error) {
+	if n.IPAlloc.Subnet6 == "" {
+		return nil, nil
+	}
+	_, subnet, err := net.ParseCIDR(n.IPAlloc.Subnet6)
+	if err != nil {
+		return nil, fmt.Errorf("ipAlloc.subnet6: %v", err)
+	}
+	if subnet.IP.To4() != nil {
+		return nil, fmt.Errorf("ipAlloc.subnet6: %v is not an IPv6 subnet", subnet)
+	}
+	if ones, _ := subnet.Mask.Size(); ones > maxPrefix6 {
+		return nil, fmt.Errorf("ipAlloc.subnet6: the prefix of %v is longer than /%d", subnet, maxPrefix6)
+	}
+	if n.IPAlloc.Type == "host-local" && n.Name == "" {
+		return nil, fmt.Errorf("host-local IP allocation needs a network name")
+	}
+
+	// the gateway defaults to the first address after the subnet-router
+	// anycast one, as in IPv4 subnets
+	gw := ipAdd6(subnet.IP, 1)
+	if s := n.IPAlloc.Gateway6; s != "" {
+		gw = net.ParseIP(s)
+		if gw == nil || gw.To4() != nil || !subnet.Contains(gw) {
+			return nil, fmt.Errorf("ipAlloc.gateway6: invalid address %q in %v", s, subnet)
+		}
+	}
+	return &net6{
+		name:    n.Name,
+		ipType:  n.IPAlloc.Type,
+		subnet:  subnet,
+		gateway: gw,
+	}, nil
+}
+
+func bigToIP6(n *big.Int) net.IP {
+	b := n.Bytes()
+	ip := make(net.IP, net.IPv6len)
+	copy(ip[net.IPv6len-len(b):], b)
+	return ip
+}
+
+func ipAdd6(ip net.IP, val uint64) net.IP {
+	n := new(big.Int).SetBytes(ip.To16())
+	return bigToIP6(n.Add(n, new(big.Int).SetUint64(val)))
+}
+
+// randomIP6 picks the first address of a random block of size addresses
+// of subnet, other than the one starting with the subnet-router anycast
+// address.
+func randomIP6(subnet *net.IPNet, size uint64) (net.IP, error) {
+	ones, bits := subnet.Mask.Size()
+	blocks := new(big.Int).Lsh(big.NewInt(1), uint(bits-ones))
+	blocks.Div(blocks, new(big.Int).SetUint64(size))
+	n, err := rand.Int(rand.Reader, blocks.Sub(blocks, big.NewInt(1)))
+	if err != nil {
+		return nil, err
+	}
+	n.Add(n, big.NewInt(1))
+	n.Mul(n, new(big.Int).SetUint64(size))
+	return bigToIP6(n.Add(n, new(big.Int).SetBytes(subnet.IP.To16()))), nil
+}
+
+// usable checks the size addresses starting at ip may be allocated: they
+// must be in the subnet and be neither its anycast address nor the gateway.
+func (n *net6) usable(ip net.IP, size uint64) error {
+	for i := uint64(0); i < size; i++ {
+		a := ipAdd6(ip, i)
+		if !n.subnet.Contains(a) || a.Equal(n.subnet.IP) {
+			return fmt.Errorf("IP %v is not usable in %v", a, n.subnet)
+		}
+		if a.Equal(n.gateway) {
+			return fmt.Errorf("IP %v is the gateway of %v", a, n.subnet)
+		}
+	}
+	return nil
+}
+
+// alloc allocates size consecutive addresses, aligned on size, to contID
+// and returns the first one: the ones starting at ip if it isn't nil,
+// random ones otherwise. Host-local allocations record them next to the
+// IPv4 ones of the network, so they are released together.
+func (n *net6) alloc(contID string, ip net.IP, size uint64) (net.IP, error) {
+	switch n.ipType {
+	case "static", "host-local":
+	default:
+		return nil, fmt.Errorf("IPv6 addresses are not supported by the %q IP allocation", n.ipType)
+	}
+
+	reserve := func(first net.IP) (bool, error) {
+		if n.ipType == "static" {
+			return true, nil
+		}
+		ips := make([]net.IP, size)
+		for i := range ips {
+			ips[i] = ipAdd6(first, uint64(i))
+		}
+		dir := filepath.Join(HostLocalDir, n.name)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return false, fmt.Errorf("error creating %s: %v", dir, err)
+		}
+		ok, err := reserveIPs(dir, ips, contID)
+		if err == nil && !ok && ip != nil {
+			err = allocatedError(dir, ips)
+		}
+		return ok, err
+	}
+
+	if ip != nil {
+		if err := n.usable(ip, size); err != nil {
+			return nil, err
+		}
+		if _, err := reserve(ip); err != nil {
+			return nil, err
+		}
+		return ip, nil
+	}
+
+	for i := 0; i < allocTries6; i++ {
+		first, err := randomIP6(n.subnet, size)
+		if err != nil {
+			return nil, err
+		}
+		if n.usable(first, size) != nil {
+			continue
+		}
+		ok, err := reserve(first)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return first, nil
+		}
+	}
+	return nil, fmt.Errorf("no free IPv6 address found in %v", n.subnet)
+}
+
+// AllocIP6 allocates an IPv6 address, in addition to the IPv4 one given
+// by AllocIP, on networks with an ipAlloc.subnet6. It returns a nil
+// address on IPv4-only networks. An "ip6" argument requests a specific
+// address.
+func AllocIP6(contID types.UUID, netConf []byte, ifName, args string) (*net.IPNet, net.IP, error) {
+	opts, err := parseArgs(args)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	n := util.Net{}
+	if err := util.ParseNet(netConf, &n); err != nil {
+		return nil, nil, err
+	}
+	n6, err := loadNet6(&n)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error parsing %q conf: %v", n.Name, err)
+	}
+	if n6 == nil {
+		if opts.ip6 != nil {
+			return nil, nil, fmt.Errorf("requested IP %v but %q has no IPv6 subnet", opts.ip6, n.Name)
+		}
+		return nil, nil, nil
+	}
+
+	ip, err := n6.alloc(contID.String(), opts.ip6, 1)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error allocating IPv6 address in %v: %v", n6.subnet, err)
+	}
+	return &net.IPNet{
+		IP:   ip,
+		Mask: n6.subnet.Mask,
+	}, n6.gateway, nil
+}
+
+// AllocPtP6 allocates a /127 for point-to-point links on networks with an
+// ipAlloc.subnet6, as AllocPtP does a /31. It returns nil addresses on
+// IPv4-only networks. The second address is the container's: an "ip6"
+// argument must be the second address of a /127.
+func AllocPtP6(contID types.UUID, netConf []byte, ifName, args string) ([2]net.IP, error) {
+	opts, err := parseArgs(args)
+	if err != nil {
+		return [2]net.IP{nil, nil}, err
+	}
+	mask := net.CIDRMask(127, 128)
+	var first net.IP
+	if opts.ip6 != nil {
+		if first = opts.ip6.Mask(mask); first.Equal(opts.ip6) {
+			return [2]net.IP{nil, nil}, fmt.Errorf("requested IP %v is not the second address of a /127", opts.ip6)
+		}
+	}
+
+	n := util.Net{}
+	if err := util.ParseNet(netConf, &n); err != nil {
+		return [2]net.IP{nil, nil}, err
+	}
+	n6, err := loadNet6(&n)
+	if err != nil {
+		return [2]net.IP{nil, nil}, fmt.Errorf("error parsing %q conf: %v", n.Name, err)
+	}
+	if n6 == nil {
+		if opts.ip6 != nil {
+			return [2]net.IP{nil, nil}, fmt.Errorf("requested IP %v but %q has no IPv6 subnet", opts.ip6, n.Name)
+		}
+		return [2]net.IP{nil, nil}, nil
+	}
+
+	if first, err = n6.alloc(contID.String(), first, 2); err != nil {
+		return [2]net.IP{nil, nil}, fmt.Errorf("error allocating /127 in %v: %v", n6.subnet, err)
+	}
+	return [2]net.IP{first, ipAdd6(first, 1)}, nil
+}
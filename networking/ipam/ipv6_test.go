@@ -0,0 +1,106 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ipam
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadNet6(t *testing.T) {
+	n := testNet("test", "10.0.0.0/24", "", "")
+	if n6, err := loadNet6(n); err != nil || n6 != nil {
+		t.Errorf("got %v (err %v) for an IPv4-only net, want nil", n6, err)
+	}
+
+	n.IPAlloc.Subnet6 = "fd00::/64"
+	n6, err := loadNet6(n)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n6.gateway.String() != "fd00::1" {
+		t.Errorf("got gateway %v, want fd00::1", n6.gateway)
+	}
+
+	for _, bad := range []struct{ subnet, gw string }{
+		{"10.1.0.0/16", ""},
+		{"fd00::/124", ""},
+		{"fd00::/64", "fd01::1"},
+		{"fd00::/64", "10.0.0.1"},
+	} {
+		n.IPAlloc.Subnet6, n.IPAlloc.Gateway6 = bad.subnet, bad.gw
+		if _, err := loadNet6(n); err == nil {
+			t.Errorf("subnet6 %q gateway6 %q: expected error", bad.subnet, bad.gw)
+		}
+	}
+}
+
+func TestHostLocal6(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hostlocal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	HostLocalDir = dir
+
+	n := testNet("test", "10.0.0.0/24", "", "")
+	n.IPAlloc.Subnet6 = "fd00::/120"
+	n6, err := loadNet6(n)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// requested before the random allocations, which can't take it then
+	ip := net.ParseIP("fd00::42")
+	if _, err := n6.alloc("c2", ip, 1); err != nil {
+		t.Fatalf("unexpected error requesting %v: %v", ip, err)
+	}
+	if _, err := n6.alloc("c3", ip, 1); err == nil {
+		t.Errorf("expected error requesting %v twice", ip)
+	}
+	if _, err := n6.alloc("c3", n6.gateway, 1); err == nil {
+		t.Errorf("expected error requesting the gateway")
+	}
+
+	for i := 0; i < 20; i++ {
+		ip, err := n6.alloc("c1", nil, 2)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !n6.subnet.Contains(ip) || ip[15]%2 != 0 || ip.Equal(n6.subnet.IP) {
+			t.Errorf("got unaligned or unusable pair starting at %v", ip)
+		}
+		for _, a := range []net.IP{ip, ipAdd6(ip, 1)} {
+			if _, err := os.Stat(filepath.Join(dir, "test", a.String())); err != nil {
+				t.Errorf("%v not reserved: %v", a, err)
+			}
+		}
+	}
+
+	// the IPv4 and IPv6 addresses are released together
+	h, err := newHostLocal(n)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := h.release("c2"); err != nil {
+		t.Fatalf("unexpected error releasing: %v", err)
+	}
+	if _, err := n6.alloc("c3", ip, 1); err != nil {
+		t.Errorf("unexpected error requesting released %v: %v", ip, err)
+	}
+}
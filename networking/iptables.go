@@ -0,0 +1,234 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package networking
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha512"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Containers on a net with ipMasq have the traffic they send out of the
+// net masqueraded. Each net has a chain in the nat table, for iptables and
+// ip6tables, holding its masquerading rules, and the traffic of each of its
+// containers jumps there from POSTROUTING:
+//
+//	-A POSTROUTING -s 172.16.28.3 -j RKT-MASQ-1A2B3C4D
+//	-A RKT-MASQ-1A2B3C4D -d 172.16.28.0/24 -j RETURN
+//	-A RKT-MASQ-1A2B3C4D ! -d 224.0.0.0/4 -j MASQUERADE
+//
+// The chain is removed along with the jump of the last container.
+
+// masqChain returns the name of the chain masquerading the traffic of the
+// net named netName. Chain names are limited to 28 characters, so it is
+// derived from a hash of the name.
+func masqChain(netName string) string {
+	h := sha512.Sum512([]byte(netName))
+	return fmt.Sprintf("RKT-MASQ-%X", h[:4])
+}
+
+// ipTablesTool returns the tool managing the firewall rules of ip's family
+func ipTablesTool(ip net.IP) string {
+	if ip.To4() == nil {
+		return "ip6tables"
+	}
+	return "iptables"
+}
+
+// masqChainRules returns the rules of chain, not masquerading the traffic
+// to subnet, nor multicast traffic.
+func masqChainRules(chain string, subnet *net.IPNet) [][]string {
+	multicast := "224.0.0.0/4"
+	if subnet.IP.To4() == nil {
+		multicast = "ff00::/8"
+	}
+	return [][]string{
+		{chain, "-d", subnet.String(), "-j", "RETURN"},
+		{chain, "!", "-d", multicast, "-j", "MASQUERADE"},
+	}
+}
+
+// masqJumpRule returns the rule sending the traffic of ip to chain
+func masqJumpRule(chain string, ip net.IP) []string {
+	return []string{"POSTROUTING", "-s", ip.String(), "-j", chain}
+}
+
+func natCmd(tool, op string, rule ...string) ([]byte, error) {
+	args := append([]string{"-t", "nat", op}, rule...)
+	return exec.Command(tool, args...).CombinedOutput()
+}
+
+// ensureRule appends rule to the nat table unless it is there already
+func ensureRule(tool string, rule []string) error {
+	if _, err := natCmd(tool, "-C", rule...); err == nil {
+		return nil
+	}
+	if out, err := natCmd(tool, "-A", rule...); err != nil {
+		return fmt.Errorf("error adding %s rule %q: %v: %s", tool, strings.Join(rule, " "), err, out)
+	}
+	return nil
+}
+
+// setupIPMasq masquerades the traffic of ipn, a container's address on the
+// net named netName, leaving subnet, the net's subnet.
+func setupIPMasq(netName string, ipn, subnet *net.IPNet) error {
+	tool := ipTablesTool(ipn.IP)
+	chain := masqChain(netName)
+	// the chain may exist already, shared with other containers
+	natCmd(tool, "-N", chain)
+	for _, rule := range masqChainRules(chain, subnet) {
+		if err := ensureRule(tool, rule); err != nil {
+			return err
+		}
+	}
+	return ensureRule(tool, masqJumpRule(chain, ipn.IP))
+}
+
+// teardownIPMasq stops masquerading the traffic of ip on the net named
+// netName, removing its chain if no other container uses it.
+func teardownIPMasq(netName string, ip net.IP) error {
+	tool := ipTablesTool(ip)
+	chain := masqChain(netName)
+	if out, err := natCmd(tool, "-D", masqJumpRule(chain, ip)...); err != nil {
+		return fmt.Errorf("error deleting %s masquerading rule for %v: %v: %s", tool, ip, err, out)
+	}
+
+	out, err := natCmd(tool, "-S", "POSTROUTING")
+	if err != nil {
+		return fmt.Errorf("error listing %s rules: %v: %s", tool, err, out)
+	}
+	if referencesChain(out, chain) {
+		return nil
+	}
+	if out, err := natCmd(tool, "-F", chain); err != nil {
+		return fmt.Errorf("error flushing %s chain %s: %v: %s", tool, chain, err, out)
+	}
+	if out, err := natCmd(tool, "-X", chain); err != nil {
+		return fmt.Errorf("error deleting %s chain %s: %v: %s", tool, chain, err, out)
+	}
+	return nil
+}
+
+// referencesChain reports whether the rules listed in out by "-S" jump to
+// chain
+func referencesChain(out []byte, chain string) bool {
+	s := bufio.NewScanner(bytes.NewReader(out))
+	for s.Scan() {
+		f := strings.Fields(s.Text())
+		for i := 0; i+1 < len(f); i++ {
+			if f[i] == "-j" && f[i+1] == chain {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// masqSubnet returns the subnet of the net an address of the container is
+// in: the configured one of the address's family, or the network of the
+// address itself if the net has none, e.g. with DHCP.
+func masqSubnet(n *Net, ipn *net.IPNet) (*net.IPNet, error) {
+	s := n.IPAlloc.Subnet
+	if ipn.IP.To4() == nil {
+		s = n.IPAlloc.Subnet6
+	}
+	if s == "" {
+		return &net.IPNet{IP: ipn.IP.Mask(ipn.Mask), Mask: ipn.Mask}, nil
+	}
+	_, subnet, err := net.ParseCIDR(s)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing subnet of net %q: %v", n.Name, err)
+	}
+	return subnet, nil
+}
+
+// setupNetMasq masquerades the traffic of the container on an, if its net
+// asks for it.
+func setupNetMasq(an *activeNet) error {
+	if !an.IPMasq {
+		return nil
+	}
+	for i, ipn := range an.addrs() {
+		subnet, err := masqSubnet(&an.Net, ipn)
+		if err == nil {
+			err = setupIPMasq(an.Name, ipn, subnet)
+		}
+		if err != nil {
+			for _, added := range an.addrs()[:i] {
+				teardownIPMasq(an.Name, added.IP)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// teardownNetMasq undoes setupNetMasq
+func teardownNetMasq(an *activeNet) error {
+	if !an.IPMasq {
+		return nil
+	}
+	var first error
+	for _, ipn := range an.addrs() {
+		if err := teardownIPMasq(an.Name, ipn.IP); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// TeardownIPMasq removes the masquerading rules recorded in the directory
+// cdir of a container which exited without tearing them down.
+func TeardownIPMasq(cdir string) error {
+	ni, err := LoadNetInfo(cdir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var first error
+	changed := false
+	for i, na := range ni.Nets {
+		if !na.IPMasq {
+			continue
+		}
+		for _, a := range []string{na.IP, na.IP6} {
+			if a == "" {
+				continue
+			}
+			ip, _, err := net.ParseCIDR(a)
+			if err == nil {
+				err = teardownIPMasq(na.NetName, ip)
+			}
+			if err != nil && first == nil {
+				first = err
+			}
+		}
+		ni.Nets[i].IPMasq = false
+		changed = true
+	}
+	if changed {
+		if err := writeNetInfo(cdir, ni); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
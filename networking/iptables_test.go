@@ -0,0 +1,109 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package networking
+
+import (
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMasqChain(t *testing.T) {
+	c := masqChain("default")
+	if len(c) > 28 || !strings.HasPrefix(c, "RKT-MASQ-") {
+		t.Errorf("bad chain name %q", c)
+	}
+	if c != masqChain("default") {
+		t.Errorf("chain name of a net changed")
+	}
+	if c == masqChain("backend") {
+		t.Errorf("two nets got chain %q", c)
+	}
+}
+
+func TestMasqRules(t *testing.T) {
+	tests := []struct {
+		ip     string
+		subnet string
+		tool   string
+		rules  []string
+	}{
+		{
+			"172.16.28.3",
+			"172.16.28.0/24",
+			"iptables",
+			[]string{
+				"RKT-MASQ-X -d 172.16.28.0/24 -j RETURN",
+				"RKT-MASQ-X ! -d 224.0.0.0/4 -j MASQUERADE",
+				"POSTROUTING -s 172.16.28.3 -j RKT-MASQ-X",
+			},
+		},
+		{
+			"fd00:10:1::2a",
+			"fd00:10:1::/64",
+			"ip6tables",
+			[]string{
+				"RKT-MASQ-X -d fd00:10:1::/64 -j RETURN",
+				"RKT-MASQ-X ! -d ff00::/8 -j MASQUERADE",
+				"POSTROUTING -s fd00:10:1::2a -j RKT-MASQ-X",
+			},
+		},
+	}
+	for _, tt := range tests {
+		ip := net.ParseIP(tt.ip)
+		_, subnet, err := net.ParseCIDR(tt.subnet)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.subnet, err)
+		}
+		if tool := ipTablesTool(ip); tool != tt.tool {
+			t.Errorf("%s: got tool %q, want %q", tt.ip, tool, tt.tool)
+		}
+		var rules []string
+		for _, r := range append(masqChainRules("RKT-MASQ-X", subnet), masqJumpRule("RKT-MASQ-X", ip)) {
+			rules = append(rules, strings.Join(r, " "))
+		}
+		if !reflect.DeepEqual(rules, tt.rules) {
+			t.Errorf("%s: got %q, want %q", tt.ip, rules, tt.rules)
+		}
+	}
+}
+
+func TestReferencesChain(t *testing.T) {
+	out := []byte(`-P POSTROUTING ACCEPT
+-A POSTROUTING -s 172.16.28.3/32 -j RKT-MASQ-1A2B3C4D
+-A POSTROUTING -s 10.1.0.2/32 -j MASQUERADE
+`)
+	if !referencesChain(out, "RKT-MASQ-1A2B3C4D") {
+		t.Errorf("reference to RKT-MASQ-1A2B3C4D not found")
+	}
+	if referencesChain(out, "RKT-MASQ-5E6F7A8B") {
+		t.Errorf("unexpected reference to RKT-MASQ-5E6F7A8B")
+	}
+}
+
+func TestMasqSubnet(t *testing.T) {
+	n := &Net{}
+	n.IPAlloc.Subnet = "10.1.0.0/16"
+	ipn := &net.IPNet{IP: net.ParseIP("10.1.2.3"), Mask: net.CIDRMask(16, 32)}
+	if s, err := masqSubnet(n, ipn); err != nil || s.String() != "10.1.0.0/16" {
+		t.Errorf("got %v (err %v), want 10.1.0.0/16", s, err)
+	}
+	// no configured IPv6 subnet, e.g. a plugin allocating addresses itself
+	ipn6 := &net.IPNet{IP: net.ParseIP("fd00::2a"), Mask: net.CIDRMask(64, 128)}
+	if s, err := masqSubnet(n, ipn6); err != nil || s.String() != "fd00::/64" {
+		t.Errorf("got %v (err %v), want fd00::/64", s, err)
+	}
+}
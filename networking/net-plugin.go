@@ -16,10 +16,11 @@ package networking
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"net"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
 
@@ -31,35 +32,155 @@ import (
 const UserNetPluginsPath = "/usr/lib/rkt/plugins/net"
 const BuiltinNetPluginsPath = "usr/lib/rkt/plugins/net"
 
-func (e *containerEnv) netPluginAdd(n *Net, netns, args, ifName string) (*net.IPNet, error) {
-	output, err := e.execNetPlugin("ADD", n, netns, args, ifName)
+// netPluginAdd runs the plugins of n to attach the container to it and
+// returns the addresses it got, IPv4 and, on dual-stack networks, IPv6,
+// and the DNS settings of the network.
+func (e *containerEnv) netPluginAdd(n *Net, netns, args, ifName string) (ipn, ipn6 *net.IPNet, dns *util.DNS, err error) {
+	r, err := e.execNetPlugins(n, netns, args, ifName)
 	if err != nil {
-		return nil, err
+		return nil, nil, nil, err
 	}
-
-	return util.ParseCIDR(output)
+	if r.IP4 == nil {
+		return nil, nil, nil, fmt.Errorf("plugin %q returned no IPv4 address", n.Type)
+	}
+	ipn = (*net.IPNet)(&r.IP4.IP)
+	if r.IP6 != nil {
+		ipn6 = (*net.IPNet)(&r.IP6.IP)
+		if ipn6.IP.To4() != nil {
+			return nil, nil, nil, fmt.Errorf("plugin %q returned %v as an IPv6 address", n.Type, ipn6)
+		}
+	}
+	return ipn, ipn6, &r.DNS, nil
 }
 
+// netPluginDel runs the plugins of n, in reverse order, to detach the
+// container from it. All of them are run whatever the failures, the first
+// one is returned.
 func (e *containerEnv) netPluginDel(n *Net, netns, args, ifName string) error {
-	_, err := e.execNetPlugin("DEL", n, netns, args, ifName)
-	return err
+	ps := n.plugins()
+	var first error
+	for i := len(ps) - 1; i >= 0; i-- {
+		if _, err := e.execNetPlugin("DEL", n, ps[i], nil, netns, args, ifName); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
 }
 
-func (e *containerEnv) findNetPlugin(plugin string) string {
+// execNetPlugins runs the plugins of n in turn for ADD, handing each
+// chained plugin the result of the previous one, and returns the result
+// of the last one. If one fails, those already run are run for DEL.
+func (e *containerEnv) execNetPlugins(n *Net, netns, args, ifName string) (*util.Result, error) {
+	ps := n.plugins()
+	var r *util.Result
+	for i, p := range ps {
+		pr, err := e.execNetPlugin("ADD", n, p, r, netns, args, ifName)
+		if err != nil {
+			for j := i - 1; j >= 0; j-- {
+				e.execNetPlugin("DEL", n, ps[j], nil, netns, args, ifName)
+			}
+			if i > 0 {
+				err = fmt.Errorf("chained plugin %q: %v", p.typ, err)
+			}
+			return nil, err
+		}
+		// chained plugins which don't change the result may print none
+		if pr != nil {
+			r = pr
+		}
+	}
+	return r, nil
+}
+
+func (e *containerEnv) netPluginPaths() []string {
 	// try 3rd-party path first
-	paths := []string{
+	return []string{
 		UserNetPluginsPath,
-		filepath.Join(rktpath.Stage1RootfsPath(e.rktRoot), BuiltinNetPluginsPath),
+		filepath.Join(e.stage1Rootfs(), BuiltinNetPluginsPath),
 	}
+}
+
+func (e *containerEnv) stage1Rootfs() string {
+	return rktpath.Stage1RootfsPath(e.rktRoot)
+}
 
-	for _, p := range paths {
+// findNetPlugin returns the path of the plugin and, if it is shipped in
+// the stage1 image rather than installed on the host, the stage1 rootfs
+func (e *containerEnv) findNetPlugin(plugin string) (path, root string) {
+	for _, p := range e.netPluginPaths() {
 		fullname := filepath.Join(p, plugin)
 		if fi, err := os.Stat(fullname); err == nil && fi.Mode().IsRegular() {
-			return fullname
+			if p != UserNetPluginsPath {
+				root = e.stage1Rootfs()
+			}
+			return fullname, root
+		}
+	}
+
+	return "", ""
+}
+
+// execNetPlugin runs p, a plugin of n, for cmd, returning its result for
+// ADD. prev, if not nil, is the result of the plugin p is chained to.
+// Plugins which don't answer VERSION are run with the protocol of earlier
+// rkt versions, unless chained.
+func (e *containerEnv) execNetPlugin(cmd string, n *Net, p netPlugin, prev *util.Result, netns, args, ifName string) (*util.Result, error) {
+	pluginPath, root := e.findNetPlugin(p.typ)
+	if pluginPath == "" {
+		return nil, fmt.Errorf("Could not find plugin %q", p.typ)
+	}
+
+	vi, err := util.PluginVersionInfo(root, pluginPath)
+	if err != nil {
+		if n.chain != nil {
+			return nil, fmt.Errorf("plugin %q doesn't support the plugin protocol, it can't be chained", p.typ)
+		}
+		return e.execLegacyNetPlugin(root, pluginPath, cmd, n, netns, args, ifName)
+	}
+	if !vi.Supports() {
+		return nil, fmt.Errorf("plugin %q speaks unsupported versions %v of the plugin protocol", p.typ, vi.SupportedVersions)
+	}
+
+	conf := p.conf
+	if prev != nil {
+		if conf, err = withPrevResult(conf, prev); err != nil {
+			return nil, err
 		}
 	}
+	out, err := util.ExecRootPlugin(root, pluginPath, cmd, &util.CmdArgs{
+		ContainerID: e.contID.String(),
+		Netns:       netns,
+		IfName:      ifName,
+		Args:        args,
+		Path:        strings.Join(e.netPluginPaths(), ":"),
+		StdinData:   conf,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if cmd != "ADD" || (prev != nil && len(bytes.TrimSpace(out)) == 0) {
+		return nil, nil
+	}
+	r := &util.Result{}
+	if err := json.Unmarshal(out, r); err != nil {
+		return nil, fmt.Errorf("error parsing result of plugin %q: %v", p.typ, err)
+	}
+	return r, nil
+}
 
-	return ""
+// withPrevResult returns the plugin configuration conf with r as the
+// result of the previous plugin of the chain
+func withPrevResult(conf []byte, r *util.Result) ([]byte, error) {
+	fields := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(conf, &fields); err != nil {
+		return nil, err
+	}
+	b, err := json.Marshal(r)
+	if err != nil {
+		return nil, err
+	}
+	fields["prevResult"] = b
+	return json.Marshal(fields)
 }
 
 func envVars(vars [][2]string) []string {
@@ -72,34 +193,84 @@ func envVars(vars [][2]string) []string {
 	return env
 }
 
-func (e *containerEnv) execNetPlugin(cmd string, n *Net, netns, args, ifName string) (string, error) {
-	pluginPath := e.findNetPlugin(n.Type)
-	if pluginPath == "" {
-		return "", fmt.Errorf("Could not find plugin %q", n.Type)
+// execLegacyNetPlugin runs the plugin at pluginPath, shipped in the rootfs
+// at root if set, as rkt ran plugins before the protocol was versioned,
+// passing everything in RKT_NETPLUGIN_* variables
+func (e *containerEnv) execLegacyNetPlugin(root, pluginPath, cmd string, n *Net, netns, args, ifName string) (*util.Result, error) {
+	// nets recorded with a container have no configuration file
+	confPath := n.Filename
+	if confPath == "" {
+		f, err := ioutil.TempFile("", "rkt-net-")
+		if err != nil {
+			return nil, err
+		}
+		defer os.Remove(f.Name())
+		_, err = f.Write(n.conf)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("error writing configuration of net %q: %v", n.Name, err)
+		}
+		confPath = f.Name()
 	}
 
 	vars := [][2]string{
-		{ "RKT_NETPLUGIN_COMMAND", cmd },
-		{ "RKT_NETPLUGIN_CONTID", e.contID.String() },
-		{ "RKT_NETPLUGIN_NETNS", netns },
-		{ "RKT_NETPLUGIN_ARGS", args },
-		{ "RKT_NETPLUGIN_IFNAME", ifName },
-		{ "RKT_NETPLUGIN_NETNAME", n.Name },
-		{ "RKT_NETPLUGIN_NETCONF", n.Filename },
+		{"RKT_NETPLUGIN_COMMAND", cmd},
+		{"RKT_NETPLUGIN_CONTID", e.contID.String()},
+		{"RKT_NETPLUGIN_NETNS", netns},
+		{"RKT_NETPLUGIN_ARGS", args},
+		{"RKT_NETPLUGIN_IFNAME", ifName},
+		{"RKT_NETPLUGIN_NETNAME", n.Name},
+		{"RKT_NETPLUGIN_NETCONF", confPath},
 	}
 
 	stdout := &bytes.Buffer{}
 
-	c := exec.Cmd{
-		Path: pluginPath,
-		Args: []string{pluginPath},
-		Env: envVars(vars),
-		Stdout: stdout,
-		Stderr: os.Stderr,
+	c, err := util.PluginCommand(root, pluginPath)
+	if err != nil {
+		return nil, err
 	}
+	c.Env = append(c.Env, envVars(vars)...)
+	c.Stdout = stdout
+	c.Stderr = os.Stderr
 	if err := c.Run(); err != nil {
-		return "", err
+		return nil, err
+	}
+	if cmd != "ADD" {
+		return nil, nil
 	}
+	return parseLegacyResult(n.Type, stdout.String())
+}
 
-	return stdout.String(), nil
+// parseLegacyResult parses what plugins of earlier rkt versions printed:
+// on the first line, an IPv4 address, followed by an IPv6 one on
+// dual-stack networks, then optionally DNS settings in the resolv.conf
+// format.
+func parseLegacyResult(plugin, output string) (*util.Result, error) {
+	addrs, rest := output, ""
+	if i := strings.Index(output, "\n"); i >= 0 {
+		addrs, rest = output[:i], output[i+1:]
+	}
+	fields := strings.Fields(addrs)
+	if len(fields) == 0 || len(fields) > 2 {
+		return nil, fmt.Errorf("unexpected output of plugin %q: %q", plugin, output)
+	}
+	r := &util.Result{}
+	ipn, err := util.ParseCIDR(fields[0])
+	if err != nil {
+		return nil, err
+	}
+	r.IP4 = &util.IPConfig{IP: util.IPNet(*ipn)}
+	if len(fields) == 2 {
+		ipn6, err := util.ParseCIDR(fields[1])
+		if err != nil {
+			return nil, err
+		}
+		r.IP6 = &util.IPConfig{IP: util.IPNet(*ipn6)}
+	}
+	dns, err := util.ParseDNS(rest)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing DNS settings printed by plugin %q: %v", plugin, err)
+	}
+	r.DNS = *dns
+	return r, nil
 }
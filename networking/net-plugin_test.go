@@ -0,0 +1,212 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package networking
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/appc/spec/schema/types"
+	rktpath "github.com/coreos/rocket/path"
+)
+
+const (
+	cniPlugin = `#!/bin/sh
+case "$CNI_COMMAND" in
+VERSION) echo '{"cniVersion": "0.2.0", "supportedVersions": ["0.1.0", "0.2.0"]}' ;;
+ADD) cat > "$0.stdin"; echo '{"ip4": {"ip": "10.1.2.3/24"}, "ip6": {"ip": "fd00::3/64"}, "dns": {"nameservers": ["10.1.0.53"]}}' ;;
+DEL) touch "$0.del" ;;
+*) exit 1 ;;
+esac
+`
+	chainedPlugin = `#!/bin/sh
+case "$CNI_COMMAND" in
+VERSION) echo '{"cniVersion": "0.2.0", "supportedVersions": ["0.2.0"]}' ;;
+ADD) cat > "$0.stdin"; echo '{"ip4": {"ip": "10.1.2.9/24"}}' ;;
+esac
+`
+	quietPlugin = `#!/bin/sh
+[ "$CNI_COMMAND" = VERSION ] && echo '{"cniVersion": "0.2.0", "supportedVersions": ["0.2.0"]}'
+exit 0
+`
+	legacyPlugin = `#!/bin/sh
+[ -n "$RKT_NETPLUGIN_COMMAND" ] || exit 1
+echo 10.1.2.4/24
+echo nameserver 10.1.0.54
+`
+	failingPlugin = `#!/bin/sh
+[ "$CNI_COMMAND" = VERSION ] && exec echo '{"cniVersion": "0.2.0", "supportedVersions": ["0.2.0"]}'
+echo '{"code": 100, "msg": "no more addresses"}'
+exit 1
+`
+	futurePlugin = `#!/bin/sh
+echo '{"cniVersion": "9.0.0", "supportedVersions": ["9.0.0"]}'
+`
+)
+
+func TestExecNetPlugin(t *testing.T) {
+	dir, err := ioutil.TempDir("", "netplugin")
+	if err != nil {
+		t.Fatalf("error creating tempdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	pd := filepath.Join(rktpath.Stage1RootfsPath(dir), BuiltinNetPluginsPath)
+	if err := os.MkdirAll(pd, 0755); err != nil {
+		t.Fatalf("error creating plugin directory: %v", err)
+	}
+	plugins := map[string]string{
+		"cni":     cniPlugin,
+		"legacy":  legacyPlugin,
+		"failing": failingPlugin,
+		"future":  futurePlugin,
+		"chained": chainedPlugin,
+		"quiet":   quietPlugin,
+	}
+	for name, script := range plugins {
+		if _, err := os.Stat(filepath.Join(UserNetPluginsPath, name)); err == nil {
+			t.Skipf("plugin %q installed on the host", name)
+		}
+		if err := ioutil.WriteFile(filepath.Join(pd, name), []byte(script), 0755); err != nil {
+			t.Fatalf("error writing plugin: %v", err)
+		}
+	}
+	conf := filepath.Join(dir, "net.conf")
+	confData := []byte(`{"name": "backend", "type": "cni"}`)
+	if err := ioutil.WriteFile(conf, confData, 0644); err != nil {
+		t.Fatalf("error writing net configuration: %v", err)
+	}
+
+	uuid, err := types.NewUUID("6733c35b-3b5f-4b4c-a67b-1f3a5d6e1c2a")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	e := containerEnv{rktRoot: dir, contID: *uuid}
+	newNet := func(typ string) *Net {
+		n := &Net{conf: confData}
+		n.Name, n.Type, n.Filename = "backend", typ, conf
+		return n
+	}
+
+	ipn, ipn6, dns, err := e.netPluginAdd(newNet("cni"), "/proc/self/ns/net", "", "eth0")
+	if err != nil {
+		t.Fatalf("cni: unexpected error: %v", err)
+	}
+	if ipn.String() != "10.1.2.3/24" || ipn6 == nil || ipn6.String() != "fd00::3/64" {
+		t.Errorf("cni: got addresses %v %v", ipn, ipn6)
+	}
+	if !reflect.DeepEqual(dns.Nameservers, []string{"10.1.0.53"}) {
+		t.Errorf("cni: got DNS settings %+v", dns)
+	}
+	stdin, err := ioutil.ReadFile(filepath.Join(pd, "cni.stdin"))
+	if err != nil || !strings.Contains(string(stdin), `"backend"`) {
+		t.Errorf("cni: network configuration not passed on stdin: %q, %v", stdin, err)
+	}
+	if err := e.netPluginDel(newNet("cni"), "/proc/self/ns/net", "", "eth0"); err != nil {
+		t.Errorf("cni: unexpected error: %v", err)
+	}
+
+	ipn, ipn6, dns, err = e.netPluginAdd(newNet("legacy"), "/proc/self/ns/net", "", "eth0")
+	if err != nil {
+		t.Fatalf("legacy: unexpected error: %v", err)
+	}
+	if !ipn.IP.Equal(net.ParseIP("10.1.2.4")) || ipn6 != nil {
+		t.Errorf("legacy: got addresses %v %v", ipn, ipn6)
+	}
+	if !reflect.DeepEqual(dns.Nameservers, []string{"10.1.0.54"}) {
+		t.Errorf("legacy: got DNS settings %+v", dns)
+	}
+
+	if _, _, _, err = e.netPluginAdd(newNet("failing"), "/proc/self/ns/net", "", "eth0"); err == nil || err.Error() != "no more addresses" {
+		t.Errorf("failing: got error %v, want the one reported by the plugin", err)
+	}
+	if _, _, _, err = e.netPluginAdd(newNet("future"), "/proc/self/ns/net", "", "eth0"); err == nil {
+		t.Errorf("future: expected an error for an unsupported protocol version")
+	}
+
+	chain, err := parseNet([]byte(`{"name": "backend", "cniVersion": "0.2.0", "plugins": [{"type": "cni"}, {"type": "chained", "mark": 1}, {"type": "quiet"}]}`))
+	if err != nil {
+		t.Fatalf("chain: unexpected error: %v", err)
+	}
+	if chain.Type != "cni" || len(chain.plugins()) != 3 {
+		t.Fatalf("chain: got type %q and plugins %+v", chain.Type, chain.plugins())
+	}
+	ipn, _, _, err = e.netPluginAdd(&chain, "/proc/self/ns/net", "", "eth0")
+	if err != nil {
+		t.Fatalf("chain: unexpected error: %v", err)
+	}
+	if ipn.String() != "10.1.2.9/24" {
+		t.Errorf("chain: got address %v, want the one of the last plugin returning a result", ipn)
+	}
+	stdin, err = ioutil.ReadFile(filepath.Join(pd, "chained.stdin"))
+	if err != nil {
+		t.Fatalf("chain: unexpected error: %v", err)
+	}
+	for _, want := range []string{`"name":"backend"`, `"mark":1`, `"prevResult":{`, `"10.1.2.3/24"`} {
+		if !strings.Contains(string(stdin), want) {
+			t.Errorf("chain: %s missing from the configuration of the chained plugin: %s", want, stdin)
+		}
+	}
+
+	os.Remove(filepath.Join(pd, "cni.del"))
+	chain, err = parseNet([]byte(`{"name": "backend", "plugins": [{"type": "cni"}, {"type": "failing"}]}`))
+	if err != nil {
+		t.Fatalf("chain: unexpected error: %v", err)
+	}
+	if _, _, _, err = e.netPluginAdd(&chain, "/proc/self/ns/net", "", "eth0"); err == nil {
+		t.Errorf("chain: expected an error for a failing chained plugin")
+	}
+	if _, err := os.Stat(filepath.Join(pd, "cni.del")); err != nil {
+		t.Errorf("chain: main plugin not run for DEL after a chained one failed: %v", err)
+	}
+
+	chain, err = parseNet([]byte(`{"name": "backend", "plugins": [{"type": "cni"}, {"type": "legacy"}]}`))
+	if err != nil {
+		t.Fatalf("chain: unexpected error: %v", err)
+	}
+	if _, _, _, err = e.netPluginAdd(&chain, "/proc/self/ns/net", "", "eth0"); err == nil {
+		t.Errorf("chain: expected an error for a chained legacy plugin")
+	}
+
+	for _, conf := range []string{
+		`{"name": "backend", "type": "cni", "plugins": [{"type": "cni"}]}`,
+		`{"name": "backend", "plugins": [{"mark": 1}]}`,
+	} {
+		if _, err := parseNet([]byte(conf)); err == nil {
+			t.Errorf("%s: expected an error", conf)
+		}
+	}
+}
+
+func TestParseLegacyResult(t *testing.T) {
+	r, err := parseLegacyResult("veth", "10.1.2.3/31 fd00::3/127\nsearch example.com\n")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if r.IP4 == nil || r.IP6 == nil || !reflect.DeepEqual(r.DNS.Search, []string{"example.com"}) {
+		t.Errorf("got %+v", r)
+	}
+
+	for _, out := range []string{"", "10.1.2.3/31 fd00::3/127 10.1.2.5/31\n", "10.1.2.3\n", "10.1.2.3/31\nnameserver bogus\n"} {
+		if _, err := parseLegacyResult("veth", out); err == nil {
+			t.Errorf("%q: expected an error", out)
+		}
+	}
+}
@@ -15,6 +15,8 @@
 package networking
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -29,10 +31,105 @@ import (
 type Net struct {
 	util.Net
 	args string
+	// conf is the configuration the net was loaded from, handed to its
+	// plugin
+	conf []byte
+	// chain holds the plugins of a net configured with a list of them,
+	// the main one first; nil when conf names a single plugin
+	chain []netPlugin
+}
+
+// netPlugin is a plugin run for a net, with its own configuration
+type netPlugin struct {
+	typ  string
+	conf []byte
+}
+
+// plugins returns the plugins run for n, in order
+func (n *Net) plugins() []netPlugin {
+	if n.chain != nil {
+		return n.chain
+	}
+	return []netPlugin{{typ: n.Type, conf: n.conf}}
+}
+
+// loadNet loads the net configured in the file at path.
+func loadNet(path string) (Net, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return Net{}, err
+	}
+	n, err := parseNet(b)
+	if err != nil {
+		return Net{}, err
+	}
+	n.Filename = path
+	return n, nil
+}
+
+// parseNet parses the configuration of a net
+func parseNet(conf []byte) (Net, error) {
+	n := Net{conf: conf}
+	if err := util.ParseNet(conf, &n); err != nil {
+		return Net{}, err
+	}
+	if len(n.Plugins) == 0 {
+		return n, nil
+	}
+
+	if n.Type != "" {
+		return Net{}, errors.New("a net can't have both a type and a list of plugins")
+	}
+	for i, pc := range n.Plugins {
+		p, err := chainedPluginConf(&n, pc)
+		if err != nil {
+			return Net{}, fmt.Errorf("error parsing plugin %d of net %q: %v", i, n.Name, err)
+		}
+		n.chain = append(n.chain, p)
+	}
+	// the settings of the main plugin, e.g. its IP allocation, are the
+	// net's
+	if err := util.ParseNet(n.chain[0].conf, &n); err != nil {
+		return Net{}, err
+	}
+	return n, nil
+}
+
+// chainedPluginConf returns the plugin configured by pc, an entry of the
+// plugins of n, which gets the name and version of the net's configuration
+// unless it has its own.
+func chainedPluginConf(n *Net, pc json.RawMessage) (netPlugin, error) {
+	var pn util.Net
+	if err := util.ParseNet(pc, &pn); err != nil {
+		return netPlugin{}, err
+	}
+	if pn.Type == "" {
+		return netPlugin{}, errors.New("no plugin type given")
+	}
+	fields := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(pc, &fields); err != nil {
+		return netPlugin{}, err
+	}
+	for k, v := range map[string]string{"name": n.Name, "cniVersion": n.CNIVersion} {
+		if _, ok := fields[k]; ok || v == "" {
+			continue
+		}
+		b, err := json.Marshal(v)
+		if err != nil {
+			return netPlugin{}, err
+		}
+		fields[k] = b
+	}
+	conf, err := json.Marshal(fields)
+	if err != nil {
+		return netPlugin{}, err
+	}
+	return netPlugin{typ: pn.Type, conf: conf}, nil
 }
 
 // Absolute path where users place their net configs
 const UserNetPath = "/etc/rkt/net.d"
+
 // Default net path relative to stage1 root
 const DefaultNetPath = "etc/rkt/net.d/99-default.conf"
 
@@ -70,10 +167,15 @@ func loadUserNets() ([]Net, error) {
 
 	for _, filename := range files {
 		filepath := path.Join(UserNetPath, filename)
-		n := Net{}
-		if err := util.LoadNet(filepath, &n); err != nil {
+		n, err := loadNet(filepath)
+		if err != nil {
 			return nil, fmt.Errorf("error loading %v: %v", filepath, err)
 		}
+		if n.Type == flannelType {
+			if err := applyFlannel(&n); err != nil {
+				return nil, fmt.Errorf("error loading %v: %v", filepath, err)
+			}
+		}
 
 		nets = append(nets, n)
 	}
@@ -81,18 +183,44 @@ func loadUserNets() ([]Net, error) {
 	return nets, nil
 }
 
-// Loads nets specified by user and default one from stage1
-func (e *containerEnv) loadNets() ([]Net, error) {
+// Loads nets specified by user and default one from stage1. If names is
+// not empty only the nets with those names are returned, in that order.
+func (e *containerEnv) loadNets(names []string) ([]Net, error) {
 	nets, err := loadUserNets()
 	if err != nil {
 		return nil, err
 	}
 
 	defPath := path.Join(rktpath.Stage1RootfsPath(e.rktRoot), DefaultNetPath)
-	defNet := Net{}
-	if err := util.LoadNet(defPath, &defNet); err != nil {
+	defNet, err := loadNet(defPath)
+	if err != nil {
 		return nil, fmt.Errorf("error loading net: %v", err)
 	}
+	nets = append(nets, defNet)
+
+	if len(names) == 0 {
+		return nets, nil
+	}
+	return selectNets(nets, names)
+}
+
+// selectNets picks the nets with the given names. A net defined by the
+// user takes precedence over the default one of the same name.
+func selectNets(nets []Net, names []string) ([]Net, error) {
+	byName := make(map[string]Net)
+	for _, n := range nets {
+		if _, ok := byName[n.Name]; !ok {
+			byName[n.Name] = n
+		}
+	}
 
-	return append(nets, defNet), nil
+	selected := make([]Net, 0, len(names))
+	for _, name := range names {
+		n, ok := byName[name]
+		if !ok {
+			return nil, fmt.Errorf("network %q not found in %v", name, UserNetPath)
+		}
+		selected = append(selected, n)
+	}
+	return selected, nil
 }
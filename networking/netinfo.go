@@ -0,0 +1,119 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package networking
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/coreos/rocket/networking/util"
+)
+
+// NetInfoFile is the file, relative to the container directory, recording
+// the networks the container was attached to.
+const NetInfoFile = "net-info.json"
+
+// NetInfo describes the networking set up for a container.
+type NetInfo struct {
+	// Host tells the container shares the network stack of the host, it
+	// has no network namespace or nets of its own then
+	Host bool `json:"host,omitempty"`
+	// NetNS is the path the container's network namespace is bound to
+	NetNS string          `json:"netNS"`
+	Nets  []NetAttachment `json:"nets"`
+	// Ports are the ports forwarded from the host to the container
+	Ports []PortForward `json:"ports,omitempty"`
+	// Detached tells the container was detached from Nets, which are
+	// only kept as a record, the plugins were run for DEL
+	Detached bool `json:"detached,omitempty"`
+}
+
+// NetAttachment describes a network a container is attached to.
+type NetAttachment struct {
+	NetName string `json:"netName"`
+	NetType string `json:"netType"`
+	IfName  string `json:"ifName"`
+	// IP is the address of the container on the network, in CIDR notation
+	IP string `json:"ip"`
+	// IP6 is the IPv6 address of the container on dual-stack networks
+	IP6 string `json:"ip6,omitempty"`
+	// IPMasq tells the traffic of the container leaving the network is
+	// masqueraded, until the network is torn down
+	IPMasq bool `json:"ipMasq,omitempty"`
+	// DNS holds the resolver settings returned by the network's plugin
+	DNS *util.DNS `json:"dns,omitempty"`
+	// Conf is the configuration of the network the plugin was run with,
+	// and Args the arguments, so that it can be run again to detach the
+	// container whatever became of the configuration files
+	Conf json.RawMessage `json:"conf,omitempty"`
+	Args string          `json:"args,omitempty"`
+}
+
+func saveNetInfo(root, netns string, nets []activeNet, ports []PortForward, detached bool) error {
+	ni := NetInfo{
+		NetNS:    netns,
+		Ports:    ports,
+		Detached: detached,
+	}
+	for _, an := range nets {
+		na := NetAttachment{
+			NetName: an.Name,
+			NetType: an.Type,
+			IfName:  an.ifName,
+			IP:      an.ipn.String(),
+			IPMasq:  an.IPMasq,
+			Conf:    an.conf,
+			Args:    an.args,
+		}
+		if an.ipn6 != nil {
+			na.IP6 = an.ipn6.String()
+		}
+		if !an.dns.Empty() {
+			dns := an.dns
+			na.DNS = &dns
+		}
+		ni.Nets = append(ni.Nets, na)
+	}
+	return writeNetInfo(root, &ni)
+}
+
+// SaveHostNetInfo records in the container directory root that the
+// container shares the network stack of the host.
+func SaveHostNetInfo(root string) error {
+	return writeNetInfo(root, &NetInfo{Host: true})
+}
+
+func writeNetInfo(root string, ni *NetInfo) error {
+	b, err := json.Marshal(ni)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(root, NetInfoFile), b, 0644)
+}
+
+// LoadNetInfo loads the networking info recorded in the container
+// directory cdir.
+func LoadNetInfo(cdir string) (*NetInfo, error) {
+	b, err := ioutil.ReadFile(filepath.Join(cdir, NetInfoFile))
+	if err != nil {
+		return nil, err
+	}
+	var ni NetInfo
+	if err := json.Unmarshal(b, &ni); err != nil {
+		return nil, err
+	}
+	return &ni, nil
+}
@@ -0,0 +1,109 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package networking
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/appc/spec/schema/types"
+	rktpath "github.com/coreos/rocket/path"
+)
+
+func TestHostNetInfo(t *testing.T) {
+	dir, err := ioutil.TempDir("", "netinfo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := SaveHostNetInfo(dir); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ni, err := LoadNetInfo(dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !ni.Host || ni.NetNS != "" || len(ni.Nets) != 0 {
+		t.Errorf("expected host networking, got %+v", ni)
+	}
+
+	// nothing to tear down
+	if err := TeardownIPMasq(dir); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := TeardownPortForwards(dir); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestTeardownNets(t *testing.T) {
+	dir, err := ioutil.TempDir("", "netinfo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	pd := filepath.Join(rktpath.Stage1RootfsPath(dir), BuiltinNetPluginsPath)
+	if err := os.MkdirAll(pd, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(UserNetPluginsPath, "recorder")); err == nil {
+		t.Skip("plugin recorder installed on the host")
+	}
+	plugin := `#!/bin/sh
+[ "$CNI_COMMAND" = VERSION ] && exec echo '{"cniVersion": "0.2.0", "supportedVersions": ["0.2.0"]}'
+echo "$CNI_COMMAND $CNI_IFNAME $CNI_ARGS $(cat)" >> "$0.log"
+`
+	if err := ioutil.WriteFile(filepath.Join(pd, "recorder"), []byte(plugin), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	ni := &NetInfo{
+		NetNS: "/nonexistent/ns/net",
+		Nets: []NetAttachment{
+			{NetName: "old", NetType: "recorder", IfName: "eth0", IP: "10.1.2.3/24"},
+			{NetName: "backend", NetType: "recorder", IfName: "eth1", IP: "10.2.2.3/24", Args: "IP=10.2.2.3",
+				Conf: []byte(`{"name":"backend","type":"recorder"}`)},
+		},
+	}
+	if err := writeNetInfo(dir, ni); err != nil {
+		t.Fatal(err)
+	}
+	uuid, err := types.NewUUID("6733c35b-3b5f-4b4c-a67b-1f3a5d6e1c2a")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := TeardownNets(dir, *uuid); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	b, err := ioutil.ReadFile(filepath.Join(pd, "recorder.log"))
+	if err != nil {
+		t.Fatalf("plugin not run: %v", err)
+	}
+	// the net recorded without its configuration is left alone, the other
+	// one torn down once
+	if want := "DEL eth1 IP=10.2.2.3 {\"name\":\"backend\",\"type\":\"recorder\"}\n"; string(b) != want {
+		t.Errorf("got plugin runs %q, want %q", b, want)
+	}
+	if ni, err = LoadNetInfo(dir); err != nil || !ni.Detached || len(ni.Nets) != 2 {
+		t.Errorf("got %+v, %v, want the nets recorded as detached", ni, err)
+	}
+}
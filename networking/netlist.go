@@ -0,0 +1,118 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package networking
+
+import (
+	"fmt"
+	"strings"
+)
+
+// NetList implements the flag.Value interface to select the networks a
+// container is attached to. Given without a value, as a boolean flag, it
+// selects all the configured networks; otherwise it takes a comma-separated
+// list of network names. A name may be followed by a colon and arguments
+// for the network's plugin, as semicolon-separated KEY=VALUE pairs, e.g.
+// "backend:IP=10.1.2.3".
+type NetList struct {
+	enabled bool
+	names   []string
+	args    map[string]string
+}
+
+func (l *NetList) Set(s string) error {
+	switch s {
+	case "true":
+		*l = NetList{enabled: true}
+		return nil
+	case "false":
+		*l = NetList{}
+		return nil
+	}
+	nl := NetList{enabled: true}
+	seen := make(map[string]bool)
+	for _, e := range strings.Split(s, ",") {
+		n, args := e, ""
+		if i := strings.Index(e, ":"); i >= 0 {
+			n, args = e[:i], e[i+1:]
+			if err := checkPluginArgs(args); err != nil {
+				return fmt.Errorf("network %q: %v", n, err)
+			}
+		}
+		if n == "" {
+			return fmt.Errorf("empty network name in %q", s)
+		}
+		if seen[n] {
+			return fmt.Errorf("network %q given more than once", n)
+		}
+		seen[n] = true
+		nl.names = append(nl.names, n)
+		if args != "" {
+			if nl.args == nil {
+				nl.args = make(map[string]string)
+			}
+			nl.args[n] = args
+		}
+	}
+	*l = nl
+	return nil
+}
+
+// checkPluginArgs checks args are semicolon-separated KEY=VALUE pairs
+func checkPluginArgs(args string) error {
+	for _, kv := range strings.Split(args, ";") {
+		if i := strings.Index(kv, "="); i <= 0 {
+			return fmt.Errorf("invalid argument %q, want KEY=VALUE", kv)
+		}
+	}
+	return nil
+}
+
+func (l *NetList) String() string {
+	if !l.enabled {
+		return "false"
+	}
+	if l.names == nil {
+		return "true"
+	}
+	entries := make([]string, len(l.names))
+	for i, n := range l.names {
+		entries[i] = n
+		if args := l.args[n]; args != "" {
+			entries[i] += ":" + args
+		}
+	}
+	return strings.Join(entries, ",")
+}
+
+// IsBoolFlag makes the flag package accept the flag without a value.
+func (l *NetList) IsBoolFlag() bool {
+	return true
+}
+
+// Enabled reports whether the container gets a private network stack.
+func (l *NetList) Enabled() bool {
+	return l.enabled
+}
+
+// Names returns the names of the selected networks, nil meaning all of them.
+func (l *NetList) Names() []string {
+	return l.names
+}
+
+// Args returns the plugin arguments given for the selected networks, by
+// network name.
+func (l *NetList) Args() map[string]string {
+	return l.args
+}
@@ -0,0 +1,92 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package networking
+
+import (
+	"flag"
+	"io/ioutil"
+	"reflect"
+	"testing"
+
+	"github.com/coreos/rocket/networking/util"
+)
+
+func TestNetList(t *testing.T) {
+	tests := []struct {
+		args    []string
+		enabled bool
+		names   []string
+		netArgs map[string]string
+		err     bool
+	}{
+		{nil, false, nil, nil, false},
+		{[]string{"--private-net"}, true, nil, nil, false},
+		{[]string{"--private-net=false"}, false, nil, nil, false},
+		{[]string{"--private-net=default"}, true, []string{"default"}, nil, false},
+		{[]string{"--private-net=default,backend"}, true, []string{"default", "backend"}, nil, false},
+		{[]string{"--private-net=default,backend:IP=10.1.2.3"}, true, []string{"default", "backend"}, map[string]string{"backend": "IP=10.1.2.3"}, false},
+		{[]string{"--private-net=backend:IP=10.1.2.3;FOO=bar"}, true, []string{"backend"}, map[string]string{"backend": "IP=10.1.2.3;FOO=bar"}, false},
+		{[]string{"--private-net=default,"}, false, nil, nil, true},
+		{[]string{"--private-net=default,default"}, false, nil, nil, true},
+		{[]string{"--private-net=backend:10.1.2.3"}, false, nil, nil, true},
+		{[]string{"--private-net=:IP=10.1.2.3"}, false, nil, nil, true},
+	}
+	for i, tt := range tests {
+		var l NetList
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		fs.SetOutput(ioutil.Discard)
+		fs.Var(&l, "private-net", "")
+		err := fs.Parse(tt.args)
+		if (err != nil) != tt.err {
+			t.Errorf("#%d: got err %v, want err %t", i, err, tt.err)
+			continue
+		}
+		if tt.err {
+			continue
+		}
+		if l.Enabled() != tt.enabled || !reflect.DeepEqual(l.Names(), tt.names) || !reflect.DeepEqual(l.Args(), tt.netArgs) {
+			t.Errorf("#%d: got %t %v %v, want %t %v %v", i, l.Enabled(), l.Names(), l.Args(), tt.enabled, tt.names, tt.netArgs)
+		}
+		// the flag is passed on to stage1 as it was given
+		var l2 NetList
+		if err := l2.Set(l.String()); err != nil || !reflect.DeepEqual(l2, l) {
+			t.Errorf("#%d: %q doesn't round-trip: %v %v", i, l.String(), l2, err)
+		}
+	}
+}
+
+func TestSelectNets(t *testing.T) {
+	mkNet := func(name, typ string) Net {
+		return Net{Net: util.Net{Name: name, Type: typ}}
+	}
+	nets := []Net{
+		mkNet("backend", "bridge"),
+		mkNet("default", "macvlan"),
+		mkNet("default", "veth"),
+	}
+
+	got, err := selectNets(nets, []string{"default", "backend"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []Net{mkNet("default", "macvlan"), mkNet("backend", "bridge")}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	if _, err := selectNets(nets, []string{"frontend"}); err == nil {
+		t.Errorf("expected error selecting unknown net")
+	}
+}
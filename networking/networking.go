@@ -20,12 +20,14 @@ import (
 	"net"
 	"os"
 	"path/filepath"
+	"strings"
 	"syscall"
 
 	"github.com/appc/spec/schema/types"
 	"github.com/coreos/rocket/Godeps/_workspace/src/github.com/vishvananda/netlink"
 
 	"github.com/coreos/rocket/networking/util"
+	"github.com/coreos/rocket/pkg/trace"
 )
 
 const (
@@ -37,36 +39,56 @@ type activeNet struct {
 	Net
 	ifName string
 	ipn    *net.IPNet
+	// ipn6 is the IPv6 address on dual-stack networks
+	ipn6 *net.IPNet
+	// dns holds the resolver settings returned by the plugin
+	dns util.DNS
+}
+
+// addrs returns the addresses of the container on the net
+func (an *activeNet) addrs() []*net.IPNet {
+	if an.ipn6 == nil {
+		return []*net.IPNet{an.ipn}
+	}
+	return []*net.IPNet{an.ipn, an.ipn6}
 }
 
 // "base" struct that's populated from the beginning
 // describing the environment in which the container
 // is running in
 type containerEnv struct {
-	rktRoot    string
-	contID     types.UUID
+	rktRoot string
+	contID  types.UUID
 }
 
 // Networking describes the networking details of a container.
 type Networking struct {
 	containerEnv
 
-	MetadataIP net.IP
+	// MetadataIP is the address of the container on its default net,
+	// MetadataNet, the one the metadata service sees its apps connect from
+	MetadataIP  net.IP
+	MetadataNet string
 
 	contID     types.UUID
 	hostNS     *os.File
 	contNS     *os.File
 	contNSPath string
 	nets       []activeNet
+	ports      []PortForward
+	proxies    []net.Listener
 }
 
-// Setup produces a Networking object for a given container ID.
-func Setup(rktRoot string, contID types.UUID) (*Networking, error) {
+// Setup produces a Networking object for a given container ID, attaching
+// the container to the nets named in netNames or all of them if empty.
+// netArgs holds, by net name, arguments passed on to the nets' plugins.
+// The ADD of each net is traced as a child of span, if not nil.
+func Setup(rktRoot string, contID types.UUID, netNames []string, netArgs map[string]string, span *trace.Span) (*Networking, error) {
 	var err error
 	n := Networking{
 		containerEnv: containerEnv{
 			rktRoot: rktRoot,
-			contID: contID,
+			contID:  contID,
 		},
 	}
 
@@ -82,7 +104,10 @@ func Setup(rktRoot string, contID types.UUID) (*Networking, error) {
 	}
 	// we're in contNS!
 
-	contNSPath := filepath.Join("/var/lib/rkt/containers", contID.String(), "ns")
+	contNSPath, err := nsDir(rktRoot)
+	if err != nil {
+		return nil, err
+	}
 	if err = bindMountFile(selfNetNS, contNSPath, "net"); err != nil {
 		return nil, err
 	}
@@ -92,13 +117,16 @@ func Setup(rktRoot string, contID types.UUID) (*Networking, error) {
 		return nil, fmt.Errorf("error loading plugin definitions: %v", err)
 	}
 
-	nets, err := n.loadNets()
+	nets, err := n.loadNets(netNames)
 	if err != nil {
 		return nil, fmt.Errorf("error loading network definitions: %v", err)
 	}
+	for i := range nets {
+		nets[i].args = netArgs[nets[i].Name]
+	}
 
 	err = withNetNS(n.contNS, n.hostNS, func() error {
-		n.nets, err = n.setupNets(n.contNSPath, nets)
+		n.nets, err = n.setupNets(n.contNSPath, nets, span)
 		return err
 	})
 	if err != nil {
@@ -109,12 +137,133 @@ func Setup(rktRoot string, contID types.UUID) (*Networking, error) {
 		return nil, fmt.Errorf("no nets successfully setup")
 	}
 
+	if err = saveNetInfo(rktRoot, n.contNSPath, n.nets, nil, false); err != nil {
+		return nil, fmt.Errorf("error saving network info: %v", err)
+	}
+
+	// last net is the default
+	n.MetadataIP = n.nets[len(n.nets)-1].ipn.IP
+	n.MetadataNet = n.nets[len(n.nets)-1].Name
+
+	return &n, nil
+}
+
+// Load produces a Networking object for a container whose networking was
+// set up by Setup beforehand, e.g. when the container was prepared. The
+// container directory rktRoot may have moved since.
+func Load(rktRoot string, contID types.UUID) (*Networking, error) {
+	var err error
+	n := Networking{
+		containerEnv: containerEnv{
+			rktRoot: rktRoot,
+			contID:  contID,
+		},
+	}
+
+	defer func() {
+		if err != nil {
+			n.closeNS()
+		}
+	}()
+
+	ni, err := LoadNetInfo(rktRoot)
+	if err != nil {
+		return nil, fmt.Errorf("error loading network info: %v", err)
+	}
+	if len(ni.Nets) == 0 {
+		err = fmt.Errorf("no nets were set up")
+		return nil, err
+	}
+
+	contNSPath, err := nsDir(rktRoot)
+	if err != nil {
+		return nil, err
+	}
+	n.contNSPath = filepath.Join(contNSPath, "net")
+	if n.hostNS, err = os.Open(selfNetNS); err != nil {
+		return nil, err
+	}
+	if n.contNS, err = os.Open(n.contNSPath); err != nil {
+		return nil, err
+	}
+
+	// the nets are loaded from the configuration recorded with them, or
+	// from the configuration files if they were recorded by an earlier
+	// version
+	var names []string
+	for _, na := range ni.Nets {
+		if na.Conf == nil {
+			names = append(names, na.NetName)
+		}
+	}
+	var nets []Net
+	if len(names) > 0 {
+		if nets, err = n.loadNets(names); err != nil {
+			return nil, fmt.Errorf("error loading network definitions: %v", err)
+		}
+	}
+	for _, na := range ni.Nets {
+		var nt Net
+		if na.Conf != nil {
+			if nt, err = parseNet(na.Conf); err != nil {
+				return nil, fmt.Errorf("error parsing configuration of net %q: %v", na.NetName, err)
+			}
+			nt.IPMasq = na.IPMasq
+		} else {
+			nt, nets = nets[0], nets[1:]
+		}
+		nt.args = na.Args
+		an := activeNet{
+			Net:    nt,
+			ifName: na.IfName,
+		}
+		if an.ipn, err = util.ParseCIDR(na.IP); err != nil {
+			return nil, fmt.Errorf("error parsing IP of net %q: %v", na.NetName, err)
+		}
+		if na.IP6 != "" {
+			if an.ipn6, err = util.ParseCIDR(na.IP6); err != nil {
+				return nil, fmt.Errorf("error parsing IPv6 of net %q: %v", na.NetName, err)
+			}
+		}
+		if na.DNS != nil {
+			an.dns = *na.DNS
+		}
+		n.nets = append(n.nets, an)
+	}
+
+	n.ports = ni.Ports
+
+	// the namespace path changes if the container directory moved
+	if err = saveNetInfo(rktRoot, n.contNSPath, n.nets, n.ports, false); err != nil {
+		return nil, fmt.Errorf("error saving network info: %v", err)
+	}
+
 	// last net is the default
 	n.MetadataIP = n.nets[len(n.nets)-1].ipn.IP
+	n.MetadataNet = n.nets[len(n.nets)-1].Name
 
 	return &n, nil
 }
 
+// nsDir returns the directory namespaces of the container in rktRoot are
+// bound to.
+func nsDir(rktRoot string) (string, error) {
+	root, err := filepath.Abs(rktRoot)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(root, "ns"), nil
+}
+
+func (n *Networking) closeNS() {
+	if n.hostNS != nil {
+		n.hostNS.Close()
+	}
+	if n.contNS != nil {
+		n.contNS.Close()
+	}
+}
+
 // Teardown cleans up a produced Networking object.
 func (n *Networking) Teardown() {
 	// Teardown everything in reverse order of setup.
@@ -132,7 +281,24 @@ func (n *Networking) Teardown() {
 		return
 	}
 
-	n.teardownNets(n.contNSPath, n.nets)
+	if len(n.ports) > 0 {
+		n.teardownPorts()
+		if err := saveNetInfo(n.rktRoot, n.contNSPath, n.nets, nil, false); err != nil {
+			log.Printf("Error saving network info: %v", err)
+		}
+	}
+
+	if len(n.nets) > 0 {
+		n.teardownNets(n.contNSPath, n.nets)
+		// the nets and their masquerading rules are gone, gc mustn't
+		// tear them down again
+		for i := range n.nets {
+			n.nets[i].IPMasq = false
+		}
+		if err := saveNetInfo(n.rktRoot, n.contNSPath, n.nets, nil, true); err != nil {
+			log.Printf("Error saving network info: %v", err)
+		}
+	}
 
 	if n.contNSPath == "" {
 		return
@@ -143,6 +309,85 @@ func (n *Networking) Teardown() {
 	}
 }
 
+// TeardownNets detaches the container in the directory cdir from the nets
+// recorded with it if it exited without being detached, e.g. because stage1
+// died: the plugins of the nets are run for DEL, deleting the interfaces of
+// the container and releasing its addresses. Nets recorded without their
+// configuration, by earlier versions, are left alone. The plugins are only
+// run once, whether they succeed or not.
+func TeardownNets(cdir string, contID types.UUID) error {
+	ni, err := LoadNetInfo(cdir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if ni.Host || ni.Detached || len(ni.Nets) == 0 {
+		return nil
+	}
+
+	// the container directory may have moved since the nets were set up
+	nsd, err := nsDir(cdir)
+	if err != nil {
+		return err
+	}
+	netns := filepath.Join(nsd, "net")
+	e := containerEnv{
+		rktRoot: cdir,
+		contID:  contID,
+	}
+	var first error
+	for i := len(ni.Nets) - 1; i >= 0; i-- {
+		na := ni.Nets[i]
+		if na.Conf == nil {
+			continue
+		}
+		nt, err := parseNet(na.Conf)
+		if err == nil {
+			err = e.netPluginDel(&nt, netns, na.Args, na.IfName)
+		}
+		if err != nil && first == nil {
+			first = fmt.Errorf("error detaching from net %q: %v", na.NetName, err)
+		}
+	}
+
+	ni.Detached = true
+	if err := writeNetInfo(cdir, ni); err != nil && first == nil {
+		first = err
+	}
+	return first
+}
+
+// TeardownHostLinks deletes the host links of a container which exited
+// without tearing down its networks, i.e. those tagged as owned by it. Links
+// named as the container's but not tagged as such are not rkt's to delete
+// and are left alone.
+func TeardownHostLinks(contID string) error {
+	links, err := netlink.LinkList()
+	if err != nil {
+		return fmt.Errorf("error listing links: %v", err)
+	}
+	var first error
+	for _, l := range links {
+		name := l.Attrs().Name
+		alias, _ := util.LinkAlias(name)
+		owner, _, ok := util.ParseOwnerAlias(alias)
+		switch {
+		case ok && owner == contID:
+			err = netlink.LinkDel(l)
+		case strings.HasPrefix(name, util.HostLinkPrefix(contID)):
+			err = fmt.Errorf("refusing to delete %q: not tagged as owned by the container", name)
+		default:
+			continue
+		}
+		if err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
 // sets up new netns with just lo
 func basicNetNS() (hostNS, contNS *os.File, err error) {
 	hostNS, contNS, err = newNetNS()
@@ -171,7 +416,7 @@ func (n *Networking) EnterContNS() error {
 	return util.SetNS(n.contNS, syscall.CLONE_NEWNET)
 }
 
-func (e *containerEnv) setupNets(netns string, nets []Net) ([]activeNet, error) {
+func (e *containerEnv) setupNets(netns string, nets []Net, span *trace.Span) ([]activeNet, error) {
 	var err error
 
 	active := []activeNet{}
@@ -184,11 +429,20 @@ func (e *containerEnv) setupNets(netns string, nets []Net) ([]activeNet, error)
 
 		log.Printf("Executing net-plugin %v", nt.Type)
 
-		an.ipn, err = e.netPluginAdd(&nt, netns, nt.args, an.ifName)
+		var dns *util.DNS
+		add := span.Child("network ADD " + nt.Name)
+		an.ipn, an.ipn6, dns, err = e.netPluginAdd(&nt, netns, nt.args, an.ifName)
+		add.Finish()
 		if err != nil {
 			err = fmt.Errorf("error adding network %q: %v", nt.Name, err)
 			break
 		}
+		an.dns = *dns
+		if err = setupNetMasq(&an); err != nil {
+			e.netPluginDel(&nt, netns, nt.args, an.ifName)
+			err = fmt.Errorf("error masquerading network %q: %v", nt.Name, err)
+			break
+		}
 
 		active = append(active, an)
 	}
@@ -206,6 +460,9 @@ func (e *containerEnv) setupNets(netns string, nets []Net) ([]activeNet, error)
 func (e *containerEnv) teardownNets(netns string, nets []activeNet) {
 	for i := len(nets) - 1; i >= 0; i-- {
 		nt := nets[i]
+		if err := teardownNetMasq(&nt); err != nil {
+			log.Printf("Error removing masquerading of %q: %v", nt.Name, err)
+		}
 		err := e.netPluginDel(&nt.Net, netns, nt.args, nt.ifName)
 		if err != nil {
 			log.Printf("Error deleting %q: %v", nt.Name, err)
@@ -2,7 +2,6 @@ package main
 
 import (
 	"fmt"
-	"log"
 	"net"
 	"os"
 	"runtime"
@@ -21,6 +20,12 @@ type netConf struct {
 	util.Net
 	BrName string `json:"brName"`
 	IsGW   bool   `json:"isGW"`
+	// MTU, if set, is the MTU of the bridge and of the veths
+	MTU int `json:"mtu"`
+
+	// names of the above in CNI network configurations
+	Bridge    string `json:"bridge"`
+	IsGateway bool   `json:"isGateway"`
 }
 
 func init() {
@@ -30,8 +35,8 @@ func init() {
 	runtime.LockOSThread()
 }
 
-func ensureBridgeAddr(br *netlink.Bridge, ipn *net.IPNet) error {
-	addrs, err := netlink.AddrList(br, syscall.AF_INET)
+func ensureBridgeAddr(br *netlink.Bridge, family int, ipn *net.IPNet) error {
+	addrs, err := netlink.AddrList(br, family)
 	if err != nil && err != syscall.ENOENT {
 		return fmt.Errorf("could not get list of IP addresses: %v", err)
 	}
@@ -45,7 +50,10 @@ func ensureBridgeAddr(br *netlink.Bridge, ipn *net.IPNet) error {
 				return nil
 			}
 		}
-		return fmt.Errorf("%q already has an IP address different from %v", br.Name, ipn.String())
+		// link-local IPv6 addresses are configured by the kernel
+		if family != syscall.AF_INET6 || hasGlobalAddr(addrs) {
+			return fmt.Errorf("%q already has an IP address different from %v", br.Name, ipn.String())
+		}
 	}
 
 	addr := &netlink.Addr{IPNet: ipn, Label: ""}
@@ -55,6 +63,15 @@ func ensureBridgeAddr(br *netlink.Bridge, ipn *net.IPNet) error {
 	return nil
 }
 
+func hasGlobalAddr(addrs []netlink.Addr) bool {
+	for _, a := range addrs {
+		if !a.IP.IsLinkLocalUnicast() {
+			return true
+		}
+	}
+	return false
+}
+
 func bridgeByName(name string) (*netlink.Bridge, error) {
 	l, err := netlink.LinkByName(name)
 	if err != nil {
@@ -67,7 +84,7 @@ func bridgeByName(name string) (*netlink.Bridge, error) {
 	return br, nil
 }
 
-func ensureBridge(brName string, ipn *net.IPNet) (*netlink.Bridge, error) {
+func ensureBridge(brName string, mtu int, ipn, ipn6 *net.IPNet) (*netlink.Bridge, error) {
 	br := &netlink.Bridge{
 		LinkAttrs: netlink.LinkAttrs{
 			Name: brName,
@@ -86,27 +103,58 @@ func ensureBridge(brName string, ipn *net.IPNet) (*netlink.Bridge, error) {
 		}
 	}
 
+	if mtu > 0 && br.Attrs().MTU != mtu {
+		if err := netlink.LinkSetMTU(br, mtu); err != nil {
+			return nil, fmt.Errorf("could not set MTU of %q: %v", brName, err)
+		}
+	}
+
 	if err := netlink.LinkSetUp(br); err != nil {
 		return nil, err
 	}
 
 	if ipn != nil {
-		return br, ensureBridgeAddr(br, ipn)
+		if err := ensureBridgeAddr(br, syscall.AF_INET, ipn); err != nil {
+			return nil, err
+		}
+	}
+	if ipn6 != nil {
+		if err := ensureBridgeAddr(br, syscall.AF_INET6, ipn6); err != nil {
+			return nil, err
+		}
 	}
 
 	return br, nil
 }
 
-func setupVeth(contID types.UUID, netns string, br *netlink.Bridge, ipn *net.IPNet, ifName string) error {
+func setupVeth(contID types.UUID, netName, netns string, br *netlink.Bridge, mtu int, ipn, ipn6 *net.IPNet, ifName string, gw, gw6 net.IP, routes []string) error {
 	var hostVethName string
 
 	err := util.WithNetNSPath(netns, func(hostNS *os.File) error {
 		// create the veth pair in the container and move host end into host netns
-		hostVeth, _, err := util.SetupVeth(contID.String(), ifName, ipn, hostNS)
+		hostVeth, contVeth, err := util.SetupVeth(contID.String(), netName, ifName, ipn, hostNS)
 		if err != nil {
 			return err
 		}
 
+		if mtu > 0 {
+			if err = netlink.LinkSetMTU(contVeth, mtu); err != nil {
+				return fmt.Errorf("failed to set MTU of %q: %v", ifName, err)
+			}
+		}
+
+		if ipn6 != nil {
+			addr := &netlink.Addr{IPNet: ipn6, Label: ""}
+			if err = netlink.AddrAdd(contVeth, addr); err != nil {
+				return fmt.Errorf("failed to add IPv6 addr to veth: %v", err)
+			}
+		}
+
+		// routes go through the bridge, so only if it's the gateway
+		if err = util.AddRoutes(routes, gw, gw6, contVeth); err != nil {
+			return err
+		}
+
 		hostVethName = hostVeth.Attrs().Name
 		return nil
 	})
@@ -120,6 +168,12 @@ func setupVeth(contID types.UUID, netns string, br *netlink.Bridge, ipn *net.IPN
 		return fmt.Errorf("failed to lookup %q: %v", hostVethName, err)
 	}
 
+	if mtu > 0 {
+		if err = netlink.LinkSetMTU(hostVeth, mtu); err != nil {
+			return fmt.Errorf("failed to set MTU of %q: %v", hostVethName, err)
+		}
+	}
+
 	// connect host veth end to the bridge
 	if err = netlink.LinkSetMaster(hostVeth, br); err != nil {
 		return fmt.Errorf("failed to connect %q to bridge %v: %v", hostVethName, br.Attrs().Name, err)
@@ -128,87 +182,130 @@ func setupVeth(contID types.UUID, netns string, br *netlink.Bridge, ipn *net.IPN
 	return nil
 }
 
-func cmdAdd(contID, netns, netCfg, ifName string) error {
-	cid, err := types.NewUUID(contID)
-	if err != nil {
-		return fmt.Errorf("error parsing ContainerID: %v", err)
+func loadConf(netCfg []byte) (*netConf, error) {
+	conf := &netConf{}
+	if err := util.ParseNet(netCfg, conf); err != nil {
+		return nil, fmt.Errorf("failed to parse network configuration: %v", err)
+	}
+	if conf.BrName == "" {
+		conf.BrName = conf.Bridge
 	}
+	if conf.BrName == "" {
+		conf.BrName = defaultBrName
+	}
+	conf.IsGW = conf.IsGW || conf.IsGateway
+	return conf, nil
+}
 
-	conf := netConf{
-		BrName: defaultBrName,
+func cmdAdd(args *util.CmdArgs) (*util.Result, error) {
+	cid, err := types.NewUUID(args.ContainerID)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing ContainerID: %v", err)
 	}
-	if err := util.LoadNet(netCfg, &conf); err != nil {
-		return fmt.Errorf("failed to load %q: %v", netCfg, err)
+
+	conf, err := loadConf(args.StdinData)
+	if err != nil {
+		return nil, err
 	}
 
-	ipn, gw, err := ipam.AllocIP(*cid, netCfg, ifName, "")
+	a, err := allocIPs(*cid, conf, args)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	ipn, ipn6, gw, gw6, routes := a.IPN, a.IPN6, a.GW, a.GW6, a.Routes
 
-	var gwn *net.IPNet
+	var gwn, gwn6 *net.IPNet
 	if conf.IsGW && gw != nil {
 		gwn = &net.IPNet{
 			IP:   gw,
 			Mask: ipn.Mask,
 		}
+	} else {
+		gw = nil
+	}
+	if conf.IsGW && gw6 != nil {
+		gwn6 = &net.IPNet{
+			IP:   gw6,
+			Mask: ipn6.Mask,
+		}
+	} else {
+		gw6 = nil
 	}
 
 	// create bridge if necessary
-	br, err := ensureBridge(conf.BrName, gwn)
+	br, err := ensureBridge(conf.BrName, conf.MTU, gwn, gwn6)
 	if err != nil {
-		return fmt.Errorf("failed to create bridge %q: %v", conf.BrName, err)
+		ipam.Release(&conf.Net, *cid, args)
+		return nil, fmt.Errorf("failed to create bridge %q: %v", conf.BrName, err)
 	}
 
-	if err = setupVeth(*cid, netns, br, ipn, ifName); err != nil {
-		return err
+	// routes go through the bridge, so only if it's the gateway
+	if !conf.IsGW {
+		routes = nil
 	}
-
-	// print to stdout the assigned IP for rkt
-	// TODO(eyakubovich): this will need to be JSON per latest proposal
-	if _, err = fmt.Print(ipn.String()); err != nil {
-		return err
+	if err = setupVeth(*cid, conf.Name, args.Netns, br, conf.MTU, ipn, ipn6, args.IfName, gw, gw6, routes); err != nil {
+		ipam.Release(&conf.Net, *cid, args)
+		return nil, err
 	}
 
-	return nil
-}
-
-func cmdDel(contID, netns, netConf, ifName string) error {
-	return util.WithNetNSPath(netns, func(hostNS *os.File) error {
-		return util.DelLinkByName(ifName)
-	})
+	return util.NewResult(ipn, ipn6, gw, gw6, routes, a.DNS)
 }
 
-func main() {
-	var err error
-
-	cmd := os.Getenv("RKT_NETPLUGIN_COMMAND")
-	contID := os.Getenv("RKT_NETPLUGIN_CONTID")
-	netns := os.Getenv("RKT_NETPLUGIN_NETNS")
-	ifName := os.Getenv("RKT_NETPLUGIN_IFNAME")
-	netConf := os.Getenv("RKT_NETPLUGIN_NETCONF")
-
-	if cmd == "" || contID == "" || netns == "" || ifName == "" || netConf == "" {
-		log.Printf("Required env variable missing")
-		log.Print("Env: ", os.Environ())
-		os.Exit(1)
+// allocIPs allocates the addresses of the container, through the IPAM
+// plugin of the network if it has one.
+func allocIPs(cid types.UUID, conf *netConf, args *util.CmdArgs) (*ipam.Allocation, error) {
+	if ipam.Delegated(&conf.Net) {
+		a, err := ipam.ExecAdd(&conf.Net, args)
+		if err != nil {
+			return nil, err
+		}
+		if !conf.DNS.Empty() {
+			a.DNS = conf.DNS
+		}
+		return a, nil
 	}
 
-	switch cmd {
-	case "ADD":
-		err = cmdAdd(contID, netns, netConf, ifName)
+	ipn, gw, err := ipam.AllocIP(cid, args.StdinData, args.IfName, args.Args)
+	if err != nil {
+		return nil, err
+	}
+	ipn6, gw6, err := ipam.AllocIP6(cid, args.StdinData, args.IfName, args.Args)
+	if err != nil {
+		ipam.DeallocIP(cid, args.StdinData, args.IfName, ipn)
+		return nil, err
+	}
+	return &ipam.Allocation{
+		IPN:    ipn,
+		IPN6:   ipn6,
+		GW:     gw,
+		GW6:    gw6,
+		Routes: conf.Routes,
+		DNS:    conf.DNS,
+	}, nil
+}
 
-	case "DEL":
-		err = cmdDel(contID, netns, netConf, ifName)
+func cmdDel(args *util.CmdArgs) error {
+	cid, err := types.NewUUID(args.ContainerID)
+	if err != nil {
+		return fmt.Errorf("error parsing ContainerID: %v", err)
+	}
 
-	default:
-		log.Printf("Unknown RKT_NETPLUGIN_COMMAND: %v", cmd)
-		os.Exit(1)
+	conf, err := loadConf(args.StdinData)
+	if err != nil {
+		return err
 	}
 
+	// the IP is released even if the link can't be removed
+	relErr := ipam.Release(&conf.Net, *cid, args)
+	err = util.WithNetNSPath(args.Netns, func(hostNS *os.File) error {
+		return util.DelLinkByName(args.IfName)
+	})
 	if err != nil {
-		log.Printf("%v: %v", os.Args[1], err)
-		os.Exit(1)
+		return err
 	}
+	return relErr
+}
 
+func main() {
+	util.PluginMain(cmdAdd, cmdDel)
 }
@@ -0,0 +1,27 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// The dhcp IPAM plugin gets the address of the container from the DHCP
+// server of the network, through the rkt-dhcp daemon. The interface must
+// be set up and up in the container first.
+package main
+
+import (
+	"github.com/coreos/rocket/networking/ipam"
+	"github.com/coreos/rocket/networking/util"
+)
+
+func main() {
+	util.PluginMain(ipam.PluginAdd, ipam.PluginDel)
+}
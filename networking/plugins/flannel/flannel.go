@@ -0,0 +1,105 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/coreos/rocket/networking/util"
+)
+
+// stateDir holds the configurations of the bridge networks containers were
+// added to, so they are deleted with the same one even if the subnet of
+// the host changed meanwhile
+const stateDir = "/var/lib/rkt/flannel"
+
+// delegatePlugin is the plugin, next to this one, flannel networks are
+// delegated to
+const delegatePlugin = "bridge"
+
+func delegateConfPath(contID, ifName string) string {
+	return filepath.Join(stateDir, contID+"-"+ifName+".json")
+}
+
+// execDelegate runs the delegate plugin for cmd with the network
+// configuration netConf, returning what it printed.
+func execDelegate(cmd string, args *util.CmdArgs, netConf []byte) ([]byte, error) {
+	dargs := *args
+	dargs.StdinData = netConf
+	out, err := util.ExecPlugin(filepath.Join(filepath.Dir(os.Args[0]), delegatePlugin), cmd, &dargs)
+	if err != nil {
+		return nil, fmt.Errorf("error running %s plugin: %v", delegatePlugin, err)
+	}
+	return out, nil
+}
+
+func cmdAdd(args *util.CmdArgs) (*util.Result, error) {
+	fn := util.FlannelNet{}
+	if err := util.ParseNet(args.StdinData, &fn); err != nil {
+		return nil, fmt.Errorf("failed to parse network configuration: %v", err)
+	}
+	env, err := util.LoadFlannelEnv(fn.SubnetFilePath())
+	if err != nil {
+		return nil, fmt.Errorf("failed to load flannel subnet: %v", err)
+	}
+
+	b, err := json.Marshal(util.FlannelDelegate(&fn, env))
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(stateDir, 0755); err != nil {
+		return nil, err
+	}
+	dp := delegateConfPath(args.ContainerID, args.IfName)
+	if err := ioutil.WriteFile(dp, b, 0644); err != nil {
+		return nil, fmt.Errorf("failed to save delegate configuration: %v", err)
+	}
+
+	out, err := execDelegate("ADD", args, b)
+	if err != nil {
+		os.Remove(dp)
+		return nil, err
+	}
+	r := &util.Result{}
+	if err := json.Unmarshal(out, r); err != nil {
+		execDelegate("DEL", args, b)
+		os.Remove(dp)
+		return nil, fmt.Errorf("error parsing result of %s plugin: %v", delegatePlugin, err)
+	}
+	return r, nil
+}
+
+func cmdDel(args *util.CmdArgs) error {
+	dp := delegateConfPath(args.ContainerID, args.IfName)
+	b, err := ioutil.ReadFile(dp)
+	if os.IsNotExist(err) {
+		// never added
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if _, err := execDelegate("DEL", args, b); err != nil {
+		return err
+	}
+	return os.Remove(dp)
+}
+
+func main() {
+	util.PluginMain(cmdAdd, cmdDel)
+}
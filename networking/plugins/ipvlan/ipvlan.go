@@ -0,0 +1,223 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+
+	"github.com/appc/spec/schema/types"
+	"github.com/coreos/rocket/Godeps/_workspace/src/github.com/vishvananda/netlink"
+
+	"github.com/coreos/rocket/networking/ipam"
+	"github.com/coreos/rocket/networking/util"
+)
+
+type netConf struct {
+	util.Net
+	// Master is the host device the ipvlan device is a sub-interface of
+	Master string `json:"master"`
+	Mode   string `json:"mode"`
+	MTU    int    `json:"mtu"`
+}
+
+func init() {
+	// this ensures that main runs only on main thread (thread group leader).
+	// since namespace ops (unshare, setns) are done for a single thread, we
+	// must ensure that the goroutine does not jump from OS thread to thread
+	runtime.LockOSThread()
+}
+
+func modeFromString(s string) (netlink.IPVlanMode, error) {
+	switch s {
+	case "", "l2":
+		return netlink.IPVLAN_MODE_L2, nil
+	case "l3":
+		return netlink.IPVLAN_MODE_L3, nil
+	default:
+		return 0, fmt.Errorf("unknown ipvlan mode: %q", s)
+	}
+}
+
+func loadConf(netCfg []byte) (*netConf, error) {
+	conf := &netConf{}
+	if err := util.ParseNet(netCfg, conf); err != nil {
+		return nil, fmt.Errorf("failed to parse network configuration: %v", err)
+	}
+	if conf.Master == "" {
+		return nil, fmt.Errorf(`%q: "master" field is required, it names the host device to attach to`, conf.Name)
+	}
+	return conf, nil
+}
+
+// createIpvlan creates the ipvlan device ifName in netns, as a
+// sub-interface of conf.Master.
+func createIpvlan(conf *netConf, mode netlink.IPVlanMode, contID, netns, ifName string) (netlink.Link, error) {
+	m, err := netlink.LinkByName(conf.Master)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lookup master %q: %v", conf.Master, err)
+	}
+
+	// the device is created in the host namespace, where ifName may be
+	// taken, and renamed once in the container's
+	iv := &netlink.IPVlan{
+		LinkAttrs: netlink.LinkAttrs{
+			Name:        util.TempLinkName("ipv", contID, ifName),
+			ParentIndex: m.Attrs().Index,
+		},
+		Mode: mode,
+	}
+	if err := netlink.LinkAdd(iv); err != nil {
+		return nil, fmt.Errorf("failed to create ipvlan: %v", err)
+	}
+	return util.MoveLinkIn(iv, netns, ifName)
+}
+
+func cmdAdd(args *util.CmdArgs) (*util.Result, error) {
+	cid, err := types.NewUUID(args.ContainerID)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing ContainerID: %v", err)
+	}
+
+	conf, err := loadConf(args.StdinData)
+	if err != nil {
+		return nil, err
+	}
+	mode, err := modeFromString(conf.Mode)
+	if err != nil {
+		return nil, err
+	}
+
+	link, err := createIpvlan(conf, mode, args.ContainerID, args.Netns, args.IfName)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := setupLink(*cid, conf, link, args)
+	if err != nil {
+		util.WithNetNSPath(args.Netns, func(hostNS *os.File) error {
+			return util.DelLinkByName(args.IfName)
+		})
+		return nil, err
+	}
+	return r, nil
+}
+
+// setupLink brings link up and configures its IP and routes
+func setupLink(cid types.UUID, conf *netConf, link netlink.Link, args *util.CmdArgs) (*util.Result, error) {
+	netns, ifName := args.Netns, args.IfName
+	err := util.WithNetNSPath(netns, func(hostNS *os.File) error {
+		if conf.MTU > 0 {
+			if err := netlink.LinkSetMTU(link, conf.MTU); err != nil {
+				return fmt.Errorf("failed to set MTU of %q: %v", ifName, err)
+			}
+		}
+		if err := netlink.LinkSetUp(link); err != nil {
+			return fmt.Errorf("failed to set %q up: %v", ifName, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// the IP is allocated once the link is up, it may come from DHCP
+	a, err := allocIP(cid, conf, args)
+	if err != nil {
+		return nil, err
+	}
+	ipn, gw := a.IPN, a.GW
+	if conf.Mode == "l3" {
+		// there are no neighbours in L3 mode, the master routes the
+		// packets itself
+		gw = nil
+	}
+
+	err = util.WithNetNSPath(netns, func(hostNS *os.File) error {
+		addr := &netlink.Addr{IPNet: ipn, Label: ""}
+		if err := netlink.AddrAdd(link, addr); err != nil {
+			return fmt.Errorf("failed to add IP addr to %q: %v", ifName, err)
+		}
+
+		for _, r := range a.Routes {
+			dst, err := util.ParseCIDR(r)
+			if err != nil {
+				return fmt.Errorf("failed to parse route %q: %v", r, err)
+			}
+
+			if err = util.AddRoute(dst, gw, link); err != nil {
+				return fmt.Errorf("failed to add route %q: %v", dst, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		ipam.Release(&conf.Net, cid, args)
+		return nil, err
+	}
+	return util.NewResult(ipn, nil, gw, nil, a.Routes, a.DNS)
+}
+
+// allocIP allocates the IPv4 address of the container, through the IPAM
+// plugin of the network if it has one.
+func allocIP(cid types.UUID, conf *netConf, args *util.CmdArgs) (*ipam.Allocation, error) {
+	if !ipam.Delegated(&conf.Net) {
+		ipn, gw, err := ipam.AllocIPOnLink(cid, args.Netns, args.StdinData, args.IfName, args.Args)
+		if err != nil {
+			return nil, err
+		}
+		return &ipam.Allocation{IPN: ipn, GW: gw, Routes: conf.Routes, DNS: conf.DNS}, nil
+	}
+
+	a, err := ipam.ExecAdd(&conf.Net, args)
+	if err != nil {
+		return nil, err
+	}
+	if a.IPN6 != nil {
+		ipam.ExecDel(&conf.Net, args)
+		return nil, fmt.Errorf("IPv6 addresses are not supported by the %s plugin", conf.Type)
+	}
+	if !conf.DNS.Empty() {
+		a.DNS = conf.DNS
+	}
+	return a, nil
+}
+
+func cmdDel(args *util.CmdArgs) error {
+	cid, err := types.NewUUID(args.ContainerID)
+	if err != nil {
+		return fmt.Errorf("error parsing ContainerID: %v", err)
+	}
+
+	conf, err := loadConf(args.StdinData)
+	if err != nil {
+		return err
+	}
+
+	// the link is removed even if its IP can't be released
+	relErr := ipam.Release(&conf.Net, *cid, args)
+	err = util.WithNetNSPath(args.Netns, func(hostNS *os.File) error {
+		return util.DelLinkByName(args.IfName)
+	})
+	if err != nil {
+		return err
+	}
+	return relErr
+}
+
+func main() {
+	util.PluginMain(cmdAdd, cmdDel)
+}
@@ -16,7 +16,6 @@ package main
 
 import (
 	"fmt"
-	"log"
 	"net"
 	"os"
 	"runtime"
@@ -35,120 +34,195 @@ func init() {
 	runtime.LockOSThread()
 }
 
-func cmdAdd(contID, netns, netConf, ifName, args string) error {
-	var hostVethName, contIPNet string
+// ptpAddrs are the addresses of the two ends of the veth
+type ptpAddrs struct {
+	// host and host6 are the addresses of the host end, the gateways of
+	// the container
+	host, host6 *net.IPNet
+	cont, cont6 *net.IPNet
+	routes      []string
+	dns         util.DNS
+}
 
-	cid, err := types.NewUUID(contID)
+// allocPtP allocates the addresses of both ends of the veth: a /31, and a
+// /127 on dual-stack networks, or, when the network has an IPAM plugin, the
+// addresses it allocates to the container, the gateways going to the host
+// end.
+func allocPtP(cid types.UUID, conf *util.Net, args *util.CmdArgs) (*ptpAddrs, error) {
+	if ipam.Delegated(conf) {
+		a, err := ipam.ExecAdd(conf, args)
+		if err != nil {
+			return nil, err
+		}
+		if a.GW == nil || (a.IPN6 != nil && a.GW6 == nil) {
+			ipam.ExecDel(conf, args)
+			return nil, fmt.Errorf("IPAM plugin %q returned no gateway for the host end of the veth", conf.IPAM.Type)
+		}
+		p := &ptpAddrs{
+			host:   &net.IPNet{IP: a.GW, Mask: net.CIDRMask(32, 32)},
+			cont:   a.IPN,
+			routes: a.Routes,
+			dns:    conf.DNS,
+		}
+		if a.IPN6 != nil {
+			p.host6 = &net.IPNet{IP: a.GW6, Mask: net.CIDRMask(128, 128)}
+			p.cont6 = a.IPN6
+		}
+		if p.dns.Empty() {
+			p.dns = a.DNS
+		}
+		return p, nil
+	}
+
+	ips, err := ipam.AllocPtP(cid, args.StdinData, args.IfName, args.Args)
 	if err != nil {
-		return fmt.Errorf("error parsing ContainerID: %v", err)
+		return nil, err
+	}
+	ips6, err := ipam.AllocPtP6(cid, args.StdinData, args.IfName, args.Args)
+	if err != nil {
+		ipam.DeallocIP(cid, args.StdinData, args.IfName, nil)
+		return nil, err
 	}
 
-	conf := util.Net{}
-	if err := util.LoadNet(netConf, &conf); err != nil {
-		return fmt.Errorf("failed to load %q: %v", netConf, err)
+	p := &ptpAddrs{
+		host:   &net.IPNet{IP: ips[0], Mask: net.CIDRMask(31, 32)},
+		cont:   &net.IPNet{IP: ips[1], Mask: net.CIDRMask(31, 32)},
+		routes: conf.Routes,
+		dns:    conf.DNS,
+	}
+	if ips6[1] != nil {
+		p.host6 = &net.IPNet{IP: ips6[0], Mask: net.CIDRMask(127, 128)}
+		p.cont6 = &net.IPNet{IP: ips6[1], Mask: net.CIDRMask(127, 128)}
+	}
+	return p, nil
+}
+
+// hostRoute returns the route to the single address ip
+func hostRoute(ip net.IP) *net.IPNet {
+	if ip.To4() != nil {
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(32, 32)}
 	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}
+}
+
+func cmdAdd(args *util.CmdArgs) (*util.Result, error) {
+	var hostVethName string
 
-	ips, err := ipam.AllocPtP(*cid, netConf, ifName, args)
+	cid, err := types.NewUUID(args.ContainerID)
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("error parsing ContainerID: %v", err)
 	}
 
-	hostIP, contIP := ips[0], ips[1]
+	conf := util.Net{}
+	if err := util.ParseNet(args.StdinData, &conf); err != nil {
+		return nil, fmt.Errorf("failed to parse network configuration: %v", err)
+	}
 
-	err = util.WithNetNSPath(netns, func(hostNS *os.File) error {
-		entropy := contID + ifName
+	p, err := allocPtP(*cid, &conf, args)
+	if err != nil {
+		return nil, err
+	}
+	delegated := ipam.Delegated(&conf)
 
-		ipn := &net.IPNet{
-			IP:   contIP,
-			Mask: net.CIDRMask(31, 32),
-		}
+	var hostIP6 net.IP
+	if p.host6 != nil {
+		hostIP6 = p.host6.IP
+	}
 
-		hostVeth, contVeth, err := util.SetupVeth(entropy, ifName, ipn, hostNS)
+	err = util.WithNetNSPath(args.Netns, func(hostNS *os.File) error {
+		hostVeth, contVeth, err := util.SetupVeth(args.ContainerID, conf.Name, args.IfName, p.cont, hostNS)
 		if err != nil {
 			return err
 		}
 
-		for _, r := range conf.Routes {
-			dst, err := util.ParseCIDR(r)
-			if err != nil {
-				return fmt.Errorf("failed to parse route %q: %v", r, err)
-			}
+		hostVethName = hostVeth.Attrs().Name
 
-			if err = util.AddRoute(dst, hostIP, contVeth); err != nil {
-				return fmt.Errorf("failed to add route %q: %v", dst, err)
+		if p.cont6 != nil {
+			addr := &netlink.Addr{IPNet: p.cont6, Label: ""}
+			if err = netlink.AddrAdd(contVeth, addr); err != nil {
+				return fmt.Errorf("failed to add IPv6 addr to veth: %v", err)
 			}
 		}
 
-		hostVethName = hostVeth.Attrs().Name
-		contIPNet = ipn.String()
+		if delegated {
+			// the gateways given by the IPAM plugin may not be in the
+			// subnet of the container's addresses
+			for _, h := range []*net.IPNet{p.host, p.host6} {
+				if h == nil {
+					continue
+				}
+				if err = util.AddLinkRoute(hostRoute(h.IP), contVeth); err != nil && !os.IsExist(err) {
+					return fmt.Errorf("failed to add route to gateway: %v", err)
+				}
+			}
+		}
 
-		return nil
+		return util.AddRoutes(p.routes, p.host.IP, hostIP6, contVeth)
 	})
 	if err != nil {
-		return err
+		ipam.Release(&conf, *cid, args)
+		return nil, err
 	}
 
 	// hostVeth moved namespaces and may have a new ifindex
 	hostVeth, err := netlink.LinkByName(hostVethName)
 	if err != nil {
-		return fmt.Errorf("failed to lookup %q: %v", hostVethName, err)
+		return nil, fmt.Errorf("failed to lookup %q: %v", hostVethName, err)
 	}
 
-	ipn := &net.IPNet{
-		IP:   hostIP,
-		Mask: net.CIDRMask(31, 32),
-	}
-	addr := &netlink.Addr{IPNet: ipn, Label: ""}
-	if err = netlink.AddrAdd(hostVeth, addr); err != nil {
-		return fmt.Errorf("failed to add IP addr to veth: %v", err)
-	}
+	for _, ipn := range []*net.IPNet{p.host, p.host6} {
+		if ipn == nil {
+			continue
+		}
+		addr := &netlink.Addr{IPNet: ipn, Label: ""}
+		if err = netlink.AddrAdd(hostVeth, addr); err != nil {
+			return nil, fmt.Errorf("failed to add IP addr to veth: %v", err)
+		}
 
-	// dst happens to be the same as IP/net of host veth
-	if err = util.AddHostRoute(ipn, nil, hostVeth); err != nil && !os.IsExist(err) {
-		return fmt.Errorf("failed to add route on host: %v", err)
+		if delegated {
+			continue
+		}
+		// dst happens to be the same as IP/net of host veth
+		if err = util.AddHostRoute(ipn, nil, hostVeth); err != nil && !os.IsExist(err) {
+			return nil, fmt.Errorf("failed to add route on host: %v", err)
+		}
+	}
+	if delegated {
+		for _, ipn := range []*net.IPNet{p.cont, p.cont6} {
+			if ipn == nil {
+				continue
+			}
+			if err = util.AddLinkRoute(hostRoute(ipn.IP), hostVeth); err != nil && !os.IsExist(err) {
+				return nil, fmt.Errorf("failed to add route on host: %v", err)
+			}
+		}
 	}
 
-	fmt.Print(contIPNet)
-
-	return nil
+	return util.NewResult(p.cont, p.cont6, p.host.IP, hostIP6, p.routes, p.dns)
 }
 
-func cmdDel(contID, netns, netConf, ifName, args string) error {
-	return util.WithNetNSPath(netns, func(hostNS *os.File) error {
-		return util.DelLinkByName(ifName)
-	})
-}
-
-func main() {
-	var err error
-
-	cmd := os.Getenv("RKT_NETPLUGIN_COMMAND")
-	contID := os.Getenv("RKT_NETPLUGIN_CONTID")
-	netns := os.Getenv("RKT_NETPLUGIN_NETNS")
-	args :=	os.Getenv("RKT_NETPLUGIN_ARGS")
-	ifName := os.Getenv("RKT_NETPLUGIN_IFNAME")
-	netConf := os.Getenv("RKT_NETPLUGIN_NETCONF")
-
-	if cmd == "" || contID == "" || netns == "" || ifName == "" || netConf == "" {
-		log.Printf("Required env variable missing")
-		log.Print("Env: ", os.Environ())
-		os.Exit(1)
+func cmdDel(args *util.CmdArgs) error {
+	cid, err := types.NewUUID(args.ContainerID)
+	if err != nil {
+		return fmt.Errorf("error parsing ContainerID: %v", err)
 	}
 
-	switch cmd {
-	case "ADD":
-		err = cmdAdd(contID, netns, netConf, ifName, args)
-
-	case "DEL":
-		err = cmdDel(contID, netns, netConf, ifName, args)
-
-	default:
-		log.Printf("Unknown RKT_NETPLUGIN_COMMAND: %v", cmd)
-		os.Exit(1)
+	conf := util.Net{}
+	if err := util.ParseNet(args.StdinData, &conf); err != nil {
+		return fmt.Errorf("failed to parse network configuration: %v", err)
 	}
 
+	// the IP is released even if the link can't be removed
+	relErr := ipam.Release(&conf, *cid, args)
+	err = util.WithNetNSPath(args.Netns, func(hostNS *os.File) error {
+		return util.DelLinkByName(args.IfName)
+	})
 	if err != nil {
-		log.Printf("%v: %v", os.Args[1], err)
-		os.Exit(1)
+		return err
 	}
+	return relErr
+}
+
+func main() {
+	util.PluginMain(cmdAdd, cmdDel)
 }
@@ -0,0 +1,248 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package networking
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/appc/spec/schema/types"
+)
+
+// ForwardedPort is a port of an app, named as in its image manifest, to be
+// forwarded from a port of the host.
+type ForwardedPort struct {
+	Name     types.ACName `json:"name"`
+	HostPort uint         `json:"hostPort"`
+}
+
+func (fp ForwardedPort) String() string {
+	return fmt.Sprintf("%s:%d", fp.Name, fp.HostPort)
+}
+
+// PortList implements the flag.Value interface to collect the ports to
+// forward, given as NAME:HOSTPORT, one per flag.
+type PortList []ForwardedPort
+
+func (l *PortList) Set(s string) error {
+	i := strings.LastIndex(s, ":")
+	if i < 0 {
+		return fmt.Errorf("port must be of form NAME:HOSTPORT")
+	}
+	name, err := types.NewACName(s[:i])
+	if err != nil {
+		return fmt.Errorf("invalid port name %q: %v", s[:i], err)
+	}
+	hp, err := strconv.ParseUint(s[i+1:], 10, 16)
+	if err != nil || hp == 0 {
+		return fmt.Errorf("invalid host port %q", s[i+1:])
+	}
+	for _, fp := range *l {
+		if fp.HostPort == uint(hp) {
+			return fmt.Errorf("host port %d forwarded more than once", hp)
+		}
+	}
+	*l = append(*l, ForwardedPort{Name: *name, HostPort: uint(hp)})
+	return nil
+}
+
+func (l *PortList) String() string {
+	ss := make([]string, len(*l))
+	for i, fp := range *l {
+		ss[i] = fp.String()
+	}
+	return strings.Join(ss, ",")
+}
+
+// PortForward is a port of a container forwarded from the host.
+type PortForward struct {
+	Protocol string `json:"protocol"`
+	HostPort uint   `json:"hostPort"`
+	IP       string `json:"ip"`
+	Port     uint   `json:"port"`
+}
+
+// dnatArgs returns the iptables arguments of the rules redirecting the
+// traffic for pf's host port to the container: the traffic coming in and
+// the traffic from the host itself, to any of its addresses but the
+// loopback ones, which are handled by a proxy.
+func dnatArgs(op string, pf PortForward) [][]string {
+	match := []string{
+		"-p", pf.Protocol,
+		"-m", "addrtype", "--dst-type", "LOCAL",
+		"--dport", strconv.FormatUint(uint64(pf.HostPort), 10),
+	}
+	target := []string{
+		"-j", "DNAT",
+		"--to-destination", net.JoinHostPort(pf.IP, strconv.FormatUint(uint64(pf.Port), 10)),
+	}
+	in := append([]string{"-t", "nat", op, "PREROUTING"}, match...)
+	out := append([]string{"-t", "nat", op, "OUTPUT", "!", "-d", "127.0.0.0/8"}, match...)
+	return [][]string{append(in, target...), append(out, target...)}
+}
+
+func setupPortForward(pf PortForward) error {
+	for i, args := range dnatArgs("-A", pf) {
+		if out, err := exec.Command("iptables", args...).CombinedOutput(); err != nil {
+			for _, added := range dnatArgs("-D", pf)[:i] {
+				exec.Command("iptables", added...).Run()
+			}
+			return fmt.Errorf("error adding forwarding rule for port %d: %v: %s", pf.HostPort, err, out)
+		}
+	}
+	return nil
+}
+
+func teardownPortForward(pf PortForward) error {
+	var first error
+	for _, args := range dnatArgs("-D", pf) {
+		if out, err := exec.Command("iptables", args...).CombinedOutput(); err != nil && first == nil {
+			first = fmt.Errorf("error deleting forwarding rule for port %d: %v: %s", pf.HostPort, err, out)
+		}
+	}
+	return first
+}
+
+// proxyLoopback relays the TCP connections to pf's host port on the
+// loopback address, which DNAT can't redirect, to the container.
+func proxyLoopback(pf PortForward) (net.Listener, error) {
+	l, err := net.Listen("tcp", net.JoinHostPort("127.0.0.1", strconv.FormatUint(uint64(pf.HostPort), 10)))
+	if err != nil {
+		return nil, fmt.Errorf("error proxying port %d on localhost: %v", pf.HostPort, err)
+	}
+	dst := net.JoinHostPort(pf.IP, strconv.FormatUint(uint64(pf.Port), 10))
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				// closed by Teardown
+				return
+			}
+			go relay(c, dst)
+		}
+	}()
+	return l, nil
+}
+
+func relay(c net.Conn, dst string) {
+	defer c.Close()
+	d, err := net.Dial("tcp", dst)
+	if err != nil {
+		log.Printf("Error proxying connection to %s: %v", dst, err)
+		return
+	}
+	defer d.Close()
+	done := make(chan struct{})
+	go func() {
+		io.Copy(d, c)
+		d.(*net.TCPConn).CloseWrite()
+		close(done)
+	}()
+	io.Copy(c, d)
+	c.(*net.TCPConn).CloseWrite()
+	<-done
+}
+
+// ForwardPorts forwards the given ports of the container's apps, declared
+// in ports, from the host to the container's address on its default
+// network, the last one. Traffic to the host's external addresses is
+// redirected with iptables, TCP connections to localhost are proxied.
+// The forwards are recorded in the container directory and undone by
+// Teardown or, if the container didn't exit cleanly, by TeardownPortForwards.
+func (n *Networking) ForwardPorts(fps []ForwardedPort, ports []types.Port) error {
+	if err := n.EnterHostNS(); err != nil {
+		return err
+	}
+	ip := n.nets[len(n.nets)-1].ipn.IP
+	for _, fp := range fps {
+		var port *types.Port
+		for i := range ports {
+			if ports[i].Name == fp.Name {
+				port = &ports[i]
+				break
+			}
+		}
+		if port == nil {
+			return fmt.Errorf("port %q is not declared by any app", fp.Name)
+		}
+		pf := PortForward{
+			Protocol: port.Protocol,
+			HostPort: fp.HostPort,
+			IP:       ip.String(),
+			Port:     port.Port,
+		}
+		if err := setupPortForward(pf); err != nil {
+			return err
+		}
+		n.ports = append(n.ports, pf)
+		if pf.Protocol == "tcp" {
+			l, err := proxyLoopback(pf)
+			if err != nil {
+				return err
+			}
+			n.proxies = append(n.proxies, l)
+		}
+	}
+	if err := saveNetInfo(n.rktRoot, n.contNSPath, n.nets, n.ports, false); err != nil {
+		return fmt.Errorf("error saving network info: %v", err)
+	}
+	return nil
+}
+
+// teardownPorts stops forwarding the ports of the container.
+func (n *Networking) teardownPorts() {
+	for _, l := range n.proxies {
+		l.Close()
+	}
+	n.proxies = nil
+	for _, pf := range n.ports {
+		if err := teardownPortForward(pf); err != nil {
+			log.Print(err)
+		}
+	}
+	n.ports = nil
+}
+
+// TeardownPortForwards removes the port forwards recorded in the directory
+// cdir of a container which exited without tearing them down.
+func TeardownPortForwards(cdir string) error {
+	ni, err := LoadNetInfo(cdir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if len(ni.Ports) == 0 {
+		return nil
+	}
+	var first error
+	for _, pf := range ni.Ports {
+		if err := teardownPortForward(pf); err != nil && first == nil {
+			first = err
+		}
+	}
+	ni.Ports = nil
+	if err := writeNetInfo(cdir, ni); err != nil && first == nil {
+		first = err
+	}
+	return first
+}
@@ -0,0 +1,71 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package networking
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPortList(t *testing.T) {
+	tests := []struct {
+		args  []string
+		ports PortList
+		err   bool
+	}{
+		{[]string{"http:8080"}, PortList{{"http", 8080}}, false},
+		{[]string{"http:8080", "dns:53"}, PortList{{"http", 8080}, {"dns", 53}}, false},
+		{[]string{"http"}, nil, true},
+		{[]string{"http:"}, nil, true},
+		{[]string{"http:0"}, nil, true},
+		{[]string{"http:65536"}, nil, true},
+		{[]string{"Not_An_ACName:80"}, nil, true},
+		{[]string{"http:8080", "https:8080"}, nil, true},
+	}
+	for i, tt := range tests {
+		var l PortList
+		var err error
+		for _, a := range tt.args {
+			if err = l.Set(a); err != nil {
+				break
+			}
+		}
+		if (err != nil) != tt.err {
+			t.Errorf("#%d: got error %v, want error %t", i, err, tt.err)
+			continue
+		}
+		if !tt.err && !reflect.DeepEqual(l, tt.ports) {
+			t.Errorf("#%d: got %v, want %v", i, l, tt.ports)
+		}
+	}
+}
+
+func TestDNATArgs(t *testing.T) {
+	pf := PortForward{Protocol: "tcp", HostPort: 8080, IP: "10.1.0.2", Port: 80}
+	want := []string{
+		"-t nat -A PREROUTING -p tcp -m addrtype --dst-type LOCAL --dport 8080 -j DNAT --to-destination 10.1.0.2:80",
+		"-t nat -A OUTPUT ! -d 127.0.0.0/8 -p tcp -m addrtype --dst-type LOCAL --dport 8080 -j DNAT --to-destination 10.1.0.2:80",
+	}
+	args := dnatArgs("-A", pf)
+	if len(args) != len(want) {
+		t.Fatalf("got %d rules, want %d", len(args), len(want))
+	}
+	for i, a := range args {
+		if g := strings.Join(a, " "); g != want[i] {
+			t.Errorf("rule #%d: got %q, want %q", i, g, want[i])
+		}
+	}
+}
@@ -0,0 +1,211 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package networking
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/coreos/rocket/Godeps/_workspace/src/github.com/vishvananda/netlink"
+)
+
+const (
+	// ifInet6 lists the IPv6 addresses of the interfaces of the netns
+	// of the reading process, with their flags
+	ifInet6 = "/proc/net/if_inet6"
+	// flags of IPv6 addresses whose duplicate address detection isn't
+	// over or failed
+	ifaFlagDADFailed = 0x08
+	ifaFlagTentative = 0x40
+
+	readyPollInterval = 100 * time.Millisecond
+	// discardPort is the port datagrams probing gateways are sent to
+	discardPort = 9
+)
+
+// ReadyPolicy tells what to do when the nets of a container aren't ready
+// in time.
+type ReadyPolicy string
+
+const (
+	// ReadyAbort doesn't start the apps of the container
+	ReadyAbort ReadyPolicy = "abort"
+	// ReadyContinue starts them anyway
+	ReadyContinue ReadyPolicy = "continue"
+)
+
+// Set implements the flag.Value interface
+func (p *ReadyPolicy) Set(s string) error {
+	switch rp := ReadyPolicy(s); rp {
+	case ReadyAbort, ReadyContinue:
+		*p = rp
+		return nil
+	}
+	return fmt.Errorf("unknown policy %q, want %q or %q", s, ReadyAbort, ReadyContinue)
+}
+
+func (p *ReadyPolicy) String() string {
+	return string(*p)
+}
+
+// WaitReady waits, up to timeout, for the nets of the container to be
+// ready: the addresses returned by the plugins assigned to its interfaces,
+// IPv6 ones done with duplicate address detection, and the gateways of
+// their routes reachable, i.e. answering ARP or neighbor discovery probes.
+// It returns an error naming the nets not ready in time. It must be called
+// in the container's netns.
+func (n *Networking) WaitReady(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		var notReady []string
+		for i := range n.nets {
+			if err := netReady(&n.nets[i]); err != nil {
+				notReady = append(notReady, fmt.Sprintf("%s (%v)", n.nets[i].Name, err))
+			}
+		}
+		if len(notReady) == 0 {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("networks not ready after %v: %s", timeout, strings.Join(notReady, ", "))
+		}
+		time.Sleep(readyPollInterval)
+	}
+}
+
+// netReady returns why the net an isn't ready, if it isn't
+func netReady(an *activeNet) error {
+	link, err := netlink.LinkByName(an.ifName)
+	if err != nil {
+		return err
+	}
+
+	addrs, err := netlink.AddrList(link, netlink.FAMILY_ALL)
+	if err != nil {
+		return err
+	}
+	var flags6 map[string]uint64
+	for _, ipn := range an.addrs() {
+		if !hasAddr(addrs, ipn.IP) {
+			return fmt.Errorf("address %v not assigned", ipn.IP)
+		}
+		if ipn.IP.To4() != nil {
+			continue
+		}
+		if flags6 == nil {
+			if flags6, err = readIfInet6(an.ifName); err != nil {
+				return err
+			}
+		}
+		switch f := flags6[ipn.IP.String()]; {
+		case f&ifaFlagDADFailed != 0:
+			return fmt.Errorf("address %v is a duplicate", ipn.IP)
+		case f&ifaFlagTentative != 0:
+			return fmt.Errorf("address %v is tentative", ipn.IP)
+		}
+	}
+
+	for _, family := range []int{netlink.FAMILY_V4, netlink.FAMILY_V6} {
+		routes, err := netlink.RouteList(link, family)
+		if err != nil {
+			return err
+		}
+		for _, r := range routes {
+			if r.Gw == nil {
+				continue
+			}
+			if err := probeGateway(link, family, r.Gw); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func hasAddr(addrs []netlink.Addr, ip net.IP) bool {
+	for _, a := range addrs {
+		if a.IP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// probeGateway returns an error unless gw is known to be reachable. If it
+// isn't, a datagram is sent to it so that the kernel probes it.
+func probeGateway(link netlink.Link, family int, gw net.IP) error {
+	neighs, err := netlink.NeighList(link.Attrs().Index, family)
+	if err != nil {
+		return err
+	}
+	for _, nb := range neighs {
+		if nb.IP.Equal(gw) && neighReachable(nb.State) {
+			return nil
+		}
+	}
+	if c, err := net.DialUDP("udp", nil, &net.UDPAddr{IP: gw, Port: discardPort}); err == nil {
+		c.Write([]byte{0})
+		c.Close()
+	}
+	return fmt.Errorf("gateway %v not reachable yet", gw)
+}
+
+// neighReachable reports whether a neighbor in state answered probes, or
+// needs none
+func neighReachable(state int) bool {
+	return state&(netlink.NUD_REACHABLE|netlink.NUD_STALE|netlink.NUD_DELAY|netlink.NUD_PROBE|netlink.NUD_PERMANENT|netlink.NUD_NOARP) != 0
+}
+
+// readIfInet6 returns the flags of the IPv6 addresses of the interface
+// ifName, by address
+func readIfInet6(ifName string) (map[string]uint64, error) {
+	f, err := os.Open(ifInet6)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseIfInet6(f, ifName)
+}
+
+// parseIfInet6 parses the lines of the interface ifName in the format of
+// /proc/net/if_inet6: the address in hex, the interface index, the prefix
+// length, the scope and the flags in hex, and the interface name.
+func parseIfInet6(r io.Reader, ifName string) (map[string]uint64, error) {
+	flags := make(map[string]uint64)
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) != 6 || fields[5] != ifName {
+			continue
+		}
+		b, err := hex.DecodeString(fields[0])
+		if err != nil || len(b) != net.IPv6len {
+			return nil, fmt.Errorf("invalid address %q in %s", fields[0], ifInet6)
+		}
+		f, err := strconv.ParseUint(fields[4], 16, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid flags %q in %s", fields[4], ifInet6)
+		}
+		flags[net.IP(b).String()] = f
+	}
+	return flags, s.Err()
+}
@@ -0,0 +1,66 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package networking
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/coreos/rocket/Godeps/_workspace/src/github.com/vishvananda/netlink"
+)
+
+func TestParseIfInet6(t *testing.T) {
+	in := `fe800000000000000000000000000001 02 40 20 80     eth0
+fd000000000000000000000000000003 02 40 00 40     eth0
+fd000000000000000000000000000004 03 40 00 00     eth1
+`
+	flags, err := parseIfInet6(strings.NewReader(in), "eth0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(flags) != 2 || flags["fd00::3"] != ifaFlagTentative || flags["fe80::1"] != 0x80 {
+		t.Errorf("got %v", flags)
+	}
+
+	if _, err := parseIfInet6(strings.NewReader("fd00 02 40 00 40 eth0\n"), "eth0"); err == nil {
+		t.Errorf("expected an error for a truncated address")
+	}
+}
+
+func TestNeighReachable(t *testing.T) {
+	for state, want := range map[int]bool{
+		netlink.NUD_REACHABLE:  true,
+		netlink.NUD_STALE:      true,
+		netlink.NUD_PERMANENT:  true,
+		netlink.NUD_NOARP:      true,
+		netlink.NUD_INCOMPLETE: false,
+		netlink.NUD_FAILED:     false,
+		netlink.NUD_NONE:       false,
+	} {
+		if got := neighReachable(state); got != want {
+			t.Errorf("state %#x: got %v, want %v", state, got, want)
+		}
+	}
+}
+
+func TestReadyPolicy(t *testing.T) {
+	var p ReadyPolicy
+	if err := p.Set("continue"); err != nil || p != ReadyContinue {
+		t.Errorf("got %q, %v", p, err)
+	}
+	if err := p.Set("retry"); err == nil {
+		t.Errorf("expected an error for an unknown policy")
+	}
+}
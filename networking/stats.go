@@ -0,0 +1,106 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package networking
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/coreos/rocket/networking/util"
+)
+
+// sysClassNet lists the links of the host netns
+var sysClassNet = "/sys/class/net"
+
+// LinkStats are the traffic counters of a host link of a container, e.g.
+// the host end of its veth, from the point of view of the container: what
+// the host end transmitted, the container received.
+type LinkStats struct {
+	// NetName is the network the link attaches the container to
+	NetName string
+	// Link is the name of the host link
+	Link      string
+	RxBytes   uint64
+	RxPackets uint64
+	RxDropped uint64
+	TxBytes   uint64
+	TxPackets uint64
+	TxDropped uint64
+}
+
+// HostLinkStats returns the counters of the host links tagged as owned by
+// containers, by container UUID. Containers attached to networks through
+// sub-interfaces of a host device, e.g. macvlan, have no host link.
+func HostLinkStats() (map[string][]LinkStats, error) {
+	links, err := ioutil.ReadDir(sysClassNet)
+	if err != nil {
+		return nil, fmt.Errorf("error listing links: %v", err)
+	}
+
+	stats := make(map[string][]LinkStats)
+	for _, l := range links {
+		name := l.Name()
+		alias, err := ioutil.ReadFile(filepath.Join(sysClassNet, name, "ifalias"))
+		if err != nil {
+			continue
+		}
+		contID, netName, ok := util.ParseOwnerAlias(strings.TrimSuffix(string(alias), "\n"))
+		if !ok {
+			continue
+		}
+
+		ls := LinkStats{
+			NetName: netName,
+			Link:    name,
+		}
+		// the counters of the host end are reversed
+		for _, c := range []struct {
+			file string
+			v    *uint64
+		}{
+			{"tx_bytes", &ls.RxBytes},
+			{"tx_packets", &ls.RxPackets},
+			{"tx_dropped", &ls.RxDropped},
+			{"rx_bytes", &ls.TxBytes},
+			{"rx_packets", &ls.TxPackets},
+			{"rx_dropped", &ls.TxDropped},
+		} {
+			if *c.v, err = readCounter(filepath.Join(sysClassNet, name, "statistics", c.file)); err != nil {
+				break
+			}
+		}
+		if os.IsNotExist(err) {
+			// the link went away in the meantime
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading counters of %q: %v", name, err)
+		}
+		stats[contID] = append(stats[contID], ls)
+	}
+	return stats, nil
+}
+
+func readCounter(path string) (uint64, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(strings.TrimSpace(string(b)), 10, 64)
+}
@@ -0,0 +1,75 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package networking
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/coreos/rocket/networking/util"
+)
+
+func TestHostLinkStats(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sysfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(d string) { sysClassNet = d }(sysClassNet)
+	sysClassNet = dir
+
+	const contID = "6733c35b-3b5f-4b4c-a67b-1f3a5d6e1c2a"
+	links := map[string]string{
+		"rkt6733c3-back": util.OwnerAlias(contID, "backend") + "\n",
+		"eth0":           "\n",
+	}
+	for name, alias := range links {
+		sd := filepath.Join(dir, name, "statistics")
+		if err := os.MkdirAll(sd, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, name, "ifalias"), []byte(alias), 0644); err != nil {
+			t.Fatal(err)
+		}
+		for i, c := range []string{"rx_bytes", "rx_packets", "rx_dropped", "tx_bytes", "tx_packets", "tx_dropped"} {
+			if err := ioutil.WriteFile(filepath.Join(sd, c), []byte{'1' + byte(i), '\n'}, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	stats, err := HostLinkStats()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := map[string][]LinkStats{
+		contID: {{
+			NetName:   "backend",
+			Link:      "rkt6733c3-back",
+			RxBytes:   4,
+			RxPackets: 5,
+			RxDropped: 6,
+			TxBytes:   1,
+			TxPackets: 2,
+			TxDropped: 3,
+		}},
+	}
+	if !reflect.DeepEqual(stats, want) {
+		t.Errorf("got %+v, want %+v", stats, want)
+	}
+}
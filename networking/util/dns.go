@@ -0,0 +1,91 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// DNS holds resolver settings, as found in a resolv.conf. It is also the
+// dns section of a network configuration, in the format of upstream CNI.
+type DNS struct {
+	Nameservers []string `json:"nameservers,omitempty"`
+	Domain      string   `json:"domain,omitempty"`
+	Search      []string `json:"search,omitempty"`
+	Options     []string `json:"options,omitempty"`
+}
+
+// Empty reports whether d holds no settings.
+func (d *DNS) Empty() bool {
+	return len(d.Nameservers) == 0 && d.Domain == "" && len(d.Search) == 0 && len(d.Options) == 0
+}
+
+// String renders d in the resolv.conf format, one setting per line.
+func (d *DNS) String() string {
+	var lines []string
+	for _, ns := range d.Nameservers {
+		lines = append(lines, "nameserver "+ns)
+	}
+	if d.Domain != "" {
+		lines = append(lines, "domain "+d.Domain)
+	}
+	if len(d.Search) > 0 {
+		lines = append(lines, "search "+strings.Join(d.Search, " "))
+	}
+	if len(d.Options) > 0 {
+		lines = append(lines, "options "+strings.Join(d.Options, " "))
+	}
+	if len(lines) == 0 {
+		return ""
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// ParseDNS parses settings in the resolv.conf format. Comments, blank
+// lines and keywords other than nameserver, domain, search and options are
+// ignored.
+func ParseDNS(s string) (*DNS, error) {
+	d := &DNS{}
+	sc := bufio.NewScanner(strings.NewReader(s))
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") || strings.HasPrefix(fields[0], ";") {
+			continue
+		}
+		switch fields[0] {
+		case "nameserver":
+			if len(fields) != 2 || net.ParseIP(fields[1]) == nil {
+				return nil, fmt.Errorf("invalid nameserver line %q", sc.Text())
+			}
+			d.Nameservers = append(d.Nameservers, fields[1])
+		case "domain":
+			if len(fields) != 2 {
+				return nil, fmt.Errorf("invalid domain line %q", sc.Text())
+			}
+			d.Domain = fields[1]
+		case "search":
+			d.Search = append(d.Search, fields[1:]...)
+		case "options":
+			d.Options = append(d.Options, fields[1:]...)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
@@ -0,0 +1,85 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseDNS(t *testing.T) {
+	tests := []struct {
+		in   string
+		out  *DNS
+		fail bool
+	}{
+		{"", &DNS{}, false},
+		{
+			"# comment\nnameserver 10.1.0.1\nnameserver fd00::1\n\ndomain example.com\nsearch a.example.com b.example.com\noptions ndots:2 rotate\nsortlist 10.1.0.0/16\n",
+			&DNS{
+				Nameservers: []string{"10.1.0.1", "fd00::1"},
+				Domain:      "example.com",
+				Search:      []string{"a.example.com", "b.example.com"},
+				Options:     []string{"ndots:2", "rotate"},
+			},
+			false,
+		},
+		{"nameserver dns.example.com\n", nil, true},
+		{"nameserver\n", nil, true},
+		{"domain a b\n", nil, true},
+	}
+
+	for i, tt := range tests {
+		d, err := ParseDNS(tt.in)
+		if tt.fail {
+			if err == nil {
+				t.Errorf("#%d: expected an error", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("#%d: unexpected error: %v", i, err)
+			continue
+		}
+		if !reflect.DeepEqual(d, tt.out) {
+			t.Errorf("#%d: expected %#v, got %#v", i, tt.out, d)
+		}
+	}
+}
+
+func TestDNSString(t *testing.T) {
+	d := &DNS{
+		Nameservers: []string{"10.1.0.1", "fd00::1"},
+		Domain:      "example.com",
+		Search:      []string{"a.example.com", "b.example.com"},
+		Options:     []string{"ndots:2"},
+	}
+	expected := "nameserver 10.1.0.1\nnameserver fd00::1\ndomain example.com\nsearch a.example.com b.example.com\noptions ndots:2\n"
+	if s := d.String(); s != expected {
+		t.Errorf("expected %q, got %q", expected, s)
+	}
+	if s := (&DNS{}).String(); s != "" {
+		t.Errorf("expected no output, got %q", s)
+	}
+
+	// rendered settings parse back
+	p, err := ParseDNS(d.String())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(p, d) {
+		t.Errorf("expected %#v, got %#v", d, p)
+	}
+}
@@ -0,0 +1,155 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	// DefaultFlannelSubnetFile is where flannel writes the subnet it
+	// leased for the host
+	DefaultFlannelSubnetFile = "/run/flannel/subnet.env"
+	// defaultFlannelBrName is the bridge containers of flannel networks
+	// are attached to by default
+	defaultFlannelBrName = "rkt-flannel0"
+)
+
+// FlannelNet is the configuration of a network of the flannel plugin. The
+// plugin delegates to the bridge one, with a configuration derived from
+// the subnet flannel leased for the host, which Delegate may amend.
+type FlannelNet struct {
+	Net
+	SubnetFile string                 `json:"subnetFile,omitempty"`
+	Delegate   map[string]interface{} `json:"delegate,omitempty"`
+	// IPMasq, if not set, defaults to masquerading the traffic leaving
+	// the flannel network unless flannel does it itself
+	IPMasq *bool `json:"ipMasq,omitempty"`
+}
+
+// FlannelEnv holds the settings flannel writes to its subnet file.
+type FlannelEnv struct {
+	// Network is the whole flannel network, spanning all the hosts
+	Network *net.IPNet
+	// Subnet is the subnet of the host, its IP the address of the host
+	Subnet *net.IPNet
+	MTU    int
+	// IPMasq tells flannel masquerades the traffic leaving Network itself
+	IPMasq bool
+}
+
+// SubnetFilePath returns the path of the subnet file of flannel.
+func (fn *FlannelNet) SubnetFilePath() string {
+	if fn.SubnetFile == "" {
+		return DefaultFlannelSubnetFile
+	}
+	return fn.SubnetFile
+}
+
+// Masquerade tells whether rkt masquerades the traffic of containers
+// leaving the flannel network.
+func (fn *FlannelNet) Masquerade(env *FlannelEnv) bool {
+	if fn.IPMasq != nil {
+		return *fn.IPMasq
+	}
+	return !env.IPMasq
+}
+
+// LoadFlannelEnv parses the KEY=VALUE lines of the subnet file of flannel
+// at path.
+func LoadFlannelEnv(path string) (*FlannelEnv, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	env := &FlannelEnv{}
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		kv := strings.SplitN(strings.TrimSpace(s.Text()), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch kv[0] {
+		case "FLANNEL_NETWORK":
+			if _, env.Network, err = net.ParseCIDR(kv[1]); err != nil {
+				return nil, fmt.Errorf("error parsing FLANNEL_NETWORK: %v", err)
+			}
+		case "FLANNEL_SUBNET":
+			if env.Subnet, err = ParseCIDR(kv[1]); err != nil {
+				return nil, fmt.Errorf("error parsing FLANNEL_SUBNET: %v", err)
+			}
+		case "FLANNEL_MTU":
+			if env.MTU, err = strconv.Atoi(kv[1]); err != nil {
+				return nil, fmt.Errorf("error parsing FLANNEL_MTU: %v", err)
+			}
+		case "FLANNEL_IPMASQ":
+			if env.IPMasq, err = strconv.ParseBool(kv[1]); err != nil {
+				return nil, fmt.Errorf("error parsing FLANNEL_IPMASQ: %v", err)
+			}
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+
+	if env.Network == nil {
+		return nil, fmt.Errorf("%s is missing FLANNEL_NETWORK", path)
+	}
+	if env.Subnet == nil {
+		return nil, fmt.Errorf("%s is missing FLANNEL_SUBNET", path)
+	}
+	return env, nil
+}
+
+// FlannelDelegate returns the configuration of the bridge network the
+// flannel network fn delegates to: containers get addresses from the
+// subnet of the host, whose address is given to the bridge, and a route to
+// the flannel network through it. Masquerading is left to rkt.
+func FlannelDelegate(fn *FlannelNet, env *FlannelEnv) map[string]interface{} {
+	subnet := &net.IPNet{IP: env.Subnet.IP.Mask(env.Subnet.Mask), Mask: env.Subnet.Mask}
+	d := map[string]interface{}{
+		"name":   fn.Name,
+		"type":   "bridge",
+		"brName": defaultFlannelBrName,
+		"isGW":   true,
+		"ipAlloc": map[string]interface{}{
+			"type":    "host-local",
+			"subnet":  subnet.String(),
+			"gateway": env.Subnet.IP.String(),
+		},
+		"routes": []string{env.Network.String()},
+	}
+	if env.MTU > 0 {
+		d["mtu"] = env.MTU
+	}
+	if !fn.DNS.Empty() {
+		d["dns"] = fn.DNS
+	}
+	for k, v := range fn.Delegate {
+		d[k] = v
+	}
+	// the name identifies the allocations of the network and rkt
+	// masquerades the traffic of the flannel network itself
+	d["name"] = fn.Name
+	delete(d, "ipMasq")
+	return d
+}
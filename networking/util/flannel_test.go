@@ -0,0 +1,115 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const testSubnetEnv = `FLANNEL_NETWORK=10.1.0.0/16
+FLANNEL_SUBNET=10.1.17.1/24
+FLANNEL_MTU=1472
+FLANNEL_IPMASQ=true
+`
+
+func writeSubnetEnv(t *testing.T, content string) (string, func()) {
+	dir, err := ioutil.TempDir("", "flannel")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := filepath.Join(dir, "subnet.env")
+	if err := ioutil.WriteFile(p, []byte(content), 0644); err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return p, func() { os.RemoveAll(dir) }
+}
+
+func TestLoadFlannelEnv(t *testing.T) {
+	p, cleanup := writeSubnetEnv(t, testSubnetEnv)
+	defer cleanup()
+
+	env, err := LoadFlannelEnv(p)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if env.Network.String() != "10.1.0.0/16" || env.Subnet.String() != "10.1.17.1/24" || env.MTU != 1472 || !env.IPMasq {
+		t.Errorf("unexpected settings %+v", env)
+	}
+
+	for _, bad := range []string{
+		"FLANNEL_SUBNET=10.1.17.1/24\n",
+		"FLANNEL_NETWORK=10.1.0.0/16\n",
+		"FLANNEL_NETWORK=10.1.0.0/16\nFLANNEL_SUBNET=10.1.17.1/24\nFLANNEL_MTU=big\n",
+	} {
+		p, cleanup := writeSubnetEnv(t, bad)
+		if _, err := LoadFlannelEnv(p); err == nil {
+			t.Errorf("expected an error loading %q", bad)
+		}
+		cleanup()
+	}
+}
+
+func TestFlannelDelegate(t *testing.T) {
+	p, cleanup := writeSubnetEnv(t, testSubnetEnv)
+	defer cleanup()
+	env, err := LoadFlannelEnv(p)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	fn := &FlannelNet{
+		Delegate: map[string]interface{}{
+			"brName": "flannel0",
+			"name":   "other",
+			"ipMasq": true,
+		},
+	}
+	fn.Name = "overlay"
+	expected := map[string]interface{}{
+		"name":   "overlay",
+		"type":   "bridge",
+		"brName": "flannel0",
+		"isGW":   true,
+		"ipAlloc": map[string]interface{}{
+			"type":    "host-local",
+			"subnet":  "10.1.17.0/24",
+			"gateway": "10.1.17.1",
+		},
+		"routes": []string{"10.1.0.0/16"},
+		"mtu":    1472,
+	}
+	if d := FlannelDelegate(fn, env); !reflect.DeepEqual(d, expected) {
+		t.Errorf("expected %#v, got %#v", expected, d)
+	}
+
+	// rkt masquerades unless flannel does
+	if fn.Masquerade(env) {
+		t.Errorf("expected no masquerading when flannel masquerades")
+	}
+	env.IPMasq = false
+	if !fn.Masquerade(env) {
+		t.Errorf("expected masquerading when flannel doesn't")
+	}
+	no := false
+	fn.IPMasq = &no
+	if fn.Masquerade(env) {
+		t.Errorf("expected ipMasq to take precedence")
+	}
+}
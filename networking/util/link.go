@@ -17,10 +17,25 @@ package util
 import (
 	"crypto/sha512"
 	"fmt"
+	"io/ioutil"
 	"net"
 	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
 
 	"github.com/coreos/rocket/Godeps/_workspace/src/github.com/vishvananda/netlink"
+	"github.com/coreos/rocket/Godeps/_workspace/src/github.com/vishvananda/netlink/nl"
+)
+
+const (
+	// interface names are limited to 15 characters
+	maxIfNameLen = 15
+	// shortUUIDLen is the number of characters of the container UUID in
+	// the names of host links
+	shortUUIDLen = 6
+	// ownerAliasPrefix starts the ifalias of the host links of containers
+	ownerAliasPrefix = "rkt:"
 )
 
 func makeVeth(name, peer string) (netlink.Link, error) {
@@ -44,18 +59,101 @@ func hash(s string) string {
 	return fmt.Sprintf("%x", h.Sum(nil))
 }
 
-// SetupVeth sets up a virtual ethernet link.
+// HostLinkPrefix returns the prefix of the names of the host links of the
+// container: "rkt" followed by the start of its UUID.
+func HostLinkPrefix(contID string) string {
+	id := strings.Replace(contID, "-", "", -1)
+	if len(id) > shortUUIDLen {
+		id = id[:shortUUIDLen]
+	}
+	return "rkt" + id + "-"
+}
+
+// HostVethName returns the name of the host end of the veth attaching the
+// container to the network netName, e.g. rkt6733c3-backe. Network names
+// which don't fit, or can't be part of an interface name, are replaced by
+// their first characters and a hash so that a container's links differ.
+func HostVethName(contID, netName string) string {
+	prefix := HostLinkPrefix(contID)
+	if len(prefix)+len(netName) > maxIfNameLen || strings.ContainsAny(netName, "/: \t") {
+		n := maxIfNameLen - len(prefix) - 3
+		if len(netName) < n {
+			n = len(netName)
+		}
+		netName = strings.NewReplacer("/", "_", ":", "_", " ", "_", "\t", "_").Replace(netName[:n]) + hash(netName)[:maxIfNameLen-len(prefix)-n]
+	}
+	return prefix + netName
+}
+
+// OwnerAlias returns the ifalias tagging a host link as owned by the
+// container, for the network netName.
+func OwnerAlias(contID, netName string) string {
+	return ownerAliasPrefix + contID + ":" + netName
+}
+
+// ParseOwnerAlias returns the container and network an ifalias tags a link
+// as owned by, ok being false if the link isn't tagged as rkt's.
+func ParseOwnerAlias(alias string) (contID, netName string, ok bool) {
+	if !strings.HasPrefix(alias, ownerAliasPrefix) {
+		return "", "", false
+	}
+	parts := strings.SplitN(alias[len(ownerAliasPrefix):], ":", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return "", "", false
+	}
+	return parts[0], parts[1], true
+}
+
+// SetLinkAlias sets the ifalias of link.
+// Equivalent to: `ip link set $link alias $alias`
+func SetLinkAlias(link netlink.Link, alias string) error {
+	// the link may have been created without its index known
+	l, err := netlink.LinkByName(link.Attrs().Name)
+	if err != nil {
+		return err
+	}
+	req := nl.NewNetlinkRequest(syscall.RTM_SETLINK, syscall.NLM_F_ACK)
+
+	msg := nl.NewIfInfomsg(syscall.AF_UNSPEC)
+	msg.Type = syscall.RTM_SETLINK
+	msg.Flags = syscall.NLM_F_REQUEST
+	msg.Index = int32(l.Attrs().Index)
+	msg.Change = nl.DEFAULT_CHANGE
+	req.AddData(msg)
+
+	req.AddData(nl.NewRtAttr(syscall.IFLA_IFALIAS, []byte(alias)))
+
+	_, err = req.Execute(syscall.NETLINK_ROUTE, 0)
+	return err
+}
+
+// LinkAlias returns the ifalias of the link named ifName in the host netns.
+func LinkAlias(ifName string) (string, error) {
+	b, err := ioutil.ReadFile(filepath.Join("/sys/class/net", ifName, "ifalias"))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(string(b), "\n"), nil
+}
+
+// SetupVeth sets up a virtual ethernet link attaching the container to the
+// network netName. The host end is named by HostVethName and tagged as
+// owned by the container with OwnerAlias.
 // Should be in container netns.
-// TODO(eyakubovich): get rid of entropy and ask kernel to pick name via pattern
-func SetupVeth(entropy, contVethName string, ipn *net.IPNet, hostNS *os.File) (hostVeth, contVeth netlink.Link, err error) {
-	// NetworkManager (recent versions) will ignore veth devices that start with "veth"
-	hostVethName := "veth" + hash(entropy)[:4]
+func SetupVeth(contID, netName, contVethName string, ipn *net.IPNet, hostNS *os.File) (hostVeth, contVeth netlink.Link, err error) {
+	hostVethName := HostVethName(contID, netName)
 	hostVeth, err = makeVeth(hostVethName, contVethName)
 	if err != nil {
 		err = fmt.Errorf("failed to make veth pair: %v", err)
 		return
 	}
 
+	if err = SetLinkAlias(hostVeth, OwnerAlias(contID, netName)); err != nil {
+		netlink.LinkDel(hostVeth)
+		err = fmt.Errorf("failed to tag %q: %v", hostVethName, err)
+		return
+	}
+
 	if err = netlink.LinkSetUp(hostVeth); err != nil {
 		err = fmt.Errorf("failed to set %q up: %v", hostVethName, err)
 		return
@@ -101,3 +199,45 @@ func DelLinkByName(ifName string) error {
 
 	return nil
 }
+
+// MoveLinkIn moves link, created in the host netns under a temporary name,
+// into the netns at nspath and renames it to ifName there. Sub-interfaces
+// of a host device (macvlan, ipvlan) must be created next to their master,
+// where ifName may already be taken. The link is deleted on failure.
+func MoveLinkIn(link netlink.Link, nspath, ifName string) (netlink.Link, error) {
+	tmpName := link.Attrs().Name
+
+	ns, err := os.Open(nspath)
+	if err != nil {
+		netlink.LinkDel(link)
+		return nil, fmt.Errorf("failed to open netns %q: %v", nspath, err)
+	}
+	defer ns.Close()
+	if err := netlink.LinkSetNsFd(link, int(ns.Fd())); err != nil {
+		netlink.LinkDel(link)
+		return nil, fmt.Errorf("failed to move %q to container netns: %v", tmpName, err)
+	}
+
+	var moved netlink.Link
+	err = WithNetNSPath(nspath, func(hostNS *os.File) error {
+		l, err := netlink.LinkByName(tmpName)
+		if err != nil {
+			return fmt.Errorf("failed to lookup %q: %v", tmpName, err)
+		}
+		if err := netlink.LinkSetName(l, ifName); err != nil {
+			netlink.LinkDel(l)
+			return fmt.Errorf("failed to rename %q to %q: %v", tmpName, ifName, err)
+		}
+		if moved, err = netlink.LinkByName(ifName); err != nil {
+			return fmt.Errorf("failed to lookup %q: %v", ifName, err)
+		}
+		return nil
+	})
+	return moved, err
+}
+
+// TempLinkName returns a name, unique to the container and interface, under
+// which a link for it can be created in the host netns.
+func TempLinkName(prefix, contID, ifName string) string {
+	return (prefix + hash(contID+ifName))[:maxIfNameLen]
+}
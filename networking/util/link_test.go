@@ -0,0 +1,53 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"testing"
+)
+
+func TestHostVethName(t *testing.T) {
+	const contID = "6733c35b-3b5f-4b4c-a67b-1f3a5d6e1c2a"
+	tests := []struct {
+		net  string
+		want string
+	}{
+		{"back", "rkt6733c3-back"},
+		{"front", "rkt6733c3-front"},
+		{"backend", "rkt6733c3-ba" + hash("backend")[:3]},
+		{"a/b", "rkt6733c3-a_" + hash("a/b")[:3]},
+	}
+	for _, tt := range tests {
+		if g := HostVethName(contID, tt.net); g != tt.want {
+			t.Errorf("%q: got %q, want %q", tt.net, g, tt.want)
+		}
+	}
+	if HostVethName(contID, "backend1") == HostVethName(contID, "backend2") {
+		t.Errorf("networks with a common prefix got the same link name")
+	}
+}
+
+func TestOwnerAlias(t *testing.T) {
+	const contID = "6733c35b-3b5f-4b4c-a67b-1f3a5d6e1c2a"
+	id, net, ok := ParseOwnerAlias(OwnerAlias(contID, "backend"))
+	if !ok || id != contID || net != "backend" {
+		t.Errorf("got %q, %q, %v", id, net, ok)
+	}
+	for _, alias := range []string{"", "uplink", "rkt:", "rkt:" + contID} {
+		if _, _, ok := ParseOwnerAlias(alias); ok {
+			t.Errorf("%q: unexpectedly tagged as owned by a container", alias)
+		}
+	}
+}
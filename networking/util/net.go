@@ -26,20 +26,121 @@ type Net struct {
 	Name     string `json:"name,omitempty"`
 	Type     string `json:"type,omitempty"`
 	IPAlloc  struct {
-		Type   string `json:"type,omitempty"`
-		Subnet string `json:"subnet,omitempty"`
+		Type    string `json:"type,omitempty"`
+		Subnet  string `json:"subnet,omitempty"`
+		Gateway string `json:"gateway,omitempty"`
+		// RangeStart, RangeEnd and Exclude restrict the addresses of
+		// Subnet handed out by the host-local allocation
+		RangeStart string   `json:"rangeStart,omitempty"`
+		RangeEnd   string   `json:"rangeEnd,omitempty"`
+		Exclude    []string `json:"exclude,omitempty"`
+		// Subnet6 and Gateway6 give the network IPv6 addresses next to
+		// the IPv4 ones, the gateway defaulting to the first address
+		Subnet6  string `json:"subnet6,omitempty"`
+		Gateway6 string `json:"gateway6,omitempty"`
 	} `json:"ipAlloc,omitempty"`
 	Routes []string `json:"routes,omitempty"`
+	// IPMasq masquerades the traffic of the containers leaving the
+	// network, whatever its plugin
+	IPMasq bool `json:"ipMasq,omitempty"`
+	// DNS holds the resolver settings the plugin returns for containers
+	// attached to the network
+	DNS DNS `json:"dns,omitempty"`
+
+	// Fields of upstream CNI network configurations, mapped onto the
+	// ones above when loading
+	CNIVersion string `json:"cniVersion,omitempty"`
+	IPAM       *IPAM  `json:"ipam,omitempty"`
+
+	// Plugins lists the configurations of the plugins run in turn for the
+	// network, instead of the single one named by Type. The first one
+	// attaches the container, the following ones are chained: each gets
+	// the result of the previous one as PrevResult and returns its own.
+	Plugins    []json.RawMessage `json:"plugins,omitempty"`
+	PrevResult *Result           `json:"prevResult,omitempty"`
+}
+
+// IPAM is the IP address management section of a network configuration,
+// in the CNI format. Interface plugins delegate the allocation of addresses
+// to the IPAM plugin it names.
+type IPAM struct {
+	Type       string `json:"type,omitempty"`
+	Subnet     string `json:"subnet,omitempty"`
+	RangeStart string `json:"rangeStart,omitempty"`
+	RangeEnd   string `json:"rangeEnd,omitempty"`
+	Gateway    string `json:"gateway,omitempty"`
+	Routes     []struct {
+		Dst string `json:"dst"`
+	} `json:"routes,omitempty"`
+}
+
+// cniTypes maps the types of CNI plugins to the rkt plugins doing the same
+var cniTypes = map[string]string{
+	"ptp": "veth",
+}
+
+// cniIPAMTypes maps the types of IPAM plugins to the rkt IP allocation types
+// they implement
+var cniIPAMTypes = map[string]string{
+	"host-local": "host-local",
+	"dhcp":       "dhcp",
+	"static":     "static",
+}
+
+type cniConfig interface {
+	applyCNI() error
+}
+
+// applyCNI fills in the rkt fields of n from its CNI ones. Fields set in
+// rkt's format take precedence.
+func (n *Net) applyCNI() error {
+	if t, ok := cniTypes[n.Type]; ok {
+		n.Type = t
+	}
+	if n.IPAM == nil {
+		return nil
+	}
+
+	// the allocations of third-party IPAM plugins are opaque to rkt
+	if t, ok := cniIPAMTypes[n.IPAM.Type]; ok && n.IPAlloc.Type == "" {
+		n.IPAlloc.Type = t
+		n.IPAlloc.Subnet = n.IPAM.Subnet
+		n.IPAlloc.Gateway = n.IPAM.Gateway
+		n.IPAlloc.RangeStart = n.IPAM.RangeStart
+		n.IPAlloc.RangeEnd = n.IPAM.RangeEnd
+	}
+	if len(n.Routes) == 0 {
+		for _, r := range n.IPAM.Routes {
+			n.Routes = append(n.Routes, r.Dst)
+		}
+	}
+	return nil
+}
+
+// ParseNet parses a JSON-encoded Net, in rkt's or the upstream CNI format,
+// e.g. as given to a plugin on stdin.
+func ParseNet(b []byte, n interface{}) error {
+	if err := json.Unmarshal(b, n); err != nil {
+		return err
+	}
+
+	if cn, ok := n.(cniConfig); ok {
+		if err := cn.applyCNI(); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-// LoadNet loads a JSON-encoded Net from the filesystem.
+// LoadNet loads a JSON-encoded Net, in rkt's or the upstream CNI format,
+// from the filesystem.
 func LoadNet(path string, n interface{}) error {
 	c, err := ioutil.ReadFile(path)
 	if err != nil {
 		return err
 	}
 
-	if err = json.Unmarshal(c, n); err != nil {
+	if err := ParseNet(c, n); err != nil {
 		return err
 	}
 
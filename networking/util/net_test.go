@@ -17,6 +17,7 @@ package util
 import (
 	"encoding/json"
 	"io/ioutil"
+	"os"
 	"reflect"
 	"testing"
 )
@@ -92,3 +93,65 @@ func TestNetEmbedded(t *testing.T) {
 		t.Errorf("Mismatch: expected=%#v; actual=%#v", expected, actual)
 	}
 }
+
+func TestNetCNI(t *testing.T) {
+	f, err := ioutil.TempFile("", "net")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	_, err = f.WriteString(`{
+	"cniVersion": "0.1.0",
+	"name": "mynet",
+	"type": "ptp",
+	"ipam": {
+		"type": "host-local",
+		"subnet": "10.1.2.0/24",
+		"rangeStart": "10.1.2.10",
+		"rangeEnd": "10.1.2.99",
+		"gateway": "10.1.2.1",
+		"routes": [ { "dst": "0.0.0.0/0" } ]
+	}
+}`)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n := MyNet{}
+	if err = LoadNet(f.Name(), &n); err != nil {
+		t.Fatal(err)
+	}
+	if n.Name != "mynet" || n.Type != "veth" {
+		t.Errorf("unexpected name or type: %q, %q", n.Name, n.Type)
+	}
+	if n.IPAlloc.Type != "host-local" || n.IPAlloc.Subnet != "10.1.2.0/24" || n.IPAlloc.Gateway != "10.1.2.1" ||
+		n.IPAlloc.RangeStart != "10.1.2.10" || n.IPAlloc.RangeEnd != "10.1.2.99" {
+		t.Errorf("unexpected ipAlloc: %+v", n.IPAlloc)
+	}
+	if !reflect.DeepEqual(n.Routes, []string{"0.0.0.0/0"}) {
+		t.Errorf("unexpected routes: %v", n.Routes)
+	}
+
+	if err := ioutil.WriteFile(f.Name(), []byte(`{"name": "mynet", "type": "macvlan", "ipam": {"type": "dhcp"}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	n = MyNet{}
+	if err = LoadNet(f.Name(), &n); err != nil {
+		t.Fatal(err)
+	}
+	if n.IPAlloc.Type != "dhcp" {
+		t.Errorf("unexpected ipAlloc: %+v", n.IPAlloc)
+	}
+
+	if err := ioutil.WriteFile(f.Name(), []byte(`{"name": "mynet", "type": "bridge", "ipam": {"type": "unknown"}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	n = MyNet{}
+	if err = LoadNet(f.Name(), &n); err != nil {
+		t.Fatal(err)
+	}
+	if n.IPAlloc.Type != "" || n.IPAM.Type != "unknown" {
+		t.Errorf("unexpected ipAlloc or ipam: %+v, %+v", n.IPAlloc, n.IPAM)
+	}
+}
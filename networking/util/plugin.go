@@ -0,0 +1,420 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+// Network plugins are executables run by rkt to attach a container to a
+// network, and to detach it, following a versioned protocol shared with
+// CNI (https://github.com/appc/cni):
+//
+// The command, ADD, DEL or VERSION, is given in CNI_COMMAND, along with
+// CNI_CONTAINERID, CNI_NETNS (the path of the network namespace of the
+// container), CNI_IFNAME (the interface to create in it), CNI_ARGS (extra
+// KEY=VALUE arguments, separated by semicolons) and CNI_PATH (the
+// directories plugins are looked up in, separated by colons). The network
+// configuration is passed as JSON on stdin.
+//
+// On success, ADD prints a Result on stdout, VERSION a VersionInfo and DEL
+// nothing. On failure, a plugin exits with a non-zero status after printing
+// an Error.
+//
+// Plugins of earlier rkt versions took their arguments from RKT_NETPLUGIN_*
+// variables and the path of the configuration in RKT_NETPLUGIN_NETCONF, and
+// printed the addresses of the container on a line, followed by resolver
+// settings in the resolv.conf format. The in-tree plugins still accept
+// being run that way, and rkt runs plugins which don't answer VERSION so.
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// PluginVersion is the version of the plugin protocol rkt and the in-tree
+// plugins speak
+const PluginVersion = "0.2.0"
+
+// supportedVersions are the protocol versions the results of which rkt and
+// the in-tree plugins understand
+var supportedVersions = []string{"0.1.0", "0.2.0"}
+
+// Codes of the errors reported by plugins; codes from 100 on are specific
+// to the plugin.
+const (
+	ErrCodeIncompatibleVersion = 1
+	ErrCodeUnsupportedField    = 2
+	ErrCodePlugin              = 100
+)
+
+// CmdArgs are the arguments a plugin is run with.
+type CmdArgs struct {
+	ContainerID string
+	Netns       string
+	IfName      string
+	Args        string
+	Path        string
+	StdinData   []byte
+}
+
+// IPNet is a net.IPNet marshalled in the CIDR notation, keeping the address
+// rather than the network.
+type IPNet net.IPNet
+
+func (n IPNet) MarshalJSON() ([]byte, error) {
+	return json.Marshal((*net.IPNet)(&n).String())
+}
+
+func (n *IPNet) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	ipn, err := ParseCIDR(s)
+	if err != nil {
+		return err
+	}
+	*n = IPNet(*ipn)
+	return nil
+}
+
+// Route is a route set up in the container, through GW or, if it isn't
+// set, the gateway of its address family.
+type Route struct {
+	Dst IPNet  `json:"dst"`
+	GW  net.IP `json:"gw,omitempty"`
+}
+
+// IPConfig is the address of a container of one address family, with the
+// gateway and the routes set up through it.
+type IPConfig struct {
+	IP      IPNet   `json:"ip"`
+	Gateway net.IP  `json:"gateway,omitempty"`
+	Routes  []Route `json:"routes,omitempty"`
+}
+
+// Result is what a plugin prints after adding a container to a network.
+type Result struct {
+	CNIVersion string    `json:"cniVersion,omitempty"`
+	IP4        *IPConfig `json:"ip4,omitempty"`
+	IP6        *IPConfig `json:"ip6,omitempty"`
+	DNS        DNS       `json:"dns,omitempty"`
+}
+
+// NewResult returns the result of adding a container with the address ipn
+// and, on dual-stack networks, ipn6, with the gateways gw and gw6, if any,
+// and the routes, given in CIDR notation, set up in the container. Routes
+// of a family the container has no address of are left out.
+func NewResult(ipn, ipn6 *net.IPNet, gw, gw6 net.IP, routes []string, dns DNS) (*Result, error) {
+	r := &Result{
+		IP4: &IPConfig{IP: IPNet(*ipn), Gateway: gw},
+		DNS: dns,
+	}
+	if ipn6 != nil {
+		r.IP6 = &IPConfig{IP: IPNet(*ipn6), Gateway: gw6}
+	}
+	for _, rt := range routes {
+		dst, err := ParseCIDR(rt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse route %q: %v", rt, err)
+		}
+		ipc := r.IP4
+		if dst.IP.To4() == nil {
+			ipc = r.IP6
+		}
+		if ipc == nil {
+			continue
+		}
+		ipc.Routes = append(ipc.Routes, Route{Dst: IPNet(*dst)})
+	}
+	return r, nil
+}
+
+// legacyString formats r as the plugins of earlier rkt versions did
+func (r *Result) legacyString() string {
+	addrs := (*net.IPNet)(&r.IP4.IP).String()
+	if r.IP6 != nil {
+		addrs += " " + (*net.IPNet)(&r.IP6.IP).String()
+	}
+	return addrs + "\n" + r.DNS.String()
+}
+
+// VersionInfo is what a plugin prints for VERSION.
+type VersionInfo struct {
+	CNIVersion        string   `json:"cniVersion"`
+	SupportedVersions []string `json:"supportedVersions"`
+}
+
+// Supports reports whether the results of plugins speaking a version of
+// the protocol in vi are understood.
+func (vi *VersionInfo) Supports() bool {
+	for _, v := range vi.SupportedVersions {
+		if versionSupported(v) {
+			return true
+		}
+	}
+	return false
+}
+
+func versionSupported(v string) bool {
+	for _, sv := range supportedVersions {
+		if v == sv {
+			return true
+		}
+	}
+	return false
+}
+
+// Error is what a plugin prints when it fails.
+type Error struct {
+	CNIVersion string `json:"cniVersion,omitempty"`
+	Code       uint   `json:"code"`
+	Msg        string `json:"msg"`
+	Details    string `json:"details,omitempty"`
+}
+
+func (e *Error) Error() string {
+	if e.Details == "" {
+		return e.Msg
+	}
+	return e.Msg + "; " + e.Details
+}
+
+// PluginCommand returns the command running the plugin at path, with no
+// environment. If root is set, the plugin is shipped in the rootfs at root,
+// e.g. the stage1 rootfs of a container, and, when dynamically linked, is
+// run with the dynamic loader and libraries of root rather than the host's.
+func PluginCommand(root, path string) (*exec.Cmd, error) {
+	c := &exec.Cmd{
+		Path: path,
+		Args: []string{path},
+	}
+	if root == "" {
+		return c, nil
+	}
+	interp, err := elfInterp(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading plugin %s: %v", path, err)
+	}
+	if interp != "" {
+		c.Path = filepath.Join(root, interp)
+		c.Args = []string{c.Path, path}
+		c.Env = []string{"LD_LIBRARY_PATH=" + filepath.Join(root, "usr/lib")}
+	}
+	return c, nil
+}
+
+// elfInterp returns the dynamic loader requested by the executable at
+// path, "" if it is statically linked or not an ELF file, e.g. a script
+func elfInterp(path string) (string, error) {
+	f, err := elf.Open(path)
+	if _, ok := err.(*elf.FormatError); ok {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	for _, p := range f.Progs {
+		if p.Type != elf.PT_INTERP {
+			continue
+		}
+		b, err := ioutil.ReadAll(p.Open())
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(b), "\x00"), nil
+	}
+	return "", nil
+}
+
+// ExecPlugin runs the plugin at path for the command cmd, returning what
+// it printed on stdout. The Error printed by a failing plugin is returned
+// as is.
+func ExecPlugin(path, cmd string, args *CmdArgs) ([]byte, error) {
+	return execPlugin("", path, cmd, args, os.Stderr)
+}
+
+// ExecRootPlugin is ExecPlugin for a plugin shipped in the rootfs at root,
+// see PluginCommand.
+func ExecRootPlugin(root, path, cmd string, args *CmdArgs) ([]byte, error) {
+	return execPlugin(root, path, cmd, args, os.Stderr)
+}
+
+func execPlugin(root, path, cmd string, args *CmdArgs, stderr io.Writer) ([]byte, error) {
+	c, err := PluginCommand(root, path)
+	if err != nil {
+		return nil, err
+	}
+	stdout := &bytes.Buffer{}
+	c.Env = append(c.Env,
+		"CNI_COMMAND="+cmd,
+		"CNI_CONTAINERID="+args.ContainerID,
+		"CNI_NETNS="+args.Netns,
+		"CNI_IFNAME="+args.IfName,
+		"CNI_ARGS="+args.Args,
+		"CNI_PATH="+args.Path,
+	)
+	c.Stdin = bytes.NewReader(args.StdinData)
+	c.Stdout = stdout
+	c.Stderr = stderr
+	if err := c.Run(); err != nil {
+		pe := &Error{}
+		if jerr := json.Unmarshal(stdout.Bytes(), pe); jerr == nil && pe.Msg != "" {
+			return nil, pe
+		}
+		return nil, err
+	}
+	return stdout.Bytes(), nil
+}
+
+// PluginVersionInfo runs the plugin at path, shipped in the rootfs at root
+// if set, for VERSION. It fails for plugins speaking the protocol of
+// earlier rkt versions, the complaints of which are discarded.
+func PluginVersionInfo(root, path string) (*VersionInfo, error) {
+	out, err := execPlugin(root, path, "VERSION", &CmdArgs{}, nil)
+	if err != nil {
+		return nil, err
+	}
+	vi := &VersionInfo{}
+	if err := json.Unmarshal(out, vi); err != nil {
+		return nil, fmt.Errorf("error parsing version of plugin %s: %v", path, err)
+	}
+	return vi, nil
+}
+
+// PluginMain runs a plugin adding containers to networks with cmdAdd and
+// removing them with cmdDel, speaking the protocol it is run with.
+func PluginMain(cmdAdd func(args *CmdArgs) (*Result, error), cmdDel func(args *CmdArgs) error) {
+	if os.Getenv("CNI_COMMAND") == "" && os.Getenv("RKT_NETPLUGIN_COMMAND") != "" {
+		legacyPluginMain(cmdAdd, cmdDel)
+		return
+	}
+
+	var out interface{}
+	err := func() error {
+		cmd := os.Getenv("CNI_COMMAND")
+		if cmd == "VERSION" {
+			out = &VersionInfo{CNIVersion: PluginVersion, SupportedVersions: supportedVersions}
+			return nil
+		}
+		args := &CmdArgs{
+			ContainerID: os.Getenv("CNI_CONTAINERID"),
+			Netns:       os.Getenv("CNI_NETNS"),
+			IfName:      os.Getenv("CNI_IFNAME"),
+			Args:        os.Getenv("CNI_ARGS"),
+			Path:        os.Getenv("CNI_PATH"),
+		}
+		if cmd == "" || args.ContainerID == "" || args.Netns == "" || args.IfName == "" {
+			return &Error{Code: ErrCodePlugin, Msg: "required environment variable missing"}
+		}
+		var err error
+		if args.StdinData, err = ioutil.ReadAll(os.Stdin); err != nil {
+			return fmt.Errorf("error reading network configuration: %v", err)
+		}
+		var conf struct {
+			CNIVersion string `json:"cniVersion"`
+		}
+		if err := json.Unmarshal(args.StdinData, &conf); err != nil {
+			return fmt.Errorf("error parsing network configuration: %v", err)
+		}
+		if conf.CNIVersion != "" && !versionSupported(conf.CNIVersion) {
+			return &Error{Code: ErrCodeIncompatibleVersion, Msg: fmt.Sprintf("unsupported cniVersion %q", conf.CNIVersion)}
+		}
+
+		switch cmd {
+		case "ADD":
+			r, err := cmdAdd(args)
+			if err != nil {
+				return err
+			}
+			r.CNIVersion = PluginVersion
+			out = r
+			return nil
+		case "DEL":
+			return cmdDel(args)
+		}
+		return &Error{Code: ErrCodePlugin, Msg: fmt.Sprintf("unknown CNI_COMMAND %q", cmd)}
+	}()
+
+	enc := json.NewEncoder(os.Stdout)
+	if err != nil {
+		pe, ok := err.(*Error)
+		if !ok {
+			pe = &Error{Code: ErrCodePlugin, Msg: err.Error()}
+		}
+		pe.CNIVersion = PluginVersion
+		enc.Encode(pe)
+		os.Exit(1)
+	}
+	if out != nil {
+		if err := enc.Encode(out); err != nil {
+			log.Printf("%v: %v", os.Args[0], err)
+			os.Exit(1)
+		}
+	}
+}
+
+// legacyPluginMain runs a plugin as rkt ran them before the protocol was
+// versioned, taking its arguments from RKT_NETPLUGIN_* variables
+func legacyPluginMain(cmdAdd func(args *CmdArgs) (*Result, error), cmdDel func(args *CmdArgs) error) {
+	cmd := os.Getenv("RKT_NETPLUGIN_COMMAND")
+	args := &CmdArgs{
+		ContainerID: os.Getenv("RKT_NETPLUGIN_CONTID"),
+		Netns:       os.Getenv("RKT_NETPLUGIN_NETNS"),
+		IfName:      os.Getenv("RKT_NETPLUGIN_IFNAME"),
+		Args:        os.Getenv("RKT_NETPLUGIN_ARGS"),
+	}
+	netConf := os.Getenv("RKT_NETPLUGIN_NETCONF")
+
+	if args.ContainerID == "" || args.Netns == "" || args.IfName == "" || netConf == "" {
+		log.Printf("Required env variable missing")
+		log.Print("Env: ", os.Environ())
+		os.Exit(1)
+	}
+
+	var err error
+	if args.StdinData, err = ioutil.ReadFile(netConf); err != nil {
+		log.Printf("%v: failed to load %q: %v", os.Args[0], netConf, err)
+		os.Exit(1)
+	}
+
+	switch cmd {
+	case "ADD":
+		var r *Result
+		if r, err = cmdAdd(args); err == nil {
+			_, err = fmt.Print(r.legacyString())
+		}
+
+	case "DEL":
+		err = cmdDel(args)
+
+	default:
+		log.Printf("Unknown RKT_NETPLUGIN_COMMAND: %v", cmd)
+		os.Exit(1)
+	}
+
+	if err != nil {
+		log.Printf("%v: %v", os.Args[0], err)
+		os.Exit(1)
+	}
+}
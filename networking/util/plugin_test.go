@@ -0,0 +1,143 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestIPNetJSON(t *testing.T) {
+	for _, s := range []string{`"10.1.2.3/24"`, `"fd00::42/64"`} {
+		var n IPNet
+		if err := json.Unmarshal([]byte(s), &n); err != nil {
+			t.Errorf("%s: unexpected error: %v", s, err)
+			continue
+		}
+		b, err := json.Marshal(n)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", s, err)
+		} else if string(b) != s {
+			t.Errorf("got %s, want %s", b, s)
+		}
+	}
+
+	var n IPNet
+	if err := json.Unmarshal([]byte(`"10.1.2.3"`), &n); err == nil {
+		t.Errorf("expected an error for an address without a prefix length")
+	}
+}
+
+func TestNewResult(t *testing.T) {
+	ipn, _ := ParseCIDR("10.1.2.3/24")
+	ipn6, _ := ParseCIDR("fd00::3/64")
+	gw := net.ParseIP("10.1.2.1")
+	routes := []string{"0.0.0.0/0", "::/0"}
+
+	r, err := NewResult(ipn, nil, gw, nil, routes, DNS{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if r.IP6 != nil {
+		t.Errorf("got an IPv6 address for an IPv4-only network")
+	}
+	dst, _ := ParseCIDR("0.0.0.0/0")
+	want := []Route{{Dst: IPNet(*dst)}}
+	if !reflect.DeepEqual(r.IP4.Routes, want) {
+		t.Errorf("got routes %v, want %v", r.IP4.Routes, want)
+	}
+	if l := r.legacyString(); l != "10.1.2.3/24\n" {
+		t.Errorf("got legacy output %q", l)
+	}
+
+	r, err = NewResult(ipn, ipn6, gw, nil, routes, DNS{Nameservers: []string{"10.1.0.53"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if r.IP6 == nil || len(r.IP6.Routes) != 1 {
+		t.Fatalf("got IPv6 config %+v, want one route", r.IP6)
+	}
+	if l := r.legacyString(); l != "10.1.2.3/24 fd00::3/64\nnameserver 10.1.0.53\n" {
+		t.Errorf("got legacy output %q", l)
+	}
+
+	if _, err := NewResult(ipn, nil, gw, nil, []string{"bogus"}, DNS{}); err == nil {
+		t.Errorf("expected an error for an invalid route")
+	}
+}
+
+func TestVersionInfoSupports(t *testing.T) {
+	tests := []struct {
+		versions []string
+		want     bool
+	}{
+		{[]string{"0.1.0"}, true},
+		{[]string{"0.2.0", "0.3.0"}, true},
+		{[]string{"1.0.0"}, false},
+		{nil, false},
+	}
+	for _, tt := range tests {
+		vi := &VersionInfo{SupportedVersions: tt.versions}
+		if g := vi.Supports(); g != tt.want {
+			t.Errorf("%v: got %v, want %v", tt.versions, g, tt.want)
+		}
+	}
+}
+
+func TestPluginCommand(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rkt-plugin-test")
+	if err != nil {
+		t.Fatalf("error creating temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	script := filepath.Join(dir, "script")
+	if err := ioutil.WriteFile(script, []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatalf("error writing plugin: %v", err)
+	}
+	c, err := PluginCommand(dir, script)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if c.Path != script || len(c.Env) != 0 {
+		t.Errorf("got %s %v, want the script run directly", c.Path, c.Env)
+	}
+
+	// a dynamically linked binary is run with the loader of the rootfs
+	interp, err := elfInterp("/bin/sh")
+	if err != nil || interp == "" {
+		t.Skipf("/bin/sh isn't dynamically linked")
+	}
+	c, err = PluginCommand(dir, "/bin/sh")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := filepath.Join(dir, interp); c.Path != want || !reflect.DeepEqual(c.Args, []string{want, "/bin/sh"}) {
+		t.Errorf("got %s %v, want %s run by %s", c.Path, c.Args, "/bin/sh", want)
+	}
+	if want := []string{"LD_LIBRARY_PATH=" + filepath.Join(dir, "usr/lib")}; !reflect.DeepEqual(c.Env, want) {
+		t.Errorf("got environment %v, want %v", c.Env, want)
+	}
+
+	// plugins of the host are run as is
+	if c, err = PluginCommand("", "/bin/sh"); err != nil || c.Path != "/bin/sh" {
+		t.Errorf("got %v, %v, want /bin/sh run directly", c, err)
+	}
+}
@@ -15,6 +15,7 @@
 package util
 
 import (
+	"fmt"
 	"net"
 
 	"github.com/coreos/rocket/Godeps/_workspace/src/github.com/vishvananda/netlink"
@@ -45,3 +46,37 @@ func AddHostRoute(ipn *net.IPNet, gw net.IP, dev netlink.Link) error {
 		Gw:        gw,
 	})
 }
+
+// AddLinkRoute adds a link-scoped route, to a destination directly
+// reachable through a device.
+func AddLinkRoute(ipn *net.IPNet, dev netlink.Link) error {
+	return netlink.RouteAdd(&netlink.Route{
+		LinkIndex: dev.Attrs().Index,
+		Scope:     netlink.SCOPE_LINK,
+		Dst:       ipn,
+	})
+}
+
+// AddRoutes adds the routes, given in CIDR notation, to a device through
+// gw, or gw6 for IPv6 destinations. Routes of a family without a gateway
+// are skipped.
+func AddRoutes(routes []string, gw, gw6 net.IP, dev netlink.Link) error {
+	for _, r := range routes {
+		dst, err := ParseCIDR(r)
+		if err != nil {
+			return fmt.Errorf("failed to parse route %q: %v", r, err)
+		}
+
+		via := gw
+		if dst.IP.To4() == nil {
+			via = gw6
+		}
+		if via == nil {
+			continue
+		}
+		if err = AddRoute(dst, via, dev); err != nil {
+			return fmt.Errorf("failed to add route %q: %v", dst, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"crypto/rand"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+	"unsafe"
+
+	"github.com/coreos/rocket/Godeps/_workspace/src/github.com/vishvananda/netlink"
+)
+
+const tunDevice = "/dev/net/tun"
+
+// ifReq is the struct ifreq of the TUNSETIFF ioctl
+type ifReq struct {
+	Name  [syscall.IFNAMSIZ]byte
+	Flags uint16
+	_     [24 - syscall.IFNAMSIZ + 16 - 2]byte
+}
+
+// CreateTap creates a persistent tap device, which outlives the file
+// descriptor it is created through so that a virtual machine monitor can
+// attach to it by name, and brings it up.
+func CreateTap(name string) (netlink.Link, error) {
+	f, err := os.OpenFile(tunDevice, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var req ifReq
+	copy(req.Name[:syscall.IFNAMSIZ-1], name)
+	req.Flags = syscall.IFF_TAP | syscall.IFF_NO_PI
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), syscall.TUNSETIFF, uintptr(unsafe.Pointer(&req))); errno != 0 {
+		return nil, fmt.Errorf("error creating tap %q: %v", name, errno)
+	}
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), syscall.TUNSETPERSIST, 1); errno != 0 {
+		return nil, fmt.Errorf("error making tap %q persistent: %v", name, errno)
+	}
+
+	tap, err := netlink.LinkByName(name)
+	if err != nil {
+		return nil, err
+	}
+	if err := netlink.LinkSetUp(tap); err != nil {
+		return nil, err
+	}
+	return tap, nil
+}
+
+// RandomMAC returns a random unicast, locally administered, hardware
+// address.
+func RandomMAC() (net.HardwareAddr, error) {
+	mac := make(net.HardwareAddr, 6)
+	if _, err := rand.Read(mac); err != nil {
+		return nil, err
+	}
+	mac[0] = mac[0]&^0x01 | 0x02
+	return mac, nil
+}
@@ -36,6 +36,12 @@ func Stage1RootfsPath(root string) string {
 	return filepath.Join(root, Stage1Dir)
 }
 
+// Stage1FlavorPath returns the path of the file in root naming the flavor
+// of stage1, i.e. how it runs the container, when not the default one
+func Stage1FlavorPath(root string) string {
+	return filepath.Join(Stage1RootfsPath(root), "flavor")
+}
+
 // ContainerManifestPath returns the path in root to the Container Runtime Manifest
 func ContainerManifestPath(root string) string {
 	return filepath.Join(root, "container")
@@ -53,6 +59,45 @@ func AppRootfsPath(root string, imageID types.Hash) string {
 	return filepath.Join(AppImagePath(root, imageID), aci.RootfsDir)
 }
 
+// AppVerityDataPath returns the path of the filesystem image an app's rootfs
+// is packed into when it is protected with dm-verity.
+func AppVerityDataPath(root string, imageID types.Hash) string {
+	return filepath.Join(AppImagePath(root, imageID), "rootfs.squashfs")
+}
+
+// AppVerityHashPath returns the path of the dm-verity hash tree of an app's
+// rootfs image.
+func AppVerityHashPath(root string, imageID types.Hash) string {
+	return filepath.Join(AppImagePath(root, imageID), "rootfs.verity")
+}
+
+// EmptyVolumePath returns the directory created by stage1 for the empty
+// volume of the given name, which lives as long as the container. It is
+// outside of the stage1 rootfs.
+func EmptyVolumePath(root string, name types.ACName) string {
+	return filepath.Join(root, "empty-volumes", name.String())
+}
+
+// AppOverlayPath returns the directory holding the upper and work directories
+// of the overlay an app's rootfs is mounted from when it comes from the tree
+// store. It is outside of the stage1 rootfs.
+func AppOverlayPath(root string, imageID types.Hash) string {
+	return filepath.Join(OverlaysPath(root), types.ShortHash(imageID.String()))
+}
+
+// OverlaysPath returns the directory holding the overlays of the apps of
+// the container in root, see AppOverlayPath
+func OverlaysPath(root string) string {
+	return filepath.Join(root, "overlay")
+}
+
+// TreesPath returns the directory holding an empty file named after each
+// tree of the tree store the container in root uses, so that they are kept
+// while it exists
+func TreesPath(root string) string {
+	return filepath.Join(root, "trees")
+}
+
 // RelAppImagePath returns the path of an application image relative to the
 // stage1 chroot
 func RelAppImagePath(imageID types.Hash) string {
@@ -0,0 +1,135 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//+build linux
+
+// Package apparmor confines apps with AppArmor profiles.
+package apparmor
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+)
+
+const (
+	// ProfileAnnotation names the AppArmor profile an app is confined by,
+	// or the file it is loaded from. Set on the container runtime
+	// manifest, it applies to the apps which don't set it.
+	ProfileAnnotation = "os/linux/apparmor-profile"
+
+	// Unconfined is the profile of processes which aren't confined
+	Unconfined = "unconfined"
+
+	enabledParam = "/sys/module/apparmor/parameters/enabled"
+	profilesFile = "/sys/kernel/security/apparmor/profiles"
+)
+
+// Enabled reports whether AppArmor is enabled on the host.
+func Enabled() bool {
+	b, err := ioutil.ReadFile(enabledParam)
+	return err == nil && strings.TrimSpace(string(b)) == "Y"
+}
+
+// Resolve returns the name of the profile an app given profile is
+// confined by, after checking it is loaded. A profile given as the path
+// of a file is loaded, or replaced, first; the file must hold a single
+// profile.
+func Resolve(profile string) (string, error) {
+	if profile == Unconfined {
+		return profile, nil
+	}
+	if !Enabled() {
+		return "", fmt.Errorf("AppArmor is not enabled, app can't be confined by profile %q", profile)
+	}
+	if strings.HasPrefix(profile, "/") {
+		name, err := load(profile)
+		if err != nil {
+			return "", err
+		}
+		profile = name
+	}
+
+	f, err := os.Open(profilesFile)
+	if err != nil {
+		return "", fmt.Errorf("error reading loaded AppArmor profiles: %v", err)
+	}
+	defer f.Close()
+	loaded, err := parseProfiles(f)
+	if err != nil {
+		return "", fmt.Errorf("error reading loaded AppArmor profiles: %v", err)
+	}
+	if _, ok := loaded[profile]; !ok {
+		return "", fmt.Errorf("AppArmor profile %q is not loaded", profile)
+	}
+	return profile, nil
+}
+
+// load loads the profile in the file path with apparmor_parser, replacing
+// it if it is loaded already, and returns its name
+func load(path string) (string, error) {
+	out, err := exec.Command("apparmor_parser", "--names", path).Output()
+	if err != nil {
+		return "", fmt.Errorf("error reading AppArmor profile %s: %v", path, parserError(err))
+	}
+	names := strings.Fields(string(out))
+	if len(names) != 1 {
+		return "", fmt.Errorf("AppArmor profile file %s must hold a single profile, got %d", path, len(names))
+	}
+	if err := exec.Command("apparmor_parser", "--replace", "--write-cache", path).Run(); err != nil {
+		return "", fmt.Errorf("error loading AppArmor profile %s: %v", path, parserError(err))
+	}
+	return names[0], nil
+}
+
+// parserError adds the output of a failed apparmor_parser to its error
+func parserError(err error) error {
+	if ee, ok := err.(*exec.ExitError); ok && len(ee.Stderr) > 0 {
+		return fmt.Errorf("%v: %s", err, bytes.TrimSpace(ee.Stderr))
+	}
+	return err
+}
+
+// parseProfiles parses the list of loaded profiles, made of "NAME (MODE)"
+// lines, into a map of their modes by name
+func parseProfiles(r io.Reader) (map[string]string, error) {
+	profiles := make(map[string]string)
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := s.Text()
+		i := strings.LastIndex(line, " (")
+		if i < 0 || !strings.HasSuffix(line, ")") {
+			continue
+		}
+		profiles[line[:i]] = line[i+2 : len(line)-1]
+	}
+	return profiles, s.Err()
+}
+
+// ChangeOnExec confines the next program the calling thread executes by
+// profile, as aa_change_onexec does. The thread must stay locked to the
+// goroutine until then.
+func ChangeOnExec(profile string) error {
+	p := fmt.Sprintf("/proc/self/task/%d/attr/exec", syscall.Gettid())
+	if err := ioutil.WriteFile(p, []byte("exec "+profile), 0); err != nil {
+		return fmt.Errorf("error changing AppArmor profile on exec: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//+build linux
+
+package apparmor
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseProfiles(t *testing.T) {
+	got, err := parseProfiles(strings.NewReader(`/usr/sbin/ntpd (enforce)
+docker-default (enforce)
+rkt app (complain)
+garbage
+`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := map[string]string{
+		"/usr/sbin/ntpd": "enforce",
+		"docker-default": "enforce",
+		"rkt app":        "complain",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
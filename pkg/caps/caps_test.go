@@ -0,0 +1,41 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package caps
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	for val, want := range map[string][]string{
+		"CAP_NET_RAW":               {"CAP_NET_RAW"},
+		"net_raw, CAP_SYS_ADMIN":    {"CAP_NET_RAW", "CAP_SYS_ADMIN"},
+		"CAP_CHOWN CAP_KILL,setuid": {"CAP_CHOWN", "CAP_KILL", "CAP_SETUID"},
+		"":                          nil,
+	} {
+		got, err := Parse(val)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", val, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%q: got %v, want %v", val, got, want)
+		}
+	}
+	if _, err := Parse("CAP_FLY"); err == nil {
+		t.Errorf("expected an error")
+	}
+}
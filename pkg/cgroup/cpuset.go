@@ -0,0 +1,194 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cgroup
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/appc/spec/schema/types"
+)
+
+// CPUSet is the CPUs and memory nodes a container is pinned to, as lists
+// like "0-3,8", an empty list leaving it unpinned.
+type CPUSet struct {
+	CPUs string
+	Mems string
+}
+
+// ParseCPUList parses a list of numbers and ranges, e.g. "0-3,8", into the
+// numbers it includes.
+func ParseCPUList(val string) ([]int, error) {
+	var list []int
+	for _, r := range strings.Split(val, ",") {
+		bounds := strings.SplitN(r, "-", 2)
+		first, err := strconv.Atoi(bounds[0])
+		if err != nil || first < 0 {
+			return nil, fmt.Errorf("invalid list %q, want numbers and ranges, e.g. 0-3,8", val)
+		}
+		last := first
+		if len(bounds) == 2 {
+			if last, err = strconv.Atoi(bounds[1]); err != nil || last < first {
+				return nil, fmt.Errorf("invalid range %q in %q", r, val)
+			}
+		}
+		for i := first; i <= last; i++ {
+			list = append(list, i)
+		}
+	}
+	return list, nil
+}
+
+// formatCPUList formats the numbers of a set as a list of numbers and
+// ranges
+func formatCPUList(set map[int]bool) string {
+	var nums []int
+	for n := range set {
+		nums = append(nums, n)
+	}
+	sort.Ints(nums)
+	var ranges []string
+	for i := 0; i < len(nums); {
+		j := i
+		for j+1 < len(nums) && nums[j+1] == nums[j]+1 {
+			j++
+		}
+		if i == j {
+			ranges = append(ranges, strconv.Itoa(nums[i]))
+		} else {
+			ranges = append(ranges, fmt.Sprintf("%d-%d", nums[i], nums[j]))
+		}
+		i = j + 1
+	}
+	return strings.Join(ranges, ",")
+}
+
+// ContainerCPUSet returns the CPUs and memory nodes the cpuset isolators
+// pin the container to. Where several isolators pin it, it gets all the
+// CPUs or nodes they give.
+func ContainerCPUSet(isolators types.Isolators) (*CPUSet, error) {
+	cpus := make(map[int]bool)
+	mems := make(map[int]bool)
+	for _, i := range isolators {
+		var set map[int]bool
+		switch i.Name {
+		case CPUSetCPUsIsolator:
+			set = cpus
+		case CPUSetMemsIsolator:
+			set = mems
+		default:
+			continue
+		}
+		list, err := ParseCPUList(i.Val)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", i.Name, err)
+		}
+		for _, n := range list {
+			set[n] = true
+		}
+	}
+	return &CPUSet{CPUs: formatCPUList(cpus), Mems: formatCPUList(mems)}, nil
+}
+
+// Empty reports whether s leaves the container unpinned.
+func (s *CPUSet) Empty() bool {
+	return s.CPUs == "" && s.Mems == ""
+}
+
+// JoinCPUSet moves the process pid into the cpuset cgroup of the
+// container with the given UUID, creating it with the CPUs and memory
+// nodes of s. Those it doesn't set are inherited from the parent cgroup.
+func JoinCPUSet(uuid string, pid int, s *CPUSet) error {
+	cg, err := containerPath("cpuset", uuid, ErrNoController)
+	if err != nil {
+		return fmt.Errorf("error finding cpuset cgroup: %v", err)
+	}
+	// a cpuset cgroup takes no process until its CPUs and nodes are set,
+	// the rkt cgroup is initialized from the root one
+	for _, dir := range []string{filepath.Dir(cg), cg} {
+		if err := os.Mkdir(dir, 0755); err != nil && !os.IsExist(err) {
+			return fmt.Errorf("error creating cpuset cgroup: %v", err)
+		}
+		for _, file := range []string{"cpuset.cpus", "cpuset.mems"} {
+			if err := inheritCgroupFile(dir, file); err != nil {
+				return err
+			}
+		}
+	}
+	if s.CPUs != "" {
+		if err := writeCgroupFile(cg, "cpuset.cpus", s.CPUs); err != nil {
+			return err
+		}
+	}
+	if s.Mems != "" {
+		if err := writeCgroupFile(cg, "cpuset.mems", s.Mems); err != nil {
+			return err
+		}
+	}
+	return writeCgroupFile(cg, "cgroup.procs", strconv.Itoa(pid))
+}
+
+// inheritCgroupFile copies file from the parent of cg if it is empty in cg
+func inheritCgroupFile(cg, file string) error {
+	b, err := ioutil.ReadFile(filepath.Join(cg, file))
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(string(b)) != "" {
+		return nil
+	}
+	b, err = ioutil.ReadFile(filepath.Join(filepath.Dir(cg), file))
+	if err != nil {
+		return err
+	}
+	return writeCgroupFile(cg, file, strings.TrimSpace(string(b)))
+}
+
+// EffectiveCPUSet returns the CPUs and memory nodes the container with the
+// given UUID runs on, nil if it isn't pinned.
+func EffectiveCPUSet(uuid string) (*CPUSet, error) {
+	cg, err := containerPath("cpuset", uuid, ErrNoController)
+	if err == ErrNoController {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(cg); os.IsNotExist(err) {
+		return nil, nil
+	}
+	read := func(name string) (string, error) {
+		// the effective values, restricted by the ancestors, are only
+		// available on recent kernels
+		b, err := ioutil.ReadFile(filepath.Join(cg, "cpuset.effective_"+name))
+		if os.IsNotExist(err) {
+			b, err = ioutil.ReadFile(filepath.Join(cg, "cpuset."+name))
+		}
+		return strings.TrimSpace(string(b)), err
+	}
+	var s CPUSet
+	if s.CPUs, err = read("cpus"); err != nil {
+		return nil, err
+	}
+	if s.Mems, err = read("mems"); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
@@ -0,0 +1,46 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cgroup
+
+import (
+	"testing"
+
+	"github.com/appc/spec/schema/types"
+)
+
+func TestContainerCPUSet(t *testing.T) {
+	s, err := ContainerCPUSet(types.Isolators{
+		{Name: CPUSetCPUsIsolator, Val: "0-2,8"},
+		{Name: CPUSetCPUsIsolator, Val: "3,9-10"},
+		{Name: CPUSetMemsIsolator, Val: "1"},
+		{Name: MemoryIsolator, Val: "1G"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if s.CPUs != "0-3,8-10" || s.Mems != "1" {
+		t.Errorf("got %+v, want CPUs 0-3,8-10 and Mems 1", s)
+	}
+
+	if s, err := ContainerCPUSet(nil); err != nil || !s.Empty() {
+		t.Errorf("got %+v, %v, want an empty set", s, err)
+	}
+
+	for _, val := range []string{"", "a", "3-1", "-1", "0,,1"} {
+		if _, err := ParseCPUList(val); err == nil {
+			t.Errorf("%q: expected an error", val)
+		}
+	}
+}
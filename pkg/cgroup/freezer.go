@@ -0,0 +1,224 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package cgroup manages the cgroups rkt puts containers in. The freezer
+// controller is used to pause and resume containers: stage1 moves itself
+// into a freezer cgroup of its own, rkt/UUID, before starting the
+// container, and all the processes of the container inherit it. The
+// resource isolators of the apps are applied by systemd in stage1, to the
+// cgroups of their services, except for the block I/O and device
+// isolators, which stage1 applies to the whole container through rkt/UUID
+// cgroups of the blkio and devices controllers, and the cpuset isolators,
+// applied through the cpuset controller.
+package cgroup
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	freezerFrozen = "FROZEN"
+	freezerThawed = "THAWED"
+
+	// how long Freeze waits for all the processes to be frozen
+	freezeTimeout = 5 * time.Second
+)
+
+// ErrNoFreezer is returned when the freezer cgroup of a container doesn't
+// exist, e.g. because the freezer hierarchy isn't mounted.
+var ErrNoFreezer = errors.New("no freezer cgroup for the container")
+
+// JoinFreezer moves the process pid into the freezer cgroup of the
+// container with the given UUID, creating it.
+func JoinFreezer(uuid string, pid int) error {
+	cg, err := freezerPath(uuid)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(cg, 0755); err != nil {
+		return fmt.Errorf("error creating freezer cgroup: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(cg, "cgroup.procs"), []byte(strconv.Itoa(pid)), 0644); err != nil {
+		return fmt.Errorf("error joining freezer cgroup: %v", err)
+	}
+	return nil
+}
+
+// Freeze freezes all the processes of the container with the given UUID,
+// waiting until they are.
+func Freeze(uuid string) error {
+	cg, err := existingFreezerPath(uuid)
+	if err != nil {
+		return err
+	}
+	if err := setFreezerState(cg, freezerFrozen); err != nil {
+		return err
+	}
+	// processes in uninterruptible sleep are frozen once they wake up
+	for deadline := time.Now().Add(freezeTimeout); ; {
+		st, err := freezerState(cg)
+		if err != nil {
+			return err
+		}
+		if st == freezerFrozen {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			setFreezerState(cg, freezerThawed)
+			return fmt.Errorf("processes still not frozen after %v", freezeTimeout)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// Thaw resumes the processes of the container with the given UUID.
+func Thaw(uuid string) error {
+	cg, err := existingFreezerPath(uuid)
+	if err != nil {
+		return err
+	}
+	return setFreezerState(cg, freezerThawed)
+}
+
+// Frozen reports whether the container with the given UUID is frozen.
+func Frozen(uuid string) (bool, error) {
+	cg, err := existingFreezerPath(uuid)
+	if err == ErrNoFreezer {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	st, err := freezerState(cg)
+	if err != nil {
+		return false, err
+	}
+	return st == freezerFrozen, nil
+}
+
+// RemoveFreezer removes the freezer cgroup of the container with the given
+// UUID, once all its processes exited.
+func RemoveFreezer(uuid string) error {
+	cg, err := existingFreezerPath(uuid)
+	if err == ErrNoFreezer {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(cg); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("error removing freezer cgroup: %v", err)
+	}
+	return nil
+}
+
+func freezerState(cg string) (string, error) {
+	b, err := ioutil.ReadFile(filepath.Join(cg, "freezer.state"))
+	if err != nil {
+		return "", fmt.Errorf("error reading freezer state: %v", err)
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+func setFreezerState(cg, state string) error {
+	if err := ioutil.WriteFile(filepath.Join(cg, "freezer.state"), []byte(state), 0644); err != nil {
+		return fmt.Errorf("error setting freezer state to %s: %v", state, err)
+	}
+	return nil
+}
+
+func existingFreezerPath(uuid string) (string, error) {
+	cg, err := freezerPath(uuid)
+	if err != nil {
+		return "", err
+	}
+	if _, err := os.Stat(cg); os.IsNotExist(err) {
+		return "", ErrNoFreezer
+	} else if err != nil {
+		return "", err
+	}
+	return cg, nil
+}
+
+// freezerPath returns the directory of the freezer cgroup of the container
+// with the given UUID.
+func freezerPath(uuid string) (string, error) {
+	return containerPath("freezer", uuid, ErrNoFreezer)
+}
+
+// containerPath returns the directory of the cgroup of the container with
+// the given UUID in the hierarchy of controller, or notFound if the
+// hierarchy isn't mounted.
+func containerPath(controller, uuid string, notFound error) (string, error) {
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	mp, err := findController(f, controller)
+	if err == ErrNoController {
+		return "", notFound
+	}
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(mp, "rkt", uuid), nil
+}
+
+// findFreezer returns the mount point of the freezer hierarchy from the
+// contents of /proc/self/mountinfo.
+func findFreezer(r io.Reader) (string, error) {
+	mp, err := findController(r, "freezer")
+	if err == ErrNoController {
+		return "", ErrNoFreezer
+	}
+	return mp, err
+}
+
+// findController returns the mount point of the hierarchy of controller
+// from the contents of /proc/self/mountinfo.
+func findController(r io.Reader, controller string) (string, error) {
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		// optional fields end with a "-", followed by the filesystem type,
+		// the source and the superblock options
+		parts := strings.SplitN(s.Text(), " - ", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		fields := strings.Fields(parts[0])
+		fs := strings.Fields(parts[1])
+		if len(fields) < 5 || len(fs) < 3 || fs[0] != "cgroup" {
+			continue
+		}
+		for _, opt := range strings.Split(fs[2], ",") {
+			if opt == controller {
+				return fields[4], nil
+			}
+		}
+	}
+	if err := s.Err(); err != nil {
+		return "", err
+	}
+	return "", ErrNoController
+}
@@ -0,0 +1,41 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cgroup
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFindFreezer(t *testing.T) {
+	mountinfo := `17 22 0:16 / /sys rw,nosuid,nodev,noexec,relatime shared:6 - sysfs sysfs rw
+24 17 0:20 / /sys/fs/cgroup ro,nosuid,nodev,noexec shared:9 - tmpfs tmpfs ro,mode=755
+26 24 0:22 / /sys/fs/cgroup/systemd rw,nosuid,nodev,noexec,relatime shared:10 - cgroup cgroup rw,xattr,name=systemd
+29 24 0:25 / /sys/fs/cgroup/cpu,cpuacct rw,nosuid,nodev,noexec,relatime shared:13 - cgroup cgroup rw,cpu,cpuacct
+31 24 0:27 / /sys/fs/cgroup/freezer rw,nosuid,nodev,noexec,relatime shared:15 - cgroup cgroup rw,freezer
+`
+	mp, err := findFreezer(strings.NewReader(mountinfo))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if mp != "/sys/fs/cgroup/freezer" {
+		t.Errorf("got %q, want %q", mp, "/sys/fs/cgroup/freezer")
+	}
+
+	noFreezer := strings.Replace(mountinfo, "rw,freezer", "rw,devices", 1)
+	if _, err := findFreezer(strings.NewReader(noFreezer)); err != ErrNoFreezer {
+		t.Errorf("got error %v, want ErrNoFreezer", err)
+	}
+}
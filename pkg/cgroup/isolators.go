@@ -0,0 +1,151 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cgroup
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/appc/spec/schema/types"
+	"github.com/coreos/rocket/pkg/quota"
+)
+
+const (
+	// MemoryIsolator limits the memory of an app, its value is a size in
+	// bytes, optionally followed by one of the unit suffixes K, M, G or T
+	MemoryIsolator = "resource/memory"
+	// CPUIsolator sets the share of the CPU time an app gets relative to
+	// the other apps, its value is a number of CPU shares
+	CPUIsolator = "resource/cpu"
+
+	// The block I/O and device isolators apply to the whole container.
+	// BlockIOWeightIsolator sets the share of block I/O it gets relative
+	// to the other cgroups, from 10 to 1000
+	BlockIOWeightIsolator = "resource/block-io-weight"
+	// BlockIOReadBandwidthIsolator and BlockIOWriteBandwidthIsolator
+	// limit the bandwidth to a block device, their value is the path of
+	// the device and a size in bytes per second, e.g. "/dev/sda 10M"
+	BlockIOReadBandwidthIsolator  = "resource/block-io-read-bandwidth"
+	BlockIOWriteBandwidthIsolator = "resource/block-io-write-bandwidth"
+	// BlockIOReadIOPSIsolator and BlockIOWriteIOPSIsolator limit the
+	// operations per second on a block device, e.g. "/dev/sda 100"
+	BlockIOReadIOPSIsolator  = "resource/block-io-read-iops"
+	BlockIOWriteIOPSIsolator = "resource/block-io-write-iops"
+	// DeviceIsolator makes a device node of the host available to the
+	// apps, its value is the path of the device optionally followed by
+	// the access allowed, a combination of r, w and m defaulting to rwm
+	DeviceIsolator = "resource/device"
+	// CPUSetCPUsIsolator and CPUSetMemsIsolator pin the container to CPUs
+	// and NUMA memory nodes, their value is a list of numbers and ranges,
+	// e.g. "0-3,8"
+	CPUSetCPUsIsolator = "resource/cpuset-cpus"
+	CPUSetMemsIsolator = "resource/cpuset-mems"
+
+	// the bounds of cpu.shares enforced by the kernel
+	minCPUShares = 2
+	maxCPUShares = 262144
+	// the bounds of blkio.weight
+	minBlkioWeight = 10
+	maxBlkioWeight = 1000
+)
+
+// ParseMemory parses the value of a MemoryIsolator.
+func ParseMemory(val string) (uint64, error) {
+	limit, err := quota.ParseSize(val)
+	if err != nil {
+		return 0, err
+	}
+	if limit == 0 {
+		return 0, fmt.Errorf("invalid memory limit %q", val)
+	}
+	return limit, nil
+}
+
+// ParseCPUShares parses the value of a CPUIsolator.
+func ParseCPUShares(val string) (uint64, error) {
+	shares, err := strconv.ParseUint(val, 10, 64)
+	if err != nil || shares < minCPUShares || shares > maxCPUShares {
+		return 0, fmt.Errorf("invalid CPU shares %q, want a number from %d to %d", val, minCPUShares, maxCPUShares)
+	}
+	return shares, nil
+}
+
+// ParseBlockIOWeight parses the value of a BlockIOWeightIsolator.
+func ParseBlockIOWeight(val string) (uint64, error) {
+	w, err := strconv.ParseUint(val, 10, 64)
+	if err != nil || w < minBlkioWeight || w > maxBlkioWeight {
+		return 0, fmt.Errorf("invalid block I/O weight %q, want a number from %d to %d", val, minBlkioWeight, maxBlkioWeight)
+	}
+	return w, nil
+}
+
+// ParseBlockIOLimit parses the value of the bandwidth and IOPS isolators
+// into the path of the device and its limit. Bandwidths may have a unit
+// suffix.
+func ParseBlockIOLimit(name, val string) (string, uint64, error) {
+	fields := strings.Fields(val)
+	if len(fields) != 2 {
+		return "", 0, fmt.Errorf("invalid %s %q, want DEVICE LIMIT", name, val)
+	}
+	var limit uint64
+	var err error
+	switch name {
+	case BlockIOReadBandwidthIsolator, BlockIOWriteBandwidthIsolator:
+		limit, err = quota.ParseSize(fields[1])
+	case BlockIOReadIOPSIsolator, BlockIOWriteIOPSIsolator:
+		limit, err = strconv.ParseUint(fields[1], 10, 64)
+	default:
+		return "", 0, fmt.Errorf("%s is not a block I/O limit", name)
+	}
+	if err != nil || limit == 0 {
+		return "", 0, fmt.Errorf("invalid %s limit %q", name, fields[1])
+	}
+	return fields[0], limit, nil
+}
+
+// ParseDevice parses the value of a DeviceIsolator into the path of the
+// device and the access allowed.
+func ParseDevice(val string) (string, string, error) {
+	fields := strings.Fields(val)
+	switch len(fields) {
+	case 1:
+		return fields[0], "rwm", nil
+	case 2:
+		if fields[1] == "" || strings.Trim(fields[1], "rwm") != "" {
+			return "", "", fmt.Errorf("invalid device access %q, want a combination of r, w and m", fields[1])
+		}
+		return fields[0], fields[1], nil
+	}
+	return "", "", fmt.Errorf("invalid device %q, want DEVICE [ACCESS]", val)
+}
+
+// OverrideIsolators returns isolators with those named in over replaced.
+func OverrideIsolators(isolators, over types.Isolators) types.Isolators {
+	var out types.Isolators
+	for _, i := range isolators {
+		overridden := false
+		for _, o := range over {
+			if o.Name == i.Name {
+				overridden = true
+				break
+			}
+		}
+		if !overridden {
+			out = append(out, i)
+		}
+	}
+	return append(out, over...)
+}
@@ -0,0 +1,100 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cgroup
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/appc/spec/schema/types"
+)
+
+func TestParseIsolators(t *testing.T) {
+	if v, err := ParseMemory("512M"); err != nil || v != 512<<20 {
+		t.Errorf("got %d, %v, want %d", v, err, 512<<20)
+	}
+	for _, s := range []string{"", "0", "lots", "-1G"} {
+		if _, err := ParseMemory(s); err == nil {
+			t.Errorf("%q: expected an error", s)
+		}
+	}
+
+	if v, err := ParseCPUShares("512"); err != nil || v != 512 {
+		t.Errorf("got %d, %v, want 512", v, err)
+	}
+	for _, s := range []string{"", "1", "262145", "0.5"} {
+		if _, err := ParseCPUShares(s); err == nil {
+			t.Errorf("%q: expected an error", s)
+		}
+	}
+
+	if v, err := ParseBlockIOWeight("500"); err != nil || v != 500 {
+		t.Errorf("got %d, %v, want 500", v, err)
+	}
+	for _, s := range []string{"", "9", "1001"} {
+		if _, err := ParseBlockIOWeight(s); err == nil {
+			t.Errorf("%q: expected an error", s)
+		}
+	}
+
+	if dev, v, err := ParseBlockIOLimit(BlockIOReadBandwidthIsolator, "/dev/sda 10M"); err != nil || dev != "/dev/sda" || v != 10<<20 {
+		t.Errorf("got %q, %d, %v, want /dev/sda, %d", dev, v, err, 10<<20)
+	}
+	if dev, v, err := ParseBlockIOLimit(BlockIOWriteIOPSIsolator, "/dev/sdb 100"); err != nil || dev != "/dev/sdb" || v != 100 {
+		t.Errorf("got %q, %d, %v, want /dev/sdb, 100", dev, v, err)
+	}
+	for _, s := range []string{"", "/dev/sda", "/dev/sda 0", "/dev/sda 10M"} {
+		if _, _, err := ParseBlockIOLimit(BlockIOReadIOPSIsolator, s); err == nil {
+			t.Errorf("%q: expected an error", s)
+		}
+	}
+
+	for s, want := range map[string]string{"/dev/fuse": "rwm", "/dev/fuse rw": "rw"} {
+		if dev, access, err := ParseDevice(s); err != nil || dev != "/dev/fuse" || access != want {
+			t.Errorf("%q: got %q, %q, %v, want /dev/fuse, %q", s, dev, access, err, want)
+		}
+	}
+	for _, s := range []string{"", "/dev/fuse rx", "/dev/fuse r w"} {
+		if _, _, err := ParseDevice(s); err == nil {
+			t.Errorf("%q: expected an error", s)
+		}
+	}
+}
+
+func TestDeviceRule(t *testing.T) {
+	for d, want := range map[Device]string{
+		{"/dev/fuse", 'c', 10, 229, "rw"}:   "c 10:229 rw",
+		{"/dev/pts/*", 'c', 136, -1, "rwm"}: "c 136:* rwm",
+	} {
+		if got := d.rule(); got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	}
+}
+
+func TestOverrideIsolators(t *testing.T) {
+	isolators := types.Isolators{
+		{Name: "resource/memory", Val: "1G"},
+		{Name: "capabilities/bounding-set", Val: "CAP_NET_BIND_SERVICE"},
+	}
+	got := OverrideIsolators(isolators, types.Isolators{{Name: "resource/memory", Val: "256M"}})
+	want := types.Isolators{
+		{Name: "capabilities/bounding-set", Val: "CAP_NET_BIND_SERVICE"},
+		{Name: "resource/memory", Val: "256M"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
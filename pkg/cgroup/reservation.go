@@ -0,0 +1,175 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cgroup
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/appc/spec/schema/types"
+)
+
+// cpuSharesPerCPU is the number of CPU shares taken as reserving a whole
+// CPU, the default shares of a cgroup
+const cpuSharesPerCPU = 1024
+
+// Reservation is the memory and CPU time containers reserve through the
+// resource/memory and resource/cpu isolators of their apps. Apps without
+// them reserve nothing.
+type Reservation struct {
+	// Memory is in bytes
+	Memory    uint64
+	CPUShares uint64
+}
+
+// AppReservation returns the reservation of an app with the given
+// isolators, the last of each name applying as in its unit.
+func AppReservation(isolators types.Isolators) (Reservation, error) {
+	var r Reservation
+	for _, i := range isolators {
+		var err error
+		switch i.Name {
+		case MemoryIsolator:
+			r.Memory, err = ParseMemory(i.Val)
+		case CPUIsolator:
+			r.CPUShares, err = ParseCPUShares(i.Val)
+		}
+		if err != nil {
+			return Reservation{}, err
+		}
+	}
+	return r, nil
+}
+
+// Add returns the sum of r and o.
+func (r Reservation) Add(o Reservation) Reservation {
+	return Reservation{Memory: r.Memory + o.Memory, CPUShares: r.CPUShares + o.CPUShares}
+}
+
+// Empty reports whether nothing is reserved.
+func (r Reservation) Empty() bool {
+	return r.Memory == 0 && r.CPUShares == 0
+}
+
+// PreflightPolicy tells what to do when a container reserves more than
+// the host has left.
+type PreflightPolicy string
+
+const (
+	// PreflightWarn sets up the container anyway, with a warning
+	PreflightWarn PreflightPolicy = "warn"
+	// PreflightRefuse doesn't set it up
+	PreflightRefuse PreflightPolicy = "refuse"
+)
+
+// Set implements the flag.Value interface
+func (p *PreflightPolicy) Set(s string) error {
+	switch pp := PreflightPolicy(s); pp {
+	case PreflightWarn, PreflightRefuse:
+		*p = pp
+		return nil
+	}
+	return fmt.Errorf("unknown policy %q, want %q or %q", s, PreflightWarn, PreflightRefuse)
+}
+
+func (p *PreflightPolicy) String() string {
+	return string(*p)
+}
+
+// Host is the capacity of the host.
+type Host struct {
+	// MemTotal and MemAvailable are in bytes, as in /proc/meminfo
+	MemTotal     uint64
+	MemAvailable uint64
+	CPUs         int
+}
+
+// ReadHost returns the capacity of the host.
+func ReadHost() (*Host, error) {
+	f, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return nil, fmt.Errorf("error reading memory of the host: %v", err)
+	}
+	defer f.Close()
+	h, err := parseMeminfo(f)
+	if err != nil {
+		return nil, fmt.Errorf("error reading memory of the host: %v", err)
+	}
+	h.CPUs = runtime.NumCPU()
+	return h, nil
+}
+
+// parseMeminfo parses the total and available memory out of the contents
+// of /proc/meminfo
+func parseMeminfo(r io.Reader) (*Host, error) {
+	var h Host
+	var total, avail bool
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) != 3 || fields[2] != "kB" {
+			continue
+		}
+		v, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid line %q", s.Text())
+		}
+		switch fields[0] {
+		case "MemTotal:":
+			h.MemTotal, total = v<<10, true
+		case "MemAvailable:":
+			h.MemAvailable, avail = v<<10, true
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	if !total || !avail {
+		return nil, fmt.Errorf("no MemTotal or MemAvailable")
+	}
+	return &h, nil
+}
+
+// Check returns an error saying how the host is oversubscribed if a
+// container reserving requested is added to those reserving reserved: if
+// the memory reserved in all exceeds the memory of the host, or what the
+// container requests exceeds the memory available right now, or if the
+// CPU shares reserved exceed those of all the CPUs of the host, a CPU
+// being worth 1024 shares.
+func (h *Host) Check(reserved, requested Reservation) error {
+	var problems []string
+	if requested.Memory > 0 {
+		if total := reserved.Memory + requested.Memory; total > h.MemTotal {
+			problems = append(problems, fmt.Sprintf("%dM of memory reserved with the %dM requested, the host has %dM", total>>20, requested.Memory>>20, h.MemTotal>>20))
+		}
+		if requested.Memory > h.MemAvailable {
+			problems = append(problems, fmt.Sprintf("%dM of memory requested, %dM available", requested.Memory>>20, h.MemAvailable>>20))
+		}
+	}
+	if requested.CPUShares > 0 {
+		if total := reserved.CPUShares + requested.CPUShares; total > uint64(h.CPUs)*cpuSharesPerCPU {
+			problems = append(problems, fmt.Sprintf("%.2f CPUs reserved with the %.2f requested, the host has %d", float64(total)/cpuSharesPerCPU, float64(requested.CPUShares)/cpuSharesPerCPU, h.CPUs))
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("host oversubscribed: %s", strings.Join(problems, ", "))
+	}
+	return nil
+}
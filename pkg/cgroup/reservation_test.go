@@ -0,0 +1,77 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cgroup
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/appc/spec/schema/types"
+)
+
+func TestAppReservation(t *testing.T) {
+	r, err := AppReservation(types.Isolators{
+		{Name: MemoryIsolator, Val: "1G"},
+		{Name: CPUIsolator, Val: "512"},
+		{Name: MemoryIsolator, Val: "256M"},
+		{Name: CPUSetCPUsIsolator, Val: "0-1"},
+	})
+	if err != nil || r != (Reservation{Memory: 256 << 20, CPUShares: 512}) {
+		t.Errorf("got %+v, %v", r, err)
+	}
+	if _, err := AppReservation(types.Isolators{{Name: CPUIsolator, Val: "lots"}}); err == nil {
+		t.Errorf("expected an error for invalid CPU shares")
+	}
+}
+
+func TestParseMeminfo(t *testing.T) {
+	in := `MemTotal:        2048000 kB
+MemFree:          512000 kB
+MemAvailable:    1024000 kB
+HugePages_Total:       0
+`
+	h, err := parseMeminfo(strings.NewReader(in))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if h.MemTotal != 2048000<<10 || h.MemAvailable != 1024000<<10 {
+		t.Errorf("got %+v", h)
+	}
+	if _, err := parseMeminfo(strings.NewReader("MemTotal: 2048000 kB\n")); err == nil {
+		t.Errorf("expected an error without MemAvailable")
+	}
+}
+
+func TestCheckReservation(t *testing.T) {
+	h := &Host{MemTotal: 4 << 30, MemAvailable: 1 << 30, CPUs: 2}
+	for i, tt := range []struct {
+		reserved, requested Reservation
+		ok                  bool
+	}{
+		{Reservation{}, Reservation{Memory: 512 << 20, CPUShares: 1024}, true},
+		{Reservation{Memory: 3 << 30}, Reservation{Memory: 512 << 20}, true},
+		{Reservation{Memory: 3 << 30}, Reservation{Memory: 1 << 30}, true},
+		{Reservation{Memory: 3<<30 + 1}, Reservation{Memory: 1 << 30}, false},
+		{Reservation{}, Reservation{Memory: 2 << 30}, false},
+		{Reservation{CPUShares: 1024}, Reservation{CPUShares: 1024}, true},
+		{Reservation{CPUShares: 1536}, Reservation{CPUShares: 1024}, false},
+		// already oversubscribed, but nothing requested
+		{Reservation{Memory: 8 << 30, CPUShares: 4096}, Reservation{}, true},
+	} {
+		if err := h.Check(tt.reserved, tt.requested); (err == nil) != tt.ok {
+			t.Errorf("#%d: got %v", i, err)
+		}
+	}
+}
@@ -0,0 +1,252 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cgroup
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"syscall"
+
+	"github.com/appc/spec/schema/types"
+)
+
+// ErrNoController is returned when the hierarchy of a controller a
+// container needs isn't mounted.
+var ErrNoController = errors.New("cgroup controller not mounted")
+
+// BlkioLimits are the block I/O limits of a container. Per-device limits
+// are keyed by MAJOR:MINOR.
+type BlkioLimits struct {
+	// Weight is the share of block I/O the container gets relative to
+	// the other cgroups, 0 leaving it unchanged
+	Weight    uint64
+	ReadBPS   map[string]uint64
+	WriteBPS  map[string]uint64
+	ReadIOPS  map[string]uint64
+	WriteIOPS map[string]uint64
+}
+
+// Empty reports whether no limit is set.
+func (l *BlkioLimits) Empty() bool {
+	return l.Weight == 0 && len(l.ReadBPS) == 0 && len(l.WriteBPS) == 0 && len(l.ReadIOPS) == 0 && len(l.WriteIOPS) == 0
+}
+
+// JoinBlkio moves the process pid into the blkio cgroup of the container
+// with the given UUID, creating it with the limits l.
+func JoinBlkio(uuid string, pid int, l *BlkioLimits) error {
+	cg, err := containerPath("blkio", uuid, ErrNoController)
+	if err != nil {
+		return fmt.Errorf("error finding blkio cgroup: %v", err)
+	}
+	if err := os.MkdirAll(cg, 0755); err != nil {
+		return fmt.Errorf("error creating blkio cgroup: %v", err)
+	}
+	if l.Weight > 0 {
+		if err := writeCgroupFile(cg, "blkio.weight", strconv.FormatUint(l.Weight, 10)); err != nil {
+			return err
+		}
+	}
+	for file, limits := range map[string]map[string]uint64{
+		"blkio.throttle.read_bps_device":   l.ReadBPS,
+		"blkio.throttle.write_bps_device":  l.WriteBPS,
+		"blkio.throttle.read_iops_device":  l.ReadIOPS,
+		"blkio.throttle.write_iops_device": l.WriteIOPS,
+	} {
+		// the kernel takes one device per write
+		for _, dev := range sortedKeys(limits) {
+			if err := writeCgroupFile(cg, file, fmt.Sprintf("%s %d", dev, limits[dev])); err != nil {
+				return err
+			}
+		}
+	}
+	return writeCgroupFile(cg, "cgroup.procs", strconv.Itoa(pid))
+}
+
+// ContainerResources collects the block I/O limits and the devices set by
+// the block I/O and device isolators, resolving the paths of the devices
+// on the host. Where several isolators limit the same device, the highest
+// limit wins, so that no app is limited more than it asked for.
+func ContainerResources(isolators types.Isolators) (*BlkioLimits, []Device, error) {
+	l := &BlkioLimits{}
+	var devices []Device
+	for _, i := range isolators {
+		name := string(i.Name)
+		switch name {
+		case BlockIOWeightIsolator:
+			w, err := ParseBlockIOWeight(i.Val)
+			if err != nil {
+				return nil, nil, err
+			}
+			if w > l.Weight {
+				l.Weight = w
+			}
+		case BlockIOReadBandwidthIsolator, BlockIOWriteBandwidthIsolator, BlockIOReadIOPSIsolator, BlockIOWriteIOPSIsolator:
+			path, limit, err := ParseBlockIOLimit(name, i.Val)
+			if err != nil {
+				return nil, nil, err
+			}
+			d, err := DeviceOf(path, "")
+			if err != nil {
+				return nil, nil, fmt.Errorf("error finding device of %s: %v", name, err)
+			}
+			if d.Type != 'b' {
+				return nil, nil, fmt.Errorf("%s is not a block device", path)
+			}
+			m := map[string]*map[string]uint64{
+				BlockIOReadBandwidthIsolator:  &l.ReadBPS,
+				BlockIOWriteBandwidthIsolator: &l.WriteBPS,
+				BlockIOReadIOPSIsolator:       &l.ReadIOPS,
+				BlockIOWriteIOPSIsolator:      &l.WriteIOPS,
+			}[name]
+			if *m == nil {
+				*m = make(map[string]uint64)
+			}
+			if limit > (*m)[d.Number()] {
+				(*m)[d.Number()] = limit
+			}
+		case DeviceIsolator:
+			path, access, err := ParseDevice(i.Val)
+			if err != nil {
+				return nil, nil, err
+			}
+			d, err := DeviceOf(path, access)
+			if err != nil {
+				return nil, nil, fmt.Errorf("error finding device: %v", err)
+			}
+			devices = append(devices, d)
+		}
+	}
+	return l, devices, nil
+}
+
+// Device is a device node the apps of a container may access.
+type Device struct {
+	// Path is the path of the device on the host
+	Path string
+	// Type is 'b' for block devices, 'c' for character devices
+	Type         byte
+	Major, Minor int64
+	// Access is a combination of r (read), w (write) and m (mknod)
+	Access string
+}
+
+func (d Device) rule() string {
+	minor := "*"
+	if d.Minor >= 0 {
+		minor = strconv.FormatInt(d.Minor, 10)
+	}
+	return fmt.Sprintf("%c %d:%s %s", d.Type, d.Major, minor, d.Access)
+}
+
+// DeviceOf returns the device node at path with the given access.
+func DeviceOf(path, access string) (Device, error) {
+	var st syscall.Stat_t
+	if err := syscall.Stat(path, &st); err != nil {
+		return Device{}, err
+	}
+	d := Device{Path: path, Access: access}
+	switch st.Mode & syscall.S_IFMT {
+	case syscall.S_IFBLK:
+		d.Type = 'b'
+	case syscall.S_IFCHR:
+		d.Type = 'c'
+	default:
+		return Device{}, fmt.Errorf("%s is not a device node", path)
+	}
+	// the encoding of dev_t by glibc
+	d.Major = int64((st.Rdev>>8)&0xfff | (st.Rdev>>32)&^0xfff)
+	d.Minor = int64(st.Rdev&0xff | (st.Rdev>>12)&^0xff)
+	return d, nil
+}
+
+// Number returns the MAJOR:MINOR number of d.
+func (d Device) Number() string {
+	return fmt.Sprintf("%d:%d", d.Major, d.Minor)
+}
+
+// defaultDevices are the devices systemd-nspawn makes available in
+// containers, which systemd and the apps expect
+var defaultDevices = []Device{
+	{"/dev/null", 'c', 1, 3, "rwm"},
+	{"/dev/zero", 'c', 1, 5, "rwm"},
+	{"/dev/full", 'c', 1, 7, "rwm"},
+	{"/dev/random", 'c', 1, 8, "rwm"},
+	{"/dev/urandom", 'c', 1, 9, "rwm"},
+	{"/dev/tty", 'c', 5, 0, "rwm"},
+	{"/dev/console", 'c', 5, 1, "rwm"},
+	{"/dev/ptmx", 'c', 5, 2, "rwm"},
+	{"/dev/pts/*", 'c', 136, -1, "rwm"},
+}
+
+// JoinDevices moves the process pid into the devices cgroup of the
+// container with the given UUID, creating it so that only the devices of
+// systemd-nspawn containers and allowed may be accessed.
+func JoinDevices(uuid string, pid int, allowed []Device) error {
+	cg, err := containerPath("devices", uuid, ErrNoController)
+	if err != nil {
+		return fmt.Errorf("error finding devices cgroup: %v", err)
+	}
+	if err := os.MkdirAll(cg, 0755); err != nil {
+		return fmt.Errorf("error creating devices cgroup: %v", err)
+	}
+	if err := writeCgroupFile(cg, "devices.deny", "a"); err != nil {
+		return err
+	}
+	for _, d := range append(append([]Device(nil), defaultDevices...), allowed...) {
+		if err := writeCgroupFile(cg, "devices.allow", d.rule()); err != nil {
+			return err
+		}
+	}
+	return writeCgroupFile(cg, "cgroup.procs", strconv.Itoa(pid))
+}
+
+// RemoveResources removes the blkio, devices and cpuset cgroups of the
+// container with the given UUID, if any, once all its processes exited.
+func RemoveResources(uuid string) error {
+	for _, controller := range []string{"blkio", "devices", "cpuset"} {
+		cg, err := containerPath(controller, uuid, ErrNoController)
+		if err == ErrNoController {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err := os.Remove(cg); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("error removing %s cgroup: %v", controller, err)
+		}
+	}
+	return nil
+}
+
+func writeCgroupFile(cg, file, val string) error {
+	if err := ioutil.WriteFile(filepath.Join(cg, file), []byte(val), 0644); err != nil {
+		return fmt.Errorf("error writing %q to %s: %v", val, file, err)
+	}
+	return nil
+}
+
+func sortedKeys(m map[string]uint64) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,251 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package coredump routes the core dumps of the apps of containers. The
+// kernel hands core dumps to the program kernel.core_pattern pipes them
+// to, "rkt coredump" for containers to capture them: it finds the
+// container and app of the crashed process from its cgroups, and either
+// keeps the core in a size-capped directory of the container or forwards
+// it to the host's handler, e.g. systemd-coredump, recording it in the
+// container either way.
+package coredump
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/coreos/rocket/pkg/quota"
+)
+
+const (
+	// ModePod keeps core dumps in the directory of the container
+	ModePod = "pod"
+	// ModeHost forwards core dumps to the handler of the host
+	ModeHost = "host"
+
+	// DefaultMaxSize caps the size of the core dumps kept for a container
+	DefaultMaxSize = 1 << 30
+
+	// ConfigFile is the file, relative to the directory of a container,
+	// holding how its core dumps are routed
+	ConfigFile = "coredump.json"
+	// Dir is the directory, relative to the directory of a container,
+	// holding its core dumps
+	Dir = "coredumps"
+	// RecordsFile is the file, relative to the directory of a container,
+	// listing its core dumps
+	RecordsFile = "coredumps.json"
+)
+
+// Config is how the core dumps of a container are routed.
+type Config struct {
+	Mode string `json:"mode"`
+	// MaxSize caps the total size of the core dumps kept in the
+	// container's directory
+	MaxSize uint64 `json:"maxSize,omitempty"`
+}
+
+// Parse parses a routing given as "pod", "pod:SIZE" or "host".
+func Parse(s string) (*Config, error) {
+	mode, size := s, ""
+	if i := strings.Index(s, ":"); i >= 0 {
+		mode, size = s[:i], s[i+1:]
+	}
+	switch {
+	case mode == ModeHost && size == "":
+		return &Config{Mode: ModeHost}, nil
+	case mode == ModePod && size == "":
+		return &Config{Mode: ModePod, MaxSize: DefaultMaxSize}, nil
+	case mode == ModePod:
+		max, err := quota.ParseSize(size)
+		if err != nil || max == 0 {
+			return nil, fmt.Errorf("invalid core dump size cap %q", size)
+		}
+		return &Config{Mode: ModePod, MaxSize: max}, nil
+	}
+	return nil, fmt.Errorf("invalid core dump routing %q, want pod, pod:SIZE or host", s)
+}
+
+// Write writes c in the directory of the container cdir.
+func (c *Config) Write(cdir string) error {
+	b, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(cdir, ConfigFile), b, 0644)
+}
+
+// Load returns how the core dumps of the container in cdir are routed,
+// nil if they aren't captured.
+func Load(cdir string) (*Config, error) {
+	b, err := ioutil.ReadFile(filepath.Join(cdir, ConfigFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var c Config
+	if err := json.Unmarshal(b, &c); err != nil {
+		return nil, fmt.Errorf("error parsing %s: %v", ConfigFile, err)
+	}
+	return &c, nil
+}
+
+// Record describes a core dump of an app.
+type Record struct {
+	Time   time.Time `json:"time"`
+	App    string    `json:"app"`
+	PID    int       `json:"pid"`
+	Signal int       `json:"signal"`
+	Comm   string    `json:"comm"`
+	// File is the core dump, relative to the directory of the container,
+	// empty if it was forwarded to the host
+	File string `json:"file,omitempty"`
+	// Truncated is set when the core dump was cut to the size cap
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// Records returns the core dumps recorded in the container in cdir.
+func Records(cdir string) ([]Record, error) {
+	b, err := ioutil.ReadFile(filepath.Join(cdir, RecordsFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var records []Record
+	if err := json.Unmarshal(b, &records); err != nil {
+		return nil, fmt.Errorf("error parsing %s: %v", RecordsFile, err)
+	}
+	return records, nil
+}
+
+// AddRecord records a core dump in the container in cdir.
+func AddRecord(cdir string, r Record) error {
+	records, err := Records(cdir)
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(append(records, r))
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(cdir, RecordsFile), b, 0644)
+}
+
+// Keep writes the core dump read from r in the directory of the container
+// cdir, cut so that the core dumps there don't exceed c.MaxSize. It
+// returns the path of the core dump relative to cdir and whether it was
+// cut.
+func (c *Config) Keep(cdir string, r io.Reader, rec Record) (string, bool, error) {
+	dir := filepath.Join(cdir, Dir)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", false, err
+	}
+	used, err := dirSize(dir)
+	if err != nil {
+		return "", false, err
+	}
+	var left int64
+	if used < c.MaxSize {
+		left = int64(c.MaxSize - used)
+	}
+	name := fmt.Sprintf("core.%s.%d.%d", rec.App, rec.PID, rec.Time.Unix())
+	f, err := os.OpenFile(filepath.Join(dir, name), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return "", false, err
+	}
+	n, err := io.Copy(f, io.LimitReader(r, left))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return "", false, err
+	}
+	// the kernel waits for the whole core dump to be read
+	extra, err := io.Copy(ioutil.Discard, r)
+	if err != nil {
+		return "", false, err
+	}
+	if n == 0 {
+		os.Remove(filepath.Join(dir, name))
+		return "", extra > 0, nil
+	}
+	return filepath.Join(Dir, name), extra > 0, nil
+}
+
+func dirSize(dir string) (uint64, error) {
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return 0, err
+	}
+	var size uint64
+	for _, fi := range fis {
+		size += uint64(fi.Size())
+	}
+	return size, nil
+}
+
+// FindContainer returns the UUID of the container the process pid runs
+// in and the systemd unit of its app, from its cgroups. The UUID is empty
+// if it doesn't run in a container.
+func FindContainer(pid int) (string, string, error) {
+	f, err := os.Open(fmt.Sprintf("/proc/%d/cgroup", pid))
+	if err != nil {
+		return "", "", err
+	}
+	defer f.Close()
+	return parseCgroups(f)
+}
+
+// parseCgroups finds the container and app unit of a process from its
+// /proc/PID/cgroup. stage1 puts the containers in rkt/UUID cgroups of
+// several controllers, and systemd in the container runs each app in a
+// service of its own.
+func parseCgroups(r io.Reader) (string, string, error) {
+	var uuid, unit string
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		// hierarchy-ID:controllers:path
+		parts := strings.SplitN(s.Text(), ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		elems := strings.Split(parts[2], "/")
+		for i := 0; i+1 < len(elems); i++ {
+			if elems[i] == "rkt" && uuid == "" {
+				uuid = elems[i+1]
+			}
+		}
+		if parts[1] == "name=systemd" && strings.HasSuffix(elems[len(elems)-1], ".service") {
+			unit = elems[len(elems)-1]
+		}
+	}
+	if err := s.Err(); err != nil {
+		return "", "", err
+	}
+	if uuid == "" {
+		unit = ""
+	}
+	return uuid, unit, nil
+}
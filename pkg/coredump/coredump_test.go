@@ -0,0 +1,96 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package coredump
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	for s, want := range map[string]Config{
+		"host":     {Mode: ModeHost},
+		"pod":      {Mode: ModePod, MaxSize: DefaultMaxSize},
+		"pod:100M": {Mode: ModePod, MaxSize: 100 << 20},
+	} {
+		c, err := Parse(s)
+		if err != nil || *c != want {
+			t.Errorf("%q: got %+v, %v, want %+v", s, c, err, want)
+		}
+	}
+	for _, s := range []string{"", "pod:0", "host:1G", "file"} {
+		if _, err := Parse(s); err == nil {
+			t.Errorf("%q: expected an error", s)
+		}
+	}
+}
+
+func TestParseCgroups(t *testing.T) {
+	cgroups := `10:blkio:/
+4:freezer:/rkt/4e2bc1a1-0a8e-4b86-9f27-1e0a24c9ed65
+1:name=systemd:/system.slice/rkt-web.service/system.slice/sha512-91e98d7f1679a.service
+`
+	uuid, unit, err := parseCgroups(strings.NewReader(cgroups))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if uuid != "4e2bc1a1-0a8e-4b86-9f27-1e0a24c9ed65" || unit != "sha512-91e98d7f1679a.service" {
+		t.Errorf("got %q, %q", uuid, unit)
+	}
+
+	uuid, unit, err = parseCgroups(strings.NewReader("1:name=systemd:/system.slice/sshd.service\n"))
+	if err != nil || uuid != "" || unit != "" {
+		t.Errorf("got %q, %q, %v, want no container", uuid, unit, err)
+	}
+}
+
+func TestKeep(t *testing.T) {
+	dir, err := ioutil.TempDir("", "coredump-test")
+	if err != nil {
+		t.Fatalf("error creating temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	c := &Config{Mode: ModePod, MaxSize: 6}
+	rec := Record{Time: time.Unix(1000, 0), App: "web", PID: 42}
+	file, truncated, err := c.Keep(dir, bytes.NewBufferString("core"), rec)
+	if err != nil || truncated {
+		t.Fatalf("got %v, %v, want the whole core dump", truncated, err)
+	}
+	if b, err := ioutil.ReadFile(filepath.Join(dir, file)); err != nil || string(b) != "core" {
+		t.Errorf("got %q, %v, want the core dump", b, err)
+	}
+
+	rec.PID = 43
+	file, truncated, err = c.Keep(dir, bytes.NewBufferString("core"), rec)
+	if err != nil || !truncated {
+		t.Fatalf("got %v, %v, want a cut core dump", truncated, err)
+	}
+	if b, err := ioutil.ReadFile(filepath.Join(dir, file)); err != nil || string(b) != "co" {
+		t.Errorf("got %q, %v, want the core dump cut to the cap", b, err)
+	}
+
+	if err := AddRecord(dir, rec); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if records, err := Records(dir); err != nil || len(records) != 1 || records[0].PID != 43 {
+		t.Errorf("got %v, %v, want the record", records, err)
+	}
+}
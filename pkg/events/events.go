@@ -0,0 +1,265 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//+build linux
+
+// Package events implements the journal of the lifecycle events of the pods
+// of a host: pods being prepared, run, their apps exiting and the pods
+// being garbage collected. The journal is a file of the rkt data directory
+// holding an event per line, as JSON, appended to by every rkt process
+// recording one; once it grows too large, it is rotated, a single older
+// journal being kept.
+package events
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/coreos/rocket/pkg/pod"
+)
+
+// File is the journal in the rkt data directory
+const File = "events.log"
+
+var (
+	// maxSize is the size the journal is rotated at
+	maxSize int64 = 4 << 20
+	// pollInterval is how often Follow checks for new events
+	pollInterval = 500 * time.Millisecond
+)
+
+// Type is the type of an event.
+type Type string
+
+const (
+	// Prepare events are recorded once a pod is prepared
+	Prepare Type = "prepare"
+	// Run events are recorded when a pod is about to be run
+	Run Type = "run"
+	// AppExit events are recorded for every app of a pod once the pod
+	// exited, with its exit code
+	AppExit Type = "app-exit"
+	// Exit events are recorded once a pod exited, after those of its apps
+	Exit Type = "exit"
+	// GC events are recorded once a pod was removed
+	GC Type = "gc"
+)
+
+// Event is a lifecycle event of a pod.
+type Event struct {
+	Time time.Time `json:"time"`
+	Type Type      `json:"type"`
+	Pod  string    `json:"pod"`
+	// App and ExitCode are set for AppExit events
+	App      string `json:"app,omitempty"`
+	ExitCode *int   `json:"exitCode,omitempty"`
+}
+
+func journalPath(dataDir string) string {
+	return filepath.Join(dataDir, File)
+}
+
+func rotatedPath(dataDir string) string {
+	return journalPath(dataDir) + ".1"
+}
+
+// Record appends e to the journal of the rkt data directory dataDir, its
+// time being set to now if it is zero.
+func Record(dataDir string, e Event) error {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	e.Time = e.Time.UTC()
+	b, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("error marshalling event: %v", err)
+	}
+	b = append(b, '\n')
+
+	p := journalPath(dataDir)
+	for {
+		f, err := os.OpenFile(p, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			return fmt.Errorf("error opening event journal: %v", err)
+		}
+		if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+			f.Close()
+			return fmt.Errorf("error locking event journal: %v", err)
+		}
+		// the journal may have been rotated while waiting for the lock
+		fi, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return fmt.Errorf("error reading event journal: %v", err)
+		}
+		if pfi, err := os.Stat(p); err != nil || !os.SameFile(fi, pfi) {
+			f.Close()
+			continue
+		}
+		if fi.Size() > 0 && fi.Size()+int64(len(b)) > maxSize {
+			// appenders of the old journal wait on its lock and find
+			// it rotated
+			if err := os.Rename(p, rotatedPath(dataDir)); err != nil {
+				f.Close()
+				return fmt.Errorf("error rotating event journal: %v", err)
+			}
+			f.Close()
+			continue
+		}
+		_, err = f.Write(b)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return fmt.Errorf("error writing event: %v", err)
+		}
+		return nil
+	}
+}
+
+// RecordExit records the exit of the pod p of the rkt data directory
+// dataDir: an AppExit event for each of its apps which reported its exit
+// code, then an Exit event.
+func RecordExit(dataDir string, p *pod.Pod) error {
+	id := p.UUID.String()
+	s, err := p.Status()
+	if err != nil {
+		return err
+	}
+	for _, as := range s.Apps {
+		if !as.Exited {
+			continue
+		}
+		code := as.ExitCode
+		if err := Record(dataDir, Event{Type: AppExit, Pod: id, App: as.Name.String(), ExitCode: &code}); err != nil {
+			return err
+		}
+	}
+	return Record(dataDir, Event{Type: Exit, Pod: id})
+}
+
+// Read returns the events of the journal of the rkt data directory dataDir
+// recorded since the given time, oldest first.
+func Read(dataDir string, since time.Time) ([]Event, error) {
+	var evs []Event
+	for _, p := range []string{rotatedPath(dataDir), journalPath(dataDir)} {
+		f, err := os.Open(p)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error opening event journal: %v", err)
+		}
+		_, err = readEvents(f, since, func(e Event) error {
+			evs = append(evs, e)
+			return nil
+		})
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return evs, nil
+}
+
+// readEvents calls fn with the events of r recorded since the given time,
+// up to the last complete line, and returns how many bytes were read. A
+// line still being written is left for later.
+func readEvents(r io.Reader, since time.Time, fn func(Event) error) (int64, error) {
+	var n int64
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadBytes('\n')
+		if err == io.EOF {
+			return n, nil
+		}
+		if err != nil {
+			return n, fmt.Errorf("error reading event journal: %v", err)
+		}
+		n += int64(len(line))
+		var e Event
+		if err := json.Unmarshal(bytes.TrimSpace(line), &e); err != nil {
+			// e.g. written by a rkt which crashed midway, the
+			// events after it are still good
+			continue
+		}
+		if e.Time.Before(since) {
+			continue
+		}
+		if err := fn(e); err != nil {
+			return n, err
+		}
+	}
+}
+
+// Follow calls fn with the events of the journal of the rkt data directory
+// dataDir recorded since the given time, oldest first, then with the events
+// recorded next as they are, until ctx is done or fn fails.
+func Follow(ctx context.Context, dataDir string, since time.Time, fn func(Event) error) error {
+	if f, err := os.Open(rotatedPath(dataDir)); err == nil {
+		_, err = readEvents(f, since, fn)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	// f is the journal being followed, read up to off
+	var f *os.File
+	var off int64
+	defer func() {
+		if f != nil {
+			f.Close()
+		}
+	}()
+	for {
+		if f == nil {
+			var err error
+			if f, err = os.Open(journalPath(dataDir)); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("error opening event journal: %v", err)
+			}
+			off = 0
+		}
+		if f != nil {
+			n, err := readEvents(io.NewSectionReader(f, off, 1<<62), since, fn)
+			if err != nil {
+				return err
+			}
+			off += n
+			// once rotated, the rest of the old journal was read above
+			fi, err := f.Stat()
+			if err != nil {
+				return fmt.Errorf("error reading event journal: %v", err)
+			}
+			if pfi, err := os.Stat(journalPath(dataDir)); err == nil && !os.SameFile(fi, pfi) {
+				f.Close()
+				f = nil
+				continue
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(pollInterval):
+		}
+	}
+}
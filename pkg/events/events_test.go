@@ -0,0 +1,144 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//+build linux
+
+package events
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+const testUUID = "6733c3b1-8b9e-4f5c-9c2a-1d2e3f4a5b6c"
+
+func TestJournal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "events")
+	if err != nil {
+		t.Fatalf("error creating tempdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	if evs, err := Read(dir, time.Time{}); err != nil || len(evs) != 0 {
+		t.Fatalf("got %v, %v, want no events without a journal", evs, err)
+	}
+
+	start := time.Now().Add(-time.Hour)
+	code := 3
+	for _, e := range []Event{
+		{Time: start, Type: Prepare, Pod: testUUID},
+		{Type: Run, Pod: testUUID},
+		{Type: AppExit, Pod: testUUID, App: "example.com/app", ExitCode: &code},
+		{Type: Exit, Pod: testUUID},
+	} {
+		if err := Record(dir, e); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	// a line left by a rkt which crashed midway is skipped
+	f, err := os.OpenFile(journalPath(dir), os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	f.WriteString("{\"time\":\n")
+	f.Close()
+	if err := Record(dir, Event{Type: GC, Pod: testUUID}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	evs, err := Read(dir, time.Time{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var types []Type
+	for _, e := range evs {
+		types = append(types, e.Type)
+	}
+	if len(types) != 5 || types[0] != Prepare || types[4] != GC {
+		t.Fatalf("got events %v", types)
+	}
+	if e := evs[2]; e.App != "example.com/app" || e.ExitCode == nil || *e.ExitCode != 3 {
+		t.Errorf("got app exit %+v", e)
+	}
+	if evs, err := Read(dir, start.Add(time.Minute)); err != nil || len(evs) != 4 || evs[0].Type != Run {
+		t.Errorf("got %+v, %v, want the events since the prepare one", evs, err)
+	}
+
+	// once rotated, the older journal is still read
+	maxSize = 1
+	defer func() { maxSize = 4 << 20 }()
+	if err := Record(dir, Event{Type: Prepare, Pod: testUUID}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if evs, err := Read(dir, time.Time{}); err != nil || len(evs) != 6 || evs[5].Type != Prepare {
+		t.Errorf("got %+v, %v, want 6 events", evs, err)
+	}
+	if _, err := os.Stat(rotatedPath(dir)); err != nil {
+		t.Errorf("expected the journal to be rotated: %v", err)
+	}
+}
+
+func TestFollow(t *testing.T) {
+	dir, err := ioutil.TempDir("", "events")
+	if err != nil {
+		t.Fatalf("error creating tempdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	pollInterval = 10 * time.Millisecond
+	defer func() { pollInterval = 500 * time.Millisecond }()
+
+	if err := Record(dir, Event{Type: Prepare, Pod: testUUID}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	evc := make(chan Event)
+	errc := make(chan error)
+	go func() { errc <- Follow(ctx, dir, time.Time{}, func(e Event) error { evc <- e; return nil }) }()
+
+	next := func(want Type) {
+		select {
+		case e := <-evc:
+			if e.Type != want {
+				t.Errorf("got event %s, want %s", e.Type, want)
+			}
+		case <-ctx.Done():
+			t.Fatalf("timed out waiting for event %s", want)
+		}
+	}
+	next(Prepare)
+	if err := Record(dir, Event{Type: Run, Pod: testUUID}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	next(Run)
+	// followed across rotations
+	maxSize = 1
+	defer func() { maxSize = 4 << 20 }()
+	if err := Record(dir, Event{Type: Exit, Pod: testUUID}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	next(Exit)
+	if err := Record(dir, Event{Type: GC, Pod: testUUID}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	next(GC)
+
+	cancel()
+	if err := <-errc; err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
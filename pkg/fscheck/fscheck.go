@@ -0,0 +1,104 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//+build linux
+
+// Package fscheck tells whether a directory is on a filesystem with the
+// features rkt relies on for its data directory: hard links within images
+// and the tree store, symbolic links, ownership and permissions of the
+// files of images, device nodes in the stage1 rootfs, extended attributes
+// for file capabilities and SELinux labels, and flock for the locking of the
+// store and the containers. Filesystems known to lack some are rejected
+// with the list of those, rather than letting rkt fail in obscure ways
+// later; unknown filesystems are assumed to have all of them.
+package fscheck
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// The features of a filesystem rkt relies on
+const (
+	HardLinks   = "hard links"
+	Symlinks    = "symbolic links"
+	Ownership   = "file ownership and permissions"
+	DeviceNodes = "device nodes"
+	Xattrs      = "extended attributes"
+	Locks       = "reliable flock locks"
+	Writes      = "writes"
+)
+
+// unsupported are the filesystems known to lack features, by magic number
+// as statfs reports it (from linux/magic.h)
+var unsupported = map[uint32]struct {
+	name    string
+	missing []string
+}{
+	0x6969:     {"NFS", []string{Locks, Ownership, Xattrs}},
+	0x4d44:     {"FAT", []string{HardLinks, Symlinks, Ownership, DeviceNodes, Xattrs}},
+	0x2011bab0: {"exFAT", []string{HardLinks, Symlinks, Ownership, DeviceNodes, Xattrs}},
+	0xff534d42: {"CIFS", []string{Symlinks, Ownership, DeviceNodes, Locks}},
+	0xfe534d42: {"SMB2", []string{Symlinks, Ownership, DeviceNodes, Locks}},
+	0x517b:     {"SMB", []string{Symlinks, Ownership, DeviceNodes, Locks}},
+	0x9660:     {"ISO 9660", []string{Writes}},
+	0x73717368: {"squashfs", []string{Writes}},
+}
+
+// UnsupportedError is returned for a directory on a filesystem lacking
+// features rkt relies on.
+type UnsupportedError struct {
+	Dir string
+	// FS is the name of the filesystem, and Missing the features it lacks
+	FS      string
+	Missing []string
+}
+
+func (e *UnsupportedError) Error() string {
+	return fmt.Sprintf("%s is on %s, which lacks features rkt relies on: %s", e.Dir, e.FS, strings.Join(e.Missing, ", "))
+}
+
+// Check returns an *UnsupportedError if dir is on a filesystem lacking
+// features rkt relies on. If dir doesn't exist yet, the filesystem it
+// would be created on is checked.
+func Check(dir string) error {
+	var st syscall.Statfs_t
+	p := dir
+	for {
+		err := syscall.Statfs(p, &st)
+		if err == nil {
+			break
+		}
+		if err != syscall.ENOENT || filepath.Dir(p) == p {
+			return fmt.Errorf("error checking the filesystem of %s: %v", dir, err)
+		}
+		p = filepath.Dir(p)
+	}
+	// the type is signed on some architectures
+	return check(dir, uint32(st.Type))
+}
+
+func check(dir string, fsType uint32) error {
+	fs, ok := unsupported[fsType]
+	if !ok {
+		return nil
+	}
+	return &UnsupportedError{
+		Dir:     dir,
+		FS:      fs.name,
+		Missing: fs.missing,
+	}
+}
@@ -0,0 +1,60 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//+build linux
+
+package fscheck
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fscheck")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// the data directory may not have been created yet
+	if err := Check(filepath.Join(dir, "var", "lib", "rkt")); err != nil {
+		if _, ok := err.(*UnsupportedError); !ok {
+			t.Errorf("unexpected error checking missing directory: %v", err)
+		}
+	}
+
+	tests := []struct {
+		fsType uint32
+		err    error
+	}{
+		// ext4
+		{0xef53, nil},
+		{0x4d44, &UnsupportedError{"/var/lib/rkt", "FAT", []string{HardLinks, Symlinks, Ownership, DeviceNodes, Xattrs}}},
+		{0x6969, &UnsupportedError{"/var/lib/rkt", "NFS", []string{Locks, Ownership, Xattrs}}},
+	}
+	for _, tt := range tests {
+		if err := check("/var/lib/rkt", tt.fsType); !reflect.DeepEqual(err, tt.err) {
+			t.Errorf("filesystem %#x: got %v, want %v", tt.fsType, err, tt.err)
+		}
+	}
+
+	want := "/var/lib/rkt is on NFS, which lacks features rkt relies on: reliable flock locks, file ownership and permissions, extended attributes"
+	if msg := check("/var/lib/rkt", 0x6969).Error(); msg != want {
+		t.Errorf("got message %q, want %q", msg, want)
+	}
+}
@@ -0,0 +1,95 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//+build linux
+
+// Package harden handles the hardening of apps: keeping them from gaining
+// privileges, e.g. through setuid binaries, and masking the sensitive
+// files of the kernel they might otherwise see.
+package harden
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+)
+
+const (
+	// Annotation hardens an app when "true", it can be set on the app or
+	// on the whole container
+	Annotation = "os/linux/hardened"
+
+	// from linux/prctl.h
+	prSetNoNewPrivs = 38
+)
+
+// MaskedPaths are the files and directories of /proc and /sys hidden from
+// hardened apps.
+var MaskedPaths = []string{
+	"/proc/acpi",
+	"/proc/kcore",
+	"/proc/keys",
+	"/proc/latency_stats",
+	"/proc/sched_debug",
+	"/proc/scsi",
+	"/proc/sysrq-trigger",
+	"/proc/timer_list",
+	"/proc/timer_stats",
+	"/sys/firmware",
+}
+
+// Parse parses the value of Annotation.
+func Parse(val string) (bool, error) {
+	h, err := strconv.ParseBool(val)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s %q, want true or false", Annotation, val)
+	}
+	return h, nil
+}
+
+// SetNoNewPrivs keeps the calling thread, and the processes it forks, from
+// gaining privileges through execve. The thread must stay locked to the
+// goroutine.
+func SetNoNewPrivs() error {
+	if _, _, errno := syscall.RawSyscall6(syscall.SYS_PRCTL, prSetNoNewPrivs, 1, 0, 0, 0, 0); errno != 0 {
+		return fmt.Errorf("error setting no_new_privs: %v", errno)
+	}
+	return nil
+}
+
+// Mask hides the MaskedPaths existing in root: files are covered by
+// /dev/null and directories by an empty read-only tmpfs.
+func Mask(root string) error {
+	for _, p := range MaskedPaths {
+		dst := filepath.Join(root, p)
+		fi, err := os.Stat(dst)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("error masking %s: %v", p, err)
+		}
+		if fi.IsDir() {
+			err = syscall.Mount("tmpfs", dst, "tmpfs", syscall.MS_RDONLY|syscall.MS_NOSUID|syscall.MS_NODEV|syscall.MS_NOEXEC, "")
+		} else {
+			err = syscall.Mount("/dev/null", dst, "", syscall.MS_BIND, "")
+		}
+		if err != nil {
+			return fmt.Errorf("error masking %s: %v", p, err)
+		}
+	}
+	return nil
+}
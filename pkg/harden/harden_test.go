@@ -0,0 +1,30 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//+build linux
+
+package harden
+
+import "testing"
+
+func TestParse(t *testing.T) {
+	for val, want := range map[string]bool{"true": true, "false": false, "1": true} {
+		if h, err := Parse(val); err != nil || h != want {
+			t.Errorf("%q: got %v, %v, want %v", val, h, err, want)
+		}
+	}
+	if _, err := Parse("strict"); err == nil {
+		t.Errorf("expected an error for an invalid value")
+	}
+}
@@ -0,0 +1,68 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package io
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+// ContextReader reads from R until Ctx is done, from then on reads fail
+// with the error of Ctx. It lets long copies, e.g. of images, be aborted
+// between reads.
+type ContextReader struct {
+	Ctx context.Context
+	R   io.Reader
+}
+
+func (c *ContextReader) Read(p []byte) (int, error) {
+	if err := c.Ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.R.Read(p)
+}
+
+// ThrottledReader reads from R at no more than Rate bytes per second on
+// average, sleeping between reads, e.g. so that a background scan of the
+// store doesn't starve the apps of disk I/O. A Rate of 0 doesn't throttle.
+type ThrottledReader struct {
+	R    io.Reader
+	Rate int64
+
+	start time.Time
+	n     int64
+}
+
+func (t *ThrottledReader) Read(p []byte) (int, error) {
+	if t.Rate <= 0 {
+		return t.R.Read(p)
+	}
+	if t.start.IsZero() {
+		t.start = time.Now()
+	}
+	// read no more than a tenth of a second's worth at once, to keep the
+	// rate even
+	if max := t.Rate/10 + 1; int64(len(p)) > max {
+		p = p[:max]
+	}
+	n, err := t.R.Read(p)
+	t.n += int64(n)
+	due := t.start.Add(time.Duration(t.n * int64(time.Second) / t.Rate))
+	if d := due.Sub(time.Now()); d > 0 {
+		time.Sleep(d)
+	}
+	return n, err
+}
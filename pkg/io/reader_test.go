@@ -0,0 +1,48 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package io
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func TestContextReader(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &ContextReader{Ctx: ctx, R: bytes.NewBufferString("data")}
+	b := make([]byte, 2)
+	if n, err := r.Read(b); n != 2 || err != nil {
+		t.Fatalf("got %d, %v, want 2, nil", n, err)
+	}
+	cancel()
+	if _, err := ioutil.ReadAll(r); err != context.Canceled {
+		t.Errorf("got %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestThrottledReader(t *testing.T) {
+	data := make([]byte, 3000)
+	start := time.Now()
+	b, err := ioutil.ReadAll(&ThrottledReader{R: bytes.NewReader(data), Rate: 10000})
+	if err != nil || len(b) != len(data) {
+		t.Fatalf("got %d bytes, %v, want %d", len(b), err, len(data))
+	}
+	if d := time.Since(start); d < 250*time.Millisecond {
+		t.Errorf("read %d bytes at 10000 bytes/s in %v", len(data), d)
+	}
+}
@@ -17,6 +17,8 @@ package keystore
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
@@ -24,12 +26,16 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"sort"
 	"strings"
 
 	"github.com/appc/spec/schema/types"
 	"github.com/coreos/rocket/Godeps/_workspace/src/golang.org/x/crypto/openpgp"
 )
 
+// ErrKeyNotFound is returned by Delete if the key is not trusted at all.
+var ErrKeyNotFound = errors.New("keystore: key not found")
+
 // A Config structure is used to configure a Keystore.
 type Config struct {
 	RootPath         string
@@ -66,9 +72,20 @@ func CheckSignature(prefix string, signed, signature io.Reader) (*openpgp.Entity
 	return checkSignature(ks, prefix, signed, signature)
 }
 
+// UntrustedPrefixError is returned when not a single key, neither a root key
+// nor a key for one of its prefixes, is trusted for an image name.
+type UntrustedPrefixError struct {
+	Name string
+}
+
+func (e *UntrustedPrefixError) Error() string {
+	return fmt.Sprintf("keystore: no keys trusted for %q", e.Name)
+}
+
 // CheckSignature takes a signed file and a detached signature and returns the signer
 // if the signature is signed by a trusted signer.
-// If the signer is unknown or not trusted, opengpg.ErrUnknownIssuer is returned.
+// If no key is trusted for prefix an *UntrustedPrefixError is returned, if the
+// signer is unknown or not trusted, opengpg.ErrUnknownIssuer is returned.
 func (ks *Keystore) CheckSignature(prefix string, signed, signature io.Reader) (*openpgp.Entity, error) {
 	return checkSignature(ks, prefix, signed, signature)
 }
@@ -82,9 +99,60 @@ func checkSignature(ks *Keystore, prefix string, signed, signature io.Reader) (*
 	if err != nil {
 		return nil, fmt.Errorf("keystore: error loading keyring %v", err)
 	}
+	if len(keyring) == 0 {
+		return nil, &UntrustedPrefixError{acname.String()}
+	}
 	return openpgp.CheckArmoredDetachedSignature(keyring, signed, signature)
 }
 
+// TrustsPrefix reports whether any key is trusted to sign images named
+// name, taking root keys, the keys of every prefix of name and masked
+// system keys into account. It allows callers to fail early, before
+// fetching anything, for images which could never be verified.
+func (ks *Keystore) TrustsPrefix(name string) (bool, error) {
+	keyring, err := ks.loadKeyring(name)
+	if err != nil {
+		return false, err
+	}
+	return len(keyring) > 0, nil
+}
+
+// TrustsKey reports whether the key with the given fingerprint, in hex, is
+// trusted to sign images named name, as TrustsPrefix takes keys into
+// account. It allows the signer recorded for an image to be checked again
+// without its signature.
+func (ks *Keystore) TrustsKey(name, fingerprint string) (bool, error) {
+	keyring, err := ks.loadKeyring(name)
+	if err != nil {
+		return false, err
+	}
+	for _, e := range keyring {
+		if strings.EqualFold(fmt.Sprintf("%x", e.PrimaryKey.Fingerprint), fingerprint) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Digest returns a digest of the files of the keystore, their paths, sizes
+// and modification times, which changes whenever a key is trusted, deleted
+// or masked, so that what was checked against the keystore can be cached.
+func (ks *Keystore) Digest() (string, error) {
+	files, err := ks.keyFiles()
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	for _, kf := range files {
+		fi, err := os.Lstat(kf.path)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(h, "%q %d %d\n", kf.path, fi.Size(), fi.ModTime().UnixNano())
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
 // DeleteTrustedKeyPrefix deletes the prefix trusted key identified by fingerprint.
 func (ks *Keystore) DeleteTrustedKeyPrefix(prefix, fingerprint string) error {
 	acname, err := types.NewACName(prefix)
@@ -101,7 +169,7 @@ func (ks *Keystore) MaskTrustedKeySystemPrefix(prefix, fingerprint string) (stri
 		return "", err
 	}
 	dst := path.Join(ks.PrefixPath, acname.String(), fingerprint)
-	return dst, ioutil.WriteFile(dst, []byte(""), 0644)
+	return dst, maskKey(dst)
 }
 
 // DeleteTrustedKeyRoot deletes the root trusted key identified by fingerprint.
@@ -112,7 +180,14 @@ func (ks *Keystore) DeleteTrustedKeyRoot(fingerprint string) error {
 // MaskTrustedKeySystemRoot masks the system root trusted key identified by fingerprint.
 func (ks *Keystore) MaskTrustedKeySystemRoot(fingerprint string) (string, error) {
 	dst := path.Join(ks.RootPath, fingerprint)
-	return dst, ioutil.WriteFile(dst, []byte(""), 0644)
+	return dst, maskKey(dst)
+}
+
+func maskKey(dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0700); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(dst, []byte(""), 0644)
 }
 
 // StoreTrustedKeyPrefix stores the contents of public key r as a prefix trusted key.
@@ -169,7 +244,7 @@ func entityFromFile(path string) (*openpgp.Entity, error) {
 	return entityList[0], nil
 }
 
-func (ks *Keystore) loadKeyring(prefix string) (openpgp.KeyRing, error) {
+func (ks *Keystore) loadKeyring(prefix string) (openpgp.EntityList, error) {
 	acname, err := types.NewACName(prefix)
 	if err != nil {
 		return nil, err
@@ -188,6 +263,9 @@ func (ks *Keystore) loadKeyring(prefix string) (openpgp.KeyRing, error) {
 		{path.Join(ks.PrefixPath, prefixRoot), path.Join(ks.PrefixPath, acname.String())},
 	}
 	for _, p := range paths {
+		// a key trusted for example.com/app must not be trusted for
+		// example.com/application, so only whole path components match
+		fullPath := p.fullPath + "/"
 		err := filepath.Walk(p.root, func(path string, info os.FileInfo, err error) error {
 			if err != nil && !os.IsNotExist(err) {
 				return err
@@ -197,7 +275,7 @@ func (ks *Keystore) loadKeyring(prefix string) (openpgp.KeyRing, error) {
 			}
 			if info.IsDir() {
 				switch {
-				case strings.HasPrefix(p.fullPath, path):
+				case strings.HasPrefix(fullPath, path+"/"):
 					return nil
 				default:
 					return filepath.SkipDir
@@ -226,6 +304,177 @@ func (ks *Keystore) loadKeyring(prefix string) (openpgp.KeyRing, error) {
 	return keyring, nil
 }
 
+// A TrustedKey is a key trusted for a prefix, or for all images.
+type TrustedKey struct {
+	Fingerprint string
+	// Prefix is the image name prefix the key is trusted for, it is empty
+	// for root keys.
+	Prefix string
+	// Path is the file the key is stored in.
+	Path string
+	// System is set for keys shipped in the system directories.
+	System bool
+	Entity *openpgp.Entity
+}
+
+// keyFile is a file found in one of the keystore directories, masks are
+// empty files disabling a system key.
+type keyFile struct {
+	fingerprint string
+	prefix      string
+	path        string
+	system      bool
+	mask        bool
+}
+
+func (ks *Keystore) keyFiles() ([]keyFile, error) {
+	var files []keyFile
+	dirs := []struct {
+		dir    string
+		prefix bool
+		system bool
+	}{
+		{ks.SystemRootPath, false, true},
+		{ks.RootPath, false, false},
+		{ks.SystemPrefixPath, true, true},
+		{ks.PrefixPath, true, false},
+	}
+	for _, d := range dirs {
+		err := filepath.Walk(d.dir, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				if os.IsNotExist(err) {
+					return nil
+				}
+				return err
+			}
+			if info.IsDir() {
+				// root keys live directly in the root directory
+				if !d.prefix && path != d.dir {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			kf := keyFile{
+				fingerprint: info.Name(),
+				path:        path,
+				system:      d.system,
+				mask:        info.Size() == 0,
+			}
+			if d.prefix {
+				prefix, err := filepath.Rel(d.dir, filepath.Dir(path))
+				if err != nil {
+					return err
+				}
+				kf.prefix = prefix
+			}
+			files = append(files, kf)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// List returns the keys trusted by the keystore, sorted by fingerprint and
+// prefix. A key trusted for several prefixes is listed once per prefix.
+// System keys which have been masked are left out.
+func (ks *Keystore) List() ([]TrustedKey, error) {
+	files, err := ks.keyFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	// user keys and masks take precedence over system keys for the same
+	// prefix
+	type location struct{ prefix, fingerprint string }
+	overridden := make(map[location]bool)
+	for _, f := range files {
+		if !f.system {
+			overridden[location{f.prefix, f.fingerprint}] = true
+		}
+	}
+
+	var keys []TrustedKey
+	for _, f := range files {
+		if f.mask || (f.system && overridden[location{f.prefix, f.fingerprint}]) {
+			continue
+		}
+		entity, err := entityFromFile(f.path)
+		if err != nil {
+			return nil, fmt.Errorf("keystore: error reading %s: %v", f.path, err)
+		}
+		keys = append(keys, TrustedKey{
+			Fingerprint: f.fingerprint,
+			Prefix:      f.prefix,
+			Path:        f.path,
+			System:      f.system,
+			Entity:      entity,
+		})
+	}
+	sort.Sort(byFingerprint(keys))
+	return keys, nil
+}
+
+type byFingerprint []TrustedKey
+
+func (k byFingerprint) Len() int      { return len(k) }
+func (k byFingerprint) Swap(i, j int) { k[i], k[j] = k[j], k[i] }
+func (k byFingerprint) Less(i, j int) bool {
+	if k[i].Fingerprint != k[j].Fingerprint {
+		return k[i].Fingerprint < k[j].Fingerprint
+	}
+	return k[i].Prefix < k[j].Prefix
+}
+
+// Delete revokes all trust in the key identified by fingerprint, as a root
+// key and for every prefix. Copies of the key in the user directories are
+// removed and copies in the system directories are masked.
+// ErrKeyNotFound is returned if the key is not trusted at all.
+func (ks *Keystore) Delete(fingerprint string) error {
+	keys, err := ks.List()
+	if err != nil {
+		return err
+	}
+	found := false
+	for _, k := range keys {
+		if k.Fingerprint == fingerprint {
+			found = true
+		}
+	}
+	if !found {
+		return ErrKeyNotFound
+	}
+
+	files, err := ks.keyFiles()
+	if err != nil {
+		return err
+	}
+	// remove first: masking a system key may reuse the path of a user copy
+	for _, f := range files {
+		if f.fingerprint == fingerprint && !f.system && !f.mask {
+			if err := os.Remove(f.path); err != nil {
+				return err
+			}
+		}
+	}
+	for _, f := range files {
+		if f.fingerprint != fingerprint || !f.system || f.mask {
+			continue
+		}
+		if f.prefix == "" {
+			_, err = ks.MaskTrustedKeySystemRoot(fingerprint)
+		} else {
+			_, err = ks.MaskTrustedKeySystemPrefix(f.prefix, fingerprint)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func fingerprintToFilename(fp [20]byte) string {
 	return fmt.Sprintf("%x", fp)
 }
@@ -31,6 +31,9 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
 	"testing"
 
 	"github.com/coreos/rocket/pkg/keystore/keystoretest"
@@ -155,6 +158,7 @@ func TestCheckSignature(t *testing.T) {
 		{"acme.com/etcd", "acme.com", false},
 		{"acme.com/web/nginx", "acme.com", false},
 		{"acme.com/services/web", "acme.com/services/web/nginx", false},
+		{"example.com/application", "example.com/app", false},
 	}
 	for _, tt := range checkSignatureTests {
 		key := keystoretest.KeyMap[tt.key]
@@ -183,3 +187,203 @@ func TestCheckSignature(t *testing.T) {
 		}
 	}
 }
+
+func TestTrustsPrefix(t *testing.T) {
+	ks, ksPath, err := NewTestKeystore()
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	defer os.RemoveAll(ksPath)
+
+	key := keystoretest.KeyMap["example.com/app"]
+	if _, err := ks.StoreTrustedKeyPrefix("example.com/app", bytes.NewBufferString(key.ArmoredPublicKey)); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		trusted bool
+	}{
+		{"example.com/app", true},
+		{"example.com/app/worker", true},
+		{"example.com/application", false},
+		{"example.com", false},
+		{"coreos.com/etcd", false},
+	}
+	for _, tt := range tests {
+		trusted, err := ks.TrustsPrefix(tt.name)
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", tt.name, err)
+			continue
+		}
+		if trusted != tt.trusted {
+			t.Errorf("%s: got trusted %v, want %v", tt.name, trusted, tt.trusted)
+		}
+	}
+
+	// without any applicable key verification fails closed with a
+	// descriptive error rather than attempting to check the signature
+	message, signature, err := keystoretest.NewMessageAndSignature(key.ArmoredPrivateKey)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	_, err = ks.CheckSignature("coreos.com/etcd", message, signature)
+	if _, ok := err.(*UntrustedPrefixError); !ok {
+		t.Errorf("expected *UntrustedPrefixError, got %v", err)
+	}
+
+	// trusting a root key covers every name
+	root := keystoretest.KeyMap["coreos.com"]
+	if _, err := ks.StoreTrustedKeyRoot(bytes.NewBufferString(root.ArmoredPublicKey)); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if trusted, err := ks.TrustsPrefix("example.com/application"); err != nil || !trusted {
+		t.Errorf("expected root key to be trusted, got %v, %v", trusted, err)
+	}
+}
+
+func TestTrustsKeyAndDigest(t *testing.T) {
+	ks, ksPath, err := NewTestKeystore()
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	defer os.RemoveAll(ksPath)
+
+	empty, err := ks.Digest()
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	key := keystoretest.KeyMap["example.com/app"]
+	if _, err := ks.StoreTrustedKeyPrefix("example.com/app", bytes.NewBufferString(key.ArmoredPublicKey)); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	trusted, err := ks.Digest()
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if trusted == empty {
+		t.Errorf("digest didn't change when a key was trusted")
+	}
+	if d, err := ks.Digest(); err != nil || d != trusted {
+		t.Errorf("got digest %q, %v, want %q", d, err, trusted)
+	}
+
+	other := keystoretest.KeyMap["coreos.com"].Fingerprint
+	tests := []struct {
+		name        string
+		fingerprint string
+		trusted     bool
+	}{
+		{"example.com/app", key.Fingerprint, true},
+		{"example.com/app/worker", strings.ToUpper(key.Fingerprint), true},
+		{"example.com/application", key.Fingerprint, false},
+		{"example.com/app", other, false},
+	}
+	for _, tt := range tests {
+		got, err := ks.TrustsKey(tt.name, tt.fingerprint)
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", tt.name, err)
+			continue
+		}
+		if got != tt.trusted {
+			t.Errorf("%s %s: got trusted %v, want %v", tt.name, tt.fingerprint, got, tt.trusted)
+		}
+	}
+
+	if err := ks.Delete(key.Fingerprint); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if d, err := ks.Digest(); err != nil || d != empty {
+		t.Errorf("got digest %q, %v, want that of the empty keystore", d, err)
+	}
+}
+
+func TestListAndDelete(t *testing.T) {
+	ks, ksPath, err := NewTestKeystore()
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	defer os.RemoveAll(ksPath)
+
+	app := keystoretest.KeyMap["example.com/app"]
+	coreos := keystoretest.KeyMap["coreos.com"]
+	acme := keystoretest.KeyMap["acme.com"]
+
+	if _, err := ks.StoreTrustedKeyPrefix("example.com/app", bytes.NewBufferString(app.ArmoredPublicKey)); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if _, err := ks.StoreTrustedKeyPrefix("example.com/other", bytes.NewBufferString(app.ArmoredPublicKey)); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if _, err := ks.StoreTrustedKeyRoot(bytes.NewBufferString(coreos.ArmoredPublicKey)); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	dst := filepath.Join(ks.SystemRootPath, acme.Fingerprint)
+	if err := ioutil.WriteFile(dst, []byte(acme.ArmoredPublicKey), 0644); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	list := func() []listEntry {
+		keys, err := ks.List()
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		var entries []listEntry
+		for _, k := range keys {
+			if fp := fmt.Sprintf("%x", k.Entity.PrimaryKey.Fingerprint); fp != k.Fingerprint {
+				t.Errorf("entity fingerprint %s doesn't match %s", fp, k.Fingerprint)
+			}
+			entries = append(entries, listEntry{k.Fingerprint, k.Prefix, k.System})
+		}
+		return entries
+	}
+	want := []listEntry{
+		{app.Fingerprint, "example.com/app", false},
+		{app.Fingerprint, "example.com/other", false},
+		{coreos.Fingerprint, "", false},
+		{acme.Fingerprint, "", true},
+	}
+	sort.Sort(byListEntry(want))
+	if g := list(); !reflect.DeepEqual(g, want) {
+		t.Fatalf("got %v, want %v", g, want)
+	}
+
+	if err := ks.Delete(app.Fingerprint); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	// system keys are masked rather than removed
+	if err := ks.Delete(acme.Fingerprint); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if _, err := os.Stat(dst); err != nil {
+		t.Errorf("expected system key to be left in place: %v", err)
+	}
+	want = []listEntry{{coreos.Fingerprint, "", false}}
+	if g := list(); !reflect.DeepEqual(g, want) {
+		t.Errorf("got %v, want %v", g, want)
+	}
+	if trusted, err := ks.TrustsPrefix("example.com/app"); err != nil || !trusted {
+		// still trusted through the coreos.com root key
+		t.Errorf("expected root key to remain trusted, got %v, %v", trusted, err)
+	}
+
+	if err := ks.Delete(acme.Fingerprint); err != ErrKeyNotFound {
+		t.Errorf("expected ErrKeyNotFound, got %v", err)
+	}
+}
+
+type listEntry struct {
+	fingerprint, prefix string
+	system              bool
+}
+
+type byListEntry []listEntry
+
+func (e byListEntry) Len() int      { return len(e) }
+func (e byListEntry) Swap(i, j int) { e[i], e[j] = e[j], e[i] }
+func (e byListEntry) Less(i, j int) bool {
+	if e[i].fingerprint != e[j].fingerprint {
+		return e[i].fingerprint < e[j].fingerprint
+	}
+	return e[i].prefix < e[j].prefix
+}
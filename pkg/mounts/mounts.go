@@ -0,0 +1,132 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package mounts adds mount points to apps at runtime, beyond those their
+// images declare, so that volumes can be mounted where the images never
+// expected one.
+package mounts
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/appc/spec/schema/types"
+)
+
+// Annotation of a runtime app lists, as JSON, the mount points added to it,
+// e.g. [{"name": "data", "path": "/srv/data"}], each named after the volume
+// mounted on it
+const Annotation = "coreos.com/rkt/mount-points"
+
+// A Mount mounts a volume on a path of the apps, or only of App if set.
+type Mount struct {
+	Volume   types.ACName
+	Target   string
+	ReadOnly bool
+	App      types.ACName
+}
+
+// Parse parses a mount given as volume=NAME,target=PATH, optionally
+// followed by readOnly=BOOL and app=NAME.
+func Parse(s string) (Mount, error) {
+	var m Mount
+	for _, kv := range strings.Split(s, ",") {
+		p := strings.SplitN(kv, "=", 2)
+		if len(p) != 2 {
+			return m, fmt.Errorf("invalid mount option %q, want KEY=VALUE", kv)
+		}
+		switch p[0] {
+		case "volume", "app":
+			n, err := types.NewACName(p[1])
+			if err != nil {
+				return m, fmt.Errorf("invalid %s name %q: %v", p[0], p[1], err)
+			}
+			if p[0] == "volume" {
+				m.Volume = *n
+			} else {
+				m.App = *n
+			}
+		case "target":
+			if !filepath.IsAbs(p[1]) {
+				return m, fmt.Errorf("mount target %q is not an absolute path", p[1])
+			}
+			m.Target = filepath.Clean(p[1])
+		case "readOnly":
+			ro, err := strconv.ParseBool(p[1])
+			if err != nil {
+				return m, fmt.Errorf("invalid readOnly value %q", p[1])
+			}
+			m.ReadOnly = ro
+		default:
+			return m, fmt.Errorf("unknown mount option %q", p[0])
+		}
+	}
+	if m.Volume == "" || m.Target == "" {
+		return m, fmt.Errorf("mount %q needs a volume and a target", s)
+	}
+	return m, nil
+}
+
+func (m Mount) String() string {
+	s := "volume=" + m.Volume.String() + ",target=" + m.Target
+	if m.ReadOnly {
+		s += ",readOnly=true"
+	}
+	if m.App != "" {
+		s += ",app=" + m.App.String()
+	}
+	return s
+}
+
+// MountPoint returns the mount point m adds to the apps.
+func (m Mount) MountPoint() types.MountPoint {
+	return types.MountPoint{Name: m.Volume, Path: m.Target, ReadOnly: m.ReadOnly}
+}
+
+// Encode returns the value of the annotation adding mps to an app.
+func Encode(mps []types.MountPoint) (string, error) {
+	b, err := json.Marshal(mps)
+	if err != nil {
+		return "", fmt.Errorf("error encoding mount points: %v", err)
+	}
+	return string(b), nil
+}
+
+// Decode returns the mount points the annotation value val adds.
+func Decode(val string) ([]types.MountPoint, error) {
+	var mps []types.MountPoint
+	if err := json.Unmarshal([]byte(val), &mps); err != nil {
+		return nil, fmt.Errorf("error decoding %s annotation: %v", Annotation, err)
+	}
+	return mps, nil
+}
+
+// Merge returns the mount points declared by an image with added ones,
+// which replace those declared on the same path.
+func Merge(declared, added []types.MountPoint) []types.MountPoint {
+	paths := make(map[string]bool)
+	for _, mp := range added {
+		paths[filepath.Clean(mp.Path)] = true
+	}
+	var mps []types.MountPoint
+	for _, mp := range declared {
+		if !paths[filepath.Clean(mp.Path)] {
+			mps = append(mps, mp)
+		}
+	}
+	return append(mps, added...)
+}
@@ -0,0 +1,81 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mounts
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/appc/spec/schema/types"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Mount
+		ok   bool
+	}{
+		{"volume=data,target=/srv/data", Mount{Volume: "data", Target: "/srv/data"}, true},
+		{"target=/srv/data/,volume=data,readOnly=true,app=web", Mount{Volume: "data", Target: "/srv/data", ReadOnly: true, App: "web"}, true},
+		{"volume=data", Mount{}, false},
+		{"target=/srv", Mount{}, false},
+		{"volume=data,target=srv", Mount{}, false},
+		{"volume=Data,target=/srv", Mount{}, false},
+		{"volume=data,target=/srv,readOnly=maybe", Mount{}, false},
+		{"volume=data,target=/srv,mode=0755", Mount{}, false},
+		{"data:/srv", Mount{}, false},
+	}
+	for _, tt := range tests {
+		m, err := Parse(tt.in)
+		if (err == nil) != tt.ok {
+			t.Errorf("Parse(%q): got error %v, want ok %v", tt.in, err, tt.ok)
+			continue
+		}
+		if tt.ok && m != tt.want {
+			t.Errorf("Parse(%q) = %+v, want %+v", tt.in, m, tt.want)
+		}
+		if tt.ok {
+			if rt, err := Parse(m.String()); err != nil || rt != m {
+				t.Errorf("Parse(%q) = %+v, %v, want %+v", m.String(), rt, err, m)
+			}
+		}
+	}
+}
+
+func TestMerge(t *testing.T) {
+	declared := []types.MountPoint{
+		{Name: "conf", Path: "/etc/app", ReadOnly: true},
+		{Name: "data", Path: "/var/lib/app/"},
+	}
+	added := []types.MountPoint{
+		{Name: "fast", Path: "/var/lib/app"},
+		{Name: "logs", Path: "/var/log/app"},
+	}
+	val, err := Encode(added)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := Decode(val)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []types.MountPoint{declared[0], added[0], added[1]}
+	if got := Merge(declared, decoded); !reflect.DeepEqual(got, want) {
+		t.Errorf("Merge() = %+v, want %+v", got, want)
+	}
+	if _, err := Decode("data:/srv"); err == nil {
+		t.Errorf("Decode() of an invalid value succeeded")
+	}
+}
@@ -0,0 +1,273 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//+build linux
+
+// Package pod inspects the pods, i.e. containers, rkt keeps in its data
+// directory: their state, manifests, networks and the exit codes of their
+// apps. It follows the locking discipline of the rkt commands, so that pods
+// can be inspected while rkt runs, stops or garbage-collects them, and
+// programs using it don't depend on the layout of the data directory.
+package pod
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/appc/spec/aci"
+	"github.com/appc/spec/schema"
+	"github.com/appc/spec/schema/types"
+	"github.com/coreos/rocket/networking"
+	rktpath "github.com/coreos/rocket/path"
+	"github.com/coreos/rocket/pkg/lock"
+)
+
+// State is the state of a pod.
+type State string
+
+const (
+	// Prepared pods were set up by `rkt prepare` and not run yet
+	Prepared State = "prepared"
+	// Running pods are run by their stage1
+	Running State = "running"
+	// Exited pods ran, their apps exited
+	Exited State = "exited"
+	// Garbage pods exited and are to be removed by `rkt gc`
+	Garbage State = "garbage"
+)
+
+// the directories of the data directory holding the pods, by state; running
+// and exited pods share one, told apart by its lock
+var stateDirs = []struct {
+	dir   string
+	state State
+}{
+	{"containers", Exited},
+	{"prepared", Prepared},
+	{"garbage", Garbage},
+}
+
+// statusDir holds the status reported by the stage1 of a pod, see Status
+const statusDir = "stage1/rkt/status"
+
+// ErrNotFound is returned by Open when there is no pod with the UUID given.
+var ErrNotFound = errors.New("pod not found")
+
+// Pod is a pod opened for inspection. Its directory is opened, and locked
+// unless the pod is running or being prepared, until it is closed; the
+// files of the pod are read relative to the directory, so they stay
+// readable when `rkt gc` moves it.
+type Pod struct {
+	UUID  types.UUID
+	State State
+	l     *lock.DirLock
+}
+
+// List returns the UUIDs of the pods in the rkt data directory dataDir,
+// whatever their state, sorted.
+func List(dataDir string) ([]types.UUID, error) {
+	var names []string
+	for _, sd := range stateDirs {
+		ls, err := ioutil.ReadDir(filepath.Join(dataDir, sd.dir))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading pods: %v", err)
+		}
+		for _, fi := range ls {
+			if fi.IsDir() {
+				names = append(names, fi.Name())
+			}
+		}
+	}
+	sort.Strings(names)
+	var uuids []types.UUID
+	for _, n := range names {
+		if u, err := types.NewUUID(n); err == nil {
+			uuids = append(uuids, *u)
+		}
+	}
+	return uuids, nil
+}
+
+// Open opens the pod with the given UUID in the rkt data directory dataDir.
+// Exited pods are locked shared, so that they aren't removed while they are
+// inspected; the pods locked exclusively by their stage1 are running. Pods
+// being removed by `rkt gc` can't be opened.
+func Open(dataDir string, uuid types.UUID) (*Pod, error) {
+	for _, sd := range stateDirs {
+		l, err := lock.NewLock(filepath.Join(dataDir, sd.dir, uuid.String()))
+		if err == lock.ErrNotExist {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error opening lock: %v", err)
+		}
+		p := &Pod{UUID: uuid, State: sd.state, l: l}
+		switch err := l.TrySharedLock(); {
+		case err == lock.ErrLocked && sd.state == Exited:
+			p.State = Running
+		case err == lock.ErrLocked && sd.state == Garbage:
+			l.Close()
+			return nil, fmt.Errorf("pod %s is being removed", uuid)
+		case err == lock.ErrLocked:
+			// being run from the prepared directory
+		case err != nil:
+			l.Close()
+			return nil, fmt.Errorf("error acquiring lock: %v", err)
+		}
+		return p, nil
+	}
+	return nil, ErrNotFound
+}
+
+// Close closes the directory of p, releasing its lock.
+func (p *Pod) Close() error {
+	return p.l.Close()
+}
+
+// Fd returns the file descriptor of the directory of p, which the files of
+// the pod can be opened relative to until p is closed.
+func (p *Pod) Fd() (int, error) {
+	return p.l.Fd()
+}
+
+// WaitExited blocks until the stage1 of a running pod exited.
+func (p *Pod) WaitExited() error {
+	if p.State != Running {
+		return nil
+	}
+	if err := p.l.SharedLock(); err != nil {
+		return fmt.Errorf("error acquiring lock: %v", err)
+	}
+	p.State = Exited
+	return nil
+}
+
+// openAt opens the file at path relative to the directory of p
+func (p *Pod) openAt(path string) (*os.File, error) {
+	dirfd, err := p.l.Fd()
+	if err != nil {
+		return nil, err
+	}
+	fd, err := syscall.Openat(dirfd, path, syscall.O_RDONLY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: path, Err: err}
+	}
+	return os.NewFile(uintptr(fd), path), nil
+}
+
+func (p *Pod) readFile(path string) ([]byte, error) {
+	f, err := p.openAt(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ioutil.ReadAll(f)
+}
+
+// Manifest returns the container runtime manifest of p.
+func (p *Pod) Manifest() (*schema.ContainerRuntimeManifest, error) {
+	b, err := p.readFile(rktpath.ContainerManifestPath("."))
+	if err != nil {
+		return nil, fmt.Errorf("error reading pod manifest: %v", err)
+	}
+	cm := &schema.ContainerRuntimeManifest{}
+	if err := cm.UnmarshalJSON(b); err != nil {
+		return nil, fmt.Errorf("error loading pod manifest: %v", err)
+	}
+	return cm, nil
+}
+
+// AppManifest returns the image manifest of the app of p with the given
+// name.
+func (p *Pod) AppManifest(name types.ACName) (*schema.ImageManifest, error) {
+	cm, err := p.Manifest()
+	if err != nil {
+		return nil, err
+	}
+	ra := cm.Apps.Get(name)
+	if ra == nil {
+		return nil, fmt.Errorf("no app %q in pod %s", name, p.UUID)
+	}
+	b, err := p.readFile(filepath.Join(rktpath.AppImagePath(".", ra.ImageID), aci.ManifestFile))
+	if err != nil {
+		return nil, fmt.Errorf("error reading manifest of app %q: %v", name, err)
+	}
+	am := &schema.ImageManifest{}
+	if err := am.UnmarshalJSON(b); err != nil {
+		return nil, fmt.Errorf("error loading manifest of app %q: %v", name, err)
+	}
+	return am, nil
+}
+
+// PID returns the PID of the stage1 of p, from the time it ran.
+func (p *Pod) PID() (int, error) {
+	b, err := p.readFile("pid")
+	if err != nil {
+		return 0, fmt.Errorf("error reading pid: %v", err)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil {
+		return 0, fmt.Errorf("invalid pid: %v", err)
+	}
+	return pid, nil
+}
+
+// NetInfo returns the networks p is attached to, with their IPs, empty if
+// none were recorded, e.g. because it didn't run yet.
+func (p *Pod) NetInfo() (*networking.NetInfo, error) {
+	f, err := p.openAt(networking.NetInfoFile)
+	if os.IsNotExist(err) {
+		return &networking.NetInfo{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error opening network info: %v", err)
+	}
+	defer f.Close()
+	var ni networking.NetInfo
+	if err := json.NewDecoder(f).Decode(&ni); err != nil {
+		return nil, fmt.Errorf("error reading network info: %v", err)
+	}
+	return &ni, nil
+}
+
+// ExitCodes returns the exit codes of the apps of p which exited, by app
+// name.
+func (p *Pod) ExitCodes() (map[types.ACName]int, error) {
+	cm, err := p.Manifest()
+	if err != nil {
+		return nil, err
+	}
+	codes := make(map[types.ACName]int)
+	for _, ra := range cm.Apps {
+		code, ok, err := p.readInt(filepath.Join(statusDir, types.ShortHash(ra.ImageID.String())))
+		if err != nil {
+			return nil, fmt.Errorf("error reading exit code of app %q: %v", ra.Name, err)
+		}
+		if ok {
+			codes[ra.Name] = code
+		}
+	}
+	return codes, nil
+}
@@ -0,0 +1,152 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//+build linux
+
+package pod
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/appc/spec/schema/types"
+	"github.com/coreos/rocket/pkg/lock"
+)
+
+const (
+	testUUID    = "6733c3b1-8b9e-4f5c-9c2a-1d2e3f4a5b6c"
+	testImageID = "sha512-0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+)
+
+func writeFile(t *testing.T, path, data string) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatalf("error creating dir: %v", err)
+	}
+	if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatalf("error writing file: %v", err)
+	}
+}
+
+func TestPod(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pod")
+	if err != nil {
+		t.Fatalf("error creating tempdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	u, err := types.NewUUID(testUUID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	cdir := filepath.Join(dir, "containers", u.String())
+	writeFile(t, filepath.Join(cdir, "container"), `{"acKind":"ContainerRuntimeManifest","acVersion":"0.1.1","apps":[{"name":"example.com/app","imageID":"`+testImageID+`"}]}`)
+	writeFile(t, filepath.Join(cdir, "pid"), "4242\n")
+	writeFile(t, filepath.Join(cdir, "stage1/rkt/status", types.ShortHash(testImageID)), "3\n")
+	writeFile(t, filepath.Join(cdir, "net-info.json"), `{"nets":[{"netName":"default","ifName":"eth0","ip":"172.16.28.2"}]}`)
+	if err := os.MkdirAll(filepath.Join(dir, "prepared", "not-a-uuid"), 0755); err != nil {
+		t.Fatalf("error creating dir: %v", err)
+	}
+
+	uuids, err := List(dir)
+	if err != nil || !reflect.DeepEqual(uuids, []types.UUID{*u}) {
+		t.Fatalf("got %v, %v, want %v", uuids, err, []types.UUID{*u})
+	}
+
+	p, err := Open(dir, *u)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if p.State != Exited {
+		t.Errorf("got state %s, want %s", p.State, Exited)
+	}
+	if pid, err := p.PID(); err != nil || pid != 4242 {
+		t.Errorf("got pid %d, %v, want 4242", pid, err)
+	}
+	codes, err := p.ExitCodes()
+	if err != nil || !reflect.DeepEqual(codes, map[types.ACName]int{"example.com/app": 3}) {
+		t.Errorf("got exit codes %v, %v", codes, err)
+	}
+	ni, err := p.NetInfo()
+	if err != nil || len(ni.Nets) != 1 || ni.Nets[0].IP != "172.16.28.2" {
+		t.Errorf("got network info %+v, %v", ni, err)
+	}
+	if _, err := p.AppManifest("example.com/other"); err == nil {
+		t.Errorf("expected an error for an unknown app")
+	}
+
+	// gc moving the pod doesn't affect it once open
+	gdir := filepath.Join(dir, "garbage", u.String())
+	if err := os.MkdirAll(filepath.Dir(gdir), 0755); err != nil {
+		t.Fatalf("error creating dir: %v", err)
+	}
+	if err := os.Rename(cdir, gdir); err != nil {
+		t.Fatalf("error moving pod: %v", err)
+	}
+	if pid, err := p.PID(); err != nil || pid != 4242 {
+		t.Errorf("got pid %d, %v after move, want 4242", pid, err)
+	}
+	p.Close()
+
+	// locked exclusively, it is being removed
+	l, err := lock.TryExclusiveLock(gdir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := Open(dir, *u); err == nil {
+		t.Errorf("expected an error opening a pod being removed")
+	}
+	l.Close()
+	if p, err := Open(dir, *u); err != nil || p.State != Garbage {
+		t.Errorf("got %v, %v, want a garbage pod", p, err)
+	}
+
+	os.RemoveAll(gdir)
+	if _, err := Open(dir, *u); err != ErrNotFound {
+		t.Errorf("got %v, want %v", err, ErrNotFound)
+	}
+}
+
+func TestRunning(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pod")
+	if err != nil {
+		t.Fatalf("error creating tempdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	u, _ := types.NewUUID(testUUID)
+	cdir := filepath.Join(dir, "containers", u.String())
+	if err := os.MkdirAll(cdir, 0755); err != nil {
+		t.Fatalf("error creating dir: %v", err)
+	}
+
+	// as held by stage1
+	l, err := lock.TryExclusiveLock(cdir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	p, err := Open(dir, *u)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer p.Close()
+	if p.State != Running {
+		t.Errorf("got state %s, want %s", p.State, Running)
+	}
+	l.Close()
+	if err := p.WaitExited(); err != nil || p.State != Exited {
+		t.Errorf("got %v, state %s, want %s", err, p.State, Exited)
+	}
+}
@@ -0,0 +1,197 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//+build linux
+
+package pod
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/appc/spec/schema/types"
+)
+
+// Stage1 reports how far it got with a pod and the processes of its apps
+// as files of the status directory of its rootfs, stage1/rkt/status in the
+// pod directory, rather than leaving readers to guess from the lock of the
+// pod and the files other parts of rkt leave behind. In version 1 of the
+// protocol, these are:
+//
+//   - pod.json, the phase of the pod, as JSON: {"version": 1, "phase":
+//     "running"}, replaced atomically as the phase changes
+//   - APP.pid, the PID of the main process of the app, in the PID namespace
+//     of the pod, once it is ready: started, and if it notifies systemd of
+//     its readiness, ready
+//   - APP, the exit code of the app, once it exited
+//
+// APP being the short image ID of the app. Readers reject versions newer
+// than theirs, and find no phase for pods run by a stage1 not following
+// the protocol.
+
+// StatusVersion is the version of the status protocol implemented here
+const StatusVersion = 1
+
+// podStatusFile is the file of the status directory holding the phase
+const podStatusFile = "pod.json"
+
+// Phase is how far stage1 got with a pod.
+type Phase string
+
+const (
+	// PhaseSetup pods are set up by stage1: networks, volumes
+	PhaseSetup Phase = "setup"
+	// PhaseRunning pods had their apps started
+	PhaseRunning Phase = "running"
+	// PhaseExited pods had their apps exit
+	PhaseExited Phase = "exited"
+)
+
+type podStatus struct {
+	Version int   `json:"version"`
+	Phase   Phase `json:"phase"`
+}
+
+// AppStatus is the status of an app of a pod.
+type AppStatus struct {
+	Name    types.ACName
+	ImageID types.Hash
+	// PID is the PID of the main process of the app in the PID namespace
+	// of the pod, set once it is ready
+	PID int
+	// Exited tells whether the app exited, with ExitCode
+	Exited   bool
+	ExitCode int
+}
+
+// Status is the status of a pod as reported by its stage1.
+type Status struct {
+	// Phase is empty if the pod didn't run, or its stage1 doesn't report
+	// it
+	Phase Phase
+	Apps  []AppStatus
+}
+
+// Status returns the status of p reported by its stage1.
+func (p *Pod) Status() (*Status, error) {
+	s := &Status{}
+	b, err := p.readFile(filepath.Join(statusDir, podStatusFile))
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		return nil, fmt.Errorf("error reading pod status: %v", err)
+	default:
+		var ps podStatus
+		if err := json.Unmarshal(b, &ps); err != nil {
+			return nil, fmt.Errorf("error loading pod status: %v", err)
+		}
+		if ps.Version > StatusVersion {
+			return nil, fmt.Errorf("pod status is of version %d of the status protocol, newer than %d", ps.Version, StatusVersion)
+		}
+		s.Phase = ps.Phase
+	}
+	// stage1 can't report the end of a pod once it gave way to it
+	if s.Phase != "" && (p.State == Exited || p.State == Garbage) {
+		s.Phase = PhaseExited
+	}
+
+	cm, err := p.Manifest()
+	if err != nil {
+		return nil, err
+	}
+	for _, ra := range cm.Apps {
+		as := AppStatus{Name: ra.Name, ImageID: ra.ImageID}
+		id := types.ShortHash(ra.ImageID.String())
+		if as.PID, _, err = p.readInt(filepath.Join(statusDir, id+".pid")); err != nil {
+			return nil, fmt.Errorf("error reading pid of app %q: %v", ra.Name, err)
+		}
+		if as.ExitCode, as.Exited, err = p.readInt(filepath.Join(statusDir, id)); err != nil {
+			return nil, fmt.Errorf("error reading exit code of app %q: %v", ra.Name, err)
+		}
+		s.Apps = append(s.Apps, as)
+	}
+	return s, nil
+}
+
+// readInt reads the integer in the file at path relative to the directory
+// of p, ok being false if there is none yet: the file doesn't exist or is
+// still empty.
+func (p *Pod) readInt(path string) (i int, ok bool, err error) {
+	b, err := p.readFile(path)
+	if os.IsNotExist(err) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	s := strings.TrimSpace(string(b))
+	if s == "" {
+		return 0, false, nil
+	}
+	if i, err = strconv.Atoi(s); err != nil {
+		return 0, false, err
+	}
+	return i, true, nil
+}
+
+// WritePhase reports, for stage1, the phase of the pod in the directory
+// root.
+func WritePhase(root string, phase Phase) error {
+	b, err := json.Marshal(podStatus{Version: StatusVersion, Phase: phase})
+	if err != nil {
+		return err
+	}
+	sd := filepath.Join(root, statusDir)
+	if err := os.MkdirAll(sd, 0755); err != nil {
+		return fmt.Errorf("error creating status directory: %v", err)
+	}
+	tmp, err := ioutil.TempFile(sd, "."+podStatusFile)
+	if err != nil {
+		return fmt.Errorf("error writing pod status: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(b)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), filepath.Join(sd, podStatusFile))
+	}
+	if err != nil {
+		return fmt.Errorf("error writing pod status: %v", err)
+	}
+	return nil
+}
+
+// WriteAppPID reports, for stage1, the PID of the main process of the app
+// with the image id of the pod in the directory root.
+func WriteAppPID(root string, id types.Hash, pid int) error {
+	sd := filepath.Join(root, statusDir)
+	if err := os.MkdirAll(sd, 0755); err != nil {
+		return fmt.Errorf("error creating status directory: %v", err)
+	}
+	path := filepath.Join(sd, types.ShortHash(id.String())+".pid")
+	if err := ioutil.WriteFile(path, []byte(strconv.Itoa(pid)+"\n"), 0644); err != nil {
+		return fmt.Errorf("error writing pid of app: %v", err)
+	}
+	return nil
+}
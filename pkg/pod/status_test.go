@@ -0,0 +1,90 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//+build linux
+
+package pod
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/appc/spec/schema/types"
+	"github.com/coreos/rocket/pkg/lock"
+)
+
+func TestStatus(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pod")
+	if err != nil {
+		t.Fatalf("error creating tempdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	u, _ := types.NewUUID(testUUID)
+	id, _ := types.NewHash(testImageID)
+	cdir := filepath.Join(dir, "containers", u.String())
+	writeFile(t, filepath.Join(cdir, "container"), `{"acKind":"ContainerRuntimeManifest","acVersion":"0.1.1","apps":[{"name":"example.com/app","imageID":"`+testImageID+`"}]}`)
+
+	// as held by stage1
+	l, err := lock.TryExclusiveLock(cdir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	p, err := Open(dir, *u)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer p.Close()
+
+	check := func(want *Status) {
+		s, err := p.Status()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !reflect.DeepEqual(s, want) {
+			t.Errorf("got status %+v, want %+v", s, want)
+		}
+	}
+
+	// run by a stage1 not reporting its status
+	check(&Status{Apps: []AppStatus{{Name: "example.com/app", ImageID: *id}}})
+
+	if err := WritePhase(cdir, PhaseSetup); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	check(&Status{Phase: PhaseSetup, Apps: []AppStatus{{Name: "example.com/app", ImageID: *id}}})
+
+	if err := WritePhase(cdir, PhaseRunning); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := WriteAppPID(cdir, *id, 42); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	check(&Status{Phase: PhaseRunning, Apps: []AppStatus{{Name: "example.com/app", ImageID: *id, PID: 42}}})
+
+	// stage1 gave way to the pod, which exited without it reporting it
+	writeFile(t, filepath.Join(cdir, "stage1/rkt/status", types.ShortHash(testImageID)), "0\n")
+	l.Close()
+	if err := p.WaitExited(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	check(&Status{Phase: PhaseExited, Apps: []AppStatus{{Name: "example.com/app", ImageID: *id, PID: 42, Exited: true}}})
+
+	writeFile(t, filepath.Join(cdir, "stage1/rkt/status/pod.json"), `{"version":2,"phase":"running"}`)
+	if _, err := p.Status(); err == nil {
+		t.Errorf("expected an error for a newer version of the status protocol")
+	}
+}
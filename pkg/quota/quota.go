@@ -0,0 +1,231 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//+build linux
+
+// Package quota limits the disk space containers write to, through the
+// project quotas of xfs and ext4. Each container directory is given a
+// project of its own, inherited by all the files created beneath it, so the
+// limit covers the stage1 rootfs, the extracted images and the upper layers
+// of overlays alike. The filesystem holding the containers must be mounted
+// with project quotas enabled (the prjquota option).
+package quota
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	// from linux/fs.h
+	fsIocFSGetXattr    = 0x801c581f
+	fsIocFSSetXattr    = 0x401c5820
+	fsXflagProjInherit = 0x00000200
+
+	// from linux/quota.h
+	qGetQuota  = 0x800007
+	qSetQuota  = 0x800008
+	prjQuota   = 2
+	qifBLimits = 1
+	// limits are set in blocks of qifBlockSize bytes
+	qifBlockSize = 1024
+)
+
+// fsxattr is struct fsxattr of linux/fs.h
+type fsxattr struct {
+	xflags     uint32
+	extsize    uint32
+	nextents   uint32
+	projid     uint32
+	cowextsize uint32
+	pad        [8]byte
+}
+
+// dqblk is struct if_dqblk of linux/quota.h
+type dqblk struct {
+	bhardlimit uint64
+	bsoftlimit uint64
+	curspace   uint64
+	ihardlimit uint64
+	isoftlimit uint64
+	curinodes  uint64
+	btime      uint64
+	itime      uint64
+	valid      uint32
+}
+
+// ProjectID returns the project ID for the container with the given UUID.
+// IDs are derived from the UUID so that they don't need to be recorded.
+func ProjectID(uuid string) uint32 {
+	h := sha1.Sum([]byte(uuid))
+	// 0 is the default project of all files
+	return binary.BigEndian.Uint32(h[:4])&0x7fffffff | 1
+}
+
+func ioctl(fd int, req uintptr, arg unsafe.Pointer) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), req, uintptr(arg)); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+func quotactl(cmd int, special string, id uint32, q *dqblk) error {
+	p, err := syscall.BytePtrFromString(special)
+	if err != nil {
+		return err
+	}
+	qcmd := uintptr(cmd<<8 | prjQuota)
+	if _, _, errno := syscall.Syscall6(syscall.SYS_QUOTACTL, qcmd, uintptr(unsafe.Pointer(p)), uintptr(id), uintptr(unsafe.Pointer(q)), 0, 0); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// Set puts the directory dir, and all the files to be created beneath it,
+// in the project id and limits the space used by that project to limit
+// bytes. It must be called on an empty directory, files already in it are
+// not moved to the project.
+func Set(dir string, id uint32, limit uint64) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	fd := int(d.Fd())
+
+	dev, err := deviceAt(fd)
+	if err != nil {
+		return err
+	}
+
+	var attr fsxattr
+	if err := ioctl(fd, fsIocFSGetXattr, unsafe.Pointer(&attr)); err != nil {
+		return fmt.Errorf("error getting project of %q, project quotas are only supported on xfs and ext4: %v", dir, err)
+	}
+	attr.projid = id
+	attr.xflags |= fsXflagProjInherit
+	if err := ioctl(fd, fsIocFSSetXattr, unsafe.Pointer(&attr)); err != nil {
+		return fmt.Errorf("error setting project of %q: %v", dir, err)
+	}
+
+	return setLimit(dev, id, limit)
+}
+
+func setLimit(dev string, id uint32, limit uint64) error {
+	blocks := (limit + qifBlockSize - 1) / qifBlockSize
+	q := dqblk{
+		bhardlimit: blocks,
+		bsoftlimit: blocks,
+		valid:      qifBLimits,
+	}
+	if err := quotactl(qSetQuota, dev, id, &q); err != nil {
+		if err == syscall.ESRCH {
+			return fmt.Errorf("project quotas are not enabled on %s, it must be mounted with the prjquota option", dev)
+		}
+		return fmt.Errorf("error setting quota of project %d: %v", id, err)
+	}
+	return nil
+}
+
+// GetAt returns the space used by the project of the directory open as
+// fd, and the limit of that project. The limit is 0 when the directory is
+// in no project or its project has no limit.
+func GetAt(fd int) (used, limit uint64, err error) {
+	var attr fsxattr
+	if err := ioctl(fd, fsIocFSGetXattr, unsafe.Pointer(&attr)); err != nil || attr.projid == 0 {
+		// not in a project, or on a filesystem without project quotas
+		return 0, 0, nil
+	}
+	dev, err := deviceAt(fd)
+	if err != nil {
+		return 0, 0, err
+	}
+	var q dqblk
+	if err := quotactl(qGetQuota, dev, attr.projid, &q); err != nil {
+		if err == syscall.ESRCH {
+			return 0, 0, nil
+		}
+		return 0, 0, fmt.Errorf("error getting quota of project %d: %v", attr.projid, err)
+	}
+	return q.curspace, q.bhardlimit * qifBlockSize, nil
+}
+
+// Clear removes the limit of the project of dir, once the directory isn't
+// needed anymore. Directories without a project are ignored.
+func Clear(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	fd := int(d.Fd())
+
+	var attr fsxattr
+	if err := ioctl(fd, fsIocFSGetXattr, unsafe.Pointer(&attr)); err != nil || attr.projid == 0 {
+		return nil
+	}
+	dev, err := deviceAt(fd)
+	if err != nil {
+		return err
+	}
+	return setLimit(dev, attr.projid, 0)
+}
+
+// deviceAt returns the block device holding the filesystem of fd, which
+// quotactl identifies filesystems by
+func deviceAt(fd int) (string, error) {
+	var st syscall.Stat_t
+	if err := syscall.Fstat(fd, &st); err != nil {
+		return "", err
+	}
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	return findDevice(f, st.Dev)
+}
+
+// findDevice returns the source of the first mount of the device dev in
+// the mountinfo read from r
+func findDevice(r io.Reader, dev uint64) (string, error) {
+	major := (dev>>8)&0xfff | (dev>>32)&^0xfff
+	minor := dev&0xff | (dev>>12)&^0xff
+	want := fmt.Sprintf("%d:%d", major, minor)
+
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		// 36 35 98:0 /mnt1 /mnt2 rw,noatime master:1 - ext3 /dev/root rw
+		fields := strings.Fields(s.Text())
+		if len(fields) < 3 || fields[2] != want {
+			continue
+		}
+		for i, f := range fields {
+			if f == "-" && i+2 < len(fields) {
+				return fields[i+2], nil
+			}
+		}
+	}
+	if err := s.Err(); err != nil {
+		return "", err
+	}
+	return "", fmt.Errorf("no mount found for device %s", want)
+}
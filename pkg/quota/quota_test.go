@@ -0,0 +1,59 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//+build linux
+
+package quota
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFindDevice(t *testing.T) {
+	mountinfo := `17 22 0:16 / /sys rw,nosuid,nodev,noexec,relatime shared:6 - sysfs sysfs rw
+22 1 253:1 / / rw,relatime shared:1 - ext4 /dev/mapper/root rw,data=ordered
+40 22 8:17 / /var/lib/rkt rw,relatime shared:27 - xfs /dev/sdb1 rw,prjquota
+41 22 8:17 /containers /srv/containers rw,relatime shared:27 - xfs /dev/sdb1 rw,prjquota
+`
+	tests := []struct {
+		dev  uint64
+		want string
+	}{
+		{253<<8 | 1, "/dev/mapper/root"},
+		{8<<8 | 17, "/dev/sdb1"},
+	}
+	for _, tt := range tests {
+		got, err := findDevice(strings.NewReader(mountinfo), tt.dev)
+		if err != nil {
+			t.Errorf("device %d: unexpected error: %v", tt.dev, err)
+		} else if got != tt.want {
+			t.Errorf("device %d: got %q, want %q", tt.dev, got, tt.want)
+		}
+	}
+
+	if _, err := findDevice(strings.NewReader(mountinfo), 9<<8); err == nil {
+		t.Errorf("expected error for unmounted device")
+	}
+}
+
+func TestProjectID(t *testing.T) {
+	a := ProjectID("9f2a7d5c-0b3e-4a41-8c1d-2b8e7f6a5d4c")
+	if a == 0 {
+		t.Errorf("got project 0, the default project")
+	}
+	if b := ProjectID("9f2a7d5c-0b3e-4a41-8c1d-2b8e7f6a5d4c"); a != b {
+		t.Errorf("project IDs differ for the same UUID: %d, %d", a, b)
+	}
+}
@@ -0,0 +1,72 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package rootfs handles the isolator making the root filesystem of apps
+// read-only.
+package rootfs
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+
+	"github.com/appc/spec/schema/types"
+)
+
+const (
+	// ReadOnlyIsolator makes the rootfs of an app read-only when "true",
+	// only its volumes and TmpDir being writable
+	ReadOnlyIsolator = "os/linux/read-only-rootfs"
+
+	// TmpDir gets a tmpfs of its own in a read-only rootfs, for the
+	// temporary files of the app
+	TmpDir = "/tmp"
+)
+
+// ParseReadOnly parses the value of a ReadOnlyIsolator.
+func ParseReadOnly(val string) (bool, error) {
+	ro, err := strconv.ParseBool(val)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s %q, want true or false", ReadOnlyIsolator, val)
+	}
+	return ro, nil
+}
+
+// ReadOnly reports whether isolators make the rootfs of an app read-only,
+// the last ReadOnlyIsolator applying.
+func ReadOnly(isolators types.Isolators) (bool, error) {
+	ro := false
+	for _, i := range isolators {
+		if i.Name != ReadOnlyIsolator {
+			continue
+		}
+		var err error
+		if ro, err = ParseReadOnly(i.Val); err != nil {
+			return false, err
+		}
+	}
+	return ro, nil
+}
+
+// NeedsTmpfs reports whether an app with the given mount points gets a
+// tmpfs on TmpDir in a read-only rootfs, i.e. unless a volume is mounted
+// there.
+func NeedsTmpfs(mountPoints []types.MountPoint) bool {
+	for _, mp := range mountPoints {
+		if filepath.Clean(mp.Path) == TmpDir {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,49 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rootfs
+
+import (
+	"testing"
+
+	"github.com/appc/spec/schema/types"
+)
+
+func TestReadOnly(t *testing.T) {
+	for i, tt := range []struct {
+		isolators types.Isolators
+		ro        bool
+		err       bool
+	}{
+		{nil, false, false},
+		{types.Isolators{{Name: ReadOnlyIsolator, Val: "true"}}, true, false},
+		{types.Isolators{{Name: ReadOnlyIsolator, Val: "true"}, {Name: ReadOnlyIsolator, Val: "false"}}, false, false},
+		{types.Isolators{{Name: "resource/memory", Val: "1G"}, {Name: ReadOnlyIsolator, Val: "1"}}, true, false},
+		{types.Isolators{{Name: ReadOnlyIsolator, Val: "yes"}}, false, true},
+	} {
+		ro, err := ReadOnly(tt.isolators)
+		if (err != nil) != tt.err || ro != tt.ro {
+			t.Errorf("#%d: got %v, %v", i, ro, err)
+		}
+	}
+}
+
+func TestNeedsTmpfs(t *testing.T) {
+	if !NeedsTmpfs([]types.MountPoint{{Name: "data", Path: "/var/data"}}) {
+		t.Errorf("expected a tmpfs without a volume on /tmp")
+	}
+	if NeedsTmpfs([]types.MountPoint{{Name: "tmp", Path: "/tmp/"}}) {
+		t.Errorf("expected no tmpfs with a volume on /tmp")
+	}
+}
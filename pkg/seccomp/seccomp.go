@@ -0,0 +1,210 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package seccomp handles the isolators and profiles restricting the
+// system calls apps may make.
+package seccomp
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+)
+
+const (
+	// RetainSetIsolator restricts the system calls of an app to those it
+	// lists
+	RetainSetIsolator = "os/linux/seccomp-retain-set"
+	// RemoveSetIsolator forbids the system calls it lists to an app, an
+	// empty set leaves the app unrestricted
+	RemoveSetIsolator = "os/linux/seccomp-remove-set"
+
+	// DefaultErrno is the error forbidden system calls fail with, unless
+	// a filter gives another one
+	DefaultErrno = "EPERM"
+
+	// ProfileNone selects no filter at all
+	ProfileNone = "none"
+	// ProfileDefault is the profile applied to apps declaring no filter
+	ProfileDefault = "default"
+)
+
+// Filter is a set of system calls an app is restricted to, or forbidden.
+type Filter struct {
+	// Retain is set if the app is restricted to Syscalls, rather than
+	// forbidden them
+	Retain   bool     `json:"retain"`
+	Syscalls []string `json:"syscalls"`
+	// Errno is the error forbidden system calls fail with
+	Errno string `json:"errno,omitempty"`
+}
+
+// Profiles are the built-in filters, by name.
+var Profiles = map[string]*Filter{
+	// forbids what containers have no business doing, e.g. loading
+	// kernel modules, changing the time or tracing other processes
+	ProfileDefault: {
+		Errno: DefaultErrno,
+		Syscalls: []string{
+			"_sysctl", "acct", "add_key", "bpf", "clock_adjtime", "clock_settime",
+			"create_module", "delete_module", "finit_module", "get_kernel_syms",
+			"get_mempolicy", "init_module", "ioperm", "iopl", "kcmp",
+			"kexec_file_load", "kexec_load", "keyctl", "lookup_dcookie", "mbind",
+			"mount", "move_pages", "name_to_handle_at", "nfsservctl",
+			"open_by_handle_at", "perf_event_open", "personality", "pivot_root",
+			"process_vm_readv", "process_vm_writev", "ptrace", "query_module",
+			"quotactl", "reboot", "request_key", "set_mempolicy", "setns",
+			"settimeofday", "stime", "swapoff", "swapon", "sysfs", "umount",
+			"umount2", "unshare", "uselib", "userfaultfd", "ustat", "vm86",
+			"vm86old",
+		},
+	},
+	// only allows what common services need: files, sockets, processes,
+	// threads, signals, timers and memory
+	"strict": {
+		Retain: true,
+		Errno:  DefaultErrno,
+		Syscalls: []string{
+			"accept", "accept4", "access", "alarm", "arch_prctl", "bind", "brk",
+			"capget", "capset", "chdir", "chmod", "chown", "clock_getres",
+			"clock_gettime", "clock_nanosleep", "clone", "close", "connect",
+			"creat", "dup", "dup2", "dup3", "epoll_create", "epoll_create1",
+			"epoll_ctl", "epoll_pwait", "epoll_wait", "eventfd", "eventfd2",
+			"execve", "exit", "exit_group", "faccessat", "fadvise64",
+			"fallocate", "fchdir", "fchmod", "fchmodat", "fchown", "fchownat",
+			"fcntl", "fdatasync", "fgetxattr", "flistxattr", "flock", "fork",
+			"fstat", "fstatfs", "fsync", "ftruncate", "futex", "getcwd",
+			"getdents", "getdents64", "getegid", "geteuid", "getgid",
+			"getgroups", "getitimer", "getpeername", "getpgid", "getpgrp",
+			"getpid", "getppid", "getpriority", "getrandom", "getresgid",
+			"getresuid", "getrlimit", "getrusage", "getsid", "getsockname",
+			"getsockopt", "gettid", "gettimeofday", "getuid", "getxattr",
+			"inotify_add_watch", "inotify_init", "inotify_init1",
+			"inotify_rm_watch", "ioctl", "kill", "lchown", "lgetxattr", "link",
+			"linkat", "listen", "listxattr", "llistxattr", "lseek", "lstat",
+			"madvise", "mincore", "mkdir", "mkdirat", "mlock", "mmap",
+			"mprotect", "mremap", "msync", "munlock", "munmap", "nanosleep",
+			"newfstatat", "open", "openat", "pause", "pipe", "pipe2", "poll",
+			"ppoll", "prctl", "pread64", "preadv", "prlimit64", "pselect6",
+			"pwrite64", "pwritev", "read", "readahead", "readlink",
+			"readlinkat", "readv", "recvfrom", "recvmmsg", "recvmsg", "rename",
+			"renameat", "restart_syscall", "rmdir", "rt_sigaction",
+			"rt_sigpending", "rt_sigprocmask", "rt_sigqueueinfo",
+			"rt_sigreturn", "rt_sigsuspend", "rt_sigtimedwait",
+			"sched_getaffinity", "sched_getparam", "sched_getscheduler",
+			"sched_yield", "select", "sendfile", "sendmmsg", "sendmsg", "sendto",
+			"set_robust_list", "set_tid_address", "setgid", "setgroups",
+			"setitimer", "setpgid", "setpriority", "setresgid", "setresuid",
+			"setrlimit", "setsid", "setsockopt", "setuid", "shutdown",
+			"sigaltstack", "signalfd", "signalfd4", "socket", "socketpair",
+			"splice", "stat", "statfs", "symlink", "symlinkat", "sync",
+			"sysinfo", "tee", "tgkill", "time", "timer_create", "timer_delete",
+			"timer_getoverrun", "timer_gettime", "timer_settime",
+			"timerfd_create", "timerfd_gettime", "timerfd_settime", "tkill",
+			"truncate", "umask", "uname", "unlink", "unlinkat", "utime",
+			"utimensat", "utimes", "vfork", "wait4", "waitid", "write", "writev",
+		},
+	},
+}
+
+// ProfileNames returns the names of the built-in profiles, sorted.
+func ProfileNames() []string {
+	names := []string{ProfileNone}
+	for n := range Profiles {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Parse parses the value of a RetainSetIsolator or RemoveSetIsolator, as
+// given by name: a list of system call names separated by spaces or
+// commas, which may include errno=ENAME to set the error forbidden system
+// calls fail with.
+func Parse(name, val string) (*Filter, error) {
+	f := &Filter{Retain: name == RetainSetIsolator, Errno: DefaultErrno}
+	for _, s := range strings.FieldsFunc(val, func(r rune) bool { return r == ' ' || r == ',' }) {
+		if strings.HasPrefix(s, "errno=") {
+			f.Errno = s[len("errno="):]
+			continue
+		}
+		f.Syscalls = append(f.Syscalls, s)
+	}
+	if err := f.check(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// check checks the names of the system calls and the errno are well-formed
+func (f *Filter) check() error {
+	if f.Retain && len(f.Syscalls) == 0 {
+		return fmt.Errorf("an empty retain set would forbid all system calls")
+	}
+	for _, s := range f.Syscalls {
+		if s == "" || strings.Trim(s, "abcdefghijklmnopqrstuvwxyz0123456789_") != "" {
+			return fmt.Errorf("invalid system call name %q", s)
+		}
+	}
+	if len(f.Errno) < 2 || f.Errno[0] != 'E' || strings.Trim(f.Errno, "ABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789") != "" {
+		return fmt.Errorf("invalid errno %q", f.Errno)
+	}
+	return nil
+}
+
+// Isolator returns the name and value of the isolator applying f.
+func (f *Filter) Isolator() (string, string) {
+	name := RemoveSetIsolator
+	if f.Retain {
+		name = RetainSetIsolator
+	}
+	val := strings.Join(f.Syscalls, " ")
+	if f.Errno != DefaultErrno {
+		val += " errno=" + f.Errno
+	}
+	return name, strings.TrimSpace(val)
+}
+
+// Load returns the filter named by profile: none, which is nil, a built-in
+// profile, or a profile file, in the JSON form of Filter, e.g.
+//
+//	{"retain": false, "syscalls": ["mount", "ptrace"], "errno": "EACCES"}
+func Load(profile string) (*Filter, error) {
+	if profile == ProfileNone {
+		return nil, nil
+	}
+	if f, ok := Profiles[profile]; ok {
+		return f, nil
+	}
+	if !strings.Contains(profile, "/") {
+		return nil, fmt.Errorf("unknown seccomp profile %q, want one of %s or a file", profile, strings.Join(ProfileNames(), ", "))
+	}
+	b, err := ioutil.ReadFile(profile)
+	if err != nil {
+		return nil, fmt.Errorf("error reading seccomp profile: %v", err)
+	}
+	f := &Filter{}
+	if err := json.Unmarshal(b, f); err != nil {
+		return nil, fmt.Errorf("error parsing seccomp profile %s: %v", profile, err)
+	}
+	if f.Errno == "" {
+		f.Errno = DefaultErrno
+	}
+	if err := f.check(); err != nil {
+		return nil, fmt.Errorf("seccomp profile %s: %v", profile, err)
+	}
+	return f, nil
+}
@@ -0,0 +1,117 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package seccomp
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name string
+		val  string
+
+		want *Filter
+		err  bool
+	}{
+		{
+			RemoveSetIsolator,
+			"mount, umount2 ptrace",
+			&Filter{Syscalls: []string{"mount", "umount2", "ptrace"}, Errno: DefaultErrno},
+			false,
+		},
+		{
+			RetainSetIsolator,
+			"read write errno=EACCES",
+			&Filter{Retain: true, Syscalls: []string{"read", "write"}, Errno: "EACCES"},
+			false,
+		},
+		{
+			RemoveSetIsolator,
+			"",
+			&Filter{Errno: DefaultErrno},
+			false,
+		},
+		{RetainSetIsolator, "", nil, true},
+		{RemoveSetIsolator, "Mount", nil, true},
+		{RemoveSetIsolator, "mount errno=eperm", nil, true},
+	}
+	for i, tt := range tests {
+		f, err := Parse(tt.name, tt.val)
+		if (err != nil) != tt.err {
+			t.Errorf("#%d: got error %v, want error %t", i, err, tt.err)
+			continue
+		}
+		if !reflect.DeepEqual(f, tt.want) {
+			t.Errorf("#%d: got %+v, want %+v", i, f, tt.want)
+		}
+	}
+}
+
+func TestIsolator(t *testing.T) {
+	for _, f := range []*Filter{
+		Profiles[ProfileDefault],
+		Profiles["strict"],
+		{Syscalls: []string{"mount"}, Errno: "EACCES"},
+	} {
+		name, val := f.Isolator()
+		got, err := Parse(name, val)
+		if err != nil {
+			t.Errorf("%s %q: unexpected error: %v", name, val, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, f) {
+			t.Errorf("%s %q: got %+v, want %+v", name, val, got, f)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "seccomp")
+	if err != nil {
+		t.Fatalf("error creating tempdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	if f, err := Load(ProfileNone); f != nil || err != nil {
+		t.Errorf("got %+v, %v for no profile", f, err)
+	}
+	if f, err := Load(ProfileDefault); f != Profiles[ProfileDefault] || err != nil {
+		t.Errorf("got %+v, %v for the default profile", f, err)
+	}
+	if _, err := Load("lax"); err == nil {
+		t.Errorf("expected an error for an unknown profile")
+	}
+
+	p := filepath.Join(dir, "profile.json")
+	if err := ioutil.WriteFile(p, []byte(`{"retain": true, "syscalls": ["read", "write", "exit"]}`), 0644); err != nil {
+		t.Fatalf("error writing profile: %v", err)
+	}
+	want := &Filter{Retain: true, Syscalls: []string{"read", "write", "exit"}, Errno: DefaultErrno}
+	if f, err := Load(p); err != nil || !reflect.DeepEqual(f, want) {
+		t.Errorf("got %+v, %v, want %+v", f, err, want)
+	}
+
+	if err := ioutil.WriteFile(p, []byte(`{"syscalls": ["read"], "errno": "nope"}`), 0644); err != nil {
+		t.Fatalf("error writing profile: %v", err)
+	}
+	if _, err := Load(p); err == nil {
+		t.Errorf("expected an error for an invalid errno")
+	}
+}
@@ -0,0 +1,281 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//+build linux
+
+// Package selinux gives each container an SELinux context of its own, sVirt
+// style: its processes and files get the contexts of the host's
+// lxc_contexts with a category pair no other container has, so that
+// containers can't access each other's files even when running as the
+// same type.
+package selinux
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	// LabelsFile is the file, relative to the directory of a container,
+	// recording its labels
+	LabelsFile = "selinux.json"
+
+	selinuxfs     = "/sys/fs/selinux"
+	selinuxConfig = "/etc/selinux/config"
+
+	defaultProcess = "system_u:system_r:svirt_lxc_net_t:s0"
+	defaultFile    = "system_u:object_r:svirt_sandbox_file_t:s0"
+
+	// categories is the number of MCS categories, c0 to c1023
+	categories = 1024
+
+	xattrName           = "security.selinux"
+	overlayfsSuperMagic = 0x794c7630
+)
+
+// Enabled reports whether SELinux is enabled on the host, be it enforcing
+// or permissive.
+func Enabled() bool {
+	_, err := os.Stat(filepath.Join(selinuxfs, "enforce"))
+	return err == nil
+}
+
+// Labels are the contexts of the processes and files of a container.
+type Labels struct {
+	Process string `json:"process"`
+	File    string `json:"file"`
+}
+
+// Contexts returns the contexts of the processes and files of containers
+// from the lxc_contexts of the host's policy, or the usual ones if it has
+// none.
+func Contexts() (string, string) {
+	process, file := defaultProcess, defaultFile
+	policy, err := policyType()
+	if err != nil {
+		return process, file
+	}
+	f, err := os.Open(filepath.Join("/etc/selinux", policy, "contexts", "lxc_contexts"))
+	if err != nil {
+		return process, file
+	}
+	defer f.Close()
+	p, fl := parseLxcContexts(f)
+	if p != "" {
+		process = p
+	}
+	if fl != "" {
+		file = fl
+	}
+	return process, file
+}
+
+// policyType returns the SELINUXTYPE of the host's configuration
+func policyType() (string, error) {
+	f, err := os.Open(selinuxConfig)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if strings.HasPrefix(line, "SELINUXTYPE=") {
+			return strings.TrimPrefix(line, "SELINUXTYPE="), nil
+		}
+	}
+	if err := s.Err(); err != nil {
+		return "", err
+	}
+	return "", fmt.Errorf("no SELINUXTYPE in %s", selinuxConfig)
+}
+
+// parseLxcContexts returns the process and file contexts of an
+// lxc_contexts file, made of KEY = "CONTEXT" lines
+func parseLxcContexts(r io.Reader) (string, string) {
+	var process, file string
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		val := strings.Trim(strings.TrimSpace(kv[1]), "\"")
+		switch strings.TrimSpace(kv[0]) {
+		case "process":
+			process = val
+		case "file":
+			file = val
+		}
+	}
+	return process, file
+}
+
+// withLevel replaces the level of context, its fourth field, with level
+func withLevel(context, level string) string {
+	parts := strings.SplitN(context, ":", 4)
+	if len(parts) < 3 {
+		return context
+	}
+	return strings.Join(append(parts[:3], level), ":")
+}
+
+// Level returns the MCS level of the labels, e.g. s0:c12,c345.
+func (l *Labels) Level() string {
+	parts := strings.SplitN(l.Process, ":", 4)
+	if len(parts) < 4 {
+		return ""
+	}
+	return parts[3]
+}
+
+// Allocate returns labels derived from the process and file contexts with
+// a category pair none of the containers in dirs has.
+func Allocate(process, file string, dirs ...string) (*Labels, error) {
+	used := make(map[string]bool)
+	for _, d := range dirs {
+		if d == "" {
+			continue
+		}
+		ls, err := ioutil.ReadDir(d)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading containers directory: %v", err)
+		}
+		for _, fi := range ls {
+			// containers set up without labels have none
+			if l, err := Load(filepath.Join(d, fi.Name())); err == nil && l != nil {
+				used[l.Level()] = true
+			}
+		}
+	}
+
+	for i := 0; i < 100; i++ {
+		c1, err := rand.Int(rand.Reader, big.NewInt(categories))
+		if err != nil {
+			return nil, err
+		}
+		c2, err := rand.Int(rand.Reader, big.NewInt(categories))
+		if err != nil {
+			return nil, err
+		}
+		a, b := c1.Int64(), c2.Int64()
+		if a == b {
+			continue
+		}
+		if a > b {
+			a, b = b, a
+		}
+		level := fmt.Sprintf("s0:c%d,c%d", a, b)
+		if used[level] {
+			continue
+		}
+		return &Labels{
+			Process: withLevel(process, level),
+			File:    withLevel(file, level),
+		}, nil
+	}
+	return nil, fmt.Errorf("unable to find a free category pair")
+}
+
+// Write records the labels in the directory of a container.
+func (l *Labels) Write(dir string) error {
+	b, err := json.Marshal(l)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, LabelsFile), b, 0644)
+}
+
+// Load returns the labels recorded in the directory of a container, nil if
+// it has none.
+func Load(dir string) (*Labels, error) {
+	b, err := ioutil.ReadFile(filepath.Join(dir, LabelsFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	l := &Labels{}
+	if err := json.Unmarshal(b, l); err != nil {
+		return nil, fmt.Errorf("error reading SELinux labels: %v", err)
+	}
+	return l, nil
+}
+
+// MountOption returns the option labeling all the files of a mount with
+// the file context of the labels.
+func (l *Labels) MountOption() string {
+	return `context="` + l.File + `"`
+}
+
+// Relabel labels dir and everything below it with the file context of the
+// labels. Overlays are skipped, they are mounted with MountOption.
+func (l *Labels) Relabel(dir string) error {
+	return filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() && path != dir {
+			var st syscall.Statfs_t
+			if err := syscall.Statfs(path, &st); err != nil {
+				return err
+			}
+			if st.Type == overlayfsSuperMagic {
+				return filepath.SkipDir
+			}
+		}
+		if err := lsetxattr(path, xattrName, []byte(l.File)); err != nil {
+			return fmt.Errorf("error labeling %s: %v", path, err)
+		}
+		return nil
+	})
+}
+
+// lsetxattr sets an extended attribute of path, not following symlinks
+func lsetxattr(path, name string, value []byte) error {
+	p, err := syscall.BytePtrFromString(path)
+	if err != nil {
+		return err
+	}
+	n, err := syscall.BytePtrFromString(name)
+	if err != nil {
+		return err
+	}
+	var v unsafe.Pointer
+	if len(value) > 0 {
+		v = unsafe.Pointer(&value[0])
+	}
+	if _, _, errno := syscall.Syscall6(syscall.SYS_LSETXATTR, uintptr(unsafe.Pointer(p)), uintptr(unsafe.Pointer(n)), uintptr(v), uintptr(len(value)), 0, 0); errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//+build linux
+
+package selinux
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseLxcContexts(t *testing.T) {
+	process, file := parseLxcContexts(strings.NewReader(`# comment
+process = "system_u:system_r:svirt_lxc_net_t:s0"
+content = "system_u:object_r:virt_var_lib_t:s0"
+file = "system_u:object_r:svirt_sandbox_file_t:s0"
+`))
+	if process != "system_u:system_r:svirt_lxc_net_t:s0" {
+		t.Errorf("got process context %q", process)
+	}
+	if file != "system_u:object_r:svirt_sandbox_file_t:s0" {
+		t.Errorf("got file context %q", file)
+	}
+}
+
+func TestWithLevel(t *testing.T) {
+	for ctx, want := range map[string]string{
+		"system_u:system_r:svirt_lxc_net_t:s0":         "system_u:system_r:svirt_lxc_net_t:s0:c1,c2",
+		"system_u:system_r:svirt_lxc_net_t:s0:c4,c5":   "system_u:system_r:svirt_lxc_net_t:s0:c1,c2",
+		"system_u:system_r:svirt_lxc_net_t":            "system_u:system_r:svirt_lxc_net_t:s0:c1,c2",
+		"system_u:object_r:svirt_sandbox_file_t:s0-s0": "system_u:object_r:svirt_sandbox_file_t:s0:c1,c2",
+	} {
+		if got := withLevel(ctx, "s0:c1,c2"); got != want {
+			t.Errorf("%q: got %q, want %q", ctx, got, want)
+		}
+	}
+}
+
+func TestAllocate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "selinux")
+	if err != nil {
+		t.Fatalf("error creating tempdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	seen := make(map[string]bool)
+	for i := 0; i < 20; i++ {
+		l, err := Allocate(defaultProcess, defaultFile, dir, filepath.Join(dir, "missing"))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		level := l.Level()
+		if seen[level] {
+			t.Fatalf("level %q allocated twice", level)
+		}
+		seen[level] = true
+		if !strings.HasSuffix(l.File, ":"+level) {
+			t.Errorf("file context %q doesn't have level %q", l.File, level)
+		}
+
+		cdir := filepath.Join(dir, strings.Replace(level, ",", "-", -1))
+		if err := os.Mkdir(cdir, 0700); err != nil {
+			t.Fatalf("error creating container dir: %v", err)
+		}
+		if err := l.Write(cdir); err != nil {
+			t.Fatalf("error writing labels: %v", err)
+		}
+		if got, err := Load(cdir); err != nil || !reflect.DeepEqual(got, l) {
+			t.Errorf("got %+v, %v, want %+v", got, err, l)
+		}
+	}
+	if l, err := Load(dir); l != nil || err != nil {
+		t.Errorf("got %+v, %v for a container without labels", l, err)
+	}
+}
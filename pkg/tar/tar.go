@@ -16,11 +16,13 @@ package tar
 
 import (
 	"archive/tar"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"syscall"
 )
@@ -180,6 +182,66 @@ func ExtractFileFromTar(tr *tar.Reader, file string) ([]byte, error) {
 	}
 }
 
+// ErrNotFound is returned when a path isn't in a tarball
+var ErrNotFound = errors.New("no such file in tarball")
+
+// FindFile advances tr to the entry for file and returns its header. The
+// contents of a regular file can then be streamed from tr.
+func FindFile(tr *tar.Reader, file string) (*tar.Header, error) {
+	file = filepath.Clean(file)
+	for {
+		hdr, err := tr.Next()
+		switch err {
+		case io.EOF:
+			return nil, ErrNotFound
+		case nil:
+			if filepath.Clean(hdr.Name) == file {
+				return hdr, nil
+			}
+		default:
+			return nil, fmt.Errorf("error reading tarball: %v", err)
+		}
+	}
+}
+
+// ListDir returns the headers of the entries directly beneath dir in tr,
+// sorted by name, or the header of dir alone if it isn't a directory.
+func ListDir(tr *tar.Reader, dir string) ([]*tar.Header, error) {
+	dir = filepath.Clean(dir)
+	var self *tar.Header
+	var hdrs []*tar.Header
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading tarball: %v", err)
+		}
+		name := filepath.Clean(hdr.Name)
+		switch {
+		case name == dir:
+			self = hdr
+		case filepath.Dir(name) == dir:
+			hdrs = append(hdrs, hdr)
+		}
+	}
+	if self != nil && self.Typeflag != tar.TypeDir {
+		return []*tar.Header{self}, nil
+	}
+	if self == nil && hdrs == nil {
+		return nil, ErrNotFound
+	}
+	sort.Sort(headersByName(hdrs))
+	return hdrs, nil
+}
+
+type headersByName []*tar.Header
+
+func (h headersByName) Len() int           { return len(h) }
+func (h headersByName) Less(i, j int) bool { return h[i].Name < h[j].Name }
+func (h headersByName) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
 // makedev mimics glib's gnu_dev_makedev
 func makedev(major, minor int) int {
 	return (minor & 0xff) | (major & 0xfff << 8) | int((uint64(minor & ^0xff) << 12)) | int(uint64(major & ^0xfff)<<32)
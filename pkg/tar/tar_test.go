@@ -639,3 +639,74 @@ func TestExtractTarOverwrite(t *testing.T) {
 		t.Errorf("unexpected error: %v", err)
 	}
 }
+
+func TestFindFileListDir(t *testing.T) {
+	entries := []*testTarEntry{
+		{header: &tar.Header{Name: "rootfs/", Typeflag: tar.TypeDir}},
+		{header: &tar.Header{Name: "rootfs/etc/", Typeflag: tar.TypeDir}},
+		{
+			contents: "ID=test\n",
+			header:   &tar.Header{Name: "rootfs/etc/os-release", Size: 8},
+		},
+		{header: &tar.Header{Name: "rootfs/etc/ssl/", Typeflag: tar.TypeDir}},
+		{header: &tar.Header{Name: "rootfs/etc/hostname", Typeflag: tar.TypeSymlink, Linkname: "../run/hostname"}},
+	}
+	testTarPath, err := newTestTar(entries)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.Remove(testTarPath)
+	open := func() (*os.File, *tar.Reader) {
+		f, err := os.Open(testTarPath)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return f, tar.NewReader(f)
+	}
+
+	f, tr := open()
+	hdr, err := FindFile(tr, "./rootfs/etc/os-release")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if b, err := ioutil.ReadAll(tr); err != nil || string(b) != "ID=test\n" {
+		t.Errorf("unexpected contents %q, %v", b, err)
+	}
+	if hdr.Size != 8 {
+		t.Errorf("unexpected size %d", hdr.Size)
+	}
+	f.Close()
+
+	f, tr = open()
+	if _, err := FindFile(tr, "rootfs/etc/passwd"); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+	f.Close()
+
+	for dir, want := range map[string][]string{
+		"rootfs/etc":            {"rootfs/etc/hostname", "rootfs/etc/os-release", "rootfs/etc/ssl/"},
+		"rootfs/etc/os-release": {"rootfs/etc/os-release"},
+		"rootfs/etc/ssl":        nil,
+	} {
+		f, tr = open()
+		hdrs, err := ListDir(tr, dir)
+		f.Close()
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", dir, err)
+			continue
+		}
+		var got []string
+		for _, h := range hdrs {
+			got = append(got, h.Name)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("%s: got %v, want %v", dir, got, want)
+		}
+	}
+
+	f, tr = open()
+	if _, err := ListDir(tr, "rootfs/var"); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+	f.Close()
+}
@@ -0,0 +1,149 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package trace records how long the steps of launching a container take,
+// as a tree of spans, so that slow launches can be broken down. A trace is
+// started by stage0 and carried over to stage1 through a file.
+package trace
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sync"
+	"time"
+)
+
+// Names of the spans stage0 leaves open for stage1 to fill in.
+const (
+	// SpanStage1 covers stage1, up to the start of the apps
+	SpanStage1 = "stage1"
+	// SpanExec covers the exec of stage1 by stage0
+	SpanExec = "exec"
+)
+
+// A Span is a timed step, broken down into its children. A nil *Span is
+// valid and records nothing, so callers can thread an optional trace
+// through without checking for it.
+type Span struct {
+	Name  string    `json:"name"`
+	Start time.Time `json:"start"`
+	// Duration is zero until the span is finished
+	Duration time.Duration `json:"duration"`
+	Children []*Span       `json:"children,omitempty"`
+
+	mu sync.Mutex
+}
+
+// New starts the root span of a trace.
+func New(name string) *Span {
+	return &Span{Name: name, Start: time.Now()}
+}
+
+// Child starts a span as the last child of s.
+func (s *Span) Child(name string) *Span {
+	if s == nil {
+		return nil
+	}
+	c := New(name)
+	s.mu.Lock()
+	s.Children = append(s.Children, c)
+	s.mu.Unlock()
+	return c
+}
+
+// Finish records the duration of s. It may be called more than once, the
+// first call counts.
+func (s *Span) Finish() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.Duration == 0 {
+		s.Duration = time.Since(s.Start)
+	}
+}
+
+// Find returns the last child of s with the given name, nil if none.
+func (s *Span) Find(name string) *Span {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := len(s.Children) - 1; i >= 0; i-- {
+		if s.Children[i].Name == name {
+			return s.Children[i]
+		}
+	}
+	return nil
+}
+
+// WriteFile writes the trace rooted at s to path as JSON, durations in
+// nanoseconds. Spans not finished yet have a zero duration.
+func (s *Span) WriteFile(path string) error {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	b, err := json.MarshalIndent(s, "", "\t")
+	s.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("error encoding trace: %v", err)
+	}
+	if err := ioutil.WriteFile(path, append(b, '\n'), 0644); err != nil {
+		return fmt.Errorf("error writing trace: %v", err)
+	}
+	return nil
+}
+
+// ReadFile reads a trace written by WriteFile, to be added to.
+func ReadFile(path string) (*Span, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading trace: %v", err)
+	}
+	s := &Span{}
+	if err := json.Unmarshal(b, s); err != nil {
+		return nil, fmt.Errorf("error decoding trace: %v", err)
+	}
+	return s, nil
+}
+
+type spanKey struct{}
+
+// NewContext returns a copy of ctx carrying s, under which Start adds spans.
+func NewContext(ctx context.Context, s *Span) context.Context {
+	return context.WithValue(ctx, spanKey{}, s)
+}
+
+// FromContext returns the span ctx carries, nil if none.
+func FromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(spanKey{}).(*Span)
+	return s
+}
+
+// Start starts a span as a child of the one ctx carries and returns it with
+// a copy of ctx carrying it. If ctx carries none, ctx is returned as is
+// with a nil span.
+func Start(ctx context.Context, name string) (context.Context, *Span) {
+	p := FromContext(ctx)
+	if p == nil {
+		return ctx, nil
+	}
+	s := p.Child(name)
+	return NewContext(ctx, s), s
+}
@@ -0,0 +1,85 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSpans(t *testing.T) {
+	root := New("run")
+	ctx := NewContext(context.Background(), root)
+	fctx, fetch := Start(ctx, "fetch")
+	_, verify := Start(fctx, "verify")
+	verify.Finish()
+	fetch.Finish()
+	root.Child(SpanStage1).Child(SpanExec)
+
+	if fetch.Duration == 0 || verify.Duration == 0 {
+		t.Errorf("finished spans have no duration")
+	}
+	if root.Find("fetch") != fetch || fetch.Find("verify") != verify {
+		t.Errorf("spans not found under their parents")
+	}
+	if root.Find("verify") != nil {
+		t.Errorf("Find returned a grandchild")
+	}
+
+	dir, err := ioutil.TempDir("", "trace")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "trace.json")
+	if err := root.WriteFile(path); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond)
+
+	read, err := ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := read.Find("fetch").Duration; d != fetch.Duration {
+		t.Errorf("read duration %v, want %v", d, fetch.Duration)
+	}
+	exec := read.Find(SpanStage1).Find(SpanExec)
+	if exec == nil || exec.Duration != 0 {
+		t.Fatalf("open span not read back open: %+v", exec)
+	}
+	exec.Finish()
+	if exec.Duration < time.Millisecond {
+		t.Errorf("span finished after being read back lasted %v, want at least 1ms", exec.Duration)
+	}
+}
+
+func TestNilSpan(t *testing.T) {
+	ctx, s := Start(context.Background(), "fetch")
+	if s != nil || FromContext(ctx) != nil {
+		t.Fatalf("span started without a trace")
+	}
+	s.Child("verify").Finish()
+	if s.Find("verify") != nil {
+		t.Errorf("nil span has children")
+	}
+	if err := s.WriteFile("/nonexistent/trace.json"); err != nil {
+		t.Errorf("nil span written: %v", err)
+	}
+}
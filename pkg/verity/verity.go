@@ -0,0 +1,101 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package verity packs directories into dm-verity protected filesystem
+// images and sets them up as block devices, so that any modification of the
+// image is detected when it is read. It drives the mksquashfs and veritysetup
+// tools, which must be installed on the host.
+package verity
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+const mapperDir = "/dev/mapper"
+
+// Format packs dir into a read-only squashfs image at dataPath and builds
+// its dm-verity hash tree at hashPath. It returns the root hash, which has
+// to be kept somewhere trustworthy: it is what the image is checked against.
+func Format(dir, dataPath, hashPath string) (string, error) {
+	if out, err := exec.Command("mksquashfs", dir, dataPath, "-noappend", "-no-progress").CombinedOutput(); err != nil {
+		return "", fmt.Errorf("error creating filesystem image: %v: %s", err, out)
+	}
+	out, err := exec.Command("veritysetup", "format", dataPath, hashPath).CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("error creating hash tree: %v: %s", err, out)
+	}
+	return parseRootHash(out)
+}
+
+// parseRootHash extracts the root hash from the output of veritysetup format
+func parseRootHash(out []byte) (string, error) {
+	s := bufio.NewScanner(bytes.NewReader(out))
+	for s.Scan() {
+		parts := strings.SplitN(s.Text(), ":", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) != "Root hash" {
+			continue
+		}
+		h := strings.TrimSpace(parts[1])
+		if _, err := hex.DecodeString(h); err != nil || h == "" {
+			return "", fmt.Errorf("malformed root hash %q", h)
+		}
+		return h, nil
+	}
+	return "", errors.New("no root hash in veritysetup output")
+}
+
+// Open sets up the device mapper device name reading the image at dataPath
+// through dm-verity, with the hash tree at hashPath checked against rootHash.
+// It returns the path of the device.
+func Open(name, dataPath, hashPath, rootHash string) (string, error) {
+	if out, err := exec.Command("veritysetup", "open", dataPath, name, hashPath, rootHash).CombinedOutput(); err != nil {
+		return "", fmt.Errorf("error opening verity device %s: %v: %s", name, err, out)
+	}
+	return filepath.Join(mapperDir, name), nil
+}
+
+// Close removes the device mapper device name.
+func Close(name string) error {
+	if out, err := exec.Command("veritysetup", "close", name).CombinedOutput(); err != nil {
+		return fmt.Errorf("error closing verity device %s: %v: %s", name, err, out)
+	}
+	return nil
+}
+
+// CloseAll removes all device mapper devices whose name starts with prefix.
+func CloseAll(prefix string) error {
+	devs, err := filepath.Glob(filepath.Join(mapperDir, prefix+"*"))
+	if err != nil {
+		return err
+	}
+	for _, dev := range devs {
+		if err := Close(filepath.Base(dev)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DevicePrefix returns the prefix of the names of the devices set up for the
+// container with the given UUID.
+func DevicePrefix(containerUUID string) string {
+	return "rkt-" + containerUUID + "-"
+}
@@ -0,0 +1,60 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package verity
+
+import "testing"
+
+func TestParseRootHash(t *testing.T) {
+	tests := []struct {
+		out string
+
+		hash string
+		err  bool
+	}{
+		{
+			`VERITY header information for rootfs.verity
+UUID:            	6c2a8b8f-2d62-4b8d-9a3e-3f0e7c6b4a1d
+Hash type:       	1
+Data blocks:     	512
+Data block size: 	4096
+Hash block size: 	4096
+Hash algorithm:  	sha256
+Salt:            	3a2f4e1c9b7d6a5f
+Root hash:      	9e7fa1c1b09a5fbd3b1d0a0b8c7e1f4d2a6b5c3d9e8f7a6b5c4d3e2f1a0b9c8d
+`,
+			"9e7fa1c1b09a5fbd3b1d0a0b8c7e1f4d2a6b5c3d9e8f7a6b5c4d3e2f1a0b9c8d",
+			false,
+		},
+		{
+			"Root hash: not-hex\n",
+			"",
+			true,
+		},
+		{
+			"VERITY header information for rootfs.verity\n",
+			"",
+			true,
+		},
+	}
+	for i, tt := range tests {
+		h, err := parseRootHash([]byte(tt.out))
+		if (err != nil) != tt.err {
+			t.Errorf("#%d: got err %v, want err %v", i, err, tt.err)
+		}
+		if h != tt.hash {
+			t.Errorf("#%d: got %q, want %q", i, h, tt.hash)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package watchdog aborts operations which take too long, e.g. container
+// setup wedged on a dead NFS mount, reporting what they were doing at the
+// time.
+package watchdog
+
+import (
+	"fmt"
+	"io"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// Phases of container setup, in the order they occur.
+const (
+	PhaseFetch   = "fetch"
+	PhaseExtract = "extract"
+	PhaseRender  = "render"
+	PhaseNetwork = "network"
+)
+
+// TimeoutError is handed to the abort function when the watchdog fires.
+type TimeoutError struct {
+	Phase   string
+	Timeout time.Duration
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("setup timed out after %v in %s phase", e.Timeout, e.Phase)
+}
+
+// A Watchdog fires unless stopped before its timeout expires.
+// A nil *Watchdog is valid and does nothing, so callers can thread an
+// optional watchdog through without checking for it.
+type Watchdog struct {
+	timeout  time.Duration
+	deadline time.Time
+	out      io.Writer
+	abort    func(error)
+	timer    *time.Timer
+
+	mu    sync.Mutex
+	phase string
+}
+
+// Start arms a watchdog starting in phase. If it is not stopped within
+// timeout, the current phase and the stacks of all goroutines are written
+// to out and abort is called with a *TimeoutError.
+// abort runs on the watchdog's goroutine while the operation is still
+// wedged, so it will usually report the error and exit the process.
+// A timeout of zero or less disables the watchdog and Start returns nil.
+func Start(timeout time.Duration, phase string, out io.Writer, abort func(error)) *Watchdog {
+	if timeout <= 0 {
+		return nil
+	}
+	w := &Watchdog{
+		timeout:  timeout,
+		deadline: time.Now().Add(timeout),
+		out:      out,
+		abort:    abort,
+		phase:    phase,
+	}
+	w.timer = time.AfterFunc(timeout, w.fire)
+	return w
+}
+
+func (w *Watchdog) fire() {
+	phase := w.Phase()
+	fmt.Fprintf(w.out, "watchdog: setup did not complete within %v, stuck in %s phase\n", w.timeout, phase)
+	fmt.Fprintf(w.out, "watchdog: goroutine stacks:\n%s\n", stacks())
+	w.abort(&TimeoutError{Phase: phase, Timeout: w.timeout})
+}
+
+// stacks returns the stack traces of all goroutines.
+func stacks() []byte {
+	buf := make([]byte, 64*1024)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			return buf[:n]
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+}
+
+// SetPhase records that the watched operation entered phase.
+func (w *Watchdog) SetPhase(phase string) {
+	if w == nil {
+		return
+	}
+	w.mu.Lock()
+	w.phase = phase
+	w.mu.Unlock()
+}
+
+// Phase returns the phase the watched operation is in.
+func (w *Watchdog) Phase() string {
+	if w == nil {
+		return ""
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.phase
+}
+
+// Remaining returns the time left before the watchdog fires, it is zero
+// for a nil watchdog.
+func (w *Watchdog) Remaining() time.Duration {
+	if w == nil {
+		return 0
+	}
+	return w.deadline.Sub(time.Now())
+}
+
+// Stop disarms the watchdog. It reports whether the watchdog was stopped
+// before firing.
+func (w *Watchdog) Stop() bool {
+	if w == nil {
+		return true
+	}
+	return w.timer.Stop()
+}
@@ -0,0 +1,80 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package watchdog
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWatchdogFires(t *testing.T) {
+	var out bytes.Buffer
+	aborted := make(chan error, 1)
+	w := Start(10*time.Millisecond, PhaseFetch, &out, func(err error) {
+		aborted <- err
+	})
+	w.SetPhase(PhaseExtract)
+
+	select {
+	case err := <-aborted:
+		te, ok := err.(*TimeoutError)
+		if !ok {
+			t.Fatalf("expected *TimeoutError, got %v", err)
+		}
+		if te.Phase != PhaseExtract {
+			t.Errorf("got phase %q, want %q", te.Phase, PhaseExtract)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("watchdog did not fire")
+	}
+
+	o := out.String()
+	if !strings.Contains(o, "stuck in extract phase") {
+		t.Errorf("phase missing from output:\n%s", o)
+	}
+	if !strings.Contains(o, "TestWatchdogFires") {
+		t.Errorf("goroutine stacks missing from output:\n%s", o)
+	}
+}
+
+func TestWatchdogStop(t *testing.T) {
+	w := Start(50*time.Millisecond, PhaseRender, &bytes.Buffer{}, func(err error) {
+		t.Errorf("unexpected abort: %v", err)
+	})
+	if !w.Stop() {
+		t.Fatalf("expected watchdog to be stopped before firing")
+	}
+	time.Sleep(100 * time.Millisecond)
+}
+
+func TestWatchdogDisabled(t *testing.T) {
+	w := Start(0, PhaseFetch, &bytes.Buffer{}, nil)
+	if w != nil {
+		t.Fatalf("expected nil watchdog for zero timeout")
+	}
+	// all methods must be safe to call on the disabled watchdog
+	w.SetPhase(PhaseRender)
+	if p := w.Phase(); p != "" {
+		t.Errorf("got phase %q from disabled watchdog", p)
+	}
+	if r := w.Remaining(); r != 0 {
+		t.Errorf("got remaining %v from disabled watchdog", r)
+	}
+	if !w.Stop() {
+		t.Errorf("expected Stop on disabled watchdog to succeed")
+	}
+}
@@ -0,0 +1,183 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package zfs keeps rendered images in ZFS datasets and gives containers
+// writable clones of their snapshots, which share all unmodified blocks.
+// It drives the zfs tool, which must be installed on the host.
+package zfs
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// mount is an entry of /proc/self/mountinfo
+type mount struct {
+	point  string
+	fstype string
+	source string
+}
+
+var mountPointUnescaper = strings.NewReplacer(`\040`, " ", `\011`, "\t", `\012`, "\n", `\134`, `\`)
+
+// parseMountinfo reads the mounts listed in the mountinfo read from r
+func parseMountinfo(r io.Reader) ([]mount, error) {
+	var mounts []mount
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		// 36 35 98:0 /mnt1 /mnt2 rw,noatime master:1 - zfs tank/rkt rw
+		fields := strings.Fields(s.Text())
+		if len(fields) < 5 {
+			continue
+		}
+		for i, f := range fields {
+			if f == "-" && i+2 < len(fields) {
+				mounts = append(mounts, mount{
+					point:  mountPointUnescaper.Replace(fields[4]),
+					fstype: fields[i+1],
+					source: fields[i+2],
+				})
+				break
+			}
+		}
+	}
+	return mounts, s.Err()
+}
+
+func readMounts() ([]mount, error) {
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseMountinfo(f)
+}
+
+// below reports whether path is dir or a path beneath it
+func below(path, dir string) bool {
+	return path == dir || dir == "/" || strings.HasPrefix(path, dir+"/")
+}
+
+// datasetOf returns the dataset holding path among mounts, i.e. the source
+// of the innermost mount above it, which must be a ZFS mount
+func datasetOf(mounts []mount, path string) (string, error) {
+	var m *mount
+	for i := range mounts {
+		if below(path, mounts[i].point) && (m == nil || len(mounts[i].point) >= len(m.point)) {
+			m = &mounts[i]
+		}
+	}
+	if m == nil || m.fstype != "zfs" {
+		return "", fmt.Errorf("%s is not on a ZFS dataset", path)
+	}
+	return m.source, nil
+}
+
+// DatasetOf returns the name of the ZFS dataset holding path, failing if
+// path is on another kind of filesystem.
+func DatasetOf(path string) (string, error) {
+	path, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", err
+	}
+	path, err = filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	mounts, err := readMounts()
+	if err != nil {
+		return "", err
+	}
+	return datasetOf(mounts, path)
+}
+
+// Exists reports whether the dataset or snapshot name exists.
+func Exists(name string) bool {
+	return exec.Command("zfs", "list", "-H", "-o", "name", name).Run() == nil
+}
+
+// Create creates the filesystem dataset name mounted at mountpoint.
+func Create(name, mountpoint string) error {
+	if out, err := exec.Command("zfs", "create", "-o", "mountpoint="+mountpoint, name).CombinedOutput(); err != nil {
+		return fmt.Errorf("error creating dataset %s: %v: %s", name, err, out)
+	}
+	return nil
+}
+
+// Snapshot takes the snapshot snap, given as dataset@name.
+func Snapshot(snap string) error {
+	if out, err := exec.Command("zfs", "snapshot", snap).CombinedOutput(); err != nil {
+		return fmt.Errorf("error taking snapshot %s: %v: %s", snap, err, out)
+	}
+	return nil
+}
+
+// Clone creates the dataset name, mounted at mountpoint, as a writable
+// clone of the snapshot snap.
+func Clone(snap, name, mountpoint string) error {
+	if out, err := exec.Command("zfs", "clone", "-o", "mountpoint="+mountpoint, snap, name).CombinedOutput(); err != nil {
+		return fmt.Errorf("error cloning %s: %v: %s", snap, err, out)
+	}
+	return nil
+}
+
+// Destroy unmounts and destroys the dataset name with its snapshots. It
+// fails while the snapshots have clones.
+func Destroy(name string) error {
+	if out, err := exec.Command("zfs", "destroy", "-r", name).CombinedOutput(); err != nil {
+		return fmt.Errorf("error destroying dataset %s: %v: %s", name, err, out)
+	}
+	return nil
+}
+
+// Parent returns the name of the dataset holding the dataset or snapshot
+// name.
+func Parent(name string) string {
+	name = strings.SplitN(name, "@", 2)[0]
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		return name[:i]
+	}
+	return name
+}
+
+// DestroyUnder destroys the ZFS datasets mounted beneath dir, e.g. the
+// clones giving a container its app rootfs, deepest first. The zfs tool
+// isn't needed if there are none.
+func DestroyUnder(dir string) error {
+	mounts, err := readMounts()
+	if err != nil {
+		return err
+	}
+	var mps []string
+	datasets := make(map[string]string)
+	for _, m := range mounts {
+		if m.fstype == "zfs" && strings.HasPrefix(m.point, dir+"/") {
+			mps = append(mps, m.point)
+			datasets[m.point] = m.source
+		}
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(mps)))
+	for _, mp := range mps {
+		if err := Destroy(datasets[mp]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package zfs
+
+import (
+	"strings"
+	"testing"
+)
+
+const mountinfo = `17 22 0:16 / /sys rw,nosuid,nodev,noexec,relatime shared:6 - sysfs sysfs rw
+22 1 253:1 / / rw,relatime shared:1 - ext4 /dev/mapper/root rw,data=ordered
+40 22 0:45 / /var/lib/rkt rw,relatime shared:27 - zfs tank/rkt rw,xattr,noacl
+41 40 0:46 / /var/lib/rkt/containers/c1/stage1/rootfs/opt/stage2/sha512-aa rw,relatime shared:28 - zfs tank/rkt/rkt-pod-c1-sha512-aa rw,xattr,noacl
+42 22 0:47 / /srv/my\040data rw,relatime shared:29 - zfs tank/data rw,xattr,noacl
+`
+
+func TestParseMountinfo(t *testing.T) {
+	mounts, err := parseMountinfo(strings.NewReader(mountinfo))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(mounts) != 5 {
+		t.Fatalf("got %d mounts, want 5", len(mounts))
+	}
+	want := mount{point: "/srv/my data", fstype: "zfs", source: "tank/data"}
+	if mounts[4] != want {
+		t.Errorf("got %+v, want %+v", mounts[4], want)
+	}
+}
+
+func TestDatasetOf(t *testing.T) {
+	mounts, err := parseMountinfo(strings.NewReader(mountinfo))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	tests := []struct {
+		path string
+		want string
+		err  bool
+	}{
+		{"/var/lib/rkt", "tank/rkt", false},
+		{"/var/lib/rkt/cas/zfstree", "tank/rkt", false},
+		{"/var/lib/rkt/containers/c1/stage1/rootfs/opt/stage2/sha512-aa/rootfs", "tank/rkt/rkt-pod-c1-sha512-aa", false},
+		{"/srv/my data/x", "tank/data", false},
+		// not on ZFS
+		{"/var/lib/rktx", "", true},
+		{"/sys/fs", "", true},
+	}
+	for _, tt := range tests {
+		got, err := datasetOf(mounts, tt.path)
+		if tt.err {
+			if err == nil {
+				t.Errorf("%s: expected an error", tt.path)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.path, err)
+		} else if got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestParent(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"tank/rkt/rkt-tree-sha512-aa@rendered", "tank/rkt"},
+		{"tank/rkt/rkt-tree-sha512-aa", "tank/rkt"},
+		{"tank", "tank"},
+	}
+	for _, tt := range tests {
+		if got := Parent(tt.name); got != tt.want {
+			t.Errorf("Parent(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+
+	"github.com/appc/spec/schema/types"
+)
+
+// defaultAnnotationMaxSize caps the size of an annotation file, annotations
+// end up in the container manifest and the metadata service so they should
+// stay small.
+const defaultAnnotationMaxSize = 64 * 1024
+
+// loadAnnotations reads container annotations from the JSON file at path,
+// which holds an object mapping annotation names to values, e.g.
+//
+//	{"example.com/owner": "team-a", "example.com/zone": "us-east-1b"}
+//
+// Files larger than maxSize bytes are rejected before being parsed. Names
+// must be valid AC names. The annotations are returned sorted by name.
+func loadAnnotations(path string, maxSize int64) (types.Annotations, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if fi.Size() > maxSize {
+		return nil, fmt.Errorf("annotation file %s is %d bytes, exceeding the limit of %d bytes", path, fi.Size(), maxSize)
+	}
+	// don't trust the size if the file is being written to or isn't
+	// a regular file
+	b, err := ioutil.ReadAll(io.LimitReader(f, maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(b)) > maxSize {
+		return nil, fmt.Errorf("annotation file %s exceeds the limit of %d bytes", path, maxSize)
+	}
+
+	var m map[string]string
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, fmt.Errorf("error parsing annotation file %s: %v", path, err)
+	}
+
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var annotations types.Annotations
+	for _, name := range names {
+		acname, err := types.NewACName(name)
+		if err != nil {
+			return nil, fmt.Errorf("invalid annotation name %q in %s: %v", name, path, err)
+		}
+		annotations = append(annotations, types.Annotation{Name: *acname, Value: m[name]})
+	}
+	return annotations, nil
+}
@@ -0,0 +1,92 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/appc/spec/schema/types"
+)
+
+func TestLoadAnnotations(t *testing.T) {
+	dir, err := ioutil.TempDir("", "annotations")
+	if err != nil {
+		t.Fatalf("error creating tempdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		contents string
+		maxSize  int64
+
+		want types.Annotations
+		err  bool
+	}{
+		{
+			`{"example.com/zone": "us-east-1b", "example.com/owner": "team-a"}`,
+			defaultAnnotationMaxSize,
+			types.Annotations{
+				{Name: "example.com/owner", Value: "team-a"},
+				{Name: "example.com/zone", Value: "us-east-1b"},
+			},
+			false,
+		},
+		{
+			`{}`,
+			defaultAnnotationMaxSize,
+			nil,
+			false,
+		},
+		// invalid AC name
+		{
+			`{"Example.com/Owner": "team-a"}`,
+			defaultAnnotationMaxSize,
+			nil,
+			true,
+		},
+		// not an object of strings
+		{
+			`{"example.com/owner": {"team": "a"}}`,
+			defaultAnnotationMaxSize,
+			nil,
+			true,
+		},
+		// too big
+		{
+			`{"example.com/owner": "team-a"}`,
+			16,
+			nil,
+			true,
+		},
+	}
+	for i, tt := range tests {
+		path := filepath.Join(dir, "annotations.json")
+		if err := ioutil.WriteFile(path, []byte(tt.contents), 0644); err != nil {
+			t.Fatalf("error writing annotation file: %v", err)
+		}
+		g, err := loadAnnotations(path, tt.maxSize)
+		if (err != nil) != tt.err {
+			t.Errorf("#%d: got err %v, want err %v", i, err, tt.err)
+			continue
+		}
+		if !reflect.DeepEqual(g, tt.want) {
+			t.Errorf("#%d: got %v, want %v", i, g, tt.want)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//+build linux
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/coreos/rocket/api"
+)
+
+const cmdAPIServiceName = "api-service"
+
+var (
+	cmdAPIService = &Command{
+		Name:    cmdAPIServiceName,
+		Summary: "Serve the pods and images of the host to other programs",
+		Usage:   "[--socket=PATH]",
+		Description: `Serves a read-only API listing and inspecting the pods and images rkt
+knows about, and streaming the changes of the states of the pods, for
+monitoring agents and orchestrators to use instead of parsing the output of
+rkt. The API is JSON-RPC 1.0, service "API", on a unix socket only root can
+reach:
+
+	API.ListPods		{"States": ["running"]}
+	API.InspectPod		{"ID": "UUID"}
+	API.ListImages		{"Name": "example.com/app"}
+	API.InspectImage	{"ID": "sha512-..."}
+	API.WatchEvents		{"After": SEQ, "Wait": NANOSECONDS}
+
+WatchEvents returns the events of the journal of rkt events after the
+one numbered After, waiting for some if there are none yet, up to Wait.`,
+		Run: runAPIService,
+	}
+	flagAPIServiceSocket string
+)
+
+func init() {
+	commands = append(commands, cmdAPIService)
+	cmdAPIService.Flags.StringVar(&flagAPIServiceSocket, "socket", api.SocketPath, "unix socket to serve the API on")
+}
+
+func runAPIService(args []string) (exit int) {
+	if len(args) != 0 {
+		printCommandUsageByName(cmdAPIServiceName)
+		return 1
+	}
+
+	ds, err := getStore()
+	if err != nil {
+		fmt.Fprintf(stderr, "Unable to open the store: %v\n", err)
+		return 1
+	}
+
+	if err := os.MkdirAll(filepath.Dir(flagAPIServiceSocket), 0755); err != nil {
+		fmt.Fprintf(stderr, "Unable to create the API socket: %v\n", err)
+		return 1
+	}
+	// left behind by a service which didn't exit cleanly
+	if err := os.Remove(flagAPIServiceSocket); err != nil && !os.IsNotExist(err) {
+		fmt.Fprintf(stderr, "Unable to create the API socket: %v\n", err)
+		return 1
+	}
+	// the pods and images of the host aren't for everyone to see
+	l, err := listenPrivate(flagAPIServiceSocket)
+	if err != nil {
+		fmt.Fprintf(stderr, "Unable to create the API socket: %v\n", err)
+		return 1
+	}
+	defer l.Close()
+
+	ctx, stop := interruptContext()
+	defer stop()
+	errc := make(chan error, 1)
+	go func() { errc <- api.New(globalFlags.Dir, ds).Serve(l) }()
+	select {
+	case err := <-errc:
+		fmt.Fprintf(stderr, "Unable to serve the API: %v\n", err)
+		return 1
+	case <-ctx.Done():
+	}
+	return 0
+}
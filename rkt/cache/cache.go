@@ -0,0 +1,245 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package cache keeps what rkt fetches besides images, discovery results
+// and public keys, in the cache directory of unprivileged users, so that
+// running rkt on a developer's machine doesn't repeat the same requests on
+// every invocation. Root uses no such cache.
+package cache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/coreos/rocket/cas"
+
+	"github.com/appc/spec/discovery"
+)
+
+// DiscoveryMaxAge is how long discovery results are reused. Discovery
+// responses carry no validators rkt gets to see, so unlike other cached
+// responses they are never revalidated, only discovered again.
+const DiscoveryMaxAge = time.Hour
+
+// discoverEndpoints is replaced in tests
+var discoverEndpoints = discovery.DiscoverEndpoints
+
+// Cache is a directory holding cached discovery results and HTTP
+// responses. A nil *Cache caches nothing.
+type Cache struct {
+	dir string
+}
+
+// UserDir returns the cache directory of the invoking user, following the
+// XDG base directory specification: $XDG_CACHE_HOME/rkt, ~/.cache/rkt by
+// default. It returns "" for root, or if the home directory is unknown.
+func UserDir() string {
+	if os.Geteuid() == 0 {
+		return ""
+	}
+	// relative paths are invalid and must be ignored
+	if d := os.Getenv("XDG_CACHE_HOME"); filepath.IsAbs(d) {
+		return filepath.Join(d, "rkt")
+	}
+	home := os.Getenv("HOME")
+	if home == "" {
+		return ""
+	}
+	return filepath.Join(home, ".cache", "rkt")
+}
+
+// New returns a Cache in dir, created when first written to. It returns
+// nil if dir is "".
+func New(dir string) *Cache {
+	if dir == "" {
+		return nil
+	}
+	return &Cache{dir: dir}
+}
+
+// path returns the path of the entry of kind identified by key
+func (c *Cache) path(kind, key string) string {
+	return filepath.Join(c.dir, kind, fmt.Sprintf("%x", sha256.Sum256([]byte(key))))
+}
+
+// write atomically replaces the file at path with b
+func write(path string, b []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(path), ".tmp-")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(b)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+// discoveryEntry is a cached discovery result
+type discoveryEntry struct {
+	App       string
+	Time      time.Time
+	Endpoints discovery.Endpoints
+}
+
+// appKey identifies the discovery of app
+func appKey(app discovery.App, insecure bool) string {
+	labels := make([]string, 0, len(app.Labels))
+	for k, v := range app.Labels {
+		labels = append(labels, k+"="+v)
+	}
+	sort.Strings(labels)
+	return fmt.Sprintf("%s,%s,insecure=%t", app.Name, strings.Join(labels, ","), insecure)
+}
+
+// Discover runs meta-discovery for app, as discovery.DiscoverEndpoints
+// does, reusing the result of the same discovery made less than
+// DiscoveryMaxAge ago.
+func (c *Cache) Discover(app discovery.App, insecure bool) (*discovery.Endpoints, error) {
+	if c == nil {
+		return discoverEndpoints(app, insecure)
+	}
+
+	key := appKey(app, insecure)
+	p := c.path("discovery", key)
+	var e discoveryEntry
+	if b, err := ioutil.ReadFile(p); err == nil && json.Unmarshal(b, &e) == nil {
+		if e.App == key && time.Since(e.Time) < DiscoveryMaxAge {
+			return &e.Endpoints, nil
+		}
+	}
+
+	ep, err := discoverEndpoints(app, insecure)
+	if err != nil {
+		return nil, err
+	}
+	// failing to cache only costs a discovery next time
+	if b, err := json.Marshal(discoveryEntry{App: key, Time: time.Now(), Endpoints: *ep}); err == nil {
+		write(p, b)
+	}
+	return ep, nil
+}
+
+// httpEntry holds the cache information of a cached response, its body
+// being stored next to it
+type httpEntry struct {
+	URL          string
+	ETag         string
+	LastModified string
+	MaxAge       int
+	DownloadTime time.Time
+}
+
+// fresh reports whether the response may be used without revalidation, as
+// cas.Remote.Fresh does for images
+func (e httpEntry) fresh(now time.Time) bool {
+	return e.MaxAge > 0 && now.Before(e.DownloadTime.Add(time.Duration(e.MaxAge)*time.Second))
+}
+
+// Get returns the body, of at most maxSize bytes, of url. Responses are
+// cached like images in the store: a cached body is used as is while
+// fresh according to the Cache-Control max-age it was served with, and
+// afterwards only if the server reports it not modified when asked with
+// its ETag or Last-Modified validators.
+func (c *Cache) Get(ctx context.Context, url string, maxSize int64) ([]byte, error) {
+	var e httpEntry
+	var body []byte
+	var p string
+	if c != nil {
+		p = c.path("http", url)
+		if b, err := ioutil.ReadFile(p + ".json"); err == nil && json.Unmarshal(b, &e) == nil && e.URL == url {
+			body, err = ioutil.ReadFile(p)
+			if err != nil {
+				e = httpEntry{}
+			}
+		}
+	}
+	now := time.Now()
+	if body != nil && e.fresh(now) {
+		return body, nil
+	}
+
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		if e.ETag != "" {
+			req.Header.Set("If-None-Match", e.ETag)
+		}
+		if e.LastModified != "" {
+			req.Header.Set("If-Modified-Since", e.LastModified)
+		}
+	}
+	res, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	switch {
+	case res.StatusCode == http.StatusNotModified && body != nil:
+	case res.StatusCode == http.StatusOK:
+		b, err := ioutil.ReadAll(io.LimitReader(res.Body, maxSize+1))
+		if err != nil {
+			return nil, err
+		}
+		if int64(len(b)) > maxSize {
+			return nil, fmt.Errorf("%s exceeds %d bytes", url, maxSize)
+		}
+		body = b
+		e = httpEntry{
+			URL:          url,
+			ETag:         res.Header.Get("ETag"),
+			LastModified: res.Header.Get("Last-Modified"),
+		}
+		if c != nil {
+			// the validators of the old body mustn't be kept with the new
+			os.Remove(p + ".json")
+			if err := write(p, body); err != nil {
+				return body, nil
+			}
+		}
+	default:
+		return nil, fmt.Errorf("bad HTTP status code: %d", res.StatusCode)
+	}
+
+	if c != nil {
+		e.MaxAge = cas.MaxAge(res.Header.Get("Cache-Control"))
+		e.DownloadTime = now
+		if b, err := json.Marshal(e); err == nil {
+			write(p+".json", b)
+		}
+	}
+	return body, nil
+}
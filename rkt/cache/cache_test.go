@@ -0,0 +1,146 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/appc/spec/discovery"
+)
+
+func TestUserDir(t *testing.T) {
+	if os.Geteuid() == 0 {
+		if d := UserDir(); d != "" {
+			t.Errorf("got %q for root, want no cache", d)
+		}
+		return
+	}
+	defer os.Setenv("XDG_CACHE_HOME", os.Getenv("XDG_CACHE_HOME"))
+	defer os.Setenv("HOME", os.Getenv("HOME"))
+	os.Setenv("HOME", "/home/user")
+	os.Setenv("XDG_CACHE_HOME", "/tmp/cache")
+	if d := UserDir(); d != "/tmp/cache/rkt" {
+		t.Errorf("got %q, want /tmp/cache/rkt", d)
+	}
+	os.Setenv("XDG_CACHE_HOME", "relative")
+	if d := UserDir(); d != "/home/user/.cache/rkt" {
+		t.Errorf("got %q, want /home/user/.cache/rkt", d)
+	}
+}
+
+func newTestCache(t *testing.T) (*Cache, func()) {
+	dir, err := ioutil.TempDir("", "cache")
+	if err != nil {
+		t.Fatalf("error creating tempdir: %v", err)
+	}
+	return New(filepath.Join(dir, "rkt")), func() { os.RemoveAll(dir) }
+}
+
+func TestDiscover(t *testing.T) {
+	c, cleanup := newTestCache(t)
+	defer cleanup()
+
+	calls := 0
+	discoverEndpoints = func(app discovery.App, insecure bool) (*discovery.Endpoints, error) {
+		calls++
+		return &discovery.Endpoints{Keys: []string{fmt.Sprintf("https://%s/key-%d.gpg", app.Name, calls)}}, nil
+	}
+	defer func() { discoverEndpoints = discovery.DiscoverEndpoints }()
+
+	app := discovery.App{Name: "example.com/app", Labels: map[string]string{"os": "linux"}}
+	for i := 0; i < 2; i++ {
+		ep, err := c.Discover(app, false)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if ep.Keys[0] != "https://example.com/app/key-1.gpg" {
+			t.Errorf("#%d: got %v, want the first discovery", i, ep.Keys)
+		}
+	}
+	// different labels or insecure discovery are other entries
+	app.Labels["os"] = "freebsd"
+	if _, err := c.Discover(app, false); err != nil || calls != 2 {
+		t.Errorf("got %d discoveries (err %v), want 2", calls, err)
+	}
+	if _, err := c.Discover(app, true); err != nil || calls != 3 {
+		t.Errorf("got %d discoveries (err %v), want 3", calls, err)
+	}
+
+	var nc *Cache
+	nc.Discover(app, true)
+	nc.Discover(app, true)
+	if calls != 5 {
+		t.Errorf("got %d discoveries without cache, want 5", calls)
+	}
+}
+
+func TestGet(t *testing.T) {
+	c, cleanup := newTestCache(t)
+	defer cleanup()
+
+	var requests, notModified int
+	cacheControl := ""
+	key := "key-v1"
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		etag := `"` + key + `"`
+		w.Header().Set("ETag", etag)
+		if cacheControl != "" {
+			w.Header().Set("Cache-Control", cacheControl)
+		}
+		if r.Header.Get("If-None-Match") == etag {
+			notModified++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		fmt.Fprint(w, key)
+	}))
+	defer ts.Close()
+
+	get := func(want string) {
+		b, err := c.Get(context.Background(), ts.URL, 100)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if string(b) != want {
+			t.Errorf("got %q, want %q", b, want)
+		}
+	}
+
+	get("key-v1")
+	get("key-v1")
+	if requests != 2 || notModified != 1 {
+		t.Errorf("got %d requests, %d not modified, want 2 and 1", requests, notModified)
+	}
+
+	key = "key-v2"
+	cacheControl = "max-age=3600"
+	get("key-v2")
+	get("key-v2")
+	if requests != 3 {
+		t.Errorf("got %d requests, want 3: a fresh response is reused", requests)
+	}
+
+	if _, err := c.Get(context.Background(), ts.URL+"/other", 2); err == nil {
+		t.Errorf("expected error for a body over the size limit")
+	}
+}
@@ -0,0 +1,367 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package config implements loading of rkt's configuration directories.
+package config
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// SystemAuthDir holds the auth configuration shipped by the vendor
+	SystemAuthDir = "/usr/lib/rkt/auth.d"
+	// UserAuthDir holds the auth configuration of the local administrator,
+	// it takes precedence over SystemAuthDir
+	UserAuthDir = "/etc/rkt/auth.d"
+
+	authKind    = "auth"
+	authVersion = "v1"
+
+	// helperTimeout bounds the time a credential helper may take
+	helperTimeout = 30 * time.Second
+	// helperDefaultTTL is how long credentials printed by a helper
+	// without an expiry are used
+	helperDefaultTTL = time.Minute
+)
+
+// authFile is the on-disk format of a file in an auth.d directory, e.g.
+//
+//	{
+//		"rktKind": "auth",
+//		"rktVersion": "v1",
+//		"domains": ["example.com", "*.example.org"],
+//		"type": "basic",
+//		"credentials": {"user": "alice", "password": "secret"}
+//	}
+type authFile struct {
+	RktKind     string          `json:"rktKind"`
+	RktVersion  string          `json:"rktVersion"`
+	Domains     []string        `json:"domains"`
+	Type        string          `json:"type"`
+	Credentials json.RawMessage `json:"credentials"`
+}
+
+type basicCredentials struct {
+	User     string `json:"user"`
+	Password string `json:"password"`
+}
+
+type tokenCredentials struct {
+	Token string `json:"token"`
+}
+
+type helperConfig struct {
+	Command []string `json:"command"`
+}
+
+// helperOutput is what a credential helper prints, e.g.
+//
+//	{
+//		"type": "bearer",
+//		"credentials": {"token": "sometoken"},
+//		"expiresAt": "2015-06-01T12:00:00Z"
+//	}
+type helperOutput struct {
+	Type        string          `json:"type"`
+	Credentials json.RawMessage `json:"credentials"`
+	ExpiresAt   *time.Time      `json:"expiresAt"`
+}
+
+type authEntry struct {
+	domains []string
+	// header is the value of the Authorization header
+	header string
+	// basic is set for basic credentials, some consumers (e.g. docker
+	// registries) need the user and password rather than a header
+	basic *basicCredentials
+	// helper, if set, provides the credentials instead
+	helper *credentialHelper
+}
+
+// credentialHelper is a command printing short-lived credentials for the
+// host appended to its arguments, e.g. fetched from the metadata service
+// of a cloud or from a vault, so that no secret is stored on disk.
+// Credentials are kept until they expire.
+type credentialHelper struct {
+	command []string
+
+	mu    sync.Mutex
+	cache map[string]*helperCredentials
+}
+
+type helperCredentials struct {
+	header  string
+	basic   *basicCredentials
+	expires time.Time
+}
+
+// Auth maps hosts to the credentials to use when talking to them.
+type Auth struct {
+	entries []authEntry
+}
+
+// LoadAuth loads the *.json files from the given auth.d directories.
+// Directories are given in order of precedence: when more than one entry
+// matches a host, entries from earlier directories win, and within a
+// directory files are considered in lexical order. Missing directories are
+// ignored.
+func LoadAuth(dirs ...string) (*Auth, error) {
+	a := &Auth{}
+	for _, dir := range dirs {
+		files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+		if err != nil {
+			return nil, err
+		}
+		sort.Strings(files)
+		for _, f := range files {
+			e, err := loadAuthFile(f)
+			if err != nil {
+				return nil, fmt.Errorf("error loading %s: %v", f, err)
+			}
+			a.entries = append(a.entries, *e)
+		}
+	}
+	return a, nil
+}
+
+// DefaultAuth loads the auth configuration from UserAuthDir and
+// SystemAuthDir.
+func DefaultAuth() (*Auth, error) {
+	return LoadAuth(UserAuthDir, SystemAuthDir)
+}
+
+func loadAuthFile(path string) (*authEntry, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var af authFile
+	if err := json.Unmarshal(b, &af); err != nil {
+		return nil, err
+	}
+	if af.RktKind != authKind {
+		return nil, fmt.Errorf("unexpected rktKind %q, want %q", af.RktKind, authKind)
+	}
+	if af.RktVersion != authVersion {
+		return nil, fmt.Errorf("unsupported rktVersion %q", af.RktVersion)
+	}
+	if len(af.Domains) == 0 {
+		return nil, fmt.Errorf("no domains specified")
+	}
+
+	e := &authEntry{
+		domains: af.Domains,
+	}
+	if af.Type == "helper" {
+		var c helperConfig
+		if err := json.Unmarshal(af.Credentials, &c); err != nil {
+			return nil, fmt.Errorf("bad helper credentials: %v", err)
+		}
+		if len(c.Command) == 0 {
+			return nil, fmt.Errorf("helper credentials without command")
+		}
+		e.helper = &credentialHelper{command: c.Command}
+		return e, nil
+	}
+	if e.header, e.basic, err = parseCredentials(af.Type, af.Credentials); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// parseCredentials returns the Authorization header of credentials of the
+// given type, and the basic credentials if they are
+func parseCredentials(typ string, raw json.RawMessage) (string, *basicCredentials, error) {
+	switch typ {
+	case "basic":
+		var c basicCredentials
+		if err := json.Unmarshal(raw, &c); err != nil {
+			return "", nil, fmt.Errorf("bad basic credentials: %v", err)
+		}
+		if c.User == "" {
+			return "", nil, fmt.Errorf("basic credentials without user")
+		}
+		return "Basic " + base64.StdEncoding.EncodeToString([]byte(c.User+":"+c.Password)), &c, nil
+	case "bearer", "oauth":
+		var c tokenCredentials
+		if err := json.Unmarshal(raw, &c); err != nil {
+			return "", nil, fmt.Errorf("bad %s credentials: %v", typ, err)
+		}
+		if c.Token == "" {
+			return "", nil, fmt.Errorf("%s credentials without token", typ)
+		}
+		return "Bearer " + c.Token, nil, nil
+	}
+	return "", nil, fmt.Errorf("unknown auth type %q", typ)
+}
+
+// credentials returns the Authorization header and the basic credentials,
+// if any, of e for host. Helpers failing are logged and give none.
+func (e *authEntry) credentials(host string) (string, *basicCredentials) {
+	if e.helper == nil {
+		return e.header, e.basic
+	}
+	c, err := e.helper.get(host)
+	if err != nil {
+		log.Printf("Error getting credentials for %s from helper %s: %v", host, e.helper.command[0], err)
+		return "", nil
+	}
+	return c.header, c.basic
+}
+
+// get returns the credentials for host, running the helper unless it
+// printed unexpired ones already
+func (h *credentialHelper) get(host string) (*helperCredentials, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if c := h.cache[host]; c != nil && time.Now().Before(c.expires) {
+		return c, nil
+	}
+
+	args := append(append([]string(nil), h.command[1:]...), host)
+	cmd := exec.Command(h.command[0], args...)
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+	select {
+	case err := <-done:
+		if err != nil {
+			return nil, err
+		}
+	case <-time.After(helperTimeout):
+		cmd.Process.Kill()
+		<-done
+		return nil, fmt.Errorf("helper timed out after %v", helperTimeout)
+	}
+
+	var ho helperOutput
+	if err := json.Unmarshal(stdout.Bytes(), &ho); err != nil {
+		return nil, fmt.Errorf("bad helper output: %v", err)
+	}
+	c := &helperCredentials{expires: time.Now().Add(helperDefaultTTL)}
+	if ho.ExpiresAt != nil {
+		c.expires = *ho.ExpiresAt
+	}
+	var err error
+	if c.header, c.basic, err = parseCredentials(ho.Type, ho.Credentials); err != nil {
+		return nil, err
+	}
+	if h.cache == nil {
+		h.cache = make(map[string]*helperCredentials)
+	}
+	h.cache[host] = c
+	return c, nil
+}
+
+// matchDomain reports whether host matches pattern. A pattern of the form
+// "*.example.com" matches any subdomain of example.com, other patterns must
+// match exactly. Ports are ignored.
+func matchDomain(pattern, host string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(host)
+	pattern = strings.ToLower(pattern)
+	if strings.HasPrefix(pattern, "*.") {
+		return strings.HasSuffix(host, pattern[1:])
+	}
+	return host == pattern
+}
+
+func (a *Auth) lookup(host string) *authEntry {
+	if a == nil {
+		return nil
+	}
+	for i, e := range a.entries {
+		for _, d := range e.domains {
+			if matchDomain(d, host) {
+				return &a.entries[i]
+			}
+		}
+	}
+	return nil
+}
+
+// Authenticate adds the Authorization header configured for the request's
+// host, if any. Requests which already carry credentials are left alone.
+func (a *Auth) Authenticate(req *http.Request) {
+	if req.Header.Get("Authorization") != "" {
+		return
+	}
+	if e := a.lookup(req.URL.Host); e != nil {
+		if h, _ := e.credentials(req.URL.Host); h != "" {
+			req.Header.Set("Authorization", h)
+		}
+	}
+}
+
+// BasicCredentials returns the user and password configured for host, ok
+// is false if there are none.
+func (a *Auth) BasicCredentials(host string) (user, password string, ok bool) {
+	e := a.lookup(host)
+	if e == nil {
+		return "", "", false
+	}
+	_, basic := e.credentials(host)
+	if basic == nil {
+		return "", "", false
+	}
+	return basic.User, basic.Password, true
+}
+
+// Transport returns a http.RoundTripper authenticating requests before
+// handing them to base.
+func (a *Auth) Transport(base http.RoundTripper) http.RoundTripper {
+	return &authTransport{auth: a, base: base}
+}
+
+type authTransport struct {
+	auth *Auth
+	base http.RoundTripper
+}
+
+func (t *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.auth.lookup(req.URL.Host) == nil || req.Header.Get("Authorization") != "" {
+		return t.base.RoundTrip(req)
+	}
+	// a RoundTripper must not modify the request it was given
+	r := new(http.Request)
+	*r = *req
+	r.Header = make(http.Header, len(req.Header))
+	for k, v := range req.Header {
+		r.Header[k] = v
+	}
+	t.auth.Authenticate(r)
+	return t.base.RoundTrip(r)
+}
@@ -0,0 +1,179 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatalf("error creating dir: %v", err)
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+			t.Fatalf("error writing %s: %v", name, err)
+		}
+	}
+}
+
+func TestAuth(t *testing.T) {
+	dir, err := ioutil.TempDir("", "auth-test")
+	if err != nil {
+		t.Fatalf("error creating tempdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	user := filepath.Join(dir, "etc")
+	system := filepath.Join(dir, "usr")
+	writeFiles(t, user, map[string]string{
+		"10-aci.json": `{"rktKind": "auth", "rktVersion": "v1", "domains": ["aci.example.com"],
+			"type": "basic", "credentials": {"user": "alice", "password": "secret"}}`,
+		"ignored.txt": `not json`,
+	})
+	writeFiles(t, system, map[string]string{
+		"10-aci.json": `{"rktKind": "auth", "rktVersion": "v1", "domains": ["aci.example.com"],
+			"type": "basic", "credentials": {"user": "vendor", "password": "vendor"}}`,
+		"20-org.json": `{"rktKind": "auth", "rktVersion": "v1", "domains": ["*.example.org"],
+			"type": "oauth", "credentials": {"token": "sometoken"}}`,
+	})
+
+	a, err := LoadAuth(user, system, filepath.Join(dir, "missing"))
+	if err != nil {
+		t.Fatalf("unexpected error loading auth: %v", err)
+	}
+
+	tests := []struct {
+		url    string
+		header string
+	}{
+		// user configuration wins over the system one
+		{"https://aci.example.com/app.aci", "Basic YWxpY2U6c2VjcmV0"},
+		{"https://aci.example.com:8443/app.sig", "Basic YWxpY2U6c2VjcmV0"},
+		{"https://images.example.org/app.aci", "Bearer sometoken"},
+		{"https://example.org/app.aci", ""},
+		{"https://other.com/app.aci", ""},
+	}
+	for i, tt := range tests {
+		req, err := http.NewRequest("GET", tt.url, nil)
+		if err != nil {
+			t.Fatalf("#%d: unexpected error: %v", i, err)
+		}
+		a.Authenticate(req)
+		if g := req.Header.Get("Authorization"); g != tt.header {
+			t.Errorf("#%d: got %q, want %q", i, g, tt.header)
+		}
+	}
+
+	if u, p, ok := a.BasicCredentials("aci.example.com"); !ok || u != "alice" || p != "secret" {
+		t.Errorf("unexpected basic credentials: %q %q %v", u, p, ok)
+	}
+	if _, _, ok := a.BasicCredentials("images.example.org"); ok {
+		t.Errorf("unexpected basic credentials for a token entry")
+	}
+}
+
+func TestAuthHelper(t *testing.T) {
+	dir, err := ioutil.TempDir("", "auth-test")
+	if err != nil {
+		t.Fatalf("error creating tempdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// the helper counts its runs, and gives expired credentials for
+	// hosts of example.org so they are asked for again
+	helper := filepath.Join(dir, "helper")
+	runs := filepath.Join(dir, "runs")
+	script := `#!/bin/sh
+echo "$2" >>` + runs + `
+case "$2" in
+*.example.org) echo '{"type": "bearer", "credentials": {"token": "t0ken"}, "expiresAt": "2015-01-01T00:00:00Z"}' ;;
+*.example.com) echo '{"type": "basic", "credentials": {"user": "'$1'", "password": "secret"}}' ;;
+*) exit 1 ;;
+esac
+`
+	if err := ioutil.WriteFile(helper, []byte(script), 0755); err != nil {
+		t.Fatalf("error writing helper: %v", err)
+	}
+	conf := filepath.Join(dir, "auth.d")
+	writeFiles(t, conf, map[string]string{
+		"10-helper.json": `{"rktKind": "auth", "rktVersion": "v1", "domains": ["*.example.com", "*.example.org", "*.example.net"],
+			"type": "helper", "credentials": {"command": ["` + helper + `", "bob"]}}`,
+	})
+	a, err := LoadAuth(conf)
+	if err != nil {
+		t.Fatalf("unexpected error loading auth: %v", err)
+	}
+
+	for i, tt := range []struct {
+		url    string
+		header string
+	}{
+		{"https://aci.example.com/app.aci", "Basic Ym9iOnNlY3JldA=="},
+		{"https://aci.example.com/app.sig", "Basic Ym9iOnNlY3JldA=="},
+		{"https://aci.example.org/app.aci", "Bearer t0ken"},
+		{"https://aci.example.org/app.sig", "Bearer t0ken"},
+		{"https://aci.example.net/app.aci", ""},
+	} {
+		req, err := http.NewRequest("GET", tt.url, nil)
+		if err != nil {
+			t.Fatalf("#%d: unexpected error: %v", i, err)
+		}
+		a.Authenticate(req)
+		if g := req.Header.Get("Authorization"); g != tt.header {
+			t.Errorf("#%d: got %q, want %q", i, g, tt.header)
+		}
+	}
+	if u, p, ok := a.BasicCredentials("aci.example.com"); !ok || u != "bob" || p != "secret" {
+		t.Errorf("unexpected basic credentials: %q %q %v", u, p, ok)
+	}
+
+	b, err := ioutil.ReadFile(runs)
+	if err != nil {
+		t.Fatalf("error reading helper runs: %v", err)
+	}
+	want := "aci.example.com\naci.example.org\naci.example.org\naci.example.net\n"
+	if string(b) != want {
+		t.Errorf("helper ran for %q, want %q", strings.Split(string(b), "\n"), strings.Split(want, "\n"))
+	}
+}
+
+func TestLoadAuthErrors(t *testing.T) {
+	tests := []string{
+		`{"rktKind": "foo", "rktVersion": "v1", "domains": ["a.com"], "type": "basic", "credentials": {"user": "u"}}`,
+		`{"rktKind": "auth", "rktVersion": "v2", "domains": ["a.com"], "type": "basic", "credentials": {"user": "u"}}`,
+		`{"rktKind": "auth", "rktVersion": "v1", "type": "basic", "credentials": {"user": "u"}}`,
+		`{"rktKind": "auth", "rktVersion": "v1", "domains": ["a.com"], "type": "digest", "credentials": {}}`,
+		`{"rktKind": "auth", "rktVersion": "v1", "domains": ["a.com"], "type": "bearer", "credentials": {}}`,
+		`{"rktKind": "auth", "rktVersion": "v1", "domains": ["a.com"], "type": "helper", "credentials": {"command": []}}`,
+		`{"rktKind": "auth"`,
+	}
+	for i, tt := range tests {
+		dir, err := ioutil.TempDir("", "auth-test")
+		if err != nil {
+			t.Fatalf("error creating tempdir: %v", err)
+		}
+		writeFiles(t, dir, map[string]string{"bad.json": tt})
+		if _, err := LoadAuth(dir); err == nil {
+			t.Errorf("#%d: expected an error", i)
+		}
+		os.RemoveAll(dir)
+	}
+}
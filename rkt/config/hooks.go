@@ -0,0 +1,174 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	// SystemHooksDir holds the image hooks shipped by the vendor
+	SystemHooksDir = "/usr/lib/rkt/hooks.d"
+	// UserHooksDir holds the image hooks of the local administrator. A
+	// file replaces the file of the same name in SystemHooksDir.
+	UserHooksDir = "/etc/rkt/hooks.d"
+
+	imageHookKind    = "imageHook"
+	imageHookVersion = "v1"
+)
+
+// imageHookFile is the on-disk format of a file in a hooks.d directory, e.g.
+//
+//	{
+//		"rktKind": "imageHook",
+//		"rktVersion": "v1",
+//		"prefixes": ["example.com/", "coreos.com/etcd"],
+//		"command": ["/usr/libexec/scan-image", "--policy=strict"]
+//	}
+type imageHookFile struct {
+	RktKind    string   `json:"rktKind"`
+	RktVersion string   `json:"rktVersion"`
+	Prefixes   []string `json:"prefixes"`
+	Command    []string `json:"command"`
+}
+
+// ImageHook is a command checking images before they are run, e.g. a
+// vulnerability scanner. It is invoked with the image ID and the path of
+// its rendered rootfs appended to its arguments, and must exit with status
+// 0 for the image to be run.
+type ImageHook struct {
+	// Path is the file the hook was loaded from
+	Path string
+	// Prefixes restricts the hook to the images whose name starts with
+	// one of them, it applies to all images if empty
+	Prefixes []string
+	Command  []string
+}
+
+// ImageHooks are the hooks images are checked with.
+type ImageHooks struct {
+	hooks []ImageHook
+}
+
+// LoadImageHooks loads the *.json files from the given hooks.d
+// directories, given in order of precedence: a file replaces the files of
+// the same name in later directories. Hooks run in the lexical order of
+// their file names. Missing directories are ignored.
+func LoadImageHooks(dirs ...string) (*ImageHooks, error) {
+	byName := make(map[string]string)
+	for i := len(dirs) - 1; i >= 0; i-- {
+		files, err := filepath.Glob(filepath.Join(dirs[i], "*.json"))
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range files {
+			byName[filepath.Base(f)] = f
+		}
+	}
+	names := make([]string, 0, len(byName))
+	for n := range byName {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	h := &ImageHooks{}
+	for _, n := range names {
+		hook, err := loadImageHookFile(byName[n])
+		if err != nil {
+			return nil, fmt.Errorf("error loading %s: %v", byName[n], err)
+		}
+		h.hooks = append(h.hooks, *hook)
+	}
+	return h, nil
+}
+
+// DefaultImageHooks loads the image hooks from UserHooksDir and
+// SystemHooksDir.
+func DefaultImageHooks() (*ImageHooks, error) {
+	return LoadImageHooks(UserHooksDir, SystemHooksDir)
+}
+
+func loadImageHookFile(path string) (*ImageHook, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var hf imageHookFile
+	if err := json.Unmarshal(b, &hf); err != nil {
+		return nil, err
+	}
+	if hf.RktKind != imageHookKind {
+		return nil, fmt.Errorf("unexpected rktKind %q, want %q", hf.RktKind, imageHookKind)
+	}
+	if hf.RktVersion != imageHookVersion {
+		return nil, fmt.Errorf("unsupported rktVersion %q", hf.RktVersion)
+	}
+	if len(hf.Command) == 0 {
+		return nil, fmt.Errorf("no command specified")
+	}
+	return &ImageHook{
+		Path:     path,
+		Prefixes: hf.Prefixes,
+		Command:  hf.Command,
+	}, nil
+}
+
+// Empty reports whether there are no hooks at all.
+func (h *ImageHooks) Empty() bool {
+	return h == nil || len(h.hooks) == 0
+}
+
+// Matches reports whether the hook applies to the image named name.
+func (hook *ImageHook) Matches(name string) bool {
+	if len(hook.Prefixes) == 0 {
+		return true
+	}
+	for _, p := range hook.Prefixes {
+		if strings.HasPrefix(name, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// Check runs the hooks applying to the image named name, with the given ID
+// and rendered in rootfs, in turn. It returns an error telling which hook
+// refused the image if one of them fails. The output of the hooks goes to
+// stderr.
+func (h *ImageHooks) Check(name, id, rootfs string) error {
+	if h == nil {
+		return nil
+	}
+	for _, hook := range h.hooks {
+		if !hook.Matches(name) {
+			continue
+		}
+		args := append(append([]string(nil), hook.Command[1:]...), id, rootfs)
+		cmd := exec.Command(hook.Command[0], args...)
+		cmd.Stdout = os.Stderr
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("launch refused: image %s (%s) didn't pass the check of hook %s: %v", name, id, hook.Path, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestImageHooks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hooks-test")
+	if err != nil {
+		t.Fatalf("error creating tempdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	user := filepath.Join(dir, "etc")
+	system := filepath.Join(dir, "usr")
+	writeFiles(t, user, map[string]string{
+		// replaces the vendor hook refusing everything
+		"10-scan.json": `{"rktKind": "imageHook", "rktVersion": "v1",
+			"command": ["/bin/sh", "-c", "test \"$1\" != sha512-bad && test -d \"$2\"", "scan"]}`,
+	})
+	writeFiles(t, system, map[string]string{
+		"10-scan.json": `{"rktKind": "imageHook", "rktVersion": "v1", "command": ["/bin/false"]}`,
+		"20-org.json": `{"rktKind": "imageHook", "rktVersion": "v1", "prefixes": ["example.org/"],
+			"command": ["/bin/false"]}`,
+	})
+
+	h, err := LoadImageHooks(user, system, filepath.Join(dir, "missing"))
+	if err != nil {
+		t.Fatalf("unexpected error loading hooks: %v", err)
+	}
+	if h.Empty() {
+		t.Fatalf("no hooks loaded")
+	}
+
+	tests := []struct {
+		name string
+		id   string
+		ok   bool
+	}{
+		{"example.com/app", "sha512-good", true},
+		{"example.com/app", "sha512-bad", false},
+		{"example.org/app", "sha512-good", false},
+	}
+	for i, tt := range tests {
+		err := h.Check(tt.name, tt.id, dir)
+		if tt.ok && err != nil {
+			t.Errorf("#%d: unexpected error: %v", i, err)
+		} else if !tt.ok && err == nil {
+			t.Errorf("#%d: expected image to be refused", i)
+		}
+	}
+
+	writeFiles(t, user, map[string]string{
+		"30-bad.json": `{"rktKind": "imageHook", "rktVersion": "v1", "command": []}`,
+	})
+	if _, err := LoadImageHooks(user, system); err == nil {
+		t.Errorf("expected error loading hook without command")
+	}
+
+	if !(&ImageHooks{}).Empty() {
+		t.Errorf("expected no hooks to be empty")
+	}
+}
@@ -0,0 +1,158 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	// SystemMirrorsDir holds the mirror rules shipped by the vendor
+	SystemMirrorsDir = "/usr/lib/rkt/mirrors.d"
+	// UserMirrorsDir holds the mirror rules of the local administrator,
+	// they take precedence over those in SystemMirrorsDir
+	UserMirrorsDir = "/etc/rkt/mirrors.d"
+
+	mirrorsKind    = "mirrors"
+	mirrorsVersion = "v1"
+)
+
+// mirrorsFile is the on-disk format of a file in a mirrors.d directory, e.g.
+//
+//	{
+//		"rktKind": "mirrors",
+//		"rktVersion": "v1",
+//		"rules": [
+//			{"from": "quay.io/*", "to": "mirror.internal/quay/*"},
+//			{"from": "https://example.com/app.aci", "to": "https://mirror.internal/app.aci"}
+//		]
+//	}
+type mirrorsFile struct {
+	RktKind    string       `json:"rktKind"`
+	RktVersion string       `json:"rktVersion"`
+	Rules      []MirrorRule `json:"rules"`
+}
+
+// MirrorRule rewrites the image names and URLs equal to From, or starting
+// with From but for its trailing "*", if it has one. In that case the rest
+// of the name replaces the "*" of To, if any.
+type MirrorRule struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+func (mr *MirrorRule) check() error {
+	if mr.From == "" || mr.To == "" {
+		return fmt.Errorf("rule without from or to")
+	}
+	if i := strings.Index(mr.From, "*"); i >= 0 && i != len(mr.From)-1 {
+		return fmt.Errorf("rule from %q: \"*\" may only end the pattern", mr.From)
+	}
+	if n := strings.Count(mr.To, "*"); n > 1 || n == 1 && !strings.HasSuffix(mr.From, "*") {
+		return fmt.Errorf("rule to %q: \"*\" may only appear once, when the pattern ends with it", mr.To)
+	}
+	return nil
+}
+
+// rewrite returns what name is rewritten to by mr, ok being false if mr
+// doesn't apply to it
+func (mr *MirrorRule) rewrite(name string) (string, bool) {
+	if !strings.HasSuffix(mr.From, "*") {
+		return mr.To, name == mr.From
+	}
+	prefix := strings.TrimSuffix(mr.From, "*")
+	if !strings.HasPrefix(name, prefix) {
+		return "", false
+	}
+	return strings.Replace(mr.To, "*", name[len(prefix):], 1), true
+}
+
+// Mirrors are the rules redirecting image fetches, e.g. to a mirror inside
+// an air-gapped site.
+type Mirrors struct {
+	rules []MirrorRule
+}
+
+// LoadMirrors loads the *.json files from the given mirrors.d directories.
+// Directories are given in order of precedence: the first rule matching a
+// name applies, rules from earlier directories being considered first and,
+// within a directory, files in lexical order. Missing directories are
+// ignored.
+func LoadMirrors(dirs ...string) (*Mirrors, error) {
+	m := &Mirrors{}
+	for _, dir := range dirs {
+		files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+		if err != nil {
+			return nil, err
+		}
+		sort.Strings(files)
+		for _, f := range files {
+			rules, err := loadMirrorsFile(f)
+			if err != nil {
+				return nil, fmt.Errorf("error loading %s: %v", f, err)
+			}
+			m.rules = append(m.rules, rules...)
+		}
+	}
+	return m, nil
+}
+
+// DefaultMirrors loads the mirror rules from UserMirrorsDir and
+// SystemMirrorsDir.
+func DefaultMirrors() (*Mirrors, error) {
+	return LoadMirrors(UserMirrorsDir, SystemMirrorsDir)
+}
+
+func loadMirrorsFile(path string) ([]MirrorRule, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var mf mirrorsFile
+	if err := json.Unmarshal(b, &mf); err != nil {
+		return nil, err
+	}
+	if mf.RktKind != mirrorsKind {
+		return nil, fmt.Errorf("unexpected rktKind %q, want %q", mf.RktKind, mirrorsKind)
+	}
+	if mf.RktVersion != mirrorsVersion {
+		return nil, fmt.Errorf("unsupported rktVersion %q", mf.RktVersion)
+	}
+	for i := range mf.Rules {
+		if err := mf.Rules[i].check(); err != nil {
+			return nil, err
+		}
+	}
+	return mf.Rules, nil
+}
+
+// Rewrite returns what the first rule applying to the image name or URL
+// name rewrites it to, ok being false if none does.
+func (m *Mirrors) Rewrite(name string) (string, bool) {
+	if m == nil {
+		return "", false
+	}
+	for i := range m.rules {
+		if to, ok := m.rules[i].rewrite(name); ok {
+			return to, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,93 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMirrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mirrors-test")
+	if err != nil {
+		t.Fatalf("error creating tempdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	user := filepath.Join(dir, "etc")
+	system := filepath.Join(dir, "usr")
+	writeFiles(t, user, map[string]string{
+		"10-quay.json": `{"rktKind": "mirrors", "rktVersion": "v1", "rules": [
+			{"from": "quay.io/coreos/*", "to": "mirror.internal/coreos/*"}]}`,
+	})
+	writeFiles(t, system, map[string]string{
+		"10-vendor.json": `{"rktKind": "mirrors", "rktVersion": "v1", "rules": [
+			{"from": "quay.io/*", "to": "vendor.example.com/quay/*"},
+			{"from": "docker://*", "to": "docker://registry.internal/*"},
+			{"from": "https://example.com/app.aci", "to": "https://mirror.internal/app.aci"},
+			{"from": "example.com/*", "to": "mirror.internal/app"}]}`,
+	})
+	m, err := LoadMirrors(user, system, filepath.Join(dir, "missing"))
+	if err != nil {
+		t.Fatalf("unexpected error loading mirrors: %v", err)
+	}
+
+	for i, tt := range []struct {
+		in, out string
+	}{
+		// user rules apply first
+		{"quay.io/coreos/etcd", "mirror.internal/coreos/etcd"},
+		{"quay.io/other/app", "vendor.example.com/quay/other/app"},
+		{"docker://busybox", "docker://registry.internal/busybox"},
+		{"https://example.com/app.aci", "https://mirror.internal/app.aci"},
+		{"example.com/anything", "mirror.internal/app"},
+		{"https://example.com/other.aci", ""},
+		{"coreos.com/etcd", ""},
+	} {
+		out, ok := m.Rewrite(tt.in)
+		if ok != (tt.out != "") || out != tt.out {
+			t.Errorf("#%d: got %q, %v, want %q", i, out, ok, tt.out)
+		}
+	}
+
+	var none *Mirrors
+	if _, ok := none.Rewrite("quay.io/coreos/etcd"); ok {
+		t.Errorf("unexpected rewrite without rules")
+	}
+}
+
+func TestLoadMirrorsErrors(t *testing.T) {
+	tests := []string{
+		`{"rktKind": "mirror", "rktVersion": "v1", "rules": []}`,
+		`{"rktKind": "mirrors", "rktVersion": "v2", "rules": []}`,
+		`{"rktKind": "mirrors", "rktVersion": "v1", "rules": [{"from": "quay.io/*"}]}`,
+		`{"rktKind": "mirrors", "rktVersion": "v1", "rules": [{"from": "*.io/app", "to": "mirror.internal/app"}]}`,
+		`{"rktKind": "mirrors", "rktVersion": "v1", "rules": [{"from": "quay.io/app", "to": "mirror.internal/*"}]}`,
+		`{"rktKind": "mirrors", "rktVersion": "v1", "rules": [{"from": "quay.io/*", "to": "*/mirror/*"}]}`,
+	}
+	for i, tt := range tests {
+		dir, err := ioutil.TempDir("", "mirrors-test")
+		if err != nil {
+			t.Fatalf("error creating tempdir: %v", err)
+		}
+		writeFiles(t, dir, map[string]string{"bad.json": tt})
+		if _, err := LoadMirrors(dir); err == nil {
+			t.Errorf("#%d: expected an error", i)
+		}
+		os.RemoveAll(dir)
+	}
+}
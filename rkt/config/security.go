@@ -0,0 +1,91 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+)
+
+const (
+	// UserSecurityConfig holds the configuration of the mandatory access
+	// control containers run under
+	UserSecurityConfig = "/etc/rkt/security.json"
+
+	securityKind    = "security"
+	securityVersion = "v1"
+)
+
+// securityFile is the on-disk format of UserSecurityConfig, e.g.
+//
+//	{
+//		"rktKind": "security",
+//		"rktVersion": "v1",
+//		"selinux": {"disabled": true}
+//	}
+type securityFile struct {
+	RktKind    string  `json:"rktKind"`
+	RktVersion string  `json:"rktVersion"`
+	SELinux    SELinux `json:"selinux"`
+}
+
+// Security is the configuration of the mandatory access control
+// containers run under.
+type Security struct {
+	SELinux SELinux
+}
+
+// SELinux configures the SELinux contexts of containers. Unless disabled,
+// each container runs in a context of its own when SELinux is enabled on
+// the host.
+type SELinux struct {
+	Disabled bool `json:"disabled"`
+	// ProcessContext and FileContext, if set, replace the contexts of
+	// the host's lxc_contexts, the category pair of each container is
+	// appended to them
+	ProcessContext string `json:"processContext"`
+	FileContext    string `json:"fileContext"`
+}
+
+// LoadSecurity loads the security configuration from path. A missing file
+// yields the default configuration.
+func LoadSecurity(path string) (*Security, error) {
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return &Security{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var sf securityFile
+	if err := json.Unmarshal(b, &sf); err != nil {
+		return nil, fmt.Errorf("error loading %s: %v", path, err)
+	}
+	if sf.RktKind != securityKind {
+		return nil, fmt.Errorf("error loading %s: unexpected rktKind %q, want %q", path, sf.RktKind, securityKind)
+	}
+	if sf.RktVersion != securityVersion {
+		return nil, fmt.Errorf("error loading %s: unsupported rktVersion %q", path, sf.RktVersion)
+	}
+	return &Security{SELinux: sf.SELinux}, nil
+}
+
+// DefaultSecurity loads the security configuration from
+// UserSecurityConfig.
+func DefaultSecurity() (*Security, error) {
+	return LoadSecurity(UserSecurityConfig)
+}
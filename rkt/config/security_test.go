@@ -0,0 +1,64 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSecurity(t *testing.T) {
+	dir, err := ioutil.TempDir("", "security-test")
+	if err != nil {
+		t.Fatalf("error creating tempdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	writeFiles(t, dir, map[string]string{
+		"disabled.json": `{"rktKind": "security", "rktVersion": "v1", "selinux": {"disabled": true}}`,
+		"contexts.json": `{"rktKind": "security", "rktVersion": "v1", "selinux": {"processContext": "system_u:system_r:container_t:s0", "fileContext": "system_u:object_r:container_file_t:s0"}}`,
+		"empty.json":    `{"rktKind": "security", "rktVersion": "v1"}`,
+		"badkind.json":  `{"rktKind": "store", "rktVersion": "v1"}`,
+		"badvers.json":  `{"rktKind": "security", "rktVersion": "v2"}`,
+	})
+
+	tests := []struct {
+		file string
+
+		want SELinux
+		err  bool
+	}{
+		{"disabled.json", SELinux{Disabled: true}, false},
+		{"contexts.json", SELinux{ProcessContext: "system_u:system_r:container_t:s0", FileContext: "system_u:object_r:container_file_t:s0"}, false},
+		{"empty.json", SELinux{}, false},
+		{"missing.json", SELinux{}, false},
+		{"badkind.json", SELinux{}, true},
+		{"badvers.json", SELinux{}, true},
+	}
+	for _, tt := range tests {
+		s, err := LoadSecurity(filepath.Join(dir, tt.file))
+		if (err != nil) != tt.err {
+			t.Errorf("%s: got err %v, want err %v", tt.file, err, tt.err)
+		}
+		if err != nil {
+			continue
+		}
+		if s.SELinux != tt.want {
+			t.Errorf("%s: got %+v, want %+v", tt.file, s.SELinux, tt.want)
+		}
+	}
+}
@@ -0,0 +1,204 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+const (
+	// SystemStage1File declares the stage1 images shipped by the vendor
+	SystemStage1File = "/usr/lib/rkt/stage1.json"
+	// UserStage1File declares the stage1 images of the local
+	// administrator, it replaces SystemStage1File
+	UserStage1File = "/etc/rkt/stage1.json"
+
+	stage1Kind    = "stage1"
+	stage1Version = "v1"
+)
+
+// stage1File is the on-disk format of a stage1 file, e.g.
+//
+//	{
+//		"rktKind": "stage1",
+//		"rktVersion": "v1",
+//		"default": "coreos.com/rkt/stage1:0.5.0",
+//		"trusted": ["coreos.com/rkt/stage1", "coreos.com/rkt/stage1-kvm"],
+//		"rules": [
+//			{"imagePrefix": "untrusted.example.com/", "stage1": "coreos.com/rkt/stage1-kvm:0.5.0"},
+//			{"annotation": "example.com/class=sandboxed", "stage1": "coreos.com/rkt/stage1-kvm:0.5.0"}
+//		]
+//	}
+type stage1File struct {
+	RktKind    string       `json:"rktKind"`
+	RktVersion string       `json:"rktVersion"`
+	Default    string       `json:"default"`
+	Trusted    []string     `json:"trusted"`
+	Rules      []Stage1Rule `json:"rules"`
+}
+
+// Stage1Rule selects the stage1 image of the containers running an image
+// named ImagePrefix, or with the pod annotation Annotation, e.g. so that
+// the images of an untrusted origin are always run in a virtual machine.
+type Stage1Rule struct {
+	// ImagePrefix matches the image named so, or the images named under
+	// it if it ends with a slash
+	ImagePrefix string `json:"imagePrefix,omitempty"`
+	// Annotation matches the containers with the annotation NAME, or
+	// with the value VALUE if given as NAME=VALUE
+	Annotation string `json:"annotation,omitempty"`
+	// Stage1 is the stage1 image, as given to --stage1-image
+	Stage1 string `json:"stage1"`
+}
+
+// Stage1 declares the images containers may be run with as their stage1,
+// which runs with full privileges on the host.
+type Stage1 struct {
+	// Default, if set, is the stage1 image of containers run without
+	// another one, as given to --stage1-image
+	Default string
+	// Trusted names the images which may be used as stage1, besides the
+	// default one. A name ending with a slash trusts all images named
+	// under it.
+	Trusted []string
+	// Rules select the stage1 image of containers instead of Default, the
+	// first one matching applies. The images they select are trusted.
+	Rules []Stage1Rule
+}
+
+// LoadStage1 loads the first of the given stage1 files which exists. No
+// stage1 image is trusted without any.
+func LoadStage1(files ...string) (*Stage1, error) {
+	for _, f := range files {
+		b, err := ioutil.ReadFile(f)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		s, err := parseStage1(b)
+		if err != nil {
+			return nil, fmt.Errorf("error loading %s: %v", f, err)
+		}
+		return s, nil
+	}
+	return &Stage1{}, nil
+}
+
+// DefaultStage1 loads the stage1 images from UserStage1File or
+// SystemStage1File.
+func DefaultStage1() (*Stage1, error) {
+	return LoadStage1(UserStage1File, SystemStage1File)
+}
+
+func parseStage1(b []byte) (*Stage1, error) {
+	var sf stage1File
+	if err := json.Unmarshal(b, &sf); err != nil {
+		return nil, err
+	}
+	if sf.RktKind != stage1Kind {
+		return nil, fmt.Errorf("unexpected rktKind %q, want %q", sf.RktKind, stage1Kind)
+	}
+	if sf.RktVersion != stage1Version {
+		return nil, fmt.Errorf("unsupported rktVersion %q", sf.RktVersion)
+	}
+	for i, r := range sf.Rules {
+		if r.Stage1 == "" {
+			return nil, fmt.Errorf("rule %d: no stage1", i)
+		}
+		if (r.ImagePrefix == "") == (r.Annotation == "") {
+			return nil, fmt.Errorf("rule %d: exactly one of imagePrefix and annotation must be given", i)
+		}
+	}
+	return &Stage1{
+		Default: sf.Default,
+		Trusted: sf.Trusted,
+		Rules:   sf.Rules,
+	}, nil
+}
+
+// imageName strips the labels off an image given as NAME:VERSION or
+// NAME,LABEL=VALUE..., as --stage1-image takes it
+func imageName(img string) string {
+	if i := strings.IndexAny(img, ":,"); i >= 0 {
+		return img[:i]
+	}
+	return img
+}
+
+// matchName reports whether name is pattern, or is under it if it ends
+// with a slash
+func matchName(name, pattern string) bool {
+	return name == pattern || (strings.HasSuffix(pattern, "/") && strings.HasPrefix(name, pattern))
+}
+
+// IsTrusted reports whether the image named name may be used as stage1.
+func (s *Stage1) IsTrusted(name string) bool {
+	if s == nil {
+		return false
+	}
+	if s.Default != "" && name == imageName(s.Default) {
+		return true
+	}
+	for _, r := range s.Rules {
+		if name == r.ImageName() {
+			return true
+		}
+	}
+	for _, t := range s.Trusted {
+		if matchName(name, t) {
+			return true
+		}
+	}
+	return false
+}
+
+// ImageName returns the name of the stage1 image r selects, without its
+// labels.
+func (r *Stage1Rule) ImageName() string {
+	return imageName(r.Stage1)
+}
+
+// Select returns the first rule matching a container running the images
+// named images, with the given pod annotations, nil if none does.
+func (s *Stage1) Select(images []string, annotations map[string]string) *Stage1Rule {
+	if s == nil {
+		return nil
+	}
+	for i, r := range s.Rules {
+		if r.ImagePrefix != "" {
+			for _, name := range images {
+				if matchName(name, r.ImagePrefix) {
+					return &s.Rules[i]
+				}
+			}
+			continue
+		}
+		name, value := r.Annotation, ""
+		hasValue := false
+		if j := strings.Index(r.Annotation, "="); j >= 0 {
+			name, value, hasValue = r.Annotation[:j], r.Annotation[j+1:], true
+		}
+		if v, ok := annotations[name]; ok && (!hasValue || v == value) {
+			return &s.Rules[i]
+		}
+	}
+	return nil
+}
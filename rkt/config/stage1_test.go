@@ -0,0 +1,131 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStage1(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stage1-test")
+	if err != nil {
+		t.Fatalf("error creating tempdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := LoadStage1(filepath.Join(dir, "missing.json"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if s.Default != "" || s.IsTrusted("coreos.com/rkt/stage1") {
+		t.Errorf("expected no stage1 image without a stage1 file, got %+v", s)
+	}
+
+	writeFiles(t, dir, map[string]string{
+		"user.json": `{"rktKind": "stage1", "rktVersion": "v1",
+			"default": "coreos.com/rkt/stage1:0.5.0",
+			"trusted": ["coreos.com/rkt/stage1-kvm", "example.com/stage1/"]}`,
+		"system.json": `{"rktKind": "stage1", "rktVersion": "v1", "trusted": ["example.org/stage1"]}`,
+		"bad.json":    `{"rktKind": "imageHook", "rktVersion": "v1"}`,
+	})
+	s, err = LoadStage1(filepath.Join(dir, "user.json"), filepath.Join(dir, "system.json"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if s.Default != "coreos.com/rkt/stage1:0.5.0" {
+		t.Errorf("got default %q", s.Default)
+	}
+	for name, want := range map[string]bool{
+		"coreos.com/rkt/stage1":      true,
+		"coreos.com/rkt/stage1-kvm":  true,
+		"example.com/stage1/fly":     true,
+		"example.com/stage1":         false,
+		"coreos.com/rkt/stage1-evil": false,
+		// the system file is replaced by the user one
+		"example.org/stage1": false,
+	} {
+		if got := s.IsTrusted(name); got != want {
+			t.Errorf("%s: got trusted %v, want %v", name, got, want)
+		}
+	}
+
+	if _, err := LoadStage1(filepath.Join(dir, "bad.json")); err == nil {
+		t.Errorf("expected an error for a file of another kind")
+	}
+}
+
+func TestStage1Rules(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stage1-test")
+	if err != nil {
+		t.Fatalf("error creating tempdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	writeFiles(t, dir, map[string]string{
+		"rules.json": `{"rktKind": "stage1", "rktVersion": "v1",
+			"default": "coreos.com/rkt/stage1:0.5.0",
+			"rules": [
+				{"imagePrefix": "untrusted.example.com/", "stage1": "coreos.com/rkt/stage1-kvm:0.5.0"},
+				{"imagePrefix": "example.com/db", "stage1": "example.com/stage1-db"},
+				{"annotation": "example.com/class=sandboxed", "stage1": "coreos.com/rkt/stage1-kvm:0.5.0"},
+				{"annotation": "example.com/gpu", "stage1": "example.com/stage1-gpu"}
+			]}`,
+		"nostage1.json": `{"rktKind": "stage1", "rktVersion": "v1",
+			"rules": [{"imagePrefix": "example.com/"}]}`,
+		"both.json": `{"rktKind": "stage1", "rktVersion": "v1",
+			"rules": [{"imagePrefix": "example.com/", "annotation": "example.com/gpu", "stage1": "example.com/stage1"}]}`,
+	})
+	s, err := LoadStage1(filepath.Join(dir, "rules.json"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for i, tt := range []struct {
+		images      []string
+		annotations map[string]string
+		want        string
+	}{
+		{[]string{"example.com/app"}, nil, ""},
+		{[]string{"example.com/app", "untrusted.example.com/tools/curl"}, nil, "coreos.com/rkt/stage1-kvm:0.5.0"},
+		{[]string{"example.com/db"}, nil, "example.com/stage1-db"},
+		{[]string{"example.com/db-backup"}, nil, ""},
+		{[]string{"example.com/app"}, map[string]string{"example.com/class": "sandboxed"}, "coreos.com/rkt/stage1-kvm:0.5.0"},
+		{[]string{"example.com/app"}, map[string]string{"example.com/class": "batch"}, ""},
+		{[]string{"example.com/app"}, map[string]string{"example.com/gpu": ""}, "example.com/stage1-gpu"},
+		// the first rule matching applies
+		{[]string{"example.com/db"}, map[string]string{"example.com/gpu": "1"}, "example.com/stage1-db"},
+	} {
+		var got string
+		if r := s.Select(tt.images, tt.annotations); r != nil {
+			got = r.Stage1
+		}
+		if got != tt.want {
+			t.Errorf("#%d: got stage1 %q, want %q", i, got, tt.want)
+		}
+	}
+	for _, name := range []string{"coreos.com/rkt/stage1-kvm", "example.com/stage1-db", "example.com/stage1-gpu"} {
+		if !s.IsTrusted(name) {
+			t.Errorf("%s: expected the stage1 of a rule to be trusted", name)
+		}
+	}
+
+	for _, f := range []string{"nostage1.json", "both.json"} {
+		if _, err := LoadStage1(filepath.Join(dir, f)); err == nil {
+			t.Errorf("%s: expected an error for an invalid rule", f)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+)
+
+const (
+	// UserStoreConfig holds the configuration of the image store
+	UserStoreConfig = "/etc/rkt/store.json"
+
+	storeKind    = "store"
+	storeVersion = "v1"
+)
+
+// storeFile is the on-disk format of UserStoreConfig, e.g.
+//
+//	{
+//		"rktKind": "store",
+//		"rktVersion": "v1",
+//		"encryption": {"keyCommand": ["keyctl", "pipe", "%user:rkt"]}
+//	}
+type storeFile struct {
+	RktKind    string      `json:"rktKind"`
+	RktVersion string      `json:"rktVersion"`
+	Encryption *Encryption `json:"encryption"`
+}
+
+// Store is the configuration of the image store.
+type Store struct {
+	// Encryption is nil if images are stored in the clear
+	Encryption *Encryption
+}
+
+// Encryption tells where to get the key images are encrypted with. The key
+// is 32 hex encoded bytes, read either from KeyFile or from the output of
+// KeyCommand, e.g. a tool querying the kernel keyring or a KMS.
+type Encryption struct {
+	KeyFile    string   `json:"keyFile"`
+	KeyCommand []string `json:"keyCommand"`
+}
+
+// LoadStore loads the store configuration from path. A missing file yields
+// the default configuration.
+func LoadStore(path string) (*Store, error) {
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return &Store{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var sf storeFile
+	if err := json.Unmarshal(b, &sf); err != nil {
+		return nil, fmt.Errorf("error loading %s: %v", path, err)
+	}
+	if sf.RktKind != storeKind {
+		return nil, fmt.Errorf("error loading %s: unexpected rktKind %q, want %q", path, sf.RktKind, storeKind)
+	}
+	if sf.RktVersion != storeVersion {
+		return nil, fmt.Errorf("error loading %s: unsupported rktVersion %q", path, sf.RktVersion)
+	}
+	if e := sf.Encryption; e != nil && (e.KeyFile == "") == (len(e.KeyCommand) == 0) {
+		return nil, fmt.Errorf("error loading %s: exactly one of keyFile and keyCommand must be set", path)
+	}
+	return &Store{Encryption: sf.Encryption}, nil
+}
+
+// DefaultStore loads the store configuration from UserStoreConfig.
+func DefaultStore() (*Store, error) {
+	return LoadStore(UserStoreConfig)
+}
+
+// Key returns the encryption key. A key file must not be accessible to
+// anyone but its owner.
+func (e *Encryption) Key() ([]byte, error) {
+	var b []byte
+	if e.KeyFile != "" {
+		fi, err := os.Stat(e.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading encryption key: %v", err)
+		}
+		if fi.Mode().Perm()&0077 != 0 {
+			return nil, fmt.Errorf("encryption key file %s is accessible to group or others", e.KeyFile)
+		}
+		if b, err = ioutil.ReadFile(e.KeyFile); err != nil {
+			return nil, fmt.Errorf("error reading encryption key: %v", err)
+		}
+	} else {
+		cmd := exec.Command(e.KeyCommand[0], e.KeyCommand[1:]...)
+		cmd.Stderr = os.Stderr
+		var err error
+		if b, err = cmd.Output(); err != nil {
+			return nil, fmt.Errorf("error running encryption key command: %v", err)
+		}
+	}
+	key, err := hex.DecodeString(string(bytes.TrimSpace(b)))
+	if err != nil {
+		return nil, fmt.Errorf("malformed encryption key: %v", err)
+	}
+	return key, nil
+}
@@ -0,0 +1,89 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "store-test")
+	if err != nil {
+		t.Fatalf("error creating tempdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	hexKey := "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f"
+	writeFiles(t, dir, map[string]string{
+		"key":          hexKey + "\n",
+		"file.json":    `{"rktKind": "store", "rktVersion": "v1", "encryption": {"keyFile": "` + filepath.Join(dir, "key") + `"}}`,
+		"command.json": `{"rktKind": "store", "rktVersion": "v1", "encryption": {"keyCommand": ["echo", "` + hexKey + `"]}}`,
+		"clear.json":   `{"rktKind": "store", "rktVersion": "v1"}`,
+		"both.json":    `{"rktKind": "store", "rktVersion": "v1", "encryption": {"keyFile": "/key", "keyCommand": ["true"]}}`,
+		"neither.json": `{"rktKind": "store", "rktVersion": "v1", "encryption": {}}`,
+		"badkind.json": `{"rktKind": "auth", "rktVersion": "v1"}`,
+		"badhex.json":  `{"rktKind": "store", "rktVersion": "v1", "encryption": {"keyCommand": ["echo", "xyz"]}}`,
+		"badperm.json": `{"rktKind": "store", "rktVersion": "v1", "encryption": {"keyFile": "` + filepath.Join(dir, "badperm") + `"}}`,
+		"badperm":      hexKey,
+	})
+	if err := os.Chmod(filepath.Join(dir, "badperm"), 0644); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want, _ := hex.DecodeString(hexKey)
+
+	tests := []struct {
+		file string
+
+		loadErr bool
+		encrypt bool
+		keyErr  bool
+	}{
+		{"file.json", false, true, false},
+		{"command.json", false, true, false},
+		{"clear.json", false, false, false},
+		{"missing.json", false, false, false},
+		{"both.json", true, false, false},
+		{"neither.json", true, false, false},
+		{"badkind.json", true, false, false},
+		{"badhex.json", false, true, true},
+		{"badperm.json", false, true, true},
+	}
+	for _, tt := range tests {
+		s, err := LoadStore(filepath.Join(dir, tt.file))
+		if (err != nil) != tt.loadErr {
+			t.Errorf("%s: got err %v, want err %v", tt.file, err, tt.loadErr)
+		}
+		if err != nil {
+			continue
+		}
+		if (s.Encryption != nil) != tt.encrypt {
+			t.Errorf("%s: got encryption %v, want %v", tt.file, s.Encryption != nil, tt.encrypt)
+		}
+		if s.Encryption == nil {
+			continue
+		}
+		key, err := s.Encryption.Key()
+		if (err != nil) != tt.keyErr {
+			t.Errorf("%s: got key err %v, want err %v", tt.file, err, tt.keyErr)
+		}
+		if err == nil && string(key) != string(want) {
+			t.Errorf("%s: got key %x, want %x", tt.file, key, want)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//+build linux
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/appc/spec/schema"
+	"github.com/appc/spec/schema/types"
+	rktpath "github.com/coreos/rocket/path"
+	"github.com/coreos/rocket/pkg/coredump"
+)
+
+const cmdCoreDumpName = "coredump"
+
+var (
+	cmdCoreDump = &Command{
+		Name:    cmdCoreDumpName,
+		Summary: "Handle a core dump piped by the kernel",
+		Usage:   "PID SIGNAL TIME COMM [-- HANDLER [ARG...]]",
+		Description: `Meant to be set as the core dump handler of the host, e.g.
+  kernel.core_pattern=|/usr/bin/rkt coredump %P %s %t %e -- /usr/lib/systemd/systemd-coredump %P %u %g %s %t %e
+Core dumps of apps of containers run with --core-dumps=pod are kept in the
+container directory, those of containers run with --core-dumps=host and of
+processes outside of containers are piped to HANDLER, if given, and dropped
+otherwise. Core dumps of apps are listed by "rkt status".`,
+		Run: runCoreDump,
+	}
+)
+
+func init() {
+	commands = append(commands, cmdCoreDump)
+}
+
+func runCoreDump(args []string) (exit int) {
+	var handler []string
+	for i, a := range args {
+		if a == "--" {
+			args, handler = args[:i], args[i+1:]
+			break
+		}
+	}
+	if len(args) != 4 {
+		printCommandUsageByName(cmdCoreDumpName)
+		return 1
+	}
+	pid, err := strconv.Atoi(args[0])
+	if err != nil {
+		fmt.Fprintf(stderr, "Invalid PID %q\n", args[0])
+		return 1
+	}
+	sig, err := strconv.Atoi(args[1])
+	if err != nil {
+		fmt.Fprintf(stderr, "Invalid signal %q\n", args[1])
+		return 1
+	}
+	secs, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil {
+		fmt.Fprintf(stderr, "Invalid time %q\n", args[2])
+		return 1
+	}
+	rec := coredump.Record{
+		Time:   time.Unix(secs, 0).UTC(),
+		PID:    pid,
+		Signal: sig,
+		Comm:   args[3],
+	}
+
+	cdir, cfg, err := coreDumpContainer(&rec)
+	if err != nil {
+		// still hand the core dump to the host rather than losing it
+		fmt.Fprintf(stderr, "Unable to find container of process %d: %v\n", pid, err)
+	}
+	if cfg == nil {
+		return forwardCoreDump(handler)
+	}
+
+	switch cfg.Mode {
+	case coredump.ModePod:
+		file, truncated, err := cfg.Keep(cdir, os.Stdin, rec)
+		if err != nil {
+			fmt.Fprintf(stderr, "Unable to keep core dump: %v\n", err)
+			return 1
+		}
+		rec.File, rec.Truncated = file, truncated
+	default:
+		if exit := forwardCoreDump(handler); exit != 0 {
+			return exit
+		}
+	}
+	if err := coredump.AddRecord(cdir, rec); err != nil {
+		fmt.Fprintf(stderr, "Unable to record core dump: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// coreDumpContainer returns the directory and core dump configuration of
+// the container of the process of rec, and sets the name of its app in
+// rec. The configuration is nil if the process doesn't run in a container
+// capturing core dumps.
+func coreDumpContainer(rec *coredump.Record) (string, *coredump.Config, error) {
+	uuid, unit, err := coredump.FindContainer(rec.PID)
+	if err != nil || uuid == "" {
+		return "", nil, err
+	}
+	if _, err := types.NewUUID(uuid); err != nil {
+		return "", nil, err
+	}
+	cdir := filepath.Join(containersDir(), uuid)
+	cfg, err := coredump.Load(cdir)
+	if err != nil || cfg == nil {
+		return "", nil, err
+	}
+
+	b, err := ioutil.ReadFile(rktpath.ContainerManifestPath(cdir))
+	if err != nil {
+		return "", nil, fmt.Errorf("error reading container manifest: %v", err)
+	}
+	cm := schema.ContainerRuntimeManifest{}
+	if err := cm.UnmarshalJSON(b); err != nil {
+		return "", nil, fmt.Errorf("error loading container manifest: %v", err)
+	}
+	// e.g. processes of stage1 itself
+	rec.App = "stage1"
+	for _, ra := range cm.Apps {
+		if types.ShortHash(ra.ImageID.String())+".service" == unit {
+			rec.App = ra.Name.String()
+			break
+		}
+	}
+	return cdir, cfg, nil
+}
+
+// forwardCoreDump pipes the core dump to the handler command of the host,
+// or drops it if there is none.
+func forwardCoreDump(handler []string) int {
+	if len(handler) == 0 {
+		if _, err := io.Copy(ioutil.Discard, os.Stdin); err != nil {
+			fmt.Fprintf(stderr, "Unable to read core dump: %v\n", err)
+			return 1
+		}
+		return 0
+	}
+	cmd := exec.Command(handler[0], handler[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		fmt.Fprintf(stderr, "Unable to run core dump handler: %v\n", err)
+		return 1
+	}
+	return 0
+}
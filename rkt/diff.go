@@ -0,0 +1,438 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//+build linux
+
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+
+	"github.com/appc/spec/aci"
+	"github.com/appc/spec/schema"
+	"github.com/appc/spec/schema/types"
+	"github.com/coreos/rocket/cas"
+	rktpath "github.com/coreos/rocket/path"
+	"github.com/coreos/rocket/pkg/pod"
+)
+
+const cmdDiffName = "diff"
+
+var (
+	cmdDiff = &Command{
+		Name:    cmdDiffName,
+		Summary: "Show the files the apps of an exited container changed",
+		Usage:   "UUID [APP...]",
+		Formats: []string{formatJSON, formatRaw},
+		Description: `Prints, for every app or only the given ones, the files it added (A), changed (C)
+or deleted (D) relative to its image and dependencies, e.g. to see what a
+misbehaving app wrote before removing the container. Apps mounted through an
+overlay are compared through its writable layer, others by reading their image
+again and hashing the files of the same size.`,
+		Run: runDiff,
+	}
+)
+
+func init() {
+	commands = append(commands, cmdDiff)
+}
+
+// fileChange is a file of an app's rootfs which differs from its image
+type fileChange struct {
+	// Kind is 'A' for added, 'C' for changed and 'D' for deleted files
+	Kind byte
+	Path string
+}
+
+type changesByPath []fileChange
+
+func (c changesByPath) Len() int           { return len(c) }
+func (c changesByPath) Less(i, j int) bool { return c[i].Path < c[j].Path }
+func (c changesByPath) Swap(i, j int)      { c[i], c[j] = c[j], c[i] }
+
+func runDiff(args []string) (exit int) {
+	if len(args) < 1 {
+		printCommandUsageByName(cmdDiffName)
+		return 1
+	}
+
+	containerUUID, err := types.NewUUID(args[0])
+	if err != nil {
+		fmt.Fprintf(stderr, "Invalid UUID: %v\n", err)
+		return 1
+	}
+	p, err := openContainer(containerUUID)
+	if err != nil {
+		fmt.Fprintf(stderr, "Unable to access container: %v\n", err)
+		return 1
+	}
+	defer p.Close()
+	if p.State == pod.Running {
+		fmt.Fprintf(stderr, "Container %q is still running, its files can only be compared once it exited\n", containerUUID)
+		return 1
+	}
+	// the container may be moved to the garbage directory meanwhile
+	cfd, err := p.Fd()
+	if err != nil {
+		fmt.Fprintf(stderr, "Unable to get lock fd: %v\n", err)
+		return 1
+	}
+	cdir := fmt.Sprintf("/proc/self/fd/%d", cfd)
+
+	b, err := ioutil.ReadFile(rktpath.ContainerManifestPath(cdir))
+	if err != nil {
+		fmt.Fprintf(stderr, "Unable to read container manifest: %v\n", err)
+		return 1
+	}
+	cm := schema.ContainerRuntimeManifest{}
+	if err := cm.UnmarshalJSON(b); err != nil {
+		fmt.Fprintf(stderr, "Unable to load container manifest: %v\n", err)
+		return 1
+	}
+	// containers set up by older versions don't record dependencies, only
+	// their app images are compared
+	var deps map[string][]string
+	if p, err := readProvenance(cdir); err == nil {
+		deps = p.Dependencies
+	}
+
+	ds, err := getStore()
+	if err != nil {
+		fmt.Fprintf(stderr, "%v\n", err)
+		return 1
+	}
+
+	apps := cm.Apps
+	if len(args) > 1 {
+		apps = nil
+		for _, name := range args[1:] {
+			app := cm.Apps.Get(types.ACName(name))
+			if app == nil {
+				fmt.Fprintf(stderr, "No app %q in container %q\n", name, containerUUID)
+				return 1
+			}
+			apps = append(apps, *app)
+		}
+	}
+	appChanges := []appFileChange{}
+	for _, app := range apps {
+		changes, err := diffApp(ds, cdir, app.ImageID, deps)
+		if err != nil {
+			fmt.Fprintf(stderr, "Unable to compare the files of app %q: %v\n", app.Name, err)
+			exit = 1
+			continue
+		}
+		for _, c := range changes {
+			switch globalFlags.Format {
+			case formatJSON:
+				appChanges = append(appChanges, appFileChange{app.Name.String(), string(c.Kind), c.Path})
+			case formatRaw:
+				printRaw(app.Name, string(c.Kind), c.Path)
+			default:
+				fmt.Fprintf(stdout, "%s %c %s\n", app.Name, c.Kind, c.Path)
+			}
+		}
+	}
+	if globalFlags.Format == formatJSON {
+		if err := printJSON(appChanges); err != nil {
+			fmt.Fprintf(stderr, "%v\n", err)
+			return 1
+		}
+	}
+	return
+}
+
+// appFileChange is a change printed with --format=json
+type appFileChange struct {
+	App  string `json:"app"`
+	Kind string `json:"kind"`
+	Path string `json:"path"`
+}
+
+// diffApp returns the changes made to the rootfs of the app with image img
+// in the container in cdir
+func diffApp(ds *cas.Store, cdir string, img types.Hash, deps map[string][]string) ([]fileChange, error) {
+	if _, err := os.Stat(rktpath.AppVerityHashPath(cdir, img)); err == nil {
+		// mounted read-only, nothing could be changed
+		return nil, nil
+	}
+	upper := filepath.Join(rktpath.AppOverlayPath(cdir, img), "upper")
+	if _, err := os.Stat(upper); err == nil {
+		ref, err := imageEntries(ds, img.String(), deps, false)
+		if err != nil {
+			return nil, err
+		}
+		return diffOverlay(upper, ref)
+	}
+	ref, err := imageEntries(ds, img.String(), deps, true)
+	if err != nil {
+		return nil, err
+	}
+	return diffRootfs(rktpath.AppRootfsPath(cdir, img), ref)
+}
+
+// imageEntry is a file of a rendered image
+type imageEntry struct {
+	hdr *tar.Header
+	// digest is the SHA-256 of a regular file, if computed
+	digest []byte
+}
+
+// imageEntries returns the files of the rootfs of the image stored under
+// key rendered on top of its dependencies, by absolute path, optionally
+// with the digests of the regular files.
+func imageEntries(ds *cas.Store, key string, deps map[string][]string, digests bool) (map[string]*imageEntry, error) {
+	entries := make(map[string]*imageEntry)
+	var render func(key string, pwl map[string]bool) error
+	render = func(key string, pwl map[string]bool) error {
+		if len(deps[key]) > 0 {
+			im, err := ds.GetImageManifest(key)
+			if err != nil {
+				return err
+			}
+			dpwl := pwl
+			if len(im.PathWhitelist) > 0 {
+				dpwl = make(map[string]bool)
+				for _, p := range im.PathWhitelist {
+					for p = path.Clean("/" + p); ; p = path.Dir(p) {
+						if pwl == nil || pwl[p] {
+							dpwl[p] = true
+						}
+						if p == "/" {
+							break
+						}
+					}
+				}
+			}
+			for _, dep := range deps[key] {
+				if err := render(dep, dpwl); err != nil {
+					return err
+				}
+			}
+		}
+		return readImageEntries(ds, key, pwl, digests, entries)
+	}
+	if err := render(key, nil); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// readImageEntries adds the files of the rootfs of the image stored under
+// key in pwl, or all of them if pwl is nil, to entries
+func readImageEntries(ds *cas.Store, key string, pwl map[string]bool, digests bool, entries map[string]*imageEntry) error {
+	rs, err := ds.ReadStream(key)
+	if err != nil {
+		return err
+	}
+	defer rs.Close()
+	tr := tar.NewReader(rs)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("error reading image %s: %v", key, err)
+		}
+		p, ok := rootfsEntryPath(hdr.Name)
+		if !ok || (pwl != nil && !pwl[p]) {
+			continue
+		}
+		e := &imageEntry{hdr: hdr}
+		switch hdr.Typeflag {
+		case tar.TypeLink:
+			// a hard link is the file it links to
+			if target, ok := rootfsEntryPath(hdr.Linkname); ok && entries[target] != nil {
+				e = entries[target]
+			}
+		case tar.TypeReg, tar.TypeRegA:
+			if digests {
+				h := sha256.New()
+				if _, err := io.Copy(h, tr); err != nil {
+					return fmt.Errorf("error reading image %s: %v", key, err)
+				}
+				e.digest = h.Sum(nil)
+			}
+		}
+		entries[p] = e
+	}
+}
+
+// rootfsEntryPath returns the absolute path in the rootfs of the tarball
+// entry name, if it is beneath it
+func rootfsEntryPath(name string) (string, bool) {
+	name = path.Clean(name)
+	if !strings.HasPrefix(name, aci.RootfsDir+"/") {
+		return "", false
+	}
+	return strings.TrimPrefix(name, aci.RootfsDir), true
+}
+
+// diffOverlay returns the changes recorded in the upper layer of an
+// overlay whose lower layer holds the files in ref. Directories are only
+// reported when added, they are copied up for any change beneath them.
+func diffOverlay(upper string, ref map[string]*imageEntry) ([]fileChange, error) {
+	var changes []fileChange
+	err := filepath.Walk(upper, func(fp string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fp == upper {
+			return nil
+		}
+		p := "/" + strings.TrimPrefix(fp, upper+"/")
+		switch {
+		case isWhiteout(fi):
+			changes = append(changes, fileChange{'D', p})
+		case fi.IsDir():
+			if ref[p] == nil {
+				changes = append(changes, fileChange{'A', p})
+			} else if isOpaque(fp) {
+				// the directory replaces the one of the image, whose
+				// files not in it are gone
+				for q := range ref {
+					if strings.HasPrefix(q, p+"/") {
+						if _, err := os.Lstat(filepath.Join(upper, q)); os.IsNotExist(err) {
+							changes = append(changes, fileChange{'D', q})
+						}
+					}
+				}
+			}
+		case ref[p] == nil:
+			changes = append(changes, fileChange{'A', p})
+		default:
+			changes = append(changes, fileChange{'C', p})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Sort(changesByPath(changes))
+	return changes, nil
+}
+
+// isWhiteout reports whether fi is an overlayfs whiteout, a character
+// device numbered 0:0 hiding a file of the lower layer
+func isWhiteout(fi os.FileInfo) bool {
+	if fi.Mode()&os.ModeCharDevice == 0 {
+		return false
+	}
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	return ok && st.Rdev == 0
+}
+
+func isOpaque(dir string) bool {
+	buf := make([]byte, 1)
+	n, err := syscall.Getxattr(dir, "trusted.overlay.opaque", buf)
+	return err == nil && n == 1 && buf[0] == 'y'
+}
+
+// diffRootfs returns the changes made to the files in ref, extracted in
+// rootfs. Deleted directories are reported alone, without their files.
+func diffRootfs(rootfs string, ref map[string]*imageEntry) ([]fileChange, error) {
+	var changes []fileChange
+	seen := make(map[string]bool)
+	err := filepath.Walk(rootfs, func(fp string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fp == rootfs {
+			return nil
+		}
+		p := "/" + strings.TrimPrefix(fp, rootfs+"/")
+		seen[p] = true
+		e := ref[p]
+		if e == nil {
+			changes = append(changes, fileChange{'A', p})
+			return nil
+		}
+		changed, err := fileChanged(fp, fi, e)
+		if err != nil {
+			return err
+		}
+		if changed {
+			changes = append(changes, fileChange{'C', p})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for p := range ref {
+		if seen[p] {
+			continue
+		}
+		if dir := path.Dir(p); dir == "/" || seen[dir] {
+			changes = append(changes, fileChange{'D', p})
+		}
+	}
+	sort.Sort(changesByPath(changes))
+	return changes, nil
+}
+
+// fileChanged reports whether the file at fp differs from e in type,
+// permissions, ownership or content
+func fileChanged(fp string, fi os.FileInfo, e *imageEntry) (bool, error) {
+	want := e.hdr.FileInfo().Mode()
+	const perm = os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky
+	if fi.Mode()&os.ModeType != want&os.ModeType {
+		return true, nil
+	}
+	// symlink permissions are meaningless
+	if fi.Mode()&os.ModeSymlink == 0 && fi.Mode()&perm != want&perm {
+		return true, nil
+	}
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok && (int(st.Uid) != e.hdr.Uid || int(st.Gid) != e.hdr.Gid) {
+		return true, nil
+	}
+	switch {
+	case fi.Mode()&os.ModeSymlink != 0:
+		target, err := os.Readlink(fp)
+		if err != nil {
+			return false, err
+		}
+		return target != e.hdr.Linkname, nil
+	case fi.Mode().IsRegular():
+		if fi.Size() != e.hdr.Size {
+			return true, nil
+		}
+		if e.digest == nil {
+			return false, nil
+		}
+		f, err := os.Open(fp)
+		if err != nil {
+			return false, err
+		}
+		defer f.Close()
+		h := sha256.New()
+		if _, err := io.Copy(h, f); err != nil {
+			return false, err
+		}
+		return !bytes.Equal(h.Sum(nil), e.digest), nil
+	}
+	return false, nil
+}
@@ -0,0 +1,118 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// testRef returns the entries of an image with an /etc directory holding
+// the files given with their contents, owned by the current user
+func testRef(files map[string]string) map[string]*imageEntry {
+	uid, gid := os.Getuid(), os.Getgid()
+	ref := map[string]*imageEntry{
+		"/etc": {hdr: &tar.Header{Name: "rootfs/etc", Typeflag: tar.TypeDir, Mode: 0755, Uid: uid, Gid: gid}},
+	}
+	for name, contents := range files {
+		d := sha256.Sum256([]byte(contents))
+		ref["/etc/"+name] = &imageEntry{
+			hdr:    &tar.Header{Name: "rootfs/etc/" + name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(contents)), Uid: uid, Gid: gid},
+			digest: d[:],
+		}
+	}
+	return ref
+}
+
+func writeTestFiles(t *testing.T, dir string, files map[string]string) {
+	for name, contents := range files {
+		p := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatalf("error creating directory: %v", err)
+		}
+		if err := ioutil.WriteFile(p, []byte(contents), 0644); err != nil {
+			t.Fatalf("error writing file: %v", err)
+		}
+	}
+}
+
+func TestDiffRootfs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rkt-diff-test")
+	if err != nil {
+		t.Fatalf("error creating temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.Chmod(dir, 0755); err != nil {
+		t.Fatalf("error changing mode: %v", err)
+	}
+
+	ref := testRef(map[string]string{"hosts": "localhost", "passwd": "root", "group": "root", "shadow": "x"})
+	writeTestFiles(t, dir, map[string]string{
+		"etc/hosts":  "localhost",
+		"etc/passwd": "evil",
+		"etc/group":  "root",
+		"tmp/x":      "x",
+	})
+	if err := os.Chmod(filepath.Join(dir, "etc/group"), 0666); err != nil {
+		t.Fatalf("error changing mode: %v", err)
+	}
+
+	got, err := diffRootfs(dir, ref)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []fileChange{
+		{'C', "/etc/group"},
+		{'C', "/etc/passwd"},
+		{'D', "/etc/shadow"},
+		{'A', "/tmp"},
+		{'A', "/tmp/x"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestDiffOverlay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rkt-diff-test")
+	if err != nil {
+		t.Fatalf("error creating temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	ref := testRef(map[string]string{"hosts": "localhost", "passwd": "root"})
+	writeTestFiles(t, dir, map[string]string{
+		"etc/passwd": "evil",
+		"tmp/x":      "x",
+	})
+
+	got, err := diffOverlay(dir, ref)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []fileChange{
+		{'C', "/etc/passwd"},
+		{'A', "/tmp"},
+		{'A', "/tmp/x"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
@@ -59,7 +59,7 @@ func runEnter(args []string) (exit int) {
 
 	containerUUID, err := types.NewUUID(args[0])
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Invalid UUID: %v\n", err)
+		fmt.Fprintf(stderr, "Invalid UUID: %v\n", err)
 		return 1
 	}
 
@@ -67,31 +67,31 @@ func runEnter(args []string) (exit int) {
 	cdir := filepath.Join(containersDir(), cid)
 
 	if err = pingContainer(cdir); err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to query container %q: %v\n", cid, err)
+		fmt.Fprintf(stderr, "Failed to query container %q: %v\n", cid, err)
 		return 1
 	}
 
 	imageID, err := getAppImageID(cdir)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Unable to determine image id: %v\n", err)
+		fmt.Fprintf(stderr, "Unable to determine image id: %v\n", err)
 		return 1
 	}
 
 	_, err = os.Stat(filepath.Join(rktpath.AppRootfsPath(cdir, *imageID)))
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Unable to access app rootfs: %v\n", err)
+		fmt.Fprintf(stderr, "Unable to access app rootfs: %v\n", err)
 		return 1
 	}
 
 	argv, err := getEnterArgv(cdir, imageID, args)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Enter failed: %v\n", err)
+		fmt.Fprintf(stderr, "Enter failed: %v\n", err)
 		return 1
 	}
 
 	err = stage0.Enter(cdir, imageID, argv)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Enter failed: %v\n", err)
+		fmt.Fprintf(stderr, "Enter failed: %v\n", err)
 		return 1
 	}
 	// not reached when stage0.Enter execs /enter
@@ -127,9 +127,9 @@ func getAppImageID(cdir string) (*types.Hash, error) {
 	default:
 	}
 
-	fmt.Fprintf(os.Stderr, "Container contains multiple apps:\n")
+	fmt.Fprintf(stderr, "Container contains multiple apps:\n")
 	for _, ra := range m.Apps {
-		fmt.Fprintf(os.Stderr, "\t%s: %s\n", types.ShortHash(ra.ImageID.String()), ra.Name.String())
+		fmt.Fprintf(stderr, "\t%s: %s\n", types.ShortHash(ra.ImageID.String()), ra.Name.String())
 	}
 
 	return nil, fmt.Errorf("specify app using \"rkt enter --imageid ...\"")
@@ -158,7 +158,7 @@ func pingContainer(cdir string) error {
 func getEnterArgv(cdir string, imageID *types.Hash, cmdArgs []string) ([]string, error) {
 	var argv []string
 	if len(cmdArgs) < 2 {
-		fmt.Printf("No command specified, assuming %q\n", defaultCmd)
+		fmt.Fprintf(stderr, "No command specified, assuming %q\n", defaultCmd)
 		argv = []string{defaultCmd}
 	} else {
 		argv = cmdArgs[1:]
@@ -0,0 +1,179 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//+build linux
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/appc/spec/schema/types"
+	"github.com/coreos/rocket/pkg/events"
+	"github.com/coreos/rocket/pkg/pod"
+)
+
+const cmdEventsName = "events"
+
+var (
+	cmdEvents = &Command{
+		Name:    cmdEventsName,
+		Summary: "Print the lifecycle events of the containers of the host",
+		Usage:   "[--since=DURATION|TIME] [--follow]",
+		Description: `Prints the events recorded in the event journal of the data directory, oldest
+first: containers prepared (prepare), about to run (run), the exit codes of
+their apps (app-exit) once they exited (exit), and containers removed by gc or
+rm (gc). --since only prints the events of the last DURATION, e.g. 1h, or since
+TIME, in RFC 3339. --follow then prints the events as they are recorded, until
+interrupted.
+
+The exit of containers sharing the network of the host is only recorded when
+gc collects them. With --format=json, each event is printed as a JSON object
+on a line of its own.`,
+		Formats: []string{formatJSON, formatRaw},
+		Run:     runEvents,
+	}
+	flagEventsSince  string
+	flagEventsFollow bool
+)
+
+func init() {
+	commands = append(commands, cmdEvents)
+	cmdEvents.Flags.StringVar(&flagEventsSince, "since", "", "only print the events of the last DURATION, or since TIME in RFC 3339")
+	cmdEvents.Flags.BoolVar(&flagEventsFollow, "follow", false, "print the events as they are recorded, until interrupted")
+}
+
+func runEvents(args []string) (exit int) {
+	if len(args) != 0 {
+		printCommandUsageByName(cmdEventsName)
+		return 1
+	}
+	since, err := parseSince(flagEventsSince)
+	if err != nil {
+		fmt.Fprintf(stderr, "Invalid --since: %v\n", err)
+		return 1
+	}
+
+	if !flagEventsFollow {
+		evs, err := events.Read(globalFlags.Dir, since)
+		if err != nil {
+			fmt.Fprintf(stderr, "Unable to read events: %v\n", err)
+			return 1
+		}
+		for _, e := range evs {
+			if err := printEvent(e); err != nil {
+				fmt.Fprintf(stderr, "%v\n", err)
+				return 1
+			}
+		}
+		return 0
+	}
+
+	ctx, stop := interruptContext()
+	defer stop()
+	if err := events.Follow(ctx, globalFlags.Dir, since, printEvent); err != nil {
+		fmt.Fprintf(stderr, "Unable to follow events: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// parseSince parses --since, a duration back from now or a time, the zero
+// time if empty
+func parseSince(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%q is neither a duration nor a time in RFC 3339", s)
+	}
+	return t, nil
+}
+
+// printEvent prints e on a line, as --format says
+func printEvent(e events.Event) error {
+	code := ""
+	if e.ExitCode != nil {
+		code = fmt.Sprint(*e.ExitCode)
+	}
+	switch globalFlags.Format {
+	case formatJSON:
+		b, err := json.Marshal(e)
+		if err != nil {
+			return fmt.Errorf("error marshalling event: %v", err)
+		}
+		fmt.Fprintln(stdout, string(b))
+	case formatRaw:
+		printRaw(e.Time.Format(time.RFC3339Nano), e.Type, e.Pod, e.App, code)
+	default:
+		fields := []string{e.Time.Local().Format(time.RFC3339), string(e.Type), e.Pod}
+		if e.App != "" {
+			fields = append(fields, e.App, code)
+		}
+		fmt.Fprintln(stdout, strings.Join(fields, " "))
+	}
+	return nil
+}
+
+// recordEvent records an event of the given type for the container uuid in
+// the event journal. Failing to is no reason to fail the command.
+func recordEvent(typ events.Type, uuid string) {
+	if err := events.Record(globalFlags.Dir, events.Event{Type: typ, Pod: uuid}); err != nil {
+		fmt.Fprintf(stderr, "Warning: unable to record %s event of container %q: %v\n", typ, uuid, err)
+	}
+}
+
+// recordMissedExits records the exit of the given containers, moved to
+// the garbage directory, whose stage1 couldn't record it, i.e. those the
+// journal has no exit event for.
+func recordMissedExits(uuids []string) {
+	if len(uuids) == 0 {
+		return
+	}
+	evs, err := events.Read(globalFlags.Dir, time.Time{})
+	if err != nil {
+		fmt.Fprintf(stderr, "Unable to read events: %v\n", err)
+		return
+	}
+	exited := make(map[string]bool)
+	for _, e := range evs {
+		if e.Type == events.Exit {
+			exited[e.Pod] = true
+		}
+	}
+	for _, uuid := range uuids {
+		if exited[uuid] {
+			continue
+		}
+		containerUUID, err := types.NewUUID(uuid)
+		if err != nil {
+			continue
+		}
+		p, err := pod.Open(globalFlags.Dir, *containerUUID)
+		if err == nil {
+			err = events.RecordExit(globalFlags.Dir, p)
+			p.Close()
+		}
+		if err != nil {
+			fmt.Fprintf(stderr, "Warning: unable to record the exit of container %q: %v\n", uuid, err)
+		}
+	}
+}
@@ -16,150 +16,60 @@ package main
 
 import (
 	"fmt"
-	"net/url"
-	"os"
-	"runtime"
-	"strings"
 
-	"github.com/coreos/rocket/cas"
-	"github.com/coreos/rocket/pkg/keystore"
+	"github.com/coreos/rocket/rkt/image"
 
-	"github.com/appc/spec/discovery"
 	"github.com/appc/spec/schema/types"
 )
 
-const (
-	defaultOS   = runtime.GOOS
-	defaultArch = runtime.GOARCH
-)
-
 var (
-	cmdFetch = &Command{
+	flagQuiet bool
+	cmdFetch  = &Command{
 		Name:    "fetch",
 		Summary: "Fetch image(s) and store them in the local cache",
-		Usage:   "IMAGE_URL...",
-		Run:     runFetch,
+		Usage:   "[--quiet] IMAGE_URL...",
+		Description: `The hashes of the images are printed on stdout once fetched, so that e.g.
+IMG=$(rkt fetch URL) works. Progress is reported on stderr, unless --quiet is given.`,
+		Run: runFetch,
 	}
 )
 
 func init() {
 	commands = append(commands, cmdFetch)
+	cmdFetch.Flags.BoolVar(&flagQuiet, "quiet", false, "don't report progress on stderr, only print the hashes of the images")
 }
 
 func runFetch(args []string) (exit int) {
 	if len(args) < 1 {
-		fmt.Fprintf(os.Stderr, "fetch: Must provide at least one image\n")
+		fmt.Fprintf(stderr, "fetch: Must provide at least one image\n")
 		return 1
 	}
 
-	ds := cas.NewStore(globalFlags.Dir)
-	ks := getKeystore()
-	for _, img := range args {
-		hash, err := fetchImage(img, ds, ks)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "%v\n", err)
-			return 1
-		}
-		shortHash := types.ShortHash(hash)
-		fmt.Println(shortHash)
-	}
-
-	return
-}
-
-// fetchImage will take an image as either a URL or a name string and import it
-// into the store if found.
-func fetchImage(img string, ds *cas.Store, ks *keystore.Keystore) (string, error) {
-	u, err := url.Parse(img)
-	if err == nil && u.Scheme == "" {
-		if app := newDiscoveryApp(img); app != nil {
-			fmt.Printf("rkt: starting to discover app img %s\n", img)
-			ep, err := discovery.DiscoverEndpoints(*app, true)
-			if err != nil {
-				return "", err
-			}
-			return fetchImageFromEndpoints(ep, ds, ks)
-		}
-	}
+	ds, err := getStore()
 	if err != nil {
-		return "", fmt.Errorf("not a valid URL (%s)", img)
-	}
-	if u.Scheme != "http" && u.Scheme != "https" {
-		return "", fmt.Errorf("rkt only supports http or https URLs (%s)", img)
+		fmt.Fprintf(stderr, "%v\n", err)
+		return 1
 	}
-	return fetchImageFromURL(u.String(), ds, ks)
-}
-
-func fetchImageFromEndpoints(ep *discovery.Endpoints, ds *cas.Store, ks *keystore.Keystore) (string, error) {
-	rem := cas.NewRemote(ep.ACIEndpoints[0].ACI, ep.ACIEndpoints[0].Sig)
-	return downloadImage(rem, ds, ks)
-}
-
-func fetchImageFromURL(imgurl string, ds *cas.Store, ks *keystore.Keystore) (string, error) {
-	rem := cas.NewRemote(imgurl, sigURLFromImgURL(imgurl))
-	return downloadImage(rem, ds, ks)
-}
-
-func downloadImage(rem *cas.Remote, ds *cas.Store, ks *keystore.Keystore) (string, error) {
-	fmt.Printf("rkt: starting to fetch img from %s\n", rem.ACIURL)
-	if globalFlags.InsecureSkipVerify {
-		fmt.Printf("rkt: warning: signature verification has been disabled\n")
+	r, err := getResolver(ds)
+	if err != nil {
+		fmt.Fprintf(stderr, "%v\n", err)
+		return 1
 	}
-	err := ds.ReadIndex(rem)
-	if err != nil && rem.BlobKey == "" {
-		entity, aciFile, err := rem.Download(*ds, ks)
+	ctx, stop := interruptContext()
+	defer stop()
+	for _, img := range args {
+		hash, err := r.FetchImage(ctx, img)
 		if err != nil {
-			return "", err
-		}
-		defer os.Remove(aciFile.Name())
-
-		if !globalFlags.InsecureSkipVerify {
-			fmt.Println("rkt: signature verified signed by: ")
-			for _, v := range entity.Identities {
-				fmt.Printf("  %s\n", v.Name)
-			}
+			fmt.Fprintf(stderr, "%v\n", err)
+			return 1
 		}
-		rem, err = rem.Store(*ds, aciFile)
-		if err != nil {
-			return "", err
+		if err := r.ResolveDependencies(ctx, hash, image.Dependencies{}); err != nil {
+			fmt.Fprintf(stderr, "%v\n", err)
+			return 1
 		}
+		shortHash := types.ShortHash(hash)
+		fmt.Fprintln(stdout, shortHash)
 	}
-	return rem.BlobKey, nil
-}
-
-func validateURL(s string) error {
-	u, err := url.Parse(s)
-	if err != nil {
-		return fmt.Errorf("discovery: fetched URL (%s) is invalid (%v)", s, err)
-	}
-	if u.Scheme != "http" && u.Scheme != "https" {
-		return fmt.Errorf("rkt only supports http or https URLs (%s)", s)
-	}
-	return nil
-}
 
-func sigURLFromImgURL(imgurl string) string {
-	s := strings.TrimSuffix(imgurl, ".aci")
-	return s + ".sig"
-}
-
-// newDiscoveryApp creates a discovery app if the given img is an app name and
-// has a URL-like structure, for example example.com/reduce-worker.
-// Or it returns nil.
-func newDiscoveryApp(img string) *discovery.App {
-	app, err := discovery.NewAppFromString(img)
-	if err != nil {
-		return nil
-	}
-	u, err := url.Parse(app.Name.String())
-	if err != nil || u.Scheme != "" {
-		return nil
-	}
-	if _, ok := app.Labels["arch"]; !ok {
-		app.Labels["arch"] = defaultArch
-	}
-	if _, ok := app.Labels["os"]; !ok {
-		app.Labels["os"] = defaultOS
-	}
-	return app
+	return
 }
@@ -0,0 +1,93 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//+build linux
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/appc/spec/schema"
+	rktpath "github.com/coreos/rocket/path"
+)
+
+// containerFilter selects the containers the commands given --all act on,
+// by the names of their apps and their annotations. A container must match
+// every filter given.
+type containerFilter struct {
+	apps        stringList
+	annotations stringList
+}
+
+func (f *containerFilter) addFlags(fs *flag.FlagSet) {
+	fs.Var(&f.apps, "app", "with --all, only the containers running an app of this name (may be given more than once)")
+	fs.Var(&f.annotations, "annotation", "with --all, only the containers annotated NAME=VALUE (may be given more than once)")
+}
+
+func (f *containerFilter) empty() bool {
+	return len(f.apps) == 0 && len(f.annotations) == 0
+}
+
+// check checks the filters are well-formed
+func (f *containerFilter) check() error {
+	for _, a := range f.annotations {
+		if i := strings.Index(a, "="); i <= 0 {
+			return fmt.Errorf("invalid annotation filter %q, want NAME=VALUE", a)
+		}
+	}
+	return nil
+}
+
+// match reports whether the container of manifest cm passes the filters
+func (f *containerFilter) match(cm *schema.ContainerRuntimeManifest) bool {
+	for _, name := range f.apps {
+		found := false
+		for _, ra := range cm.Apps {
+			if ra.Name.String() == name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	for _, a := range f.annotations {
+		kv := strings.SplitN(a, "=", 2)
+		if v, ok := cm.Annotations.Get(kv[0]); !ok || v != kv[1] {
+			return false
+		}
+	}
+	return true
+}
+
+// matchDir reports whether the container in cdir passes the filters
+func (f *containerFilter) matchDir(cdir string) (bool, error) {
+	if f.empty() {
+		return true, nil
+	}
+	b, err := ioutil.ReadFile(rktpath.ContainerManifestPath(cdir))
+	if err != nil {
+		return false, fmt.Errorf("error reading container manifest: %v", err)
+	}
+	cm := schema.ContainerRuntimeManifest{}
+	if err := cm.UnmarshalJSON(b); err != nil {
+		return false, fmt.Errorf("error loading container manifest: %v", err)
+	}
+	return f.match(&cm), nil
+}
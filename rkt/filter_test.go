@@ -0,0 +1,68 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//+build linux
+
+package main
+
+import (
+	"testing"
+
+	"github.com/appc/spec/schema"
+	"github.com/appc/spec/schema/types"
+)
+
+func TestContainerFilter(t *testing.T) {
+	cm := &schema.ContainerRuntimeManifest{
+		Apps: []schema.RuntimeApp{
+			{Name: "example.com/db"},
+			{Name: "example.com/web"},
+		},
+		Annotations: types.Annotations{
+			{Name: "example.com/owner", Value: "team-a"},
+		},
+	}
+
+	tests := []struct {
+		apps        stringList
+		annotations stringList
+
+		match bool
+		err   bool
+	}{
+		{nil, nil, true, false},
+		{stringList{"example.com/db"}, nil, true, false},
+		{stringList{"example.com/db", "example.com/web"}, nil, true, false},
+		{stringList{"example.com/db", "example.com/cache"}, nil, false, false},
+		{nil, stringList{"example.com/owner=team-a"}, true, false},
+		{nil, stringList{"example.com/owner=team-b"}, false, false},
+		{nil, stringList{"example.com/zone=us-east-1b"}, false, false},
+		{stringList{"example.com/web"}, stringList{"example.com/owner=team-a"}, true, false},
+		{nil, stringList{"example.com/owner"}, false, true},
+		{nil, stringList{"=team-a"}, false, true},
+	}
+	for i, tt := range tests {
+		f := containerFilter{apps: tt.apps, annotations: tt.annotations}
+		if err := f.check(); (err != nil) != tt.err {
+			t.Errorf("#%d: got error %v, want error %t", i, err, tt.err)
+			continue
+		}
+		if tt.err {
+			continue
+		}
+		if m := f.match(cm); m != tt.match {
+			t.Errorf("#%d: got match %t, want %t", i, m, tt.match)
+		}
+	}
+}
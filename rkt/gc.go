@@ -15,26 +15,45 @@
 package main
 
 import (
+	"bufio"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
+	"github.com/appc/spec/schema/types"
+	"github.com/coreos/rocket/metadatasvc"
+	"github.com/coreos/rocket/networking"
+	"github.com/coreos/rocket/networking/ipam"
+	rktpath "github.com/coreos/rocket/path"
+	"github.com/coreos/rocket/pkg/cgroup"
+	"github.com/coreos/rocket/pkg/events"
 	"github.com/coreos/rocket/pkg/lock"
+	"github.com/coreos/rocket/pkg/quota"
+	"github.com/coreos/rocket/pkg/verity"
+	"github.com/coreos/rocket/pkg/zfs"
+	"github.com/coreos/rocket/volume"
 )
 
 const (
-	defaultGracePeriod = 30 * time.Minute
+	defaultGracePeriod        = 30 * time.Minute
+	defaultPreparedExpiration = 24 * time.Hour
 )
 
 var (
-	flagGracePeriod time.Duration
-	cmdGC           = &Command{
+	flagGracePeriod        time.Duration
+	flagPreparedExpiration time.Duration
+	flagAuditRetention     time.Duration
+	cmdGC                  = &Command{
 		Name:    "gc",
 		Summary: "Garbage-collect rkt containers no longer in use",
-		Usage:   "[--grace-period=duration]",
+		Usage:   "[--grace-period=duration] [--expire-prepared=duration] [--audit-retention=duration]",
 		Run:     runGC,
 	}
 )
@@ -42,39 +61,66 @@ var (
 func init() {
 	commands = append(commands, cmdGC)
 	cmdGC.Flags.DurationVar(&flagGracePeriod, "grace-period", defaultGracePeriod, "duration to wait before discarding inactive containers from garbage")
+	cmdGC.Flags.DurationVar(&flagPreparedExpiration, "expire-prepared", defaultPreparedExpiration, "duration to wait before discarding prepared containers which were never run")
+	cmdGC.Flags.DurationVar(&flagAuditRetention, "audit-retention", defaultAuditRetention, "duration to keep the provenance of garbage collected containers for (0 keeps it forever)")
 }
 
 func runGC(args []string) (exit int) {
 	if err := os.MkdirAll(garbageDir(), 0755); err != nil {
-		fmt.Fprintf(os.Stderr, "Unable to create garbage dir: %v\n", err)
+		fmt.Fprintf(stderr, "Unable to create garbage dir: %v\n", err)
 		return 1
 	}
 
 	cs, err := getContainers()
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Unable to get containers list: %v\n", err)
+		fmt.Fprintf(stderr, "Unable to get containers list: %v\n", err)
 		return 1
 	}
+	var moved []string
 	for _, c := range cs {
 		cp := filepath.Join(containersDir(), c)
 		l, err := lock.TryExclusiveLock(cp)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Unable to open lock, ignoring %q: %v\n", c, err)
+			fmt.Fprintf(stderr, "Unable to open lock, ignoring %q: %v\n", c, err)
 			continue
 		}
 
-		fmt.Printf("Moving container %q to garbage\n", c)
-		err = os.Rename(cp, filepath.Join(garbageDir(), c))
+		fmt.Fprintf(stderr, "Moving container %q to garbage\n", c)
+		gp := filepath.Join(garbageDir(), c)
+		err = os.Rename(cp, gp)
 		if err != nil {
-			fmt.Println(err)
+			fmt.Fprintln(stderr, err)
+		} else {
+			// the addresses are released right away rather than
+			// after the grace period
+			teardownExitedNet(gp, c)
+			moved = append(moved, c)
 		}
 		l.Close()
 	}
+	recordMissedExits(moved)
+
+	if err := expirePrepared(flagPreparedExpiration); err != nil {
+		fmt.Fprintf(stderr, "Unable to expire prepared containers: %v\n", err)
+	}
 
 	// clean up anything old in the garbage dir
 	err = emptyGarbage(flagGracePeriod)
 	if err != nil {
-		fmt.Println(err)
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+
+	if err := removeUnusedTrees(); err != nil {
+		fmt.Fprintf(stderr, "Unable to remove the unused trees of the tree store: %v\n", err)
+	}
+
+	if err := releaseOrphanedIPs(flagGracePeriod); err != nil {
+		fmt.Fprintf(stderr, "Unable to release the addresses of removed containers: %v\n", err)
+	}
+
+	if err := pruneAudit(flagAuditRetention); err != nil {
+		fmt.Fprintf(stderr, "Unable to prune the audit archive: %v\n", err)
 		return 1
 	}
 
@@ -91,7 +137,7 @@ func getContainers() ([]string, error) {
 	var cs []string
 	for _, dir := range ls {
 		if !dir.IsDir() {
-			fmt.Fprintf(os.Stderr, "Unrecognized file: %q, ignoring\n", dir)
+			fmt.Fprintf(stderr, "Unrecognized file: %q, ignoring\n", dir)
 			continue
 		}
 		cs = append(cs, dir.Name())
@@ -99,6 +145,205 @@ func getContainers() ([]string, error) {
 	return cs, nil
 }
 
+// expirePrepared moves the containers prepared longer than expiration ago
+// to garbageDir(), releasing their network if it was set up.
+func expirePrepared(expiration time.Duration) error {
+	ls, err := ioutil.ReadDir(preparedDir())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, dir := range ls {
+		pp := filepath.Join(preparedDir(), dir.Name())
+		st := &syscall.Stat_t{}
+		if err := syscall.Lstat(pp, st); err != nil {
+			continue
+		}
+		if time.Now().Before(time.Unix(st.Ctim.Unix()).Add(expiration)) {
+			continue
+		}
+		// a locked container is being run
+		l, err := lock.TryExclusiveLock(pp)
+		if err != nil {
+			continue
+		}
+
+		fmt.Fprintf(stderr, "Moving expired prepared container %q to garbage\n", dir.Name())
+		if err := teardownPreparedNet(pp, dir.Name()); err != nil {
+			fmt.Fprintf(stderr, "Unable to release the network of container %q: %v\n", dir.Name(), err)
+		}
+		if err := os.Rename(pp, filepath.Join(garbageDir(), dir.Name())); err != nil {
+			fmt.Fprintln(stderr, err)
+		}
+		l.Close()
+	}
+	return nil
+}
+
+// teardownPreparedNet releases the network set up for the container
+// prepared in dir, if any.
+func teardownPreparedNet(dir, uuid string) error {
+	if _, err := os.Stat(filepath.Join(dir, networking.NetInfoFile)); os.IsNotExist(err) {
+		return nil
+	}
+	containerUUID, err := types.NewUUID(uuid)
+	if err != nil {
+		return err
+	}
+
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	n, err := networking.Load(dir, *containerUUID)
+	if err != nil {
+		return err
+	}
+	n.Teardown()
+	return nil
+}
+
+// teardownExitedNet tears down what is left of the networking of the
+// container in dir if it exited without tearing it down, e.g. because
+// stage1 died: the container is detached from its nets, releasing its
+// addresses, and the port forwards, masquerading rules and host interfaces
+// set up for it are removed. Failures are reported, not returned, so that
+// as much as possible is cleaned up.
+func teardownExitedNet(dir, uuid string) {
+	if containerUUID, err := types.NewUUID(uuid); err == nil {
+		if err := networking.TeardownNets(dir, *containerUUID); err != nil {
+			fmt.Fprintf(stderr, "Unable to detach container %q from its networks: %v\n", uuid, err)
+		}
+	}
+	if err := networking.TeardownPortForwards(dir); err != nil {
+		fmt.Fprintf(stderr, "Unable to remove the port forwards of container %q: %v\n", uuid, err)
+	}
+	if err := networking.TeardownIPMasq(dir); err != nil {
+		fmt.Fprintf(stderr, "Unable to remove the masquerading rules of container %q: %v\n", uuid, err)
+	}
+	if err := networking.TeardownHostLinks(uuid); err != nil {
+		fmt.Fprintf(stderr, "Unable to remove the network interfaces of container %q: %v\n", uuid, err)
+	}
+}
+
+// releaseOrphanedIPs releases the host-local addresses allocated to
+// containers which don't exist anymore, e.g. because their directory was
+// removed by hand before they were torn down. The allocations are shared
+// by the data directories of the host: the addresses of containers of other
+// data directories are kept while they run, i.e. have host links, and
+// addresses allocated less than gracePeriod ago are kept, their container
+// may still be setting up its network.
+func releaseOrphanedIPs(gracePeriod time.Duration) error {
+	links, err := networking.HostLinkStats()
+	if err != nil {
+		return err
+	}
+	released, err := ipam.ReleaseOrphans(func(l ipam.Lease) bool {
+		// not allocated by rkt
+		if _, err := types.NewUUID(l.ContID); err != nil {
+			return true
+		}
+		if time.Since(l.Time) < gracePeriod {
+			return true
+		}
+		if _, ok := links[l.ContID]; ok {
+			return true
+		}
+		for _, d := range []string{containersDir(), preparedDir(), garbageDir()} {
+			if _, err := os.Stat(filepath.Join(d, l.ContID)); !os.IsNotExist(err) {
+				return true
+			}
+		}
+		return false
+	})
+	for _, l := range released {
+		fmt.Fprintf(stderr, "Released IP %v of network %q, allocated to removed container %q\n", l.IP, l.Net, l.ContID)
+	}
+	return err
+}
+
+// removeUnusedTrees removes the trees of the tree store no container uses
+// anymore, including the prepared ones and those waiting in the garbage.
+func removeUnusedTrees() error {
+	ds, err := getStore()
+	if err != nil {
+		return err
+	}
+	l, err := ds.LockTrees(true)
+	if err == lock.ErrLocked {
+		// trees are being set up for containers, left for the next run
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+
+	used, err := usedTrees(containersDir(), preparedDir(), garbageDir())
+	if err != nil {
+		return err
+	}
+	if used == nil {
+		fmt.Fprintf(stderr, "Keeping all trees, some containers don't record the trees they use\n")
+		return nil
+	}
+	ids, err := ds.TreeIDs()
+	if err != nil {
+		return err
+	}
+	zids, err := ds.ZFSTreeIDs()
+	if err != nil {
+		return err
+	}
+	seen := make(map[string]bool)
+	for _, id := range append(ids, zids...) {
+		if used[id] || seen[id] {
+			continue
+		}
+		seen[id] = true
+		fmt.Fprintf(stderr, "Removing unused tree %q\n", id)
+		if err := ds.RemoveTree(id); err != nil {
+			fmt.Fprintf(stderr, "Unable to remove tree %q: %v\n", id, err)
+		}
+	}
+	return nil
+}
+
+// usedTrees returns the trees of the tree store used by the containers in
+// dirs. It returns nil if some container has app overlays but no record of
+// the trees it uses, having been set up before they were recorded: any
+// tree may be the lower layer of its overlays.
+func usedTrees(dirs ...string) (map[string]bool, error) {
+	used := make(map[string]bool)
+	for _, d := range dirs {
+		ls, err := ioutil.ReadDir(d)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, fi := range ls {
+			cp := filepath.Join(d, fi.Name())
+			trees, err := ioutil.ReadDir(rktpath.TreesPath(cp))
+			if os.IsNotExist(err) {
+				if _, err := os.Stat(rktpath.OverlaysPath(cp)); err == nil {
+					return nil, nil
+				}
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			for _, t := range trees {
+				used[t.Name()] = true
+			}
+		}
+	}
+	return used, nil
+}
+
 // emptyGarbage discards sufficiently aged containers from garbageDir()
 func emptyGarbage(gracePeriod time.Duration) error {
 	g := garbageDir()
@@ -114,7 +359,7 @@ func emptyGarbage(gracePeriod time.Duration) error {
 		err := syscall.Lstat(gp, st)
 		if err != nil {
 			if err != syscall.ENOENT {
-				fmt.Fprintf(os.Stderr, "Unable to stat %q, ignoring: %v\n", gp, err)
+				fmt.Fprintf(stderr, "Unable to stat %q, ignoring: %v\n", gp, err)
 			}
 			continue
 		}
@@ -125,12 +370,105 @@ func emptyGarbage(gracePeriod time.Duration) error {
 			if err != nil {
 				continue
 			}
-			fmt.Printf("Garbage collecting container %q\n", dir.Name())
-			if err = os.RemoveAll(gp); err != nil {
-				fmt.Fprintf(os.Stderr, "Unable to remove container %q: %v\n", dir.Name(), err)
-			}
+			fmt.Fprintf(stderr, "Garbage collecting container %q\n", dir.Name())
+			removeContainer(gp, dir.Name())
 			l.Close()
 		}
 	}
 	return nil
 }
+
+// removeContainer releases what the exited container in dir, locked
+// exclusively by the caller, holds outside of its directory and removes
+// it, once its provenance is archived. Failures are reported; it returns
+// whether the container was removed.
+func removeContainer(dir, uuid string) bool {
+	if err := archiveProvenance(dir, uuid); err != nil {
+		// the container is kept rather than losing its record
+		fmt.Fprintf(stderr, "Unable to archive the provenance of container %q, keeping it: %v\n", uuid, err)
+		return false
+	}
+	var err error
+	if err = verity.CloseAll(verity.DevicePrefix(uuid)); err != nil {
+		fmt.Fprintf(stderr, "Unable to release verity devices of container %q: %v\n", uuid, err)
+	}
+	if err = cgroup.RemoveFreezer(uuid); err != nil {
+		fmt.Fprintf(stderr, "Unable to remove the freezer cgroup of container %q: %v\n", uuid, err)
+	}
+	if err = cgroup.RemoveResources(uuid); err != nil {
+		fmt.Fprintf(stderr, "Unable to remove the resource cgroups of container %q: %v\n", uuid, err)
+	}
+	if err = quota.Clear(dir); err != nil {
+		fmt.Fprintf(stderr, "Unable to clear the disk quota of container %q: %v\n", uuid, err)
+	}
+	teardownExitedNet(dir, uuid)
+	if err = metadatasvc.UnregisterContainer(uuid); err != nil {
+		fmt.Fprintf(stderr, "Unable to unregister container %q from the metadata service: %v\n", uuid, err)
+	}
+	if err = volume.UnmountAll(dir); err != nil {
+		fmt.Fprintf(stderr, "Unable to release the volumes of container %q: %v\n", uuid, err)
+	}
+	if err = zfs.DestroyUnder(dir); err != nil {
+		fmt.Fprintf(stderr, "Unable to destroy the ZFS clones of container %q: %v\n", uuid, err)
+	}
+	if err = unmountAll(dir); err != nil {
+		fmt.Fprintf(stderr, "Unable to unmount the overlays of container %q: %v\n", uuid, err)
+	}
+	if err = os.RemoveAll(dir); err != nil {
+		fmt.Fprintf(stderr, "Unable to remove container %q: %v\n", uuid, err)
+		return false
+	}
+	recordEvent(events.GC, uuid)
+	return true
+}
+
+// unmountAll unmounts everything mounted below dir, e.g. app rootfs
+// overlays from the tree store, deepest first.
+func unmountAll(dir string) error {
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var mps []string
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		// the mount point is the fifth field, with spaces and such escaped
+		// in octal
+		fields := strings.Fields(s.Text())
+		if len(fields) < 5 {
+			continue
+		}
+		mp := unescapeMountPoint(fields[4])
+		if strings.HasPrefix(mp, dir+"/") {
+			mps = append(mps, mp)
+		}
+	}
+	if err := s.Err(); err != nil {
+		return err
+	}
+
+	sort.Sort(sort.Reverse(sort.StringSlice(mps)))
+	for _, mp := range mps {
+		if err := syscall.Unmount(mp, 0); err != nil {
+			return fmt.Errorf("error unmounting %q: %v", mp, err)
+		}
+	}
+	return nil
+}
+
+func unescapeMountPoint(s string) string {
+	var b []byte
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) {
+			if c, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b = append(b, byte(c))
+				i += 3
+				continue
+			}
+		}
+		b = append(b, s[i])
+	}
+	return string(b)
+}
@@ -0,0 +1,92 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	rktpath "github.com/coreos/rocket/path"
+)
+
+func TestUnescapeMountPoint(t *testing.T) {
+	tests := []struct {
+		in  string
+		out string
+	}{
+		{"/var/lib/rkt/garbage/abc/overlay", "/var/lib/rkt/garbage/abc/overlay"},
+		{"/mnt/with\\040space", "/mnt/with space"},
+		{"/mnt/tab\\011and\\134backslash", "/mnt/tab\tand\\backslash"},
+		{"/mnt/trailing\\04", "/mnt/trailing\\04"},
+	}
+	for i, tt := range tests {
+		if g := unescapeMountPoint(tt.in); g != tt.out {
+			t.Errorf("#%d: got %q, want %q", i, g, tt.out)
+		}
+	}
+}
+
+func TestUsedTrees(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gc")
+	if err != nil {
+		t.Fatalf("error creating tempdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	running, garbage := filepath.Join(dir, "containers"), filepath.Join(dir, "garbage")
+	for _, tt := range []struct {
+		c     string
+		trees []string
+	}{
+		{filepath.Join(running, "c1"), []string{"sha512-a", "sha512-b"}},
+		{filepath.Join(garbage, "c2"), []string{"sha512-b", "sha512-c"}},
+		{filepath.Join(garbage, "c3"), nil},
+	} {
+		if err := os.MkdirAll(tt.c, 0755); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		for _, id := range tt.trees {
+			if err := os.MkdirAll(rktpath.TreesPath(tt.c), 0755); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if err := ioutil.WriteFile(filepath.Join(rktpath.TreesPath(tt.c), id), nil, 0644); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		}
+	}
+
+	used, err := usedTrees(running, filepath.Join(dir, "prepared"), garbage)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := map[string]bool{"sha512-a": true, "sha512-b": true, "sha512-c": true}
+	if !reflect.DeepEqual(used, want) {
+		t.Errorf("got used trees %v, want %v", used, want)
+	}
+
+	// overlays without a record of their trees may use any of them
+	if err := os.MkdirAll(rktpath.OverlaysPath(filepath.Join(garbage, "c3")), 0755); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	used, err = usedTrees(running, garbage)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if used != nil {
+		t.Errorf("got used trees %v, want all trees kept", used)
+	}
+}
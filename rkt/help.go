@@ -17,7 +17,6 @@ package main
 import (
 	"flag"
 	"fmt"
-	"os"
 	"strings"
 	"text/template"
 
@@ -40,6 +39,7 @@ var (
 			// trim leading/trailing whitespace and split into slice of lines
 			return strings.Split(strings.Trim(s, "\n\t "), "\n")
 		},
+		"join": strings.Join,
 		"printOption": func(name, defvalue, usage string) string {
 			prefix := "--"
 			if len(name) == 1 {
@@ -84,6 +84,9 @@ DESCRIPTION:
 {{if .CmdFlags}}OPTIONS:{{range .CmdFlags}}
 {{printOption .Name .DefValue .Usage}}{{end}}
 
+{{end}}{{if .Cmd.Formats}}FORMATS:
+{{printf "\t--format=%s" (join .Cmd.Formats "|")}}
+
 {{end}}For help on global options run "{{.Executable}} help"
 `[1:]))
 }
@@ -96,7 +99,7 @@ func runHelp(args []string) (exit int) {
 
 	if err := printCommandUsageByName(args[0]); err != nil {
 		printGlobalUsage()
-		fmt.Fprintf(os.Stderr, "\nHelp error: %v\n", err)
+		fmt.Fprintf(stderr, "\nHelp error: %v\n", err)
 		return 1
 	}
 	return
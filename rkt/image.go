@@ -0,0 +1,496 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"archive/tar"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/appc/spec/aci"
+	"github.com/coreos/rocket/cas"
+	"github.com/coreos/rocket/pkg/quota"
+	ptar "github.com/coreos/rocket/pkg/tar"
+)
+
+const (
+	cmdImageName = "image"
+
+	// maxSymlinks bounds the symlinks followed by image cat, as the
+	// kernel does
+	maxSymlinks = 40
+)
+
+var (
+	cmdImage = &Command{
+		Name:    cmdImageName,
+		Summary: "Inspect the files of images in the store",
+		Usage:   "cat HASH PATH | ls HASH PATH | export HASH | verify [--io-limit=RATE] [--older-than=DURATION] [--repair] [--signature-only] --all|HASH...",
+		Formats: []string{formatJSON, formatRaw},
+		Description: `cat prints the file at PATH in the rootfs of the stored image HASH, following
+symlinks, e.g. "rkt image cat sha512-0c45e8c0ab2 /etc/os-release". ls lists the
+directory at PATH, or the file alone if it isn't one. The image is streamed from
+the store rather than extracted; the files of its dependencies aren't searched.
+With --format=raw, the modification times of the files are given in RFC 3339
+and the targets of symlinks in a column of their own.
+
+export writes the stored image HASH to stdout as an ACI, e.g.
+"rkt image export sha512-0c45e8c0ab2 > app.aci"; images of encrypted stores are
+decrypted.
+
+verify hashes the given images again, or with --all every image and rendered tree
+of the store, and prints those whose content doesn't match their digest. Reads
+are throttled to --io-limit bytes per second, and with --older-than what was
+verified more recently is skipped, so that e.g. a daily timer spreads a slow
+sweep of the store over days. --repair sets corrupt trees aside, to be rendered
+again when next used; corrupt images must be fetched again.
+
+verify --signature-only reads no image, it only checks that the keys the images
+were signed with, as recorded when their signatures were verified as they were
+fetched, are still trusted for their names, and prints those whose key isn't
+(untrusted) or whose signature was never verified (unsigned). The results are
+kept until the trusted keys change.`,
+		Run: runImage,
+	}
+	imageVerifyFlags = flag.NewFlagSet("verify", flag.ContinueOnError)
+	flagVerifyAll    bool
+	flagIOLimit      string
+	flagOlderThan    time.Duration
+	flagRepair       bool
+	flagSigOnly      bool
+)
+
+func init() {
+	commands = append(commands, cmdImage)
+	imageVerifyFlags.SetOutput(stderr)
+	imageVerifyFlags.BoolVar(&flagVerifyAll, "all", false, "verify every image and rendered tree of the store")
+	imageVerifyFlags.StringVar(&flagIOLimit, "io-limit", "", "read no more than this many bytes per second (e.g. 10M)")
+	imageVerifyFlags.DurationVar(&flagOlderThan, "older-than", 0, "with --all, skip what was verified less than this long ago")
+	imageVerifyFlags.BoolVar(&flagRepair, "repair", false, "set corrupt trees aside, to be rendered again when next used")
+	imageVerifyFlags.BoolVar(&flagSigOnly, "signature-only", false, "only check that the keys the images were signed with are still trusted, without reading them")
+}
+
+func runImage(args []string) (exit int) {
+	if len(args) > 0 && args[0] == "verify" {
+		return runImageVerify(args[1:])
+	}
+	if len(args) == 2 && args[0] == "export" {
+		return runImageExport(args[1])
+	}
+	if len(args) != 3 || (args[0] != "cat" && args[0] != "ls") {
+		printCommandUsageByName(cmdImageName)
+		return 1
+	}
+	if args[0] == "cat" && globalFlags.Format != "" {
+		fmt.Fprintf(stderr, "%s %s cat prints files as they are, it has no --format\n", cliName, cmdImageName)
+		return 1
+	}
+
+	ds, err := getStore()
+	if err != nil {
+		fmt.Fprintf(stderr, "%v\n", err)
+		return 1
+	}
+	key, err := ds.ResolveKey(args[1])
+	if err != nil {
+		fmt.Fprintf(stderr, "Unable to find image %q: %v\n", args[1], err)
+		return 1
+	}
+	p := path.Clean("/" + args[2])
+
+	if args[0] == "cat" {
+		err = catImageFile(ds, key, p, stdout)
+	} else {
+		err = listImageDir(ds, key, p)
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "%s: %v\n", p, err)
+		return 1
+	}
+	return 0
+}
+
+// runImageExport writes the image key, which may be abbreviated, to
+// stdout
+func runImageExport(key string) (exit int) {
+	if globalFlags.Format != "" {
+		fmt.Fprintf(stderr, "%s %s export writes images as they are, it has no --format\n", cliName, cmdImageName)
+		return 1
+	}
+	ds, err := getStore()
+	if err != nil {
+		fmt.Fprintf(stderr, "%v\n", err)
+		return 1
+	}
+	k, err := ds.ResolveKey(key)
+	if err != nil {
+		fmt.Fprintf(stderr, "Unable to find image %q: %v\n", key, err)
+		return 1
+	}
+	b, err := ds.OpenBlob(k)
+	if err != nil {
+		fmt.Fprintf(stderr, "Unable to open image %s: %v\n", k, err)
+		return 1
+	}
+	defer b.Close()
+	// straight from the store to stdout, with sendfile when it can be
+	if _, err := b.WriteTo(stdout); err != nil {
+		fmt.Fprintf(stderr, "Unable to export image %s: %v\n", k, err)
+		return 1
+	}
+	return 0
+}
+
+// rootfsPath returns the path in the image tarball of p, an absolute path
+// in its rootfs
+func rootfsPath(p string) string {
+	return path.Join(aci.RootfsDir, p)
+}
+
+// catImageFile copies the regular file at p in the rootfs of the image
+// stored under key to w. The image is read again for every symlink.
+func catImageFile(ds *cas.Store, key, p string, w io.Writer) error {
+	for i := 0; i < maxSymlinks; i++ {
+		rs, err := ds.ReadStream(key)
+		if err != nil {
+			return err
+		}
+		tr := tar.NewReader(rs)
+		hdr, err := ptar.FindFile(tr, rootfsPath(p))
+		if err != nil {
+			rs.Close()
+			return err
+		}
+		switch hdr.Typeflag {
+		case tar.TypeReg, tar.TypeRegA:
+			_, err = io.Copy(w, tr)
+			rs.Close()
+			return err
+		case tar.TypeSymlink:
+			target := hdr.Linkname
+			if !path.IsAbs(target) {
+				target = path.Join(path.Dir(p), target)
+			}
+			p = path.Clean("/" + target)
+		case tar.TypeLink:
+			// hard links name the path of their target in the tarball
+			p = path.Clean("/" + strings.TrimPrefix(path.Clean(hdr.Linkname), aci.RootfsDir))
+		default:
+			rs.Close()
+			return fmt.Errorf("not a regular file")
+		}
+		rs.Close()
+	}
+	return fmt.Errorf("too many levels of symbolic links")
+}
+
+// listImageDir prints the entries of the directory at p in the rootfs of
+// the image stored under key, ls -l style.
+func listImageDir(ds *cas.Store, key, p string) error {
+	rs, err := ds.ReadStream(key)
+	if err != nil {
+		return err
+	}
+	defer rs.Close()
+	hdrs, err := ptar.ListDir(tar.NewReader(rs), rootfsPath(p))
+	if err != nil {
+		return err
+	}
+	entries := []imageDirEntry{}
+	for _, hdr := range hdrs {
+		e := imageDirEntry{
+			Mode:    hdr.FileInfo().Mode().String(),
+			UID:     hdr.Uid,
+			GID:     hdr.Gid,
+			Size:    hdr.Size,
+			ModTime: hdr.ModTime,
+			Name:    path.Base(path.Clean(hdr.Name)),
+		}
+		if hdr.Typeflag == tar.TypeSymlink {
+			e.Target = hdr.Linkname
+		}
+		entries = append(entries, e)
+	}
+
+	switch globalFlags.Format {
+	case formatJSON:
+		return printJSON(entries)
+	case formatRaw:
+		for _, e := range entries {
+			printRaw(e.Mode, e.UID, e.GID, e.Size, e.ModTime.Format(time.RFC3339), e.Name, e.Target)
+		}
+		return nil
+	}
+	for _, e := range entries {
+		name := e.Name
+		if e.Target != "" {
+			name += " -> " + e.Target
+		}
+		fmt.Fprintf(out, "%s\t%d\t%d\t%d\t%s\t%s\n", e.Mode, e.UID, e.GID, e.Size, e.ModTime.Format(time.Stamp), name)
+	}
+	out.Flush()
+	return nil
+}
+
+// imageDirEntry is a file listed by image ls; Target is the target of
+// symlinks
+type imageDirEntry struct {
+	Mode    string    `json:"mode"`
+	UID     int       `json:"uid"`
+	GID     int       `json:"gid"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+	Name    string    `json:"name"`
+	Target  string    `json:"target,omitempty"`
+}
+
+// corruptEntry is an image or tree found corrupt by image verify
+type corruptEntry struct {
+	Kind string `json:"kind"`
+	ID   string `json:"id"`
+}
+
+// runImageVerify verifies images and trees of the store, returning 1 if
+// any is corrupt or can't be verified
+func runImageVerify(args []string) (exit int) {
+	if err := imageVerifyFlags.Parse(args); err != nil {
+		return 1
+	}
+	keys := imageVerifyFlags.Args()
+	if flagVerifyAll == (len(keys) > 0) {
+		printCommandUsageByName(cmdImageName)
+		return 1
+	}
+	if flagSigOnly && (flagIOLimit != "" || flagOlderThan > 0 || flagRepair) {
+		fmt.Fprintf(stderr, "--signature-only reads no image, it can't be combined with --io-limit, --older-than or --repair\n")
+		return 1
+	}
+	if flagSigOnly && globalFlags.InsecureSkipVerify {
+		fmt.Fprintf(stderr, "--signature-only checks the trusted keys, it can't be combined with --insecure-skip-verify\n")
+		return 1
+	}
+	var rate uint64
+	if flagIOLimit != "" {
+		var err error
+		if rate, err = quota.ParseSize(flagIOLimit); err != nil {
+			fmt.Fprintf(stderr, "Invalid --io-limit: %v\n", err)
+			return 1
+		}
+	}
+
+	ds, err := getStore()
+	if err != nil {
+		fmt.Fprintf(stderr, "%v\n", err)
+		return 1
+	}
+	verified, err := ds.LastVerified()
+	if err != nil {
+		fmt.Fprintf(stderr, "Unable to read when the store was last verified: %v\n", err)
+		return 1
+	}
+	ctx, stop := interruptContext()
+	defer stop()
+
+	var trees []string
+	if flagVerifyAll {
+		keys = ds.ImageKeys()
+		if trees, err = ds.TreeIDs(); err != nil {
+			fmt.Fprintf(stderr, "Unable to list trees: %v\n", err)
+			return 1
+		}
+	} else {
+		for i, k := range keys {
+			if keys[i], err = ds.ResolveKey(k); err != nil {
+				fmt.Fprintf(stderr, "Unable to find image %q: %v\n", k, err)
+				return 1
+			}
+		}
+	}
+	if flagSigOnly {
+		return verifySigners(ctx, ds, keys)
+	}
+
+	// with --format=json, the corrupt images and trees are printed once
+	// all were verified
+	corrupt := []corruptEntry{}
+	defer func() {
+		if globalFlags.Format == formatJSON && ctx.Err() == nil {
+			if err := printJSON(struct {
+				Corrupt []corruptEntry `json:"corrupt"`
+			}{corrupt}); err != nil {
+				fmt.Fprintf(stderr, "%v\n", err)
+				exit = 1
+			}
+		}
+	}()
+
+	// verify checks one image or tree, recording when it was verified
+	// after each so that an interrupted sweep resumes where it stopped
+	verify := func(kind, id, name string, check func() error) bool {
+		if flagVerifyAll && flagOlderThan > 0 && time.Since(verified[name]) < flagOlderThan {
+			return true
+		}
+		fmt.Fprintf(stderr, "Verifying %s %s\n", kind, id)
+		err := check()
+		switch {
+		case ctx.Err() != nil:
+			return false
+		case err == cas.ErrCorrupt:
+			switch globalFlags.Format {
+			case formatJSON:
+				corrupt = append(corrupt, corruptEntry{kind, id})
+			case formatRaw:
+				printRaw(kind, id)
+			default:
+				fmt.Fprintf(stdout, "corrupt %s %s\n", kind, id)
+			}
+			exit = 1
+			if kind == "tree" && flagRepair {
+				if aside, err := ds.SetTreeAside(id); err != nil {
+					fmt.Fprintf(stderr, "Unable to set tree %s aside: %v\n", id, err)
+				} else {
+					fmt.Fprintf(stderr, "Tree %s set aside in %s\n", id, aside)
+				}
+			}
+		case err != nil:
+			fmt.Fprintf(stderr, "Unable to verify %s %s: %v\n", kind, id, err)
+			exit = 1
+		default:
+			verified[name] = time.Now().UTC()
+			if err := ds.SetLastVerified(verified); err != nil {
+				fmt.Fprintf(stderr, "Unable to record when %s %s was verified: %v\n", kind, id, err)
+			}
+		}
+		return true
+	}
+	for _, k := range keys {
+		if !verify("image", k, k, func() error { return ds.VerifyImage(ctx, k, int64(rate)) }) {
+			return 1
+		}
+	}
+	for _, id := range trees {
+		if !verify("tree", id, "tree/"+id, func() error { return ds.VerifyTree(ctx, id, int64(rate)) }) {
+			return 1
+		}
+	}
+	return
+}
+
+// signerEntry is an image found unsigned or signed by a key which isn't
+// trusted anymore by image verify --signature-only
+type signerEntry struct {
+	ID     string `json:"id"`
+	Signer string `json:"signer,omitempty"`
+}
+
+// verifySigners checks that the keys the images stored under keys were
+// signed with are still trusted for their names, returning 1 if any isn't
+// or if any image was never verified. The results of the checks are
+// cached in the store until the keystore changes.
+func verifySigners(ctx context.Context, ds *cas.Store, keys []string) (exit int) {
+	ks := getKeystore()
+	digest, err := ks.Digest()
+	if err != nil {
+		fmt.Fprintf(stderr, "Unable to read the keystore: %v\n", err)
+		return 1
+	}
+	checks, err := ds.SignatureChecks()
+	if err != nil {
+		fmt.Fprintf(stderr, "Unable to read the signature checks: %v\n", err)
+		return 1
+	}
+	for name, c := range checks {
+		if c.Keystore != digest {
+			delete(checks, name)
+		}
+	}
+
+	unsigned := []signerEntry{}
+	untrusted := []signerEntry{}
+	report := func(problem string, e signerEntry) {
+		exit = 1
+		switch globalFlags.Format {
+		case formatJSON:
+			if problem == "unsigned" {
+				unsigned = append(unsigned, e)
+			} else {
+				untrusted = append(untrusted, e)
+			}
+		case formatRaw:
+			printRaw(problem, e.ID, e.Signer)
+		default:
+			if e.Signer != "" {
+				fmt.Fprintf(stdout, "%s image %s signed by %s\n", problem, e.ID, e.Signer)
+			} else {
+				fmt.Fprintf(stdout, "%s image %s\n", problem, e.ID)
+			}
+		}
+	}
+	for _, k := range keys {
+		if ctx.Err() != nil {
+			return 1
+		}
+		fp, err := ds.Signer(k)
+		if err != nil {
+			fmt.Fprintf(stderr, "Unable to verify image %s: %v\n", k, err)
+			exit = 1
+			continue
+		}
+		if fp == "" {
+			report("unsigned", signerEntry{ID: k})
+			continue
+		}
+		name := k + " " + fp
+		c, ok := checks[name]
+		if !ok {
+			im, err := ds.GetImageManifest(k)
+			if err != nil {
+				fmt.Fprintf(stderr, "Unable to verify image %s: %v\n", k, err)
+				exit = 1
+				continue
+			}
+			trusted, err := ks.TrustsKey(im.Name.String(), fp)
+			if err != nil {
+				fmt.Fprintf(stderr, "Unable to verify image %s: %v\n", k, err)
+				exit = 1
+				continue
+			}
+			c = cas.SignatureCheck{Trusted: trusted, Keystore: digest}
+			checks[name] = c
+		}
+		if !c.Trusted {
+			report("untrusted", signerEntry{ID: k, Signer: fp})
+		}
+	}
+	if err := ds.SetSignatureChecks(checks); err != nil {
+		fmt.Fprintf(stderr, "Unable to record the signature checks: %v\n", err)
+	}
+
+	if globalFlags.Format == formatJSON {
+		if err := printJSON(struct {
+			Unsigned  []signerEntry `json:"unsigned"`
+			Untrusted []signerEntry `json:"untrusted"`
+		}{unsigned, untrusted}); err != nil {
+			fmt.Fprintf(stderr, "%v\n", err)
+			return 1
+		}
+	}
+	return exit
+}
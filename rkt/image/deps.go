@@ -0,0 +1,116 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package image
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/appc/spec/discovery"
+	"github.com/appc/spec/schema/types"
+)
+
+// maxDependencyDepth bounds how deep dependencies may be nested
+const maxDependencyDepth = 32
+
+// Dependencies maps the image IDs of images to the image IDs of their direct
+// dependencies, in the order they are declared.
+type Dependencies map[string][]types.Hash
+
+// ResolveDependencies makes sure the dependencies declared by the image
+// stored under key, and recursively theirs, are available in the store.
+// Dependencies with an image ID already in the store are used as is, others
+// are discovered, downloaded and verified like any image given by name.
+// The dependency graph found is added to deps. Fetches are aborted once ctx
+// is done.
+func (r *Resolver) ResolveDependencies(ctx context.Context, key string, deps Dependencies) error {
+	return r.resolveDependencies(ctx, key, deps, nil)
+}
+
+func (r *Resolver) resolveDependencies(ctx context.Context, key string, deps Dependencies, path []string) error {
+	if _, ok := deps[key]; ok {
+		return nil
+	}
+	for _, k := range path {
+		if k == key {
+			return fmt.Errorf("dependency cycle involving image %s", key)
+		}
+	}
+	if len(path) >= maxDependencyDepth {
+		return fmt.Errorf("dependencies nested more than %d levels deep", maxDependencyDepth)
+	}
+
+	im, err := r.Store.GetImageManifest(key)
+	if err != nil {
+		return err
+	}
+	var direct []types.Hash
+	for _, dep := range im.Dependencies {
+		depKey, err := r.fetchDependency(ctx, dep)
+		if err != nil {
+			return fmt.Errorf("error resolving dependency %s of %s: %v", dep.App, im.Name, err)
+		}
+		if err := r.resolveDependencies(ctx, depKey, deps, append(path, key)); err != nil {
+			return err
+		}
+		direct = append(direct, *mustHash(depKey))
+	}
+	deps[key] = direct
+	return nil
+}
+
+// fetchDependency returns the store key of the image satisfying dep,
+// fetching it if needed.
+func (r *Resolver) fetchDependency(ctx context.Context, dep types.Dependency) (string, error) {
+	if dep.ImageID != nil {
+		if key, err := r.Store.ResolveKey(dep.ImageID.String()); err == nil {
+			r.record(key, Origin{Source: "store"})
+			return key, nil
+		}
+	}
+
+	app := &discovery.App{
+		Name:   dep.App,
+		Labels: make(map[string]string),
+	}
+	for _, l := range dep.Labels {
+		app.Labels[l.Name.String()] = l.Value
+	}
+	if _, ok := app.Labels["arch"]; !ok {
+		app.Labels["arch"] = defaultArch
+	}
+	if _, ok := app.Labels["os"]; !ok {
+		app.Labels["os"] = defaultOS
+	}
+	key, err := r.fetchImageFromApp(ctx, app)
+	if err != nil {
+		return "", err
+	}
+
+	if dep.ImageID != nil && !keyMatches(key, dep.ImageID.String()) {
+		return "", fmt.Errorf("fetched image %s does not match required image ID %s", key, dep.ImageID)
+	}
+	return key, nil
+}
+
+// keyMatches reports whether the store key, which holds a truncated hash,
+// belongs to the image with the given ID.
+func keyMatches(key, id string) bool {
+	if len(id) > len(key) {
+		id = id[:len(key)]
+	}
+	return strings.HasPrefix(key, id)
+}
@@ -0,0 +1,96 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package image
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/coreos/rocket/cas"
+	"github.com/coreos/rocket/pkg/util"
+)
+
+func importACI(t *testing.T, ds *cas.Store, dir, manifest string) string {
+	aci, err := util.NewACI(dir, manifest, nil)
+	if err != nil {
+		t.Fatalf("error creating ACI: %v", err)
+	}
+	defer aci.Close()
+	if _, err := aci.Seek(0, 0); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	key, err := ds.WriteACI(context.Background(), aci)
+	if err != nil {
+		t.Fatalf("error importing ACI: %v", err)
+	}
+	return key
+}
+
+func TestResolveDependencies(t *testing.T) {
+	dir, err := ioutil.TempDir("", "resolve-deps")
+	if err != nil {
+		t.Fatalf("error creating tempdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	ds := cas.NewStore(dir)
+	r := &Resolver{Store: ds}
+
+	base := importACI(t, ds, dir, `{"acKind":"ImageManifest","acVersion":"0.1.1","name":"example.com/base"}`)
+	lib := importACI(t, ds, dir, fmt.Sprintf(`{"acKind":"ImageManifest","acVersion":"0.1.1","name":"example.com/lib",
+		"dependencies":[{"app":"example.com/base","imageID":"%s"}]}`, base))
+	app := importACI(t, ds, dir, fmt.Sprintf(`{"acKind":"ImageManifest","acVersion":"0.1.1","name":"example.com/app",
+		"dependencies":[{"app":"example.com/lib","imageID":"%s"},{"app":"example.com/base","imageID":"%s"}]}`, lib, base))
+
+	deps := Dependencies{}
+	if err := r.ResolveDependencies(context.Background(), app, deps); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	want := Dependencies{
+		app:  {*mustHash(lib), *mustHash(base)},
+		lib:  {*mustHash(base)},
+		base: nil,
+	}
+	if !reflect.DeepEqual(deps, want) {
+		t.Errorf("got %v, want %v", deps, want)
+	}
+
+	// a dependency neither in the store nor discoverable is an error
+	missing := importACI(t, ds, dir, `{"acKind":"ImageManifest","acVersion":"0.1.1","name":"example.com/broken",
+		"dependencies":[{"app":"example.invalid/missing"}]}`)
+	if err := r.ResolveDependencies(context.Background(), missing, Dependencies{}); err == nil {
+		t.Errorf("expected error resolving missing dependency")
+	}
+}
+
+func TestKeyMatches(t *testing.T) {
+	key := "sha512-0123456789abcdef"
+	tests := []struct {
+		id string
+		w  bool
+	}{
+		{"sha512-0123456789abcdef0123456789abcdef", true},
+		{"sha512-0123456789abcdef", true},
+		{"sha512-0123456789abcdee0123456789abcdef", false},
+	}
+	for i, tt := range tests {
+		if g := keyMatches(key, tt.id); g != tt.w {
+			t.Errorf("#%d: got %v, want %v", i, g, tt.w)
+		}
+	}
+}
@@ -0,0 +1,200 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package image
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	docker2aci "github.com/appc/docker2aci/lib"
+	"github.com/coreos/rocket/cas"
+)
+
+const (
+	dockerScheme = "docker"
+	// dockerPrefix is prepended to image arguments which reference an image
+	// in a Docker registry, e.g. docker://quay.io/coreos/etcd:latest
+	dockerPrefix = dockerScheme + "://"
+
+	defaultDockerRegistry = "index.docker.io"
+	// the Docker Hub is keyed by its v1 endpoint in .dockercfg
+	defaultDockerCfgKey = "https://index.docker.io/v1/"
+)
+
+// dockerCfgEntry is a single registry entry in a ~/.dockercfg file
+type dockerCfgEntry struct {
+	Auth  string `json:"auth"`
+	Email string `json:"email"`
+}
+
+// fetchImageFromDocker pulls the image referenced by a docker:// URL,
+// converts its layers into a single squashed ACI and imports that into the
+// store. Docker images carry no signatures, so this is refused unless
+// verification has been explicitly disabled.
+// Conversions are recorded by the digest of the manifest of the image and
+// the reference it was pulled by, so the image is only pulled and converted
+// again once its digest changed.
+func (r *Resolver) fetchImageFromDocker(ctx context.Context, img string) (string, error) {
+	if r.Keystore != nil {
+		return "", fmt.Errorf("%s: docker images cannot be verified, use --insecure-skip-verify to fetch them", img)
+	}
+
+	dockerURL := strings.TrimPrefix(img, dockerPrefix)
+	if dockerURL == "" {
+		return "", fmt.Errorf("empty docker image reference (%s)", img)
+	}
+
+	user, pass, err := r.dockerCredentials(dockerRegistry(dockerURL))
+	if err != nil {
+		return "", fmt.Errorf("error reading docker credentials: %v", err)
+	}
+
+	// the conversion can be made without it, just not cached
+	digest, err := r.dockerDigest(ctx, dockerURL, user, pass)
+	if err != nil {
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		r.printf("rkt: warning: unable to get the digest of docker image %s, converting it again: %v\n", dockerURL, err)
+	} else {
+		digest = dockerConversionDigest(parseDockerReference(dockerURL), digest)
+		if key, ok := r.Store.ConvertedImage(digest); ok {
+			r.printf("rkt: docker image %s not modified, using cached conversion\n", dockerURL)
+			r.record(key, Origin{Source: "remote", Location: img})
+			return key, nil
+		}
+	}
+
+	tmpDir, err := r.Store.TmpDir()
+	if err != nil {
+		return "", fmt.Errorf("error creating temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	r.printf("rkt: fetching and converting docker image %s\n", dockerURL)
+	// squash all layers so we end up with a single ACI per image
+	acis, err := docker2aci.Convert(dockerURL, true, tmpDir, tmpDir, user, pass)
+	if err != nil {
+		return "", fmt.Errorf("error converting docker image %s: %v", dockerURL, err)
+	}
+	// the conversion itself can't be interrupted
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	if len(acis) != 1 {
+		return "", fmt.Errorf("expected one squashed ACI for %s, got %d", dockerURL, len(acis))
+	}
+
+	f, err := os.Open(acis[0])
+	if err != nil {
+		return "", fmt.Errorf("error opening converted image: %v", err)
+	}
+	defer f.Close()
+
+	key, err := r.Store.WriteACI(ctx, f)
+	if err != nil {
+		return "", fmt.Errorf("error importing converted image: %v", err)
+	}
+	if digest != "" {
+		r.Store.WriteIndex(&cas.Conversion{Digest: digest, BlobKey: key})
+	}
+	r.record(key, Origin{Source: "remote", Location: img})
+	return key, nil
+}
+
+// dockerConversionDigest identifies the conversion of the image with the
+// manifest digest pulled by ref: the name and labels of the ACI come from
+// the repository and tag of ref, the same image pulled by another reference
+// converts to another ACI.
+func dockerConversionDigest(ref dockerReference, digest string) string {
+	s := dockerScheme + ":" + ref.Registry + "/" + ref.Name
+	if ref.Tag != "" {
+		s += ":" + ref.Tag
+	}
+	return s + "@" + digest
+}
+
+// dockerRegistry returns the registry host the given image reference
+// (without the docker:// prefix) lives in. As with the docker CLI the first
+// path component is only treated as a host if it looks like one.
+func dockerRegistry(dockerURL string) string {
+	parts := strings.SplitN(dockerURL, "/", 2)
+	if len(parts) == 1 {
+		return defaultDockerRegistry
+	}
+	if !strings.ContainsAny(parts[0], ".:") && parts[0] != "localhost" {
+		return defaultDockerRegistry
+	}
+	return parts[0]
+}
+
+// dockerCredentials looks up the username and password for registry, first
+// in the basic credentials of the auth.d configuration and then in the
+// invoking user's ~/.dockercfg, as written by `docker login`. Missing files or
+// entries are not an error, anonymous access is attempted instead.
+func (r *Resolver) dockerCredentials(registry string) (string, string, error) {
+	if user, pass, ok := r.Auth.BasicCredentials(registry); ok {
+		return user, pass, nil
+	}
+
+	home := os.Getenv("HOME")
+	if home == "" {
+		return "", "", nil
+	}
+	return dockerCredentialsFromFile(filepath.Join(home, ".dockercfg"), registry)
+}
+
+func dockerCredentialsFromFile(path, registry string) (string, string, error) {
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return "", "", nil
+	}
+	if err != nil {
+		return "", "", err
+	}
+
+	cfg := make(map[string]dockerCfgEntry)
+	if err := json.Unmarshal(b, &cfg); err != nil {
+		return "", "", fmt.Errorf("error parsing %s: %v", path, err)
+	}
+
+	keys := []string{registry, "https://" + registry, "https://" + registry + "/v1/"}
+	if registry == defaultDockerRegistry {
+		keys = append([]string{defaultDockerCfgKey}, keys...)
+	}
+	for _, k := range keys {
+		e, ok := cfg[k]
+		if !ok {
+			continue
+		}
+		auth, err := base64.StdEncoding.DecodeString(e.Auth)
+		if err != nil {
+			return "", "", fmt.Errorf("error decoding auth for %s: %v", k, err)
+		}
+		creds := strings.SplitN(string(auth), ":", 2)
+		if len(creds) != 2 {
+			return "", "", fmt.Errorf("malformed auth for %s", k)
+		}
+		return creds[0], creds[1], nil
+	}
+
+	return "", "", nil
+}
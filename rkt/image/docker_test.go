@@ -0,0 +1,160 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package image
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDockerRegistry(t *testing.T) {
+	tests := []struct {
+		in, out string
+	}{
+		{"busybox", defaultDockerRegistry},
+		{"library/busybox:latest", defaultDockerRegistry},
+		{"quay.io/coreos/etcd:v2.0.0", "quay.io"},
+		{"localhost:5000/foo", "localhost:5000"},
+		{"localhost/foo", "localhost"},
+	}
+	for i, tt := range tests {
+		if g := dockerRegistry(tt.in); g != tt.out {
+			t.Errorf("#%d: got %v, want %v", i, g, tt.out)
+		}
+	}
+}
+
+func TestDockerCredentialsFromFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dockercfg")
+	if err != nil {
+		t.Fatalf("error creating tempdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	cfg := filepath.Join(dir, ".dockercfg")
+	// "alice:secret" and "bob:pa:ss"
+	data := `{
+		"https://index.docker.io/v1/": {"auth": "YWxpY2U6c2VjcmV0", "email": "alice@example.com"},
+		"quay.io": {"auth": "Ym9iOnBhOnNz", "email": "bob@example.com"}
+	}`
+	if err := ioutil.WriteFile(cfg, []byte(data), 0600); err != nil {
+		t.Fatalf("error writing dockercfg: %v", err)
+	}
+
+	tests := []struct {
+		registry   string
+		user, pass string
+	}{
+		{defaultDockerRegistry, "alice", "secret"},
+		{"quay.io", "bob", "pa:ss"},
+		{"example.com", "", ""},
+	}
+	for i, tt := range tests {
+		user, pass, err := dockerCredentialsFromFile(cfg, tt.registry)
+		if err != nil {
+			t.Errorf("#%d: unexpected error: %v", i, err)
+			continue
+		}
+		if user != tt.user || pass != tt.pass {
+			t.Errorf("#%d: got %q/%q, want %q/%q", i, user, pass, tt.user, tt.pass)
+		}
+	}
+
+	// a missing file means anonymous access
+	user, pass, err := dockerCredentialsFromFile(filepath.Join(dir, "missing"), "quay.io")
+	if err != nil || user != "" || pass != "" {
+		t.Errorf("got %q/%q/%v for missing file, want empty credentials", user, pass, err)
+	}
+}
+
+func TestParseDockerReference(t *testing.T) {
+	tests := []struct {
+		in  string
+		out dockerReference
+	}{
+		{"busybox", dockerReference{defaultDockerRegistry, "library/busybox", "latest", ""}},
+		{"coreos/etcd:v2.0.0", dockerReference{defaultDockerRegistry, "coreos/etcd", "v2.0.0", ""}},
+		{"quay.io/coreos/etcd@sha256:abcd", dockerReference{"quay.io", "coreos/etcd", "", "sha256:abcd"}},
+		{"localhost:5000/foo", dockerReference{"localhost:5000", "foo", "latest", ""}},
+		{"localhost:5000/foo:1", dockerReference{"localhost:5000", "foo", "1", ""}},
+	}
+	for i, tt := range tests {
+		if g := parseDockerReference(tt.in); g != tt.out {
+			t.Errorf("#%d: got %+v, want %+v", i, g, tt.out)
+		}
+	}
+}
+
+func TestDockerConversionDigest(t *testing.T) {
+	tests := []struct {
+		in  string
+		out string
+	}{
+		{"busybox", "docker:index.docker.io/library/busybox:latest@sha256:0123"},
+		{"quay.io/coreos/etcd:v2.0.0", "docker:quay.io/coreos/etcd:v2.0.0@sha256:0123"},
+		{"quay.io/coreos/etcd@sha256:0123", "docker:quay.io/coreos/etcd@sha256:0123"},
+	}
+	for i, tt := range tests {
+		if g := dockerConversionDigest(parseDockerReference(tt.in), "sha256:0123"); g != tt.out {
+			t.Errorf("#%d: got %q, want %q", i, g, tt.out)
+		}
+	}
+}
+
+func TestRegistryDigest(t *testing.T) {
+	const digest = "sha256:0123456789abcdef"
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/token":
+			if user, pass, ok := r.BasicAuth(); !ok || user != "alice" || pass != "secret" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			if r.URL.Query().Get("scope") != "repository:coreos/etcd:pull" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			w.Write([]byte(`{"token": "t0k3n"}`))
+		case "/v2/coreos/etcd/manifests/v2":
+			if r.Header.Get("Authorization") != "Bearer t0k3n" {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="`+srv.URL+`/token",service="registry",scope="repository:coreos/etcd:pull"`)
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.Header().Set("Docker-Content-Digest", digest)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	d, err := registryDigest(ctx, srv.Client(), srv.URL, "coreos/etcd", "v2", "alice", "secret")
+	if err != nil || d != digest {
+		t.Errorf("got %q, %v, want %q", d, err, digest)
+	}
+	if _, err := registryDigest(ctx, srv.Client(), srv.URL, "coreos/etcd", "v2", "", ""); err == nil {
+		t.Errorf("expected an error without credentials")
+	}
+	if _, err := registryDigest(ctx, srv.Client(), srv.URL, "coreos/etcd", "v3", "alice", "secret"); err == nil {
+		t.Errorf("expected an error for a missing tag")
+	}
+}
@@ -0,0 +1,219 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package image
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	// the registry the Docker Hub serves the v2 API from
+	defaultDockerAPIHost = "registry-1.docker.io"
+
+	// dockerManifestTypes are accepted for the manifest of an image, the
+	// digest of which changes with the type served
+	dockerManifestTypes = "application/vnd.docker.distribution.manifest.v2+json, application/vnd.docker.distribution.manifest.v1+prettyjws"
+)
+
+// dockerReference is an image reference of a docker:// URL
+type dockerReference struct {
+	// Registry is the host of the registry, as in the credentials
+	Registry string
+	// Name is the repository of the image in the registry
+	Name string
+	// Tag is the tag of the image, empty if given by Digest
+	Tag string
+	// Digest is the digest of the manifest of the image if the reference
+	// pins it, e.g. busybox@sha256:...
+	Digest string
+}
+
+// parseDockerReference parses the image reference dockerURL, without the
+// docker:// prefix, with the defaults of the docker CLI: the Docker Hub,
+// its library of official images and the latest tag.
+func parseDockerReference(dockerURL string) dockerReference {
+	ref := dockerReference{Registry: dockerRegistry(dockerURL)}
+	name := dockerURL
+	if ref.Registry != defaultDockerRegistry || strings.HasPrefix(name, defaultDockerRegistry+"/") {
+		name = strings.TrimPrefix(name, ref.Registry+"/")
+	}
+	if i := strings.Index(name, "@"); i >= 0 {
+		name, ref.Digest = name[:i], name[i+1:]
+	} else if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		name, ref.Tag = name[:i], name[i+1:]
+	} else {
+		ref.Tag = "latest"
+	}
+	if ref.Registry == defaultDockerRegistry && !strings.Contains(name, "/") {
+		name = "library/" + name
+	}
+	ref.Name = name
+	return ref
+}
+
+// apiBase returns the base URL of the v2 API of the registry of ref
+func (ref dockerReference) apiBase() string {
+	if ref.Registry == defaultDockerRegistry {
+		return "https://" + defaultDockerAPIHost
+	}
+	return "https://" + ref.Registry
+}
+
+// dockerDigest returns the digest of the manifest of the image referenced
+// by dockerURL, asking its registry unless the reference pins it.
+func (r *Resolver) dockerDigest(ctx context.Context, dockerURL, user, pass string) (string, error) {
+	ref := parseDockerReference(dockerURL)
+	if ref.Digest != "" {
+		return ref.Digest, nil
+	}
+	return registryDigest(ctx, http.DefaultClient, ref.apiBase(), ref.Name, ref.Tag, user, pass)
+}
+
+// registryDigest asks the registry with the v2 API at base for the digest
+// of the manifest of the image name:tag, without fetching it. Anonymous
+// access is tried first, then the credentials as the registry asks for
+// them, through a bearer token or basic authentication.
+func registryDigest(ctx context.Context, client *http.Client, base, name, tag, user, pass string) (string, error) {
+	u := fmt.Sprintf("%s/v2/%s/manifests/%s", base, name, tag)
+	head := func(auth func(*http.Request)) (*http.Response, error) {
+		req, err := http.NewRequest("HEAD", u, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Accept", dockerManifestTypes)
+		if auth != nil {
+			auth(req)
+		}
+		res, err := client.Do(req.WithContext(ctx))
+		if err != nil {
+			return nil, err
+		}
+		res.Body.Close()
+		return res, nil
+	}
+
+	res, err := head(nil)
+	if err != nil {
+		return "", err
+	}
+	if res.StatusCode == http.StatusUnauthorized {
+		challenge := res.Header.Get("WWW-Authenticate")
+		var auth func(*http.Request)
+		switch {
+		case strings.HasPrefix(challenge, "Bearer "):
+			token, err := registryToken(ctx, client, challenge, user, pass)
+			if err != nil {
+				return "", err
+			}
+			auth = func(req *http.Request) { req.Header.Set("Authorization", "Bearer "+token) }
+		case strings.HasPrefix(challenge, "Basic ") && user != "":
+			auth = func(req *http.Request) { req.SetBasicAuth(user, pass) }
+		default:
+			return "", fmt.Errorf("unauthorized to get the manifest of %s:%s", name, tag)
+		}
+		if res, err = head(auth); err != nil {
+			return "", err
+		}
+	}
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("error getting the manifest of %s:%s: %s", name, tag, res.Status)
+	}
+	digest := res.Header.Get("Docker-Content-Digest")
+	if digest == "" {
+		return "", errors.New("the registry did not return the digest of the manifest")
+	}
+	return digest, nil
+}
+
+// registryToken gets a bearer token from the token service of the
+// WWW-Authenticate challenge of a registry, with the credentials if any.
+func registryToken(ctx context.Context, client *http.Client, challenge, user, pass string) (string, error) {
+	params := parseChallenge(strings.TrimPrefix(challenge, "Bearer "))
+	realm := params["realm"]
+	if realm == "" {
+		return "", fmt.Errorf("no realm in authentication challenge %q", challenge)
+	}
+	q := url.Values{}
+	for _, p := range []string{"service", "scope"} {
+		if v, ok := params[p]; ok {
+			q.Set(p, v)
+		}
+	}
+	req, err := http.NewRequest("GET", realm+"?"+q.Encode(), nil)
+	if err != nil {
+		return "", err
+	}
+	if user != "" {
+		req.SetBasicAuth(user, pass)
+	}
+	res, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("error getting registry token: %s", res.Status)
+	}
+	var t struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&t); err != nil {
+		return "", fmt.Errorf("error decoding registry token: %v", err)
+	}
+	if t.Token == "" {
+		t.Token = t.AccessToken
+	}
+	if t.Token == "" {
+		return "", errors.New("empty registry token")
+	}
+	return t.Token, nil
+}
+
+// parseChallenge parses the comma-separated key="value" parameters of an
+// authentication challenge
+func parseChallenge(s string) map[string]string {
+	params := make(map[string]string)
+	for s != "" {
+		i := strings.Index(s, "=")
+		if i < 0 {
+			break
+		}
+		key := strings.TrimSpace(s[:i])
+		s = s[i+1:]
+		var val string
+		if strings.HasPrefix(s, `"`) {
+			end := strings.Index(s[1:], `"`)
+			if end < 0 {
+				val, s = s[1:], ""
+			} else {
+				val, s = s[1:end+1], s[end+2:]
+			}
+		} else if end := strings.Index(s, ","); end >= 0 {
+			val, s = s[:end], s[end:]
+		} else {
+			val, s = s, ""
+		}
+		params[key] = val
+		s = strings.TrimLeft(s, ", ")
+	}
+	return params
+}
@@ -0,0 +1,222 @@
+// Copyright 2014 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package image
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/coreos/rocket/cas"
+	"github.com/coreos/rocket/pkg/keystore"
+	"github.com/coreos/rocket/pkg/trace"
+
+	"github.com/appc/spec/discovery"
+	"github.com/appc/spec/schema/types"
+)
+
+const (
+	defaultOS   = runtime.GOOS
+	defaultArch = runtime.GOARCH
+)
+
+// FetchImage will take an image as either a URL or a name string and import it
+// into the store if found. docker:// URLs are converted to ACIs on the fly.
+// It returns the key of the image in the store. The fetch is aborted once
+// ctx is done.
+func (r *Resolver) FetchImage(ctx context.Context, img string) (string, error) {
+	u, err := url.Parse(img)
+	if err == nil && u.Scheme == "" {
+		if app := newDiscoveryApp(img); app != nil {
+			return r.fetchImageFromApp(ctx, app)
+		}
+	}
+	if err != nil {
+		return "", fmt.Errorf("not a valid URL (%s)", img)
+	}
+	if u.Scheme == dockerScheme {
+		return r.fetchImageFromDocker(ctx, r.mirror(img))
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", fmt.Errorf("rkt only supports http, https or docker URLs (%s)", img)
+	}
+	return r.fetchImageFromURL(ctx, r.mirror(u.String()))
+}
+
+// mirror returns what r.Mirrors rewrites the image name or URL img to,
+// img itself if nothing
+func (r *Resolver) mirror(img string) string {
+	to, ok := r.Mirrors.Rewrite(img)
+	if !ok {
+		return img
+	}
+	r.printf("rkt: fetching %s from mirror %s\n", img, to)
+	return to
+}
+
+func (r *Resolver) fetchImageFromApp(ctx context.Context, app *discovery.App) (string, error) {
+	// the image is signed for its own name, wherever it comes from
+	if err := r.checkTrust(app.Name.String()); err != nil {
+		return "", err
+	}
+	if name := r.mirror(app.Name.String()); name != app.Name.String() {
+		n, err := types.NewACName(name)
+		if err != nil {
+			return "", fmt.Errorf("invalid mirror of %s: %v", app.Name, err)
+		}
+		mirrored := *app
+		mirrored.Name = *n
+		app = &mirrored
+	}
+	r.printf("rkt: starting to discover app img %s\n", app.Name)
+	ep, err := r.Cache.Discover(*app, !r.Strict)
+	if err != nil {
+		return "", err
+	}
+	// discovery itself can't be interrupted
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	return r.fetchImageFromEndpoints(ctx, ep)
+}
+
+func (r *Resolver) fetchImageFromEndpoints(ctx context.Context, ep *discovery.Endpoints) (string, error) {
+	rem := cas.NewRemote(ep.ACIEndpoints[0].ACI, ep.ACIEndpoints[0].Sig)
+	return r.downloadImage(ctx, rem)
+}
+
+func (r *Resolver) fetchImageFromURL(ctx context.Context, imgurl string) (string, error) {
+	rem := cas.NewRemote(imgurl, sigURLFromImgURL(imgurl))
+	return r.downloadImage(ctx, rem)
+}
+
+func (r *Resolver) downloadImage(ctx context.Context, rem *cas.Remote) (string, error) {
+	ctx, span := trace.Start(ctx, "fetch "+rem.ACIURL)
+	defer span.Finish()
+
+	r.printf("rkt: starting to fetch img from %s\n", rem.ACIURL)
+	if r.Keystore == nil {
+		if r.Strict {
+			return "", fmt.Errorf("refusing to fetch %s without verifying its signature", rem.ACIURL)
+		}
+		r.printf("rkt: warning: signature verification has been disabled\n")
+	}
+	now := time.Now()
+	if err := r.Store.ReadIndex(rem); err == nil && rem.Fresh(now) {
+		r.record(rem.BlobKey, Origin{Source: "remote", Location: rem.ACIURL})
+		return rem.BlobKey, nil
+	}
+
+	var auth cas.Authenticator
+	if r.Auth != nil {
+		auth = r.Auth
+	}
+	entity, aciFile, cd, err := rem.Download(ctx, *r.Store, r.Keystore, r.Retry, auth, r.Out)
+	if aciFile != nil {
+		// removed while still locked, so that other fetches of the same
+		// URL waiting for it start afresh
+		defer aciFile.Close()
+		defer os.Remove(aciFile.Name())
+	}
+	if ue, ok := err.(*keystore.UntrustedPrefixError); ok {
+		return "", untrustedError(ue.Name)
+	}
+	if err != nil {
+		return "", err
+	}
+	rem.UpdateCache(cd, now)
+	if cd.UseCached {
+		r.printf("rkt: image not modified, using cached copy\n")
+		r.Store.WriteIndex(rem)
+		r.record(rem.BlobKey, Origin{Source: "remote", Location: rem.ACIURL, Signer: signerOf(entity)})
+		r.recordSigner(rem.BlobKey, entity)
+		return rem.BlobKey, nil
+	}
+
+	if r.Keystore != nil {
+		r.printf("rkt: signature verified signed by: \n")
+		for _, v := range entity.Identities {
+			r.printf("  %s\n", v.Name)
+		}
+	}
+	rem, err = rem.Store(ctx, *r.Store, aciFile)
+	if err != nil {
+		return "", err
+	}
+	r.record(rem.BlobKey, Origin{Source: "remote", Location: rem.ACIURL, Signer: signerOf(entity)})
+	r.recordSigner(rem.BlobKey, entity)
+	return rem.BlobKey, nil
+}
+
+// checkTrust fails closed for images no trusted key could have signed,
+// sparing a download which would be rejected anyway.
+func (r *Resolver) checkTrust(name string) error {
+	if r.Keystore == nil {
+		return nil
+	}
+	trusted, err := r.Keystore.TrustsPrefix(name)
+	if err != nil {
+		return fmt.Errorf("error checking trust for %s: %v", name, err)
+	}
+	if !trusted {
+		return untrustedError(name)
+	}
+	return nil
+}
+
+func untrustedError(name string) error {
+	return fmt.Errorf("no keys trusted for %q, use `rkt trust --prefix=%s KEYFILE` to trust its signing key", name, name)
+}
+
+func validateURL(s string) error {
+	u, err := url.Parse(s)
+	if err != nil {
+		return fmt.Errorf("discovery: fetched URL (%s) is invalid (%v)", s, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("rkt only supports http or https URLs (%s)", s)
+	}
+	return nil
+}
+
+func sigURLFromImgURL(imgurl string) string {
+	s := strings.TrimSuffix(imgurl, aciExt)
+	return s + ".sig"
+}
+
+// newDiscoveryApp creates a discovery app if the given img is an app name and
+// has a URL-like structure, for example example.com/reduce-worker.
+// Or it returns nil.
+func newDiscoveryApp(img string) *discovery.App {
+	app, err := discovery.NewAppFromString(img)
+	if err != nil {
+		return nil
+	}
+	u, err := url.Parse(app.Name.String())
+	if err != nil || u.Scheme != "" {
+		return nil
+	}
+	if _, ok := app.Labels["arch"]; !ok {
+		app.Labels["arch"] = defaultArch
+	}
+	if _, ok := app.Labels["os"]; !ok {
+		app.Labels["os"] = defaultOS
+	}
+	return app
+}
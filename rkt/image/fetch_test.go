@@ -0,0 +1,282 @@
+package image
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/coreos/rocket/cas"
+	"github.com/coreos/rocket/pkg/keystore"
+	"github.com/coreos/rocket/pkg/keystore/keystoretest"
+	"github.com/coreos/rocket/pkg/util"
+	"github.com/coreos/rocket/rkt/config"
+
+	"github.com/appc/spec/discovery"
+)
+
+func TestNewDiscoveryApp(t *testing.T) {
+	tests := []struct {
+		in string
+
+		w *discovery.App
+	}{
+		// not a valid AC name
+		{
+			"bad AC name",
+			nil,
+		},
+		// simple case - default arch, os should be substituted
+		{
+			"foo.com/bar",
+			&discovery.App{
+				Name: "foo.com/bar",
+				Labels: map[string]string{
+					"arch": defaultArch,
+					"os":   defaultOS,
+				},
+			},
+		},
+		// overriding arch, os should work
+		{
+			"www.abc.xyz/my/app,os=freebsd,arch=i386",
+			&discovery.App{
+				Name: "www.abc.xyz/my/app",
+				Labels: map[string]string{
+					"arch": "i386",
+					"os":   "freebsd",
+				},
+			},
+		},
+		// setting version should work
+		{
+			"yes.com/no:v1.2.3",
+			&discovery.App{
+				Name: "yes.com/no",
+				Labels: map[string]string{
+					"version": "v1.2.3",
+					"arch":    defaultArch,
+					"os":      defaultOS,
+				},
+			},
+		},
+		// arbitrary user-supplied labels
+		{
+			"example.com/foo/haha,val=one",
+			&discovery.App{
+				Name: "example.com/foo/haha",
+				Labels: map[string]string{
+					"val":  "one",
+					"arch": defaultArch,
+					"os":   defaultOS,
+				},
+			},
+		},
+		// combinations
+		{
+			"one.two/:three,os=four,foo=five,arch=six",
+			&discovery.App{
+				Name: "one.two/",
+				Labels: map[string]string{
+					"version": "three",
+					"os":      "four",
+					"foo":     "five",
+					"arch":    "six",
+				},
+			},
+		},
+	}
+	for i, tt := range tests {
+		g := newDiscoveryApp(tt.in)
+		if !reflect.DeepEqual(g, tt.w) {
+			t.Errorf("#%d: got %v, want %v", i, g, tt.w)
+		}
+	}
+}
+
+func TestFetchImage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fetch-image")
+	if err != nil {
+		t.Fatalf("error creating tempdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	ds := cas.NewStore(dir)
+	defer ds.Dump(false)
+
+	ks, ksPath, err := keystore.NewTestKeystore()
+	if err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+	defer os.RemoveAll(ksPath)
+
+	key := keystoretest.KeyMap["example.com/app"]
+	if _, err := ks.StoreTrustedKeyPrefix("example.com/app", bytes.NewBufferString(key.ArmoredPublicKey)); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	aci, err := util.NewBasicACI(dir, "example.com/app")
+	defer aci.Close()
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	// Rewind the ACI
+	if _, err := aci.Seek(0, 0); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	sig, err := util.NewDetachedSignature(key.ArmoredPrivateKey, aci)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	// Rewind the ACI.
+	if _, err := aci.Seek(0, 0); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch filepath.Ext(r.URL.Path) {
+		case ".aci":
+			io.Copy(w, aci)
+			return
+		case ".sig":
+			io.Copy(w, sig)
+			return
+		}
+	}))
+	defer ts.Close()
+	r := &Resolver{Store: ds, Keystore: ks}
+	blobKey, err := r.FetchImage(context.Background(), fmt.Sprintf("%s/app.aci", ts.URL))
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	// the signer is recorded for the key to be checked again later
+	if fp, err := ds.Signer(blobKey); err != nil || !strings.EqualFold(fp, key.Fingerprint) {
+		t.Errorf("got signer %q, %v, want %s", fp, err, key.Fingerprint)
+	}
+}
+
+func TestFetchImageMirror(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fetch-image")
+	if err != nil {
+		t.Fatalf("error creating tempdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	ds := cas.NewStore(dir)
+	defer ds.Dump(false)
+
+	ks, ksPath, err := keystore.NewTestKeystore()
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	defer os.RemoveAll(ksPath)
+
+	key := keystoretest.KeyMap["example.com/app"]
+	if _, err := ks.StoreTrustedKeyPrefix("example.com/app", bytes.NewBufferString(key.ArmoredPublicKey)); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	aci, err := util.NewBasicACI(dir, "example.com/app")
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	defer aci.Close()
+	if _, err := aci.Seek(0, 0); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	sig, err := util.NewDetachedSignature(key.ArmoredPrivateKey, aci)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if _, err := aci.Seek(0, 0); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	var paths []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		switch r.URL.Path {
+		case "/mirror/app.aci":
+			io.Copy(w, aci)
+		case "/mirror/app.sig":
+			io.Copy(w, sig)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	mirrorsDir := filepath.Join(dir, "mirrors.d")
+	if err := os.Mkdir(mirrorsDir, 0755); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	rules := `{"rktKind": "mirrors", "rktVersion": "v1", "rules": [{"from": "https://example.invalid/*", "to": "` + ts.URL + `/mirror/*"}]}`
+	if err := ioutil.WriteFile(filepath.Join(mirrorsDir, "mirror.json"), []byte(rules), 0644); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	m, err := config.LoadMirrors(mirrorsDir)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	r := &Resolver{Store: ds, Keystore: ks, Mirrors: m}
+	if _, err := r.FetchImage(context.Background(), "https://example.invalid/app.aci"); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if want := []string{"/mirror/app.aci", "/mirror/app.sig"}; !reflect.DeepEqual(paths, want) {
+		t.Errorf("got requests for %v, want %v", paths, want)
+	}
+}
+
+func TestSigURLFromImgURL(t *testing.T) {
+	tests := []struct {
+		in, out string
+	}{
+		{
+			"http://localhost/aci-latest-linux-amd64.aci",
+			"http://localhost/aci-latest-linux-amd64.sig",
+		},
+	}
+	for i, tt := range tests {
+		out := sigURLFromImgURL(tt.in)
+		if out != tt.out {
+			t.Errorf("#%d: got %v, want %v", i, out, tt.out)
+		}
+	}
+}
+
+func TestFetchImageUntrusted(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fetch-image")
+	if err != nil {
+		t.Fatalf("error creating tempdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	ds := cas.NewStore(dir)
+	defer ds.Dump(false)
+
+	ks, ksPath, err := keystore.NewTestKeystore()
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	defer os.RemoveAll(ksPath)
+
+	key := keystoretest.KeyMap["example.com/app"]
+	if _, err := ks.StoreTrustedKeyPrefix("example.com/app", bytes.NewBufferString(key.ArmoredPublicKey)); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	// no key is trusted for the name, so discovery must not even be
+	// attempted (example.invalid would fail to resolve anyway)
+	r := &Resolver{Store: ds, Keystore: ks}
+	_, err = r.FetchImage(context.Background(), "example.invalid/application")
+	if err == nil || !strings.Contains(err.Error(), "rkt trust --prefix=example.invalid/application") {
+		t.Errorf("expected untrusted prefix error, got %v", err)
+	}
+}
@@ -0,0 +1,408 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package image
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/appc/spec/aci"
+	"github.com/appc/spec/schema"
+	"github.com/appc/spec/schema/types"
+	"github.com/coreos/rocket/cas"
+	"github.com/coreos/rocket/pkg/rootfs"
+	"github.com/coreos/rocket/version"
+)
+
+const (
+	// ociConfigFile holds the configuration of an OCI runtime bundle, a
+	// directory with it next to the root filesystem of the container
+	ociConfigFile = "config.json"
+	// ociDefaultRoot is the root filesystem of a bundle not naming one
+	ociDefaultRoot = "rootfs"
+	// ociDefaultPath is searched for relative commands of a bundle without
+	// PATH in its environment
+	ociDefaultPath = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+)
+
+// ociConfig is the subset of the config.json of an OCI runtime bundle
+// which translates to an image manifest
+type ociConfig struct {
+	Platform struct {
+		OS   string `json:"os"`
+		Arch string `json:"arch"`
+	} `json:"platform"`
+	Process struct {
+		User struct {
+			UID uint32 `json:"uid"`
+			GID uint32 `json:"gid"`
+		} `json:"user"`
+		Args []string `json:"args"`
+		Env  []string `json:"env"`
+		Cwd  string   `json:"cwd"`
+	} `json:"process"`
+	Root struct {
+		Path     string `json:"path"`
+		Readonly bool   `json:"readonly"`
+	} `json:"root"`
+	Mounts []ociMount `json:"mounts"`
+}
+
+// ociMount is a mount of an OCI bundle, in either the form of early
+// versions of the spec (name and path) or of later ones (destination,
+// type, source and options)
+type ociMount struct {
+	Name        string   `json:"name"`
+	Path        string   `json:"path"`
+	Destination string   `json:"destination"`
+	Type        string   `json:"type"`
+	Options     []string `json:"options"`
+}
+
+// ociAPIMountTypes are the filesystems stage1 mounts in every app itself
+var ociAPIMountTypes = map[string]bool{
+	"proc":   true,
+	"sysfs":  true,
+	"devpts": true,
+	"mqueue": true,
+	"cgroup": true,
+}
+
+// isBundle reports whether path is the directory of an OCI runtime bundle
+func isBundle(path string) bool {
+	fi, err := os.Stat(filepath.Join(path, ociConfigFile))
+	return err == nil && fi.Mode().IsRegular()
+}
+
+// importBundle converts the OCI runtime bundle in the directory dir into an
+// ACI and imports that into the store. The bundle is not copied or changed.
+// Conversions are recorded by the digest of the bundle and its name, so that
+// it is only converted again once it changed.
+func (r *Resolver) importBundle(ctx context.Context, dir string) (string, error) {
+	b, err := ioutil.ReadFile(filepath.Join(dir, ociConfigFile))
+	if err != nil {
+		return "", fmt.Errorf("error reading bundle configuration: %v", err)
+	}
+	var cfg ociConfig
+	if err := json.Unmarshal(b, &cfg); err != nil {
+		return "", fmt.Errorf("error parsing %s: %v", ociConfigFile, err)
+	}
+	root := cfg.Root.Path
+	if root == "" {
+		root = ociDefaultRoot
+	}
+	if !filepath.IsAbs(root) {
+		root = filepath.Join(dir, root)
+	}
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	im, err := bundleManifest(cfg, filepath.Base(abs), root)
+	if err != nil {
+		return "", fmt.Errorf("error converting bundle %s: %v", dir, err)
+	}
+	digest, err := bundleDigest(im.Name.String(), b, root)
+	if err != nil {
+		return "", fmt.Errorf("error reading bundle %s: %v", dir, err)
+	}
+	if key, ok := r.Store.ConvertedImage(digest); ok {
+		r.printf("rkt: OCI bundle %s not modified, using cached conversion\n", dir)
+		return key, nil
+	}
+
+	tmpDir, err := r.Store.TmpDir()
+	if err != nil {
+		return "", fmt.Errorf("error creating temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	f, err := ioutil.TempFile(tmpDir, "bundle")
+	if err != nil {
+		return "", fmt.Errorf("error creating temporary file: %v", err)
+	}
+	defer f.Close()
+
+	r.printf("rkt: converting OCI bundle %s\n", dir)
+	aw := aci.NewImageWriter(*im, tar.NewWriter(f))
+	if err := writeRootfs(ctx, aw, root); err != nil {
+		return "", fmt.Errorf("error converting bundle %s: %v", dir, err)
+	}
+	if err := aw.Close(); err != nil {
+		return "", fmt.Errorf("error converting bundle %s: %v", dir, err)
+	}
+	if _, err := f.Seek(0, 0); err != nil {
+		return "", err
+	}
+	key, err := r.Store.WriteACI(ctx, f)
+	if err != nil {
+		return "", fmt.Errorf("error importing converted bundle: %v", err)
+	}
+	r.Store.WriteIndex(&cas.Conversion{Digest: digest, BlobKey: key})
+	return key, nil
+}
+
+// bundleDigest returns the digest identifying the bundle converted to an
+// image called name, with the configuration config and its root filesystem
+// in root: of name, config and the path, mode, owner, size and modification
+// time of the files of root, as hashing their contents would cost about as
+// much as converting them. The name comes from the directory of the bundle,
+// the same bundle in another directory converts to another image.
+func bundleDigest(name string, config []byte, root string) (string, error) {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00", name)
+	h.Write(config)
+	err := filepath.Walk(root, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		var uid, gid uint32
+		if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+			uid, gid = st.Uid, st.Gid
+		}
+		fmt.Fprintf(h, "\x00%s %o %d:%d %d %d", rel, fi.Mode(), uid, gid, fi.Size(), fi.ModTime().UnixNano())
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("oci:sha256:%x", h.Sum(nil)), nil
+}
+
+// bundleManifest translates the configuration of an OCI bundle, with its
+// root filesystem in root, into the manifest of an image called name.
+// Relative commands are looked up in the root filesystem, as the PATH of
+// the app isn't searched when it is started.
+func bundleManifest(cfg ociConfig, name, root string) (*schema.ImageManifest, error) {
+	p := cfg.Process
+	if len(p.Args) == 0 {
+		return nil, fmt.Errorf("no process arguments in %s", ociConfigFile)
+	}
+	an, err := types.NewACName(bundleName(name))
+	if err != nil {
+		return nil, fmt.Errorf("invalid image name: %v", err)
+	}
+
+	env := types.Environment{}
+	for _, kv := range p.Env {
+		i := strings.Index(kv, "=")
+		if i <= 0 {
+			return nil, fmt.Errorf("invalid environment variable %q, want KEY=VALUE", kv)
+		}
+		env[kv[:i]] = kv[i+1:]
+	}
+	exec := append(types.Exec(nil), p.Args...)
+	if !path.IsAbs(exec[0]) {
+		search := ociDefaultPath
+		if v, ok := env["PATH"]; ok {
+			search = v
+		}
+		cmd, err := lookPath(root, exec[0], search)
+		if err != nil {
+			return nil, err
+		}
+		exec[0] = cmd
+	}
+
+	app := &types.App{
+		Exec:             exec,
+		User:             strconv.FormatUint(uint64(p.User.UID), 10),
+		Group:            strconv.FormatUint(uint64(p.User.GID), 10),
+		WorkingDirectory: p.Cwd,
+	}
+	if len(env) > 0 {
+		app.Environment = env
+	}
+	if cfg.Root.Readonly {
+		app.Isolators = append(app.Isolators, types.Isolator{Name: rootfs.ReadOnlyIsolator, Val: "true"})
+	}
+	seen := make(map[types.ACName]bool)
+	for _, m := range cfg.Mounts {
+		mp, ok, err := bundleMountPoint(m)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		if seen[mp.Name] {
+			return nil, fmt.Errorf("mount point %q given more than once", mp.Name)
+		}
+		seen[mp.Name] = true
+		app.MountPoints = append(app.MountPoints, mp)
+	}
+
+	v, err := types.NewSemVer(version.Version)
+	if err != nil {
+		return nil, fmt.Errorf("error creating version: %v", err)
+	}
+	im := &schema.ImageManifest{
+		ACKind:    "ImageManifest",
+		ACVersion: *v,
+		Name:      *an,
+		App:       app,
+	}
+	for _, l := range []struct{ name, val string }{{"os", cfg.Platform.OS}, {"arch", cfg.Platform.Arch}} {
+		if l.val != "" {
+			im.Labels = append(im.Labels, types.Label{Name: types.ACName(l.name), Value: l.val})
+		}
+	}
+	return im, nil
+}
+
+// bundleMountPoint returns the mount point the volume of m is mounted on,
+// false for the API filesystems stage1 sets up itself. Volumes are named
+// after their path unless the mount has a name.
+func bundleMountPoint(m ociMount) (types.MountPoint, bool, error) {
+	p := m.Path
+	if p == "" {
+		p = m.Destination
+	}
+	if !path.IsAbs(p) {
+		return types.MountPoint{}, false, fmt.Errorf("invalid mount destination %q, must be absolute", p)
+	}
+	p = path.Clean(p)
+	if ociAPIMountTypes[m.Type] {
+		return types.MountPoint{}, false, nil
+	}
+	for _, api := range []string{"/proc", "/sys", "/dev"} {
+		if p == api || strings.HasPrefix(p, api+"/") {
+			return types.MountPoint{}, false, nil
+		}
+	}
+
+	name := m.Name
+	if name == "" {
+		name = bundleName(p)
+	}
+	an, err := types.NewACName(name)
+	if err != nil {
+		return types.MountPoint{}, false, fmt.Errorf("invalid mount name %q: %v", name, err)
+	}
+	mp := types.MountPoint{Name: *an, Path: p}
+	for _, o := range m.Options {
+		if o == "ro" {
+			mp.ReadOnly = true
+		}
+	}
+	return mp, true, nil
+}
+
+// bundleName turns s into a valid AC name, lower-casing it and replacing
+// the characters not allowed by dashes, e.g. "/var/Lib" is "var-lib"
+func bundleName(s string) string {
+	name := strings.Map(func(c rune) rune {
+		switch {
+		case c >= 'a' && c <= 'z', c >= '0' && c <= '9':
+			return c
+		case c >= 'A' && c <= 'Z':
+			return c - 'A' + 'a'
+		}
+		return '-'
+	}, s)
+	name = strings.Trim(name, "-")
+	if name == "" {
+		return "bundle"
+	}
+	return name
+}
+
+// lookPath searches the directories of the colon-separated search path in
+// the root filesystem root for cmd, returning its path inside of root.
+func lookPath(root, cmd, search string) (string, error) {
+	if strings.Contains(cmd, "/") {
+		return "", fmt.Errorf("relative command %q, must be absolute or a plain name", cmd)
+	}
+	for _, dir := range filepath.SplitList(search) {
+		if !path.IsAbs(dir) {
+			continue
+		}
+		p := path.Join(dir, cmd)
+		// symlinks may be absolute, relative to root, and are not followed
+		if fi, err := os.Lstat(filepath.Join(root, p)); err == nil && !fi.IsDir() {
+			return p, nil
+		}
+	}
+	return "", fmt.Errorf("command %q not found in %s of the bundle", cmd, search)
+}
+
+// writeRootfs adds the files of the directory root to the rootfs of the
+// image written by aw, keeping their ownership. Files hard-linked together
+// stay so.
+func writeRootfs(ctx context.Context, aw aci.ArchiveWriter, root string) error {
+	links := make(map[uint64]string)
+	return filepath.Walk(root, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		name := aci.RootfsDir
+		if rel != "." {
+			name = path.Join(aci.RootfsDir, filepath.ToSlash(rel))
+		}
+
+		link := ""
+		if fi.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(p); err != nil {
+				return err
+			}
+		}
+		hdr, err := tar.FileInfoHeader(fi, link)
+		if err != nil {
+			return err
+		}
+		hdr.Name = name
+		if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+			hdr.Uid, hdr.Gid = int(st.Uid), int(st.Gid)
+			if fi.Mode().IsRegular() && st.Nlink > 1 {
+				if target, ok := links[st.Ino]; ok {
+					hdr.Typeflag = tar.TypeLink
+					hdr.Linkname = target
+					hdr.Size = 0
+					return aw.AddFile(name, hdr, nil)
+				}
+				links[st.Ino] = name
+			}
+		}
+		if !fi.Mode().IsRegular() {
+			return aw.AddFile(name, hdr, nil)
+		}
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		return aw.AddFile(name, hdr, f)
+	})
+}
@@ -0,0 +1,177 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package image
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/appc/spec/schema/types"
+	"github.com/coreos/rocket/cas"
+)
+
+const testBundleConfig = `{
+	"platform": {"os": "linux", "arch": "amd64"},
+	"process": {
+		"user": {"uid": 1000, "gid": 100},
+		"args": ["sh", "-c", "echo hi"],
+		"env": ["PATH=/bin", "TERM=xterm"],
+		"cwd": "/srv"
+	},
+	"root": {"path": "rootfs", "readonly": true},
+	"mounts": [
+		{"destination": "/proc", "type": "proc", "source": "proc"},
+		{"destination": "/dev/pts", "type": "devpts", "source": "devpts"},
+		{"destination": "/var/Data", "type": "bind", "source": "/srv/data", "options": ["rbind", "ro"]},
+		{"name": "cache", "path": "/var/cache"}
+	]
+}`
+
+func writeBundle(t *testing.T, dir string) {
+	bin := filepath.Join(dir, "rootfs", "bin")
+	if err := os.MkdirAll(bin, 0755); err != nil {
+		t.Fatalf("error creating rootfs: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(bin, "sh"), []byte("#!"), 0755); err != nil {
+		t.Fatalf("error writing file: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, ociConfigFile), []byte(testBundleConfig), 0644); err != nil {
+		t.Fatalf("error writing config: %v", err)
+	}
+}
+
+func TestBundleManifest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "oci-bundle")
+	if err != nil {
+		t.Fatalf("error creating tempdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	writeBundle(t, dir)
+
+	var cfg ociConfig
+	if err := json.Unmarshal([]byte(testBundleConfig), &cfg); err != nil {
+		t.Fatalf("error parsing config: %v", err)
+	}
+	im, err := bundleManifest(cfg, "My_Bundle", filepath.Join(dir, "rootfs"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if im.Name != "my-bundle" {
+		t.Errorf("got name %q, want %q", im.Name, "my-bundle")
+	}
+	if v, _ := im.Labels.Get("arch"); v != "amd64" {
+		t.Errorf("got arch %q, want %q", v, "amd64")
+	}
+	app := im.App
+	if want := (types.Exec{"/bin/sh", "-c", "echo hi"}); !reflect.DeepEqual(app.Exec, want) {
+		t.Errorf("got exec %v, want %v", app.Exec, want)
+	}
+	if app.User != "1000" || app.Group != "100" || app.WorkingDirectory != "/srv" {
+		t.Errorf("got user %q, group %q, working directory %q", app.User, app.Group, app.WorkingDirectory)
+	}
+	if want := (types.Environment{"PATH": "/bin", "TERM": "xterm"}); !reflect.DeepEqual(app.Environment, want) {
+		t.Errorf("got environment %v, want %v", app.Environment, want)
+	}
+	if want := (types.Isolators{{Name: "os/linux/read-only-rootfs", Val: "true"}}); !reflect.DeepEqual(app.Isolators, want) {
+		t.Errorf("got isolators %v, want %v", app.Isolators, want)
+	}
+	wantMPs := []types.MountPoint{
+		{Name: "var-data", Path: "/var/Data", ReadOnly: true},
+		{Name: "cache", Path: "/var/cache"},
+	}
+	if !reflect.DeepEqual(app.MountPoints, wantMPs) {
+		t.Errorf("got mount points %v, want %v", app.MountPoints, wantMPs)
+	}
+
+	cfg.Process.Args = []string{"busybox"}
+	if _, err := bundleManifest(cfg, "app", filepath.Join(dir, "rootfs")); err == nil {
+		t.Errorf("expected an error for a command not in the rootfs")
+	}
+	cfg.Process.Args = nil
+	if _, err := bundleManifest(cfg, "app", filepath.Join(dir, "rootfs")); err == nil {
+		t.Errorf("expected an error without process arguments")
+	}
+}
+
+func TestImportBundle(t *testing.T) {
+	dir, err := ioutil.TempDir("", "oci-bundle")
+	if err != nil {
+		t.Fatalf("error creating tempdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	bundle := filepath.Join(dir, "etcd")
+	writeBundle(t, bundle)
+
+	if out, err := ExpandArgs([]string{bundle}); err != nil || !reflect.DeepEqual(out, []string{bundle}) {
+		t.Fatalf("got %v, %v expanding a bundle, want it untouched", out, err)
+	}
+
+	ds := cas.NewStore(filepath.Join(dir, "store"))
+	r := &Resolver{Store: ds, Source: SourceFile}
+	h, err := r.FindImage(context.Background(), bundle)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	im, err := ds.GetImageManifest(h.String())
+	if err != nil {
+		t.Fatalf("error reading manifest: %v", err)
+	}
+	if im.Name != "etcd" || im.App == nil || im.App.Exec[0] != "/bin/sh" {
+		t.Errorf("unexpected manifest %+v", im)
+	}
+	if o := r.Origins[h.String()]; o.Source != "file" || o.Location != bundle {
+		t.Errorf("got origin %+v", o)
+	}
+
+	// converted again only once changed
+	out := &bytes.Buffer{}
+	r.Out = out
+	if h2, err := r.FindImage(context.Background(), bundle); err != nil || *h2 != *h {
+		t.Fatalf("got %v, %v, want the same image", h2, err)
+	}
+	if !strings.Contains(out.String(), "cached conversion") {
+		t.Errorf("bundle converted again: %q", out.String())
+	}
+	if err := ioutil.WriteFile(filepath.Join(bundle, "rootfs", "etc-version"), []byte("2"), 0644); err != nil {
+		t.Fatalf("error writing file: %v", err)
+	}
+	h3, err := r.FindImage(context.Background(), bundle)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if *h3 == *h {
+		t.Errorf("changed bundle not converted again")
+	}
+
+	// the same bundle under another name is another image
+	renamed := filepath.Join(dir, "etcd-renamed")
+	if err := os.Rename(bundle, renamed); err != nil {
+		t.Fatalf("error renaming bundle: %v", err)
+	}
+	h4, err := r.FindImage(context.Background(), renamed)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if im, err := ds.GetImageManifest(h4.String()); err != nil || im.Name != "etcd-renamed" {
+		t.Errorf("got manifest %+v, %v, want it named etcd-renamed", im, err)
+	}
+}
@@ -0,0 +1,73 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package image
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/coreos/rocket/Godeps/_workspace/src/golang.org/x/crypto/openpgp"
+)
+
+// Origin tells where the resolver found an image, for the record.
+type Origin struct {
+	// Source is "store", "file" or "remote"
+	Source string `json:"source"`
+	// Location is the local file or the URL the image came from, empty
+	// for images found in the store
+	Location string `json:"location,omitempty"`
+	// Signer is the fingerprint of the key the signature of the image was
+	// verified with, empty if it wasn't verified as it was fetched
+	Signer string `json:"signer,omitempty"`
+}
+
+// record records the origin of the image with the given store key, unless
+// it was found before
+func (r *Resolver) record(key string, o Origin) {
+	if r.Origins == nil {
+		r.Origins = make(map[string]Origin)
+	}
+	if _, ok := r.Origins[key]; !ok {
+		r.Origins[key] = o
+	}
+}
+
+func (r *Resolver) recordFile(key, path string) {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	r.record(key, Origin{Source: "file", Location: path})
+}
+
+// signerOf returns the fingerprint of the primary key of e, nil if the
+// signature wasn't checked
+func signerOf(e *openpgp.Entity) string {
+	if e == nil || e.PrimaryKey == nil {
+		return ""
+	}
+	return fmt.Sprintf("%X", e.PrimaryKey.Fingerprint)
+}
+
+// recordSigner records in the store who signed the image stored under key,
+// if its signature was verified, for image verify --signature-only
+func (r *Resolver) recordSigner(key string, e *openpgp.Entity) {
+	fp := signerOf(e)
+	if fp == "" {
+		return
+	}
+	if err := r.Store.SetSigner(key, fp); err != nil {
+		r.printf("rkt: warning: unable to record the signer of %s: %v\n", key, err)
+	}
+}
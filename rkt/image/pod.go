@@ -0,0 +1,44 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package image
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/appc/spec/schema"
+	"github.com/appc/spec/schema/types"
+)
+
+// FindPodImages makes sure the images of the apps of a pod manifest are
+// available in the store, returning their image IDs in the order of apps.
+// Apps whose image ID is in the store use it as is, others are fetched by
+// name through discovery and must match their image ID, if any.
+func (r *Resolver) FindPodImages(ctx context.Context, apps schema.AppList) ([]types.Hash, error) {
+	out := make([]types.Hash, len(apps))
+	for i, app := range apps {
+		dep := types.Dependency{App: app.Name}
+		if !app.ImageID.Empty() {
+			id := app.ImageID
+			dep.ImageID = &id
+		}
+		key, err := r.fetchDependency(ctx, dep)
+		if err != nil {
+			return nil, fmt.Errorf("error resolving image of app %s: %v", app.Name, err)
+		}
+		out[i] = *mustHash(key)
+	}
+	return out, nil
+}
@@ -0,0 +1,332 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package image resolves image arguments, as given to `rkt run` or
+// `rkt fetch`, to images in the store, importing local files and fetching
+// remote images as needed. It is used by rkt itself and can be used by other
+// programs wanting to resolve or pre-pull images with the same semantics.
+package image
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/coreos/rocket/cas"
+	"github.com/coreos/rocket/pkg/keystore"
+	"github.com/coreos/rocket/pkg/trace"
+	"github.com/coreos/rocket/rkt/cache"
+	"github.com/coreos/rocket/rkt/config"
+
+	"github.com/appc/spec/schema/types"
+)
+
+const aciExt = ".aci"
+
+// Source restricts where an image argument is looked for.
+type Source string
+
+const (
+	// SourceAny tries, in order: an image hash in the store, a local
+	// file, and fetching by name through discovery or from a URL
+	SourceAny Source = ""
+	// SourceStore only considers images already in the store, given by
+	// hash or by name
+	SourceStore Source = "store"
+	// SourceFile only imports local files
+	SourceFile Source = "file"
+	// SourceDiscovery only fetches images by name through discovery or
+	// from a URL
+	SourceDiscovery Source = "discovery"
+)
+
+// ParseSource parses the name of a Source, "" standing for SourceAny.
+func ParseSource(s string) (Source, error) {
+	switch src := Source(s); src {
+	case SourceAny, SourceStore, SourceFile, SourceDiscovery:
+		return src, nil
+	}
+	return SourceAny, fmt.Errorf("unknown image source %q (must be one of store, file or discovery)", s)
+}
+
+// Resolver finds images given as hashes, local files, app names (via
+// discovery) or URLs and makes sure they are available in the store.
+type Resolver struct {
+	// Store is where images are looked up and imported into.
+	Store *cas.Store
+	// Keystore holds the keys trusted to sign fetched images. If nil,
+	// signature verification is skipped.
+	Keystore *keystore.Keystore
+	// Auth holds credentials for image hosts, it may be nil.
+	// Note that discovery requests are made with the default HTTP
+	// transport; use Auth.Transport to authenticate those.
+	Auth *config.Auth
+	// Retry controls how interrupted downloads are resumed.
+	Retry cas.RetryPolicy
+	// Out receives progress messages and download progress bars, nothing
+	// is written if nil.
+	Out io.Writer
+	// Source restricts where images are looked for.
+	Source Source
+	// Cache, if not nil, keeps discovery results across resolvers.
+	Cache *cache.Cache
+	// Origins records where the images found were found, by store key.
+	Origins map[string]Origin
+	// Mirrors, if not nil, rewrites the names and URLs of the images
+	// fetched, e.g. to fetch them from a mirror.
+	Mirrors *config.Mirrors
+	// Strict fails where a warning would otherwise be printed, and only
+	// discovers images over https.
+	Strict bool
+}
+
+func (r *Resolver) printf(format string, a ...interface{}) {
+	out := r.Out
+	if out == nil {
+		out = ioutil.Discard
+	}
+	fmt.Fprintf(out, format, a...)
+}
+
+// imageAttempt records the outcome of a single strategy tried while
+// looking for an image.
+type imageAttempt struct {
+	strategy string
+	err      error
+}
+
+// FindImageError is returned when no strategy succeeded in finding an image.
+// It renders every attempt made so the user can tell which step failed.
+type FindImageError struct {
+	Image    string
+	attempts []imageAttempt
+}
+
+func (e *FindImageError) Error() string {
+	lines := []string{fmt.Sprintf("unable to find image %q:", e.Image)}
+	for _, a := range e.attempts {
+		lines = append(lines, fmt.Sprintf("  %s: %v", a.strategy, a.err))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// ExpandArgs expands image arguments referring to multiple local files:
+// directories are replaced by the ACIs they contain and glob patterns by the
+// files they match, so non-shell callers get the same semantics as
+// `rkt run ./out/*.aci`. Other arguments, OCI bundles included, are passed
+// through untouched.
+func ExpandArgs(args []string) ([]string, error) {
+	var out []string
+	for _, arg := range args {
+		if strings.Contains(arg, "://") {
+			out = append(out, arg)
+			continue
+		}
+
+		if fi, err := os.Stat(arg); err == nil && fi.IsDir() && !isBundle(arg) {
+			matches, err := filepath.Glob(filepath.Join(arg, "*"+aciExt))
+			if err != nil {
+				return nil, err
+			}
+			if len(matches) == 0 {
+				return nil, fmt.Errorf("no images found in directory %q", arg)
+			}
+			out = append(out, matches...)
+			continue
+		}
+
+		if !strings.ContainsAny(arg, "*?[") {
+			out = append(out, arg)
+			continue
+		}
+		matches, err := filepath.Glob(arg)
+		if err != nil {
+			return nil, fmt.Errorf("bad pattern %q: %v", arg, err)
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("no images match %q", arg)
+		}
+		out = append(out, matches...)
+	}
+	return out, nil
+}
+
+// FindImages will recognize a ACI hash and use that, import a local file, use
+// discovery or download an ACI directly.
+// Unless only the store or discovery are searched, directories and glob
+// patterns are expanded to the local files they refer to.
+// Imports and fetches are aborted once ctx is done.
+func (r *Resolver) FindImages(ctx context.Context, args []string) ([]types.Hash, error) {
+	if r.Source == SourceAny || r.Source == SourceFile {
+		var err error
+		if args, err = ExpandArgs(args); err != nil {
+			return nil, err
+		}
+	}
+	out := make([]types.Hash, len(args))
+	for i, img := range args {
+		h, err := r.FindImage(ctx, img)
+		if err != nil {
+			return nil, err
+		}
+		out[i] = *h
+	}
+
+	return out, nil
+}
+
+// FindImage looks for img in the places allowed by r.Source, returning a
+// *FindImageError describing all attempts if none of them succeeded.
+//
+// With SourceAny the first match wins, trying in turn: an image hash in the
+// store, a local file, and a fetch by name or URL. As a name may refer to
+// both a local file and an image in the store, the local file is used
+// with a warning in that case. Once ctx is done its error is returned as is.
+func (r *Resolver) FindImage(ctx context.Context, img string) (h *types.Hash, err error) {
+	ctx, span := trace.Start(ctx, "resolve "+img)
+	defer span.Finish()
+
+	fe := &FindImageError{Image: img}
+	attempt := func(strategy string, err error) {
+		fe.attempts = append(fe.attempts, imageAttempt{strategy, err})
+	}
+	defer func() {
+		if cerr := ctx.Err(); err != nil && cerr != nil {
+			err = cerr
+		}
+	}()
+
+	switch r.Source {
+	case SourceStore:
+		key, err := r.findInStore(img)
+		if err != nil {
+			attempt("store", err)
+			return nil, fe
+		}
+		r.record(key, Origin{Source: "store"})
+		return mustHash(key), nil
+	case SourceFile:
+		key, err := r.importFile(ctx, img)
+		if err != nil {
+			attempt("local file", err)
+			return nil, fe
+		}
+		r.recordFile(key, img)
+		return mustHash(key), nil
+	case SourceDiscovery:
+		key, err := r.FetchImage(ctx, img)
+		if err != nil {
+			attempt("remote", err)
+			return nil, fe
+		}
+		return mustHash(key), nil
+	case SourceAny:
+	default:
+		return nil, fmt.Errorf("unknown image source %q", r.Source)
+	}
+
+	// check if it is a valid hash, if so let it pass through
+	_, err = types.NewHash(img)
+	if err == nil {
+		fullKey, err := r.Store.ResolveKey(img)
+		if err != nil {
+			// a valid hash is unambiguous, don't go looking elsewhere
+			attempt("hash", fmt.Errorf("could not resolve key: %v", err))
+			return nil, fe
+		}
+		r.record(fullKey, Origin{Source: "store"})
+		return mustHash(fullKey), nil
+	}
+	attempt("hash", fmt.Errorf("not an image hash: %v", err))
+
+	// import the local file if it exists
+	if _, err = os.Stat(img); err == nil {
+		if _, err := types.NewACName(img); err == nil {
+			if keys := r.Store.ImageKeysByName(img); len(keys) > 0 {
+				if r.Strict {
+					attempt("local file", fmt.Errorf("%q is both a local file and the name of an image in the store (use --image-source to choose)", img))
+					return nil, fe
+				}
+				r.printf("rkt: warning: %q is both a local file and the name of an image in the store, using the local file (use --image-source to choose)\n", img)
+			}
+		}
+		key, err := r.importFile(ctx, img)
+		if err != nil {
+			attempt("local file", err)
+			return nil, fe
+		}
+		r.recordFile(key, img)
+		return mustHash(key), nil
+	}
+	attempt("local file", err)
+
+	key, err := r.FetchImage(ctx, img)
+	if err != nil {
+		attempt("remote", err)
+		return nil, fe
+	}
+	return mustHash(key), nil
+}
+
+// findInStore returns the key of the image in the store with the given hash
+// or name.
+func (r *Resolver) findInStore(img string) (string, error) {
+	if _, err := types.NewHash(img); err == nil {
+		key, err := r.Store.ResolveKey(img)
+		if err != nil {
+			return "", fmt.Errorf("could not resolve key: %v", err)
+		}
+		return key, nil
+	}
+	keys := r.Store.ImageKeysByName(img)
+	switch len(keys) {
+	case 0:
+		return "", fmt.Errorf("no image named %q", img)
+	case 1:
+		return keys[0], nil
+	}
+	return "", fmt.Errorf("ambiguous name, matching images: %s", strings.Join(keys, ", "))
+}
+
+// importFile imports the ACI in the local file path into the store, or the
+// OCI runtime bundle in the directory path, converted into an ACI.
+func (r *Resolver) importFile(ctx context.Context, path string) (string, error) {
+	if isBundle(path) {
+		return r.importBundle(ctx, path)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	key, err := r.Store.WriteACI(ctx, file)
+	if err != nil {
+		return "", fmt.Errorf("error importing: %v", err)
+	}
+	return key, nil
+}
+
+// mustHash converts a key produced by the store into a types.Hash
+func mustHash(key string) *types.Hash {
+	h, err := types.NewHash(key)
+	if err != nil {
+		// should never happen
+		panic(err)
+	}
+	return h
+}
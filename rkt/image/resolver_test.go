@@ -0,0 +1,173 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package image
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/coreos/rocket/cas"
+	"github.com/coreos/rocket/pkg/util"
+)
+
+func TestFindImageError(t *testing.T) {
+	fe := &FindImageError{
+		Image: "example.com/app",
+		attempts: []imageAttempt{
+			{"hash", errors.New("not an image hash")},
+			{"local file", errors.New("no such file or directory")},
+			{"remote", errors.New("discovery failed")},
+		},
+	}
+	want := `unable to find image "example.com/app":
+  hash: not an image hash
+  local file: no such file or directory
+  remote: discovery failed`
+	if g := fe.Error(); g != want {
+		t.Errorf("got:\n%s\nwant:\n%s", g, want)
+	}
+}
+
+func TestExpandArgs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "expand-images")
+	if err != nil {
+		t.Fatalf("error creating tempdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	out := filepath.Join(dir, "out")
+	if err := os.Mkdir(out, 0755); err != nil {
+		t.Fatalf("error creating dir: %v", err)
+	}
+	for _, f := range []string{"b.aci", "a.aci", "notes.txt"} {
+		if err := ioutil.WriteFile(filepath.Join(out, f), nil, 0644); err != nil {
+			t.Fatalf("error creating file: %v", err)
+		}
+	}
+	a, b := filepath.Join(out, "a.aci"), filepath.Join(out, "b.aci")
+
+	tests := []struct {
+		in   []string
+		out  []string
+		fail bool
+	}{
+		{[]string{out}, []string{a, b}, false},
+		{[]string{filepath.Join(out, "*.aci")}, []string{a, b}, false},
+		{[]string{filepath.Join(out, "b.*"), "example.com/app"}, []string{b, "example.com/app"}, false},
+		{[]string{"https://example.com/app.aci?v=1"}, []string{"https://example.com/app.aci?v=1"}, false},
+		{[]string{filepath.Join(out, "*.tar")}, nil, true},
+		{[]string{dir}, nil, true},
+	}
+	for i, tt := range tests {
+		g, err := ExpandArgs(tt.in)
+		if (err != nil) != tt.fail {
+			t.Errorf("#%d: unexpected error state: %v", i, err)
+			continue
+		}
+		if !reflect.DeepEqual(g, tt.out) {
+			t.Errorf("#%d: got %v, want %v", i, g, tt.out)
+		}
+	}
+}
+
+func TestFindImageSource(t *testing.T) {
+	dir, err := ioutil.TempDir("", "find-image")
+	if err != nil {
+		t.Fatalf("error creating tempdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	ds := cas.NewStore(dir)
+
+	// an image named like a local file, which is only found as a relative
+	// path
+	stored := importACI(t, ds, dir, `{"acKind":"ImageManifest","acVersion":"0.1.1","name":"nginx.aci"}`)
+	aci, err := util.NewACI(dir, `{"acKind":"ImageManifest","acVersion":"0.1.1","name":"example.com/nginx"}`, nil)
+	if err != nil {
+		t.Fatalf("error creating ACI: %v", err)
+	}
+	defer aci.Close()
+	if _, err := aci.Seek(0, 0); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	b, err := ioutil.ReadAll(aci)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "nginx.aci"), b, 0644); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.Chdir(wd)
+
+	tests := []struct {
+		src Source
+		img string
+
+		file    bool
+		fail    bool
+		warning bool
+	}{
+		{SourceAny, "nginx.aci", true, false, true},
+		{SourceAny, "./nginx.aci", true, false, false},
+		{SourceStore, "nginx.aci", false, false, false},
+		{SourceStore, stored, false, false, false},
+		{SourceStore, "example.com/missing", false, true, false},
+		{SourceFile, "nginx.aci", true, false, false},
+		{SourceFile, "missing.aci", false, true, false},
+	}
+	for i, tt := range tests {
+		out := &bytes.Buffer{}
+		r := &Resolver{Store: ds, Source: tt.src, Out: out}
+		h, err := r.FindImage(context.Background(), tt.img)
+		if (err != nil) != tt.fail {
+			t.Errorf("#%d: got err %v, want err %v", i, err, tt.fail)
+		}
+		if err != nil {
+			continue
+		}
+		if g := h.String() != stored; g != tt.file {
+			t.Errorf("#%d: got local file %v, want %v", i, g, tt.file)
+		}
+		if g := strings.Contains(out.String(), "warning"); g != tt.warning {
+			t.Errorf("#%d: got warning %v, want %v (%q)", i, g, tt.warning, out.String())
+		}
+	}
+
+	// strict resolvers fail rather than warn
+	r := &Resolver{Store: ds, Source: SourceAny, Strict: true}
+	if _, err := r.FindImage(context.Background(), "nginx.aci"); err == nil {
+		t.Errorf("strict resolver found an ambiguous image")
+	}
+	if _, err := r.FindImage(context.Background(), "./nginx.aci"); err != nil {
+		t.Errorf("strict resolver failed on a local file: %v", err)
+	}
+
+	if _, err := ParseSource("bogus"); err == nil {
+		t.Errorf("expected an error parsing a bogus source")
+	}
+}
@@ -0,0 +1,123 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//+build linux
+
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/appc/spec/schema/types"
+	"github.com/coreos/rocket/pkg/pod"
+	"github.com/coreos/rocket/stage0"
+)
+
+const cmdLogsName = "logs"
+
+var (
+	cmdLogs = &Command{
+		Name:    cmdLogsName,
+		Summary: "Print the output of the apps of a container",
+		Usage:   "[--since=DURATION|TIME] [--follow] UUID [APP]",
+		Description: `Prints what the apps of the container UUID, or only APP, wrote on stdout and
+stderr, as kept in the journal of the container, in its directory, by its
+stage1. The journal lasts as long as the container does, until gc removes it.
+--since only prints what was written in the last DURATION, e.g. 1h, or since
+TIME, in RFC 3339. --follow then prints what the apps write, until
+interrupted.
+
+The journal is read by the journalctl of stage1, so that hosts need none.
+Containers run with the fly flavor or a stage1 image without journald keep no
+journal.`,
+		Run: runLogs,
+	}
+	flagLogsSince  string
+	flagLogsFollow bool
+)
+
+func init() {
+	commands = append(commands, cmdLogs)
+	cmdLogs.Flags.StringVar(&flagLogsSince, "since", "", "only print what was written in the last DURATION, or since TIME in RFC 3339")
+	cmdLogs.Flags.BoolVar(&flagLogsFollow, "follow", false, "print what the apps write as they do, until interrupted")
+}
+
+func runLogs(args []string) (exit int) {
+	if len(args) < 1 || len(args) > 2 {
+		printCommandUsageByName(cmdLogsName)
+		return 1
+	}
+	uuid, err := types.NewUUID(args[0])
+	if err != nil {
+		fmt.Fprintf(stderr, "Invalid UUID: %v\n", err)
+		return 1
+	}
+	since, err := parseSince(flagLogsSince)
+	if err != nil {
+		fmt.Fprintf(stderr, "Invalid --since: %v\n", err)
+		return 1
+	}
+
+	p, err := pod.Open(globalFlags.Dir, *uuid)
+	if err != nil {
+		fmt.Fprintf(stderr, "Unable to open container %s: %v\n", uuid, err)
+		return 1
+	}
+	defer p.Close()
+	cm, err := p.Manifest()
+	if err != nil {
+		fmt.Fprintf(stderr, "Unable to read container manifest: %v\n", err)
+		return 1
+	}
+	var apps []string
+	for _, ra := range cm.Apps {
+		if len(args) == 1 || ra.Name.String() == args[1] {
+			apps = append(apps, ra.Name.String())
+		}
+	}
+	if len(apps) == 0 {
+		fmt.Fprintf(stderr, "Container %s has no app %q\n", uuid, args[1])
+		return 1
+	}
+
+	fd, err := p.Fd()
+	if err == nil {
+		// the lock of exited containers is kept across the exec, so that
+		// they aren't removed while their journal is read
+		err = stage0.Logs(fd, journalctlArgs(apps, since, flagLogsFollow))
+	}
+	fmt.Fprintf(stderr, "Unable to read the logs of container %s: %v\n", uuid, err)
+	return 1
+}
+
+// journalctlArgs returns the arguments of journalctl printing what apps
+// wrote since the given time, if not zero, and with follow what they write
+// from then on
+func journalctlArgs(apps []string, since time.Time, follow bool) []string {
+	args := []string{"--no-pager", "--quiet"}
+	if !since.IsZero() {
+		// journalctl takes local times without zones
+		args = append(args, "--since="+since.Local().Format("2006-01-02 15:04:05"))
+	}
+	if follow {
+		args = append(args, "--follow")
+	}
+	// the output of the apps is logged under their name, matches of the
+	// same field are alternatives
+	for _, a := range apps {
+		args = append(args, "SYSLOG_IDENTIFIER="+a)
+	}
+	return args
+}
@@ -0,0 +1,46 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//+build linux
+
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestJournalctlArgs(t *testing.T) {
+	since := time.Date(2015, 3, 1, 12, 30, 0, 0, time.Local)
+	for i, tt := range []struct {
+		apps   []string
+		since  time.Time
+		follow bool
+		want   []string
+	}{
+		{
+			[]string{"example.com/app"}, time.Time{}, false,
+			[]string{"--no-pager", "--quiet", "SYSLOG_IDENTIFIER=example.com/app"},
+		},
+		{
+			[]string{"example.com/app", "example.com/db"}, since, true,
+			[]string{"--no-pager", "--quiet", "--since=2015-03-01 12:30:00", "--follow", "SYSLOG_IDENTIFIER=example.com/app", "SYSLOG_IDENTIFIER=example.com/db"},
+		},
+	} {
+		if got := journalctlArgs(tt.apps, tt.since, tt.follow); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("#%d: got %q, want %q", i, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//+build linux
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/coreos/rocket/metadatasvc"
+)
+
+const cmdMetadataServiceName = "metadata-service"
+
+var (
+	cmdMetadataService = &Command{
+		Name:    cmdMetadataServiceName,
+		Summary: "Run the metadata service the apps of containers introspect them with",
+		Usage:   "[--listen-port=PORT]",
+		Description: `Serves the App Container metadata service: the apps of the containers get the
+manifest and annotations of their container, the image manifests and
+annotations of its apps and signatures of their container's identity, made
+with a secret generated for each container, from
+` + metadatasvc.URL + `/acMetadata/v1, found in AC_METADATA_URL.
+
+Containers are registered with the service when they are prepared, over
+` + metadatasvc.SocketPath + `, and identified by their address on their
+default network once it is set up. Only containers with a private network
+can reach the service; their traffic is restricted to that address on the
+host end of their veth. The service keeps the containers in memory: those
+prepared while it wasn't running are registered when they start.`,
+		Run: runMetadataService,
+	}
+	flagMetadataServicePort int
+)
+
+func init() {
+	commands = append(commands, cmdMetadataService)
+	cmdMetadataService.Flags.IntVar(&flagMetadataServicePort, "listen-port", metadatasvc.DefaultPort, "port to serve the apps on, the traffic to "+metadatasvc.IP+":80 is redirected to it")
+}
+
+func runMetadataService(args []string) (exit int) {
+	if len(args) != 0 {
+		printCommandUsageByName(cmdMetadataServiceName)
+		return 1
+	}
+
+	s := metadatasvc.NewServer()
+
+	if err := os.MkdirAll(filepath.Dir(metadatasvc.SocketPath), 0755); err != nil {
+		fmt.Fprintf(stderr, "Unable to create the registration socket: %v\n", err)
+		return 1
+	}
+	// left behind by a service which didn't exit cleanly
+	if err := os.Remove(metadatasvc.SocketPath); err != nil && !os.IsNotExist(err) {
+		fmt.Fprintf(stderr, "Unable to create the registration socket: %v\n", err)
+		return 1
+	}
+	rl, err := listenPrivate(metadatasvc.SocketPath)
+	if err != nil {
+		fmt.Fprintf(stderr, "Unable to create the registration socket: %v\n", err)
+		return 1
+	}
+	defer rl.Close()
+
+	l, err := net.Listen("tcp", fmt.Sprintf(":%d", flagMetadataServicePort))
+	if err != nil {
+		fmt.Fprintf(stderr, "Unable to listen for the apps: %v\n", err)
+		return 1
+	}
+	defer l.Close()
+
+	if err := metadatasvc.Redirect(flagMetadataServicePort, true); err != nil {
+		fmt.Fprintf(stderr, "Unable to redirect %s to the metadata service: %v\n", metadatasvc.IP, err)
+		return 1
+	}
+	defer func() {
+		if err := metadatasvc.Redirect(flagMetadataServicePort, false); err != nil {
+			fmt.Fprintf(stderr, "Unable to remove the redirection of %s: %v\n", metadatasvc.IP, err)
+		}
+	}()
+
+	ctx, stop := interruptContext()
+	defer stop()
+	errc := make(chan error, 2)
+	go func() { errc <- http.Serve(rl, s.RegistrationHandler()) }()
+	go func() { errc <- http.Serve(l, s.Handler()) }()
+	select {
+	case err := <-errc:
+		fmt.Fprintf(stderr, "Unable to serve the metadata: %v\n", err)
+		return 1
+	case <-ctx.Done():
+	}
+	return 0
+}
+
+// listenPrivate listens on the unix socket path, which only root can reach:
+// it is created with a umask leaving access to its owner only, rather than
+// restricted once anyone could have connected to it. The umask is shared by
+// the threads of the process, this is called before the services start
+// anything else.
+func listenPrivate(path string) (net.Listener, error) {
+	um := syscall.Umask(0177)
+	defer syscall.Umask(um)
+	return net.Listen("unix", path)
+}
@@ -0,0 +1,194 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//+build linux
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/appc/spec/schema"
+	"github.com/appc/spec/schema/types"
+	"github.com/coreos/rocket/networking"
+	rktpath "github.com/coreos/rocket/path"
+	"github.com/coreos/rocket/pkg/pod"
+)
+
+const cmdMetricsName = "metrics"
+
+var (
+	cmdMetrics = &Command{
+		Name:    cmdMetricsName,
+		Summary: "Export the network counters of running rkt containers",
+		Usage:   "[--listen=ADDR]",
+		Description: `Prints the bytes, packets and drops received and transmitted by the running
+containers on each of their networks, in the Prometheus text format, labeled by
+container UUID, app names and network. With --listen, serves them on /metrics
+at ADDR instead, e.g. --listen=:9105, read anew on every scrape.
+
+The counters are read from the host end of the veth of the networks, so
+networks without one, e.g. macvlan ones, are not covered.`,
+		Run: runMetrics,
+	}
+	flagMetricsListen string
+)
+
+func init() {
+	commands = append(commands, cmdMetrics)
+	cmdMetrics.Flags.StringVar(&flagMetricsListen, "listen", "", "address to serve the metrics on, over HTTP")
+}
+
+// netMetrics are the metrics exported for every network of a container
+var netMetrics = []struct {
+	name  string
+	help  string
+	value func(*networking.LinkStats) uint64
+}{
+	{"rkt_pod_network_receive_bytes_total", "Bytes received by the container on the network.", func(s *networking.LinkStats) uint64 { return s.RxBytes }},
+	{"rkt_pod_network_receive_packets_total", "Packets received by the container on the network.", func(s *networking.LinkStats) uint64 { return s.RxPackets }},
+	{"rkt_pod_network_receive_drops_total", "Packets dropped on their way to the container on the network.", func(s *networking.LinkStats) uint64 { return s.RxDropped }},
+	{"rkt_pod_network_transmit_bytes_total", "Bytes transmitted by the container on the network.", func(s *networking.LinkStats) uint64 { return s.TxBytes }},
+	{"rkt_pod_network_transmit_packets_total", "Packets transmitted by the container on the network.", func(s *networking.LinkStats) uint64 { return s.TxPackets }},
+	{"rkt_pod_network_transmit_drops_total", "Packets transmitted by the container on the network and dropped.", func(s *networking.LinkStats) uint64 { return s.TxDropped }},
+}
+
+// podNetStats are the counters of the networks of a container
+type podNetStats struct {
+	uuid  string
+	apps  []string
+	links []networking.LinkStats
+}
+
+func runMetrics(args []string) (exit int) {
+	if len(args) != 0 {
+		printCommandUsageByName(cmdMetricsName)
+		return 1
+	}
+
+	if flagMetricsListen == "" {
+		pods, err := gatherNetStats()
+		if err != nil {
+			fmt.Fprintf(stderr, "Unable to gather network counters: %v\n", err)
+			return 1
+		}
+		writeMetrics(stdout, pods)
+		return 0
+	}
+
+	http.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		pods, err := gatherNetStats()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		writeMetrics(w, pods)
+	})
+	if err := http.ListenAndServe(flagMetricsListen, nil); err != nil {
+		fmt.Fprintf(stderr, "Unable to serve metrics: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// gatherNetStats returns the counters of the networks of the running
+// containers
+func gatherNetStats() ([]podNetStats, error) {
+	stats, err := networking.HostLinkStats()
+	if err != nil {
+		return nil, err
+	}
+	cs, err := getContainers()
+	if err != nil {
+		return nil, err
+	}
+
+	var pods []podNetStats
+	for _, c := range cs {
+		links := stats[c]
+		if len(links) == 0 {
+			continue
+		}
+		containerUUID, err := types.NewUUID(c)
+		if err != nil {
+			continue
+		}
+		p, err := openContainer(containerUUID)
+		if err != nil {
+			continue
+		}
+		p.Close()
+		if p.State != pod.Running {
+			continue
+		}
+		apps, err := containerAppNames(filepath.Join(containersDir(), c))
+		if err != nil {
+			fmt.Fprintf(stderr, "Unable to get the apps of container %q: %v\n", c, err)
+		}
+		pods = append(pods, podNetStats{
+			uuid:  c,
+			apps:  apps,
+			links: links,
+		})
+	}
+	return pods, nil
+}
+
+// containerAppNames returns the sorted names of the apps of the container
+// in cdir
+func containerAppNames(cdir string) ([]string, error) {
+	b, err := ioutil.ReadFile(rktpath.ContainerManifestPath(cdir))
+	if err != nil {
+		return nil, fmt.Errorf("error reading container manifest: %v", err)
+	}
+	m := schema.ContainerRuntimeManifest{}
+	if err := m.UnmarshalJSON(b); err != nil {
+		return nil, fmt.Errorf("unable to load manifest: %v", err)
+	}
+	var names []string
+	for _, ra := range m.Apps {
+		names = append(names, ra.Name.String())
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// writeMetrics writes the counters of pods to w in the Prometheus text
+// format
+func writeMetrics(w io.Writer, pods []podNetStats) {
+	for _, m := range netMetrics {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", m.name, m.help, m.name)
+		for _, p := range pods {
+			for i := range p.links {
+				ls := &p.links[i]
+				fmt.Fprintf(w, "%s{pod_uuid=\"%s\",apps=\"%s\",network=\"%s\"} %d\n", m.name,
+					labelValue(p.uuid), labelValue(strings.Join(p.apps, ",")), labelValue(ls.NetName), m.value(ls))
+			}
+		}
+	}
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// labelValue escapes a label value of the Prometheus text format
+func labelValue(s string) string {
+	return labelEscaper.Replace(s)
+}
@@ -0,0 +1,51 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/coreos/rocket/networking"
+)
+
+func TestWriteMetrics(t *testing.T) {
+	pods := []podNetStats{{
+		uuid: "6733c35b-3b5f-4b4c-a67b-1f3a5d6e1c2a",
+		apps: []string{"db", "web"},
+		links: []networking.LinkStats{
+			{NetName: "backend", RxBytes: 1024, TxPackets: 7},
+			{NetName: `we"ird`},
+		},
+	}}
+	b := &bytes.Buffer{}
+	writeMetrics(b, pods)
+	out := b.String()
+
+	for _, want := range []string{
+		"# TYPE rkt_pod_network_receive_bytes_total counter\n",
+		`rkt_pod_network_receive_bytes_total{pod_uuid="6733c35b-3b5f-4b4c-a67b-1f3a5d6e1c2a",apps="db,web",network="backend"} 1024` + "\n",
+		`rkt_pod_network_transmit_packets_total{pod_uuid="6733c35b-3b5f-4b4c-a67b-1f3a5d6e1c2a",apps="db,web",network="backend"} 7` + "\n",
+		`network="we\"ird"} 0` + "\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("%q missing from output:\n%s", want, out)
+		}
+	}
+	if n := strings.Count(out, "\n"); n != len(netMetrics)*4 {
+		t.Errorf("got %d lines, want %d", n, len(netMetrics)*4)
+	}
+}
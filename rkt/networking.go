@@ -0,0 +1,132 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//+build linux
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/appc/spec/schema/types"
+	"github.com/coreos/rocket/networking"
+	"github.com/coreos/rocket/pkg/pod"
+)
+
+const cmdNetworkingName = "networking"
+
+var (
+	cmdNetworking = &Command{
+		Name:    cmdNetworkingName,
+		Summary: "Inspect the networking of rkt containers",
+		Usage:   "dump [UUID...]",
+		// the dump is always JSON
+		Formats: []string{formatJSON},
+		Description: `dump prints, as JSON, the addresses, routes and firewall rules set up for
+the given running containers, or all of them if none are given. Firewall rules
+are given as printed by iptables-save and ebtables-save, a rule lost e.g. to
+"iptables -F" can be restored with "TOOL -t TABLE RULE".`,
+		Run: runNetworking,
+	}
+)
+
+func init() {
+	commands = append(commands, cmdNetworking)
+}
+
+// containerNetDump is the dump of a single container
+type containerNetDump struct {
+	UUID string `json:"uuid"`
+	*networking.Dump
+}
+
+func runNetworking(args []string) (exit int) {
+	if len(args) < 1 || args[0] != "dump" {
+		printCommandUsageByName(cmdNetworkingName)
+		return 1
+	}
+
+	uuids := args[1:]
+	if len(uuids) == 0 {
+		cs, err := getContainers()
+		if err != nil {
+			fmt.Fprintf(stderr, "Unable to get containers list: %v\n", err)
+			return 1
+		}
+		uuids = cs
+	}
+
+	dumps := []containerNetDump{}
+	for _, u := range uuids {
+		d, err := dumpContainerNet(u)
+		if err != nil {
+			fmt.Fprintf(stderr, "Unable to dump networking of container %q: %v\n", u, err)
+			exit = 1
+			continue
+		}
+		if d != nil {
+			dumps = append(dumps, *d)
+		}
+	}
+
+	b, err := json.MarshalIndent(struct {
+		Containers []containerNetDump `json:"containers"`
+	}{dumps}, "", "\t")
+	if err != nil {
+		fmt.Fprintf(stderr, "Unable to marshal networking dump: %v\n", err)
+		return 1
+	}
+	fmt.Fprintln(stdout, string(b))
+	return
+}
+
+// dumpContainerNet dumps the networking of the container with the given
+// UUID. It returns nil for containers which exited or share the host's
+// networking.
+func dumpContainerNet(u string) (*containerNetDump, error) {
+	containerUUID, err := types.NewUUID(u)
+	if err != nil {
+		return nil, fmt.Errorf("invalid UUID: %v", err)
+	}
+	p, err := openContainer(containerUUID)
+	if err != nil {
+		return nil, err
+	}
+	defer p.Close()
+	if p.State != pod.Running {
+		return nil, nil
+	}
+
+	ni, err := networking.LoadNetInfo(filepath.Join(containersDir(), containerUUID.String()))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error loading network info: %v", err)
+	}
+	if ni.Host {
+		return nil, nil
+	}
+	d, err := networking.DumpNetInfo(ni)
+	if err != nil {
+		return nil, err
+	}
+	return &containerNetDump{
+		UUID: containerUUID.String(),
+		Dump: d,
+	}, nil
+}
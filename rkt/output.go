@@ -0,0 +1,88 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// Commands keep their output apart so that it can be piped: the results
+// meant for other programs (hashes, UUIDs, status, JSON) go to stdout and
+// everything meant for humans (progress, diagnostics, prompts) to stderr.
+var (
+	stdout io.Writer = os.Stdout
+	stderr io.Writer = os.Stderr
+)
+
+// progressOut returns where progress is reported: stderr, or nowhere in
+// quiet mode
+func progressOut() io.Writer {
+	if flagQuiet {
+		return ioutil.Discard
+	}
+	return stderr
+}
+
+// The commands printing records for other programs, e.g. the status of a
+// container, can print them in other formats than their default one with
+// --format, for scripts to parse them reliably. Each command lists the
+// formats it supports in its Formats.
+const (
+	// formatJSON prints the records as a single JSON document
+	formatJSON = "json"
+	// formatRaw prints the fields of each record on a line, separated by
+	// tabs, without aligning or decorating them
+	formatRaw = "raw"
+)
+
+// checkFormat checks cmd supports the --format asked for, if any
+func checkFormat(cmd *Command, format string) error {
+	if format == "" {
+		return nil
+	}
+	for _, f := range cmd.Formats {
+		if f == format {
+			return nil
+		}
+	}
+	if len(cmd.Formats) == 0 {
+		return fmt.Errorf("%s %s has no --format", cliName, cmd.Name)
+	}
+	return fmt.Errorf("%s %s doesn't support --format=%s, only %s", cliName, cmd.Name, format, strings.Join(cmd.Formats, ", "))
+}
+
+// printJSON prints v on stdout as indented JSON
+func printJSON(v interface{}) error {
+	b, err := json.MarshalIndent(v, "", "\t")
+	if err != nil {
+		return fmt.Errorf("error marshalling output: %v", err)
+	}
+	_, err = fmt.Fprintln(stdout, string(b))
+	return err
+}
+
+// printRaw prints fields on a line of stdout, separated by tabs
+func printRaw(fields ...interface{}) {
+	strs := make([]string, len(fields))
+	for i, f := range fields {
+		strs[i] = fmt.Sprint(f)
+	}
+	fmt.Fprintln(stdout, strings.Join(strs, "\t"))
+}
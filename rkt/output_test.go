@@ -0,0 +1,59 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestCheckFormat(t *testing.T) {
+	cmd := &Command{Name: "status", Formats: []string{formatJSON, formatRaw}}
+	for _, f := range []string{"", formatJSON, formatRaw} {
+		if err := checkFormat(cmd, f); err != nil {
+			t.Errorf("%q: unexpected error: %v", f, err)
+		}
+	}
+	if err := checkFormat(cmd, "yaml"); err == nil {
+		t.Errorf("expected an error for an unknown format")
+	}
+	if err := checkFormat(&Command{Name: "run"}, formatJSON); err == nil {
+		t.Errorf("expected an error for a command without formats")
+	}
+	if err := checkFormat(&Command{Name: "run"}, ""); err != nil {
+		t.Errorf("unexpected error for the default format: %v", err)
+	}
+}
+
+func TestPrint(t *testing.T) {
+	saved := stdout
+	defer func() { stdout = saved }()
+	var b bytes.Buffer
+	stdout = &b
+
+	printRaw("app", "A", "/etc/motd", 42)
+	if got, want := b.String(), "app\tA\t/etc/motd\t42\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	b.Reset()
+	if err := printJSON(struct {
+		Name string `json:"name"`
+	}{"app"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got, want := b.String(), "{\n\t\"name\": \"app\"\n}\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,94 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//+build linux
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/appc/spec/schema/types"
+	"github.com/coreos/rocket/pkg/cgroup"
+	"github.com/coreos/rocket/pkg/pod"
+)
+
+const (
+	cmdPauseName   = "pause"
+	cmdUnpauseName = "unpause"
+)
+
+var (
+	cmdPause = &Command{
+		Name:    cmdPauseName,
+		Summary: "Suspend all the processes of a running rkt container",
+		Usage:   "UUID",
+		Description: `The processes are frozen through the freezer cgroup and keep their state,
+they are resumed with "rkt unpause UUID".`,
+		Run: runPause,
+	}
+	cmdUnpause = &Command{
+		Name:    cmdUnpauseName,
+		Summary: "Resume the processes of a paused rkt container",
+		Usage:   "UUID",
+		Run:     runUnpause,
+	}
+)
+
+func init() {
+	commands = append(commands, cmdPause, cmdUnpause)
+}
+
+func runPause(args []string) (exit int) {
+	return pauseContainer(cmdPauseName, args, cgroup.Freeze)
+}
+
+func runUnpause(args []string) (exit int) {
+	return pauseContainer(cmdUnpauseName, args, cgroup.Thaw)
+}
+
+// pauseContainer applies op to the running container given in the args of
+// the cmd command
+func pauseContainer(cmd string, args []string, op func(uuid string) error) (exit int) {
+	if len(args) != 1 {
+		printCommandUsageByName(cmd)
+		return 1
+	}
+
+	containerUUID, err := types.NewUUID(args[0])
+	if err != nil {
+		fmt.Fprintf(stderr, "Invalid UUID: %v\n", err)
+		return 1
+	}
+
+	p, err := openContainer(containerUUID)
+	if err != nil {
+		fmt.Fprintf(stderr, "Unable to access container: %v\n", err)
+		return 1
+	}
+	defer p.Close()
+	if p.State != pod.Running {
+		fmt.Fprintf(stderr, "Container %v is not running\n", containerUUID)
+		return 1
+	}
+
+	if err := op(containerUUID.String()); err != nil {
+		if err == cgroup.ErrNoFreezer {
+			err = fmt.Errorf("%v, is the freezer cgroup hierarchy mounted?", err)
+		}
+		fmt.Fprintf(stderr, "Unable to %s container: %v\n", cmd, err)
+		return 1
+	}
+	return 0
+}
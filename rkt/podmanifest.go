@@ -0,0 +1,112 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/coreos/rocket/pkg/keystore"
+	"github.com/coreos/rocket/rkt/cache"
+
+	"github.com/appc/spec/schema"
+)
+
+// maxPodManifestSize bounds the size of a pod manifest and its signature
+const maxPodManifestSize = 1024 * 1024
+
+// podManifestSigLocation returns where the signature of the pod manifest at
+// location is looked for by default: next to it, with a .sig extension in
+// place of .json, as for images fetched from a URL
+func podManifestSigLocation(location string) string {
+	return strings.TrimSuffix(location, ".json") + ".sig"
+}
+
+// readPodManifestFile reads the pod manifest or signature at location, a
+// local file or an https URL. Plain http is only accepted with
+// --insecure-skip-verify.
+func readPodManifestFile(ctx context.Context, location string) ([]byte, error) {
+	u, err := url.Parse(location)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		f, err := os.Open(location)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		b, err := ioutil.ReadAll(io.LimitReader(f, maxPodManifestSize+1))
+		if err != nil {
+			return nil, err
+		}
+		if len(b) > maxPodManifestSize {
+			return nil, fmt.Errorf("%s exceeds %d bytes", location, maxPodManifestSize)
+		}
+		return b, nil
+	}
+	if u.Scheme == "http" && !globalFlags.InsecureSkipVerify {
+		return nil, fmt.Errorf("refusing to fetch %s over plain http, use https or --insecure-skip-verify", location)
+	}
+	// not cached: a manifest and its signature must be fetched together
+	var c *cache.Cache
+	return c.Get(ctx, u.String(), maxPodManifestSize)
+}
+
+// loadPodManifest reads the pod manifest at location and checks its
+// detached signature, read from sigLocation, was made by a key trusted for
+// every app of the manifest. The signature isn't checked if ks is nil.
+func loadPodManifest(ctx context.Context, ks *keystore.Keystore, location, sigLocation string) (*schema.ContainerRuntimeManifest, error) {
+	b, err := readPodManifestFile(ctx, location)
+	if err != nil {
+		return nil, fmt.Errorf("error reading pod manifest: %v", err)
+	}
+	var pm schema.ContainerRuntimeManifest
+	if err := json.Unmarshal(b, &pm); err != nil {
+		return nil, fmt.Errorf("error parsing pod manifest: %v", err)
+	}
+	if pm.ACKind != "ContainerRuntimeManifest" {
+		return nil, fmt.Errorf("pod manifest has kind %q, want %q", pm.ACKind, "ContainerRuntimeManifest")
+	}
+	if len(pm.Apps) == 0 {
+		return nil, fmt.Errorf("pod manifest has no apps")
+	}
+	for _, app := range pm.Apps {
+		if app.Name == "" {
+			return nil, fmt.Errorf("pod manifest has an app without a name")
+		}
+	}
+
+	if ks == nil {
+		fmt.Fprintf(stderr, "rkt: warning: pod manifest signature verification has been disabled\n")
+		return &pm, nil
+	}
+	sig, err := readPodManifestFile(ctx, sigLocation)
+	if err != nil {
+		return nil, fmt.Errorf("error reading pod manifest signature: %v", err)
+	}
+	// the manifest decides which images run, so its signer must be
+	// trusted to sign all of them
+	for _, app := range pm.Apps {
+		if _, err := ks.CheckSignature(app.Name.String(), bytes.NewReader(b), bytes.NewReader(sig)); err != nil {
+			return nil, fmt.Errorf("error verifying pod manifest signature for app %s: %v", app.Name, err)
+		}
+	}
+	return &pm, nil
+}
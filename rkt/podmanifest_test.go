@@ -0,0 +1,154 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/coreos/rocket/pkg/keystore"
+	"github.com/coreos/rocket/pkg/keystore/keystoretest"
+
+	"github.com/coreos/rocket/Godeps/_workspace/src/golang.org/x/crypto/openpgp"
+)
+
+func TestPodManifestSigLocation(t *testing.T) {
+	tests := []struct {
+		location string
+		want     string
+	}{
+		{"https://example.com/pods/web.json", "https://example.com/pods/web.sig"},
+		{"https://example.com/pods/web", "https://example.com/pods/web.sig"},
+		{"web.json", "web.sig"},
+	}
+	for _, tt := range tests {
+		if g := podManifestSigLocation(tt.location); g != tt.want {
+			t.Errorf("podManifestSigLocation(%q) = %q, want %q", tt.location, g, tt.want)
+		}
+	}
+}
+
+func TestLoadPodManifest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "podmanifest")
+	if err != nil {
+		t.Fatalf("error creating tempdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	ks, ksPath, err := keystore.NewTestKeystore()
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	defer os.RemoveAll(ksPath)
+	key := keystoretest.KeyMap["example.com/app"]
+	if _, err := ks.StoreTrustedKeyPrefix("example.com/app", bytes.NewBufferString(key.ArmoredPublicKey)); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	entities, err := openpgp.ReadArmoredKeyRing(bytes.NewBufferString(key.ArmoredPrivateKey))
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	tests := []struct {
+		manifest string
+		// tamper changes the manifest after it has been signed
+		tamper bool
+		ks     *keystore.Keystore
+
+		err bool
+	}{
+		{
+			`{"acKind": "ContainerRuntimeManifest", "apps": [{"name": "example.com/app"}]}`,
+			false,
+			ks,
+			false,
+		},
+		// no keys trusted for one of the apps
+		{
+			`{"acKind": "ContainerRuntimeManifest", "apps": [{"name": "example.com/app"}, {"name": "coreos.com/etcd"}]}`,
+			false,
+			ks,
+			true,
+		},
+		{
+			`{"acKind": "ContainerRuntimeManifest", "apps": [{"name": "example.com/app"}]}`,
+			true,
+			ks,
+			true,
+		},
+		// signature verification disabled
+		{
+			`{"acKind": "ContainerRuntimeManifest", "apps": [{"name": "coreos.com/etcd"}]}`,
+			true,
+			nil,
+			false,
+		},
+		{
+			`{"acKind": "ImageManifest", "apps": [{"name": "example.com/app"}]}`,
+			false,
+			ks,
+			true,
+		},
+		{
+			`{"acKind": "ContainerRuntimeManifest", "apps": []}`,
+			false,
+			ks,
+			true,
+		},
+		{
+			`{"acKind": "ContainerRuntimeManifest"`,
+			false,
+			ks,
+			true,
+		},
+	}
+	for i, tt := range tests {
+		mp := filepath.Join(dir, "pod.json")
+		sp := podManifestSigLocation(mp)
+		var sig bytes.Buffer
+		if err := openpgp.ArmoredDetachSign(&sig, entities[0], bytes.NewBufferString(tt.manifest), nil); err != nil {
+			t.Fatalf("error signing manifest: %v", err)
+		}
+		manifest := tt.manifest
+		if tt.tamper {
+			manifest = manifest[:len(manifest)-1] + ", \"isolators\": []}"
+		}
+		if err := ioutil.WriteFile(mp, []byte(manifest), 0644); err != nil {
+			t.Fatalf("error writing manifest: %v", err)
+		}
+		if err := ioutil.WriteFile(sp, sig.Bytes(), 0644); err != nil {
+			t.Fatalf("error writing signature: %v", err)
+		}
+
+		pm, err := loadPodManifest(context.Background(), tt.ks, mp, sp)
+		if tt.err {
+			if err == nil {
+				t.Errorf("#%d: expected an error", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("#%d: unexpected error: %v", i, err)
+			continue
+		}
+		if len(pm.Apps) == 0 {
+			t.Errorf("#%d: no apps loaded", i)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//+build linux
+
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"runtime"
+
+	"github.com/appc/spec/schema/types"
+	"github.com/coreos/rocket/networking"
+	"github.com/coreos/rocket/pkg/events"
+	"github.com/coreos/rocket/pkg/watchdog"
+	"github.com/coreos/rocket/stage0"
+)
+
+const cmdRunPreparedName = "run-prepared"
+
+var (
+	flagPrepareNet bool
+	cmdPrepare     = &Command{
+		Name:    "prepare",
+		Summary: "Prepare a container running image(s), to be started later with run-prepared",
+		Usage:   "[--prepare-net] [run flags] IMAGE... | --pod-manifest=FILE|URL",
+		Description: `prepare fetches the images and sets up a container as run does, prints its UUID
+and exits. The container is started with "rkt run-prepared UUID".
+With --private-net and --prepare-net, the network namespace is created and the
+container is attached to its networks too, holding on to their IP addresses, so
+that run-prepared only has to start stage1.`,
+		Run: runPrepare,
+	}
+	cmdRunPrepared = &Command{
+		Name:    cmdRunPreparedName,
+		Summary: "Run a container set up with prepare",
+		Usage:   "UUID",
+		Run:     runRunPrepared,
+	}
+)
+
+func init() {
+	commands = append(commands, cmdPrepare, cmdRunPrepared)
+	addRunFlags(&cmdPrepare.Flags)
+	cmdPrepare.Flags.BoolVar(&flagPrepareNet, "prepare-net", false, "set up the private network of the container now rather than when it is run")
+}
+
+func runPrepare(args []string) (exit int) {
+	if flagPrepareNet && !flagPrivateNet.Enabled() {
+		fmt.Fprintf(stderr, "prepare: --prepare-net requires --private-net\n")
+		return 1
+	}
+	if flagVerity {
+		fmt.Fprintf(stderr, "prepare: --verity can't be used, root hashes are never written to disk\n")
+		return 1
+	}
+
+	cfg, cdir, exit := setupContainer("prepare", args, preparedDir())
+	if exit != 0 {
+		return exit
+	}
+
+	if flagPrepareNet {
+		cfg.Watchdog.SetPhase(watchdog.PhaseNetwork)
+		if err := prepareNet(cdir, cfg.Networks, cfg.NetArgs); err != nil {
+			fmt.Fprintf(stderr, "prepare: error setting up network: %v\n", err)
+			cleanupPrepare(cfg, cdir)
+			return 1
+		}
+		cfg.PreparedNet = true
+	}
+	cfg.Watchdog.Stop()
+
+	if err := stage0.SavePrepared(cfg, cdir); err != nil {
+		fmt.Fprintf(stderr, "prepare: %v\n", err)
+		cleanupPrepare(cfg, cdir)
+		return 1
+	}
+	recordEvent(events.Prepare, filepath.Base(cdir))
+	fmt.Fprintln(stdout, filepath.Base(cdir))
+	return 0
+}
+
+// prepareNet sets up the network namespace of the container prepared in
+// cdir, attached to the named nets, for stage1 to enter when it runs.
+func prepareNet(cdir string, netNames []string, netArgs map[string]string) error {
+	containerUUID, err := types.NewUUID(filepath.Base(cdir))
+	if err != nil {
+		return err
+	}
+
+	// the network is set up in a new namespace entered by the calling
+	// thread, which must not run anything else meanwhile
+	runtime.LockOSThread()
+	n, err := networking.Setup(cdir, *containerUUID, netNames, netArgs, nil)
+	if err != nil {
+		return err
+	}
+	if err := n.EnterHostNS(); err != nil {
+		// leave the thread locked, it is in the container's namespace
+		return err
+	}
+	runtime.UnlockOSThread()
+	return nil
+}
+
+// cleanupPrepare removes the container prepared in cdir after a failure,
+// releasing the network set up for it first, if any, so that its addresses
+// aren't held until they are garbage collected.
+func cleanupPrepare(cfg stage0.Config, cdir string) {
+	if err := teardownPreparedNet(cdir, filepath.Base(cdir)); err != nil {
+		fmt.Fprintf(stderr, "prepare: unable to release the network of the container: %v\n", err)
+	}
+	stage0.CleanupSetup(cfg, cdir)
+}
+
+func runRunPrepared(args []string) (exit int) {
+	if len(args) != 1 {
+		printCommandUsageByName(cmdRunPreparedName)
+		return 1
+	}
+
+	containerUUID, err := types.NewUUID(args[0])
+	if err != nil {
+		fmt.Fprintf(stderr, "Invalid UUID: %v\n", err)
+		return 1
+	}
+
+	cfg, cdir, err := stage0.LoadPrepared(filepath.Join(preparedDir(), containerUUID.String()), containersDir())
+	if err != nil {
+		fmt.Fprintf(stderr, "run-prepared: %v\n", err)
+		return 1
+	}
+	recordEvent(events.Run, containerUUID.String())
+	stage0.Run(cfg, cdir) // execs, never returns
+	return 1
+}
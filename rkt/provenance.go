@@ -0,0 +1,258 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/appc/spec/schema/types"
+	"github.com/coreos/rocket/rkt/image"
+	"github.com/coreos/rocket/stage0"
+	"github.com/coreos/rocket/version"
+)
+
+const (
+	// provenanceFile is the file, relative to the directory of a
+	// container, recording what it was set up from
+	provenanceFile = "provenance.json"
+	// provenanceKind names the files of provenance, as it can't be
+	// guessed from their content
+	provenanceKind = "provenance"
+
+	defaultAuditRetention = 90 * 24 * time.Hour
+)
+
+// provenance records what a container was set up from, for forensics:
+// it is written when the container is set up and archived in auditDir()
+// when it is garbage collected.
+type provenance struct {
+	RktKind    string            `json:"rktKind"`
+	UUID       string            `json:"uuid"`
+	Created    time.Time         `json:"created"`
+	RktVersion string            `json:"rktVersion"`
+	Kernel     string            `json:"kernel"`
+	Stage1     stage1Provenance  `json:"stage1"`
+	Images     []imageProvenance `json:"images"`
+	// Dependencies maps the image IDs of images to those of their direct
+	// dependencies, rendered beneath them in that order
+	Dependencies map[string][]string `json:"dependencies,omitempty"`
+	// Insecure lists the options the container was set up with which
+	// skip checks, e.g. of image signatures
+	Insecure []string `json:"insecure,omitempty"`
+}
+
+type stage1Provenance struct {
+	Flavor string `json:"flavor,omitempty"`
+	// Rootfs is the stage1 rootfs tarball given with --stage1-rootfs,
+	// empty for the built-in one or a stage1 image
+	Rootfs       string `json:"rootfs,omitempty"`
+	RootfsDigest string `json:"rootfsDigest,omitempty"`
+	// Init is the stage1 init given with --stage1-init, empty for the
+	// built-in one
+	Init       string `json:"init,omitempty"`
+	InitDigest string `json:"initDigest"`
+}
+
+type imageProvenance struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	// Role is "app", "dependency" or "stage1"
+	Role string `json:"role"`
+	image.Origin
+}
+
+// writeProvenance records in dir the provenance of the container set up
+// there by cfg, with the images found by r.
+func writeProvenance(cfg stage0.Config, dir string, r *image.Resolver) error {
+	rootfs, init, err := stage0.Stage1Digests(cfg, dir)
+	if err != nil {
+		return err
+	}
+	p := provenance{
+		RktKind:    provenanceKind,
+		UUID:       filepath.Base(dir),
+		Created:    time.Now().UTC(),
+		RktVersion: version.Version,
+		Kernel:     kernelRelease(),
+		Stage1: stage1Provenance{
+			Flavor:       cfg.Stage1Flavor,
+			Rootfs:       cfg.Stage1Rootfs,
+			RootfsDigest: rootfs,
+			Init:         cfg.Stage1Init,
+			InitDigest:   init,
+		},
+	}
+
+	seen := make(map[string]bool)
+	add := func(h types.Hash, role string) error {
+		if seen[h.String()] {
+			return nil
+		}
+		seen[h.String()] = true
+		im, err := cfg.Store.GetImageManifest(h.String())
+		if err != nil {
+			return fmt.Errorf("error reading manifest of %s: %v", h, err)
+		}
+		p.Images = append(p.Images, imageProvenance{
+			ID:     h.String(),
+			Name:   im.Name.String(),
+			Role:   role,
+			Origin: r.Origins[h.String()],
+		})
+		return nil
+	}
+	for _, h := range cfg.Images {
+		if err := add(h, "app"); err != nil {
+			return err
+		}
+	}
+	if cfg.Stage1Image != nil {
+		if err := add(*cfg.Stage1Image, "stage1"); err != nil {
+			return err
+		}
+	}
+	keys := make([]string, 0, len(cfg.Dependencies))
+	for k := range cfg.Dependencies {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		for _, h := range cfg.Dependencies[k] {
+			if err := add(h, "dependency"); err != nil {
+				return err
+			}
+			if p.Dependencies == nil {
+				p.Dependencies = make(map[string][]string)
+			}
+			p.Dependencies[k] = append(p.Dependencies[k], h.String())
+		}
+	}
+
+	if globalFlags.InsecureSkipVerify {
+		p.Insecure = append(p.Insecure, "insecure-skip-verify")
+	}
+	// stage1 overrides are run as is, with full privileges
+	if cfg.Stage1Rootfs != "" {
+		p.Insecure = append(p.Insecure, "stage1-rootfs")
+	}
+	if cfg.Stage1Init != "" {
+		p.Insecure = append(p.Insecure, "stage1-init")
+	}
+
+	b, err := json.MarshalIndent(p, "", "\t")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, provenanceFile), b, 0644)
+}
+
+// readProvenance reads the provenance of the container in dir.
+func readProvenance(dir string) (*provenance, error) {
+	b, err := ioutil.ReadFile(filepath.Join(dir, provenanceFile))
+	if err != nil {
+		return nil, err
+	}
+	var p provenance
+	if err := json.Unmarshal(b, &p); err != nil {
+		return nil, fmt.Errorf("error parsing provenance: %v", err)
+	}
+	return &p, nil
+}
+
+// kernelRelease returns the release of the running kernel, as uname -r
+// prints it
+func kernelRelease() string {
+	var u syscall.Utsname
+	if err := syscall.Uname(&u); err != nil {
+		return ""
+	}
+	var b []byte
+	for _, c := range u.Release {
+		if c == 0 {
+			break
+		}
+		b = append(b, byte(c))
+	}
+	return string(b)
+}
+
+func auditDir() string {
+	return filepath.Join(globalFlags.Dir, "audit")
+}
+
+// archiveProvenance copies the provenance of the container in dir, if
+// any, compressed, to auditDir(), where it outlives the container.
+func archiveProvenance(dir, uuid string) error {
+	b, err := ioutil.ReadFile(filepath.Join(dir, provenanceFile))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(auditDir(), 0700); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(auditDir(), "."+uuid)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	zw := gzip.NewWriter(f)
+	_, err = zw.Write(b)
+	if cerr := zw.Close(); err == nil {
+		err = cerr
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), filepath.Join(auditDir(), uuid+".json.gz"))
+}
+
+// pruneAudit removes the provenance archived in auditDir() longer than
+// retention ago, none if retention is 0.
+func pruneAudit(retention time.Duration) error {
+	if retention == 0 {
+		return nil
+	}
+	ls, err := ioutil.ReadDir(auditDir())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, fi := range ls {
+		if !strings.HasSuffix(fi.Name(), ".json.gz") || time.Since(fi.ModTime()) < retention {
+			continue
+		}
+		if err := os.Remove(filepath.Join(auditDir(), fi.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
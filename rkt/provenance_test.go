@@ -0,0 +1,90 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestArchiveProvenance(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rkt-provenance-test")
+	if err != nil {
+		t.Fatalf("error creating temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(d string) { globalFlags.Dir = d }(globalFlags.Dir)
+	globalFlags.Dir = dir
+
+	cdir := filepath.Join(dir, "garbage", "abc")
+	if err := os.MkdirAll(cdir, 0700); err != nil {
+		t.Fatalf("error creating container directory: %v", err)
+	}
+	// containers set up by earlier versions have no provenance
+	if err := archiveProvenance(cdir, "abc"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := os.Stat(auditDir()); !os.IsNotExist(err) {
+		t.Errorf("expected nothing archived, got %v", err)
+	}
+
+	want := `{"rktKind": "provenance", "uuid": "abc"}`
+	if err := ioutil.WriteFile(filepath.Join(cdir, provenanceFile), []byte(want), 0644); err != nil {
+		t.Fatalf("error writing provenance: %v", err)
+	}
+	if err := archiveProvenance(cdir, "abc"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	archived := filepath.Join(auditDir(), "abc.json.gz")
+	f, err := os.Open(archived)
+	if err != nil {
+		t.Fatalf("error opening archived provenance: %v", err)
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatalf("error decompressing archived provenance: %v", err)
+	}
+	if b, err := ioutil.ReadAll(zr); err != nil || string(b) != want {
+		t.Errorf("got %q, %v, want %q", b, err, want)
+	}
+
+	if err := pruneAudit(time.Hour); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := os.Stat(archived); err != nil {
+		t.Errorf("recent provenance pruned: %v", err)
+	}
+	old := time.Now().Add(-2 * time.Hour)
+	if err := os.Chtimes(archived, old, old); err != nil {
+		t.Fatalf("error aging archived provenance: %v", err)
+	}
+	if err := pruneAudit(0); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := os.Stat(archived); err != nil {
+		t.Errorf("provenance pruned without retention: %v", err)
+	}
+	if err := pruneAudit(time.Hour); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := os.Stat(archived); !os.IsNotExist(err) {
+		t.Errorf("expected old provenance pruned, got %v", err)
+	}
+}
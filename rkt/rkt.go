@@ -15,13 +15,23 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
+	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"syscall"
 	"text/tabwriter"
+	"time"
 
+	"github.com/coreos/rocket/cas"
+	"github.com/coreos/rocket/pkg/fscheck"
 	"github.com/coreos/rocket/pkg/keystore"
+	"github.com/coreos/rocket/rkt/cache"
+	"github.com/coreos/rocket/rkt/config"
+	"github.com/coreos/rocket/rkt/image"
 )
 
 const (
@@ -29,17 +39,25 @@ const (
 	cliDescription = "rocket, the application container runner"
 
 	defaultDataDir = "/var/lib/rkt"
+
+	defaultFetchRetries = 3
+	defaultFetchBackoff = time.Second
 )
 
 var (
 	globalFlagset = flag.NewFlagSet(cliName, flag.ExitOnError)
 	out           *tabwriter.Writer
 	commands      []*Command // Commands should register themselves by appending
+	authConfig    *config.Auth
 	globalFlags   = struct {
 		Dir                string
 		Debug              bool
 		Help               bool
 		InsecureSkipVerify bool
+		FetchRetries       int
+		FetchBackoff       time.Duration
+		ForceUnsupportedFS bool
+		Format             string
 	}{}
 )
 
@@ -48,6 +66,10 @@ func init() {
 	globalFlagset.BoolVar(&globalFlags.Debug, "debug", false, "Print out more debug information to stderr")
 	globalFlagset.StringVar(&globalFlags.Dir, "dir", defaultDataDir, "rocket data directory")
 	globalFlagset.BoolVar(&globalFlags.InsecureSkipVerify, "insecure-skip-verify", false, "skip image verification")
+	globalFlagset.IntVar(&globalFlags.FetchRetries, "fetch-retries", defaultFetchRetries, "number of times an interrupted image download is resumed")
+	globalFlagset.DurationVar(&globalFlags.FetchBackoff, "fetch-backoff", defaultFetchBackoff, "delay before resuming an interrupted image download, doubled on every retry")
+	globalFlagset.StringVar(&globalFlags.Format, "format", "", "print the output of the commands supporting it in this format: json, or raw for tab-separated values")
+	globalFlagset.BoolVar(&globalFlags.ForceUnsupportedFS, "force-unsupported-fs", false, "use the data directory even if it is on a filesystem lacking features rkt relies on, e.g. NFS or FAT")
 }
 
 type Command struct {
@@ -56,6 +78,7 @@ type Command struct {
 	Usage       string       // Usage options/arguments
 	Description string       // Detailed description of command
 	Flags       flag.FlagSet // Set of flags associated with this command
+	Formats     []string     // Output formats supported with --format, besides the default one
 
 	Run func(args []string) int // Run a command with the given arguments, return exit status
 
@@ -63,7 +86,7 @@ type Command struct {
 
 func init() {
 	out = new(tabwriter.Writer)
-	out.Init(os.Stdout, 0, 8, 1, '\t', 0)
+	out.Init(stdout, 0, 8, 1, '\t', 0)
 }
 
 func main() {
@@ -81,7 +104,7 @@ func main() {
 		if c.Name == args[0] {
 			cmd = c
 			if err := c.Flags.Parse(args[1:]); err != nil {
-				fmt.Fprintf(os.Stderr, "%v\n", err)
+				fmt.Fprintf(stderr, "%v\n", err)
 				os.Exit(2)
 			}
 			break
@@ -89,8 +112,12 @@ func main() {
 	}
 
 	if cmd == nil {
-		fmt.Fprintf(os.Stderr, "%v: unknown subcommand: %q\n", cliName, args[0])
-		fmt.Fprintf(os.Stderr, "Run '%v help' for usage.\n", cliName)
+		fmt.Fprintf(stderr, "%v: unknown subcommand: %q\n", cliName, args[0])
+		fmt.Fprintf(stderr, "Run '%v help' for usage.\n", cliName)
+		os.Exit(2)
+	}
+	if err := checkFormat(cmd, globalFlags.Format); err != nil {
+		fmt.Fprintf(stderr, "%v\n", err)
 		os.Exit(2)
 	}
 	os.Exit(cmd.Run(cmd.Flags.Args()))
@@ -112,6 +139,10 @@ func containersDir() string {
 	return filepath.Join(globalFlags.Dir, "containers")
 }
 
+func preparedDir() string {
+	return filepath.Join(globalFlags.Dir, "prepared")
+}
+
 func garbageDir() string {
 	return filepath.Join(globalFlags.Dir, "garbage")
 }
@@ -122,3 +153,107 @@ func getKeystore() *keystore.Keystore {
 	}
 	return keystore.New(nil)
 }
+
+// getAuth returns the credentials configured for image hosts in the auth.d
+// directories, loading them on first use. Besides being handed to the
+// fetcher explicitly, they are installed in http.DefaultTransport so that
+// discovery requests are authenticated as well.
+func getAuth() (*config.Auth, error) {
+	if authConfig != nil {
+		return authConfig, nil
+	}
+	a, err := config.DefaultAuth()
+	if err != nil {
+		return nil, fmt.Errorf("error loading auth configuration: %v", err)
+	}
+	http.DefaultTransport = a.Transport(http.DefaultTransport)
+	authConfig = a
+	return authConfig, nil
+}
+
+// getStore opens the image store, set up to encrypt images at rest if the
+// store configuration asks for it.
+func getStore() (*cas.Store, error) {
+	if err := checkDataDir(); err != nil {
+		return nil, err
+	}
+	ds := cas.NewStore(globalFlags.Dir)
+	sc, err := config.DefaultStore()
+	if err != nil {
+		return nil, fmt.Errorf("error loading store configuration: %v", err)
+	}
+	if sc.Encryption == nil {
+		return ds, nil
+	}
+	key, err := sc.Encryption.Key()
+	if err != nil {
+		return nil, err
+	}
+	if err := ds.SetEncryptionKey(key); err != nil {
+		return nil, fmt.Errorf("error setting up store encryption: %v", err)
+	}
+	return ds, nil
+}
+
+// checkDataDir fails if the data directory is on a filesystem lacking
+// features rkt relies on, unless told to use it anyway.
+func checkDataDir() error {
+	err := fscheck.Check(globalFlags.Dir)
+	if _, ok := err.(*fscheck.UnsupportedError); !ok {
+		return err
+	}
+	if globalFlags.ForceUnsupportedFS {
+		fmt.Fprintf(stderr, "Warning: the data directory %v; using it anyway as --force-unsupported-fs was given, expect failures\n", err)
+		return nil
+	}
+	return fmt.Errorf("the data directory %v. Choose one on another filesystem with --dir, or pass --force-unsupported-fs to use it anyway", err)
+}
+
+// getResolver returns an image resolver for the store in ds configured
+// from the global flags.
+func getResolver(ds *cas.Store) (*image.Resolver, error) {
+	a, err := getAuth()
+	if err != nil {
+		return nil, err
+	}
+	m, err := config.DefaultMirrors()
+	if err != nil {
+		return nil, fmt.Errorf("error loading mirrors configuration: %v", err)
+	}
+	return &image.Resolver{
+		Store:    ds,
+		Keystore: getKeystore(),
+		Auth:     a,
+		Retry: cas.RetryPolicy{
+			Retries: globalFlags.FetchRetries,
+			Backoff: globalFlags.FetchBackoff,
+		},
+		Out:     progressOut(),
+		Cache:   cache.New(cache.UserDir()),
+		Mirrors: m,
+	}, nil
+}
+
+// interruptContext returns a context canceled when rkt is interrupted or
+// terminated, so that long operations stop and clean up after themselves.
+// A second signal is no longer caught and kills rkt. stop must be called
+// once the operation is over.
+func interruptContext() (ctx context.Context, stop func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-c:
+			fmt.Fprintln(stderr, "Interrupted, cleaning up")
+			cancel()
+		case <-done:
+		}
+		signal.Stop(c)
+	}()
+	return ctx, func() {
+		close(done)
+		cancel()
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//+build linux
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/coreos/rocket/pkg/lock"
+)
+
+const cmdRmName = "rm"
+
+var (
+	cmdRm = &Command{
+		Name:    cmdRmName,
+		Summary: "Remove exited rkt containers",
+		Usage:   "[--all [--app=NAME] [--annotation=NAME=VALUE]] [UUID...]",
+		Description: `Removes the containers right away, as "rkt gc" does once they have been in the
+garbage for its grace period. Running containers are left alone.`,
+		Run: runRm,
+	}
+	flagRmAll bool
+)
+
+func init() {
+	commands = append(commands, cmdRm)
+	cmdRm.Flags.BoolVar(&flagRmAll, "all", false, "remove all the exited containers")
+	flagFilter.addFlags(&cmdRm.Flags)
+}
+
+func runRm(args []string) (exit int) {
+	uuids, ok := selectContainers(cmdRmName, args, flagRmAll)
+	if !ok {
+		return 1
+	}
+	if err := os.MkdirAll(garbageDir(), 0755); err != nil {
+		fmt.Fprintf(stderr, "Unable to create garbage dir: %v\n", err)
+		return 1
+	}
+
+	for _, uuid := range uuids {
+		cp := filepath.Join(containersDir(), uuid)
+		l, err := lock.TryExclusiveLock(cp)
+		if err == lock.ErrLocked {
+			if !flagRmAll {
+				fmt.Fprintf(stderr, "Container %q is running\n", uuid)
+				exit = 1
+			}
+			continue
+		}
+		if err != nil {
+			if err == lock.ErrNotExist {
+				err = fmt.Errorf("container not found")
+			}
+			fmt.Fprintf(stderr, "Unable to remove container %q: %v\n", uuid, err)
+			exit = 1
+			continue
+		}
+		if !rmContainer(cp, uuid) {
+			exit = 1
+		}
+		l.Close()
+	}
+	return
+}
+
+// rmContainer removes the exited container in cp, locked exclusively by the
+// caller, if it matches the filters. It goes through the garbage directory
+// so that "rkt gc" completes an interrupted removal.
+func rmContainer(cp, uuid string) bool {
+	if ok, err := flagFilter.matchDir(cp); err != nil {
+		fmt.Fprintf(stderr, "Unable to remove container %q: %v\n", uuid, err)
+		return false
+	} else if !ok {
+		return true
+	}
+
+	gp := filepath.Join(garbageDir(), uuid)
+	if err := os.Rename(cp, gp); err != nil {
+		fmt.Fprintf(stderr, "Unable to remove container %q: %v\n", uuid, err)
+		return false
+	}
+	if !removeContainer(gp, uuid) {
+		return false
+	}
+	fmt.Fprintln(stdout, uuid)
+	return true
+}
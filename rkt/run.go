@@ -18,158 +18,887 @@ package main
 
 import (
 	"errors"
+	"flag"
 	"fmt"
 	"io/ioutil"
 	"log"
+	"net"
 	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/appc/spec/schema"
 	"github.com/appc/spec/schema/types"
-	"github.com/coreos/rocket/cas"
-	"github.com/coreos/rocket/pkg/keystore"
+	"github.com/coreos/rocket/networking"
+	"github.com/coreos/rocket/pkg/caps"
+	"github.com/coreos/rocket/pkg/cgroup"
+	"github.com/coreos/rocket/pkg/coredump"
+	"github.com/coreos/rocket/pkg/events"
+	"github.com/coreos/rocket/pkg/mounts"
+	"github.com/coreos/rocket/pkg/quota"
+	"github.com/coreos/rocket/pkg/rootfs"
+	"github.com/coreos/rocket/pkg/seccomp"
+	"github.com/coreos/rocket/pkg/trace"
+	"github.com/coreos/rocket/pkg/watchdog"
+	"github.com/coreos/rocket/rkt/config"
+	"github.com/coreos/rocket/rkt/image"
 	"github.com/coreos/rocket/stage0"
+	"github.com/coreos/rocket/volume"
 )
 
 var (
 	flagStage1Init   string
 	flagStage1Rootfs string
-	flagVolumes      volumeMap
-	flagPrivateNet   bool
+	flagStage1Flavor string
+	flagStage1Image  string
+	flagVolumes      = volumeMap{}
+	flagVolDrivers   = volumeDriverMap{}
+	flagPrivateNet   networking.NetList
+	flagNet          netMode
+	flagPorts        networking.PortList
+	flagSetupTimeout time.Duration
+	flagAnnotations  string
+	flagAnnotMaxSize int64
+	flagVerity       bool
+	flagTreeStore    = treeStoreMode(stage0.TreeStoreAuto)
+	flagNoOverlay    bool
+	flagImageSource  string
+	flagDiskQuota    diskQuota
+	flagUUID         string
+	flagUUIDSeed     string
+	flagDNS          stringList
+	flagDNSSearch    stringList
+	flagDNSOpt       stringList
+	flagTrace        string
+	flagMounts       mountList
+	flagStrict       bool
+	flagPodManifest  string
+	flagPodSig       string
+	flagNetReady     time.Duration
+	flagNetReadyPol  = networking.ReadyAbort
+	flagRegisterMach bool
+	flagMemory       string
+	flagCPUShares    string
+	flagBlkioWeight  string
+	flagBlkioRBPS    stringList
+	flagBlkioWBPS    stringList
+	flagBlkioRIOPS   stringList
+	flagBlkioWIOPS   stringList
+	flagDevices      stringList
+	flagCPUSetCPUs   string
+	flagCPUSetMems   string
+	flagCapsRetain   string
+	flagCapsRemove   string
+	flagCoreDumps    string
+	flagSeccomp      string
+	flagReadOnlyRoot bool
+	flagHardened     bool
+	flagAppArmor     stringList
+	flagPreflight    cgroup.PreflightPolicy
 	cmdRun           = &Command{
 		Name:    "run",
 		Summary: "Run image(s) in an application container in rocket",
-		Usage:   "[--volume LABEL:SOURCE] IMAGE...",
+		Usage:   "[--quiet] [--volume LABEL:SOURCE] [--annotation-file FILE] IMAGE... | --pod-manifest=FILE|URL",
 		Description: `IMAGE should be a string referencing an image; either a hash, local file on disk, or URL.
-They will be checked in that order and the first match will be used.`,
+They will be checked in that order and the first match will be used.
+--image-source=store|file|discovery restricts the lookup to images in the store (by hash or name),
+local files or fetching by name or URL, so scripts get the same image whatever files are around.
+Images in Docker registries can be referenced as docker://REGISTRY/REPO:TAG, or REPO@DIGEST;
+they are converted to ACIs once per digest, as are OCI bundles (directories holding a config.json).
+A directory or a glob pattern (e.g. ./out/*.aci) is expanded to all the local ACIs it refers to.
+Instead of images, --pod-manifest takes a container runtime manifest, whose detached signature must
+be made by a key trusted for all its apps; their images are then found by image ID or name.`,
 		Run: runRun,
 	}
 )
 
 func init() {
 	commands = append(commands, cmdRun)
-	cmdRun.Flags.StringVar(&flagStage1Init, "stage1-init", "", "path to stage1 binary override")
-	cmdRun.Flags.StringVar(&flagStage1Rootfs, "stage1-rootfs", "", "path to stage1 rootfs tarball override")
-	cmdRun.Flags.Var(&flagVolumes, "volume", "volumes to mount into the shared container environment")
-	cmdRun.Flags.BoolVar(&flagPrivateNet, "private-net", false, "give container a private network")
-	flagVolumes = volumeMap{}
-}
-
-// findImages will recognize a ACI hash and use that, import a local file, use
-// discovery or download an ACI directly.
-func findImages(args []string, ds *cas.Store, ks *keystore.Keystore) (out []types.Hash, err error) {
-	out = make([]types.Hash, len(args))
-	for i, img := range args {
-		// check if it is a valid hash, if so let it pass through
-		h, err := types.NewHash(img)
-		if err == nil {
-			fullKey, err := ds.ResolveKey(img)
-			if err != nil {
-				return nil, fmt.Errorf("could not resolve key: %v", err)
-			}
-			h, err = types.NewHash(fullKey)
-			if err != nil {
-				// should never happen
-				panic(err)
-			}
-			out[i] = *h
-			continue
+	addRunFlags(&cmdRun.Flags)
+	cmdRun.Flags.StringVar(&flagTrace, "trace", "", "write how long resolving, fetching, verifying and rendering the images, setting up the network and starting stage1 took to this file, as a JSON tree of spans, once the apps are about to start")
+}
+
+// addRunFlags registers the flags setting up containers, shared by run and
+// prepare, on fs
+func addRunFlags(fs *flag.FlagSet) {
+	fs.StringVar(&flagStage1Init, "stage1-init", "", "path to stage1 binary override")
+	fs.StringVar(&flagStage1Rootfs, "stage1-rootfs", "", "path to stage1 rootfs tarball override")
+	fs.StringVar(&flagStage1Image, "stage1-image", "", "image to use as stage1, e.g. coreos.com/rkt/stage1-kvm:0.5.0, found like app images; it must be trusted in "+config.UserStage1File+" (default: the default image there, if any)")
+	fs.StringVar(&flagStage1Flavor, "stage1-flavor", "", "\"fly\" runs the single app of the container chrooted in its rootfs, with volumes, in the namespaces of the host, e.g. for trusted system agents needing full access to it")
+	fs.Var(&flagVolumes, "volume", "volumes to mount into the shared container environment, as NAME:PATH or NAME,kind=host,source=PATH[,readOnly=true][,recursive=true|ifEmpty=true] for a directory of the host, NAME,kind=empty[,readOnly=true][,mode=MODE] for an empty directory living as long as the container, or NAME,kind=tmpfs[,size=SIZE][,mode=MODE][,scope=pod|app] for a tmpfs shared by the apps or, with scope=app, of each app; each takes uid=UID and gid=GID to own its root, and all its files with recursive=true, or only while it is empty with ifEmpty=true")
+	fs.Var(&flagMounts, "mount", "mount a volume on a path the images may not declare a mount point at, as volume=NAME,target=PATH[,readOnly=true], in all the apps or only the given one with app=NAME, replacing a mount point declared on the same path (may be given more than once)")
+	fs.Var(&flagVolDrivers, "volume-driver", "volumes to provision with a volume driver, as LABEL:DRIVER[,KEY=VALUE...]")
+	fs.Var(&flagPrivateNet, "private-net", "give container a private network, attached to all the nets in /etc/rkt/net.d or only to the given comma-separated list of them (e.g. --private-net=default,backend), a net name may be followed by arguments for its plugin (e.g. --private-net=backend:IP=10.1.2.3)")
+	fs.Var(&flagNet, "net", "\"host\" shares the network stack of the host with the container, as without --private-net, which it can't be combined with")
+	fs.Var(&flagPorts, "port", "forward a port of an app, named as in its image manifest, from the given port of the host, as NAME:HOSTPORT (requires --private-net)")
+	fs.DurationVar(&flagSetupTimeout, "setup-timeout", 0, "abort, dumping diagnostics, if fetching images and setting up the container takes longer than this (0 disables)")
+	fs.StringVar(&flagAnnotations, "annotation-file", "", "JSON file mapping container annotation names to values")
+	fs.Int64Var(&flagAnnotMaxSize, "annotation-max-size", defaultAnnotationMaxSize, "maximum size in bytes of the annotation file")
+	fs.BoolVar(&flagVerity, "verity", false, "mount app rootfs read-only through dm-verity to detect tampering (requires mksquashfs and veritysetup)")
+	fs.Var(&flagTreeStore, "tree-store", "render each image once in the store and reuse it, mounted through an overlay (\"overlay\"), as hard-link copies (\"hardlink\") or as ZFS clones (\"zfs\"); \"auto\" uses ZFS clones on ZFS and an overlay elsewhere where supported, \"none\" extracts images into each container")
+	fs.BoolVar(&flagNoOverlay, "no-overlay", false, "never mount app rootfs through an overlay, e.g. on filesystems overlayfs doesn't support")
+	fs.BoolVar(&flagStrict, "strict", false, "fail rather than warn of misconfigurations: volumes no app mounts, unknown os/linux/ or coreos.com/rkt/ annotations, images found both as a local file and in the store, preflight checks failing; images are only discovered over https and must be verified")
+	fs.StringVar(&flagImageSource, "image-source", "", "only look for images in the store, local files or through discovery (store, file or discovery)")
+	fs.StringVar(&flagUUID, "uuid", "", "UUID of the container instead of a random one, which must not be taken by another container")
+	fs.StringVar(&flagUUIDSeed, "uuid-seed", "", "derive the UUID of the container from this string (as a version 5 UUID), so that retries of the same job get the same UUID")
+	fs.BoolVar(&flagQuiet, "quiet", false, "don't report the progress of fetching images on stderr")
+	fs.Var(&flagDNS, "dns", "nameserver of the apps, replacing the ones returned by the network plugins or those of the host (may be given more than once)")
+	fs.Var(&flagDNSSearch, "dns-search", "DNS search domain of the apps (may be given more than once)")
+	fs.Var(&flagDNSOpt, "dns-opt", "resolv.conf option of the apps, e.g. ndots:2 (may be given more than once)")
+	fs.StringVar(&flagPodManifest, "pod-manifest", "", "run the apps, volumes and annotations of the container runtime manifest in this local file or https URL instead of images given as arguments")
+	fs.StringVar(&flagPodSig, "pod-manifest-signature", "", "detached signature of the pod manifest (default: the manifest location with .sig in place of .json)")
+	fs.DurationVar(&flagNetReady, "net-ready-timeout", 0, "wait up to this long, before starting the apps, for the nets to be ready: addresses assigned and gateways answering ARP or neighbor discovery (requires --private-net)")
+	fs.Var(&flagNetReadyPol, "net-ready-policy", "\"abort\" doesn't start the apps when the nets aren't ready in time, \"continue\" starts them anyway")
+	fs.BoolVar(&flagRegisterMach, "register-machine", true, "register the container with systemd-machined as rkt-UUID when the host runs systemd, for machinectl and journalctl -M to work with it (false on hosts running systemd without machined)")
+	fs.StringVar(&flagMemory, "memory", "", "limit the memory of each app (e.g. 512M), overriding the resource/memory isolators of the images")
+	fs.StringVar(&flagCPUShares, "cpu-shares", "", "CPU shares of each app, its weight when apps compete for CPU time (from 2 to 262144, 1024 by default), overriding the resource/cpu isolators of the images")
+	fs.StringVar(&flagBlkioWeight, "blkio-weight", "", "block I/O weight of the container relative to other cgroups (from 10 to 1000)")
+	fs.Var(&flagBlkioRBPS, "blkio-read-bps", "limit the read bandwidth of the container to a block device, as DEVICE:RATE, e.g. /dev/sda:10M (may be given more than once)")
+	fs.Var(&flagBlkioWBPS, "blkio-write-bps", "limit the write bandwidth of the container to a block device, as DEVICE:RATE (may be given more than once)")
+	fs.Var(&flagBlkioRIOPS, "blkio-read-iops", "limit the read operations per second of the container on a block device, as DEVICE:N (may be given more than once)")
+	fs.Var(&flagBlkioWIOPS, "blkio-write-iops", "limit the write operations per second of the container on a block device, as DEVICE:N (may be given more than once)")
+	fs.Var(&flagDevices, "device", "make a device of the host available to the apps, as DEVICE[:ACCESS] with ACCESS a combination of r, w and m (default rwm); access to other devices is then denied (may be given more than once)")
+	fs.StringVar(&flagCPUSetCPUs, "cpuset-cpus", "", "pin the container to these CPUs, as a list of numbers and ranges, e.g. 0-3,8")
+	fs.StringVar(&flagCPUSetMems, "cpuset-mems", "", "restrict the memory of the container to these NUMA nodes, as a list of numbers and ranges, e.g. 0")
+	fs.StringVar(&flagCapsRetain, "caps-retain", "", "restrict the capabilities of each app to these, e.g. CAP_NET_BIND_SERVICE,CAP_CHOWN, overriding the capability isolators of the images")
+	fs.StringVar(&flagSeccomp, "seccomp", "", "restrict the system calls of each app with a seccomp profile, \"none\", \"default\", \"strict\" or the path of a profile file, overriding the seccomp isolators of the images")
+	fs.BoolVar(&flagReadOnlyRoot, "read-only-rootfs", false, "make the rootfs of each app read-only, but for its volumes and a tmpfs on /tmp, overriding the os/linux/read-only-rootfs isolators of the images")
+	fs.BoolVar(&flagHardened, "hardened", false, "keep the apps from gaining privileges, e.g. through setuid binaries, and mask the sensitive files of /proc and /sys, overriding the os/linux/hardened annotations of the images")
+	fs.Var(&flagAppArmor, "apparmor-profile", "confine the apps by this AppArmor profile, loaded or the path of a profile file, or only the given app as APP=PROFILE (may be given more than once), overriding the annotations of the images")
+	fs.StringVar(&flagCapsRemove, "caps-remove", "", "remove these capabilities from each app, e.g. CAP_NET_RAW, overriding the capability isolators of the images")
+	fs.StringVar(&flagCoreDumps, "core-dumps", "", "capture the core dumps of the apps, when kernel.core_pattern pipes them to \"rkt coredump\": \"pod\" keeps them in the container directory, up to 1G in total or the size given as pod:SIZE, \"host\" forwards them to the handler of the host")
+	fs.Var(&flagPreflight, "preflight", "check the memory and CPU shares reserved by the isolators of the apps against the host, counting those of the other prepared and running containers (a CPU being 1024 shares): \"warn\" only warns when they don't fit, \"refuse\" doesn't set up the container")
+	fs.Var(&flagDiskQuota, "disk-quota", "limit the disk space the container's files may use (e.g. 10G), through a project quota on the container directory (requires xfs or ext4 mounted with prjquota)")
+}
+
+func runRun(args []string) (exit int) {
+	cfg, cdir, exit := setupContainer("run", args, containersDir())
+	if exit != 0 {
+		return exit
+	}
+	recordEvent(events.Run, filepath.Base(cdir))
+	stage0.Run(cfg, cdir) // execs, never returns
+	return 1
+}
+
+// setupContainer fetches the images given in args and sets up a container
+// running them in dir as the flags of cmd say. It returns the config to run
+// the container with and its directory, or the exit status of cmd on errors.
+func setupContainer(cmd string, args []string, dir string) (stage0.Config, string, int) {
+	var cfg stage0.Config
+	if flagPodManifest != "" && len(args) > 0 {
+		fmt.Fprintf(stderr, "%s: --pod-manifest conflicts with images given as arguments\n", cmd)
+		return cfg, "", 1
+	}
+	if flagPodManifest == "" && len(args) < 1 {
+		fmt.Fprintf(stderr, "%s: Must provide at least one image\n", cmd)
+		return cfg, "", 1
+	}
+	if globalFlags.Dir == "" {
+		log.Printf("dir unset - using temporary directory")
+		var err error
+		globalFlags.Dir, err = ioutil.TempDir("", "rkt")
+		if err != nil {
+			fmt.Fprintf(stderr, "error creating temporary directory: %v\n", err)
+			return cfg, "", 1
 		}
+	}
 
-		// import the local file if it exists
-		file, err := os.Open(img)
-		if err == nil {
-			key, err := ds.WriteACI(file)
-			file.Close()
-			if err != nil {
-				return nil, fmt.Errorf("%s: %v", img, err)
-			}
-			h, err := types.NewHash(key)
-			if err != nil {
-				// should never happen
-				panic(err)
-			}
-			out[i] = *h
-			continue
+	if flagStrict && globalFlags.InsecureSkipVerify {
+		fmt.Fprintf(stderr, "%s: --strict conflicts with --insecure-skip-verify\n", cmd)
+		return cfg, "", 1
+	}
+
+	treeStore := stage0.TreeStoreMode(flagTreeStore)
+	if flagNoOverlay {
+		switch treeStore {
+		case stage0.TreeStoreAuto:
+			treeStore = stage0.TreeStoreNone
+		case stage0.TreeStoreOverlay:
+			fmt.Fprintf(stderr, "%s: --no-overlay conflicts with --tree-store=overlay\n", cmd)
+			return cfg, "", 1
 		}
+	}
 
-		key, err := fetchImage(img, ds, ks)
-		if err != nil {
-			return nil, err
+	if flagNet == netHost && flagPrivateNet.Enabled() {
+		fmt.Fprintf(stderr, "%s: --net=host conflicts with --private-net\n", cmd)
+		return cfg, "", 1
+	}
+
+	switch {
+	case flagStage1Flavor == "":
+	case flagStage1Flavor != stage0.Stage1FlavorFly:
+		fmt.Fprintf(stderr, "%s: unknown stage1 flavor %q\n", cmd, flagStage1Flavor)
+		return cfg, "", 1
+	case flagStage1Rootfs != "":
+		fmt.Fprintf(stderr, "%s: --stage1-flavor=fly conflicts with --stage1-rootfs\n", cmd)
+		return cfg, "", 1
+	case flagPrivateNet.Enabled():
+		fmt.Fprintf(stderr, "%s: --stage1-flavor=fly conflicts with --private-net\n", cmd)
+		return cfg, "", 1
+	case flagStage1Image != "":
+		fmt.Fprintf(stderr, "%s: --stage1-flavor=fly conflicts with --stage1-image\n", cmd)
+		return cfg, "", 1
+	}
+	if flagStage1Image != "" && flagStage1Rootfs != "" {
+		fmt.Fprintf(stderr, "%s: --stage1-image conflicts with --stage1-rootfs\n", cmd)
+		return cfg, "", 1
+	}
+
+	if flagNetReady > 0 && !flagPrivateNet.Enabled() {
+		fmt.Fprintf(stderr, "%s: --net-ready-timeout requires --private-net\n", cmd)
+		return cfg, "", 1
+	}
+
+	isolators, err := isolatorFlags()
+	if err != nil {
+		fmt.Fprintf(stderr, "%s: %v\n", cmd, err)
+		return cfg, "", 1
+	}
+	appArmor, err := appArmorFlags()
+	if err != nil {
+		fmt.Fprintf(stderr, "%s: --apparmor-profile: %v\n", cmd, err)
+		return cfg, "", 1
+	}
+	var coreDumps *coredump.Config
+	if flagCoreDumps != "" {
+		if coreDumps, err = coredump.Parse(flagCoreDumps); err != nil {
+			fmt.Fprintf(stderr, "%s: --core-dumps: %v\n", cmd, err)
+			return cfg, "", 1
 		}
-		h, err = types.NewHash(key)
-		if err != nil {
-			// should never happen
-			panic(err)
+	}
+
+	for key := range flagVolDrivers {
+		if _, ok := flagVolumes[key]; ok {
+			fmt.Fprintf(stderr, "%s: volume %q given both with --volume and --volume-driver\n", cmd, key)
+			return cfg, "", 1
 		}
-		out[i] = *h
 	}
 
-	return out, nil
-}
+	for _, ns := range flagDNS {
+		if net.ParseIP(ns) == nil {
+			fmt.Fprintf(stderr, "%s: invalid nameserver %q, want an IP address\n", cmd, ns)
+			return cfg, "", 1
+		}
+	}
 
-func runRun(args []string) (exit int) {
-	if len(args) < 1 {
-		fmt.Fprintf(os.Stderr, "run: Must provide at least one image\n")
-		return 1
+	source, err := image.ParseSource(flagImageSource)
+	if err != nil {
+		fmt.Fprintf(stderr, "%s: %v\n", cmd, err)
+		return cfg, "", 1
 	}
-	if globalFlags.Dir == "" {
-		log.Printf("dir unset - using temporary directory")
+
+	// a given UUID is checked before fetching anything, stage0 still
+	// catches containers created with it meanwhile
+	cuuid, err := containerUUID(flagUUID, flagUUIDSeed)
+	if err != nil {
+		fmt.Fprintf(stderr, "%s: %v\n", cmd, err)
+		return cfg, "", 1
+	}
+	if cuuid != nil {
+		if err := checkUUIDFree(cuuid, containersDir(), preparedDir(), garbageDir()); err != nil {
+			fmt.Fprintf(stderr, "%s: %v\n", cmd, err)
+			return cfg, "", 1
+		}
+	}
+
+	var annotations types.Annotations
+	if flagAnnotations != "" {
 		var err error
-		globalFlags.Dir, err = ioutil.TempDir("", "rkt")
+		annotations, err = loadAnnotations(flagAnnotations, flagAnnotMaxSize)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "error creating temporary directory: %v\n", err)
-			return 1
+			fmt.Fprintf(stderr, "%s: error loading annotations: %v\n", cmd, err)
+			return cfg, "", 1
 		}
 	}
 
-	ds := cas.NewStore(globalFlags.Dir)
-	ks := getKeystore()
-	imgs, err := findImages(args, ds, ks)
+	hooks, err := config.DefaultImageHooks()
+	if err != nil {
+		fmt.Fprintf(stderr, "%s: error loading image hooks: %v\n", cmd, err)
+		return cfg, "", 1
+	}
+
+	security, err := config.DefaultSecurity()
+	if err != nil {
+		fmt.Fprintf(stderr, "%s: error loading security configuration: %v\n", cmd, err)
+		return cfg, "", 1
+	}
+
+	stage1, err := config.DefaultStage1()
+	if err != nil {
+		fmt.Fprintf(stderr, "%s: error loading stage1 images: %v\n", cmd, err)
+		return cfg, "", 1
+	}
+
+	var tr *trace.Span
+	if flagTrace != "" {
+		tr = trace.New("rkt " + cmd)
+		// stage0 and stage1 work in the container directory
+		if flagTrace, err = filepath.Abs(flagTrace); err != nil {
+			fmt.Fprintf(stderr, "%s: --trace: %v\n", cmd, err)
+			return cfg, "", 1
+		}
+	}
+
+	wd := watchdog.Start(flagSetupTimeout, watchdog.PhaseFetch, stderr, func(err error) {
+		fmt.Fprintf(stderr, "%s: %v\n", cmd, err)
+		os.Exit(1)
+	})
+
+	ds, err := getStore()
+	if err != nil {
+		fmt.Fprintf(stderr, "%v\n", err)
+		return cfg, "", 1
+	}
+	r, err := getResolver(ds)
+	if err != nil {
+		fmt.Fprintf(stderr, "%v\n", err)
+		return cfg, "", 1
+	}
+	r.Source = source
+	r.Strict = flagStrict
+	ctx, stop := interruptContext()
+	defer stop()
+	if tr != nil {
+		ctx = trace.NewContext(ctx, tr)
+	}
+	var pm *schema.ContainerRuntimeManifest
+	var imgs []types.Hash
+	if flagPodManifest != "" {
+		sig := flagPodSig
+		if sig == "" {
+			sig = podManifestSigLocation(flagPodManifest)
+		}
+		pm, err = loadPodManifest(ctx, r.Keystore, flagPodManifest, sig)
+		if err == nil {
+			imgs, err = r.FindPodImages(ctx, pm.Apps)
+		}
+	} else {
+		imgs, err = r.FindImages(ctx, args)
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "%v\n", err)
+		return cfg, "", 1
+	}
+	if flagStage1Flavor == stage0.Stage1FlavorFly && len(imgs) != 1 {
+		fmt.Fprintf(stderr, "%s: --stage1-flavor=fly runs a single app, got %d\n", cmd, len(imgs))
+		return cfg, "", 1
+	}
+	var podAnnotations types.Annotations
+	if pm != nil {
+		podAnnotations = pm.Annotations
+	}
+	rule, err := selectStage1(ds, stage1, imgs, podAnnotations, annotations)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "%v\n", err)
-		return 1
+		fmt.Fprintf(stderr, "%s: %v\n", cmd, err)
+		return cfg, "", 1
+	}
+	stage1Img := flagStage1Image
+	if stage1Img == "" && flagStage1Rootfs == "" && flagStage1Flavor == "" {
+		stage1Img = stage1.Default
+		if rule != nil {
+			stage1Img = rule.Stage1
+		}
+	}
+	var stage1Hash *types.Hash
+	if stage1Img != "" {
+		if stage1Hash, err = findStage1Image(ctx, r, stage1, rule, stage1Img); err != nil {
+			fmt.Fprintf(stderr, "%s: %v\n", cmd, err)
+			return cfg, "", 1
+		}
+	}
+	dctx, span := trace.Start(ctx, "resolve dependencies")
+	deps := image.Dependencies{}
+	for _, img := range imgs {
+		if err := r.ResolveDependencies(dctx, img.String(), deps); err != nil {
+			fmt.Fprintf(stderr, "%v\n", err)
+			return cfg, "", 1
+		}
+	}
+	if stage1Hash != nil {
+		if err := r.ResolveDependencies(dctx, stage1Hash.String(), deps); err != nil {
+			fmt.Fprintf(stderr, "%v\n", err)
+			return cfg, "", 1
+		}
 	}
+	span.Finish()
 
-	cfg := stage0.Config{
+	cfg = stage0.Config{
 		Store:         ds,
-		ContainersDir: containersDir(),
+		ContainersDir: dir,
 		Debug:         globalFlags.Debug,
 		Stage1Init:    flagStage1Init,
 		Stage1Rootfs:  flagStage1Rootfs,
+		Stage1Flavor:  flagStage1Flavor,
 		Images:        imgs,
-		Volumes:       flagVolumes,
-		PrivateNet:    flagPrivateNet,
+		Volumes:       flagVolumes.volumes(),
+		VolumeOptions: flagVolumes.options(),
+		DriverVolumes: flagVolDrivers,
+		PrivateNet:    flagPrivateNet.Enabled(),
+		Networks:      flagPrivateNet.Names(),
+		NetArgs:       flagPrivateNet.Args(),
+		Ports:         flagPorts,
+		Watchdog:      wd,
+		Annotations:   annotations,
+		Verity:        flagVerity,
+		Dependencies:  deps,
+		TreeStore:     treeStore,
+		DiskQuota:     uint64(flagDiskQuota),
+		UUID:          cuuid,
+		DNS:           flagDNS,
+		DNSSearch:     flagDNSSearch,
+		DNSOptions:    flagDNSOpt,
+		PodManifest:   pm,
+
+		NetReadyTimeout: flagNetReady,
+		NetReadyPolicy:  flagNetReadyPol,
+		RegisterMachine: flagRegisterMach,
+		Stage1Image:     stage1Hash,
+		Isolators:       isolators,
+		CoreDumps:       coreDumps,
+		PreparedDir:     preparedDir(),
+		RunningDir:      containersDir(),
+		SELinux:         !security.SELinux.Disabled && flagStage1Flavor != stage0.Stage1FlavorFly,
+		SELinuxProcess:  security.SELinux.ProcessContext,
+		SELinuxFile:     security.SELinux.FileContext,
+		AppArmor:        appArmor,
+		Preflight:       flagPreflight,
+		Hardened:        flagHardened,
+		Trace:           tr,
+		TraceFile:       flagTrace,
+		Mounts:          flagMounts,
+		Strict:          flagStrict,
 	}
-	cdir, err := stage0.Setup(cfg)
+	if !hooks.Empty() {
+		cfg.ImageCheck = func(name types.ACName, img types.Hash, rootfs string) error {
+			return hooks.Check(string(name), img.String(), rootfs)
+		}
+	}
+	sctx, span := trace.Start(ctx, "setup")
+	cdir, err := stage0.Setup(sctx, cfg)
+	span.Finish()
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "run: error setting up stage0: %v\n", err)
-		return 1
+		fmt.Fprintf(stderr, "%s: error setting up stage0: %v\n", cmd, err)
+		return cfg, "", 1
 	}
-	stage0.Run(cfg, cdir) // execs, never returns
-	return 1
+	if err := writeProvenance(cfg, cdir, r); err != nil {
+		fmt.Fprintf(stderr, "%s: error recording provenance: %v\n", cmd, err)
+		return cfg, "", 1
+	}
+	return cfg, cdir, 0
+}
+
+// isolatorFlags returns the isolators given by --memory, --cpu-shares, the
+// block I/O, device and cpuset flags, --caps-retain or --caps-remove,
+// --seccomp and --read-only-rootfs
+func isolatorFlags() (types.Isolators, error) {
+	var isolators types.Isolators
+	if flagMemory != "" {
+		if _, err := cgroup.ParseMemory(flagMemory); err != nil {
+			return nil, fmt.Errorf("--memory: %v", err)
+		}
+		isolators = append(isolators, types.Isolator{Name: cgroup.MemoryIsolator, Val: flagMemory})
+	}
+	if flagCPUShares != "" {
+		if _, err := cgroup.ParseCPUShares(flagCPUShares); err != nil {
+			return nil, fmt.Errorf("--cpu-shares: %v", err)
+		}
+		isolators = append(isolators, types.Isolator{Name: cgroup.CPUIsolator, Val: flagCPUShares})
+	}
+	if flagBlkioWeight != "" {
+		if _, err := cgroup.ParseBlockIOWeight(flagBlkioWeight); err != nil {
+			return nil, fmt.Errorf("--blkio-weight: %v", err)
+		}
+		isolators = append(isolators, types.Isolator{Name: cgroup.BlockIOWeightIsolator, Val: flagBlkioWeight})
+	}
+	for _, f := range []struct {
+		flag   string
+		name   string
+		values stringList
+	}{
+		{"blkio-read-bps", cgroup.BlockIOReadBandwidthIsolator, flagBlkioRBPS},
+		{"blkio-write-bps", cgroup.BlockIOWriteBandwidthIsolator, flagBlkioWBPS},
+		{"blkio-read-iops", cgroup.BlockIOReadIOPSIsolator, flagBlkioRIOPS},
+		{"blkio-write-iops", cgroup.BlockIOWriteIOPSIsolator, flagBlkioWIOPS},
+	} {
+		for _, v := range f.values {
+			i := strings.LastIndex(v, ":")
+			if i < 0 {
+				return nil, fmt.Errorf("--%s: invalid limit %q, want DEVICE:LIMIT", f.flag, v)
+			}
+			val := v[:i] + " " + v[i+1:]
+			if _, _, err := cgroup.ParseBlockIOLimit(f.name, val); err != nil {
+				return nil, fmt.Errorf("--%s: %v", f.flag, err)
+			}
+			isolators = append(isolators, types.Isolator{Name: types.ACName(f.name), Val: val})
+		}
+	}
+	for _, v := range flagDevices {
+		val := v
+		if i := strings.LastIndex(v, ":"); i >= 0 {
+			val = v[:i] + " " + v[i+1:]
+		}
+		if _, _, err := cgroup.ParseDevice(val); err != nil {
+			return nil, fmt.Errorf("--device: %v", err)
+		}
+		isolators = append(isolators, types.Isolator{Name: cgroup.DeviceIsolator, Val: val})
+	}
+	if flagCPUSetCPUs != "" {
+		if _, err := cgroup.ParseCPUList(flagCPUSetCPUs); err != nil {
+			return nil, fmt.Errorf("--cpuset-cpus: %v", err)
+		}
+		isolators = append(isolators, types.Isolator{Name: cgroup.CPUSetCPUsIsolator, Val: flagCPUSetCPUs})
+	}
+	if flagCPUSetMems != "" {
+		if _, err := cgroup.ParseCPUList(flagCPUSetMems); err != nil {
+			return nil, fmt.Errorf("--cpuset-mems: %v", err)
+		}
+		isolators = append(isolators, types.Isolator{Name: cgroup.CPUSetMemsIsolator, Val: flagCPUSetMems})
+	}
+	if flagCapsRetain != "" && flagCapsRemove != "" {
+		return nil, errors.New("--caps-retain and --caps-remove can't be combined")
+	}
+	for _, f := range []struct {
+		flag, name, val string
+	}{
+		{"caps-retain", caps.RetainSetIsolator, flagCapsRetain},
+		{"caps-remove", caps.RemoveSetIsolator, flagCapsRemove},
+	} {
+		if f.val == "" {
+			continue
+		}
+		set, err := caps.Parse(f.val)
+		if err != nil {
+			return nil, fmt.Errorf("--%s: %v", f.flag, err)
+		}
+		isolators = append(isolators, types.Isolator{Name: types.ACName(f.name), Val: strings.Join(set, " ")})
+	}
+	if flagSeccomp != "" {
+		f, err := seccomp.Load(flagSeccomp)
+		if err != nil {
+			return nil, fmt.Errorf("--seccomp: %v", err)
+		}
+		// an empty remove set overrides the default profile of stage1
+		name, val := seccomp.RemoveSetIsolator, ""
+		if f != nil {
+			name, val = f.Isolator()
+		}
+		isolators = append(isolators, types.Isolator{Name: types.ACName(name), Val: val})
+	}
+	if flagReadOnlyRoot {
+		isolators = append(isolators, types.Isolator{Name: rootfs.ReadOnlyIsolator, Val: "true"})
+	}
+	return isolators, nil
+}
+
+// appArmorFlags returns the AppArmor profiles given by --apparmor-profile by
+// app name, the empty name for all the apps
+func appArmorFlags() (map[string]string, error) {
+	if len(flagAppArmor) == 0 {
+		return nil, nil
+	}
+	profiles := make(map[string]string)
+	for _, v := range flagAppArmor {
+		app, profile := "", v
+		if kv := strings.SplitN(v, "=", 2); len(kv) == 2 {
+			if _, err := types.NewACName(kv[0]); err == nil {
+				app, profile = kv[0], kv[1]
+			}
+		}
+		if profile == "" {
+			return nil, fmt.Errorf("empty profile in %q", v)
+		}
+		if _, ok := profiles[app]; ok {
+			return nil, fmt.Errorf("profile of app %q given more than once", app)
+		}
+		profiles[app] = profile
+	}
+	return profiles, nil
 }
 
-// volumeMap implements the flag.Value interface to contain a set of mappings
-// from mount label --> mount path
-type volumeMap map[string]string
+// volumeMap implements the flag.Value interface to contain the volumes
+// given on the command line, by name: as NAME:PATH for a directory of the
+// host, or as NAME followed by comma-separated options
+type volumeMap map[string]volumeFlag
+
+// volumeFlag is a volume given with --volume, with the options stage1
+// sets it up with
+type volumeFlag struct {
+	types.Volume
+	opts volume.Options
+}
 
 func (vm *volumeMap) Set(s string) error {
-	elems := strings.Split(s, ":")
+	var name string
+	v := volumeFlag{Volume: types.Volume{Kind: "host"}}
+	if i := strings.Index(s, ":"); i >= 0 && !strings.Contains(s[:i], ",") {
+		elems := strings.Split(s, ":")
+		if len(elems) != 2 {
+			return errors.New("volume must be of form key:path")
+		}
+		name, v.Source = elems[0], elems[1]
+	} else {
+		opts := strings.Split(s, ",")
+		name = opts[0]
+		for _, o := range opts[1:] {
+			kv := strings.SplitN(o, "=", 2)
+			if len(kv) != 2 {
+				return fmt.Errorf("volume option must be of form option=value, got %q", o)
+			}
+			switch kv[0] {
+			case "kind":
+				v.Kind = kv[1]
+			case "source":
+				v.Source = kv[1]
+			case "readOnly":
+				ro, err := strconv.ParseBool(kv[1])
+				if err != nil {
+					return fmt.Errorf("invalid readOnly %q, want true or false", kv[1])
+				}
+				v.ReadOnly = ro
+			case "size":
+				v.opts.Size = kv[1]
+			case "mode":
+				v.opts.Mode = kv[1]
+			case "scope":
+				v.opts.Scope = kv[1]
+			case "uid":
+				v.opts.UID = kv[1]
+			case "gid":
+				v.opts.GID = kv[1]
+			case "recursive":
+				r, err := strconv.ParseBool(kv[1])
+				if err != nil {
+					return fmt.Errorf("invalid recursive %q, want true or false", kv[1])
+				}
+				v.opts.Recursive = r
+			case "ifEmpty":
+				e, err := strconv.ParseBool(kv[1])
+				if err != nil {
+					return fmt.Errorf("invalid ifEmpty %q, want true or false", kv[1])
+				}
+				v.opts.IfEmpty = e
+			default:
+				return fmt.Errorf("unknown volume option %q", kv[0])
+			}
+		}
+		switch v.Kind {
+		case "host":
+			if v.Source == "" {
+				return fmt.Errorf("host volume %q without source", name)
+			}
+		case "empty", volume.KindTmpfs:
+			if v.Source != "" {
+				return fmt.Errorf("%s volume %q takes no source", v.Kind, name)
+			}
+		default:
+			return fmt.Errorf("unknown volume kind %q, want host, empty or tmpfs", v.Kind)
+		}
+		if err := v.opts.Check(v.Kind); err != nil {
+			return fmt.Errorf("volume %q: %v", name, err)
+		}
+	}
+	n, err := types.NewACName(name)
+	if err != nil {
+		return fmt.Errorf("invalid volume name %q: %v", name, err)
+	}
+	if _, ok := (*vm)[name]; ok {
+		return fmt.Errorf("got multiple flags for volume %q", name)
+	}
+	v.Fulfills = []types.ACName{*n}
+	(*vm)[name] = v
+	return nil
+}
+
+func (vm *volumeMap) String() string {
+	var ss []string
+	for _, v := range vm.volumes() {
+		name := v.Fulfills[0]
+		s := name.String() + ",kind=" + v.Kind
+		if v.Source != "" {
+			s += ",source=" + v.Source
+		}
+		if v.ReadOnly {
+			s += ",readOnly=true"
+		}
+		o := (*vm)[name.String()].opts
+		for _, kv := range [][2]string{{"size", o.Size}, {"mode", o.Mode}, {"scope", o.Scope}, {"uid", o.UID}, {"gid", o.GID}} {
+			if kv[1] != "" {
+				s += "," + kv[0] + "=" + kv[1]
+			}
+		}
+		if o.Recursive {
+			s += ",recursive=true"
+		}
+		if o.IfEmpty {
+			s += ",ifEmpty=true"
+		}
+		ss = append(ss, s)
+	}
+	return strings.Join(ss, " ")
+}
+
+// volumes returns the volumes of vm sorted by name
+func (vm *volumeMap) volumes() []types.Volume {
+	names := make([]string, 0, len(*vm))
+	for n := range *vm {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	vols := make([]types.Volume, len(names))
+	for i, n := range names {
+		vols[i] = (*vm)[n].Volume
+	}
+	return vols
+}
+
+// options returns the options of the volumes of vm set up otherwise than
+// by default, by name
+func (vm *volumeMap) options() map[types.ACName]volume.Options {
+	opts := make(map[types.ACName]volume.Options)
+	for _, v := range *vm {
+		if !v.opts.Empty() {
+			opts[v.Fulfills[0]] = v.opts
+		}
+	}
+	return opts
+}
+
+// volumeDriverMap implements the flag.Value interface to contain a set of
+// mappings from mount label --> volume provisioned by a driver
+type volumeDriverMap map[string]volume.Spec
+
+func (vm *volumeDriverMap) Set(s string) error {
+	elems := strings.SplitN(s, ":", 2)
 	if len(elems) != 2 {
-		return errors.New("volume must be of form key:path")
+		return errors.New("volume must be of form key:driver[,option=value...]")
 	}
 	key := elems[0]
 	if _, ok := (*vm)[key]; ok {
 		return fmt.Errorf("got multiple flags for volume %q", key)
 	}
-	(*vm)[key] = elems[1]
+	opts := strings.Split(elems[1], ",")
+	spec := volume.Spec{
+		Driver:  opts[0],
+		Options: make(map[string]string),
+	}
+	if spec.Driver == "" {
+		return fmt.Errorf("no driver given for volume %q", key)
+	}
+	for _, o := range opts[1:] {
+		kv := strings.SplitN(o, "=", 2)
+		if len(kv) != 2 {
+			return fmt.Errorf("volume option must be of form option=value, got %q", o)
+		}
+		spec.Options[kv[0]] = kv[1]
+	}
+	(*vm)[key] = spec
 	return nil
 }
 
-func (vm *volumeMap) String() string {
+func (vm *volumeDriverMap) String() string {
 	var ss []string
 	for k, v := range *vm {
-		ss = append(ss, fmt.Sprintf("%s:%s", k, v))
+		s := k + ":" + v.Driver
+		for o, val := range v.Options {
+			s += "," + o + "=" + val
+		}
+		ss = append(ss, s)
+	}
+	return strings.Join(ss, " ")
+}
+
+// netMode implements the flag.Value interface to select the networking of
+// the container, other than a private one
+type netMode string
+
+// netHost shares the network stack of the host
+const netHost netMode = "host"
+
+func (m *netMode) Set(s string) error {
+	if netMode(s) != netHost {
+		return fmt.Errorf("unknown networking %q, only %q is supported (see --private-net)", s, netHost)
+	}
+	*m = netMode(s)
+	return nil
+}
+
+func (m *netMode) String() string {
+	return string(*m)
+}
+
+// treeStoreMode implements the flag.Value interface to select how the
+// tree store is used
+type treeStoreMode stage0.TreeStoreMode
+
+func (m *treeStoreMode) Set(s string) error {
+	if s == "none" {
+		s = string(stage0.TreeStoreNone)
 	}
-	return strings.Join(ss, ",")
+	switch stage0.TreeStoreMode(s) {
+	case stage0.TreeStoreNone, stage0.TreeStoreOverlay, stage0.TreeStoreHardlink, stage0.TreeStoreZFS, stage0.TreeStoreAuto:
+		*m = treeStoreMode(s)
+		return nil
+	}
+	return fmt.Errorf("unknown tree store mode %q", s)
+}
+
+func (m *treeStoreMode) String() string {
+	if *m == treeStoreMode(stage0.TreeStoreNone) {
+		return "none"
+	}
+	return string(*m)
+}
+
+// diskQuota implements the flag.Value interface to take a size in bytes,
+// with an optional unit suffix
+type diskQuota uint64
+
+func (q *diskQuota) Set(s string) error {
+	v, err := quota.ParseSize(s)
+	if err != nil {
+		return err
+	}
+	*q = diskQuota(v)
+	return nil
+}
+
+func (q *diskQuota) String() string {
+	return strconv.FormatUint(uint64(*q), 10)
+}
+
+// mountList is the flag.Value of --mount, which may be given more than once
+type mountList []mounts.Mount
+
+func (l *mountList) Set(s string) error {
+	m, err := mounts.Parse(s)
+	if err != nil {
+		return err
+	}
+	*l = append(*l, m)
+	return nil
+}
+
+func (l *mountList) String() string {
+	s := make([]string, len(*l))
+	for i, m := range *l {
+		s[i] = m.String()
+	}
+	return strings.Join(s, " ")
+}
+
+// stringList implements the flag.Value interface for flags which may be
+// given more than once
+type stringList []string
+
+func (l *stringList) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
 }
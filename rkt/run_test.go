@@ -0,0 +1,83 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/appc/spec/schema/types"
+	"github.com/coreos/rocket/volume"
+)
+
+func TestVolumeMap(t *testing.T) {
+	vm := volumeMap{}
+	for _, s := range []string{
+		"data:/srv/data",
+		"shared,kind=host,source=/srv/shared,uid=1000,ifEmpty=true",
+		"conf,kind=host,source=/etc/app:v1,readOnly=true",
+		"scratch,kind=empty,uid=1000,mode=0700",
+		"srv,kind=host,source=/srv/www,uid=33,gid=33,recursive=true",
+		"tmp,kind=tmpfs,size=256M,mode=1777,scope=app",
+	} {
+		if err := vm.Set(s); err != nil {
+			t.Fatalf("%q: unexpected error: %v", s, err)
+		}
+	}
+	want := []types.Volume{
+		{Kind: "host", Source: "/etc/app:v1", ReadOnly: true, Fulfills: []types.ACName{"conf"}},
+		{Kind: "host", Source: "/srv/data", Fulfills: []types.ACName{"data"}},
+		{Kind: "empty", Fulfills: []types.ACName{"scratch"}},
+		{Kind: "host", Source: "/srv/shared", Fulfills: []types.ACName{"shared"}},
+		{Kind: "host", Source: "/srv/www", Fulfills: []types.ACName{"srv"}},
+		{Kind: "tmpfs", Fulfills: []types.ACName{"tmp"}},
+	}
+	if g := vm.volumes(); !reflect.DeepEqual(g, want) {
+		t.Errorf("got %+v, want %+v", g, want)
+	}
+	wantOpts := map[types.ACName]volume.Options{
+		"scratch": {Mode: "0700", UID: "1000"},
+		"shared":  {UID: "1000", IfEmpty: true},
+		"srv":     {UID: "33", GID: "33", Recursive: true},
+		"tmp":     {Size: "256M", Mode: "1777", Scope: volume.ScopeApp},
+	}
+	if g := vm.options(); !reflect.DeepEqual(g, wantOpts) {
+		t.Errorf("got options %+v, want %+v", g, wantOpts)
+	}
+
+	for _, s := range []string{
+		"data:/other",
+		"a:b:c",
+		"logs,kind=host",
+		"logs,kind=empty,source=/var/log",
+		"logs,kind=tmpfs,source=/var/log",
+		"logs,kind=tmpfs,size=lots",
+		"logs,kind=tmpfs,scope=host",
+		"logs,kind=nfs",
+		"logs,kind=host,source=/var/log,readOnly=maybe",
+		"logs,source",
+		"logs,size=1G",
+		"logs,kind=empty,uid=www-data",
+		"logs,kind=empty,uid=1,recursive=true",
+		"logs,kind=host,source=/var/log,mode=0755",
+		"logs,kind=host,source=/var/log,uid=1,ifEmpty=yes",
+		"logs,kind=empty,uid=1,ifEmpty=true",
+		"Logs:/var/log",
+	} {
+		if err := vm.Set(s); err == nil {
+			t.Errorf("%q: expected an error", s)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/appc/spec/schema/types"
+	"github.com/coreos/rocket/cas"
+	"github.com/coreos/rocket/rkt/config"
+	"github.com/coreos/rocket/rkt/image"
+)
+
+// findStage1Image finds the image img, as given to --stage1-image, like
+// app images and checks it is trusted as a stage1 image by s, and is the
+// one rule selects if not nil. The checks are made on the name in its
+// manifest, whether it was found by name, hash or file.
+func findStage1Image(ctx context.Context, r *image.Resolver, s *config.Stage1, rule *config.Stage1Rule, img string) (*types.Hash, error) {
+	h, err := r.FindImage(ctx, img)
+	if err != nil {
+		return nil, err
+	}
+	im, err := r.Store.GetImageManifest(h.String())
+	if err != nil {
+		return nil, fmt.Errorf("error reading manifest of stage1 image %s: %v", h, err)
+	}
+	if !s.IsTrusted(string(im.Name)) {
+		return nil, fmt.Errorf("%s (%s) is not a trusted stage1 image, add it to the trusted images in %s", im.Name, h, config.UserStage1File)
+	}
+	if rule != nil && string(im.Name) != rule.ImageName() {
+		return nil, fmt.Errorf("%s (%s) is not %s, the stage1 a rule of %s selects for this container", im.Name, h, rule.ImageName(), config.UserStage1File)
+	}
+	return h, nil
+}
+
+// selectStage1 returns the rule of s selecting the stage1 of the container
+// running imgs, with the given pod annotations, if any. The stage1 can then
+// only be overridden on the command line by the image the rule selects.
+func selectStage1(ds *cas.Store, s *config.Stage1, imgs []types.Hash, annotations ...types.Annotations) (*config.Stage1Rule, error) {
+	names := make([]string, len(imgs))
+	for i, img := range imgs {
+		im, err := ds.GetImageManifest(img.String())
+		if err != nil {
+			return nil, fmt.Errorf("error reading manifest of image %s: %v", img, err)
+		}
+		names[i] = string(im.Name)
+	}
+	anns := make(map[string]string)
+	for _, as := range annotations {
+		for _, a := range as {
+			anns[string(a.Name)] = a.Value
+		}
+	}
+	rule := s.Select(names, anns)
+	if rule == nil {
+		return nil, nil
+	}
+	var flag string
+	switch {
+	case flagStage1Rootfs != "":
+		flag = "--stage1-rootfs"
+	case flagStage1Flavor != "":
+		flag = "--stage1-flavor"
+	default:
+		return rule, nil
+	}
+	return nil, fmt.Errorf("a rule of %s selects the stage1 %s for this container, it can't be run with %s", config.UserStage1File, rule.Stage1, flag)
+}
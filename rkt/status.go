@@ -20,11 +20,14 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
-	"path/filepath"
 	"syscall"
+	"time"
 
 	"github.com/appc/spec/schema/types"
-	"github.com/coreos/rocket/pkg/lock"
+	"github.com/coreos/rocket/pkg/cgroup"
+	"github.com/coreos/rocket/pkg/coredump"
+	"github.com/coreos/rocket/pkg/pod"
+	"github.com/coreos/rocket/pkg/quota"
 )
 
 var (
@@ -32,13 +35,13 @@ var (
 		Name:    cmdStatusName,
 		Summary: "Check the status of a rkt container",
 		Usage:   "[--wait] UUID",
+		Formats: []string{formatJSON, formatRaw},
 		Run:     runStatus,
 	}
 	flagWait bool
 )
 
 const (
-	statusDir     = "stage1/rkt/status"
 	cmdStatusName = "status"
 )
 
@@ -55,124 +58,212 @@ func runStatus(args []string) (exit int) {
 
 	containerUUID, err := types.NewUUID(args[0])
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Invalid UUID: %v\n", err)
+		fmt.Fprintf(stderr, "Invalid UUID: %v\n", err)
 		return 1
 	}
 
-	l, exited, err := getContainerLockAndState(containerUUID)
+	p, err := openContainer(containerUUID)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Unable to access container: %v\n", err)
+		fmt.Fprintf(stderr, "Unable to access container: %v\n", err)
 		return 1
 	}
-	defer l.Close()
+	defer p.Close()
 
-	// There's a window between opening the container directory and lock acquisition where gc rename could occur,
-	// perform all subsequent opens relative to the opened container directory lock fd
-	cfd, err := l.Fd()
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Unable to get lock fd: %v\n", err)
-		return 1
-	}
-
-	if err = printStatusAt(cfd, exited); err != nil {
-		fmt.Fprintf(os.Stderr, "Unable to print status: %v\n", err)
+	if err = printStatus(p); err != nil {
+		fmt.Fprintf(stderr, "Unable to print status: %v\n", err)
 		return 1
 	}
 
 	return 0
 }
 
-// getContainerLockAndState opens the container directory in the form of a lock.DirLock,
-// returning the lock and wether the container has already exited or not.
-func getContainerLockAndState(containerUUID *types.UUID) (l *lock.DirLock, isExited bool, err error) {
-	cid := containerUUID.String()
-	isGarbage := false
-
-	cp := filepath.Join(containersDir(), cid)
-	l, err = lock.NewLock(cp)
-	if err == lock.ErrNotExist {
-		// Fallback to garbage/$cid if containers/$cid is missing, "rkt gc" renames exited containers to garbage/$cid.
-		isGarbage = true
-		cp = filepath.Join(garbageDir(), cid)
-		l, err = lock.NewLock(cp)
+// openContainer opens the container with the given UUID, running or
+// exited, found in the containers directory or, once "rkt gc" renamed it,
+// in the garbage directory. With --wait, it waits for the container to
+// exit.
+func openContainer(containerUUID *types.UUID) (*pod.Pod, error) {
+	p, err := pod.Open(globalFlags.Dir, *containerUUID)
+	if err == nil && p.State == pod.Prepared {
+		p.Close()
+		err = pod.ErrNotFound
+	}
+	if err == pod.ErrNotFound {
+		return nil, fmt.Errorf("container %v not found", containerUUID)
 	}
-
 	if err != nil {
-		if err == lock.ErrNotExist {
-			err = fmt.Errorf("container %v not found", cid)
-		} else {
-			err = fmt.Errorf("error opening lock: %v", err)
-		}
-		return
+		return nil, err
 	}
-
-	isExited = true
-	if flagWait && !isGarbage {
-		err = l.SharedLock()
-	} else {
-		err = l.TrySharedLock()
-		if err == lock.ErrLocked {
-			if isGarbage {
-				// Container is exited and being deleted, we can't reliably query its status, it's effectively gone.
-				err = fmt.Errorf("unable to query status: %q is being removed", cid)
-				return
-			}
-			isExited = false
-			err = nil
+	if flagWait {
+		if err := p.WaitExited(); err != nil {
+			p.Close()
+			return nil, err
 		}
 	}
+	return p, nil
+}
 
+// printStatus prints the container's pid, whether it is paused, its disk
+// usage if it has a quota, the CPUs and memory nodes it runs on if pinned,
+// the core dumps of its apps, per-app status codes and the interfaces of
+// its private network
+func printStatus(p *pod.Pod) error {
+	uuid := p.UUID.String()
+	exited := p.State != pod.Running
+	// gc may rename the container directory meanwhile, all the files are
+	// opened relative to it
+	cdirfd, err := p.Fd()
 	if err != nil {
-		err = fmt.Errorf("error acquiring lock: %v", err)
+		return err
+	}
+	pid, err := p.PID()
+	if err != nil {
+		return err
 	}
 
-	return
-}
-
-// printStatusAt prints the container's pid and per-app status codes
-func printStatusAt(cdirfd int, exited bool) error {
-	pid, err := getIntFromFileAt(cdirfd, "pid")
+	status, err := p.Status()
 	if err != nil {
 		return err
 	}
 
-	stats, err := getStatusesAt(cdirfd)
+	ni, err := p.NetInfo()
 	if err != nil {
 		return err
 	}
 
-	fmt.Printf("pid=%d\nexited=%t\n", pid, exited)
-	for app, stat := range stats {
-		fmt.Printf("%s=%d\n", app, stat)
+	frozen := false
+	var cpuset *cgroup.CPUSet
+	if !exited {
+		if frozen, err = cgroup.Frozen(uuid); err != nil {
+			return err
+		}
+		if cpuset, err = cgroup.EffectiveCPUSet(uuid); err != nil {
+			return err
+		}
 	}
-	return nil
-}
 
-// getStatusesAt returns a map of imageId:status codes for the given container
-func getStatusesAt(cdirfd int) (map[string]int, error) {
-	sdirfd, err := syscall.Openat(cdirfd, statusDir, syscall.O_RDONLY|syscall.O_DIRECTORY, 0)
+	used, limit, err := quota.GetAt(cdirfd)
 	if err != nil {
-		return nil, fmt.Errorf("unable to open status directory: %v", err)
+		return err
 	}
-	sdir := os.NewFile(uintptr(sdirfd), statusDir)
-	defer sdir.Close()
 
-	ls, err := sdir.Readdirnames(0)
+	cores, err := coredump.Records(fmt.Sprintf("/proc/self/fd/%d", cdirfd))
 	if err != nil {
-		return nil, fmt.Errorf("unable to read status directory: %v", err)
+		return err
 	}
 
-	stats := make(map[string]int)
-	for _, name := range ls {
-		s, err := getIntFromFileAt(sdirfd, name)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Unable to get status of app %q: %v\n", name, err)
-			continue
+	if globalFlags.Format == formatJSON {
+		cs := containerStatus{
+			UUID:      uuid,
+			PID:       pid,
+			Exited:    exited,
+			Frozen:    frozen,
+			Phase:     string(status.Phase),
+			DiskUsed:  used,
+			DiskLimit: limit,
+			CPUSet:    cpuset,
+			CoreDumps: cores,
+			Apps:      []appStatus{},
+			HostNet:   ni.Host,
 		}
-		stats[name] = s
+		for _, n := range ni.Nets {
+			cs.Nets = append(cs.Nets, netStatus{Name: n.NetName, IfName: n.IfName, IP: n.IP, IP6: n.IP6})
+		}
+		for _, as := range status.Apps {
+			a := appStatus{
+				Name:    as.Name.String(),
+				ImageID: as.ImageID.String(),
+				PID:     as.PID,
+			}
+			if as.Exited {
+				code := as.ExitCode
+				a.ExitCode = &code
+			}
+			cs.Apps = append(cs.Apps, a)
+		}
+		return printJSON(cs)
+	}
+
+	// the default output is KEY=VALUE lines, raw KEY<tab>VALUE ones
+	print := func(key string, value interface{}) {
+		if globalFlags.Format == formatRaw {
+			printRaw(key, value)
+		} else {
+			fmt.Fprintf(stdout, "%s=%v\n", key, value)
+		}
+	}
+	print("pid", pid)
+	print("exited", exited)
+	print("frozen", frozen)
+	if status.Phase != "" {
+		print("phase", status.Phase)
+	}
+	if limit > 0 {
+		print("disk_used", used)
+		print("disk_limit", limit)
+	}
+	if cpuset != nil {
+		print("cpuset_cpus", cpuset.CPUs)
+		print("cpuset_mems", cpuset.Mems)
+	}
+	for i, r := range cores {
+		where := "host"
+		if r.File != "" {
+			where = r.File
+		}
+		print(fmt.Sprintf("coredump.%d", i), fmt.Sprintf("%s,%d,%d,%s,%s", r.App, r.PID, r.Signal, r.Time.Format(time.RFC3339), where))
 	}
+	for _, as := range status.Apps {
+		if as.PID != 0 {
+			print(fmt.Sprintf("app.%s.pid", as.Name), as.PID)
+		}
+		if as.Exited {
+			print(types.ShortHash(as.ImageID.String()), as.ExitCode)
+		}
+	}
+	if ni.Host {
+		print("net", "host")
+	}
+	for _, n := range ni.Nets {
+		if n.IP6 != "" {
+			print("net."+n.NetName, fmt.Sprintf("%s,%s,%s", n.IfName, n.IP, n.IP6))
+		} else {
+			print("net."+n.NetName, fmt.Sprintf("%s,%s", n.IfName, n.IP))
+		}
+	}
+	return nil
+}
+
+// containerStatus is the status of a container printed with --format=json
+type containerStatus struct {
+	UUID      string            `json:"uuid"`
+	PID       int               `json:"pid"`
+	Exited    bool              `json:"exited"`
+	Frozen    bool              `json:"frozen"`
+	Phase     string            `json:"phase,omitempty"`
+	DiskUsed  uint64            `json:"diskUsed,omitempty"`
+	DiskLimit uint64            `json:"diskLimit,omitempty"`
+	CPUSet    *cgroup.CPUSet    `json:"cpuset,omitempty"`
+	CoreDumps []coredump.Record `json:"coreDumps,omitempty"`
+	Apps      []appStatus       `json:"apps"`
+	HostNet   bool              `json:"hostNet,omitempty"`
+	Nets      []netStatus       `json:"nets,omitempty"`
+}
+
+// netStatus is the address of a container on a network
+type netStatus struct {
+	Name   string `json:"name"`
+	IfName string `json:"ifName"`
+	IP     string `json:"ip"`
+	IP6    string `json:"ip6,omitempty"`
+}
 
-	return stats, err
+// appStatus is the status of an app of a container; ExitCode is only set
+// once it exited
+type appStatus struct {
+	Name     string `json:"name"`
+	ImageID  string `json:"imageID"`
+	PID      int    `json:"pid,omitempty"`
+	ExitCode *int   `json:"exitCode,omitempty"`
 }
 
 // getIntFromFileAt reads an integer string from the named file
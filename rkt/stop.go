@@ -0,0 +1,206 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//+build linux
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/appc/spec/schema/types"
+	rktpath "github.com/coreos/rocket/path"
+	"github.com/coreos/rocket/pkg/lock"
+)
+
+const (
+	cmdStopName = "stop"
+
+	defaultStopTimeout = 90 * time.Second
+	stopPollInterval   = 200 * time.Millisecond
+
+	// sigRTMin3 is SIGRTMIN+3, which asks systemd to power off, in the
+	// numbering of glibc which reserves the first two realtime signals
+	sigRTMin3 = syscall.Signal(37)
+)
+
+var (
+	cmdStop = &Command{
+		Name:    cmdStopName,
+		Summary: "Stop running rkt containers gracefully",
+		Usage:   "[--timeout=duration] [--all [--app=NAME] [--annotation=NAME=VALUE]] [UUID...]",
+		Description: `The apps of each container are stopped by systemd in stage1 as on power off,
+being sent SIGTERM and given their stop timeout to exit; the single app of a fly
+container is sent SIGTERM. Containers still running once the timeout, shared by
+all the containers, expires are killed. The UUIDs of the stopped containers are
+printed. dist/systemd/rkt-stop-all.service stops all the containers this way on
+host shutdown.`,
+		Run: runStop,
+	}
+	flagStopAll     bool
+	flagStopTimeout time.Duration
+	flagFilter      containerFilter
+)
+
+func init() {
+	commands = append(commands, cmdStop)
+	cmdStop.Flags.BoolVar(&flagStopAll, "all", false, "stop all the running containers")
+	cmdStop.Flags.DurationVar(&flagStopTimeout, "timeout", defaultStopTimeout, "duration to wait for the containers to stop before killing them")
+	flagFilter.addFlags(&cmdStop.Flags)
+}
+
+// stoppingContainer is a container asked to stop
+type stoppingContainer struct {
+	uuid string
+	l    *lock.DirLock
+	pid  int
+}
+
+func runStop(args []string) (exit int) {
+	uuids, ok := selectContainers(cmdStopName, args, flagStopAll)
+	if !ok {
+		return 1
+	}
+
+	var stopping []*stoppingContainer
+	for _, uuid := range uuids {
+		c, err := stopContainer(uuid)
+		if err != nil {
+			fmt.Fprintf(stderr, "Unable to stop container %q: %v\n", uuid, err)
+			exit = 1
+			continue
+		}
+		if c == nil {
+			if !flagStopAll {
+				fmt.Fprintf(stderr, "Container %q is not running\n", uuid)
+				exit = 1
+			}
+			continue
+		}
+		stopping = append(stopping, c)
+	}
+
+	deadline := time.Now().Add(flagStopTimeout)
+	for {
+		var left []*stoppingContainer
+		for _, c := range stopping {
+			// stage1 holds the lock until the container exited
+			if err := c.l.TrySharedLock(); err == lock.ErrLocked {
+				left = append(left, c)
+				continue
+			}
+			fmt.Fprintln(stdout, c.uuid)
+			c.l.Close()
+		}
+		stopping = left
+		if len(stopping) == 0 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(stopPollInterval)
+	}
+
+	for _, c := range stopping {
+		fmt.Fprintf(stderr, "Container %q didn't stop within %v, killing it\n", c.uuid, flagStopTimeout)
+		if err := syscall.Kill(c.pid, syscall.SIGKILL); err != nil && err != syscall.ESRCH {
+			fmt.Fprintf(stderr, "Unable to kill container %q: %v\n", c.uuid, err)
+		}
+		c.l.Close()
+		exit = 1
+	}
+	return
+}
+
+// stopContainer asks the container uuid to stop if it is running and
+// matches the filters, returning nil otherwise
+func stopContainer(uuid string) (*stoppingContainer, error) {
+	cdir := filepath.Join(containersDir(), uuid)
+	l, err := lock.NewLock(cdir)
+	if err != nil {
+		if err == lock.ErrNotExist {
+			err = fmt.Errorf("container not found")
+		}
+		return nil, err
+	}
+	if err := l.TrySharedLock(); err != lock.ErrLocked {
+		l.Close()
+		return nil, err
+	}
+	if ok, err := flagFilter.matchDir(cdir); !ok || err != nil {
+		l.Close()
+		return nil, err
+	}
+
+	cfd, err := l.Fd()
+	if err != nil {
+		l.Close()
+		return nil, err
+	}
+	// missing while the container is starting
+	pid, err := getIntFromFileAt(cfd, "pid")
+	if err != nil {
+		l.Close()
+		return nil, fmt.Errorf("error reading pid: %v", err)
+	}
+	sig := sigRTMin3
+	b, err := ioutil.ReadFile(rktpath.Stage1FlavorPath(fmt.Sprintf("/proc/self/fd/%d", cfd)))
+	if err == nil && strings.TrimSpace(string(b)) != "" {
+		// the app of a fly container, or the hypervisor of a kvm one
+		sig = syscall.SIGTERM
+	}
+	if err := syscall.Kill(pid, sig); err != nil {
+		l.Close()
+		return nil, fmt.Errorf("error signaling container: %v", err)
+	}
+	return &stoppingContainer{uuid: uuid, l: l, pid: pid}, nil
+}
+
+// selectContainers returns the UUIDs of the containers the cmd command acts
+// on, given in args or, with all, all the containers. Filters may only be
+// given with all. It returns false after printing the error if the
+// selection is invalid.
+func selectContainers(cmd string, args []string, all bool) ([]string, bool) {
+	if all == (len(args) > 0) {
+		printCommandUsageByName(cmd)
+		return nil, false
+	}
+	if !all {
+		if !flagFilter.empty() {
+			fmt.Fprintf(stderr, "%s: --app and --annotation only apply with --all\n", cmd)
+			return nil, false
+		}
+		for _, a := range args {
+			if _, err := types.NewUUID(a); err != nil {
+				fmt.Fprintf(stderr, "Invalid UUID %q: %v\n", a, err)
+				return nil, false
+			}
+		}
+		return args, true
+	}
+
+	if err := flagFilter.check(); err != nil {
+		fmt.Fprintf(stderr, "%s: %v\n", cmd, err)
+		return nil, false
+	}
+	cs, err := getContainers()
+	if err != nil {
+		fmt.Fprintf(stderr, "Unable to get containers list: %v\n", err)
+		return nil, false
+	}
+	return cs, true
+}
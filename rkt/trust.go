@@ -0,0 +1,282 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/coreos/rocket/pkg/keystore"
+	"github.com/coreos/rocket/rkt/cache"
+
+	"github.com/appc/spec/discovery"
+	"github.com/coreos/rocket/Godeps/_workspace/src/golang.org/x/crypto/openpgp"
+)
+
+// maxPubKeySize bounds the size of a public key fetched from a URL
+const maxPubKeySize = 1024 * 1024
+
+var (
+	flagPrefix              string
+	flagRoot                bool
+	flagSkipFingerprintRevw bool
+	flagListKeys            bool
+	flagRemoveKey           string
+	flagAllowHTTP           bool
+	cmdTrust                = &Command{
+		Name:    "trust",
+		Summary: "Trust a key for image verification",
+		Usage:   "[--prefix=PREFIX | --root] [--skip-fingerprint-review] [--insecure-allow-http] [PUBKEY...] | --list | --remove=FINGERPRINT",
+		Description: `Adds the armored public keys PUBKEY, given as local files or https URLs,
+to the keystore. If no PUBKEY is given the keys are located through
+meta-discovery of the prefix, like images are. Keys are only fetched over
+plain http with --insecure-allow-http, anyone on the network path could
+substitute their own otherwise.
+With --prefix the keys are trusted to sign images whose name starts with the
+given prefix, e.g. --prefix=example.com/app covers example.com/app and
+example.com/app/worker but not example.com/application.
+With --root the keys are trusted to sign any image.
+The fingerprint of every key is shown and has to be confirmed before it is
+trusted, unless --skip-fingerprint-review is given.
+Images for which no key is trusted are refused unless --insecure-skip-verify
+is given.
+--list shows the trusted keys and the prefixes they are trusted for,
+--remove revokes all trust in a key.`,
+		Run: runTrust,
+	}
+)
+
+func init() {
+	commands = append(commands, cmdTrust)
+	cmdTrust.Flags.StringVar(&flagPrefix, "prefix", "", "image name prefix the keys are trusted for")
+	cmdTrust.Flags.BoolVar(&flagRoot, "root", false, "trust the keys for all images")
+	cmdTrust.Flags.BoolVar(&flagSkipFingerprintRevw, "skip-fingerprint-review", false, "trust the keys without asking for confirmation of their fingerprints")
+	cmdTrust.Flags.BoolVar(&flagListKeys, "list", false, "list the trusted keys")
+	cmdTrust.Flags.StringVar(&flagRemoveKey, "remove", "", "revoke trust in the key with the given fingerprint")
+	cmdTrust.Flags.BoolVar(&flagAllowHTTP, "insecure-allow-http", false, "allow fetching keys over plain http")
+}
+
+func runTrust(args []string) (exit int) {
+	if flagListKeys || flagRemoveKey != "" {
+		if len(args) > 0 || flagRoot || flagPrefix != "" || (flagListKeys && flagRemoveKey != "") {
+			fmt.Fprintf(stderr, "trust: --list and --remove can't be combined with other arguments\n")
+			return 1
+		}
+		ks := keystore.New(nil)
+		if flagListKeys {
+			return listKeys(ks)
+		}
+		return removeKey(ks, flagRemoveKey)
+	}
+
+	if flagRoot == (flagPrefix != "") {
+		fmt.Fprintf(stderr, "trust: Must specify exactly one of --prefix or --root\n")
+		return 1
+	}
+	if _, err := getAuth(); err != nil {
+		fmt.Fprintf(stderr, "trust: %v\n", err)
+		return 1
+	}
+
+	locations := args
+	if len(locations) == 0 {
+		if flagRoot {
+			fmt.Fprintf(stderr, "trust: Must provide at least one key to trust as root key\n")
+			return 1
+		}
+		var err error
+		locations, err = discoverPubKeys(flagPrefix)
+		if err != nil {
+			fmt.Fprintf(stderr, "trust: error discovering keys for prefix %q: %v\n", flagPrefix, err)
+			return 1
+		}
+	}
+
+	ks := keystore.New(nil)
+	for _, location := range locations {
+		dst, err := trustKey(ks, location)
+		if err != nil {
+			fmt.Fprintf(stderr, "trust: error trusting key %s: %v\n", location, err)
+			return 1
+		}
+		if dst == "" {
+			fmt.Fprintf(stderr, "Not trusting key %s\n", location)
+			continue
+		}
+		fmt.Fprintf(stderr, "Added key %s to %s\n", location, dst)
+	}
+
+	return
+}
+
+func listKeys(ks *keystore.Keystore) (exit int) {
+	keys, err := ks.List()
+	if err != nil {
+		fmt.Fprintf(stderr, "trust: error listing keys: %v\n", err)
+		return 1
+	}
+
+	fmt.Fprintf(out, "FINGERPRINT\tPREFIX\tSOURCE\tIDENTITY\n")
+	for _, k := range keys {
+		prefix := k.Prefix
+		if prefix == "" {
+			prefix = "(all images)"
+		}
+		source := "user"
+		if k.System {
+			source = "system"
+		}
+		var names []string
+		for name := range k.Entity.Identities {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		fmt.Fprintf(out, "%s\t%s\t%s\t%s\n", k.Fingerprint, prefix, source, strings.Join(names, ", "))
+	}
+	out.Flush()
+	return
+}
+
+func removeKey(ks *keystore.Keystore, fingerprint string) (exit int) {
+	// accept fingerprints as printed by gpg or rkt trust as well
+	fingerprint = strings.ToLower(strings.Replace(fingerprint, " ", "", -1))
+	if err := ks.Delete(fingerprint); err != nil {
+		fmt.Fprintf(stderr, "trust: error removing key %s: %v\n", fingerprint, err)
+		return 1
+	}
+	fmt.Fprintf(stderr, "Removed key %s\n", fingerprint)
+	return
+}
+
+// discoverPubKeys returns the locations of the public keys announced by
+// meta-discovery for prefix.
+func discoverPubKeys(prefix string) ([]string, error) {
+	app, err := discovery.NewAppFromString(prefix)
+	if err != nil {
+		return nil, err
+	}
+	// keys become trust roots, never accept them over plain http
+	ep, err := cache.New(cache.UserDir()).Discover(*app, false)
+	if err != nil {
+		return nil, err
+	}
+	if len(ep.Keys) == 0 {
+		return nil, errors.New("no keys announced")
+	}
+	return ep.Keys, nil
+}
+
+// trustKey installs the armored public key at location, a local file or an
+// https URL, for the prefix or root selected by the command line flags.
+// The empty string is returned if the user declined to trust the key.
+func trustKey(ks *keystore.Keystore, location string) (string, error) {
+	b, err := readPubKey(location, flagAllowHTTP)
+	if err != nil {
+		return "", err
+	}
+	entityList, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(b))
+	if err != nil {
+		return "", fmt.Errorf("error reading key: %v", err)
+	}
+	if len(entityList) < 1 {
+		return "", errors.New("missing opengpg entity")
+	}
+
+	if !flagSkipFingerprintRevw {
+		ok, err := reviewKey(os.Stdin, stderr, location, entityList[0])
+		if err != nil {
+			return "", err
+		}
+		if !ok {
+			return "", nil
+		}
+	}
+
+	if flagRoot {
+		return ks.StoreTrustedKeyRoot(bytes.NewReader(b))
+	}
+	return ks.StoreTrustedKeyPrefix(flagPrefix, bytes.NewReader(b))
+}
+
+// readPubKey reads the public key from a local file or https URL, the
+// latter being cached for unprivileged users. Plain http URLs are refused
+// unless allowHTTP is set.
+func readPubKey(location string, allowHTTP bool) ([]byte, error) {
+	u, err := url.Parse(location)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return ioutil.ReadFile(location)
+	}
+	if u.Scheme == "http" && !allowHTTP {
+		return nil, errors.New("refusing to fetch key over plain http, use an https URL or --insecure-allow-http")
+	}
+
+	return cache.New(cache.UserDir()).Get(context.Background(), u.String(), maxPubKeySize)
+}
+
+// reviewKey shows the fingerprint and identities of entity on out and asks
+// for confirmation on in.
+func reviewKey(in io.Reader, out io.Writer, location string, entity *openpgp.Entity) (bool, error) {
+	target := "prefix " + flagPrefix
+	if flagRoot {
+		target = "all images"
+	}
+	fmt.Fprintf(out, "Key %s for %s\n", location, target)
+	fmt.Fprintf(out, "  Key fingerprint = %s\n", formatFingerprint(entity.PrimaryKey.Fingerprint))
+	var names []string
+	for name := range entity.Identities {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(out, "  %s\n", name)
+	}
+
+	r := bufio.NewReader(in)
+	for {
+		fmt.Fprintf(out, "Are you sure you want to trust this key (yes/no)? ")
+		answer, err := r.ReadString('\n')
+		if err != nil && (err != io.EOF || answer == "") {
+			return false, fmt.Errorf("error reading answer: %v", err)
+		}
+		switch strings.ToLower(strings.TrimSpace(answer)) {
+		case "yes", "y":
+			return true, nil
+		case "no", "n":
+			return false, nil
+		}
+		if err == io.EOF {
+			return false, nil
+		}
+	}
+}
+
+// formatFingerprint renders fp the way gpg does, e.g.
+// B346 E31D E7E3 C6F9 D1D4  603F 4DFB 61BF 26EF 7A14
+func formatFingerprint(fp [20]byte) string {
+	var groups []string
+	for i := 0; i < len(fp); i += 2 {
+		groups = append(groups, fmt.Sprintf("%X", fp[i:i+2]))
+	}
+	return strings.Join(groups[:5], " ") + "  " + strings.Join(groups[5:], " ")
+}
@@ -0,0 +1,101 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/coreos/rocket/pkg/keystore/keystoretest"
+
+	"github.com/coreos/rocket/Godeps/_workspace/src/golang.org/x/crypto/openpgp"
+)
+
+func TestFormatFingerprint(t *testing.T) {
+	var fp [20]byte
+	for i := range fp {
+		fp[i] = byte(i * 13)
+	}
+	want := "000D 1A27 3441 4E5B 6875  828F 9CA9 B6C3 D0DD EAF7"
+	if g := formatFingerprint(fp); g != want {
+		t.Errorf("got %q, want %q", g, want)
+	}
+}
+
+func TestReviewKey(t *testing.T) {
+	key := keystoretest.KeyMap["example.com/app"]
+	entityList, err := openpgp.ReadArmoredKeyRing(bytes.NewBufferString(key.ArmoredPublicKey))
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	entity := entityList[0]
+
+	flagPrefix, flagRoot = "example.com/app", false
+	defer func() { flagPrefix = "" }()
+
+	tests := []struct {
+		in string
+
+		ok  bool
+		err bool
+	}{
+		{"yes\n", true, false},
+		{"y\n", true, false},
+		{"no\n", false, false},
+		{"maybe\nYES\n", true, false},
+		{"maybe", false, false},
+		{"", false, true},
+	}
+	for i, tt := range tests {
+		var out bytes.Buffer
+		ok, err := reviewKey(strings.NewReader(tt.in), &out, "pubkeys.gpg", entity)
+		if (err != nil) != tt.err {
+			t.Errorf("#%d: got err %v, want err %v", i, err, tt.err)
+		}
+		if ok != tt.ok {
+			t.Errorf("#%d: got %v, want %v", i, ok, tt.ok)
+		}
+		fp := strings.Replace(formatFingerprint(entity.PrimaryKey.Fingerprint), " ", "", -1)
+		if !strings.EqualFold(fp, key.Fingerprint) {
+			t.Errorf("#%d: formatted fingerprint %s does not match %s", i, fp, key.Fingerprint)
+		}
+		if !strings.Contains(out.String(), "for prefix example.com/app") {
+			t.Errorf("#%d: prefix missing from output:\n%s", i, out.String())
+		}
+	}
+}
+
+func TestReadPubKeyHTTP(t *testing.T) {
+	key := keystoretest.KeyMap["example.com/app"]
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, key.ArmoredPublicKey)
+	}))
+	defer ts.Close()
+
+	if _, err := readPubKey(ts.URL+"/pubkeys.gpg", false); err == nil {
+		t.Errorf("expected key fetched over plain http to be refused")
+	}
+	b, err := readPubKey(ts.URL+"/pubkeys.gpg", true)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(b) != key.ArmoredPublicKey {
+		t.Errorf("got key %q, want %q", b, key.ArmoredPublicKey)
+	}
+}
@@ -0,0 +1,62 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/appc/spec/schema/types"
+	"github.com/coreos/rocket/Godeps/_workspace/src/code.google.com/p/go-uuid/uuid"
+)
+
+// containerNameSpace is the namespace of the version 5 UUIDs derived from
+// the seeds given with --uuid-seed. It must never change, the same seed
+// has to give the same UUID across rkt versions.
+var containerNameSpace = uuid.Parse("d5f7e1b6-0b1f-4c1c-9a4e-6f3b2c8e7a15")
+
+// containerUUID returns the UUID a new container is given by --uuid or
+// --uuid-seed, nil for a random one.
+func containerUUID(explicit, seed string) (*types.UUID, error) {
+	switch {
+	case explicit != "" && seed != "":
+		return nil, fmt.Errorf("--uuid and --uuid-seed can't be used together")
+	case explicit != "":
+		u, err := types.NewUUID(explicit)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --uuid: %v", err)
+		}
+		return u, nil
+	case seed != "":
+		return types.NewUUID(uuid.NewSHA1(containerNameSpace, []byte(seed)).String())
+	}
+	return nil, nil
+}
+
+// checkUUIDFree fails if a container, whatever its state, already has the
+// UUID u in one of dirs.
+func checkUUIDFree(u *types.UUID, dirs ...string) error {
+	for _, d := range dirs {
+		_, err := os.Stat(filepath.Join(d, u.String()))
+		if err == nil {
+			return fmt.Errorf("container %v already exists", u)
+		}
+		if !os.IsNotExist(err) {
+			return fmt.Errorf("error checking for container %v: %v", u, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestContainerUUID(t *testing.T) {
+	const explicit = "6733c088-4f0a-4e1b-8c5d-0f4d9f5b1a2e"
+	u, err := containerUUID(explicit, "")
+	if err != nil || u.String() != explicit {
+		t.Errorf("got %v (err %v), want %s", u, err, explicit)
+	}
+	if u, err = containerUUID("", ""); err != nil || u != nil {
+		t.Errorf("got %v (err %v), want nil", u, err)
+	}
+	if _, err = containerUUID("not-a-uuid", ""); err == nil {
+		t.Errorf("expected error for an invalid UUID")
+	}
+	if _, err = containerUUID(explicit, "seed"); err == nil {
+		t.Errorf("expected error for both an UUID and a seed")
+	}
+
+	a, err := containerUUID("", "job-42/attempt")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	b, _ := containerUUID("", "job-42/attempt")
+	c, _ := containerUUID("", "job-43/attempt")
+	if *a != *b {
+		t.Errorf("the same seed gave %v and %v", a, b)
+	}
+	if *a == *c {
+		t.Errorf("different seeds gave %v", a)
+	}
+	// version 5, RFC 4122 variant
+	if s := a.String(); s[14] != '5' || (s[19] != '8' && s[19] != '9' && s[19] != 'a' && s[19] != 'b') {
+		t.Errorf("%v is not a version 5 UUID", a)
+	}
+}
+
+func TestCheckUUIDFree(t *testing.T) {
+	dir, err := ioutil.TempDir("", "uuid")
+	if err != nil {
+		t.Fatalf("error creating tempdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	containers, prepared := filepath.Join(dir, "containers"), filepath.Join(dir, "prepared")
+
+	u, _ := containerUUID("", "seed")
+	if err := checkUUIDFree(u, containers, prepared); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Join(prepared, u.String()), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkUUIDFree(u, containers, prepared); err == nil {
+		t.Errorf("expected error for an existing container")
+	}
+}
@@ -24,6 +24,7 @@ var cmdVersion = &Command{
 	Name:        "version",
 	Description: "Print the version and exit",
 	Summary:     "Print the version and exit",
+	Formats:     []string{formatJSON, formatRaw},
 	Run:         runVersion,
 }
 
@@ -32,6 +33,18 @@ func init() {
 }
 
 func runVersion(args []string) (exit int) {
-	fmt.Printf("rkt version %s\n", version.Version)
+	switch globalFlags.Format {
+	case formatJSON:
+		if err := printJSON(struct {
+			Version string `json:"version"`
+		}{version.Version}); err != nil {
+			fmt.Fprintf(stderr, "%v\n", err)
+			return 1
+		}
+	case formatRaw:
+		printRaw(version.Version)
+	default:
+		fmt.Fprintf(stdout, "rkt version %s\n", version.Version)
+	}
 	return
 }
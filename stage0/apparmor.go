@@ -0,0 +1,54 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//+build linux
+
+package stage0
+
+import (
+	"fmt"
+
+	"github.com/appc/spec/schema"
+	"github.com/appc/spec/schema/types"
+	"github.com/coreos/rocket/pkg/apparmor"
+)
+
+// setAppArmorProfile resolves the AppArmor profile app a is confined by,
+// given by cfg.AppArmor, the annotations of the app or else those of the
+// container, and records its name in the annotations of the app for
+// stage1. The profile is checked to be loaded, or loaded if given as a
+// file, so that the app doesn't run unconfined.
+func setAppArmorProfile(cfg Config, podAnnotations types.Annotations, a *schema.RuntimeApp) error {
+	profile, ok := cfg.AppArmor[a.Name.String()]
+	if !ok {
+		profile, ok = cfg.AppArmor[""]
+	}
+	if !ok {
+		profile, ok = a.Annotations.Get(apparmor.ProfileAnnotation)
+	}
+	if !ok {
+		profile, ok = podAnnotations.Get(apparmor.ProfileAnnotation)
+	}
+	if !ok {
+		return nil
+	}
+	name, err := apparmor.Resolve(profile)
+	if err != nil {
+		return fmt.Errorf("error confining app %s: %v", a.Name, err)
+	}
+	a.Annotations = mergeAnnotations(a.Annotations, types.Annotations{
+		{Name: apparmor.ProfileAnnotation, Value: name},
+	})
+	return nil
+}
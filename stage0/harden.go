@@ -0,0 +1,50 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//+build linux
+
+package stage0
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/appc/spec/schema"
+	"github.com/appc/spec/schema/types"
+	"github.com/coreos/rocket/pkg/harden"
+)
+
+// setHardened records in the annotations of app a whether stage1 hardens
+// it, as cfg.Hardened, the annotations of the app or else those of the
+// container say.
+func setHardened(cfg Config, podAnnotations types.Annotations, a *schema.RuntimeApp) error {
+	val, ok := a.Annotations.Get(harden.Annotation)
+	if !ok {
+		val, ok = podAnnotations.Get(harden.Annotation)
+	}
+	if cfg.Hardened {
+		val, ok = "true", true
+	}
+	if !ok {
+		return nil
+	}
+	h, err := harden.Parse(val)
+	if err != nil {
+		return fmt.Errorf("error hardening app %s: %v", a.Name, err)
+	}
+	a.Annotations = mergeAnnotations(a.Annotations, types.Annotations{
+		{Name: harden.Annotation, Value: strconv.FormatBool(h)},
+	})
+	return nil
+}
@@ -0,0 +1,57 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//+build linux
+
+package stage0
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+const (
+	// the journalctl of stage1 is run by its interpreter, with its
+	// libraries, as hosts may have none
+	stage1Interp   = "stage1/usr/lib/ld-linux-x86-64.so.2"
+	stage1LibDir   = "stage1/usr/lib"
+	journalctlPath = "stage1/usr/bin/journalctl"
+	// JournalDir is where, relative to the container directory, the
+	// journald of stage1 keeps the output of the apps
+	JournalDir = "stage1/var/log/journal"
+)
+
+// Logs prints the journal of the apps of the container whose directory is
+// open as fd, by exec()ing the journalctl of its stage1 with args in the
+// directory, so that it keeps reading the journal if the container is
+// moved by gc.
+func Logs(fd int, args []string) error {
+	if err := syscall.Fchdir(fd); err != nil {
+		return fmt.Errorf("failed changing to dir: %v", err)
+	}
+	if _, err := os.Stat(JournalDir); err != nil {
+		return fmt.Errorf("the output of the apps isn't kept by the stage1 of the container: %v", err)
+	}
+
+	argv := []string{stage1Interp, journalctlPath, "--directory=" + JournalDir}
+	argv = append(argv, args...)
+	env := append(os.Environ(), "LD_LIBRARY_PATH="+stage1LibDir)
+	if err := syscall.Exec(stage1Interp, argv, env); err != nil {
+		return fmt.Errorf("error execing journalctl: %v", err)
+	}
+
+	// never reached
+	return nil
+}
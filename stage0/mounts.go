@@ -0,0 +1,67 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//+build linux
+
+package stage0
+
+import (
+	"fmt"
+
+	"github.com/appc/spec/schema"
+	"github.com/appc/spec/schema/types"
+	"github.com/coreos/rocket/pkg/mounts"
+)
+
+// setMounts records in the annotations of app a the mount points
+// cfg.Mounts add to it, for stage1 to mount their volumes on.
+func setMounts(cfg Config, a *schema.RuntimeApp) error {
+	var mps []types.MountPoint
+	for _, m := range cfg.Mounts {
+		if m.App == "" || m.App == a.Name {
+			mps = append(mps, m.MountPoint())
+		}
+	}
+	if len(mps) == 0 {
+		return nil
+	}
+	val, err := mounts.Encode(mps)
+	if err != nil {
+		return fmt.Errorf("error adding mount points to app %s: %v", a.Name, err)
+	}
+	a.Annotations = mergeAnnotations(a.Annotations, types.Annotations{
+		{Name: mounts.Annotation, Value: val},
+	})
+	return nil
+}
+
+// checkMounts checks the apps and volumes cfg.Mounts refer to are those of
+// the container manifest cm.
+func checkMounts(cfg Config, cm *schema.ContainerRuntimeManifest) error {
+	vols := make(map[types.ACName]bool)
+	for _, v := range cm.Volumes {
+		for _, f := range v.Fulfills {
+			vols[f] = true
+		}
+	}
+	for _, m := range cfg.Mounts {
+		if m.App != "" && cm.Apps.Get(m.App) == nil {
+			return fmt.Errorf("error: mount %q is for an unknown app", m)
+		}
+		if !vols[m.Volume] {
+			return fmt.Errorf("error: mount %q is of an unknown volume", m)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//+build linux
+
+package stage0
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/coreos/rocket/networking"
+	"github.com/coreos/rocket/pkg/lock"
+)
+
+// portsFile is the file, relative to the directory of a container,
+// recording the host ports forwarded to it
+const portsFile = "ports.json"
+
+// reservePorts records the host ports forwarded to the container in dir
+// and checks no other container forwards them: the prepared containers in
+// cfg.PreparedDir, until they are run or expire, and the containers in
+// cfg.RunningDir while they run. The ports are recorded before looking at
+// the other containers, so that of two containers set up at the same time
+// with the same port at least one fails.
+func reservePorts(cfg Config, dir string) error {
+	b, err := json.Marshal(cfg.Ports)
+	if err != nil {
+		return fmt.Errorf("error marshalling forwarded ports: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, portsFile), b, 0644); err != nil {
+		return fmt.Errorf("error writing forwarded ports: %v", err)
+	}
+
+	return forOtherContainers(cfg, dir, func(cdir string, prepared bool) error {
+		fp, err := portConflict(cdir, cfg.Ports, prepared)
+		if err != nil || fp == nil {
+			return err
+		}
+		state := "running"
+		if prepared {
+			state = "prepared"
+		}
+		return fmt.Errorf("error: host port %d is already forwarded to port %q of %s container %s", fp.HostPort, fp.Name, state, filepath.Base(cdir))
+	})
+}
+
+// forOtherContainers calls fn with the directory of each container in
+// cfg.PreparedDir and cfg.RunningDir but the one in dir, and whether it is
+// prepared, until fn returns an error
+func forOtherContainers(cfg Config, dir string, fn func(cdir string, prepared bool) error) error {
+	for _, d := range []struct {
+		dir      string
+		prepared bool
+	}{
+		{cfg.PreparedDir, true},
+		{cfg.RunningDir, false},
+	} {
+		if d.dir == "" {
+			continue
+		}
+		ls, err := ioutil.ReadDir(d.dir)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("error reading containers directory: %v", err)
+		}
+		for _, fi := range ls {
+			cdir := filepath.Join(d.dir, fi.Name())
+			if cdir == dir {
+				continue
+			}
+			if err := fn(cdir, d.prepared); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// portConflict returns the port forwarded to the container in cdir from
+// one of the host ports of ports, if any. Unless prepared is set, the
+// container only holds its ports while it runs, i.e. while stage1 holds
+// its lock.
+func portConflict(cdir string, ports []networking.ForwardedPort, prepared bool) (*networking.ForwardedPort, error) {
+	b, err := ioutil.ReadFile(filepath.Join(cdir, portsFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading forwarded ports of container %s: %v", filepath.Base(cdir), err)
+	}
+	var theirs []networking.ForwardedPort
+	if err := json.Unmarshal(b, &theirs); err != nil {
+		return nil, fmt.Errorf("error reading forwarded ports of container %s: %v", filepath.Base(cdir), err)
+	}
+
+	var conflict *networking.ForwardedPort
+	for _, fp := range ports {
+		for i := range theirs {
+			if theirs[i].HostPort == fp.HostPort {
+				conflict = &theirs[i]
+				break
+			}
+		}
+		if conflict != nil {
+			break
+		}
+	}
+	if conflict == nil || prepared {
+		return conflict, nil
+	}
+
+	runs, err := containerRuns(cdir)
+	if err != nil || !runs {
+		return nil, err
+	}
+	return conflict, nil
+}
+
+// containerRuns reports whether the container in cdir runs, i.e. whether
+// stage1 holds its lock
+func containerRuns(cdir string) (bool, error) {
+	l, err := lock.TrySharedLock(cdir)
+	switch err {
+	case lock.ErrLocked:
+		return true, nil
+	case nil:
+		// exited
+		l.Close()
+		return false, nil
+	case lock.ErrNotExist:
+		// garbage collected meanwhile
+		return false, nil
+	}
+	return false, fmt.Errorf("error checking whether container %s runs: %v", filepath.Base(cdir), err)
+}
@@ -0,0 +1,125 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//+build linux
+
+package stage0
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/coreos/rocket/networking"
+)
+
+// preparedFile is the file, relative to the directory of a prepared
+// container, recording how to run it
+const preparedFile = "prepared.json"
+
+// preparedConfig is the part of Config Run needs
+type preparedConfig struct {
+	Debug       bool                       `json:"debug"`
+	PrivateNet  bool                       `json:"privateNet"`
+	Networks    []string                   `json:"networks,omitempty"`
+	NetArgs     map[string]string          `json:"netArgs,omitempty"`
+	Ports       []networking.ForwardedPort `json:"ports,omitempty"`
+	PreparedNet bool                       `json:"preparedNet"`
+	DNS         []string                   `json:"dns,omitempty"`
+	DNSSearch   []string                   `json:"dnsSearch,omitempty"`
+	DNSOptions  []string                   `json:"dnsOptions,omitempty"`
+	// NetReadyTimeout is in nanoseconds
+	NetReadyTimeout time.Duration          `json:"netReadyTimeout,omitempty"`
+	NetReadyPolicy  networking.ReadyPolicy `json:"netReadyPolicy,omitempty"`
+	RegisterMachine bool                   `json:"registerMachine,omitempty"`
+}
+
+// SavePrepared records in dir, the directory of a container set up by
+// Setup, how to Run it later on with LoadPrepared.
+func SavePrepared(cfg Config, dir string) error {
+	if cfg.Verity {
+		return fmt.Errorf("error: containers using verity can't be prepared, root hashes are never written to disk")
+	}
+	b, err := json.Marshal(preparedConfig{
+		Debug:       cfg.Debug,
+		PrivateNet:  cfg.PrivateNet,
+		Networks:    cfg.Networks,
+		NetArgs:     cfg.NetArgs,
+		Ports:       cfg.Ports,
+		PreparedNet: cfg.PreparedNet,
+		DNS:         cfg.DNS,
+		DNSSearch:   cfg.DNSSearch,
+		DNSOptions:  cfg.DNSOptions,
+
+		NetReadyTimeout: cfg.NetReadyTimeout,
+		NetReadyPolicy:  cfg.NetReadyPolicy,
+		RegisterMachine: cfg.RegisterMachine,
+	})
+	if err != nil {
+		return fmt.Errorf("error marshalling prepared config: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, preparedFile), b, 0600); err != nil {
+		return fmt.Errorf("error writing prepared config: %v", err)
+	}
+	return nil
+}
+
+// LoadPrepared locks the container prepared in dir and moves it to
+// containersDir. It returns the Config to Run it with and its new
+// directory.
+func LoadPrepared(dir, containersDir string) (Config, string, error) {
+	cfg := Config{
+		ContainersDir: containersDir,
+	}
+	// the lock follows the directory and is kept across the exec of stage1
+	if err := lockDir(dir); err != nil {
+		return cfg, "", err
+	}
+
+	b, err := ioutil.ReadFile(filepath.Join(dir, preparedFile))
+	if err != nil {
+		return cfg, "", fmt.Errorf("error reading prepared config: %v", err)
+	}
+	var pc preparedConfig
+	if err := json.Unmarshal(b, &pc); err != nil {
+		return cfg, "", fmt.Errorf("error unmarshalling prepared config: %v", err)
+	}
+	cfg.Debug = pc.Debug
+	cfg.PrivateNet = pc.PrivateNet
+	cfg.Networks = pc.Networks
+	cfg.NetArgs = pc.NetArgs
+	cfg.Ports = pc.Ports
+	cfg.PreparedNet = pc.PreparedNet
+	cfg.DNS = pc.DNS
+	cfg.DNSSearch = pc.DNSSearch
+	cfg.DNSOptions = pc.DNSOptions
+	cfg.NetReadyTimeout = pc.NetReadyTimeout
+	cfg.NetReadyPolicy = pc.NetReadyPolicy
+	cfg.RegisterMachine = pc.RegisterMachine
+
+	if err := os.MkdirAll(containersDir, 0700); err != nil {
+		return cfg, "", fmt.Errorf("error creating containers directory: %v", err)
+	}
+	cdir := filepath.Join(containersDir, filepath.Base(dir))
+	if err := os.Rename(dir, cdir); err != nil {
+		return cfg, "", fmt.Errorf("error moving prepared container: %v", err)
+	}
+	if err := os.Remove(filepath.Join(cdir, preparedFile)); err != nil {
+		return cfg, "", fmt.Errorf("error removing prepared config: %v", err)
+	}
+	return cfg, cdir, nil
+}
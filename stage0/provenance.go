@@ -0,0 +1,67 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//+build linux
+
+package stage0
+
+import (
+	"crypto/sha512"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/coreos/rocket/stage0/stage1_rootfs"
+)
+
+// Stage1Digests returns the digests, as sha512-HEX, of the stage1 rootfs
+// and init of the container set up in dir by cfg, for the record. The
+// rootfs digest is the ID of the stage1 image, if any, or the digest of
+// the stage1 rootfs tarball, be it built-in or given; the fly flavor has
+// none.
+func Stage1Digests(cfg Config, dir string) (rootfs, init string, err error) {
+	switch {
+	case cfg.Stage1Flavor == Stage1FlavorFly:
+	case cfg.Stage1Image != nil:
+		rootfs = cfg.Stage1Image.String()
+	case cfg.Stage1Rootfs != "":
+		if rootfs, err = fileDigest(cfg.Stage1Rootfs); err != nil {
+			return "", "", fmt.Errorf("error hashing stage1 rootfs: %v", err)
+		}
+	default:
+		b, err := stage1_rootfs.Asset("s1rootfs.tar")
+		if err != nil {
+			return "", "", fmt.Errorf("error accessing rootfs asset: %v", err)
+		}
+		rootfs = fmt.Sprintf("sha512-%x", sha512.Sum512(b))
+	}
+	if init, err = fileDigest(filepath.Join(dir, initPath)); err != nil {
+		return "", "", fmt.Errorf("error hashing stage1 init: %v", err)
+	}
+	return rootfs, init, nil
+}
+
+func fileDigest(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha512.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("sha512-%x", h.Sum(nil)), nil
+}
@@ -0,0 +1,108 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//+build linux
+
+package stage0
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/appc/spec/schema"
+	rktpath "github.com/coreos/rocket/path"
+	"github.com/coreos/rocket/pkg/cgroup"
+)
+
+// checkReservation checks the memory and CPU the apps of cm reserve fit
+// in what the host has left, given what the other prepared and running
+// containers reserve, as cfg.Preflight says: refusing to set up the
+// container or only warning when they don't, unless cfg.Strict.
+func checkReservation(cfg Config, dir string, cm *schema.ContainerRuntimeManifest) error {
+	requested, err := containerReservation(cm)
+	if err != nil {
+		return err
+	}
+	if requested.Empty() {
+		return nil
+	}
+
+	var reserved cgroup.Reservation
+	err = forOtherContainers(cfg, dir, func(cdir string, prepared bool) error {
+		if !prepared {
+			runs, err := containerRuns(cdir)
+			if err != nil || !runs {
+				return err
+			}
+		}
+		r, err := readReservation(cdir)
+		if err != nil {
+			return err
+		}
+		reserved = reserved.Add(r)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	host, err := cgroup.ReadHost()
+	if err != nil {
+		return err
+	}
+	if err := host.Check(reserved, requested); err != nil {
+		if cfg.Preflight == cgroup.PreflightRefuse {
+			return fmt.Errorf("error: %v", err)
+		}
+		return warn(cfg, err)
+	}
+	return nil
+}
+
+// containerReservation returns what the apps of cm reserve in all
+func containerReservation(cm *schema.ContainerRuntimeManifest) (cgroup.Reservation, error) {
+	var total cgroup.Reservation
+	for _, a := range cm.Apps {
+		r, err := cgroup.AppReservation(a.Isolators)
+		if err != nil {
+			return cgroup.Reservation{}, fmt.Errorf("error reading resources of app %s: %v", a.Name, err)
+		}
+		total = total.Add(r)
+	}
+	return total, nil
+}
+
+// readReservation returns what the container in cdir reserves, nothing
+// if it has no manifest yet
+func readReservation(cdir string) (cgroup.Reservation, error) {
+	b, err := ioutil.ReadFile(rktpath.ContainerManifestPath(cdir))
+	if os.IsNotExist(err) {
+		return cgroup.Reservation{}, nil
+	}
+	if err != nil {
+		return cgroup.Reservation{}, fmt.Errorf("error reading manifest of container %s: %v", filepath.Base(cdir), err)
+	}
+	var cm schema.ContainerRuntimeManifest
+	if err := json.Unmarshal(b, &cm); err != nil {
+		return cgroup.Reservation{}, fmt.Errorf("error reading manifest of container %s: %v", filepath.Base(cdir), err)
+	}
+	r, err := containerReservation(&cm)
+	if err != nil {
+		return cgroup.Reservation{}, fmt.Errorf("container %s: %v", filepath.Base(cdir), err)
+	}
+	return r, nil
+}
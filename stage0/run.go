@@ -28,6 +28,7 @@ import (
 	"bytes"
 	"compress/bzip2"
 	"compress/gzip"
+	"context"
 	"crypto/sha512"
 	"encoding/json"
 	"fmt"
@@ -36,25 +37,48 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"strings"
 	"syscall"
+	"time"
 
 	"github.com/appc/spec/aci"
 	"github.com/appc/spec/schema"
 	"github.com/appc/spec/schema/types"
 	"github.com/coreos/rocket/Godeps/_workspace/src/code.google.com/p/go-uuid/uuid"
 	"github.com/coreos/rocket/cas"
+	"github.com/coreos/rocket/metadatasvc"
+	"github.com/coreos/rocket/networking"
 	rktpath "github.com/coreos/rocket/path"
+	"github.com/coreos/rocket/pkg/cgroup"
+	"github.com/coreos/rocket/pkg/coredump"
+	pkgio "github.com/coreos/rocket/pkg/io"
 	"github.com/coreos/rocket/pkg/lock"
+	"github.com/coreos/rocket/pkg/mounts"
+	"github.com/coreos/rocket/pkg/quota"
+	"github.com/coreos/rocket/pkg/selinux"
 	ptar "github.com/coreos/rocket/pkg/tar"
+	"github.com/coreos/rocket/pkg/trace"
+	"github.com/coreos/rocket/pkg/verity"
+	"github.com/coreos/rocket/pkg/watchdog"
+	"github.com/coreos/rocket/pkg/zfs"
 	"github.com/coreos/rocket/version"
+	"github.com/coreos/rocket/volume"
 
 	"github.com/coreos/rocket/stage0/stage1_init"
 	"github.com/coreos/rocket/stage0/stage1_rootfs"
 )
 
+// Stage1FlavorFly is the flavor of stage1 running the single app of a
+// container chrooted in its rootfs, without isolating it from the host
+const Stage1FlavorFly = "fly"
+
 const (
 	initPath  = "stage1/init"
 	envLockFd = "RKT_LOCK_FD"
+	// envVerity holds the dm-verity root hashes of the app images as
+	// comma-separated imageID=roothash pairs. It is passed to stage1 in
+	// the environment so the root hashes never touch the disk.
+	envVerity = "RKT_VERITY"
 )
 
 type Config struct {
@@ -64,50 +88,253 @@ type Config struct {
 	Stage1Rootfs  string     // compressed bundle containing a rootfs for stage1
 	Debug         bool
 	// TODO(jonboulle): These images are partially-populated hashes, this should be clarified.
-	Images     []types.Hash      // application images
-	Volumes    map[string]string // map of volumes that rocket can provide to applications
-	PrivateNet bool              // container should have its own network stack
+	Images  []types.Hash   // application images
+	Volumes []types.Volume // volumes that rocket can provide to applications
+	// DriverVolumes are volumes provisioned by volume drivers, keyed by name
+	DriverVolumes map[string]volume.Spec
+	PrivateNet    bool // container should have its own network stack
+	// Networks names the nets a container with a private network stack
+	// is attached to, all the configured ones if empty
+	Networks []string
+	// NetArgs holds, by net name, arguments for the nets' plugins, e.g.
+	// "IP=10.1.2.3" to request a specific address
+	NetArgs map[string]string
+	// Ports are the ports of the apps forwarded from the host, to a
+	// container with a private network stack
+	Ports []networking.ForwardedPort
+	// PreparedNet tells the network was set up when the container was
+	// prepared, so stage1 only has to enter it
+	PreparedNet bool
+	// DNS, DNSSearch and DNSOptions are the nameservers, search domains
+	// and resolv.conf options of the apps, replacing those returned by
+	// the network plugins or, without a private network, the host's
+	DNS        []string
+	DNSSearch  []string
+	DNSOptions []string
+	// Watchdog, if set, is kept informed of the setup phase and its
+	// remaining time is handed to stage1 to bound network setup.
+	Watchdog *watchdog.Watchdog
+	// Annotations are attached to the container runtime manifest
+	Annotations types.Annotations
+	// Verity packs each app rootfs into a dm-verity protected image which
+	// stage1 mounts read-only, so tampering is detected on read
+	Verity bool
+	// Dependencies maps image IDs to the image IDs of their direct
+	// dependencies, which are rendered beneath them
+	Dependencies map[string][]types.Hash
+	// TreeStore, if set, renders images once in the store's tree store
+	// and reuses them from there
+	TreeStore TreeStoreMode
+	// DiskQuota, if not 0, limits the space in bytes the container's
+	// files may use, through a project quota on its directory
+	DiskQuota uint64
+	// ImageCheck, if set, is called for each image once rendered in
+	// rootfs, an error refusing to run the container
+	ImageCheck func(name types.ACName, img types.Hash, rootfs string) error
+	// UUID, if set, is the UUID of the container instead of a random one
+	UUID *types.UUID
+	// PodManifest, if set, is the manifest the images were resolved
+	// from: its volumes, isolators and annotations, and those of its
+	// apps, are added to the container runtime manifest. Annotations
+	// override those of the images and are overridden by Annotations.
+	PodManifest *schema.ContainerRuntimeManifest
+	// Stage1Flavor, if set, is the flavor of stage1 to run the container
+	// with, instead of the one of the stage1 rootfs
+	Stage1Flavor string
+	// NetReadyTimeout, if not 0, is how long stage1 waits for the nets
+	// of a container with a private network stack to be ready before
+	// starting the apps, and NetReadyPolicy what it does if they aren't
+	NetReadyTimeout time.Duration
+	NetReadyPolicy  networking.ReadyPolicy
+	// RegisterMachine has stage1 register the container with
+	// systemd-machined, as rkt-UUID, if the host runs systemd
+	RegisterMachine bool
+	// Stage1Image, if set, is the image in the store whose rootfs is the
+	// stage1 rootfs, instead of Stage1Rootfs or the built-in one
+	Stage1Image *types.Hash
+	// Isolators are added to those of all the apps, replacing the ones
+	// of the same name, e.g. to override the resource limits of images
+	Isolators types.Isolators
+	// CoreDumps, if set, is how the core dumps of the apps are routed by
+	// "rkt coredump"
+	CoreDumps *coredump.Config
+	// PreparedDir and RunningDir, if set, are the directories of the
+	// prepared and running containers, whose forwarded host ports those
+	// of the container must not collide with, and whose reserved
+	// resources count against the host's with Preflight
+	PreparedDir string
+	RunningDir  string
+	// SELinux runs the container in SELinux contexts of its own, derived
+	// from SELinuxProcess and SELinuxFile, or the host's lxc_contexts if
+	// empty, with a category pair no other container has, and labels its
+	// files accordingly. It is ignored unless SELinux is enabled.
+	SELinux        bool
+	SELinuxProcess string
+	SELinuxFile    string
+	// AppArmor maps app names to the AppArmor profile, or profile file,
+	// they are confined by, overriding the annotations of the images and
+	// the pod manifest; the empty name applies to all the other apps
+	AppArmor map[string]string
+	// Preflight, if set, checks the memory and CPU shares the apps
+	// reserve through their isolators fit in the host, refusing to set
+	// up the container or warning when they don't
+	Preflight cgroup.PreflightPolicy
+	// Hardened hardens all the apps, overriding the annotations of the
+	// images and the pod manifest: stage1 keeps them from gaining
+	// privileges and masks the sensitive files of /proc and /sys
+	Hardened bool
+	// Trace, if not nil, gets the spans of the setup of the container. It
+	// is written to TraceFile, if set, before stage1 is exec'd and again
+	// by stage1 once the apps are about to start.
+	Trace     *trace.Span
+	TraceFile string
+	// Mounts add mount points to the apps, for volumes to be mounted
+	// where their images declare none
+	Mounts []mounts.Mount
+	// Strict fails the setup where it would warn of a misconfiguration,
+	// e.g. volumes no app mounts or unknown annotations of rkt
+	Strict bool
+	// VolumeOptions are how stage1 sets up the volumes, by name,
+	// overriding those the annotations of the container give
+	VolumeOptions map[types.ACName]volume.Options
+
+	// selinuxLabels are the labels allocated to the container
+	selinuxLabels *selinux.Labels
 }
 
 func init() {
 	log.SetOutput(ioutil.Discard)
 }
 
+// mergeAnnotations returns the annotations of base not named in over,
+// followed by over
+func mergeAnnotations(base, over types.Annotations) types.Annotations {
+	var merged types.Annotations
+	for _, a := range base {
+		if _, ok := over.Get(string(a.Name)); !ok {
+			merged = append(merged, a)
+		}
+	}
+	return append(merged, over...)
+}
+
 // Setup sets up a filesystem for a container based on the given config.
 // The directory containing the filesystem is returned, and any error encountered.
-func Setup(cfg Config) (string, error) {
+// Setup is aborted once ctx is done. On errors, the container directory is
+// removed.
+func Setup(ctx context.Context, cfg Config) (_ string, err error) {
 	if cfg.Debug {
 		log.SetOutput(os.Stderr)
 	}
 
-	cuuid, err := types.NewUUID(uuid.New())
-	if err != nil {
-		return "", fmt.Errorf("error creating UID: %v", err)
+	if cfg.Verity && cfg.TreeStore == TreeStoreOverlay {
+		return "", fmt.Errorf("error: verity can't be used with an overlay tree store")
+	}
+	if len(cfg.Ports) > 0 && !cfg.PrivateNet {
+		return "", fmt.Errorf("error: ports can only be forwarded to containers with a private network")
+	}
+	if cfg.DiskQuota > 0 && cfg.TreeStore == TreeStoreHardlink {
+		// files can't be hard-linked across projects
+		return "", fmt.Errorf("error: a disk quota can't be used with a hardlink tree store")
+	}
+	if cfg.DiskQuota > 0 && cfg.TreeStore == TreeStoreZFS {
+		// clones are datasets of their own, outside of the project
+		return "", fmt.Errorf("error: a disk quota can't be used with a zfs tree store")
 	}
 
-	// TODO(jonboulle): collision detection/mitigation
-	// Create a directory for this container
+	cuuid := cfg.UUID
+	if cuuid == nil {
+		if cuuid, err = types.NewUUID(uuid.New()); err != nil {
+			return "", fmt.Errorf("error creating UID: %v", err)
+		}
+	}
+
+	// Create a directory for this container, which must not exist yet:
+	// a given UUID may be the one of an existing container
 	dir := filepath.Join(cfg.ContainersDir, cuuid.String())
 
-	if err := os.MkdirAll(dir, 0700); err != nil {
+	if err := os.MkdirAll(cfg.ContainersDir, 0700); err != nil {
 		return "", fmt.Errorf("error creating directory: %v", err)
 	}
+	if err := os.Mkdir(dir, 0700); err != nil {
+		if os.IsExist(err) {
+			return "", fmt.Errorf("error: container %v already exists", cuuid)
+		}
+		return "", fmt.Errorf("error creating directory: %v", err)
+	}
+	defer func() {
+		if err != nil {
+			CleanupSetup(cfg, dir)
+		}
+	}()
+
+	if cfg.DiskQuota > 0 {
+		if err := quota.Set(dir, quota.ProjectID(cuuid.String()), cfg.DiskQuota); err != nil {
+			return "", fmt.Errorf("error setting disk quota: %v", err)
+		}
+	}
 
 	// Set up the container lock
 	if err := lockDir(dir); err != nil {
 		return "", err
 	}
 
+	if len(cfg.Ports) > 0 {
+		if err := reservePorts(cfg, dir); err != nil {
+			return "", err
+		}
+	}
+
+	if cfg.SELinux && selinux.Enabled() {
+		if cfg.TreeStore == TreeStoreHardlink {
+			// the files are shared with other containers
+			return "", fmt.Errorf("error: a hardlink tree store can't be used with SELinux labeling")
+		}
+		process, file := selinux.Contexts()
+		if cfg.SELinuxProcess != "" {
+			process = cfg.SELinuxProcess
+		}
+		if cfg.SELinuxFile != "" {
+			file = cfg.SELinuxFile
+		}
+		if cfg.selinuxLabels, err = selinux.Allocate(process, file, cfg.PreparedDir, cfg.RunningDir); err != nil {
+			return "", fmt.Errorf("error allocating SELinux labels: %v", err)
+		}
+		if err := cfg.selinuxLabels.Write(dir); err != nil {
+			return "", fmt.Errorf("error writing SELinux labels: %v", err)
+		}
+	}
+
+	cfg.Watchdog.SetPhase(watchdog.PhaseExtract)
 	log.Printf("Unpacking stage1 rootfs")
-	if cfg.Stage1Rootfs != "" {
-		err = unpackRootfs(cfg.Stage1Rootfs, rktpath.Stage1RootfsPath(dir))
-	} else {
-		err = unpackBuiltinRootfs(rktpath.Stage1RootfsPath(dir))
+	sctx, span := trace.Start(ctx, "render stage1")
+	switch {
+	case cfg.Stage1Flavor == Stage1FlavorFly:
+		// fly runs nothing from the stage1 rootfs
+		err = os.MkdirAll(rktpath.Stage1RootfsPath(dir), 0755)
+	case cfg.Stage1Image != nil:
+		err = renderStage1Image(sctx, cfg, *cfg.Stage1Image, dir)
+	case cfg.Stage1Rootfs != "":
+		err = unpackRootfs(sctx, cfg.Stage1Rootfs, rktpath.Stage1RootfsPath(dir))
+	default:
+		err = unpackBuiltinRootfs(sctx, rktpath.Stage1RootfsPath(dir))
 	}
+	span.Finish()
 	if err != nil {
 		return "", fmt.Errorf("error unpacking rootfs: %v", err)
 	}
+	if cfg.Stage1Flavor != "" {
+		if err := ioutil.WriteFile(rktpath.Stage1FlavorPath(dir), []byte(cfg.Stage1Flavor+"\n"), 0644); err != nil {
+			return "", fmt.Errorf("error writing stage1 flavor: %v", err)
+		}
+	}
+	if cfg.CoreDumps != nil {
+		if err := cfg.CoreDumps.Write(dir); err != nil {
+			return "", fmt.Errorf("error writing core dump routing: %v", err)
+		}
+	}
 
+	cfg.Watchdog.SetPhase(watchdog.PhaseRender)
 	log.Printf("Writing stage1 init")
 	var in io.Reader
 	if cfg.Stage1Init != "" {
@@ -141,6 +368,10 @@ func Setup(cfg Config) (string, error) {
 		UUID:   *cuuid,
 		Apps:   make(schema.AppList, 0),
 	}
+	cm.Annotations = cfg.Annotations
+	if cfg.PodManifest != nil {
+		cm.Annotations = mergeAnnotations(cfg.PodManifest.Annotations, cfg.Annotations)
+	}
 
 	v, err := types.NewSemVer(version.Version)
 	if err != nil {
@@ -148,11 +379,41 @@ func Setup(cfg Config) (string, error) {
 	}
 	cm.ACVersion = *v
 
+	cfg.Watchdog.SetPhase(watchdog.PhaseExtract)
+	var rootHashes []string
+	declared := make(map[types.ACName]bool)
+	apps := make(map[string]*schema.ImageManifest)
+	mounted := make(map[types.ACName]bool)
+	for _, m := range cfg.Mounts {
+		mounted[m.Volume] = true
+	}
 	for _, img := range cfg.Images {
-		am, err := setupImage(cfg, img, dir)
+		am, err := setupImage(ctx, cfg, img, dir)
 		if err != nil {
 			return "", fmt.Errorf("error setting up image %s: %v", img, err)
 		}
+		if cfg.ImageCheck != nil {
+			if err := cfg.ImageCheck(am.Name, img, rktpath.AppRootfsPath(dir, img)); err != nil {
+				return "", err
+			}
+		}
+		if cfg.Verity {
+			cfg.Watchdog.SetPhase(watchdog.PhaseRender)
+			log.Printf("Building verity image for %s", img)
+			h, err := verity.Format(rktpath.AppRootfsPath(dir, img), rktpath.AppVerityDataPath(dir, img), rktpath.AppVerityHashPath(dir, img))
+			if err != nil {
+				return "", fmt.Errorf("error protecting image %s: %v", img, err)
+			}
+			// the rootfs directory becomes the mountpoint of the image
+			if err := os.RemoveAll(rktpath.AppRootfsPath(dir, img)); err != nil {
+				return "", fmt.Errorf("error removing rendered rootfs: %v", err)
+			}
+			if err := os.Mkdir(rktpath.AppRootfsPath(dir, img), 0755); err != nil {
+				return "", fmt.Errorf("error creating rootfs mountpoint: %v", err)
+			}
+			rootHashes = append(rootHashes, img.String()+"="+h)
+			cfg.Watchdog.SetPhase(watchdog.PhaseExtract)
+		}
 		if cm.Apps.Get(am.Name) != nil {
 			return "", fmt.Errorf("error: multiple apps with name %s", am.Name)
 		}
@@ -162,24 +423,100 @@ func Setup(cfg Config) (string, error) {
 			Isolators:   am.App.Isolators,
 			Annotations: am.Annotations,
 		}
+		if cfg.PodManifest != nil {
+			if pa := cfg.PodManifest.Apps.Get(am.Name); pa != nil {
+				a.Isolators = append(a.Isolators, pa.Isolators...)
+				a.Annotations = mergeAnnotations(a.Annotations, pa.Annotations)
+			}
+		}
+		if len(cfg.Isolators) > 0 {
+			a.Isolators = cgroup.OverrideIsolators(a.Isolators, cfg.Isolators)
+		}
+		if err := setAppArmorProfile(cfg, cm.Annotations, &a); err != nil {
+			return "", err
+		}
+		if err := setHardened(cfg, cm.Annotations, &a); err != nil {
+			return "", err
+		}
+		if err := setMounts(cfg, &a); err != nil {
+			return "", err
+		}
 		cm.Apps = append(cm.Apps, a)
+		apps[am.Name.String()] = am
+		for _, p := range am.App.Ports {
+			declared[p.Name] = true
+		}
+		for _, mp := range am.App.MountPoints {
+			mounted[mp.Name] = true
+		}
+	}
+
+	for _, fp := range cfg.Ports {
+		if !declared[fp.Name] {
+			return "", fmt.Errorf("error: port %q is not declared by any app", fp.Name)
+		}
+	}
+
+	if cfg.Preflight != "" {
+		if err := checkReservation(cfg, dir, &cm); err != nil {
+			return "", err
+		}
+	}
+
+	if cfg.selinuxLabels != nil {
+		log.Printf("Labeling files")
+		if err := cfg.selinuxLabels.Relabel(rktpath.Stage1RootfsPath(dir)); err != nil {
+			return "", fmt.Errorf("error labeling container files: %v", err)
+		}
+	}
+
+	if cfg.Verity {
+		if err := os.Setenv(envVerity, strings.Join(rootHashes, ",")); err != nil {
+			return "", fmt.Errorf("error passing verity root hashes: %v", err)
+		}
 	}
 
-	var sVols []types.Volume
-	for key, path := range cfg.Volumes {
-		v := types.Volume{
-			Kind:     "host",
-			Source:   path,
-			ReadOnly: true,
-			Fulfills: []types.ACName{
-				types.ACName(key),
-			},
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	cfg.Watchdog.SetPhase(watchdog.PhaseRender)
+	sVols := append([]types.Volume(nil), cfg.Volumes...)
+	if len(cfg.DriverVolumes) > 0 {
+		log.Printf("Mounting volumes")
+		paths, err := volume.Mount(dir, *cuuid, cfg.DriverVolumes)
+		if err != nil {
+			return "", fmt.Errorf("error mounting volumes: %v", err)
+		}
+		for key, path := range paths {
+			v := types.Volume{
+				Kind:   "host",
+				Source: path,
+				Fulfills: []types.ACName{
+					types.ACName(key),
+				},
+			}
+			sVols = append(sVols, v)
 		}
-		sVols = append(sVols, v)
+	}
+	if cfg.PodManifest != nil {
+		cm.Isolators = cfg.PodManifest.Isolators
+		sVols = append(sVols, cfg.PodManifest.Volumes...)
 	}
 	// TODO(jonboulle): check that app mountpoint expectations are
 	// satisfied here, rather than waiting for stage1
 	cm.Volumes = sVols
+	if err := checkMounts(cfg, &cm); err != nil {
+		return "", err
+	}
+	if err := setVolumeOptions(cfg, &cm); err != nil {
+		return "", err
+	}
+	if err := checkVolumesMounted(cfg, &cm, mounted); err != nil {
+		return "", err
+	}
+	if err := checkAnnotations(cfg, &cm); err != nil {
+		return "", err
+	}
 
 	cdoc, err := json.Marshal(cm)
 	if err != nil {
@@ -191,6 +528,14 @@ func Setup(cfg Config) (string, error) {
 	if err := ioutil.WriteFile(fn, cdoc, 0700); err != nil {
 		return "", fmt.Errorf("error writing container manifest: %v", err)
 	}
+
+	// stage1 registers the container anew if the service didn't get it
+	if metadatasvc.Available() {
+		log.Printf("Registering container with the metadata service")
+		if err := metadatasvc.RegisterContainer(&cm, apps); err != nil {
+			log.Printf("Warning: %v", err)
+		}
+	}
 	return dir, nil
 }
 
@@ -207,9 +552,62 @@ func Run(cfg Config, dir string) {
 	if cfg.Debug {
 		args = append(args, "--debug")
 	}
+	if cfg.RegisterMachine {
+		args = append(args, "--register-machine")
+	}
+	for _, ns := range cfg.DNS {
+		args = append(args, "--dns="+ns)
+	}
+	for _, s := range cfg.DNSSearch {
+		args = append(args, "--dns-search="+s)
+	}
+	for _, o := range cfg.DNSOptions {
+		args = append(args, "--dns-opt="+o)
+	}
 	if cfg.PrivateNet {
-		args = append(args, "--private-net")
+		if len(cfg.Networks) > 0 {
+			nets := make([]string, len(cfg.Networks))
+			for i, n := range cfg.Networks {
+				nets[i] = n
+				if a := cfg.NetArgs[n]; a != "" {
+					nets[i] += ":" + a
+				}
+			}
+			args = append(args, "--private-net="+strings.Join(nets, ","))
+		} else {
+			args = append(args, "--private-net")
+		}
+		for _, fp := range cfg.Ports {
+			args = append(args, "--port="+fp.String())
+		}
+		if cfg.PreparedNet {
+			args = append(args, "--prepared-net")
+		}
+		if cfg.Watchdog != nil {
+			// stage1 sets up the network, so it inherits what is left
+			// of the setup timeout
+			remaining := cfg.Watchdog.Remaining()
+			if remaining < time.Second {
+				remaining = time.Second
+			}
+			args = append(args, fmt.Sprintf("--setup-timeout=%v", remaining))
+		}
+		if cfg.NetReadyTimeout > 0 {
+			args = append(args, fmt.Sprintf("--net-ready-timeout=%v", cfg.NetReadyTimeout))
+			if cfg.NetReadyPolicy != "" {
+				args = append(args, "--net-ready-policy="+string(cfg.NetReadyPolicy))
+			}
+		}
+	}
+	if cfg.TraceFile != "" {
+		// left open for stage1 to finish
+		cfg.Trace.Child(trace.SpanStage1).Child(trace.SpanExec)
+		if err := cfg.Trace.WriteFile(cfg.TraceFile); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+		}
+		args = append(args, "--trace="+cfg.TraceFile)
 	}
+	cfg.Watchdog.Stop()
 	if err := syscall.Exec(initPath, args, os.Environ()); err != nil {
 		log.Fatalf("error execing init: %v", err)
 	}
@@ -228,8 +626,39 @@ func lockDir(dir string) error {
 	return os.Setenv(envLockFd, fmt.Sprintf("%v", fd))
 }
 
-func untarRootfs(r io.Reader, dir string) error {
-	tr := tar.NewReader(r)
+// CleanupSetup removes the directory of a container Setup, or whatever
+// followed it, failed to set up. The volumes and overlays mounted in it are
+// released first, so that nothing outside of it is removed; it is left alone
+// if they can't be.
+func CleanupSetup(cfg Config, dir string) {
+	if err := volume.UnmountAll(dir); err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to release the volumes of container %s, leaving it behind: %v\n", dir, err)
+		return
+	}
+	if err := zfs.DestroyUnder(dir); err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to destroy the ZFS clones of container %s, leaving it behind: %v\n", dir, err)
+		return
+	}
+	for _, img := range cfg.Images {
+		// EINVAL: not a mount point, the image was extracted
+		err := syscall.Unmount(rktpath.AppRootfsPath(dir, img), 0)
+		if err != nil && err != syscall.EINVAL && err != syscall.ENOENT {
+			fmt.Fprintf(os.Stderr, "Unable to unmount the overlay of image %s, leaving container %s behind: %v\n", img, dir, err)
+			return
+		}
+	}
+	if cfg.DiskQuota > 0 {
+		if err := quota.Clear(dir); err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to clear the disk quota of container %s: %v\n", dir, err)
+		}
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to remove container %s: %v\n", dir, err)
+	}
+}
+
+func untarRootfs(ctx context.Context, r io.Reader, dir string) error {
+	tr := tar.NewReader(&pkgio.ContextReader{Ctx: ctx, R: r})
 	if err := os.MkdirAll(dir, 0776); err != nil {
 		return fmt.Errorf("error creating stage1 rootfs directory: %v", err)
 	}
@@ -242,11 +671,12 @@ func untarRootfs(r io.Reader, dir string) error {
 
 // unpackRootfs unpacks a stage1 rootfs (compressed file, pointed to by rfs)
 // into dir, returning any error encountered
-func unpackRootfs(rfs string, dir string) error {
+func unpackRootfs(ctx context.Context, rfs string, dir string) error {
 	fh, err := os.Open(rfs)
 	if err != nil {
 		return fmt.Errorf("error opening stage1 rootfs: %v", err)
 	}
+	defer fh.Close()
 	typ, err := aci.DetectFileType(fh)
 	if err != nil {
 		return fmt.Errorf("error detecting image type: %v", err)
@@ -273,56 +703,45 @@ func unpackRootfs(rfs string, dir string) error {
 		// should never happen
 		panic("no type returned from DetectFileType?")
 	}
-	return untarRootfs(r, dir)
+	return untarRootfs(ctx, r, dir)
 }
 
 // unpackBuiltinRootfs unpacks the included stage1 rootfs into dir
-func unpackBuiltinRootfs(dir string) error {
+func unpackBuiltinRootfs(ctx context.Context, dir string) error {
 	b, err := stage1_rootfs.Asset("s1rootfs.tar")
 	if err != nil {
 		return fmt.Errorf("error accessing rootfs asset: %v", err)
 	}
 	buf := bytes.NewBuffer(b)
-	return untarRootfs(buf, dir)
+	return untarRootfs(ctx, buf, dir)
 }
 
 // setupImage attempts to load the image by the given hash from the store,
-// verifies that the image matches the hash, and extracts the image into a
-// directory in the given dir.
+// verifies that the image matches the hash, and extracts the image, on top
+// of its dependencies, into a directory in the given dir.
 // It returns the ImageManifest that the image contains.
 // TODO(jonboulle): tighten up the Hash type here; currently it is partially-populated (i.e. half-length sha512)
-func setupImage(cfg Config, img types.Hash, dir string) (*schema.ImageManifest, error) {
+func setupImage(ctx context.Context, cfg Config, img types.Hash, dir string) (*schema.ImageManifest, error) {
 	log.Println("Loading image", img.String())
-
-	rs, err := cfg.Store.ReadStream(img.String())
-	if err != nil {
-		return nil, fmt.Errorf("error reading stream: %v", err)
-	}
+	ctx, span := trace.Start(ctx, "render "+img.String())
+	defer span.Finish()
 
 	ad := rktpath.AppImagePath(dir, img)
-	err = os.MkdirAll(ad, 0776)
+	err := os.MkdirAll(ad, 0776)
 	if err != nil {
 		return nil, fmt.Errorf("error creating image directory: %v", err)
 	}
 
-	hash := sha512.New()
-	r := io.TeeReader(rs, hash)
-
-	if err := ptar.ExtractTar(tar.NewReader(r), ad, false, nil); err != nil {
-		return nil, fmt.Errorf("error extracting ACI: %v", err)
-	}
-
-	// Tar does not necessarily read the complete file, so ensure we read the entirety into the hash
-	if _, err := io.Copy(ioutil.Discard, r); err != nil {
-		return nil, fmt.Errorf("error reading ACI: %v", err)
+	switch cfg.TreeStore {
+	case TreeStoreNone:
+		err = renderImage(ctx, cfg, img, ad, nil)
+	case TreeStoreAuto:
+		err = setupTreeAuto(ctx, cfg, img, dir, ad)
+	default:
+		err = setupTree(ctx, cfg, img, dir, ad, cfg.TreeStore)
 	}
-
-	// TODO(jonboulle): clean this up, leaky abstraction with the store.
-	if g := cas.HashToKey(hash); g != img.String() {
-		if err := os.RemoveAll(ad); err != nil {
-			fmt.Fprintf(os.Stderr, "error cleaning up directory: %v\n", err)
-		}
-		return nil, fmt.Errorf("image hash does not match expected (%v != %v)", g, img.String())
+	if err != nil {
+		return nil, err
 	}
 
 	err = os.MkdirAll(filepath.Join(ad, "rootfs/tmp"), 0777)
@@ -345,3 +764,98 @@ func setupImage(cfg Config, img types.Hash, dir string) (*schema.ImageManifest,
 	}
 	return &am, nil
 }
+
+// renderImage extracts img into ad after rendering its dependencies, so
+// its files take precedence over theirs. Only the paths in pwl are
+// extracted if it is not nil; the pathWhitelist of an image further
+// restricts the files taken from its dependencies.
+func renderImage(ctx context.Context, cfg Config, img types.Hash, ad string, pwl ptar.PathWhitelistMap) error {
+	deps := cfg.Dependencies[img.String()]
+	if len(deps) > 0 {
+		im, err := cfg.Store.GetImageManifest(img.String())
+		if err != nil {
+			return fmt.Errorf("error reading manifest of %s: %v", img, err)
+		}
+		dpwl := restrictWhitelist(pwl, im.PathWhitelist)
+		for _, dep := range deps {
+			log.Println("Loading dependency", dep.String())
+			if err := renderImage(ctx, cfg, dep, ad, dpwl); err != nil {
+				return fmt.Errorf("error rendering dependency %s of %s: %v", dep, img, err)
+			}
+		}
+	}
+	return extractImage(ctx, cfg, img, ad, pwl)
+}
+
+// renderStage1Image renders img, with its dependencies, and moves its
+// rootfs in place as the stage1 rootfs of the container in dir
+func renderStage1Image(ctx context.Context, cfg Config, img types.Hash, dir string) error {
+	ad := filepath.Join(dir, "stage1-image")
+	if err := os.MkdirAll(ad, 0755); err != nil {
+		return err
+	}
+	defer os.RemoveAll(ad)
+	if err := renderImage(ctx, cfg, img, ad, nil); err != nil {
+		return err
+	}
+	return os.Rename(filepath.Join(ad, aci.RootfsDir), rktpath.Stage1RootfsPath(dir))
+}
+
+// restrictWhitelist returns the paths of pwl also present in the
+// pathWhitelist paths of an image manifest, an empty pathWhitelist allows
+// everything. The returned map refers to paths in an ACI, including the
+// ancestor directories of the whitelisted paths.
+func restrictWhitelist(pwl ptar.PathWhitelistMap, paths []string) ptar.PathWhitelistMap {
+	if len(paths) == 0 {
+		return pwl
+	}
+	m := make(ptar.PathWhitelistMap)
+	for _, p := range paths {
+		for p = filepath.Join(aci.RootfsDir, p); p != "."; p = filepath.Dir(p) {
+			if _, ok := pwl[p]; pwl == nil || ok {
+				m[p] = struct{}{}
+			}
+		}
+	}
+	return m
+}
+
+// extractImage extracts the files of img in pwl, or all of them if pwl is
+// nil, into ad and verifies that the image matches its hash.
+func extractImage(ctx context.Context, cfg Config, img types.Hash, ad string, pwl ptar.PathWhitelistMap) error {
+	b, err := cfg.Store.OpenBlob(img.String())
+	if err != nil {
+		return fmt.Errorf("error reading stream: %v", err)
+	}
+	defer b.Close()
+
+	hash := sha512.New()
+	var r io.Reader = &pkgio.ContextReader{Ctx: ctx, R: b}
+	// mapped images are hashed in place once extracted, streamed ones as
+	// they are read
+	if b.Bytes() == nil {
+		r = io.TeeReader(r, hash)
+	}
+
+	// files of images further up the dependency chain replace those
+	// already extracted
+	if err := ptar.ExtractTar(tar.NewReader(r), ad, true, pwl); err != nil {
+		return fmt.Errorf("error extracting ACI: %v", err)
+	}
+
+	if data := b.Bytes(); data != nil {
+		hash.Write(data)
+	} else if _, err := io.Copy(ioutil.Discard, r); err != nil {
+		// Tar does not necessarily read the complete file, so ensure we read the entirety into the hash
+		return fmt.Errorf("error reading ACI: %v", err)
+	}
+
+	// TODO(jonboulle): clean this up, leaky abstraction with the store.
+	if g := cas.HashToKey(hash); g != img.String() {
+		if err := os.RemoveAll(ad); err != nil {
+			fmt.Fprintf(os.Stderr, "error cleaning up directory: %v\n", err)
+		}
+		return fmt.Errorf("image hash does not match expected (%v != %v)", g, img.String())
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//+build linux
+
+package stage0
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/appc/spec/schema"
+	"github.com/appc/spec/schema/types"
+	"github.com/coreos/rocket/pkg/apparmor"
+	"github.com/coreos/rocket/pkg/harden"
+	"github.com/coreos/rocket/pkg/mounts"
+	"github.com/coreos/rocket/volume"
+)
+
+// annotationPrefixes are the namespaces of the annotations rkt acts on
+var annotationPrefixes = []string{"os/linux/", "coreos.com/rkt/"}
+
+// knownAnnotations are the annotations of annotationPrefixes rkt knows
+var knownAnnotations = map[types.ACName]bool{
+	apparmor.ProfileAnnotation: true,
+	harden.Annotation:          true,
+	mounts.Annotation:          true,
+	volume.OptionsAnnotation:   true,
+}
+
+// warn prints err as a warning, unless cfg.Strict makes it an error.
+func warn(cfg Config, err error) error {
+	if cfg.Strict {
+		return fmt.Errorf("error: %v", err)
+	}
+	fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+	return nil
+}
+
+// checkAnnotations warns of the annotations of cm and its apps which are
+// in the namespaces of rkt but unknown to it, e.g. misspelled, as they
+// would be ignored.
+func checkAnnotations(cfg Config, cm *schema.ContainerRuntimeManifest) error {
+	check := func(as types.Annotations, of string) error {
+		for _, a := range as {
+			if knownAnnotations[a.Name] {
+				continue
+			}
+			for _, p := range annotationPrefixes {
+				if strings.HasPrefix(a.Name.String(), p) {
+					if err := warn(cfg, fmt.Errorf("unknown annotation %s of %s", a.Name, of)); err != nil {
+						return err
+					}
+					break
+				}
+			}
+		}
+		return nil
+	}
+	if err := check(cm.Annotations, "the container"); err != nil {
+		return err
+	}
+	for _, a := range cm.Apps {
+		if err := check(a.Annotations, "app "+a.Name.String()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkVolumesMounted warns of the volumes of cm fulfilling none of the
+// mount points in mounted, which no app would see.
+func checkVolumesMounted(cfg Config, cm *schema.ContainerRuntimeManifest, mounted map[types.ACName]bool) error {
+	for _, v := range cm.Volumes {
+		used := false
+		for _, f := range v.Fulfills {
+			used = used || mounted[f]
+		}
+		if !used && len(v.Fulfills) > 0 {
+			if err := warn(cfg, fmt.Errorf("volume %q is not mounted by any app", v.Fulfills[0])); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,244 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//+build linux
+
+package stage0
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha512"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/appc/spec/aci"
+	"github.com/appc/spec/schema/types"
+	"github.com/coreos/rocket/cas"
+	rktpath "github.com/coreos/rocket/path"
+	"github.com/coreos/rocket/pkg/lock"
+	"github.com/coreos/rocket/pkg/selinux"
+	"github.com/coreos/rocket/pkg/zfs"
+)
+
+// TreeStoreMode tells how images rendered once in the store's tree store
+// are made available to containers.
+type TreeStoreMode string
+
+const (
+	// TreeStoreNone extracts the images into each container
+	TreeStoreNone TreeStoreMode = ""
+	// TreeStoreOverlay mounts an overlay with the rendered image as its
+	// read-only lower layer, changes made by the apps go to the container
+	TreeStoreOverlay TreeStoreMode = "overlay"
+	// TreeStoreHardlink hard-links the files of the rendered image into the
+	// container. Files are shared with the tree store and other containers,
+	// so it is only safe with apps which don't modify their files in place.
+	TreeStoreHardlink TreeStoreMode = "hardlink"
+	// TreeStoreZFS renders images into ZFS datasets, snapshotted once
+	// rendered, and mounts a writable clone of the snapshot in the
+	// container, which must be on the same pool as the store
+	TreeStoreZFS TreeStoreMode = "zfs"
+	// TreeStoreAuto uses ZFS clones when the containers are on ZFS, where
+	// overlayfs doesn't work, otherwise an overlay where possible, i.e.
+	// when the kernel supports overlayfs, and extracts the images into
+	// each container otherwise. Images are only kept rendered if they
+	// may be.
+	TreeStoreAuto TreeStoreMode = "auto"
+)
+
+// treeStoreID identifies the rendering of img on top of its dependencies
+func treeStoreID(cfg Config, img types.Hash) string {
+	h := sha512.New()
+	var add func(img types.Hash)
+	add = func(img types.Hash) {
+		io.WriteString(h, "("+img.String())
+		for _, dep := range cfg.Dependencies[img.String()] {
+			add(dep)
+		}
+		io.WriteString(h, ")")
+	}
+	add(img)
+	return cas.HashToKey(h)
+}
+
+// setupTreeAuto makes img available in ad through a ZFS clone or an
+// overlay if possible, falling back to extracting it.
+func setupTreeAuto(ctx context.Context, cfg Config, img types.Hash, dir, ad string) error {
+	if cfg.Verity || cfg.Store.Encrypted() {
+		return renderImage(ctx, cfg, img, ad, nil)
+	}
+	if _, err := zfs.DatasetOf(dir); err == nil {
+		return setupTree(ctx, cfg, img, dir, ad, TreeStoreZFS)
+	}
+	if !overlaySupported() {
+		return renderImage(ctx, cfg, img, ad, nil)
+	}
+	err := setupTree(ctx, cfg, img, dir, ad, TreeStoreOverlay)
+	if err == nil || ctx.Err() != nil {
+		return err
+	}
+	// e.g. the filesystem of the container directory can't hold an upper
+	// layer
+	log.Printf("Unable to mount %s through an overlay, extracting it: %v", img, err)
+	if err := os.RemoveAll(rktpath.AppOverlayPath(dir, img)); err != nil {
+		return fmt.Errorf("error removing overlay directory: %v", err)
+	}
+	return renderImage(ctx, cfg, img, ad, nil)
+}
+
+// overlaySupported reports whether the kernel supports overlayfs
+func overlaySupported() bool {
+	f, err := os.Open("/proc/filesystems")
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) > 0 && fields[len(fields)-1] == "overlay" {
+			return true
+		}
+	}
+	return false
+}
+
+// setupTree renders img in the tree store, if it isn't there yet, and
+// makes it available in ad as mode says.
+func setupTree(ctx context.Context, cfg Config, img types.Hash, dir, ad string, mode TreeStoreMode) error {
+	l, err := useTree(cfg, dir, treeStoreID(cfg, img))
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+	if mode == TreeStoreZFS {
+		return setupTreeZFS(ctx, cfg, img, dir, ad)
+	}
+	tree, err := cfg.Store.RenderTree(treeStoreID(cfg, img), func(td string) error {
+		return renderImage(ctx, cfg, img, td, nil)
+	})
+	if err != nil {
+		return fmt.Errorf("error rendering image in tree store: %v", err)
+	}
+
+	switch mode {
+	case TreeStoreOverlay:
+		return mountOverlay(tree, ad, rktpath.AppOverlayPath(dir, img), cfg.selinuxLabels)
+	case TreeStoreHardlink:
+		if err := linkTree(tree, ad); err != nil {
+			return fmt.Errorf("error linking image from tree store: %v", err)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown tree store mode %q", mode)
+}
+
+// useTree records that the container in dir uses the tree identified by
+// id, so that rkt gc keeps it, before the tree is looked up. The returned
+// lock on the tree store is to be held until the tree is set up.
+func useTree(cfg Config, dir, id string) (*lock.DirLock, error) {
+	l, err := cfg.Store.LockTrees(false)
+	if err != nil {
+		return nil, fmt.Errorf("error locking tree store: %v", err)
+	}
+	td := rktpath.TreesPath(dir)
+	err = os.MkdirAll(td, 0755)
+	if err == nil {
+		err = ioutil.WriteFile(filepath.Join(td, id), nil, 0644)
+	}
+	if err != nil {
+		l.Close()
+		return nil, fmt.Errorf("error recording tree used: %v", err)
+	}
+	return l, nil
+}
+
+// setupTreeZFS renders img in a ZFS dataset of the tree store, if it isn't
+// there yet, and mounts a writable clone of its snapshot on ad. The clone
+// is destroyed with the container.
+func setupTreeZFS(ctx context.Context, cfg Config, img types.Hash, dir, ad string) error {
+	snap, err := cfg.Store.RenderTreeZFS(treeStoreID(cfg, img), func(td string) error {
+		return renderImage(ctx, cfg, img, td, nil)
+	})
+	if err != nil {
+		return fmt.Errorf("error rendering image in tree store: %v", err)
+	}
+	name := fmt.Sprintf("%s/rkt-pod-%s-%s", zfs.Parent(snap), filepath.Base(dir), img)
+	return zfs.Clone(snap, name, ad)
+}
+
+// mountOverlay mounts the rootfs of the rendered image in tree on the
+// rootfs of ad, with the writable layer in od, and copies its manifest.
+// The files of the overlay get the file context of labels, if not nil.
+func mountOverlay(tree, ad, od string, labels *selinux.Labels) error {
+	b, err := ioutil.ReadFile(filepath.Join(tree, aci.ManifestFile))
+	if err != nil {
+		return fmt.Errorf("error reading image manifest: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(ad, aci.ManifestFile), b, 0644); err != nil {
+		return fmt.Errorf("error writing image manifest: %v", err)
+	}
+
+	upper := filepath.Join(od, "upper")
+	work := filepath.Join(od, "work")
+	rootfs := filepath.Join(ad, aci.RootfsDir)
+	for _, d := range []string{upper, work, rootfs} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			return fmt.Errorf("error creating overlay directory: %v", err)
+		}
+	}
+	opts := fmt.Sprintf("lowerdir=%s,upperdir=%s,workdir=%s", filepath.Join(tree, aci.RootfsDir), upper, work)
+	if labels != nil {
+		opts += "," + labels.MountOption()
+	}
+	if err := syscall.Mount("overlay", rootfs, "overlay", 0, opts); err != nil {
+		return fmt.Errorf("error mounting overlay: %v", err)
+	}
+	return nil
+}
+
+// linkTree recreates the directories of src in dst, hard-linking all other
+// files.
+func linkTree(src, dst string) error {
+	return filepath.Walk(src, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		if !fi.IsDir() {
+			return os.Link(path, target)
+		}
+
+		if err := os.MkdirAll(target, fi.Mode().Perm()); err != nil {
+			return err
+		}
+		st := fi.Sys().(*syscall.Stat_t)
+		if err := os.Lchown(target, int(st.Uid), int(st.Gid)); err != nil {
+			return err
+		}
+		// MkdirAll is subject to the umask and ignores special bits
+		return os.Chmod(target, fi.Mode()&(os.ModePerm|os.ModeSetuid|os.ModeSetgid|os.ModeSticky))
+	})
+}
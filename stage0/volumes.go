@@ -0,0 +1,68 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//+build linux
+
+package stage0
+
+import (
+	"fmt"
+
+	"github.com/appc/spec/schema"
+	"github.com/appc/spec/schema/types"
+	"github.com/coreos/rocket/volume"
+)
+
+// setVolumeOptions records in the annotations of cm how stage1 sets up
+// the volumes of cm, as cfg.VolumeOptions or else the annotations of the
+// container say, once checked to apply to their volumes.
+func setVolumeOptions(cfg Config, cm *schema.ContainerRuntimeManifest) error {
+	opts := make(map[types.ACName]volume.Options)
+	if val, ok := cm.Annotations.Get(volume.OptionsAnnotation); ok {
+		var err error
+		if opts, err = volume.DecodeOptions(val); err != nil {
+			return fmt.Errorf("error: %v", err)
+		}
+	}
+	for n, o := range cfg.VolumeOptions {
+		opts[n] = o
+	}
+	if len(opts) == 0 {
+		return nil
+	}
+
+	kinds := make(map[types.ACName]string)
+	for _, v := range cm.Volumes {
+		for _, f := range v.Fulfills {
+			kinds[f] = v.Kind
+		}
+	}
+	for n, o := range opts {
+		kind, ok := kinds[n]
+		if !ok {
+			return fmt.Errorf("error: options given for unknown volume %q", n)
+		}
+		if err := o.Check(kind); err != nil {
+			return fmt.Errorf("error: volume %q: %v", n, err)
+		}
+	}
+	val, err := volume.EncodeOptions(opts)
+	if err != nil {
+		return err
+	}
+	cm.Annotations = mergeAnnotations(cm.Annotations, types.Annotations{
+		{Name: volume.OptionsAnnotation, Value: val},
+	})
+	return nil
+}
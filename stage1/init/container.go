@@ -24,12 +24,24 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
 
 	"github.com/appc/spec/schema"
 	"github.com/appc/spec/schema/types"
 	"github.com/coreos/rocket/Godeps/_workspace/src/github.com/coreos/go-systemd/unit"
+	"github.com/coreos/rocket/metadatasvc"
 	rktpath "github.com/coreos/rocket/path"
+	"github.com/coreos/rocket/pkg/apparmor"
+	"github.com/coreos/rocket/pkg/caps"
+	"github.com/coreos/rocket/pkg/cgroup"
+	"github.com/coreos/rocket/pkg/coredump"
+	"github.com/coreos/rocket/pkg/harden"
+	"github.com/coreos/rocket/pkg/mounts"
+	"github.com/coreos/rocket/pkg/rootfs"
+	"github.com/coreos/rocket/pkg/seccomp"
+	"github.com/coreos/rocket/pkg/selinux"
+	"github.com/coreos/rocket/volume"
 )
 
 // Container encapsulates a ContainerRuntimeManifest and ImageManifests
@@ -37,6 +49,16 @@ type Container struct {
 	Root     string // root directory where the container will be located
 	Manifest *schema.ContainerRuntimeManifest
 	Apps     map[string]*schema.ImageManifest
+	// Flavor is the flavor of the stage1 rootfs, empty for the default
+	// systemd-nspawn one
+	Flavor string
+	// CoreDumps is set when the core dumps of the apps are captured
+	CoreDumps bool
+	// SELinux, if set, are the SELinux labels of the container
+	SELinux *selinux.Labels
+	// VolumeOptions are how the volumes are set up, by name, for those
+	// not set up by default
+	VolumeOptions map[types.ACName]volume.Options
 }
 
 // LoadContainer loads a Container Runtime Manifest (as prepared by stage0) and
@@ -58,6 +80,23 @@ func LoadContainer(root string) (*Container, error) {
 	}
 	c.Manifest = cm
 
+	if c.Flavor, err = readFlavor(c.Root); err != nil {
+		return nil, err
+	}
+	cd, err := coredump.Load(c.Root)
+	if err != nil {
+		return nil, err
+	}
+	c.CoreDumps = cd != nil
+	if c.SELinux, err = selinux.Load(c.Root); err != nil {
+		return nil, err
+	}
+	if val, ok := cm.Annotations.Get(volume.OptionsAnnotation); ok {
+		if c.VolumeOptions, err = volume.DecodeOptions(val); err != nil {
+			return nil, err
+		}
+	}
+
 	for _, app := range c.Manifest.Apps {
 		ampath := rktpath.ImageManifestPath(c.Root, app.ImageID)
 		buf, err := ioutil.ReadFile(ampath)
@@ -73,12 +112,36 @@ func LoadContainer(root string) (*Container, error) {
 		if _, ok := c.Apps[name]; ok {
 			return nil, fmt.Errorf("got multiple definitions for app: %s", name)
 		}
+		if val, ok := app.Annotations.Get(mounts.Annotation); ok && am.App != nil {
+			added, err := mounts.Decode(val)
+			if err != nil {
+				return nil, fmt.Errorf("failed reading mount points of app %s: %v", name, err)
+			}
+			am.App.MountPoints = mounts.Merge(am.App.MountPoints, added)
+		}
 		c.Apps[name] = am
 	}
 
 	return c, nil
 }
 
+// readFlavor returns the flavor of the stage1 rootfs of the container in
+// root, empty for the default systemd-nspawn one.
+func readFlavor(root string) (string, error) {
+	b, err := ioutil.ReadFile(rktpath.Stage1FlavorPath(root))
+	if os.IsNotExist(err) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	f := strings.TrimSpace(string(b))
+	switch f {
+	case "", flavorKVM, flavorFly:
+		return f, nil
+	}
+	return "", fmt.Errorf("unsupported stage1 flavor %q", f)
+}
+
 // quoteExec returns an array of quoted strings appropriate for systemd execStart usage
 func quoteExec(exec []string) string {
 	if len(exec) == 0 {
@@ -107,8 +170,10 @@ func newUnitOption(section, name, value string) *unit.UnitOption {
 	return &unit.UnitOption{Section: section, Name: name, Value: value}
 }
 
-// appToSystemd transforms the provided app manifest into systemd units
-func (c *Container) appToSystemd(am *schema.ImageManifest, id types.Hash) error {
+// appToSystemd transforms the provided app manifest into systemd units,
+// applying the given isolators and annotations of the app in the container
+// manifest
+func (c *Container) appToSystemd(am *schema.ImageManifest, id types.Hash, isolators types.Isolators, annotations types.Annotations) error {
 	name := am.Name.String()
 	app := am.App
 
@@ -126,10 +191,23 @@ func (c *Container) appToSystemd(am *schema.ImageManifest, id types.Hash) error
 		newUnitOption("Unit", "OnFailure", "reaper.service"),
 		newUnitOption("Unit", "Wants", "exit-watcher.service"),
 		newUnitOption("Service", "Restart", "no"),
+		// reports the app ready per the status protocol of pkg/pod, from
+		// a unit of its own running as root, the app may not be able to
+		// write to the status dir
+		newUnitOption("Unit", "Wants", StartedUnitName(id)),
 		newUnitOption("Service", "ExecStart", execStart),
 		newUnitOption("Service", "User", app.User),
 		newUnitOption("Service", "Group", app.Group),
 	}
+	if c.hasJournal() {
+		// kept in the journal of the container for rkt logs, and still
+		// printed on its console
+		opts = append(opts,
+			newUnitOption("Unit", "After", journaldSocket),
+			newUnitOption("Service", "StandardOutput", "journal+console"),
+			newUnitOption("Service", "SyslogIdentifier", name),
+		)
+	}
 
 	for _, eh := range app.EventHandlers {
 		var typ string
@@ -145,8 +223,128 @@ func (c *Container) appToSystemd(am *schema.ImageManifest, id types.Hash) error
 		opts = append(opts, newUnitOption("Service", typ, exec))
 	}
 
+	// later isolators of the same name override earlier ones, as later
+	// settings do in units. The capability sets restrict the same bounding
+	// set, so the later one applies, and so do the seccomp sets. Apps
+	// declaring no seccomp set get the default profile.
+	var capsOpt *unit.UnitOption
+	filter := seccomp.Profiles[seccomp.ProfileDefault]
+	readOnly := false
+	for _, i := range isolators {
+		switch i.Name {
+		case cgroup.MemoryIsolator:
+			limit, err := cgroup.ParseMemory(i.Val)
+			if err != nil {
+				return err
+			}
+			opts = append(opts, newUnitOption("Service", "MemoryLimit", strconv.FormatUint(limit, 10)))
+		case cgroup.CPUIsolator:
+			shares, err := cgroup.ParseCPUShares(i.Val)
+			if err != nil {
+				return err
+			}
+			opts = append(opts, newUnitOption("Service", "CPUShares", strconv.FormatUint(shares, 10)))
+		case caps.RetainSetIsolator:
+			set, err := caps.Parse(i.Val)
+			if err != nil {
+				return err
+			}
+			val := strings.Join(set, " ")
+			if len(set) == 0 {
+				// an empty setting doesn't restrict anything, all the
+				// capabilities are removed instead
+				val = "~" + strings.Join(caps.Names, " ")
+			}
+			capsOpt = newUnitOption("Service", "CapabilityBoundingSet", val)
+		case caps.RemoveSetIsolator:
+			set, err := caps.Parse(i.Val)
+			if err != nil {
+				return err
+			}
+			capsOpt = nil
+			if len(set) > 0 {
+				capsOpt = newUnitOption("Service", "CapabilityBoundingSet", "~"+strings.Join(set, " "))
+			}
+		case seccomp.RetainSetIsolator, seccomp.RemoveSetIsolator:
+			f, err := seccomp.Parse(string(i.Name), i.Val)
+			if err != nil {
+				return err
+			}
+			filter = f
+		case rootfs.ReadOnlyIsolator:
+			ro, err := rootfs.ParseReadOnly(i.Val)
+			if err != nil {
+				return err
+			}
+			readOnly = ro
+		}
+	}
+	if capsOpt != nil {
+		opts = append(opts, capsOpt)
+	}
+	if len(filter.Syscalls) > 0 {
+		val := strings.Join(filter.Syscalls, " ")
+		if !filter.Retain {
+			val = "~" + val
+		}
+		opts = append(opts, newUnitOption("Service", "SystemCallFilter", val))
+		opts = append(opts, newUnitOption("Service", "SystemCallErrorNumber", filter.Errno))
+	}
+	// stage0 checked the profile is loaded, in the kernel of the host
+	// rather than the one of a VM
+	if profile, ok := annotations.Get(apparmor.ProfileAnnotation); ok && profile != apparmor.Unconfined && c.Flavor != flavorKVM {
+		opts = append(opts, newUnitOption("Service", "AppArmorProfile", profile))
+	}
+	// the rootfs is only read-only in the mount namespace of the service,
+	// the volumes and the tmpfs mounted on TmpDir beneath it by stage1
+	// staying writable
+	if readOnly {
+		root := rktpath.RelAppRootfsPath(id)
+		if rootfs.NeedsTmpfs(app.MountPoints) {
+			tmp := filepath.Join(root, rootfs.TmpDir)
+			if err := c.writeTmpfsMount(tmp, volume.Options{}.TmpfsOptions()); err != nil {
+				return fmt.Errorf("failed to write mount unit for %s: %v", rootfs.TmpDir, err)
+			}
+			opts = append(opts, newUnitOption("Unit", "RequiresMountsFor", tmp))
+		}
+		opts = append(opts, newUnitOption("Service", "ReadOnlyDirectories", root))
+	}
+	// tmpfs volumes are mounted by systemd, the other volumes by
+	// systemd-nspawn or over 9p
+	vols, err := c.volumes()
+	if err != nil {
+		return err
+	}
+	for _, mp := range app.MountPoints {
+		if vol, ok := vols[mp.Name]; ok && vol.Kind == volume.KindTmpfs {
+			where := filepath.Join(rktpath.RelAppRootfsPath(id), mp.Path)
+			if err := c.writeTmpfsVolume(mp.Name, where, mp.ReadOnly || vol.ReadOnly); err != nil {
+				return fmt.Errorf("failed to write mount unit for mountpoint %q: %v", mp.Name, err)
+			}
+			opts = append(opts, newUnitOption("Unit", "RequiresMountsFor", where))
+		}
+	}
+	// hardened apps only need paths masked with the fly flavor, they
+	// don't see the /proc and /sys of the host otherwise
+	if val, ok := annotations.Get(harden.Annotation); ok {
+		h, err := harden.Parse(val)
+		if err != nil {
+			return err
+		}
+		if h {
+			opts = append(opts, newUnitOption("Service", "NoNewPrivileges", "yes"))
+		}
+	}
+	// "rkt coredump" handles the core dumps, and caps their size
+	if c.CoreDumps {
+		opts = append(opts, newUnitOption("Service", "LimitCORE", "infinity"))
+	}
+
 	env := app.Environment
 	env["AC_APP_NAME"] = name
+	if mdsRegister {
+		env["AC_METADATA_URL"] = metadatasvc.URL
+	}
 	for ek, ev := range env {
 		ee := fmt.Sprintf(`"%s=%s"`, ek, ev)
 		opts = append(opts, newUnitOption("Service", "Environment", ee))
@@ -214,8 +412,62 @@ func (c *Container) appToSystemd(am *schema.ImageManifest, id types.Hash) error
 	return nil
 }
 
+// writeTmpfsMount writes the unit mounting a tmpfs on where with the mount
+// options opts, e.g. writable by all like /tmp with mode=1777
+func (c *Container) writeTmpfsMount(where, opts string) error {
+	opts += ",nosuid,nodev"
+	if c.SELinux != nil {
+		opts += "," + c.SELinux.MountOption()
+	}
+	return writeUnit(filepath.Join(c.Root, unitsDir, MountUnitName(where)), []*unit.UnitOption{
+		newUnitOption("Unit", "DefaultDependencies", "false"),
+		newUnitOption("Mount", "What", "tmpfs"),
+		newUnitOption("Mount", "Where", where),
+		newUnitOption("Mount", "Type", "tmpfs"),
+		newUnitOption("Mount", "Options", opts),
+	})
+}
+
+// writeTmpfsVolume writes the units mounting the tmpfs volume fulfilling
+// name on where, read-only if readOnly: a tmpfs of its own with
+// volume.ScopeApp, otherwise a bind of the tmpfs the apps share, mounted
+// once in stage1.
+func (c *Container) writeTmpfsVolume(name types.ACName, where string, readOnly bool) error {
+	o := c.VolumeOptions[name]
+	if o.Scope == volume.ScopeApp {
+		opts := o.TmpfsOptions()
+		if readOnly {
+			opts += ",ro"
+		}
+		return c.writeTmpfsMount(where, opts)
+	}
+
+	shared := TmpfsVolumePath(name)
+	if err := c.writeTmpfsMount(shared, o.TmpfsOptions()); err != nil {
+		return err
+	}
+	opts := "bind"
+	if readOnly {
+		opts += ",ro"
+	}
+	return writeUnit(filepath.Join(c.Root, unitsDir, MountUnitName(where)), []*unit.UnitOption{
+		newUnitOption("Unit", "DefaultDependencies", "false"),
+		newUnitOption("Unit", "RequiresMountsFor", shared),
+		newUnitOption("Mount", "What", shared),
+		newUnitOption("Mount", "Where", where),
+		newUnitOption("Mount", "Options", opts),
+	})
+}
+
 // ContainerToSystemd creates the appropriate systemd service unit files for
 // all the constituent apps of the Container
+// hasJournal reports whether the stage1 rootfs of c runs journald, which
+// custom stage1 images may not.
+func (c *Container) hasJournal() bool {
+	_, err := os.Stat(filepath.Join(c.Root, unitsDir, journaldSocket))
+	return err == nil
+}
+
 func (c *Container) ContainerToSystemd() error {
 	for _, am := range c.Apps {
 		a := c.Manifest.Apps.Get(am.Name)
@@ -223,7 +475,7 @@ func (c *Container) ContainerToSystemd() error {
 			// should never happen
 			panic("app not found in container manifest")
 		}
-		if err := c.appToSystemd(am, a.ImageID); err != nil {
+		if err := c.appToSystemd(am, a.ImageID, a.Isolators, a.Annotations); err != nil {
 			return fmt.Errorf("failed to transform app %q into systemd service: %v", am.Name, err)
 		}
 	}
@@ -231,17 +483,38 @@ func (c *Container) ContainerToSystemd() error {
 	return nil
 }
 
+// volumes returns the volumes of the container by the names they fulfill.
+// Empty volumes get the directory created for them as their source, tmpfs
+// volumes have none.
+func (c *Container) volumes() (map[types.ACName]types.Volume, error) {
+	vols := make(map[types.ACName]types.Volume)
+	for _, v := range c.Manifest.Volumes {
+		if v.Kind == "empty" && len(v.Fulfills) > 0 {
+			dir, err := filepath.Abs(rktpath.EmptyVolumePath(c.Root, v.Fulfills[0]))
+			if err == nil {
+				err = os.MkdirAll(dir, 0755)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("failed to create empty volume %q: %v", v.Fulfills[0], err)
+			}
+			v.Source = dir
+		}
+		for _, f := range v.Fulfills {
+			vols[f] = v
+		}
+	}
+	return vols, nil
+}
+
 // appToNspawnArgs transforms the given app manifest, with the given associated
 // app image id, into a subset of applicable systemd-nspawn argument
 func (c *Container) appToNspawnArgs(am *schema.ImageManifest, id types.Hash) ([]string, error) {
 	args := []string{}
 	name := am.Name.String()
 
-	vols := make(map[types.ACName]types.Volume)
-	for _, v := range c.Manifest.Volumes {
-		for _, f := range v.Fulfills {
-			vols[f] = v
-		}
+	vols, err := c.volumes()
+	if err != nil {
+		return nil, err
 	}
 
 	for _, mp := range am.App.MountPoints {
@@ -250,9 +523,13 @@ func (c *Container) appToNspawnArgs(am *schema.ImageManifest, id types.Hash) ([]
 		if !ok {
 			return nil, fmt.Errorf("no volume for mountpoint %q in app %q", key, name)
 		}
+		// mounted by systemd in stage1, or device nodes created there
+		if vol.Kind == volume.KindTmpfs || isDeviceVolume(vol) {
+			continue
+		}
 		opt := make([]string, 4)
 
-		if mp.ReadOnly {
+		if mp.ReadOnly || vol.ReadOnly {
 			opt[0] = "--bind-ro="
 		} else {
 			opt[0] = "--bind="
@@ -15,7 +15,16 @@
 package main
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+
+	"github.com/appc/spec/schema"
+	"github.com/appc/spec/schema/types"
+	"github.com/coreos/rocket/Godeps/_workspace/src/github.com/coreos/go-systemd/unit"
+	rktpath "github.com/coreos/rocket/path"
 )
 
 func TestQuoteExec(t *testing.T) {
@@ -45,3 +54,87 @@ func TestQuoteExec(t *testing.T) {
 		}
 	}
 }
+
+func TestReadFlavor(t *testing.T) {
+	dir, err := ioutil.TempDir("", "flavor")
+	if err != nil {
+		t.Fatalf("error creating tempdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.MkdirAll(rktpath.Stage1RootfsPath(dir), 0755); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if f, err := readFlavor(dir); err != nil || f != "" {
+		t.Errorf("got flavor %q, %v, want the default one", f, err)
+	}
+	fp := rktpath.Stage1FlavorPath(dir)
+	if err := ioutil.WriteFile(fp, []byte("kvm\n"), 0644); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if f, err := readFlavor(dir); err != nil || f != flavorKVM {
+		t.Errorf("got flavor %q, %v, want %q", f, err, flavorKVM)
+	}
+	if err := ioutil.WriteFile(fp, []byte("xen\n"), 0644); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := readFlavor(dir); err == nil {
+		t.Errorf("expected an error for an unknown flavor")
+	}
+}
+
+func TestAppToSystemdNonRoot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "units")
+	if err != nil {
+		t.Fatalf("error creating tempdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.MkdirAll(filepath.Join(dir, defaultWantsDir), 0755); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	c := &Container{
+		Root:     dir,
+		Manifest: &schema.ContainerRuntimeManifest{},
+	}
+	id, err := types.NewHash("sha512-" + strings.Repeat("a", 128))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	am := &schema.ImageManifest{
+		Name: "example.com/app",
+		App: &types.App{
+			Exec:        []string{"/bin/app"},
+			User:        "1000",
+			Group:       "1000",
+			Environment: types.Environment{},
+		},
+	}
+	if err := c.appToSystemd(am, *id, nil, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	f, err := os.Open(ServiceUnitPath(dir, *id))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer f.Close()
+	opts, err := unit.Deserialize(f)
+	if err != nil {
+		t.Fatalf("error reading unit: %v", err)
+	}
+	wants := false
+	for _, o := range opts {
+		switch {
+		case o.Name == "ExecStartPost":
+			// runs with the User of the app, which may not be allowed
+			// to write to the status dir
+			t.Errorf("unexpected %s=%s", o.Name, o.Value)
+		case o.Name == "Wants" && o.Value == StartedUnitName(*id):
+			wants = true
+		case o.Name == "User" && o.Value != "1000":
+			t.Errorf("got User=%s, want 1000", o.Value)
+		}
+	}
+	if !wants {
+		t.Errorf("unit doesn't want %s", StartedUnitName(*id))
+	}
+}
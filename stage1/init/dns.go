@@ -0,0 +1,170 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//+build linux
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/appc/spec/schema/types"
+	"github.com/coreos/rocket/networking/util"
+	rktpath "github.com/coreos/rocket/path"
+)
+
+// hostResolvConf is the resolv.conf of the host, whose settings containers
+// sharing the network of the host use
+const hostResolvConf = "/etc/resolv.conf"
+
+// stringList implements the flag.Value interface for flags which may be
+// given more than once.
+type stringList []string
+
+func (l *stringList) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+// podHostname returns the hostname of the container with the given UUID
+func podHostname(uuid types.UUID) string {
+	return "rkt-" + uuid.String()
+}
+
+// composeDNS returns the resolver settings of the container. Those given
+// by the user, if any, replace the ones of the networks, setting by
+// setting: e.g. giving only search domains keeps the nameservers of the
+// networks.
+func composeDNS(netDNS util.DNS, servers, search, opts []string) util.DNS {
+	d := netDNS
+	if len(servers) > 0 {
+		d.Nameservers = servers
+	}
+	if len(search) > 0 {
+		d.Domain = ""
+		d.Search = search
+	}
+	if len(opts) > 0 {
+		d.Options = opts
+	}
+	return d
+}
+
+// hostDNS returns the resolver settings of the host
+func hostDNS() (util.DNS, error) {
+	b, err := ioutil.ReadFile(hostResolvConf)
+	if os.IsNotExist(err) {
+		return util.DNS{}, nil
+	} else if err != nil {
+		return util.DNS{}, err
+	}
+	d, err := util.ParseDNS(string(b))
+	if err != nil {
+		return util.DNS{}, fmt.Errorf("error parsing %s: %v", hostResolvConf, err)
+	}
+	return *d, nil
+}
+
+// hostsFile renders the hosts file of the container, mapping hostname to
+// ip, or to a loopback address if the container has no private network.
+func hostsFile(hostname string, ip net.IP) string {
+	s := "127.0.0.1\tlocalhost\n::1\tlocalhost ip6-localhost ip6-loopback\n"
+	if ip == nil {
+		ip = net.IPv4(127, 0, 1, 1)
+	}
+	return s + fmt.Sprintf("%s\t%s\n", ip, hostname)
+}
+
+// writeEtcFiles writes the resolv.conf and hosts file of the container,
+// bind-mounted into the apps as etcNspawnArgs says, or copied into them
+// for a VM.
+func (c *Container) writeEtcFiles(dns util.DNS, ip net.IP) error {
+	rc := "# generated by rkt\n" + dns.String()
+	if err := ioutil.WriteFile(ResolvConfPath(c.Root), []byte(rc), 0644); err != nil {
+		return fmt.Errorf("error writing resolv.conf: %v", err)
+	}
+	hosts := hostsFile(podHostname(c.Manifest.UUID), ip)
+	if err := ioutil.WriteFile(HostsPath(c.Root), []byte(hosts), 0644); err != nil {
+		return fmt.Errorf("error writing hosts file: %v", err)
+	}
+	if c.Flavor == flavorKVM {
+		return c.copyEtcFiles()
+	}
+	return nil
+}
+
+// etcNspawnArgs returns the systemd-nspawn arguments naming the container
+// after its hostname and bind-mounting its resolv.conf and hosts file,
+// read-only, into every app.
+func (c *Container) etcNspawnArgs() ([]string, error) {
+	root, err := filepath.Abs(c.Root)
+	if err != nil {
+		return nil, err
+	}
+	files := [][2]string{
+		{ResolvConfPath(root), "etc/resolv.conf"},
+		{HostsPath(root), "etc/hosts"},
+	}
+
+	args := []string{"--machine=" + podHostname(c.Manifest.UUID)}
+	for _, app := range c.Manifest.Apps {
+		for _, f := range files {
+			src, dst := f[0], f[1]
+			if err := ensureMountTarget(filepath.Join(rktpath.AppRootfsPath(c.Root, app.ImageID), dst)); err != nil {
+				// e.g. the rootfs is a read-only verity image, the
+				// app keeps its own file
+				fmt.Fprintf(os.Stderr, "Unable to mount /%s in app %s: %v\n", dst, app.ImageID, err)
+				continue
+			}
+			args = append(args, "--bind-ro="+src+":"+filepath.Join(rktpath.RelAppRootfsPath(app.ImageID), dst))
+		}
+	}
+	return args, nil
+}
+
+// ensureMountTarget makes sure p is a regular file a file can be mounted
+// on. A symlink is replaced, so that the mount doesn't follow it out of the
+// app's rootfs.
+func ensureMountTarget(p string) error {
+	fi, err := os.Lstat(p)
+	switch {
+	case err == nil && fi.Mode().IsRegular():
+		return nil
+	case err == nil && fi.Mode()&os.ModeSymlink != 0:
+		if err := os.Remove(p); err != nil {
+			return err
+		}
+	case err == nil:
+		return fmt.Errorf("%s is not a regular file", p)
+	case !os.IsNotExist(err):
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(p, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
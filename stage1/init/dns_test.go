@@ -0,0 +1,110 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//+build linux
+
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/coreos/rocket/networking/util"
+)
+
+func TestComposeDNS(t *testing.T) {
+	netDNS := util.DNS{
+		Nameservers: []string{"10.1.0.1"},
+		Domain:      "backend.local",
+		Search:      []string{"backend.local"},
+		Options:     []string{"ndots:2"},
+	}
+	tests := []struct {
+		servers, search, opts []string
+		out                   util.DNS
+	}{
+		{nil, nil, nil, netDNS},
+		{
+			[]string{"8.8.8.8"}, nil, nil,
+			util.DNS{
+				Nameservers: []string{"8.8.8.8"},
+				Domain:      "backend.local",
+				Search:      []string{"backend.local"},
+				Options:     []string{"ndots:2"},
+			},
+		},
+		{
+			nil, []string{"example.com"}, []string{"rotate"},
+			util.DNS{
+				Nameservers: []string{"10.1.0.1"},
+				Search:      []string{"example.com"},
+				Options:     []string{"rotate"},
+			},
+		},
+	}
+
+	for i, tt := range tests {
+		d := composeDNS(netDNS, tt.servers, tt.search, tt.opts)
+		if !reflect.DeepEqual(d, tt.out) {
+			t.Errorf("#%d: expected %#v, got %#v", i, tt.out, d)
+		}
+	}
+}
+
+func TestHostsFile(t *testing.T) {
+	base := "127.0.0.1\tlocalhost\n::1\tlocalhost ip6-localhost ip6-loopback\n"
+	if h := hostsFile("rkt-a", net.ParseIP("10.1.0.5")); h != base+"10.1.0.5\trkt-a\n" {
+		t.Errorf("unexpected hosts file %q", h)
+	}
+	if h := hostsFile("rkt-a", nil); h != base+"127.0.1.1\trkt-a\n" {
+		t.Errorf("unexpected hosts file %q", h)
+	}
+}
+
+func TestEnsureMountTarget(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rkt-dns")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// missing, with its directory
+	p := filepath.Join(dir, "etc", "resolv.conf")
+	if err := ensureMountTarget(p); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if fi, err := os.Lstat(p); err != nil || !fi.Mode().IsRegular() {
+		t.Errorf("expected a regular file at %s", p)
+	}
+
+	// a symlink pointing out of the rootfs is replaced
+	h := filepath.Join(dir, "etc", "hosts")
+	if err := os.Symlink("/etc/hosts", h); err != nil {
+		t.Fatal(err)
+	}
+	if err := ensureMountTarget(h); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if fi, err := os.Lstat(h); err != nil || !fi.Mode().IsRegular() {
+		t.Errorf("expected a regular file at %s", h)
+	}
+
+	if err := ensureMountTarget(filepath.Join(dir, "etc")); err == nil {
+		t.Errorf("expected an error for a directory")
+	}
+}
@@ -0,0 +1,394 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//+build linux
+
+package main
+
+// this implements the fly flavor of stage1, running the app chrooted in
+// its rootfs without any isolation
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/appc/spec/schema"
+	"github.com/appc/spec/schema/types"
+	"github.com/coreos/rocket/networking"
+	rktpath "github.com/coreos/rocket/path"
+	"github.com/coreos/rocket/pkg/apparmor"
+	"github.com/coreos/rocket/pkg/cgroup"
+	"github.com/coreos/rocket/pkg/harden"
+	"github.com/coreos/rocket/pkg/pod"
+	"github.com/coreos/rocket/pkg/rootfs"
+	"github.com/coreos/rocket/pkg/trace"
+	"github.com/coreos/rocket/volume"
+)
+
+const (
+	// flavorFly runs the app of the container chrooted in its rootfs,
+	// sharing everything else with the host
+	flavorFly = "fly"
+
+	// statusDir holds the exit status of the apps, relative to the
+	// stage1 rootfs
+	statusDir = "rkt/status"
+	// defaultPath is the PATH of apps whose manifest doesn't set one
+	defaultPath = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+)
+
+// flyMount is a bind mount into the rootfs of the app, or a tmpfs mounted
+// with the options tmpfs if set
+type flyMount struct {
+	src, dst string
+	readOnly bool
+	tmpfs    string
+}
+
+// runFly runs the single app of the container c chrooted in its rootfs
+// with the volumes and the /proc, /sys and /dev of the host bind-mounted,
+// in the namespaces of the host but for a mount namespace, so that the
+// mounts go away with it, into which the mounts of the host propagate. It
+// returns the exit status of the app. The trace tr, if not nil, is written
+// before the app starts.
+func runFly(c *Container, tr *trace.Span) int {
+	if privNet.Enabled() {
+		fmt.Fprintf(os.Stderr, "The fly stage1 shares the network of the host, it can't set up a private one\n")
+		return 4
+	}
+	if len(c.Manifest.Apps) != 1 {
+		fmt.Fprintf(os.Stderr, "The fly stage1 runs a single app, got %d\n", len(c.Manifest.Apps))
+		return 4
+	}
+	ra := c.Manifest.Apps[0]
+	am := c.Apps[ra.Name.String()]
+
+	if err := networking.SaveHostNetInfo(c.Root); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to save network info: %v\n", err)
+		return 6
+	}
+	dns, err := hostDNS()
+	if err == nil {
+		err = c.writeEtcFiles(composeDNS(dns, dnsServers, dnsSearch, dnsOpts), nil)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to configure DNS: %v\n", err)
+		return 8
+	}
+
+	var readOnly, hardened bool
+	mounts, err := c.flyMounts(am, ra.ImageID)
+	if err == nil {
+		readOnly, err = rootfs.ReadOnly(ra.Isolators)
+	}
+	if val, ok := ra.Annotations.Get(harden.Annotation); ok && err == nil {
+		hardened, err = harden.Parse(val)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to generate mounts: %v\n", err)
+		return 4
+	}
+	tmpfs := readOnly && rootfs.NeedsTmpfs(am.App.MountPoints)
+	if err := setupFlyMounts(rktpath.AppRootfsPath(c.Root, ra.ImageID), mounts, tmpfs, readOnly); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to set up mounts: %v\n", err)
+		return 9
+	}
+	if hardened {
+		if err := harden.Mask(rktpath.AppRootfsPath(c.Root, ra.ImageID)); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to mask paths: %v\n", err)
+			return 9
+		}
+	}
+
+	if err := cgroup.JoinFreezer(c.Manifest.UUID.String(), os.Getpid()); err != nil && debug {
+		fmt.Fprintf(os.Stderr, "Unable to join freezer cgroup, the container can't be paused: %v\n", err)
+	}
+
+	profile, _ := ra.Annotations.Get(apparmor.ProfileAnnotation)
+	writeTrace(tr)
+	reportPhase(c, pod.PhaseRunning)
+	status, err := c.runFlyApp(am, ra.ImageID, profile, hardened)
+	reportPhase(c, pod.PhaseExited)
+	recordExit(c)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to execute app %s: %v\n", am.Name, err)
+		return 5
+	}
+	return status
+}
+
+// flyMounts returns the bind mounts into the rootfs of the app am
+func (c *Container) flyMounts(am *schema.ImageManifest, id types.Hash) ([]flyMount, error) {
+	mounts := []flyMount{
+		{src: "/proc", dst: "/proc"},
+		{src: "/sys", dst: "/sys"},
+		{src: "/dev", dst: "/dev"},
+		{src: ResolvConfPath(c.Root), dst: "/etc/resolv.conf", readOnly: true},
+		{src: "/etc/hosts", dst: "/etc/hosts", readOnly: true},
+	}
+
+	vols, err := c.volumes()
+	if err != nil {
+		return nil, err
+	}
+	for _, mp := range am.App.MountPoints {
+		vol, ok := vols[mp.Name]
+		if !ok {
+			return nil, fmt.Errorf("no volume for mountpoint %q in app %q", mp.Name, am.Name)
+		}
+		m := flyMount{src: vol.Source, dst: mp.Path, readOnly: mp.ReadOnly || vol.ReadOnly}
+		if vol.Kind == volume.KindTmpfs {
+			m.tmpfs = c.VolumeOptions[mp.Name].TmpfsOptions()
+		}
+		mounts = append(mounts, m)
+	}
+	return mounts, nil
+}
+
+// setupFlyMounts makes the mounts into root, in a new mount namespace,
+// and a tmpfs on TmpDir if tmpfs is set. With readOnly, root is then made
+// read-only, but for the mounts.
+func setupFlyMounts(root string, mounts []flyMount, tmpfs, readOnly bool) error {
+	if err := syscall.Unshare(syscall.CLONE_NEWNS); err != nil {
+		return fmt.Errorf("error creating mount namespace: %v", err)
+	}
+	if err := syscall.Mount("", "/", "", syscall.MS_REC|syscall.MS_SLAVE, ""); err != nil {
+		return fmt.Errorf("error making mounts slaves: %v", err)
+	}
+
+	for _, m := range mounts {
+		dst := filepath.Join(root, m.dst)
+		if m.tmpfs != "" {
+			if err := mountFlyTmpfs(dst, m.tmpfs, m.readOnly); err != nil {
+				return fmt.Errorf("error mounting tmpfs on %s: %v", m.dst, err)
+			}
+			continue
+		}
+		fi, err := os.Stat(m.src)
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() {
+			err = os.MkdirAll(dst, 0755)
+		} else {
+			err = ensureMountTarget(dst)
+		}
+		if err != nil {
+			return fmt.Errorf("error creating mount target %s: %v", m.dst, err)
+		}
+		if err := syscall.Mount(m.src, dst, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+			return fmt.Errorf("error mounting %s on %s: %v", m.src, m.dst, err)
+		}
+		if !m.readOnly {
+			continue
+		}
+		if err := syscall.Mount("", dst, "", syscall.MS_BIND|syscall.MS_REMOUNT|syscall.MS_RDONLY, ""); err != nil {
+			return fmt.Errorf("error making %s read-only: %v", m.dst, err)
+		}
+	}
+
+	if tmpfs {
+		dst := filepath.Join(root, rootfs.TmpDir)
+		if err := os.MkdirAll(dst, 0755); err != nil {
+			return fmt.Errorf("error creating mount target %s: %v", rootfs.TmpDir, err)
+		}
+		if err := syscall.Mount("tmpfs", dst, "tmpfs", syscall.MS_NOSUID|syscall.MS_NODEV, "mode=1777"); err != nil {
+			return fmt.Errorf("error mounting tmpfs on %s: %v", rootfs.TmpDir, err)
+		}
+	}
+	if !readOnly {
+		return nil
+	}
+	// only the bind of root on itself is read-only, not the mounts it
+	// carries along
+	if err := syscall.Mount(root, root, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+		return fmt.Errorf("error binding rootfs: %v", err)
+	}
+	if err := syscall.Mount("", root, "", syscall.MS_BIND|syscall.MS_REMOUNT|syscall.MS_RDONLY, ""); err != nil {
+		return fmt.Errorf("error making rootfs read-only: %v", err)
+	}
+	return nil
+}
+
+// mountFlyTmpfs mounts a tmpfs with the mount options opts on dst,
+// read-only if readOnly
+func mountFlyTmpfs(dst, opts string, readOnly bool) error {
+	if err := os.MkdirAll(dst, 0755); err != nil {
+		return err
+	}
+	flags := uintptr(syscall.MS_NOSUID | syscall.MS_NODEV)
+	if readOnly {
+		flags |= syscall.MS_RDONLY
+	}
+	return syscall.Mount("tmpfs", dst, "tmpfs", flags, opts)
+}
+
+// runFlyApp runs the event handlers and the app am of image id, confined
+// by the AppArmor profile if set and kept from gaining privileges if
+// hardened, forwarding it the signals ending rkt, and records its exit
+// status, which it returns.
+func (c *Container) runFlyApp(am *schema.ImageManifest, id types.Hash, profile string, hardened bool) (int, error) {
+	rootfs := rktpath.AppRootfsPath(c.Root, id)
+	app := am.App
+	cred, err := appCredential(rootfs, app.User, app.Group)
+	if err != nil {
+		return 0, err
+	}
+	workDir := "/"
+	if app.WorkingDirectory != "" {
+		workDir = app.WorkingDirectory
+	}
+	env := []string{"AC_APP_NAME=" + am.Name.String()}
+	if _, ok := app.Environment["PATH"]; !ok {
+		env = append(env, "PATH="+defaultPath)
+	}
+	for k, v := range app.Environment {
+		env = append(env, k+"="+v)
+	}
+	command := func(args []string) *exec.Cmd {
+		return &exec.Cmd{
+			Path:   args[0],
+			Args:   args,
+			Env:    env,
+			Dir:    workDir,
+			Stdin:  os.Stdin,
+			Stdout: os.Stdout,
+			Stderr: os.Stderr,
+			SysProcAttr: &syscall.SysProcAttr{
+				Chroot:     rootfs,
+				Credential: cred,
+			},
+		}
+	}
+	handlers := make(map[string][]string)
+	for _, eh := range app.EventHandlers {
+		handlers[eh.Name] = eh.Exec
+	}
+
+	if h, ok := handlers["pre-start"]; ok {
+		if err := command(h).Run(); err != nil {
+			return 0, fmt.Errorf("pre-start event handler failed: %v", err)
+		}
+	}
+
+	// the thread is locked, it forks the app
+	if profile != "" && profile != apparmor.Unconfined {
+		if err := apparmor.ChangeOnExec(profile); err != nil {
+			return 0, err
+		}
+	}
+	if hardened {
+		if err := harden.SetNoNewPrivs(); err != nil {
+			return 0, err
+		}
+	}
+	cmd := command(app.Exec)
+	if err := cmd.Start(); err != nil {
+		return 0, err
+	}
+	if err := pod.WriteAppPID(c.Root, id, cmd.Process.Pid); err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to record pid: %v\n", err)
+	}
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	go func() {
+		for s := range sigs {
+			cmd.Process.Signal(s)
+		}
+	}()
+	cmd.Wait()
+	signal.Stop(sigs)
+	status := exitStatus(cmd.ProcessState)
+
+	if h, ok := handlers["post-stop"]; ok {
+		if err := command(h).Run(); err != nil {
+			fmt.Fprintf(os.Stderr, "post-stop event handler failed: %v\n", err)
+		}
+	}
+
+	sp := filepath.Join(rktpath.Stage1RootfsPath(c.Root), statusDir, types.ShortHash(id.String()))
+	err = os.MkdirAll(filepath.Dir(sp), 0755)
+	if err == nil {
+		err = ioutil.WriteFile(sp, []byte(strconv.Itoa(status)+"\n"), 0644)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to record exit status: %v\n", err)
+	}
+	return status, nil
+}
+
+// exitStatus returns the exit status of a process, or 128 plus the number
+// of the signal that killed it, as shells do
+func exitStatus(ps *os.ProcessState) int {
+	ws := ps.Sys().(syscall.WaitStatus)
+	if ws.Signaled() {
+		return 128 + int(ws.Signal())
+	}
+	return ws.ExitStatus()
+}
+
+// appCredential returns the credential of the app running as user and
+// group, given as IDs or names looked up in the passwd and group files of
+// rootfs
+func appCredential(rootfs, user, group string) (*syscall.Credential, error) {
+	uid, err := lookupID(filepath.Join(rootfs, "etc/passwd"), user)
+	if err != nil {
+		return nil, fmt.Errorf("error looking up user %q: %v", user, err)
+	}
+	gid, err := lookupID(filepath.Join(rootfs, "etc/group"), group)
+	if err != nil {
+		return nil, fmt.Errorf("error looking up group %q: %v", group, err)
+	}
+	return &syscall.Credential{Uid: uid, Gid: gid}, nil
+}
+
+// lookupID returns the ID of name in a file in the format of /etc/passwd
+// or /etc/group, or name itself if numeric
+func lookupID(file, name string) (uint32, error) {
+	if id, err := strconv.ParseUint(name, 10, 32); err == nil {
+		return uint32(id), nil
+	}
+	if name == "root" {
+		return 0, nil
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		fields := strings.Split(s.Text(), ":")
+		if len(fields) < 3 || fields[0] != name {
+			continue
+		}
+		id, err := strconv.ParseUint(fields[2], 10, 32)
+		if err != nil {
+			return 0, fmt.Errorf("invalid ID %q in %s", fields[2], file)
+		}
+		return uint32(id), nil
+	}
+	if err := s.Err(); err != nil {
+		return 0, err
+	}
+	return 0, fmt.Errorf("not found in %s", file)
+}
@@ -0,0 +1,63 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLookupID(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fly")
+	if err != nil {
+		t.Fatalf("error creating tempdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	passwd := filepath.Join(dir, "passwd")
+	if err := ioutil.WriteFile(passwd, []byte("root:x:0:0::/root:/bin/sh\nkubelet:x:1042:1042::/:/bin/false\nbogus:x:nan:1\n"), 0644); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		id   uint32
+		fail bool
+	}{
+		{name: "0", id: 0},
+		{name: "1000", id: 1000},
+		{name: "root", id: 0},
+		{name: "kubelet", id: 1042},
+		{name: "nobody", fail: true},
+		{name: "bogus", fail: true},
+	}
+	for _, tt := range tests {
+		id, err := lookupID(passwd, tt.name)
+		if tt.fail {
+			if err == nil {
+				t.Errorf("%s: expected an error", tt.name)
+			}
+			continue
+		}
+		if err != nil || id != tt.id {
+			t.Errorf("%s: got %d, %v, want %d", tt.name, id, err, tt.id)
+		}
+	}
+
+	if id, err := lookupID(filepath.Join(dir, "group"), "0"); err != nil || id != 0 {
+		t.Errorf("numeric IDs need no file, got %d, %v", id, err)
+	}
+}
@@ -26,10 +26,20 @@ import (
 	"os/exec"
 	"path/filepath"
 	"runtime"
+	"strconv"
 	"syscall"
+	"time"
 
+	"github.com/appc/spec/schema/types"
+	"github.com/coreos/rocket/metadatasvc"
 	"github.com/coreos/rocket/networking"
+	"github.com/coreos/rocket/networking/util"
 	"github.com/coreos/rocket/path"
+	"github.com/coreos/rocket/pkg/cgroup"
+	"github.com/coreos/rocket/pkg/events"
+	"github.com/coreos/rocket/pkg/pod"
+	"github.com/coreos/rocket/pkg/trace"
+	"github.com/coreos/rocket/pkg/watchdog"
 )
 
 const (
@@ -76,13 +86,36 @@ func mirrorLocalZoneInfo(root string) {
 }
 
 var (
-	debug   bool
-	privNet bool
+	debug        bool
+	privNet      networking.NetList
+	ports        networking.PortList
+	preparedNet  bool
+	setupTimeout time.Duration
+	dnsServers   stringList
+	dnsSearch    stringList
+	dnsOpts      stringList
+	readyTimeout time.Duration
+	readyPolicy  = networking.ReadyAbort
+	traceFile    string
+	// mdsRegister is set when the container is registered with the
+	// metadata service, which identifies it on its private network
+	mdsRegister     bool
+	registerMachine bool
 )
 
 func init() {
 	flag.BoolVar(&debug, "debug", false, "Run in debug mode")
-	flag.BoolVar(&privNet, "private-net", false, "Setup private network (WIP!)")
+	flag.Var(&privNet, "private-net", "Setup private network (WIP!), optionally restricted to a comma-separated list of nets")
+	flag.Var(&ports, "port", "Forward a port of an app, as NAME:HOSTPORT, from the host")
+	flag.BoolVar(&preparedNet, "prepared-net", false, "Use the private network set up when the container was prepared")
+	flag.DurationVar(&setupTimeout, "setup-timeout", 0, "Abort if network setup takes longer than this")
+	flag.Var(&dnsServers, "dns", "Nameserver for the apps, may be given more than once")
+	flag.Var(&dnsSearch, "dns-search", "DNS search domain for the apps, may be given more than once")
+	flag.Var(&dnsOpts, "dns-opt", "resolv.conf option for the apps, may be given more than once")
+	flag.DurationVar(&readyTimeout, "net-ready-timeout", 0, "Wait up to this long for the networks to be ready before starting the apps")
+	flag.Var(&readyPolicy, "net-ready-policy", "What to do when the networks aren't ready in time: abort or continue")
+	flag.StringVar(&traceFile, "trace", "", "Add the spans of stage1 to the trace stage0 wrote to this file")
+	flag.BoolVar(&registerMachine, "register-machine", false, "Register the container with systemd-machined if the host runs systemd")
 
 	// this ensures that main runs only on main thread (thread group leader).
 	// since namespace ops (unshare, setns) are done for a single thread, we
@@ -90,39 +123,50 @@ func init() {
 	runtime.LockOSThread()
 }
 
-func stage1() int {
-	root := "."
-	c, err := LoadContainer(root)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to load container: %v\n", err)
-		return 1
-	}
-
-	mirrorLocalZoneInfo(c.Root)
-
-	if err = c.ContainerToSystemd(); err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to configure systemd: %v\n", err)
-		return 2
-	}
+// systemdBooted reports whether the host runs systemd, as sd_booted(3)
+// does; nspawn can't tell, fakesdboot.so answers for it.
+func systemdBooted() bool {
+	fi, err := os.Lstat("/run/systemd/system")
+	return err == nil && fi.IsDir()
+}
 
+// nspawnArgs returns the systemd-nspawn command line booting systemd in
+// the container c, with devices bound into its apps. With register, nspawn
+// registers c with systemd-machined, as the machine named by its hostname.
+func nspawnArgs(c *Container, devices []cgroup.Device, register bool) ([]string, error) {
 	args := []string{
 		filepath.Join(path.Stage1RootfsPath(c.Root), interpBin),
 		filepath.Join(path.Stage1RootfsPath(c.Root), nspawnBin),
-		"--boot",              // Launch systemd in the container
-		"--register", "false", // We cannot assume the host system is running systemd
+		"--boot", // Launch systemd in the container
+		"--register", strconv.FormatBool(register),
 	}
 
 	if !debug {
 		args = append(args, "--quiet") // silence most nspawn output (log_warning is currently not covered by this)
 	}
+	if c.SELinux != nil {
+		args = append(args, "--selinux-context="+c.SELinux.Process)
+		args = append(args, "--selinux-apifs-context="+c.SELinux.File)
+	}
 
 	nsargs, err := c.ContainerToNspawnArgs()
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to generate nspawn args: %v\n", err)
-		return 4
+		return nil, err
 	}
 	args = append(args, nsargs...)
 
+	etcArgs, err := c.etcNspawnArgs()
+	if err != nil {
+		return nil, err
+	}
+	args = append(args, etcArgs...)
+
+	devArgs, err := c.deviceNspawnArgs(devices)
+	if err != nil {
+		return nil, err
+	}
+	args = append(args, devArgs...)
+
 	// Arguments to systemd
 	args = append(args, "--")
 	args = append(args, "--default-standard-output=tty") // redirect all service logs straight to tty
@@ -131,26 +175,188 @@ func stage1() int {
 		args = append(args, "--show-status=0")   // silence systemd initialization status output
 	}
 
+	return args, nil
+}
+
+func stage1() int {
+	tr := loadTrace()
+	root := "."
+	c, err := LoadContainer(root)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load container: %v\n", err)
+		return 1
+	}
+
+	reportPhase(c, pod.PhaseSetup)
+	mirrorLocalZoneInfo(c.Root)
+	mdsRegister = privNet.Enabled() && c.Flavor != flavorFly && metadatasvc.Available()
+
+	if err = mountVerity(c); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to mount verity images: %v\n", err)
+		return 7
+	}
+
+	if err = c.setupVolumes(); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to set up volumes: %v\n", err)
+		return 4
+	}
+
+	if c.Flavor == flavorFly {
+		return runFly(c, tr)
+	}
+
+	if err = c.ContainerToSystemd(); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to configure systemd: %v\n", err)
+		return 2
+	}
+
+	isolators := c.isolators()
+	blkio, devices, err := cgroup.ContainerResources(isolators)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to read resource isolators: %v\n", err)
+		return 4
+	}
+	cpuset, err := cgroup.ContainerCPUSet(isolators)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to read cpuset isolators: %v\n", err)
+		return 4
+	}
+
+	var args []string
+	if c.Flavor == flavorKVM {
+		if !privNet.Enabled() {
+			fmt.Fprintf(os.Stderr, "A container run in a VM needs a private network\n")
+			return 4
+		}
+		if len(devices) > 0 {
+			fmt.Fprintf(os.Stderr, "Devices of the host can't be made available in a VM, ignoring them\n")
+		}
+		args, err = c.ContainerToKVMArgs()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to generate lkvm args: %v\n", err)
+			return 4
+		}
+	} else {
+		var volDevices []cgroup.Device
+		if volDevices, err = c.makeDeviceVolumes(); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to set up device volumes: %v\n", err)
+			return 4
+		}
+		// machined moves the machines it registers into scopes of their
+		// own, out of the cgroups the container is limited by
+		register := registerMachine && systemdBooted()
+		if register && (!blkio.Empty() || !cpuset.Empty() || len(devices)+len(volDevices) > 0) {
+			fmt.Fprintf(os.Stderr, "Warning: not registering the container with systemd-machined, it would lift its block I/O, cpuset and device limits\n")
+			register = false
+		}
+		if args, err = nspawnArgs(c, devices, register); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to generate nspawn args: %v\n", err)
+			return 4
+		}
+		devices = append(devices, volDevices...)
+	}
+
+	// all the processes of the container inherit the freezer cgroup, so
+	// they can be paused together
+	if err := cgroup.JoinFreezer(c.Manifest.UUID.String(), os.Getpid()); err != nil && debug {
+		fmt.Fprintf(os.Stderr, "Unable to join freezer cgroup, the container can't be paused: %v\n", err)
+	}
+	if err := c.joinResources(blkio, devices, cpuset); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to apply resource isolators: %v\n", err)
+		return 4
+	}
+
 	env := os.Environ()
 	env = append(env, "LD_PRELOAD="+filepath.Join(path.Stage1RootfsPath(c.Root), "fakesdboot.so"))
 	env = append(env, "LD_LIBRARY_PATH="+filepath.Join(path.Stage1RootfsPath(c.Root), "usr/lib"))
 
-	if privNet {
+	if privNet.Enabled() {
 		// careful not to make another local err variable.
 		// cmd.Run sets the one from parent scope
+		wd := watchdog.Start(setupTimeout, watchdog.PhaseNetwork, os.Stderr, func(err error) {
+			fmt.Fprintf(os.Stderr, "Failed to setup network: %v\n", err)
+			os.Exit(6)
+		})
 		var n *networking.Networking
-		n, err = networking.Setup(root, c.Manifest.UUID)
+		if preparedNet {
+			n, err = networking.Load(root, c.Manifest.UUID)
+		} else {
+			n, err = networking.Setup(root, c.Manifest.UUID, privNet.Names(), privNet.Args(), tr.Find(trace.SpanStage1))
+		}
 		if err != nil {
+			wd.Stop()
 			fmt.Fprintf(os.Stderr, "Failed to setup network: %v\n", err)
 			return 6
 		}
 		defer n.Teardown()
 
-		if err = n.EnterContNS(); err != nil {
+		if len(ports) > 0 {
+			var declared []types.Port
+			for _, am := range c.Apps {
+				if am.App != nil {
+					declared = append(declared, am.App.Ports...)
+				}
+			}
+			if err = n.ForwardPorts(ports, declared); err != nil {
+				wd.Stop()
+				fmt.Fprintf(os.Stderr, "Failed to forward ports: %v\n", err)
+				return 6
+			}
+		}
+
+		if mdsRegister {
+			if err = registerIP(c, n); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: the apps can't reach the metadata service: %v\n", err)
+				err = nil
+			} else {
+				defer metadatasvc.UnregisterContainer(c.Manifest.UUID.String())
+			}
+		}
+
+		dns := composeDNS(n.DNS(), dnsServers, dnsSearch, dnsOpts)
+		if err = c.writeEtcFiles(dns, n.MetadataIP); err != nil {
+			wd.Stop()
+			fmt.Fprintf(os.Stderr, "Failed to configure DNS: %v\n", err)
+			return 8
+		}
+
+		err = n.EnterContNS()
+		if err != nil {
+			wd.Stop()
 			fmt.Fprintf(os.Stderr, "Failed to switch to container netns: %v\n", err)
 			return 6
 		}
 
+		// the apps would otherwise start with no route out, and may e.g.
+		// cache failed DNS lookups
+		if readyTimeout > 0 {
+			if err = n.WaitReady(readyTimeout); err != nil {
+				if readyPolicy == networking.ReadyAbort {
+					wd.Stop()
+					fmt.Fprintf(os.Stderr, "Failed to wait for the network: %v\n", err)
+					return 6
+				}
+				fmt.Fprintf(os.Stderr, "Warning: starting the apps anyway: %v\n", err)
+				err = nil
+			}
+		}
+
+		if c.Flavor == flavorKVM {
+			var gns []networking.GuestNet
+			if gns, err = n.SetupGuestNets(); err == nil {
+				err = c.writeGuestNets(gns)
+			}
+			if err != nil {
+				wd.Stop()
+				fmt.Fprintf(os.Stderr, "Failed to setup network of the VM: %v\n", err)
+				return 6
+			}
+			args = append(args, kvmNetArgs(gns)...)
+		}
+		wd.Stop()
+
+		writeTrace(tr)
+		reportPhase(c, pod.PhaseRunning)
 		cmd := exec.Cmd{
 			Path:   args[0],
 			Args:   args,
@@ -160,18 +366,80 @@ func stage1() int {
 			Env:    env,
 		}
 		err = cmd.Run()
+		reportPhase(c, pod.PhaseExited)
+		recordExit(c)
 	} else {
+		// the container shares the network stack of the host, and so
+		// its resolver settings
+		if err = networking.SaveHostNetInfo(root); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to save network info: %v\n", err)
+			return 6
+		}
+		var dns util.DNS
+		if dns, err = hostDNS(); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to configure DNS: %v\n", err)
+			return 8
+		}
+		dns = composeDNS(dns, dnsServers, dnsSearch, dnsOpts)
+		if err = c.writeEtcFiles(dns, nil); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to configure DNS: %v\n", err)
+			return 8
+		}
+		writeTrace(tr)
+		// the end of the pod goes unreported, readers tell it from the
+		// lock of the pod
+		reportPhase(c, pod.PhaseRunning)
 		err = syscall.Exec(args[0], args, env)
 	}
 
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to execute nspawn: %v\n", err)
+		fmt.Fprintf(os.Stderr, "Failed to execute %s: %v\n", args[0], err)
 		return 5
 	}
 
 	return 0
 }
 
+// reportPhase reports the phase of the container c per the status protocol
+// of pkg/pod. Failing to is no reason not to run it.
+func reportPhase(c *Container, phase pod.Phase) {
+	if err := pod.WritePhase(c.Root, phase); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: unable to report the status of the container: %v\n", err)
+	}
+}
+
+// recordExit records the exit of the container c and of its apps in the
+// event journal of the data directory it is run from. Failing to is no
+// reason to fail the container.
+func recordExit(c *Container) {
+	cdir, err := filepath.Abs(c.Root)
+	if err == nil {
+		// c.Root is DATADIR/containers/UUID
+		dataDir := filepath.Dir(filepath.Dir(cdir))
+		var p *pod.Pod
+		if p, err = pod.Open(dataDir, c.Manifest.UUID); err == nil {
+			err = events.RecordExit(dataDir, p)
+			p.Close()
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: unable to record the exit of the container: %v\n", err)
+	}
+}
+
+// registerIP tells the metadata service the address the apps of c connect
+// from, registering c first if the service doesn't know it.
+func registerIP(c *Container, n *networking.Networking) error {
+	err := metadatasvc.RegisterIP(c.Manifest.UUID, n.MetadataIP, n.MetadataNet)
+	if err != metadatasvc.ErrNotRegistered {
+		return err
+	}
+	if err = metadatasvc.RegisterContainer(c.Manifest, c.Apps); err != nil {
+		return err
+	}
+	return metadatasvc.RegisterIP(c.Manifest.UUID, n.MetadataIP, n.MetadataNet)
+}
+
 func main() {
 	flag.Parse()
 	// move code into stage1() helper so defered fns get run
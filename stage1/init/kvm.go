@@ -0,0 +1,243 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//+build linux
+
+package main
+
+// this implements the kvm flavor of stage1, booting the container in a
+// virtual machine
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/coreos/rocket/Godeps/_workspace/src/github.com/coreos/go-systemd/unit"
+	"github.com/coreos/rocket/networking"
+	rktpath "github.com/coreos/rocket/path"
+	"github.com/coreos/rocket/volume"
+)
+
+const (
+	// flavorKVM runs the container in a virtual machine instead of
+	// systemd-nspawn
+	flavorKVM = "kvm"
+
+	// Paths to the virtual machine monitor and the kernel of the VM
+	// within the stage1 rootfs
+	lkvmBin     = "/lkvm"
+	kernelImage = "/bzImage"
+	// kvmMemory is the memory of the VM, in MiB
+	kvmMemory = 512
+	// rootTag is the 9p tag of the stage1 rootfs, the root of the VM
+	rootTag = "/dev/root"
+	// guestNetworkDir holds the network configuration of the VM, applied
+	// by systemd-networkd, relative to the stage1 rootfs
+	guestNetworkDir = "etc/systemd/network"
+)
+
+// ContainerToKVMArgs renders a prepared Container as the lkvm argument list
+// booting it in a VM, the stage1 rootfs as its root, shared over 9p like
+// the volumes of the apps, which are mounted by systemd in the VM.
+func (c *Container) ContainerToKVMArgs() ([]string, error) {
+	s1, err := filepath.Abs(rktpath.Stage1RootfsPath(c.Root))
+	if err != nil {
+		return nil, err
+	}
+	hostname := podHostname(c.Manifest.UUID)
+	if err := ioutil.WriteFile(filepath.Join(s1, "etc/hostname"), []byte(hostname+"\n"), 0644); err != nil {
+		return nil, fmt.Errorf("error writing hostname: %v", err)
+	}
+
+	args := []string{
+		filepath.Join(s1, lkvmBin), "run",
+		"--name", hostname,
+		"--kernel", filepath.Join(s1, kernelImage),
+		"--9p", s1 + "," + rootTag,
+		"--mem", strconv.Itoa(kvmMemory),
+		"--cpus", strconv.Itoa(runtime.NumCPU()),
+		"--console", "serial",
+	}
+
+	vols, err := c.volumes()
+	if err != nil {
+		return nil, err
+	}
+	ntag := 0
+	for _, ra := range c.Manifest.Apps {
+		am := c.Apps[ra.Name.String()]
+		var mounts []string
+		for _, mp := range am.App.MountPoints {
+			vol, ok := vols[mp.Name]
+			if !ok {
+				return nil, fmt.Errorf("no volume for mountpoint %q in app %q", mp.Name, am.Name)
+			}
+			// mounted in the VM by the unit of the app
+			if vol.Kind == volume.KindTmpfs {
+				continue
+			}
+			if isDeviceVolume(vol) {
+				return nil, fmt.Errorf("the device of volume %q can't be made available in a VM", mp.Name)
+			}
+			tag := fmt.Sprintf("vol%d", ntag)
+			ntag++
+			where := filepath.Join(rktpath.RelAppRootfsPath(ra.ImageID), mp.Path)
+			if err := c.writeVolumeMount(tag, where, mp.ReadOnly || vol.ReadOnly); err != nil {
+				return nil, fmt.Errorf("failed to write mount unit for mountpoint %q in app %q: %v", mp.Name, am.Name, err)
+			}
+			args = append(args, "--9p", vol.Source+","+tag)
+			mounts = append(mounts, where)
+		}
+		if len(mounts) == 0 {
+			continue
+		}
+		// the app waits for its volumes
+		dropIn := []*unit.UnitOption{
+			newUnitOption("Unit", "RequiresMountsFor", strings.Join(mounts, " ")),
+		}
+		if err := writeUnit(ServiceDropInPath(c.Root, ra.ImageID, "volumes.conf"), dropIn); err != nil {
+			return nil, fmt.Errorf("failed to write volumes of app %q: %v", am.Name, err)
+		}
+	}
+
+	params := []string{
+		"console=ttyS0",
+		"root=" + rootTag,
+		"rootfstype=9p",
+		"rootflags=trans=virtio,version=9p2000.L",
+		"rw",
+		"init=/usr/lib/systemd/systemd",
+		"systemd.default_standard_output=tty",
+	}
+	if !debug {
+		params = append(params, "quiet", "systemd.log_target=null", "systemd.show_status=0")
+	}
+	args = append(args, "--params", strings.Join(params, " "))
+	return args, nil
+}
+
+// writeVolumeMount writes the unit mounting the 9p share tag on where in
+// the VM
+func (c *Container) writeVolumeMount(tag, where string, readOnly bool) error {
+	opts := "trans=virtio,version=9p2000.L"
+	if readOnly {
+		opts += ",ro"
+	}
+	return writeUnit(filepath.Join(c.Root, unitsDir, MountUnitName(where)), []*unit.UnitOption{
+		newUnitOption("Unit", "DefaultDependencies", "false"),
+		newUnitOption("Mount", "What", tag),
+		newUnitOption("Mount", "Where", where),
+		newUnitOption("Mount", "Type", "9p"),
+		newUnitOption("Mount", "Options", opts),
+	})
+}
+
+func writeUnit(p string, opts []*unit.UnitOption) error {
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = io.Copy(file, unit.Serialize(opts))
+	return err
+}
+
+// copyEtcFiles copies the resolv.conf and hosts file of the container into
+// every app, where they can't be bind-mounted from outside of the VM.
+// Existing files are replaced rather than written to, as they may be
+// shared with other containers.
+func (c *Container) copyEtcFiles() error {
+	files := [][2]string{
+		{ResolvConfPath(c.Root), "etc/resolv.conf"},
+		{HostsPath(c.Root), "etc/hosts"},
+	}
+	for _, app := range c.Manifest.Apps {
+		for _, f := range files {
+			src, dst := f[0], filepath.Join(rktpath.AppRootfsPath(c.Root, app.ImageID), f[1])
+			b, err := ioutil.ReadFile(src)
+			if err != nil {
+				return err
+			}
+			err = ensureMountTarget(dst)
+			if err == nil {
+				err = os.Remove(dst)
+			}
+			if err == nil {
+				err = ioutil.WriteFile(dst, b, 0644)
+			}
+			if err != nil {
+				// e.g. the rootfs is a read-only verity image, the
+				// app keeps its own file
+				fmt.Fprintf(os.Stderr, "Unable to write /%s in app %s: %v\n", f[1], app.ImageID, err)
+			}
+		}
+	}
+	return nil
+}
+
+// kvmNetArgs returns the lkvm arguments connecting the VM to the taps of
+// the nets
+func kvmNetArgs(gns []networking.GuestNet) []string {
+	var args []string
+	for _, gn := range gns {
+		args = append(args, "--network", fmt.Sprintf("mode=tap,tapif=%s,guest_mac=%s", gn.Tap, gn.MAC))
+	}
+	return args
+}
+
+// writeGuestNets writes the configuration of the interfaces of the VM,
+// matched by their hardware address, for systemd-networkd.
+func (c *Container) writeGuestNets(gns []networking.GuestNet) error {
+	dir := filepath.Join(rktpath.Stage1RootfsPath(c.Root), guestNetworkDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	for i, gn := range gns {
+		p := filepath.Join(dir, fmt.Sprintf("%02d-rkt.network", i))
+		if err := ioutil.WriteFile(p, []byte(guestNetworkConf(&gn)), 0644); err != nil {
+			return fmt.Errorf("error writing configuration of net %q: %v", gn.NetName, err)
+		}
+	}
+	return nil
+}
+
+// guestNetworkConf renders the systemd-networkd configuration of the
+// interface of the VM on gn
+func guestNetworkConf(gn *networking.GuestNet) string {
+	b := &bytes.Buffer{}
+	fmt.Fprintf(b, "[Match]\nMACAddress=%s\n\n[Network]\n", gn.MAC)
+	for _, ipn := range []*net.IPNet{gn.IP, gn.IP6} {
+		if ipn != nil {
+			fmt.Fprintf(b, "Address=%s\n", ipn)
+		}
+	}
+	for _, r := range gn.Routes {
+		fmt.Fprintf(b, "\n[Route]\nDestination=%s\n", r.Dst)
+		if r.GW != nil {
+			fmt.Fprintf(b, "Gateway=%s\n", r.GW)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,68 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net"
+	"testing"
+
+	"github.com/coreos/rocket/networking"
+)
+
+func TestMountUnitName(t *testing.T) {
+	tests := []struct {
+		where string
+		name  string
+	}{
+		{"/", "-.mount"},
+		{"/opt/stage2/sha512-0123/rootfs/var/lib/data", `opt-stage2-sha512\x2d0123-rootfs-var-lib-data.mount`},
+		{"/srv/.cache//my dir/", `srv-.cache-my\x20dir.mount`},
+	}
+	for i, tt := range tests {
+		if n := MountUnitName(tt.where); n != tt.name {
+			t.Errorf("#%d: got %q, want %q", i, n, tt.name)
+		}
+	}
+}
+
+func TestGuestNetworkConf(t *testing.T) {
+	mac, _ := net.ParseMAC("02:00:00:00:00:01")
+	_, dflt, _ := net.ParseCIDR("0.0.0.0/0")
+	_, gwNet, _ := net.ParseCIDR("10.1.0.1/32")
+	gn := networking.GuestNet{
+		MAC: mac,
+		IP:  &net.IPNet{IP: net.ParseIP("10.1.2.3"), Mask: net.CIDRMask(16, 32)},
+		Routes: []networking.GuestRoute{
+			{Dst: gwNet},
+			{Dst: dflt, GW: net.ParseIP("10.1.0.1")},
+		},
+	}
+	want := `[Match]
+MACAddress=02:00:00:00:00:01
+
+[Network]
+Address=10.1.2.3/16
+
+[Route]
+Destination=10.1.0.1/32
+
+[Route]
+Destination=0.0.0.0/0
+Gateway=10.1.0.1
+`
+	if conf := guestNetworkConf(&gn); conf != want {
+		t.Errorf("got:\n%s\nwant:\n%s", conf, want)
+	}
+}
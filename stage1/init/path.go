@@ -17,7 +17,10 @@
 package main
 
 import (
+	"bytes"
+	"fmt"
 	"path/filepath"
+	"strings"
 
 	"github.com/appc/spec/schema/types"
 	"github.com/coreos/rocket/path"
@@ -27,13 +30,31 @@ const (
 	unitsDir        = path.Stage1Dir + "/usr/lib/systemd/system"
 	defaultWantsDir = unitsDir + "/default.target.wants"
 	socketsWantsDir = unitsDir + "/sockets.target.wants"
+	// journaldSocket is the unit of the socket of the journal of the
+	// apps
+	journaldSocket = "systemd-journald.socket"
+	// tmpfsVolumesDir is where stage1 mounts the tmpfs volumes shared by
+	// the apps, in its rootfs
+	tmpfsVolumesDir = "/rkt/volumes"
 )
 
+// TmpfsVolumePath returns where the tmpfs volume shared by the apps which
+// fulfills name is mounted in stage1
+func TmpfsVolumePath(name types.ACName) string {
+	return filepath.Join(tmpfsVolumesDir, name.String())
+}
+
 // ServiceUnitName returns a systemd service unit name for the given imageID
 func ServiceUnitName(imageID types.Hash) string {
 	return types.ShortHash(imageID.String()) + ".service"
 }
 
+// StartedUnitName returns the name of the instance of the app-started@
+// unit reporting the PID of the app of the given imageID
+func StartedUnitName(imageID types.Hash) string {
+	return "app-started@" + types.ShortHash(imageID.String()) + ".service"
+}
+
 // ServiceUnitPath returns the path to the systemd service file for the given
 // imageID
 func ServiceUnitPath(root string, imageID types.Hash) string {
@@ -46,6 +67,34 @@ func ServiceWantPath(root string, imageID types.Hash) string {
 	return filepath.Join(filepath.Join(root, defaultWantsDir), ServiceUnitName(imageID))
 }
 
+// ServiceDropInPath returns the path to the drop-in file name of the
+// systemd service of the given imageID
+func ServiceDropInPath(root string, imageID types.Hash, name string) string {
+	return filepath.Join(root, unitsDir, ServiceUnitName(imageID)+".d", name)
+}
+
+// MountUnitName returns the name of the systemd mount unit of the mount
+// point where, the path escaped as systemd does
+func MountUnitName(where string) string {
+	p := strings.Trim(filepath.Clean(where), "/")
+	if p == "" {
+		return "-.mount"
+	}
+	var b bytes.Buffer
+	for i := 0; i < len(p); i++ {
+		switch ch := p[i]; {
+		case ch == '/':
+			b.WriteByte('-')
+		case ch == '_', ch == '.' && i > 0,
+			'0' <= ch && ch <= '9', 'a' <= ch && ch <= 'z', 'A' <= ch && ch <= 'Z':
+			b.WriteByte(ch)
+		default:
+			fmt.Fprintf(&b, `\x%02x`, ch)
+		}
+	}
+	return b.String() + ".mount"
+}
+
 // SocketUnitName returns a systemd socket unit name for the given imageID
 func SocketUnitName(imageID types.Hash) string {
 	return imageID.String() + ".socket"
@@ -61,3 +110,13 @@ func SocketUnitPath(root string, imageID types.Hash) string {
 func SocketWantPath(root string, imageID types.Hash) string {
 	return filepath.Join(filepath.Join(root, socketsWantsDir), SocketUnitName(imageID))
 }
+
+// ResolvConfPath returns the path to the resolv.conf composed for the apps
+func ResolvConfPath(root string) string {
+	return filepath.Join(root, "resolv.conf")
+}
+
+// HostsPath returns the path to the hosts file composed for the apps
+func HostsPath(root string) string {
+	return filepath.Join(root, "hosts")
+}
@@ -0,0 +1,95 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/appc/spec/schema/types"
+	rktpath "github.com/coreos/rocket/path"
+	"github.com/coreos/rocket/pkg/cgroup"
+)
+
+// containerIsolators are the isolators stage1 applies to the container as
+// a whole rather than through systemd, which may also be set as
+// annotations of the container runtime manifest
+var containerIsolators = []string{
+	cgroup.BlockIOWeightIsolator,
+	cgroup.BlockIOReadBandwidthIsolator,
+	cgroup.BlockIOWriteBandwidthIsolator,
+	cgroup.BlockIOReadIOPSIsolator,
+	cgroup.BlockIOWriteIOPSIsolator,
+	cgroup.DeviceIsolator,
+	cgroup.CPUSetCPUsIsolator,
+	cgroup.CPUSetMemsIsolator,
+}
+
+// isolators returns the isolators of the apps and of the container runtime
+// manifest, and those of containerIsolators set by its annotations.
+func (c *Container) isolators() types.Isolators {
+	var isolators types.Isolators
+	for _, a := range c.Manifest.Apps {
+		isolators = append(isolators, a.Isolators...)
+	}
+	isolators = append(isolators, c.Manifest.Isolators...)
+	for _, a := range c.Manifest.Annotations {
+		for _, name := range containerIsolators {
+			if string(a.Name) == name {
+				isolators = append(isolators, types.Isolator{Name: a.Name, Val: a.Value})
+			}
+		}
+	}
+	return isolators
+}
+
+// deviceNspawnArgs returns the systemd-nspawn arguments binding devices
+// into every app, at the same path as on the host.
+func (c *Container) deviceNspawnArgs(devices []cgroup.Device) ([]string, error) {
+	var args []string
+	for _, app := range c.Manifest.Apps {
+		for _, d := range devices {
+			dev := d.Path
+			if err := ensureMountTarget(filepath.Join(rktpath.AppRootfsPath(c.Root, app.ImageID), dev)); err != nil {
+				return nil, fmt.Errorf("error binding %s in app %s: %v", dev, app.ImageID, err)
+			}
+			args = append(args, "--bind="+dev+":"+filepath.Join(rktpath.RelAppRootfsPath(app.ImageID), dev))
+		}
+	}
+	return args, nil
+}
+
+// joinResources moves stage1 into the blkio, devices and cpuset cgroups of
+// the container, which all its processes inherit. Access to devices is
+// only restricted once some are whitelisted, and not in a VM, where the
+// devices of the host aren't available to the apps anyway.
+func (c *Container) joinResources(blkio *cgroup.BlkioLimits, devices []cgroup.Device, cpuset *cgroup.CPUSet) error {
+	uuid := c.Manifest.UUID.String()
+	if !cpuset.Empty() {
+		if err := cgroup.JoinCPUSet(uuid, os.Getpid(), cpuset); err != nil {
+			return err
+		}
+	}
+	if !blkio.Empty() {
+		if err := cgroup.JoinBlkio(uuid, os.Getpid(), blkio); err != nil {
+			return err
+		}
+	}
+	if len(devices) == 0 || c.Flavor == flavorKVM {
+		return nil
+	}
+	return cgroup.JoinDevices(uuid, os.Getpid(), devices)
+}
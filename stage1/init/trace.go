@@ -0,0 +1,52 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//+build linux
+
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/coreos/rocket/pkg/trace"
+)
+
+// loadTrace reads the trace stage0 wrote to traceFile, finishing its span
+// of the exec of stage1. It returns nil if there is none.
+func loadTrace() *trace.Span {
+	if traceFile == "" {
+		return nil
+	}
+	tr, err := trace.ReadFile(traceFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+		return nil
+	}
+	tr.Find(trace.SpanStage1).Find(trace.SpanExec).Finish()
+	return tr
+}
+
+// writeTrace finishes tr and writes it back to traceFile, once the apps
+// are about to start.
+func writeTrace(tr *trace.Span) {
+	if tr == nil {
+		return
+	}
+	tr.Find(trace.SpanStage1).Finish()
+	tr.Finish()
+	if err := tr.WriteFile(traceFile); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+	}
+}
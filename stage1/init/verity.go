@@ -0,0 +1,116 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//+build linux
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/appc/spec/schema/types"
+	rktpath "github.com/coreos/rocket/path"
+	"github.com/coreos/rocket/pkg/verity"
+)
+
+// envVerity holds the dm-verity root hashes of the app images, as set by
+// stage0 when rendering in verity mode
+const envVerity = "RKT_VERITY"
+
+// parseRootHashes parses the comma-separated imageID=roothash pairs of
+// envVerity.
+func parseRootHashes(s string) (map[string]string, error) {
+	hashes := make(map[string]string)
+	for _, kv := range strings.Split(s, ",") {
+		p := strings.SplitN(kv, "=", 2)
+		if len(p) != 2 || p[0] == "" || p[1] == "" {
+			return nil, fmt.Errorf("malformed root hash %q", kv)
+		}
+		hashes[p[0]] = p[1]
+	}
+	return hashes, nil
+}
+
+// mountVerity mounts the dm-verity protected rootfs image of every app,
+// failing if any of them lacks a root hash. It does nothing unless stage0
+// rendered the container in verity mode.
+// The mounts are made in a private mount namespace which nspawn inherits,
+// so they go away with the container.
+func mountVerity(c *Container) error {
+	env := os.Getenv(envVerity)
+	if env == "" {
+		return nil
+	}
+	os.Unsetenv(envVerity)
+	rootHashes, err := parseRootHashes(env)
+	if err != nil {
+		return err
+	}
+
+	if err := syscall.Unshare(syscall.CLONE_NEWNS); err != nil {
+		return fmt.Errorf("error creating mount namespace: %v", err)
+	}
+	if err := syscall.Mount("", "/", "", syscall.MS_REC|syscall.MS_PRIVATE, ""); err != nil {
+		return fmt.Errorf("error making mounts private: %v", err)
+	}
+
+	prefix := verity.DevicePrefix(c.Manifest.UUID.String())
+	for _, app := range c.Manifest.Apps {
+		id := app.ImageID
+		h, ok := rootHashes[id.String()]
+		if !ok {
+			return fmt.Errorf("no root hash for image %s", id)
+		}
+		dev, err := verity.Open(prefix+types.ShortHash(id.String()), rktpath.AppVerityDataPath(c.Root, id), rktpath.AppVerityHashPath(c.Root, id), h)
+		if err != nil {
+			return err
+		}
+		rootfs := rktpath.AppRootfsPath(c.Root, id)
+		var opts string
+		if c.SELinux != nil {
+			opts = c.SELinux.MountOption()
+		}
+		if err := syscall.Mount(dev, rootfs, "squashfs", syscall.MS_RDONLY, opts); err != nil {
+			return fmt.Errorf("error mounting %s: %v", dev, err)
+		}
+		// the image is read-only, give the app a scratch /tmp
+		tmp := tmpMountPoint(rootfs)
+		if tmp == "" {
+			continue
+		}
+		tmpOpts := "mode=1777"
+		if opts != "" {
+			tmpOpts += "," + opts
+		}
+		if err := syscall.Mount("tmpfs", tmp, "tmpfs", 0, tmpOpts); err != nil {
+			return fmt.Errorf("error mounting tmpfs: %v", err)
+		}
+	}
+	return nil
+}
+
+// tmpMountPoint returns the /tmp directory of the read-only rootfs, or the
+// empty string if the image has none, which can't be created then. A
+// symlink isn't followed, it would be resolved on the host.
+func tmpMountPoint(rootfs string) string {
+	tmp := filepath.Join(rootfs, "tmp")
+	if fi, err := os.Lstat(tmp); err != nil || !fi.IsDir() {
+		return ""
+	}
+	return tmp
+}
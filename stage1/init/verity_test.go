@@ -0,0 +1,79 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//+build linux
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseRootHashes(t *testing.T) {
+	tests := []struct {
+		in string
+
+		out map[string]string
+		err bool
+	}{
+		{
+			"sha512-aaaa=0123,sha512-bbbb=4567",
+			map[string]string{"sha512-aaaa": "0123", "sha512-bbbb": "4567"},
+			false,
+		},
+		{"sha512-aaaa", nil, true},
+		{"sha512-aaaa=", nil, true},
+		{"sha512-aaaa=0123,", nil, true},
+	}
+	for i, tt := range tests {
+		out, err := parseRootHashes(tt.in)
+		if (err != nil) != tt.err {
+			t.Errorf("#%d: got err %v, want err %v", i, err, tt.err)
+		}
+		if !reflect.DeepEqual(out, tt.out) {
+			t.Errorf("#%d: got %v, want %v", i, out, tt.out)
+		}
+	}
+}
+
+func TestTmpMountPoint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "verity")
+	if err != nil {
+		t.Fatalf("error creating tempdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	if tmp := tmpMountPoint(dir); tmp != "" {
+		t.Errorf("got %q for a rootfs without /tmp", tmp)
+	}
+	if err := os.Symlink("/var/tmp", filepath.Join(dir, "tmp")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if tmp := tmpMountPoint(dir); tmp != "" {
+		t.Errorf("got %q for a /tmp symlink", tmp)
+	}
+	if err := os.Remove(filepath.Join(dir, "tmp")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "tmp"), 0755); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if tmp := tmpMountPoint(dir); tmp != filepath.Join(dir, "tmp") {
+		t.Errorf("got %q, want %q", tmp, filepath.Join(dir, "tmp"))
+	}
+}
@@ -0,0 +1,203 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//+build linux
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/appc/spec/schema/types"
+	rktpath "github.com/coreos/rocket/path"
+	"github.com/coreos/rocket/pkg/cgroup"
+	"github.com/coreos/rocket/volume"
+)
+
+// setupVolumes creates the directories of the empty volumes of the
+// container and gives them, and the directories of its host volumes, the
+// ownership and mode of their options, before the apps start. tmpfs
+// volumes get theirs as they are mounted. Host volumes with IfEmpty only
+// get theirs while their directory is empty.
+func (c *Container) setupVolumes() error {
+	for _, v := range c.Manifest.Volumes {
+		if len(v.Fulfills) == 0 {
+			continue
+		}
+		o := c.volumeOptions(v)
+		uid, gid := o.Owner()
+		switch v.Kind {
+		case "empty":
+			dir := rktpath.EmptyVolumePath(c.Root, v.Fulfills[0])
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				return fmt.Errorf("failed to create empty volume %q: %v", v.Fulfills[0], err)
+			}
+			// MkdirAll is subject to the umask and ignores special bits
+			if err := os.Chmod(dir, o.EmptyMode()); err != nil {
+				return fmt.Errorf("failed to set mode of empty volume %q: %v", v.Fulfills[0], err)
+			}
+			if err := chownTree(dir, uid, gid, false); err != nil {
+				return fmt.Errorf("failed to set owner of empty volume %q: %v", v.Fulfills[0], err)
+			}
+		case "host":
+			if uid < 0 && gid < 0 {
+				continue
+			}
+			if o.IfEmpty {
+				empty, err := isEmptyDir(v.Source)
+				if err != nil {
+					return fmt.Errorf("failed to read host volume %q: %v", v.Fulfills[0], err)
+				}
+				if !empty {
+					continue
+				}
+			}
+			if err := chownTree(v.Source, uid, gid, o.Recursive); err != nil {
+				return fmt.Errorf("failed to set owner of host volume %q: %v", v.Fulfills[0], err)
+			}
+		}
+	}
+	return nil
+}
+
+// volumeOptions returns the options of the volume v, given for any of the
+// names it fulfills.
+func (c *Container) volumeOptions(v types.Volume) volume.Options {
+	for _, f := range v.Fulfills {
+		if o, ok := c.VolumeOptions[f]; ok {
+			return o
+		}
+	}
+	return volume.Options{}
+}
+
+// chownTree gives dir, and all the files beneath it if recursive, to uid
+// and gid, -1 leaving either unchanged. Symlinks are not followed.
+func chownTree(dir string, uid, gid int, recursive bool) error {
+	if uid < 0 && gid < 0 {
+		return nil
+	}
+	if !recursive {
+		return os.Chown(dir, uid, gid)
+	}
+	return filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		return os.Lchown(path, uid, gid)
+	})
+}
+
+// isEmptyDir reports whether the directory dir has no entries.
+func isEmptyDir(dir string) (bool, error) {
+	f, err := os.Open(dir)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	_, err = f.Readdirnames(1)
+	if err == io.EOF {
+		return true, nil
+	}
+	return false, err
+}
+
+// isDeviceVolume reports whether v is a host volume of a device node, e.g.
+// a disk, which is created in the apps rather than bound into them.
+func isDeviceVolume(v types.Volume) bool {
+	if v.Kind != "host" {
+		return false
+	}
+	fi, err := os.Stat(v.Source)
+	return err == nil && fi.Mode()&os.ModeDevice != 0
+}
+
+// makeDeviceVolumes creates the device nodes of the device volumes in the
+// apps mounting them, and returns the devices the apps must be allowed to
+// access: read-only when all the apps mount them read-only.
+func (c *Container) makeDeviceVolumes() ([]cgroup.Device, error) {
+	vols, err := c.volumes()
+	if err != nil {
+		return nil, err
+	}
+	var devices []cgroup.Device
+	seen := make(map[string]int)
+	for _, ra := range c.Manifest.Apps {
+		am := c.Apps[ra.Name.String()]
+		for _, mp := range am.App.MountPoints {
+			vol, ok := vols[mp.Name]
+			if !ok || !isDeviceVolume(vol) {
+				continue
+			}
+			readOnly := mp.ReadOnly || vol.ReadOnly
+			access := "rw"
+			if readOnly {
+				access = "r"
+			}
+			d, err := cgroup.DeviceOf(vol.Source, access)
+			if err != nil {
+				return nil, err
+			}
+			dst := filepath.Join(rktpath.AppRootfsPath(c.Root, ra.ImageID), mp.Path)
+			if err := mknodDevice(dst, vol.Source, readOnly); err != nil {
+				return nil, fmt.Errorf("failed to create device of volume %q in app %q: %v", mp.Name, am.Name, err)
+			}
+			if i, ok := seen[d.Number()]; ok {
+				if !readOnly {
+					devices[i].Access = access
+				}
+				continue
+			}
+			seen[d.Number()] = len(devices)
+			devices = append(devices, d)
+		}
+	}
+	return devices, nil
+}
+
+// mknodDevice creates at dst a device node of the same device, owner and
+// mode as the node src, without write permissions if readOnly. Whatever
+// the image has at dst, e.g. an empty directory, is replaced.
+func mknodDevice(dst, src string, readOnly bool) error {
+	var st syscall.Stat_t
+	if err := syscall.Stat(src, &st); err != nil {
+		return err
+	}
+	if _, err := os.Lstat(dst); err == nil {
+		if err := os.Remove(dst); err != nil {
+			return err
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	if err := syscall.Mknod(dst, st.Mode, int(st.Rdev)); err != nil {
+		return err
+	}
+	if err := os.Chown(dst, int(st.Uid), int(st.Gid)); err != nil {
+		return err
+	}
+	mode := os.FileMode(st.Mode).Perm()
+	if readOnly {
+		mode &^= 0222
+	}
+	// mknod is subject to the umask
+	return os.Chmod(dst, mode)
+}
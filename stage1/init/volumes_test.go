@@ -0,0 +1,168 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/appc/spec/schema"
+	"github.com/appc/spec/schema/types"
+	"github.com/coreos/rocket/volume"
+)
+
+func TestChownTree(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("changing the owner of files needs root")
+	}
+	dir, err := ioutil.TempDir("", "volumes")
+	if err != nil {
+		t.Fatalf("error creating tempdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	sub := filepath.Join(dir, "sub")
+	if err := os.Mkdir(sub, 0755); err != nil {
+		t.Fatalf("error creating dir: %v", err)
+	}
+	file := filepath.Join(sub, "file")
+	if err := ioutil.WriteFile(file, nil, 0644); err != nil {
+		t.Fatalf("error writing file: %v", err)
+	}
+	if err := os.Symlink("/etc/passwd", filepath.Join(sub, "link")); err != nil {
+		t.Fatalf("error creating symlink: %v", err)
+	}
+
+	owner := func(p string) (uint32, uint32) {
+		var st syscall.Stat_t
+		if err := syscall.Lstat(p, &st); err != nil {
+			t.Fatalf("error reading owner of %s: %v", p, err)
+		}
+		return st.Uid, st.Gid
+	}
+
+	if err := chownTree(dir, 1000, -1, false); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if uid, gid := owner(dir); uid != 1000 || gid != 0 {
+		t.Errorf("got %d:%d, want 1000:0", uid, gid)
+	}
+	if uid, _ := owner(file); uid != 0 {
+		t.Errorf("file got uid %d without recursive", uid)
+	}
+
+	if err := chownTree(dir, 1000, 100, true); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, p := range []string{dir, sub, file, filepath.Join(sub, "link")} {
+		if uid, gid := owner(p); uid != 1000 || gid != 100 {
+			t.Errorf("%s: got %d:%d, want 1000:100", p, uid, gid)
+		}
+	}
+	if uid, _ := owner("/etc/passwd"); uid != 0 {
+		t.Errorf("symlink was followed")
+	}
+}
+
+func TestSetupVolumesIfEmpty(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("changing the owner of files needs root")
+	}
+	dir, err := ioutil.TempDir("", "volumes")
+	if err != nil {
+		t.Fatalf("error creating tempdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	empty := filepath.Join(dir, "empty")
+	used := filepath.Join(dir, "used")
+	for _, d := range []string{empty, used} {
+		if err := os.Mkdir(d, 0755); err != nil {
+			t.Fatalf("error creating dir: %v", err)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(used, "file"), nil, 0644); err != nil {
+		t.Fatalf("error writing file: %v", err)
+	}
+
+	c := &Container{
+		Root: dir,
+		Manifest: &schema.ContainerRuntimeManifest{
+			Volumes: []types.Volume{
+				{Kind: "host", Source: empty, Fulfills: []types.ACName{"empty"}},
+				{Kind: "host", Source: used, Fulfills: []types.ACName{"used"}},
+			},
+		},
+		VolumeOptions: map[types.ACName]volume.Options{
+			"empty": {UID: "1000", IfEmpty: true},
+			"used":  {UID: "1000", IfEmpty: true},
+		},
+	}
+	if err := c.setupVolumes(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, tt := range []struct {
+		dir string
+		uid uint32
+	}{{empty, 1000}, {used, 0}} {
+		var st syscall.Stat_t
+		if err := syscall.Stat(tt.dir, &st); err != nil {
+			t.Fatalf("error reading owner of %s: %v", tt.dir, err)
+		}
+		if st.Uid != tt.uid {
+			t.Errorf("%s: got uid %d, want %d", tt.dir, st.Uid, tt.uid)
+		}
+	}
+}
+
+func TestMknodDevice(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("creating device nodes needs root")
+	}
+	dir, err := ioutil.TempDir("", "volumes")
+	if err != nil {
+		t.Fatalf("error creating tempdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	// an image may declare the mount point as a directory
+	dst := filepath.Join(dir, "dev", "disk")
+	if err := os.MkdirAll(dst, 0755); err != nil {
+		t.Fatalf("error creating dir: %v", err)
+	}
+
+	if err := mknodDevice(dst, "/dev/null", true); err != nil {
+		if os.IsPermission(err) {
+			t.Skip("creating device nodes is not permitted")
+		}
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var src, st syscall.Stat_t
+	if err := syscall.Stat("/dev/null", &src); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := syscall.Stat(dst, &st); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if st.Mode&syscall.S_IFMT != syscall.S_IFCHR || st.Rdev != src.Rdev {
+		t.Errorf("got mode %o, device %d, want a character device %d", st.Mode, st.Rdev, src.Rdev)
+	}
+	if st.Mode&0222 != 0 {
+		t.Errorf("read-only device has mode %o", st.Mode&0777)
+	}
+	if !isDeviceVolume(types.Volume{Kind: "host", Source: dst}) || isDeviceVolume(types.Volume{Kind: "host", Source: dir}) {
+		t.Errorf("device volumes not told from directories")
+	}
+}
@@ -0,0 +1,7 @@
+#!/usr/bin/bash
+
+# Run as root by app-started@.service once the unit of an app is started, to
+# report the PID of the app per the status protocol of pkg/pod. $1 is the
+# short image ID of the app, the name of its unit.
+pid=$(/usr/bin/systemctl show -p MainPID "$1.service")
+echo "${pid#MainPID=}" > "/rkt/status/$1.pid"
@@ -0,0 +1,208 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package volume
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+
+	"github.com/appc/spec/schema/types"
+)
+
+// OptionsAnnotation of a container maps, as JSON, the names of its volumes
+// to the Options stage1 sets them up with, which types.Volume can't hold
+const OptionsAnnotation = "coreos.com/rkt/volume-options"
+
+// Kinds of volumes, beyond those of the spec, stage1 sets up
+const (
+	// KindTmpfs volumes are a tmpfs mounted by stage1, gone with the
+	// container
+	KindTmpfs = "tmpfs"
+)
+
+// Scopes of tmpfs volumes
+const (
+	// ScopePod volumes are a single tmpfs shared by the apps
+	ScopePod = "pod"
+	// ScopeApp volumes are a tmpfs of its own for each app
+	ScopeApp = "app"
+)
+
+// DefaultTmpfsMode is the mode of the root of tmpfs volumes, writable by
+// all the apps like /tmp
+const DefaultTmpfsMode = "1777"
+
+// Default modes of the directory of empty volumes: writable by all the
+// apps like /tmp unless it is given an owner, who alone may write to it
+const (
+	DefaultEmptyMode      os.FileMode = 0777 | os.ModeSticky
+	DefaultEmptyOwnedMode os.FileMode = 0755
+)
+
+var sizeRe = regexp.MustCompile(`^[0-9]+[kKmMgG%]?$`)
+
+// Options are how stage1 sets up a volume.
+type Options struct {
+	// Size limits a tmpfs volume, in bytes, with a k, m or g suffix, or
+	// as a percentage of the memory, as tmpfs takes it
+	Size string `json:"size,omitempty"`
+	// Mode is the octal mode of the root of a tmpfs or empty volume
+	Mode string `json:"mode,omitempty"`
+	// Scope is ScopePod, the default, or ScopeApp
+	Scope string `json:"scope,omitempty"`
+	// UID and GID, if not empty, own the root of the volume. As containers
+	// don't run in user namespaces, they are the IDs of the host.
+	UID string `json:"uid,omitempty"`
+	GID string `json:"gid,omitempty"`
+	// Recursive gives all the files of a host volume to UID and GID, not
+	// only its root
+	Recursive bool `json:"recursive,omitempty"`
+	// IfEmpty only gives a host volume to UID and GID while its directory
+	// is empty, e.g. when it is first used, leaving alone a directory
+	// already holding files of its own owners
+	IfEmpty bool `json:"ifEmpty,omitempty"`
+}
+
+// Empty reports whether o are the default options.
+func (o Options) Empty() bool {
+	return o == Options{}
+}
+
+// Check checks o are valid options of a volume of the given kind.
+func (o Options) Check(kind string) error {
+	if kind != KindTmpfs && (o.Size != "" || o.Scope != "") {
+		return fmt.Errorf("size and scope only apply to %s volumes", KindTmpfs)
+	}
+	if kind != KindTmpfs && kind != "empty" && o.Mode != "" {
+		return fmt.Errorf("mode only applies to %s and empty volumes", KindTmpfs)
+	}
+	if o.Recursive && kind != "host" {
+		return errors.New("recursive only applies to host volumes")
+	}
+	if o.Recursive && o.UID == "" && o.GID == "" {
+		return errors.New("recursive needs a uid or gid")
+	}
+	if o.IfEmpty && kind != "host" {
+		return errors.New("ifEmpty only applies to host volumes")
+	}
+	if o.IfEmpty && o.UID == "" && o.GID == "" {
+		return errors.New("ifEmpty needs a uid or gid")
+	}
+	if o.IfEmpty && o.Recursive {
+		return errors.New("ifEmpty and recursive can't be given together")
+	}
+	for _, id := range []struct{ name, val string }{{"uid", o.UID}, {"gid", o.GID}} {
+		if id.val == "" {
+			continue
+		}
+		if _, err := strconv.ParseUint(id.val, 10, 32); err != nil {
+			return fmt.Errorf("invalid %s %q, want a number", id.name, id.val)
+		}
+	}
+	if o.Size != "" && !sizeRe.MatchString(o.Size) {
+		return fmt.Errorf("invalid size %q", o.Size)
+	}
+	if o.Mode != "" {
+		if m, err := strconv.ParseUint(o.Mode, 8, 32); err != nil || m > 07777 {
+			return fmt.Errorf("invalid mode %q, want an octal mode", o.Mode)
+		}
+	}
+	switch o.Scope {
+	case "", ScopePod, ScopeApp:
+	default:
+		return fmt.Errorf("invalid scope %q, want %s or %s", o.Scope, ScopePod, ScopeApp)
+	}
+	return nil
+}
+
+// TmpfsOptions returns the mount options of a tmpfs volume with o.
+func (o Options) TmpfsOptions() string {
+	mode := o.Mode
+	if mode == "" {
+		mode = DefaultTmpfsMode
+	}
+	opts := "mode=" + mode
+	if o.Size != "" {
+		opts += ",size=" + o.Size
+	}
+	if o.UID != "" {
+		opts += ",uid=" + o.UID
+	}
+	if o.GID != "" {
+		opts += ",gid=" + o.GID
+	}
+	return opts
+}
+
+// Owner returns the uid and gid o give the root of a volume, -1 for those
+// left unchanged as os.Chown takes them. o must have been checked.
+func (o Options) Owner() (int, int) {
+	uid, gid := -1, -1
+	if o.UID != "" {
+		n, _ := strconv.ParseUint(o.UID, 10, 32)
+		uid = int(n)
+	}
+	if o.GID != "" {
+		n, _ := strconv.ParseUint(o.GID, 10, 32)
+		gid = int(n)
+	}
+	return uid, gid
+}
+
+// EmptyMode returns the mode of the directory of an empty volume with o.
+// o must have been checked.
+func (o Options) EmptyMode() os.FileMode {
+	if o.Mode == "" {
+		if o.UID != "" || o.GID != "" {
+			return DefaultEmptyOwnedMode
+		}
+		return DefaultEmptyMode
+	}
+	m, _ := strconv.ParseUint(o.Mode, 8, 32)
+	mode := os.FileMode(m).Perm()
+	for _, b := range []struct {
+		bit  uint64
+		mode os.FileMode
+	}{{04000, os.ModeSetuid}, {02000, os.ModeSetgid}, {01000, os.ModeSticky}} {
+		if m&b.bit != 0 {
+			mode |= b.mode
+		}
+	}
+	return mode
+}
+
+// EncodeOptions returns the value of the annotation setting up volumes
+// with opts, by volume name.
+func EncodeOptions(opts map[types.ACName]Options) (string, error) {
+	b, err := json.Marshal(opts)
+	if err != nil {
+		return "", fmt.Errorf("error encoding volume options: %v", err)
+	}
+	return string(b), nil
+}
+
+// DecodeOptions returns the options the annotation value val sets up
+// volumes with, by volume name.
+func DecodeOptions(val string) (map[types.ACName]Options, error) {
+	opts := make(map[types.ACName]Options)
+	if err := json.Unmarshal([]byte(val), &opts); err != nil {
+		return nil, fmt.Errorf("error decoding %s annotation: %v", OptionsAnnotation, err)
+	}
+	return opts, nil
+}
@@ -0,0 +1,94 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package volume
+
+import (
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/appc/spec/schema/types"
+)
+
+func TestOptions(t *testing.T) {
+	tests := []struct {
+		kind string
+		o    Options
+		ok   bool
+		mnt  string
+	}{
+		{KindTmpfs, Options{}, true, "mode=1777"},
+		{KindTmpfs, Options{Size: "256M", Mode: "0700", Scope: ScopeApp}, true, "mode=0700,size=256M"},
+		{KindTmpfs, Options{Size: "50%"}, true, "mode=1777,size=50%"},
+		{KindTmpfs, Options{Size: "256MB"}, false, ""},
+		{KindTmpfs, Options{Mode: "rwx"}, false, ""},
+		{KindTmpfs, Options{Mode: "17777"}, false, ""},
+		{KindTmpfs, Options{Scope: "host"}, false, ""},
+		{"host", Options{}, true, ""},
+		{KindTmpfs, Options{UID: "1000", GID: "100"}, true, "mode=1777,uid=1000,gid=100"},
+		{KindTmpfs, Options{UID: "nobody"}, false, ""},
+		{KindTmpfs, Options{UID: "1000", Recursive: true}, false, ""},
+		{"empty", Options{Size: "1G"}, false, ""},
+		{"empty", Options{Mode: "0770", GID: "100"}, true, ""},
+		{"host", Options{Mode: "0700"}, false, ""},
+		{"host", Options{UID: "1000", Recursive: true}, true, ""},
+		{"host", Options{Recursive: true}, false, ""},
+		{"host", Options{GID: "100", IfEmpty: true}, true, ""},
+		{"host", Options{IfEmpty: true}, false, ""},
+		{"host", Options{UID: "1000", Recursive: true, IfEmpty: true}, false, ""},
+		{"empty", Options{UID: "1000", IfEmpty: true}, false, ""},
+	}
+	for i, tt := range tests {
+		err := tt.o.Check(tt.kind)
+		if (err == nil) != tt.ok {
+			t.Errorf("#%d: got error %v, want ok %v", i, err, tt.ok)
+		}
+		if tt.mnt != "" && tt.o.TmpfsOptions() != tt.mnt {
+			t.Errorf("#%d: got mount options %q, want %q", i, tt.o.TmpfsOptions(), tt.mnt)
+		}
+	}
+
+	modes := []struct {
+		o    Options
+		mode os.FileMode
+		uid  int
+		gid  int
+	}{
+		{Options{}, 0777 | os.ModeSticky, -1, -1},
+		{Options{UID: "1000"}, 0755, 1000, -1},
+		{Options{Mode: "2770", GID: "100"}, 0770 | os.ModeSetgid, -1, 100},
+	}
+	for i, tt := range modes {
+		if m := tt.o.EmptyMode(); m != tt.mode {
+			t.Errorf("#%d: got mode %v, want %v", i, m, tt.mode)
+		}
+		if uid, gid := tt.o.Owner(); uid != tt.uid || gid != tt.gid {
+			t.Errorf("#%d: got owner %d:%d, want %d:%d", i, uid, gid, tt.uid, tt.gid)
+		}
+	}
+
+	opts := map[types.ACName]Options{"scratch": {Size: "1G", Scope: ScopeApp}}
+	val, err := EncodeOptions(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := DecodeOptions(val)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, opts) {
+		t.Errorf("decoded %+v, want %+v", decoded, opts)
+	}
+}
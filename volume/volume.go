@@ -0,0 +1,172 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package volume provisions container volumes through external drivers,
+// e.g. to mount NFS exports, Ceph images or LVM volumes, so that rkt
+// doesn't have to know about every storage system.
+//
+// A driver named NAME is a directory NAME in PluginsPath holding two
+// executables, mount and unmount. Both are run with a JSON encoded Request
+// on stdin; mount is expected to make the volume available at the target
+// directory and unmount to release it, after which rkt removes the target.
+package volume
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+
+	"github.com/appc/spec/schema/types"
+)
+
+// PluginsPath is where volume drivers are looked up
+const PluginsPath = "/usr/lib/rkt/plugins/volume"
+
+// MountsFile is the file, relative to the container directory, recording
+// the volumes mounted for the container
+const MountsFile = "volumes.json"
+
+// overridden in tests
+var pluginsPath = PluginsPath
+
+// Spec describes a volume provisioned by a driver
+type Spec struct {
+	Driver  string
+	Options map[string]string
+}
+
+// Request is handed to the mount and unmount executables of drivers.
+type Request struct {
+	ContainerID string `json:"containerID"`
+	Volume      string `json:"volume"`
+	Driver      string `json:"driver"`
+	// Target is the directory of the host the volume is mounted on
+	Target  string            `json:"target"`
+	Options map[string]string `json:"options,omitempty"`
+}
+
+// Mount mounts vols, keyed by volume name, below the container directory
+// cdir and returns the directories they are mounted on. Mounted volumes
+// are recorded in cdir, so they are released by UnmountAll even if Mount
+// fails half-way.
+func Mount(cdir string, contID types.UUID, vols map[string]Spec) (map[string]string, error) {
+	names := make([]string, 0, len(vols))
+	for name := range vols {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var mounted []Request
+	paths := make(map[string]string)
+	for _, name := range names {
+		v := vols[name]
+		req := Request{
+			ContainerID: contID.String(),
+			Volume:      name,
+			Driver:      v.Driver,
+			Target:      targetPath(cdir, name),
+			Options:     v.Options,
+		}
+		if err := os.MkdirAll(req.Target, 0755); err != nil {
+			return nil, fmt.Errorf("error creating mount point of volume %q: %v", name, err)
+		}
+		// record the volume first, mount may fail after doing something
+		if err := saveMounts(cdir, append(mounted, req)); err != nil {
+			return nil, fmt.Errorf("error recording volume %q: %v", name, err)
+		}
+		if err := run("mount", req); err != nil {
+			return nil, fmt.Errorf("error mounting volume %q: %v", name, err)
+		}
+		mounted = append(mounted, req)
+		paths[name] = req.Target
+	}
+	return paths, nil
+}
+
+// UnmountAll releases the volumes recorded by Mount in the container
+// directory cdir, in reverse order. It keeps going on errors and returns
+// the first one.
+func UnmountAll(cdir string) error {
+	b, err := ioutil.ReadFile(filepath.Join(cdir, MountsFile))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error reading mounted volumes: %v", err)
+	}
+	var mounted []Request
+	if err := json.Unmarshal(b, &mounted); err != nil {
+		return fmt.Errorf("error reading mounted volumes: %v", err)
+	}
+
+	var first error
+	for i := len(mounted) - 1; i >= 0; i-- {
+		req := mounted[i]
+		// the container directory may have moved since, e.g. to garbage
+		req.Target = targetPath(cdir, req.Volume)
+		err := run("unmount", req)
+		if err == nil {
+			err = os.Remove(req.Target)
+		}
+		if err != nil && first == nil {
+			first = fmt.Errorf("error unmounting volume %q: %v", req.Volume, err)
+		}
+	}
+	if first != nil {
+		return first
+	}
+	return os.Remove(filepath.Join(cdir, MountsFile))
+}
+
+// targetPath returns the directory the named volume is mounted on
+func targetPath(cdir, name string) string {
+	return filepath.Join(cdir, "volumes", name)
+}
+
+func saveMounts(cdir string, mounted []Request) error {
+	b, err := json.Marshal(mounted)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(cdir, MountsFile), b, 0600)
+}
+
+// run executes the cmd executable of the driver of req
+func run(cmd string, req Request) error {
+	if req.Driver == "" || req.Driver != filepath.Base(req.Driver) {
+		return fmt.Errorf("invalid driver name %q", req.Driver)
+	}
+	path := filepath.Join(pluginsPath, req.Driver, cmd)
+	if fi, err := os.Stat(path); err != nil || !fi.Mode().IsRegular() {
+		return fmt.Errorf("could not find %s of volume driver %q", cmd, req.Driver)
+	}
+
+	b, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	c := exec.Command(path)
+	c.Stdin = bytes.NewReader(b)
+	c.Stdout = os.Stderr
+	c.Stderr = os.Stderr
+	if err := c.Run(); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+// Copyright 2015 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package volume
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/appc/spec/schema/types"
+)
+
+// writeDriver installs a driver logging the requests it gets to
+// <target>.<cmd> and failing to mount volumes whose "fail" option is set.
+func writeDriver(t *testing.T, dir, name string) {
+	dd := filepath.Join(dir, name)
+	if err := os.MkdirAll(dd, 0755); err != nil {
+		t.Fatal(err)
+	}
+	scripts := map[string]string{
+		"mount": `#!/bin/sh
+req=$(cat)
+target=$(echo "$req" | sed 's/.*"target":"\([^"]*\)".*/\1/')
+echo "$req" > "$target.mount"
+case "$req" in *'"fail":"true"'*) exit 1;; esac
+`,
+		"unmount": `#!/bin/sh
+req=$(cat)
+target=$(echo "$req" | sed 's/.*"target":"\([^"]*\)".*/\1/')
+echo "$req" > "$target.unmount"
+`,
+	}
+	for cmd, script := range scripts {
+		if err := ioutil.WriteFile(filepath.Join(dd, cmd), []byte(script), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func readRequest(t *testing.T, path string) Request {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("error reading request: %v", err)
+	}
+	var req Request
+	if err := json.Unmarshal(b, &req); err != nil {
+		t.Fatalf("error decoding request: %v", err)
+	}
+	return req
+}
+
+func TestMount(t *testing.T) {
+	dir, err := ioutil.TempDir("", "volume")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	pluginsPath = filepath.Join(dir, "plugins")
+	defer func() { pluginsPath = PluginsPath }()
+	writeDriver(t, pluginsPath, "test")
+
+	cdir := filepath.Join(dir, "container")
+	contID, err := types.NewUUID("6733c3fb-c1ec-4bc6-8a34-4aac42c7a4b9")
+	if err != nil {
+		t.Fatal(err)
+	}
+	vols := map[string]Spec{
+		"data": {Driver: "test", Options: map[string]string{"server": "nfs.example.com"}},
+		"logs": {Driver: "test"},
+	}
+	paths, err := Mount(cdir, *contID, vols)
+	if err != nil {
+		t.Fatalf("unexpected error mounting volumes: %v", err)
+	}
+	wantPaths := map[string]string{
+		"data": filepath.Join(cdir, "volumes", "data"),
+		"logs": filepath.Join(cdir, "volumes", "logs"),
+	}
+	if !reflect.DeepEqual(paths, wantPaths) {
+		t.Errorf("got paths %v, want %v", paths, wantPaths)
+	}
+	want := Request{
+		ContainerID: contID.String(),
+		Volume:      "data",
+		Driver:      "test",
+		Target:      wantPaths["data"],
+		Options:     map[string]string{"server": "nfs.example.com"},
+	}
+	if req := readRequest(t, wantPaths["data"]+".mount"); !reflect.DeepEqual(req, want) {
+		t.Errorf("got request %+v, want %+v", req, want)
+	}
+
+	// volumes are released wherever the container directory moved to
+	moved := filepath.Join(dir, "garbage")
+	if err := os.Rename(cdir, moved); err != nil {
+		t.Fatal(err)
+	}
+	if err := UnmountAll(moved); err != nil {
+		t.Fatalf("unexpected error unmounting volumes: %v", err)
+	}
+	for name := range wantPaths {
+		if req := readRequest(t, filepath.Join(moved, "volumes", name)+".unmount"); req.Target != filepath.Join(moved, "volumes", name) {
+			t.Errorf("got unmount target %q, want it below %q", req.Target, moved)
+		}
+		if _, err := os.Stat(filepath.Join(moved, "volumes", name)); !os.IsNotExist(err) {
+			t.Errorf("mount point of %q not removed", name)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(moved, MountsFile)); !os.IsNotExist(err) {
+		t.Errorf("mounted volumes still recorded")
+	}
+
+	// a failed mount is still released
+	vols = map[string]Spec{
+		"data": {Driver: "test"},
+		"fail": {Driver: "test", Options: map[string]string{"fail": "true"}},
+	}
+	if _, err := Mount(cdir, *contID, vols); err == nil {
+		t.Fatalf("expected error mounting volumes")
+	}
+	if err := UnmountAll(cdir); err != nil {
+		t.Fatalf("unexpected error unmounting volumes: %v", err)
+	}
+	readRequest(t, filepath.Join(cdir, "volumes", "fail.unmount"))
+
+	if _, err := Mount(cdir, *contID, map[string]Spec{"data": {Driver: "../test"}}); err == nil {
+		t.Errorf("expected error using invalid driver name")
+	}
+	if _, err := Mount(cdir, *contID, map[string]Spec{"data": {Driver: "missing"}}); err == nil {
+		t.Errorf("expected error using missing driver")
+	}
+}